@@ -1,6 +1,7 @@
 package config
 
 import (
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
@@ -21,6 +22,11 @@ type ControlPlaneConfig struct {
 	PollInterval      int    `yaml:"poll_interval"`      // seconds, for fallback if long-poll fails
 	ReconcileInterval int    `yaml:"reconcile_interval"` // seconds, periodic full reconciliation (default 60)
 	Region            string `yaml:"region"`             // region to pull config from (default "default")
+	// Selector restricts this controller to the region's domains and
+	// clusters carrying all of these labels, for sharding a region across
+	// controllers. Empty syncs everything. A selected domain's clusters must
+	// carry the labels as well: they are not pulled in by reference.
+	Selector map[string]string `yaml:"selector"`
 }
 
 // AuthConfig holds AK/SK for HMAC-SHA256 authentication to the control plane.
@@ -98,6 +104,25 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("HERMES_CONTROLPLANE_REGION"); v != "" {
 		cfg.ControlPlane.Region = v
 	}
+	if v := os.Getenv("HERMES_CONTROLPLANE_SELECTOR"); v != "" {
+		// key=value[,key=value...]. A malformed term is an error rather than
+		// skipped: a wider selector than intended would sync, and reconcile
+		// away, other shards' resources.
+		cfg.ControlPlane.Selector = make(map[string]string)
+		for _, term := range strings.Split(v, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("HERMES_CONTROLPLANE_SELECTOR: term %q must be key=value", term)
+			}
+			if prev, dup := cfg.ControlPlane.Selector[key]; dup && prev != value {
+				return nil, fmt.Errorf("HERMES_CONTROLPLANE_SELECTOR: label %q set twice", key)
+			}
+			cfg.ControlPlane.Selector[key] = value
+		}
+	}
+	if _, ok := cfg.ControlPlane.Selector[""]; ok {
+		return nil, fmt.Errorf("controlplane.selector: label names must not be empty")
+	}
 	if v := os.Getenv("HERMES_ETCD_ENDPOINTS"); v != "" {
 		cfg.Etcd.Endpoints = strings.Split(v, ",")
 	}
@@ -128,6 +128,30 @@ func TestLoad_EnvOverrides(t *testing.T) {
 	assert.Equal(t, 30, cfg.Election.LeaseTTL)
 }
 
+func TestLoad_Selector(t *testing.T) {
+	yaml := `
+controlplane:
+  selector:
+    tier: edge
+`
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
+	cfg, err := Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "edge"}, cfg.ControlPlane.Selector)
+
+	t.Setenv("HERMES_CONTROLPLANE_SELECTOR", "tier=core, team=net")
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"tier": "core", "team": "net"}, cfg.ControlPlane.Selector)
+
+	for _, bad := range []string{"tier", "=edge", "tier=edge,", "tier=edge,tier=core"} {
+		t.Setenv("HERMES_CONTROLPLANE_SELECTOR", bad)
+		_, err = Load(tmp)
+		assert.Error(t, err, bad)
+	}
+}
+
 func TestLoad_EnvOverrideInvalidPollInterval(t *testing.T) {
 	t.Setenv("HERMES_CONTROLPLANE_POLL_INTERVAL", "not_a_number")
 	cfg, err := Load("/tmp/hermes_nonexistent_config.yaml")
@@ -183,11 +183,23 @@ func (c *Controller) pollOnce(ctx context.Context) {
 		return
 	}
 
+	// A "sync" event asks for a full reconcile; run it once after the batch.
+	resync := false
 	for _, ev := range events {
+		if ev.Kind == "sync" {
+			resync = true
+			continue
+		}
 		if err := c.applyEvent(ctx, ev); err != nil {
 			c.logger.Errorf("apply event error: %v", err)
 		}
 	}
+	if resync {
+		c.logger.Info("sync requested by controlplane, reconciling")
+		if err := c.Reconcile(ctx); err != nil {
+			c.logger.Errorf("requested reconcile failed: %v", err)
+		}
+	}
 
 	if newRev > c.GetRevision() {
 		c.SetRevision(newRev)
@@ -266,6 +278,13 @@ func (c *Controller) applyEvent(ctx context.Context, ev ChangeEvent) error {
 	prefix = strings.TrimRight(prefix, "/")
 	key := prefix + "/" + ev.Name
 
+	if len(c.cfg.ControlPlane.Selector) > 0 {
+		skip, err := c.skipUnowned(ctx, key, ev)
+		if err != nil || skip {
+			return err
+		}
+	}
+
 	switch ev.Action {
 	case "delete":
 		_, err := c.etcdClient.Delete(ctx, key)
@@ -295,6 +314,35 @@ func (c *Controller) applyEvent(ctx context.Context, ev ChangeEvent) error {
 	return nil
 }
 
+// skipUnowned reports whether a sharded controller (one with a selector)
+// must leave ev alone because the resource is not in its subset. A resource
+// relabelled out of the subset is deleted here, if this controller wrote it.
+func (c *Controller) skipUnowned(ctx context.Context, key string, ev ChangeEvent) (bool, error) {
+	data := ev.Domain
+	if ev.Kind == "cluster" {
+		data = ev.Cluster
+	}
+	if ev.Action != "delete" && (data == nil || c.owns(data)) {
+		return false, nil
+	}
+
+	resp, err := c.etcdClient.Get(ctx, key)
+	if err != nil {
+		return false, fmt.Errorf("etcd get %s: %w", key, err)
+	}
+	current := len(resp.Kvs) > 0 && c.owns(resp.Kvs[0].Value)
+	if ev.Action == "delete" {
+		return !current, nil
+	}
+	if current {
+		if _, err := c.etcdClient.Delete(ctx, key); err != nil {
+			return false, fmt.Errorf("etcd delete %s: %w", key, err)
+		}
+		c.logger.Infof("applied %s: %s left the selector, deleted", ev.Action, key)
+	}
+	return true, nil
+}
+
 // publishRevisionToEtcd writes the controlplane config revision to etcd
 // so gateways can read the business-meaningful version number.
 func (c *Controller) publishRevisionToEtcd(ctx context.Context) {
@@ -52,6 +52,7 @@ type mockControlplane struct {
 	clusters []json.RawMessage
 	changes  []ChangeEvent
 	revision int64
+	query    string // raw query of the last GET /api/v1/config
 }
 
 func newMockControlplane() *mockControlplane {
@@ -64,6 +65,7 @@ func (m *mockControlplane) handler() http.Handler {
 	mux.HandleFunc("GET /api/v1/config", func(w http.ResponseWriter, r *http.Request) {
 		m.mu.Lock()
 		defer m.mu.Unlock()
+		m.query = r.URL.RawQuery
 		json.NewEncoder(w).Encode(map[string]any{
 			"config": map[string]any{
 				"domains":  m.domains,
@@ -243,6 +245,57 @@ func TestReconcile_DeletesDirtyKeys(t *testing.T) {
 	assert.Empty(t, resp.Kvs, "dirty key should have been deleted")
 }
 
+func TestReconcileAndApplyEvent_Selector(t *testing.T) {
+	ctx := context.Background()
+	etcdEndpoint, cleanup := startEtcd(t, ctx)
+	defer cleanup()
+
+	etcdClient, err := clientv3.New(clientv3.Config{Endpoints: []string{etcdEndpoint}, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer etcdClient.Close()
+	get := func(key string) string {
+		resp, err := etcdClient.Get(ctx, key)
+		require.NoError(t, err)
+		if len(resp.Kvs) == 0 {
+			return ""
+		}
+		return string(resp.Kvs[0].Value)
+	}
+
+	// Another shard's domain, and a stale one of ours.
+	_, err = etcdClient.Put(ctx, "/hermes/domains/core", `{"name":"core","labels":{"tier":"core"}}`)
+	require.NoError(t, err)
+	_, err = etcdClient.Put(ctx, "/hermes/domains/old-edge", `{"name":"old-edge","labels":{"tier":"edge"}}`)
+	require.NoError(t, err)
+
+	cp := newMockControlplane()
+	cp.addDomain("cdn", json.RawMessage(`{"name":"cdn","labels":{"tier":"edge"}}`))
+	srv := httptest.NewServer(cp.handler())
+	defer srv.Close()
+
+	ctrl := newTestController(t, srv.URL, etcdEndpoint)
+	defer ctrl.Close()
+	ctrl.cfg.ControlPlane.Selector = map[string]string{"tier": "edge"}
+
+	require.NoError(t, ctrl.Reconcile(ctx))
+	assert.Equal(t, "selector=tier%3Dedge", cp.query)
+	assert.NotEmpty(t, get("/hermes/domains/cdn"))
+	assert.Empty(t, get("/hermes/domains/old-edge"), "stale keys of our subset are deleted")
+	assert.NotEmpty(t, get("/hermes/domains/core"), "other shards' keys are left alone")
+
+	// Events outside the subset are skipped, including deletes.
+	require.NoError(t, ctrl.applyEvent(ctx, ChangeEvent{Kind: "domain", Name: "core", Action: "update",
+		Domain: json.RawMessage(`{"name":"core","labels":{"tier":"core"},"hosts":["x"]}`)}))
+	assert.NotContains(t, get("/hermes/domains/core"), "hosts")
+	require.NoError(t, ctrl.applyEvent(ctx, ChangeEvent{Kind: "domain", Name: "core", Action: "delete"}))
+	assert.NotEmpty(t, get("/hermes/domains/core"))
+
+	// Relabelled out of the subset: the key is ours, so it goes.
+	require.NoError(t, ctrl.applyEvent(ctx, ChangeEvent{Kind: "domain", Name: "cdn", Action: "update",
+		Domain: json.RawMessage(`{"name":"cdn","labels":{"tier":"core"}}`)}))
+	assert.Empty(t, get("/hermes/domains/cdn"))
+}
+
 func TestApplyEvent_CreateAndDelete(t *testing.T) {
 	ctx := context.Background()
 	etcdEndpoint, cleanup := startEtcd(t, ctx)
@@ -320,6 +373,39 @@ func TestPollOnce(t *testing.T) {
 	assert.Contains(t, string(resp.Kvs[0].Value), "poll-cluster")
 }
 
+func TestPollOnce_SyncEventReconciles(t *testing.T) {
+	ctx := context.Background()
+	etcdEndpoint, cleanup := startEtcd(t, ctx)
+	defer cleanup()
+
+	cp := newMockControlplane()
+	srv := httptest.NewServer(cp.handler())
+	defer srv.Close()
+
+	ctrl := newTestController(t, srv.URL, etcdEndpoint)
+	defer ctrl.Close()
+	require.NoError(t, ctrl.Reconcile(ctx))
+
+	// A key written behind the controller's back is only removed by a reconcile.
+	etcdClient, err := clientv3.New(clientv3.Config{Endpoints: []string{etcdEndpoint}, DialTimeout: 5 * time.Second})
+	require.NoError(t, err)
+	defer etcdClient.Close()
+	_, err = etcdClient.Put(ctx, "/hermes/domains/stray", `{"name":"stray"}`)
+	require.NoError(t, err)
+
+	cp.mu.Lock()
+	cp.revision++
+	cp.changes = append(cp.changes, ChangeEvent{Revision: cp.revision, Kind: "sync", Action: "reconcile"})
+	cp.mu.Unlock()
+
+	ctrl.pollOnce(ctx)
+
+	resp, err := etcdClient.Get(ctx, "/hermes/domains/stray")
+	require.NoError(t, err)
+	assert.Empty(t, resp.Kvs)
+	assert.Equal(t, cp.revision, ctrl.GetRevision())
+}
+
 func TestPublishRevisionToEtcd(t *testing.T) {
 	ctx := context.Background()
 	etcdEndpoint, cleanup := startEtcd(t, ctx)
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -38,8 +40,13 @@ func (c *Controller) reconcileLoop(ctx context.Context, interval time.Duration)
 
 // Reconcile fetches the full desired state from CP, compares it to what's
 // currently in etcd, and applies the minimal diff (put missing/stale, delete unknown).
+// With a selector configured, only keys whose value carries the selected
+// labels count as this controller's: other shards' keys are left alone.
+// Clusters are selected by their own labels too, so the control plane
+// refuses (422) a selection whose domains route to clusters outside it;
+// the error is returned and etcd is left as it is.
 func (c *Controller) Reconcile(ctx context.Context) error {
-	req, err := http.NewRequestWithContext(ctx, "GET", c.cfg.ControlPlane.URL+"/api/v1/config", nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.cfg.ControlPlane.URL+"/api/v1/config"+c.selectorQuery(), nil)
 	if err != nil {
 		return err
 	}
@@ -53,7 +60,8 @@ func (c *Controller) Reconcile(ctx context.Context) error {
 	}()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("controlplane returned %d for full config", resp.StatusCode)
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("controlplane returned %d for full config: %s", resp.StatusCode, strings.TrimSpace(string(body)))
 	}
 
 	var result struct {
@@ -150,8 +158,8 @@ func (c *Controller) diffKeys(desired map[string]string, actual *clientv3.GetRes
 		}
 	}
 
-	for key := range actualMap {
-		if _, exists := desired[key]; !exists {
+	for key, actualVal := range actualMap {
+		if _, exists := desired[key]; !exists && c.owns(json.RawMessage(actualVal)) {
 			c.logger.Warnf("reconcile: dirty key %s, will delete", key)
 			ops = append(ops, diffOp{opType: "delete", key: key})
 		}
@@ -160,6 +168,41 @@ func (c *Controller) diffKeys(desired map[string]string, actual *clientv3.GetRes
 	return ops
 }
 
+// selectorQuery returns the ?selector= query for the configured label
+// selector, or "" without one.
+func (c *Controller) selectorQuery() string {
+	sel := c.cfg.ControlPlane.Selector
+	if len(sel) == 0 {
+		return ""
+	}
+	terms := make([]string, 0, len(sel))
+	for k, v := range sel {
+		terms = append(terms, k+"="+v)
+	}
+	sort.Strings(terms)
+	return "?selector=" + url.QueryEscape(strings.Join(terms, ","))
+}
+
+// owns reports whether a domain or cluster belongs to this controller's
+// subset: it carries every label of the selector. Without a selector the
+// controller owns everything.
+func (c *Controller) owns(raw json.RawMessage) bool {
+	sel := c.cfg.ControlPlane.Selector
+	if len(sel) == 0 {
+		return true
+	}
+	var h struct {
+		Labels map[string]string `json:"labels"`
+	}
+	json.Unmarshal(raw, &h)
+	for k, v := range sel {
+		if got, ok := h.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
 func extractName(raw json.RawMessage) string {
 	var h struct {
 		Name string `json:"name"`
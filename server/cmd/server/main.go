@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"expvar"
 	"flag"
 	"io/fs"
 	"log"
@@ -13,41 +14,116 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/jizhuozhi/hermes/server/internal/buildinfo"
 	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/etcdread"
 	"github.com/jizhuozhi/hermes/server/internal/handler"
+	"github.com/jizhuozhi/hermes/server/internal/notify"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 	"github.com/jizhuozhi/hermes/server/internal/store"
+	"github.com/jizhuozhi/hermes/server/internal/telemetry"
 
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 func main() {
 	cfgPath := flag.String("config", "config.yaml", "config file path")
 	flag.Parse()
 
-	logger, _ := zap.NewProduction()
+	cfg, err := config.Load(*cfgPath)
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	// The level is atomic so a config reload can change it in place.
+	zapCfg := zap.NewProductionConfig()
+	zapCfg.Level = zap.NewAtomicLevelAt(parseLogLevel(cfg.Server.LogLevel))
+	logger, err := zapCfg.Build()
+	if err != nil {
+		log.Fatalf("failed to build logger: %v", err)
+	}
 	defer logger.Sync()
 	sugar := logger.Sugar()
 
-	cfg, err := config.Load(*cfgPath)
+	// Settings that SIGHUP reloads apply to the running server.
+	quotas := config.NewDynamic(cfg.Quotas)
+	rules := config.NewDynamic(cfg.Rules)
+	corsOrigins := config.NewDynamic(cfg.Server.CORSOrigins)
+	strictJSON := config.NewDynamic(cfg.Server.StrictJSON)
+	readOnly := config.NewDynamic(cfg.Server.ReadOnly)
+	statusCfg := config.NewDynamic(cfg.Status)
+
+	// Validated by config.Load.
+	trustedProxies, _ := cfg.Server.TrustedProxyPrefixes()
+
+	// Tracing is installed first so store spans are exported from startup.
+	shutdownTracing, err := telemetry.Setup(context.Background(), cfg.Tracing)
 	if err != nil {
-		log.Fatalf("failed to load config: %v", err)
+		log.Fatalf("failed to set up tracing: %v", err)
+	}
+	if cfg.Tracing.Endpoint != "" {
+		sugar.Infof("OpenTelemetry tracing enabled (endpoint=%s, sample_ratio=%g)", cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio)
 	}
 
-	pgStore, err := store.NewPgStore(cfg.Postgres.DSN, sugar)
+	pgStore, err := store.NewPgStore(cfg.Postgres.DSN, cfg.Postgres.ReadDSN, sugar)
 	if err != nil {
 		log.Fatalf("failed to connect postgres: %v", err)
 	}
 	defer pgStore.Close()
 
-	domainHandler := handler.NewDomainHandler(pgStore, sugar)
-	configHandler := handler.NewRouteHandler(pgStore, sugar)
-	clusterHandler := handler.NewClusterHandler(pgStore, sugar)
+	// Master key for secrets at rest (nil when not configured).
+	box, err := secretbox.New(cfg.MasterKey)
+	if err != nil {
+		log.Fatalf("invalid master_key: %v", err)
+	}
+	if box != nil {
+		// Seal credential secret keys stored before master_key was set.
+		n, err := pgStore.SealAPICredentialSecrets(context.Background(), func(sk string) (string, error) {
+			return box.Seal([]byte(sk))
+		})
+		if err != nil {
+			log.Fatalf("failed to seal api credential secrets: %v", err)
+		}
+		if n > 0 {
+			sugar.Infof("sealed %d api credential secret keys with master_key", n)
+		}
+	}
+
+	// Read-only etcd client for drift checks (nil when not configured).
+	var etcdReader handler.EtcdReader
+	if r, err := etcdread.New(cfg.Etcd); err != nil {
+		log.Fatalf("failed to set up etcd: %v", err)
+	} else if r != nil {
+		defer r.Close()
+		etcdReader = r
+		sugar.Infof("etcd drift check enabled (endpoints=%s)", strings.Join(cfg.Etcd.Endpoints, ","))
+	}
+
+	domainHandler := handler.NewDomainHandler(pgStore, sugar, quotas, rules)
+	configHandler := handler.NewRouteHandler(pgStore, sugar, quotas, rules)
+	clusterHandler := handler.NewClusterHandler(pgStore, sugar, quotas, rules)
 	watchHandler := handler.NewWatchHandler(pgStore, sugar)
-	statusHandler := handler.NewStatusHandler(pgStore, sugar)
+	statusHandler := handler.NewStatusHandler(pgStore, sugar, statusCfg)
 	auditHandler := handler.NewAuditHandler(pgStore, sugar)
 	grafanaHandler := handler.NewGrafanaHandler(pgStore, sugar)
-	credentialHandler := handler.NewCredentialHandler(pgStore, sugar)
-	memberHandler := handler.NewMemberHandler(pgStore, sugar)
+	// Credential lifecycle notifications and builtin user mail (no-ops when
+	// no channel or SMTP relay is configured).
+	notifier := notify.New(cfg.SMTP, cfg.Notifications, sugar)
+	credentialHandler := handler.NewCredentialHandler(pgStore, box, notifier, sugar)
+	serviceAccountHandler := handler.NewServiceAccountHandler(pgStore, sugar)
+	secretHandler := handler.NewSecretHandler(pgStore, box, sugar)
+	regionHandler := handler.NewRegionHandler(pgStore, sugar, quotas)
+	scheduleHandler := handler.NewScheduleHandler(pgStore, sugar, rules)
+	driftHandler := handler.NewDriftHandler(pgStore, etcdReader, sugar)
+	maintenanceHandler := handler.NewMaintenanceHandler(pgStore, sugar)
+	logLevelHandler := handler.NewLogLevelHandler(zapCfg.Level, sugar)
+	memberHandler := handler.NewMemberHandler(pgStore, notifier, sugar, cfg.BuiltinAuth.PasswordPolicy)
+
+	// bgCtx scopes background workers to the process lifetime.
+	bgCtx, bgCancel := context.WithCancel(context.Background())
+	defer bgCancel()
+	go notifier.Run(bgCtx)
 
 	// OIDC handler (auth endpoints are always registered; verifier is conditional).
 	var oidcHandler *handler.OIDCHandler
@@ -61,16 +137,17 @@ func main() {
 		if err != nil {
 			sugar.Fatalf("OIDC init failed: %v", err)
 		}
-		oidcVerifier = handler.NewOIDCVerifier(cfg.OIDC, oidcHandler.JwksURI())
+		oidcVerifier = handler.NewOIDCVerifier(bgCtx, cfg.OIDC, oidcHandler.JwksURI(), sugar)
 		sugar.Infof("OIDC authentication enabled (issuer=%s, client_id=%s)", cfg.OIDC.Issuer, cfg.OIDC.ClientID)
 
 	case "builtin":
 		var err error
-		builtinHandler, err = handler.NewBuiltinAuthHandler(cfg.BuiltinAuth, pgStore, sugar)
+		builtinHandler, err = handler.NewBuiltinAuthHandler(cfg.BuiltinAuth, pgStore, box, notifier, sugar)
 		if err != nil {
 			sugar.Fatalf("Builtin auth init failed: %v", err)
 		}
 		oidcVerifier = handler.NewBuiltinVerifier(pgStore)
+		go builtinHandler.RunKeyRotation(bgCtx)
 		sugar.Info("Built-in authentication enabled")
 
 	default:
@@ -79,25 +156,42 @@ func main() {
 
 	// Middleware factories
 	nsMW := handler.RegionMiddleware
-	authMW := handler.Authenticate(pgStore, oidcVerifier, sugar)
+	authMW := handler.Authenticate(pgStore, box, oidcVerifier, sugar)
 
 	// Scope shortcuts.
 	configRead := handler.RequireScope(store.ScopeConfigRead)
 	configWrite := handler.RequireScope(store.ScopeConfigWrite)
+	configRollback := handler.RequireScope(store.ScopeConfigRollback)
 	configWatch := handler.RequireScope(store.ScopeConfigWatch)
 	statusRead := handler.RequireScope(store.ScopeStatusRead)
 	statusWrite := handler.RequireScope(store.ScopeStatusWrite)
 	credRead := handler.RequireScope(store.ScopeCredentialRead)
 	credWrite := handler.RequireScope(store.ScopeCredentialWrite)
+	secretRead := handler.RequireScope(store.ScopeSecretRead)
+	secretWrite := handler.RequireScope(store.ScopeSecretWrite)
 	memberRead := handler.RequireScope(store.ScopeMemberRead)
 	memberWrite := handler.RequireScope(store.ScopeMemberWrite)
 	auditRead := handler.RequireScope(store.ScopeAuditRead)
 	adminUsers := handler.RequireScope(store.ScopeAdminUsers)
 	nsRead := handler.RequireScope(store.ScopeRegionRead)
 	nsWrite := handler.RequireScope(store.ScopeRegionWrite)
+	// Change freeze on domain/cluster writes; goes after the scope checks.
+	frozen := handler.FreezeGuard(pgStore, sugar)
+	// Regions may require a change reason on the same writes.
+	reasoned := handler.ChangeReasonGuard(pgStore, sugar)
+	idempotent := handler.Idempotency(pgStore, sugar)
+	idempotentSealed := handler.SealedIdempotency(pgStore, box, sugar)
 
 	mux := http.NewServeMux()
 
+	// Readiness probe: 503 without a database, "degraded" when read-only.
+	mux.HandleFunc("GET /readyz", handler.Ready(pgStore, readOnly))
+
+	// Public: build version, for deployment tooling and support.
+	mux.HandleFunc("GET /api/v1/version", func(w http.ResponseWriter, r *http.Request) {
+		handler.JSON(w, http.StatusOK, buildinfo.Get())
+	})
+
 	// Public: Auth API (no authentication required)
 	mux.HandleFunc("GET /api/auth/config", func(w http.ResponseWriter, r *http.Request) {
 		resp := map[string]any{"enabled": false}
@@ -119,11 +213,19 @@ func main() {
 	}
 	if builtinHandler != nil {
 		mux.HandleFunc("POST /api/auth/login", builtinHandler.Login)
+		mux.HandleFunc("POST /api/auth/refresh", builtinHandler.Refresh)
+		mux.HandleFunc("GET /api/auth/jwks", builtinHandler.JWKS)
 		mux.Handle("GET /api/auth/userinfo", handler.Wrap(http.HandlerFunc(builtinHandler.Userinfo), nsMW, authMW))
 		mux.Handle("POST /api/auth/change-password", handler.Wrap(http.HandlerFunc(builtinHandler.ChangePassword), nsMW, authMW))
 		mux.Handle("POST /api/auth/rotate-key", handler.Wrap(http.HandlerFunc(builtinHandler.RotateKey), authMW, adminUsers))
+		mux.Handle("POST /api/auth/totp/enroll", handler.Wrap(http.HandlerFunc(builtinHandler.EnrollTOTP), authMW))
+		mux.Handle("POST /api/auth/totp/verify", handler.Wrap(http.HandlerFunc(builtinHandler.VerifyTOTP), authMW))
 	}
 
+	// Process metrics (expvar), e.g. JWKS cache age and refresh failures.
+	// Admins only: memstats and cmdline can carry flags and DSNs.
+	mux.Handle("GET /debug/vars", handler.Wrap(expvar.Handler(), authMW, adminUsers))
+
 	// Scopes reference (public)
 	mux.HandleFunc("GET /api/v1/scopes", func(w http.ResponseWriter, r *http.Request) {
 		handler.JSON(w, http.StatusOK, map[string]any{"scopes": store.AllScopes})
@@ -136,45 +238,90 @@ func main() {
 	// -- WhoAmI (any authenticated caller) --
 	mux.Handle("GET /api/v1/whoami", handler.Wrap(http.HandlerFunc(memberHandler.WhoAmI), nsMW, authMW))
 
+	// -- Role → scope mapping (any authenticated caller) --
+	mux.Handle("GET /api/v1/roles", handler.Wrap(http.HandlerFunc(memberHandler.ListRoles), nsMW, authMW))
+
 	// -- Config read (viewer+ / credential with config:read) --
 	mux.Handle("GET /api/v1/config", handler.Wrap(http.HandlerFunc(configHandler.GetConfig), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/stream", handler.Wrap(http.HandlerFunc(configHandler.StreamConfig),
+		handler.WriteTimeout(cfg.Server.Timeouts.StreamWrite, sugar), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/schema", handler.Wrap(http.HandlerFunc(configHandler.ConfigSchema), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/changes", handler.Wrap(http.HandlerFunc(watchHandler.ConfigChanges), nsMW, authMW, configRead))
 	mux.Handle("GET /api/v1/config/revision", handler.Wrap(http.HandlerFunc(watchHandler.GetRevision), nsMW, authMW, configRead))
 	mux.Handle("POST /api/v1/config/validate", handler.Wrap(http.HandlerFunc(configHandler.ValidateConfig), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/graph", handler.Wrap(http.HandlerFunc(configHandler.ConfigGraph), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/search", handler.Wrap(http.HandlerFunc(configHandler.Search), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/targets", handler.Wrap(http.HandlerFunc(regionHandler.ConfigTargets), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/drift", handler.Wrap(http.HandlerFunc(driftHandler.ConfigDrift), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/config/etcd-preview", handler.Wrap(http.HandlerFunc(driftHandler.EtcdPreview), nsMW, authMW, configRead))
+	mux.Handle("POST /api/v1/config/lint", handler.Wrap(http.HandlerFunc(configHandler.LintConfig), nsMW, authMW, configRead))
 
 	// -- Config watch (controller / credential with config:watch) --
-	mux.Handle("GET /api/v1/config/watch", handler.Wrap(http.HandlerFunc(watchHandler.WatchConfig), nsMW, authMW, configWatch))
+	mux.Handle("GET /api/v1/config/watch", handler.Wrap(http.HandlerFunc(watchHandler.WatchConfig),
+		handler.WriteTimeout(cfg.Server.Timeouts.WatchWrite, sugar), nsMW, authMW, configWatch))
+
+	// -- Canary config (viewer+ reads; editor+ starts/aborts; promoting is an import) --
+	mux.Handle("GET /api/v1/config/canary", handler.Wrap(http.HandlerFunc(configHandler.GetCanary), nsMW, authMW, configRead))
+	mux.Handle("PUT /api/v1/config/canary", handler.Wrap(http.HandlerFunc(configHandler.PutCanary), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("DELETE /api/v1/config/canary", handler.Wrap(http.HandlerFunc(configHandler.AbortCanary), nsMW, authMW, configWrite))
+	mux.Handle("POST /api/v1/config/canary/promote", handler.Wrap(http.HandlerFunc(configHandler.PromoteCanary), nsMW, authMW, configWrite, configRollback, frozen, reasoned))
 
-	// -- Config write (editor+ / credential with config:write) --
-	mux.Handle("PUT /api/v1/config", handler.Wrap(http.HandlerFunc(configHandler.PutConfig), nsMW, authMW, configWrite))
+	// -- Sync now (editor+ / credential with config:write) --
+	mux.Handle("POST /api/v1/config/trigger-sync", handler.Wrap(http.HandlerFunc(watchHandler.TriggerSync), nsMW, authMW, configWrite))
+
+	// -- Config bulk import and restore (owner+ / credential with config:write + config:rollback) --
+	mux.Handle("PUT /api/v1/config", handler.Wrap(http.HandlerFunc(configHandler.PutConfig), nsMW, authMW, configWrite, configRollback, frozen, reasoned))
+	mux.Handle("POST /api/v1/config/restore", handler.Wrap(http.HandlerFunc(configHandler.RestoreConfig), nsMW, authMW, configWrite, configRollback, frozen, reasoned))
 
 	// -- Domains --
 	mux.Handle("GET /api/v1/domains", handler.Wrap(http.HandlerFunc(domainHandler.ListDomains), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/domains/by-host", handler.Wrap(http.HandlerFunc(domainHandler.FindDomainsByHost), nsMW, authMW, configRead))
 	mux.Handle("GET /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.GetDomain), nsMW, authMW, configRead))
 	mux.Handle("GET /api/v1/domains/{name}/history", handler.Wrap(http.HandlerFunc(domainHandler.ListDomainHistory), nsMW, authMW, configRead))
+	// Erasing history is for region owners, not every config writer.
+	mux.Handle("DELETE /api/v1/domains/{name}/history", handler.Wrap(http.HandlerFunc(domainHandler.PurgeDomainHistory), nsMW, authMW, nsWrite))
 	mux.Handle("GET /api/v1/domains/{name}/history/{version}", handler.Wrap(http.HandlerFunc(domainHandler.GetDomainVersion), nsMW, authMW, configRead))
-	mux.Handle("POST /api/v1/domains", handler.Wrap(http.HandlerFunc(domainHandler.CreateDomain), nsMW, authMW, configWrite))
-	mux.Handle("PUT /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.UpdateDomain), nsMW, authMW, configWrite))
-	mux.Handle("DELETE /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.DeleteDomain), nsMW, authMW, configWrite))
-	mux.Handle("POST /api/v1/domains/{name}/rollback/{version}", handler.Wrap(http.HandlerFunc(domainHandler.RollbackDomain), nsMW, authMW, configWrite))
+	mux.Handle("POST /api/v1/domains", handler.Wrap(http.HandlerFunc(domainHandler.CreateDomain), nsMW, authMW, configWrite, frozen, reasoned, idempotent))
+	mux.Handle("POST /api/v1/domains/delete", handler.Wrap(http.HandlerFunc(domainHandler.BulkDeleteDomains), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("PUT /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.UpdateDomain), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("PATCH /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.PatchDomain), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("DELETE /api/v1/domains/{name}", handler.Wrap(http.HandlerFunc(domainHandler.DeleteDomain), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("POST /api/v1/domains/{name}/rollback/{version}", handler.Wrap(http.HandlerFunc(domainHandler.RollbackDomain), nsMW, authMW, configWrite, configRollback, frozen, reasoned))
 
 	// -- Clusters --
 	mux.Handle("GET /api/v1/clusters", handler.Wrap(http.HandlerFunc(clusterHandler.ListClusters), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/clusters/orphans", handler.Wrap(http.HandlerFunc(clusterHandler.ListOrphanClusters), nsMW, authMW, configRead))
+	mux.Handle("DELETE /api/v1/clusters/orphans", handler.Wrap(http.HandlerFunc(clusterHandler.DeleteOrphanClusters), nsMW, authMW, configWrite, frozen, reasoned))
 	mux.Handle("GET /api/v1/clusters/{name}", handler.Wrap(http.HandlerFunc(clusterHandler.GetCluster), nsMW, authMW, configRead))
+	mux.Handle("GET /api/v1/clusters/{name}/references", handler.Wrap(http.HandlerFunc(clusterHandler.ListClusterReferences), nsMW, authMW, configRead))
 	mux.Handle("GET /api/v1/clusters/{name}/history", handler.Wrap(http.HandlerFunc(clusterHandler.ListClusterHistory), nsMW, authMW, configRead))
+	mux.Handle("DELETE /api/v1/clusters/{name}/history", handler.Wrap(http.HandlerFunc(clusterHandler.PurgeClusterHistory), nsMW, authMW, nsWrite))
 	mux.Handle("GET /api/v1/clusters/{name}/history/{version}", handler.Wrap(http.HandlerFunc(clusterHandler.GetClusterVersion), nsMW, authMW, configRead))
-	mux.Handle("POST /api/v1/clusters", handler.Wrap(http.HandlerFunc(clusterHandler.CreateCluster), nsMW, authMW, configWrite))
-	mux.Handle("PUT /api/v1/clusters/{name}", handler.Wrap(http.HandlerFunc(clusterHandler.UpdateCluster), nsMW, authMW, configWrite))
-	mux.Handle("DELETE /api/v1/clusters/{name}", handler.Wrap(http.HandlerFunc(clusterHandler.DeleteCluster), nsMW, authMW, configWrite))
-	mux.Handle("POST /api/v1/clusters/{name}/rollback/{version}", handler.Wrap(http.HandlerFunc(clusterHandler.RollbackCluster), nsMW, authMW, configWrite))
+	mux.Handle("POST /api/v1/clusters", handler.Wrap(http.HandlerFunc(clusterHandler.CreateCluster), nsMW, authMW, configWrite, frozen, reasoned, idempotent))
+	mux.Handle("POST /api/v1/clusters/delete", handler.Wrap(http.HandlerFunc(clusterHandler.BulkDeleteClusters), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("PUT /api/v1/clusters/{name}", handler.Wrap(http.HandlerFunc(clusterHandler.UpdateCluster), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("DELETE /api/v1/clusters/{name}", handler.Wrap(http.HandlerFunc(clusterHandler.DeleteCluster), nsMW, authMW, configWrite, frozen, reasoned))
+	mux.Handle("POST /api/v1/clusters/{name}/rollback/{version}", handler.Wrap(http.HandlerFunc(clusterHandler.RollbackCluster), nsMW, authMW, configWrite, configRollback, frozen, reasoned))
+
+	// -- Scheduled changes --
+	mux.Handle("GET /api/v1/scheduled-changes", handler.Wrap(http.HandlerFunc(scheduleHandler.ListScheduledChanges), nsMW, authMW, configRead))
+	mux.Handle("POST /api/v1/scheduled-changes", handler.Wrap(http.HandlerFunc(scheduleHandler.CreateScheduledChange), nsMW, authMW, configWrite, reasoned, idempotent))
+	mux.Handle("DELETE /api/v1/scheduled-changes/{id}", handler.Wrap(http.HandlerFunc(scheduleHandler.CancelScheduledChange), nsMW, authMW, configWrite))
 
 	// -- Status --
 	mux.Handle("GET /api/v1/status", handler.Wrap(http.HandlerFunc(statusHandler.AggregateStatus), nsMW, authMW, statusRead))
+	mux.Handle("GET /api/v1/status/all", handler.Wrap(http.HandlerFunc(statusHandler.AllStatus), authMW, adminUsers))
 	mux.Handle("GET /api/v1/status/instances", handler.Wrap(http.HandlerFunc(statusHandler.ListInstances), nsMW, authMW, statusRead))
+	mux.Handle("GET /api/v1/status/instances/{id}/history", handler.Wrap(http.HandlerFunc(statusHandler.InstanceHistory), nsMW, authMW, statusRead))
+	mux.Handle("GET /api/v1/status/instances/{id}/config", handler.Wrap(http.HandlerFunc(statusHandler.InstanceConfig), nsMW, authMW, statusRead, configRead))
 	mux.Handle("GET /api/v1/status/controller", handler.Wrap(http.HandlerFunc(statusHandler.GetController), nsMW, authMW, statusRead))
+	mux.Handle("GET /api/v1/status/controller/elections", handler.Wrap(http.HandlerFunc(statusHandler.ControllerElections), nsMW, authMW, statusRead))
 	mux.Handle("PUT /api/v1/status/instances", handler.Wrap(http.HandlerFunc(statusHandler.ReportInstances), nsMW, authMW, statusWrite))
+	mux.Handle("DELETE /api/v1/status/instances/{id}", handler.Wrap(http.HandlerFunc(statusHandler.DeregisterInstance), nsMW, authMW, statusWrite))
 	mux.Handle("PUT /api/v1/status/controller", handler.Wrap(http.HandlerFunc(statusHandler.ReportController), nsMW, authMW, statusWrite))
 
-	// -- Audit --
+	// -- Audit & config timeline --
+	mux.Handle("GET /api/v1/history", handler.Wrap(http.HandlerFunc(auditHandler.ListHistory), nsMW, authMW, configRead))
 	mux.Handle("GET /api/v1/audit", handler.Wrap(http.HandlerFunc(auditHandler.ListAuditLog), nsMW, authMW, auditRead))
 
 	// -- Grafana dashboards --
@@ -185,10 +332,25 @@ func main() {
 
 	// -- Credentials --
 	mux.Handle("GET /api/v1/credentials", handler.Wrap(http.HandlerFunc(credentialHandler.ListCredentials), nsMW, authMW, credRead))
-	mux.Handle("POST /api/v1/credentials", handler.Wrap(http.HandlerFunc(credentialHandler.CreateCredential), nsMW, authMW, credWrite))
+	mux.Handle("POST /api/v1/credentials", handler.Wrap(http.HandlerFunc(credentialHandler.CreateCredential), nsMW, authMW, credWrite, idempotentSealed))
+	mux.Handle("GET /api/v1/credentials/export", handler.Wrap(http.HandlerFunc(credentialHandler.ExportCredentials), nsMW, authMW, credRead))
+	mux.Handle("POST /api/v1/credentials/import", handler.Wrap(http.HandlerFunc(credentialHandler.ImportCredentials), nsMW, authMW, credWrite, idempotentSealed))
+	mux.Handle("POST /api/v1/credentials/bulk-disable", handler.Wrap(http.HandlerFunc(credentialHandler.BulkDisableCredentials), nsMW, authMW, credWrite))
+	mux.Handle("POST /api/v1/credentials/bulk-enable", handler.Wrap(http.HandlerFunc(credentialHandler.BulkEnableCredentials), nsMW, authMW, credWrite))
 	mux.Handle("PUT /api/v1/credentials/{id}", handler.Wrap(http.HandlerFunc(credentialHandler.UpdateCredential), nsMW, authMW, credWrite))
 	mux.Handle("DELETE /api/v1/credentials/{id}", handler.Wrap(http.HandlerFunc(credentialHandler.DeleteCredential), nsMW, authMW, credWrite))
 
+	// -- Service accounts (bearer-token credentials) --
+	mux.Handle("GET /api/v1/service-accounts", handler.Wrap(http.HandlerFunc(serviceAccountHandler.ListServiceAccounts), nsMW, authMW, credRead))
+	mux.Handle("POST /api/v1/service-accounts", handler.Wrap(http.HandlerFunc(serviceAccountHandler.CreateServiceAccount), nsMW, authMW, credWrite))
+	mux.Handle("DELETE /api/v1/service-accounts/{id}", handler.Wrap(http.HandlerFunc(serviceAccountHandler.DeleteServiceAccount), nsMW, authMW, credWrite))
+
+	// -- Secrets (values are write-only; config references them by name) --
+	mux.Handle("GET /api/v1/secrets", handler.Wrap(http.HandlerFunc(secretHandler.ListSecrets), nsMW, authMW, secretRead))
+	mux.Handle("GET /api/v1/secrets/{name}", handler.Wrap(http.HandlerFunc(secretHandler.GetSecret), nsMW, authMW, secretRead))
+	mux.Handle("PUT /api/v1/secrets/{name}", handler.Wrap(http.HandlerFunc(secretHandler.PutSecret), nsMW, authMW, secretWrite))
+	mux.Handle("DELETE /api/v1/secrets/{name}", handler.Wrap(http.HandlerFunc(secretHandler.DeleteSecret), nsMW, authMW, secretWrite))
+
 	// -- Members --
 	mux.Handle("GET /api/v1/members", handler.Wrap(http.HandlerFunc(memberHandler.ListMembers), nsMW, authMW, memberRead))
 	mux.Handle("POST /api/v1/members", handler.Wrap(http.HandlerFunc(memberHandler.AddMember), nsMW, authMW, memberWrite))
@@ -199,14 +361,32 @@ func main() {
 	mux.Handle("POST /api/v1/group-bindings", handler.Wrap(http.HandlerFunc(memberHandler.SetGroupBinding), nsMW, authMW, memberWrite))
 	mux.Handle("DELETE /api/v1/group-bindings/{group}", handler.Wrap(http.HandlerFunc(memberHandler.RemoveGroupBinding), nsMW, authMW, memberWrite))
 
+	// -- Custom roles (region-defined scope sets) --
+	mux.Handle("GET /api/v1/custom-roles", handler.Wrap(http.HandlerFunc(memberHandler.ListCustomRoles), nsMW, authMW, memberRead))
+	mux.Handle("PUT /api/v1/custom-roles/{name}", handler.Wrap(http.HandlerFunc(memberHandler.PutCustomRole), nsMW, authMW, memberWrite))
+	mux.Handle("DELETE /api/v1/custom-roles/{name}", handler.Wrap(http.HandlerFunc(memberHandler.DeleteCustomRole), nsMW, authMW, memberWrite))
+
 	// -- Admin: global user management --
 	mux.Handle("GET /api/v1/users", handler.Wrap(http.HandlerFunc(memberHandler.ListUsers), authMW, adminUsers))
-	mux.Handle("POST /api/v1/users", handler.Wrap(http.HandlerFunc(memberHandler.CreateBuiltinUser), authMW, adminUsers))
+	mux.Handle("POST /api/v1/users", handler.Wrap(http.HandlerFunc(memberHandler.CreateBuiltinUser), authMW, adminUsers, idempotent))
 	mux.Handle("PUT /api/v1/users/{sub}/admin", handler.Wrap(http.HandlerFunc(memberHandler.SetAdmin), authMW, adminUsers))
 	mux.Handle("PUT /api/v1/users/{sub}", handler.Wrap(http.HandlerFunc(memberHandler.UpdateUser), authMW, adminUsers))
 	mux.Handle("DELETE /api/v1/users/{sub}", handler.Wrap(http.HandlerFunc(memberHandler.DeleteUser), authMW, adminUsers))
 	mux.Handle("PUT /api/v1/users/{sub}/force-password-change", handler.Wrap(http.HandlerFunc(memberHandler.ForcePasswordChange), authMW, adminUsers))
 	mux.Handle("PUT /api/v1/users/{sub}/reset-password", handler.Wrap(http.HandlerFunc(memberHandler.ResetUserPassword), authMW, adminUsers))
+	mux.Handle("POST /api/v1/users/{sub}/revoke-sessions", handler.Wrap(http.HandlerFunc(memberHandler.RevokeSessions), authMW, adminUsers))
+	mux.Handle("DELETE /api/v1/users/{sub}/totp", handler.Wrap(http.HandlerFunc(memberHandler.ResetUserTOTP), authMW, adminUsers))
+	mux.Handle("POST /api/v1/admin/impersonate", handler.Wrap(http.HandlerFunc(memberHandler.Impersonate), authMW, adminUsers))
+	mux.Handle("GET /api/v1/admin/maintenance", handler.Wrap(http.HandlerFunc(maintenanceHandler.GetMaintenance), authMW))
+	mux.Handle("PUT /api/v1/admin/maintenance", handler.Wrap(http.HandlerFunc(maintenanceHandler.SetMaintenance), authMW, adminUsers))
+	// ANALYZE / REINDEX can outlast the usual deadlines; the store serializes runs.
+	mux.Handle("POST /api/v1/admin/maintenance/tables", handler.Wrap(http.HandlerFunc(maintenanceHandler.RunTableMaintenance),
+		handler.WriteTimeout(0, sugar), authMW, adminUsers))
+	if oidcHandler != nil {
+		mux.Handle("GET /api/v1/admin/oidc/test", handler.Wrap(http.HandlerFunc(oidcHandler.TestConnection), authMW, adminUsers))
+	}
+	mux.Handle("GET /api/v1/admin/log-level", handler.Wrap(http.HandlerFunc(logLevelHandler.GetLogLevel), authMW, adminUsers))
+	mux.Handle("PUT /api/v1/admin/log-level", handler.Wrap(http.HandlerFunc(logLevelHandler.SetLogLevel), authMW, adminUsers))
 
 	// -- Regions --
 	mux.Handle("GET /api/v1/regions", handler.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -247,7 +427,18 @@ func main() {
 			_ = pgStore.SetRegionMember(r.Context(), req.Name, claims.Sub, store.RoleOwner)
 		}
 		handler.JSON(w, http.StatusCreated, map[string]any{"name": req.Name})
-	}), authMW, nsWrite))
+	}), authMW, nsWrite, idempotent))
+	// Settings are checked against the region in the path. Owners (region:write)
+	// manage them, except the quota overrides, which PutRegionSettings keeps
+	// for platform admins so that owners cannot lift their own limits.
+	mux.Handle("GET /api/v1/regions/{name}/settings", handler.Wrap(http.HandlerFunc(regionHandler.GetRegionSettings), handler.PathRegion, authMW, nsRead))
+	mux.Handle("PUT /api/v1/regions/{name}/settings", handler.Wrap(http.HandlerFunc(regionHandler.PutRegionSettings), handler.PathRegion, authMW, nsWrite))
+	// Likewise, lifting a change freeze is for admins; owners can only
+	// override it per request, which is audited.
+	mux.Handle("PUT /api/v1/regions/{name}/freeze", handler.Wrap(http.HandlerFunc(regionHandler.SetRegionFrozen), authMW, adminUsers))
+	// Fan-out targets point controllers at etcd clusters, so only admins set them.
+	mux.Handle("GET /api/v1/regions/{name}/targets", handler.Wrap(http.HandlerFunc(regionHandler.GetRegionTargets), handler.PathRegion, authMW, nsRead))
+	mux.Handle("PUT /api/v1/regions/{name}/targets", handler.Wrap(http.HandlerFunc(regionHandler.PutRegionTargets), handler.PathRegion, authMW, adminUsers))
 
 	// Static frontend SPA
 	distDir := "./web/dist"
@@ -262,21 +453,35 @@ func main() {
 		})
 	}
 
-	// Global middleware: Recovery → CORS
+	// Global middleware: RequestID → Recovery → RealIP → AccessLog → CORS → RequestTimeout → MaxBodySize → StrictJSON → ReadYourWrites → ChangeReason → ReadOnly → Maintenance → Tracing
 	var h http.Handler = mux
-	h = handler.CORS(h)
+	h = handler.Tracing(h)
+	h = handler.Maintenance(pgStore, sugar)(h)
+	h = handler.ReadOnly(readOnly)(h)
+	h = handler.ChangeReason(h)
+	h = handler.ReadYourWrites(h)
+	h = handler.StrictJSON(strictJSON)(h)
+	h = handler.MaxBodySize(cfg.Server.MaxBodyBytes)(h)
+	h = handler.RequestTimeout(cfg.Server.Timeouts.Request, handler.PathRequestTimeouts(cfg.Server.Timeouts))(h)
+	h = handler.CORSWithOrigins(corsOrigins)(h)
+	if cfg.Server.AccessLog.Enabled {
+		h = handler.AccessLog(sugar, cfg.Server.AccessLog.SampleRates)(h)
+	}
+	h = handler.RealIP(trustedProxies)(h)
 	h = handler.Recovery(sugar, h)
+	h = handler.RequestID(h)
 
 	srv := &http.Server{
 		Addr:         cfg.Server.Listen,
 		Handler:      h,
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 60 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.Timeouts.Read,
+		WriteTimeout: cfg.Server.Timeouts.Write,
+		IdleTimeout:  cfg.Server.Timeouts.Idle,
 	}
 
 	go func() {
-		sugar.Infof("hermes control plane starting on %s", cfg.Server.Listen)
+		bi := buildinfo.Get()
+		sugar.Infof("hermes control plane %s (commit %s) starting on %s", bi.Version, bi.Commit, cfg.Server.Listen)
 		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			sugar.Fatalf("server error: %v", err)
 		}
@@ -285,32 +490,64 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 
+	// Config reload on SIGHUP
+	// Only settings that are safe to swap at runtime are applied; changes to
+	// the rest are logged and wait for a restart. A file that fails to load
+	// leaves the running config untouched. Restart-only settings are always
+	// compared with the startup config, which is what still runs for them,
+	// so a pending restart keeps being reported on later reloads.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for {
+			select {
+			case <-bgCtx.Done():
+				return
+			case <-hup:
+				next, restart, err := config.Reload(*cfgPath, cfg)
+				if err != nil {
+					sugar.Errorf("config reload failed, keeping current config: %v", err)
+					continue
+				}
+				zapCfg.Level.SetLevel(parseLogLevel(next.Server.LogLevel))
+				corsOrigins.Store(next.Server.CORSOrigins)
+				strictJSON.Store(next.Server.StrictJSON)
+				readOnly.Store(next.Server.ReadOnly)
+				quotas.Store(next.Quotas)
+				rules.Store(next.Rules)
+				statusCfg.Store(next.Status)
+				if len(restart) > 0 {
+					sugar.Warnf("config reloaded; changes to %s require a restart", strings.Join(restart, ", "))
+				} else {
+					sugar.Info("config reloaded")
+				}
+			}
+		}
+	}()
+
 	// Stale instance/controller reaper
 	// Periodically marks instances and controllers as "offline" if they haven't
 	// reported within the threshold. Idempotent UPDATE — safe to run on every replica.
 	go func() {
-		const (
-			reaperInterval           = 15 * time.Second
-			instanceStaleThreshold   = 30 * time.Second // 2x gateway lease TTL (15s)
-			controllerStaleThreshold = 30 * time.Second // 3x controller heartbeat (10s)
-		)
+		const reaperInterval = 15 * time.Second
 		ticker := time.NewTicker(reaperInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
-			case <-quit:
+			case <-bgCtx.Done():
 				return
 			case <-ticker.C:
+				thresholds := statusCfg.Load()
 				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-				if stale, err := pgStore.MarkStaleInstances(ctx, instanceStaleThreshold); err != nil {
+				if stale, err := pgStore.MarkStaleInstances(ctx, thresholds.InstanceStaleAfter); err != nil {
 					sugar.Warnf("stale instance reaper: %v", err)
 				} else {
 					for _, e := range stale {
 						sugar.Warnf("gateway instance offline: region=%s id=%s", e.Region, e.ID)
 					}
 				}
-				if stale, err := pgStore.MarkStaleControllers(ctx, controllerStaleThreshold); err != nil {
+				if stale, err := pgStore.MarkStaleControllers(ctx, thresholds.ControllerStaleAfter); err != nil {
 					sugar.Warnf("stale controller reaper: %v", err)
 				} else {
 					for _, e := range stale {
@@ -322,10 +559,64 @@ func main() {
 		}
 	}()
 
+	// Scheduled changes
+	// Applies due changes. An advisory lock in the store keeps each run to
+	// one replica.
+	go func() {
+		const schedulerInterval = 15 * time.Second
+		ticker := time.NewTicker(schedulerInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-bgCtx.Done():
+				return
+			case <-ticker.C:
+				handler.RunScheduledChanges(bgCtx, pgStore, quotas, rules, sugar)
+			}
+		}
+	}()
+
+	// change_log archival
+	// Moves events past the retention window into change_log_archive. An
+	// advisory lock in the store keeps it to one replica per run.
+	if cl := cfg.ChangeLog; cl.ArchiveAfter > 0 {
+		go func() {
+			ticker := time.NewTicker(cl.ArchiveInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-bgCtx.Done():
+					return
+				case <-ticker.C:
+					n, err := pgStore.ArchiveChangeLog(bgCtx, cl.ArchiveAfter, cl.ArchiveBatchSize)
+					if err != nil {
+						sugar.Warnf("change_log archival: %v", err)
+					} else if n > 0 {
+						sugar.Infof("change_log archival: moved %d entries older than %s", n, cl.ArchiveAfter)
+					}
+				}
+			}
+		}()
+		sugar.Infof("change_log archival enabled (archive_after=%s, target=%s)", cl.ArchiveAfter, cl.ArchiveTarget)
+	}
+
 	<-quit
 
 	sugar.Info("shutting down...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	srv.Shutdown(ctx)
+	if err := shutdownTracing(ctx); err != nil {
+		sugar.Warnf("flush traces: %v", err)
+	}
+}
+
+// parseLogLevel maps server.log_level (validated by config.Load) to a zap level.
+func parseLogLevel(s string) zapcore.Level {
+	level, err := zapcore.ParseLevel(s)
+	if err != nil {
+		return zapcore.InfoLevel
+	}
+	return level
 }
@@ -0,0 +1,34 @@
+package buildinfo
+
+import (
+	"runtime/debug"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGet_Defaults(t *testing.T) {
+	info := Get()
+	assert.Equal(t, "dev", info.Version)
+	assert.NotEmpty(t, info.Commit)
+	assert.NotEmpty(t, info.BuildTime)
+	assert.NotEmpty(t, info.GoVersion)
+}
+
+func TestFromVCS(t *testing.T) {
+	settings := []debug.BuildSetting{
+		{Key: "vcs.revision", Value: "abc123"},
+		{Key: "vcs.time", Value: "2026-01-02T03:04:05Z"},
+		{Key: "vcs.modified", Value: "true"},
+	}
+
+	var info Info
+	fromVCS(&info, settings)
+	assert.Equal(t, Info{Commit: "abc123", BuildTime: "2026-01-02T03:04:05Z", Modified: true}, info)
+
+	// ldflags win over the VCS stamp.
+	info = Info{Commit: "def456", BuildTime: "2026-02-01T00:00:00Z"}
+	fromVCS(&info, settings)
+	assert.Equal(t, "def456", info.Commit)
+	assert.Equal(t, "2026-02-01T00:00:00Z", info.BuildTime)
+}
@@ -1,7 +1,16 @@
 package config
 
 import (
+	"fmt"
+	"net/mail"
+	"net/netip"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
 
 	"gopkg.in/yaml.v3"
 )
@@ -14,14 +23,269 @@ type Config struct {
 	// AuthMode selects the authentication backend: "builtin", "oidc", or "" (disabled).
 	// Can be overridden by HERMES_AUTH_MODE env var.
 	AuthMode string `yaml:"auth_mode"`
+	// MasterKey is a base64-encoded 32-byte key used to encrypt secrets at
+	// rest (e.g. TOTP seeds, API credential secret keys). Features that need
+	// it are unavailable when unset, and credential secret keys are stored
+	// unencrypted.
+	// Can be overridden by HERMES_MASTER_KEY env var.
+	MasterKey string `yaml:"master_key"`
+	// ChangeLog controls archival of old change_log entries.
+	ChangeLog ChangeLogConfig `yaml:"change_log"`
+	// Tracing exports OpenTelemetry spans for requests and store queries.
+	Tracing TracingConfig `yaml:"tracing"`
+	// Quotas caps how many resources each region may hold. Regions can
+	// override these via PUT /api/v1/regions/{name}/settings.
+	Quotas QuotaConfig `yaml:"quotas"`
+	// Rules are site-specific checks on domains and clusters, run on writes
+	// and by POST /api/v1/config/lint.
+	Rules []model.Rule `yaml:"rules"`
+	// Status controls when gateways and controllers are reported offline.
+	Status StatusConfig `yaml:"status"`
+	// Etcd optionally connects to the etcd the controllers write to, so
+	// GET /api/v1/config/drift can compare it against stored config.
+	Etcd EtcdConfig `yaml:"etcd"`
+	// SMTP is the mail relay for notification emails and builtin user
+	// account mail. Mail is disabled unless host is set.
+	SMTP SMTPConfig `yaml:"smtp"`
+	// Notifications sends security-relevant events to a webhook or mailbox.
+	Notifications NotificationsConfig `yaml:"notifications"`
+}
+
+// SMTPConfig configures outgoing mail.
+type SMTPConfig struct {
+	// Host of the SMTP relay. Empty (default) disables mail.
+	// Can be overridden by HERMES_SMTP_HOST.
+	Host string `yaml:"host"`
+	// Port of the relay. Default: 587 (STARTTLS is used when offered).
+	Port int `yaml:"port"`
+	// Username and Password enable PLAIN auth when username is set.
+	// Password can be overridden by HERMES_SMTP_PASSWORD.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	// From is the sender address. Required when host is set.
+	From string `yaml:"from"`
+}
+
+// NotificationsConfig routes each event category to its own channel, so
+// security events can go somewhere other than config change hooks.
+type NotificationsConfig struct {
+	// Credentials receives API credential lifecycle events (created,
+	// updated, enabled, disabled, deleted).
+	Credentials NotifyChannel `yaml:"credentials"`
+}
+
+// NotifyChannel is where one category of events is delivered. Both targets
+// may be set; an empty channel sends nothing.
+type NotifyChannel struct {
+	// WebhookURL receives each event as a JSON POST.
+	WebhookURL string `yaml:"webhook_url"`
+	// Email lists recipient addresses. Needs smtp.host.
+	Email []string `yaml:"email"`
+}
+
+// Enabled reports whether the channel has any target.
+func (c NotifyChannel) Enabled() bool {
+	return c.WebhookURL != "" || len(c.Email) > 0
+}
+
+// EtcdConfig configures the server's read-only etcd client. The server never
+// writes to etcd; it only reads the keys the controllers maintain.
+type EtcdConfig struct {
+	// Endpoints of the etcd cluster. Empty (default) disables drift checks.
+	// Can be overridden by HERMES_ETCD_ENDPOINTS (comma-separated).
+	Endpoints []string `yaml:"endpoints"`
+	Username  string   `yaml:"username"`
+	Password  string   `yaml:"password"`
+	// DialTimeout bounds connecting to etcd. Default: 5s.
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// DomainPrefix and ClusterPrefix must match the controller's.
+	// Defaults: /hermes/domains and /hermes/clusters.
+	DomainPrefix  string `yaml:"domain_prefix"`
+	ClusterPrefix string `yaml:"cluster_prefix"`
+	// Regions overrides the prefixes for regions whose controller writes
+	// elsewhere in the same etcd. Unlisted regions use the prefixes above.
+	Regions map[string]EtcdKeyspace `yaml:"regions"`
+}
+
+// EtcdKeyspace is where one region's controller writes its keys.
+type EtcdKeyspace struct {
+	DomainPrefix  string `yaml:"domain_prefix"`
+	ClusterPrefix string `yaml:"cluster_prefix"`
+}
+
+// Keyspace returns the etcd prefixes holding region's domains and clusters.
+func (c EtcdConfig) Keyspace(region string) EtcdKeyspace {
+	ks := EtcdKeyspace{DomainPrefix: c.DomainPrefix, ClusterPrefix: c.ClusterPrefix}
+	if o, ok := c.Regions[region]; ok {
+		if o.DomainPrefix != "" {
+			ks.DomainPrefix = o.DomainPrefix
+		}
+		if o.ClusterPrefix != "" {
+			ks.ClusterPrefix = o.ClusterPrefix
+		}
+	}
+	return ks
+}
+
+// StatusConfig controls the stale instance/controller reaper.
+type StatusConfig struct {
+	// InstanceStaleAfter marks a gateway instance offline once it has not
+	// reported for this long. Default: 30s (2x the gateway lease TTL).
+	InstanceStaleAfter time.Duration `yaml:"instance_stale_after"`
+	// ControllerStaleAfter marks a controller offline once it has not sent a
+	// heartbeat for this long. Default: 30s (3x the heartbeat interval).
+	ControllerStaleAfter time.Duration `yaml:"controller_stale_after"`
+	// Health sets when a region's computed health turns yellow or red.
+	Health HealthConfig `yaml:"health"`
+}
+
+// HealthConfig holds the thresholds of the green/yellow/red region health.
+// A region is red whenever its controller is offline, whatever the ratios.
+type HealthConfig struct {
+	// RedOfflineRatio turns health red once more than this fraction of
+	// gateway instances is offline. Default: 0.5 (a majority).
+	RedOfflineRatio float64 `yaml:"red_offline_ratio"`
+	// YellowOfflineRatio turns health yellow once more than this fraction is
+	// offline. Default: 0 (any).
+	YellowOfflineRatio float64 `yaml:"yellow_offline_ratio"`
+	// YellowOutOfSyncRatio turns health yellow once more than this fraction
+	// of online instances lags the region's latest config revision.
+	// Default: 0 (any).
+	YellowOutOfSyncRatio float64 `yaml:"yellow_out_of_sync_ratio"`
+}
+
+// QuotaConfig holds the default per-region resource limits. 0 (default)
+// means unlimited. Only creates are refused; existing resources can always
+// be updated.
+type QuotaConfig struct {
+	MaxDomains  int `yaml:"max_domains"`
+	MaxClusters int `yaml:"max_clusters"`
+}
+
+// TracingConfig configures OpenTelemetry trace export over OTLP/HTTP.
+type TracingConfig struct {
+	// Endpoint is the OTLP/HTTP collector URL, e.g.
+	// "http://otel-collector:4318". Empty (default) disables tracing.
+	// Can be overridden by HERMES_OTLP_ENDPOINT.
+	Endpoint string `yaml:"endpoint"`
+	// ServiceName is reported as service.name. Default: hermes-server.
+	ServiceName string `yaml:"service_name"`
+	// SampleRatio is the fraction of new traces recorded (0 to 1); requests
+	// that arrive with a sampled traceparent are always recorded. Default: 1.
+	SampleRatio float64 `yaml:"sample_ratio"`
+}
+
+// ChangeLogConfig controls moving old change events out of the live
+// change_log table. Archived events remain visible to audit queries that
+// pass an old enough since.
+type ChangeLogConfig struct {
+	// ArchiveAfter is the retention window of the live table. Events older
+	// than this are archived. 0 (default) disables archival.
+	ArchiveAfter time.Duration `yaml:"archive_after"`
+	// ArchiveTarget is where archived events go. Only "table"
+	// (change_log_archive in the same database) is supported. Default: table.
+	ArchiveTarget string `yaml:"archive_target"`
+	// ArchiveInterval is how often the archival job runs. Default: 1h.
+	ArchiveInterval time.Duration `yaml:"archive_interval"`
+	// ArchiveBatchSize is how many rows are moved per transaction. Default: 5000.
+	ArchiveBatchSize int `yaml:"archive_batch_size"`
 }
 
 type ServerConfig struct {
 	Listen string `yaml:"listen"`
+	// LogLevel is the minimum level logged: debug, info, warn or error.
+	// Default: info. Can be overridden by HERMES_LOG_LEVEL.
+	LogLevel string `yaml:"log_level"`
+	// CORSOrigins lists the origins allowed to call the API from a browser.
+	// "*" (default) allows any origin.
+	CORSOrigins []string `yaml:"cors_origins"`
+	// MaxBodyBytes caps request body size; larger requests get 413.
+	// Default: 10 MiB. Can be overridden by HERMES_MAX_BODY_BYTES.
+	MaxBodyBytes int64 `yaml:"max_body_bytes"`
+	// AccessLog controls the per-request access log.
+	AccessLog AccessLogConfig `yaml:"access_log"`
+	// StrictJSON rejects request bodies with fields the endpoint does not
+	// know, instead of ignoring them. Clients can override it per request
+	// with the X-Hermes-Strict-JSON header. Default: false.
+	StrictJSON bool `yaml:"strict_json"`
+	// ReadOnly rejects every write with 503 while reads keep working, for
+	// running against a read-only database during a failover. /readyz
+	// reports the instance as degraded. Default: false. Can be overridden
+	// by HERMES_READ_ONLY.
+	ReadOnly bool `yaml:"read_only"`
+	// TrustedProxies lists the CIDRs (or single IPs) of reverse proxies
+	// whose X-Forwarded-For is believed when resolving a client's IP.
+	// Default: none, so the client IP is always the connection's peer.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+	// Timeouts bound how long the HTTP server waits on each connection.
+	Timeouts TimeoutsConfig `yaml:"timeouts"`
+}
+
+// TimeoutsConfig holds the HTTP server timeouts. 0 disables a timeout.
+type TimeoutsConfig struct {
+	// Read bounds reading a whole request, body included. Default: 15s.
+	Read time.Duration `yaml:"read"`
+	// Write bounds handling a request and writing its response.
+	// Default: 60s.
+	Write time.Duration `yaml:"write"`
+	// Idle is how long a keep-alive connection may wait for its next
+	// request. Default: 60s.
+	Idle time.Duration `yaml:"idle"`
+	// WatchWrite replaces Write for GET /api/v1/config/watch, whose
+	// responses may be held open while waiting for changes; the wait must
+	// end before it. Default: 5m.
+	WatchWrite time.Duration `yaml:"watch_write"`
+	// Request is the deadline on a request's context, so that store queries
+	// stuck behind a slow database are cancelled and answered with 504
+	// instead of holding a connection. Keep it below Write so the 504 can
+	// still be written. Default: 30s.
+	Request time.Duration `yaml:"request"`
+	// WatchRequest replaces Request for GET /api/v1/config/watch. Keep it
+	// below WatchWrite. Default: 4m.
+	WatchRequest time.Duration `yaml:"watch_request"`
+	// StreamRequest replaces Request for GET /api/v1/config/stream, which
+	// exports the largest regions row by row and would otherwise be cut
+	// off partway through. Default: 10m.
+	StreamRequest time.Duration `yaml:"stream_request"`
+	// StreamWrite replaces Write for GET /api/v1/config/stream, so a slow
+	// consumer of a large export is not cut off. Keep it above
+	// StreamRequest. Default: 15m.
+	StreamWrite time.Duration `yaml:"stream_write"`
+}
+
+// TrustedProxyPrefixes parses TrustedProxies. A bare IP is taken as a
+// single-address prefix.
+func (c ServerConfig) TrustedProxyPrefixes() ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(c.TrustedProxies))
+	for _, s := range c.TrustedProxies {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			prefixes = append(prefixes, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("server.trusted_proxies: %q is not a CIDR or IP address", s)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+	}
+	return prefixes, nil
+}
+
+// AccessLogConfig controls the structured per-request access log.
+type AccessLogConfig struct {
+	// Enabled turns the access log on. Default: true.
+	Enabled bool `yaml:"enabled"`
+	// SampleRates maps a request path to the fraction (0 to 1) of its
+	// requests that are logged, for high-volume routes. Unlisted paths are
+	// always logged, as are 5xx responses. Default: config watch at 0.1.
+	SampleRates map[string]float64 `yaml:"sample_rates"`
 }
 
 type PostgresConfig struct {
 	DSN string `yaml:"dsn"`
+	// ReadDSN optionally points read-heavy queries (config listings, audit
+	// log, watch) at a read replica. Empty means all queries use DSN.
+	ReadDSN string `yaml:"read_dsn"`
 }
 
 // OIDCConfig holds OpenID Connect configuration.
@@ -36,16 +300,75 @@ type OIDCConfig struct {
 	// Subsequent logins never change admin status — it's fully managed via the UI.
 	// Can also be set via OIDC_INITIAL_ADMIN_USERS env var.
 	InitialAdminUsers string `yaml:"initial_admin_users"`
+	// Scopes requested in the authorization redirect.
+	// Defaults to openid, profile, email. Can also be set via OIDC_SCOPES
+	// (space- or comma-separated).
+	Scopes []string `yaml:"scopes"`
+	// Claims maps user attributes to the claim names issued by the IdP.
+	Claims OIDCClaimsConfig `yaml:"claims"`
+}
+
+// OIDCClaimsConfig names the token claims Hermes reads user attributes from.
+// A dotted name (e.g. "realm_access.roles") addresses a nested claim.
+// Each field can be overridden by OIDC_<FIELD>_CLAIM.
+type OIDCClaimsConfig struct {
+	Username string `yaml:"username"` // default: preferred_username
+	Email    string `yaml:"email"`    // default: email
+	Name     string `yaml:"name"`     // default: name
+	Groups   string `yaml:"groups"`   // default: groups
 }
 
 // BuiltinAuthConfig holds configuration for the built-in username/password
 // authentication system. Uses bcrypt for password hashing and self-signed
-// HMAC-SHA256 JWTs. Signing keys are auto-generated and persisted in PostgreSQL.
+// JWTs. Signing keys are auto-generated and persisted in PostgreSQL.
 type BuiltinAuthConfig struct {
 	// InitialAdminEmail is the email for the auto-created initial admin user.
 	InitialAdminEmail string `yaml:"initial_admin_email"`
 	// InitialAdminPassword is the password for the initial admin user.
 	InitialAdminPassword string `yaml:"initial_admin_password"`
+	// SigningAlgorithm is the JWS algorithm for issued tokens: "HS256"
+	// (shared secret, default), "RS256" or "ES256". Asymmetric keys publish
+	// their public halves at /api/auth/jwks. Changing it rotates the key on
+	// startup.
+	SigningAlgorithm string `yaml:"signing_algorithm"`
+	// AccessTokenTTL is the lifetime of issued access tokens (JWTs).
+	// Must be between 1m and 24h. Default: 24h.
+	// Can be overridden by HERMES_ACCESS_TOKEN_TTL.
+	AccessTokenTTL time.Duration `yaml:"access_token_ttl"`
+	// KeyRotationGracePeriod is how long a retired signing key keeps
+	// verifying tokens after POST /api/auth/rotate-key. Must be at least
+	// AccessTokenTTL (so in-flight tokens survive) and at most 7 days.
+	// Default: AccessTokenTTL.
+	KeyRotationGracePeriod time.Duration `yaml:"key_rotation_grace_period"`
+	// RotationInterval rotates the signing key automatically once the
+	// active key is this old. 0 (default) leaves rotation manual.
+	RotationInterval time.Duration `yaml:"rotation_interval"`
+	// RefreshTokenTTL is how long a builtin refresh token stays valid.
+	// Each use rotates it. Default: 7 days.
+	RefreshTokenTTL time.Duration `yaml:"refresh_token_ttl"`
+	// LockoutThreshold is the number of failed logins within LockoutWindow
+	// that locks an account for LockoutCooldown. 0 disables lockout.
+	// Can be overridden by HERMES_LOCKOUT_THRESHOLD.
+	LockoutThreshold int           `yaml:"lockout_threshold"`
+	LockoutWindow    time.Duration `yaml:"lockout_window"`
+	LockoutCooldown  time.Duration `yaml:"lockout_cooldown"`
+	// PasswordPolicy applies when builtin users set or are given a password.
+	PasswordPolicy PasswordPolicyConfig `yaml:"password_policy"`
+}
+
+// PasswordPolicyConfig controls which passwords builtin users may choose.
+type PasswordPolicyConfig struct {
+	// MinLength is the minimum number of characters. Default: 8.
+	MinLength int `yaml:"min_length"`
+	// MinCharClasses is how many of lowercase, uppercase, digits and symbols
+	// must appear. Default: 2.
+	MinCharClasses int `yaml:"min_char_classes"`
+	// RejectCommon rejects passwords on the embedded common-password list.
+	// Default: true.
+	RejectCommon bool `yaml:"reject_common"`
+	// HistorySize is how many previous passwords a user may not reuse on
+	// change or admin reset. 0 disables the check. Default: 5.
+	HistorySize int `yaml:"history_size"`
 }
 
 // Load reads configuration from a YAML file (if it exists) and applies
@@ -54,10 +377,76 @@ type BuiltinAuthConfig struct {
 // the service to start with zero configuration for local development.
 func Load(path string) (*Config, error) {
 	cfg := &Config{
-		Server: ServerConfig{Listen: "0.0.0.0:9080"},
+		Server: ServerConfig{
+			Listen:       "0.0.0.0:9080",
+			LogLevel:     "info",
+			CORSOrigins:  []string{"*"},
+			MaxBodyBytes: 10 << 20,
+			Timeouts: TimeoutsConfig{
+				Read:          15 * time.Second,
+				Write:         60 * time.Second,
+				Idle:          60 * time.Second,
+				WatchWrite:    5 * time.Minute,
+				Request:       30 * time.Second,
+				WatchRequest:  4 * time.Minute,
+				StreamRequest: 10 * time.Minute,
+				StreamWrite:   15 * time.Minute,
+			},
+			AccessLog: AccessLogConfig{
+				Enabled:     true,
+				SampleRates: map[string]float64{"/api/v1/config/watch": 0.1},
+			},
+		},
 		Postgres: PostgresConfig{
 			DSN: "postgres://localhost:5432/hermes?sslmode=disable",
 		},
+		BuiltinAuth: BuiltinAuthConfig{
+			SigningAlgorithm: "HS256",
+			AccessTokenTTL:   24 * time.Hour,
+			RefreshTokenTTL:  7 * 24 * time.Hour,
+			LockoutThreshold: 5,
+			LockoutWindow:    15 * time.Minute,
+			LockoutCooldown:  15 * time.Minute,
+			PasswordPolicy: PasswordPolicyConfig{
+				MinLength:      8,
+				MinCharClasses: 2,
+				RejectCommon:   true,
+				HistorySize:    5,
+			},
+		},
+		Status: StatusConfig{
+			InstanceStaleAfter:   30 * time.Second,
+			ControllerStaleAfter: 30 * time.Second,
+			Health: HealthConfig{
+				RedOfflineRatio: 0.5,
+			},
+		},
+		Etcd: EtcdConfig{
+			DialTimeout:   5 * time.Second,
+			DomainPrefix:  "/hermes/domains",
+			ClusterPrefix: "/hermes/clusters",
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "hermes-server",
+			SampleRatio: 1,
+		},
+		ChangeLog: ChangeLogConfig{
+			ArchiveTarget:    "table",
+			ArchiveInterval:  time.Hour,
+			ArchiveBatchSize: 5000,
+		},
+		OIDC: OIDCConfig{
+			Scopes: []string{"openid", "profile", "email"},
+			Claims: OIDCClaimsConfig{
+				Username: "preferred_username",
+				Email:    "email",
+				Name:     "name",
+				Groups:   "groups",
+			},
+		},
 	}
 
 	data, err := os.ReadFile(path)
@@ -74,9 +463,41 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("HERMES_LISTEN"); v != "" {
 		cfg.Server.Listen = v
 	}
+	if v := os.Getenv("HERMES_LOG_LEVEL"); v != "" {
+		cfg.Server.LogLevel = v
+	}
+	if v := os.Getenv("HERMES_MAX_BODY_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HERMES_MAX_BODY_BYTES: %w", err)
+		}
+		cfg.Server.MaxBodyBytes = n
+	}
+	if v := os.Getenv("HERMES_READ_ONLY"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HERMES_READ_ONLY: %w", err)
+		}
+		cfg.Server.ReadOnly = b
+	}
+	if v := os.Getenv("HERMES_OTLP_ENDPOINT"); v != "" {
+		cfg.Tracing.Endpoint = v
+	}
 	if v := os.Getenv("HERMES_POSTGRES_DSN"); v != "" {
 		cfg.Postgres.DSN = v
 	}
+	if v := os.Getenv("HERMES_POSTGRES_READ_DSN"); v != "" {
+		cfg.Postgres.ReadDSN = v
+	}
+	if v := os.Getenv("HERMES_ETCD_ENDPOINTS"); v != "" {
+		cfg.Etcd.Endpoints = strings.Split(v, ",")
+	}
+	if v := os.Getenv("HERMES_SMTP_HOST"); v != "" {
+		cfg.SMTP.Host = v
+	}
+	if v := os.Getenv("HERMES_SMTP_PASSWORD"); v != "" {
+		cfg.SMTP.Password = v
+	}
 
 	// OIDC overrides (kept backward-compatible with existing env var names).
 	if v := os.Getenv("OIDC_ENABLED"); v == "true" || v == "1" {
@@ -94,6 +515,25 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("OIDC_INITIAL_ADMIN_USERS"); v != "" {
 		cfg.OIDC.InitialAdminUsers = v
 	}
+	if v := os.Getenv("OIDC_SCOPES"); v != "" {
+		cfg.OIDC.Scopes = strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' })
+	}
+	if v := os.Getenv("OIDC_USERNAME_CLAIM"); v != "" {
+		cfg.OIDC.Claims.Username = v
+	}
+	if v := os.Getenv("OIDC_EMAIL_CLAIM"); v != "" {
+		cfg.OIDC.Claims.Email = v
+	}
+	if v := os.Getenv("OIDC_NAME_CLAIM"); v != "" {
+		cfg.OIDC.Claims.Name = v
+	}
+	if v := os.Getenv("OIDC_GROUPS_CLAIM"); v != "" {
+		cfg.OIDC.Claims.Groups = v
+	}
+
+	if v := os.Getenv("HERMES_MASTER_KEY"); v != "" {
+		cfg.MasterKey = v
+	}
 
 	// Auth mode override.
 	if v := os.Getenv("HERMES_AUTH_MODE"); v != "" {
@@ -111,6 +551,180 @@ func Load(path string) (*Config, error) {
 	if v := os.Getenv("HERMES_INITIAL_ADMIN_PASSWORD"); v != "" {
 		cfg.BuiltinAuth.InitialAdminPassword = v
 	}
+	if v := os.Getenv("HERMES_LOCKOUT_THRESHOLD"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HERMES_LOCKOUT_THRESHOLD: %w", err)
+		}
+		cfg.BuiltinAuth.LockoutThreshold = n
+	}
+	if v := os.Getenv("HERMES_ACCESS_TOKEN_TTL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid HERMES_ACCESS_TOKEN_TTL: %w", err)
+		}
+		cfg.BuiltinAuth.AccessTokenTTL = d
+	}
+	if cfg.BuiltinAuth.KeyRotationGracePeriod == 0 {
+		cfg.BuiltinAuth.KeyRotationGracePeriod = cfg.BuiltinAuth.AccessTokenTTL
+	}
+	switch cfg.Server.LogLevel {
+	case "debug", "info", "warn", "error":
+	default:
+		return nil, fmt.Errorf("server.log_level must be debug, info, warn or error, got %q", cfg.Server.LogLevel)
+	}
+	if cfg.Server.MaxBodyBytes <= 0 {
+		return nil, fmt.Errorf("server.max_body_bytes must be positive, got %d", cfg.Server.MaxBodyBytes)
+	}
+	if t := cfg.Server.Timeouts; t.Read < 0 || t.Write < 0 || t.Idle < 0 || t.WatchWrite < 0 || t.Request < 0 || t.WatchRequest < 0 || t.StreamRequest < 0 || t.StreamWrite < 0 {
+		return nil, fmt.Errorf("server.timeouts must not be negative")
+	}
+	if _, err := cfg.Server.TrustedProxyPrefixes(); err != nil {
+		return nil, err
+	}
+	for path, rate := range cfg.Server.AccessLog.SampleRates {
+		if rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("server.access_log.sample_rates[%q] must be between 0 and 1, got %g", path, rate)
+		}
+	}
+	if err := cfg.BuiltinAuth.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.ChangeLog.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Quotas.MaxDomains < 0 || cfg.Quotas.MaxClusters < 0 {
+		return nil, fmt.Errorf("quotas.max_domains and quotas.max_clusters must not be negative")
+	}
+	names := make(map[string]bool, len(cfg.Rules))
+	for i := range cfg.Rules {
+		if err := cfg.Rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("rules[%d]: %w", i, err)
+		}
+		if names[cfg.Rules[i].Name] {
+			return nil, fmt.Errorf("rules[%d]: duplicate name %q", i, cfg.Rules[i].Name)
+		}
+		names[cfg.Rules[i].Name] = true
+	}
+	if cfg.Status.InstanceStaleAfter <= 0 || cfg.Status.ControllerStaleAfter <= 0 {
+		return nil, fmt.Errorf("status.instance_stale_after and status.controller_stale_after must be positive")
+	}
+	if err := cfg.Status.Health.validate(); err != nil {
+		return nil, err
+	}
+	if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+		return nil, fmt.Errorf("tracing.sample_ratio must be between 0 and 1, got %g", cfg.Tracing.SampleRatio)
+	}
+	if len(cfg.Etcd.Endpoints) > 0 && cfg.Etcd.DialTimeout <= 0 {
+		return nil, fmt.Errorf("etcd.dial_timeout must be positive, got %s", cfg.Etcd.DialTimeout)
+	}
+	if err := cfg.SMTP.validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Notifications.Credentials.validate("notifications.credentials", cfg.SMTP); err != nil {
+		return nil, err
+	}
 
 	return cfg, nil
 }
+
+// Bounds for builtin token lifetimes.
+const (
+	MinAccessTokenTTL         = time.Minute
+	MaxAccessTokenTTL         = 24 * time.Hour
+	MaxKeyRotationGracePeriod = 7 * 24 * time.Hour
+	MinRotationInterval       = time.Hour
+)
+
+func (c BuiltinAuthConfig) validate() error {
+	switch c.SigningAlgorithm {
+	case "HS256", "RS256", "ES256":
+	default:
+		return fmt.Errorf("builtin_auth.signing_algorithm must be HS256, RS256 or ES256, got %q", c.SigningAlgorithm)
+	}
+	if c.AccessTokenTTL < MinAccessTokenTTL || c.AccessTokenTTL > MaxAccessTokenTTL {
+		return fmt.Errorf("builtin_auth.access_token_ttl must be between %s and %s, got %s",
+			MinAccessTokenTTL, MaxAccessTokenTTL, c.AccessTokenTTL)
+	}
+	if c.KeyRotationGracePeriod < c.AccessTokenTTL || c.KeyRotationGracePeriod > MaxKeyRotationGracePeriod {
+		return fmt.Errorf("builtin_auth.key_rotation_grace_period must be between access_token_ttl (%s) and %s, got %s",
+			c.AccessTokenTTL, MaxKeyRotationGracePeriod, c.KeyRotationGracePeriod)
+	}
+	if c.RotationInterval != 0 && c.RotationInterval < MinRotationInterval {
+		return fmt.Errorf("builtin_auth.rotation_interval must be 0 or at least %s, got %s",
+			MinRotationInterval, c.RotationInterval)
+	}
+	return nil
+}
+
+func (c HealthConfig) validate() error {
+	for name, ratio := range map[string]float64{
+		"red_offline_ratio":        c.RedOfflineRatio,
+		"yellow_offline_ratio":     c.YellowOfflineRatio,
+		"yellow_out_of_sync_ratio": c.YellowOutOfSyncRatio,
+	} {
+		if ratio < 0 || ratio > 1 {
+			return fmt.Errorf("status.health.%s must be between 0 and 1, got %g", name, ratio)
+		}
+	}
+	if c.YellowOfflineRatio > c.RedOfflineRatio {
+		return fmt.Errorf("status.health.yellow_offline_ratio (%g) must not exceed red_offline_ratio (%g)",
+			c.YellowOfflineRatio, c.RedOfflineRatio)
+	}
+	return nil
+}
+
+func (c SMTPConfig) validate() error {
+	if c.Host == "" {
+		return nil
+	}
+	if c.Port <= 0 || c.Port > 65535 {
+		return fmt.Errorf("smtp.port must be between 1 and 65535, got %d", c.Port)
+	}
+	if c.From == "" {
+		return fmt.Errorf("smtp.from is required when smtp.host is set")
+	}
+	return nil
+}
+
+func (c NotifyChannel) validate(name string, smtp SMTPConfig) error {
+	if c.WebhookURL != "" {
+		u, err := url.Parse(c.WebhookURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s.webhook_url must be an http(s) URL, got %q", name, c.WebhookURL)
+		}
+	}
+	if len(c.Email) > 0 && smtp.Host == "" {
+		return fmt.Errorf("%s.email needs smtp.host to be set", name)
+	}
+	for _, addr := range c.Email {
+		if _, err := mail.ParseAddress(addr); err != nil {
+			return fmt.Errorf("%s.email: invalid address %q", name, addr)
+		}
+	}
+	return nil
+}
+
+// MinChangeLogArchiveAfter keeps archival from racing controllers that are
+// still catching up on recent events via watch.
+const MinChangeLogArchiveAfter = 24 * time.Hour
+
+func (c ChangeLogConfig) validate() error {
+	if c.ArchiveTarget != "table" {
+		return fmt.Errorf("change_log.archive_target must be \"table\", got %q", c.ArchiveTarget)
+	}
+	if c.ArchiveAfter == 0 {
+		return nil
+	}
+	if c.ArchiveAfter < MinChangeLogArchiveAfter {
+		return fmt.Errorf("change_log.archive_after must be 0 or at least %s, got %s",
+			MinChangeLogArchiveAfter, c.ArchiveAfter)
+	}
+	if c.ArchiveInterval <= 0 {
+		return fmt.Errorf("change_log.archive_interval must be positive, got %s", c.ArchiveInterval)
+	}
+	if c.ArchiveBatchSize <= 0 {
+		return fmt.Errorf("change_log.archive_batch_size must be positive, got %d", c.ArchiveBatchSize)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package config
 
 import (
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -14,10 +16,21 @@ func TestLoad_Defaults(t *testing.T) {
 	require.NoError(t, err)
 
 	assert.Equal(t, "0.0.0.0:9080", cfg.Server.Listen)
+	assert.Equal(t, int64(10<<20), cfg.Server.MaxBodyBytes)
+	assert.True(t, cfg.Server.AccessLog.Enabled)
+	assert.Equal(t, 0.1, cfg.Server.AccessLog.SampleRates["/api/v1/config/watch"])
 	assert.Equal(t, "postgres://localhost:5432/hermes?sslmode=disable", cfg.Postgres.DSN)
 	assert.False(t, cfg.OIDC.Enabled)
 	assert.Empty(t, cfg.OIDC.Issuer)
 	assert.Empty(t, cfg.AuthMode)
+	assert.Equal(t, 7*24*time.Hour, cfg.BuiltinAuth.RefreshTokenTTL)
+	assert.Equal(t, 5, cfg.BuiltinAuth.LockoutThreshold)
+	assert.Equal(t, 15*time.Minute, cfg.BuiltinAuth.LockoutWindow)
+	assert.Equal(t, 15*time.Minute, cfg.BuiltinAuth.LockoutCooldown)
+	assert.Equal(t, 8, cfg.BuiltinAuth.PasswordPolicy.MinLength)
+	assert.Equal(t, 2, cfg.BuiltinAuth.PasswordPolicy.MinCharClasses)
+	assert.True(t, cfg.BuiltinAuth.PasswordPolicy.RejectCommon)
+	assert.Equal(t, 5, cfg.BuiltinAuth.PasswordPolicy.HistorySize)
 }
 
 func TestLoad_YAMLFile(t *testing.T) {
@@ -36,6 +49,9 @@ auth_mode: "oidc"
 builtin_auth:
   initial_admin_email: "admin@local"
   initial_admin_password: "pass123"
+  lockout_threshold: 10
+  lockout_window: 5m
+  lockout_cooldown: 1h
 `
 	tmp := filepath.Join(t.TempDir(), "config.yaml")
 	require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
@@ -53,6 +69,9 @@ builtin_auth:
 	assert.Equal(t, "oidc", cfg.AuthMode)
 	assert.Equal(t, "admin@local", cfg.BuiltinAuth.InitialAdminEmail)
 	assert.Equal(t, "pass123", cfg.BuiltinAuth.InitialAdminPassword)
+	assert.Equal(t, 10, cfg.BuiltinAuth.LockoutThreshold)
+	assert.Equal(t, 5*time.Minute, cfg.BuiltinAuth.LockoutWindow)
+	assert.Equal(t, time.Hour, cfg.BuiltinAuth.LockoutCooldown)
 }
 
 func TestLoad_InvalidYAML(t *testing.T) {
@@ -66,6 +85,7 @@ func TestLoad_InvalidYAML(t *testing.T) {
 func TestLoad_EnvOverrides(t *testing.T) {
 	t.Setenv("HERMES_LISTEN", "0.0.0.0:7070")
 	t.Setenv("HERMES_POSTGRES_DSN", "postgres://env:5432/hermes")
+	t.Setenv("HERMES_POSTGRES_READ_DSN", "postgres://replica:5432/hermes")
 	t.Setenv("OIDC_ENABLED", "true")
 	t.Setenv("OIDC_ISSUER", "https://env-issuer")
 	t.Setenv("OIDC_CLIENT_ID", "env-client")
@@ -74,12 +94,14 @@ func TestLoad_EnvOverrides(t *testing.T) {
 	t.Setenv("HERMES_AUTH_MODE", "builtin")
 	t.Setenv("HERMES_INITIAL_ADMIN_EMAIL", "env@admin")
 	t.Setenv("HERMES_INITIAL_ADMIN_PASSWORD", "envpass")
+	t.Setenv("HERMES_MASTER_KEY", "a2V5")
 
 	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
 	require.NoError(t, err)
 
 	assert.Equal(t, "0.0.0.0:7070", cfg.Server.Listen)
 	assert.Equal(t, "postgres://env:5432/hermes", cfg.Postgres.DSN)
+	assert.Equal(t, "postgres://replica:5432/hermes", cfg.Postgres.ReadDSN)
 	assert.True(t, cfg.OIDC.Enabled)
 	assert.Equal(t, "https://env-issuer", cfg.OIDC.Issuer)
 	assert.Equal(t, "env-client", cfg.OIDC.ClientID)
@@ -88,6 +110,7 @@ func TestLoad_EnvOverrides(t *testing.T) {
 	assert.Equal(t, "builtin", cfg.AuthMode)
 	assert.Equal(t, "env@admin", cfg.BuiltinAuth.InitialAdminEmail)
 	assert.Equal(t, "envpass", cfg.BuiltinAuth.InitialAdminPassword)
+	assert.Equal(t, "a2V5", cfg.MasterKey)
 }
 
 func TestLoad_OIDCEnabledSetsAuthMode(t *testing.T) {
@@ -124,3 +147,330 @@ server:
 	require.NoError(t, err)
 	assert.Equal(t, "0.0.0.0:1111", cfg.Server.Listen)
 }
+
+func TestLoad_OIDCScopesAndClaims(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid", "profile", "email"}, cfg.OIDC.Scopes)
+	assert.Equal(t, "preferred_username", cfg.OIDC.Claims.Username)
+	assert.Equal(t, "groups", cfg.OIDC.Claims.Groups)
+
+	yaml := `
+oidc:
+  scopes:
+    - openid
+    - offline_access
+  claims:
+    username: upn
+    groups: realm_access.roles
+`
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
+
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid", "offline_access"}, cfg.OIDC.Scopes)
+	assert.Equal(t, "upn", cfg.OIDC.Claims.Username)
+	assert.Equal(t, "email", cfg.OIDC.Claims.Email, "unset claims keep their default")
+	assert.Equal(t, "realm_access.roles", cfg.OIDC.Claims.Groups)
+
+	t.Setenv("OIDC_SCOPES", "openid, email offline_access")
+	t.Setenv("OIDC_EMAIL_CLAIM", "mail")
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"openid", "email", "offline_access"}, cfg.OIDC.Scopes)
+	assert.Equal(t, "mail", cfg.OIDC.Claims.Email)
+}
+
+func TestLoad_TokenLifetimes(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "HS256", cfg.BuiltinAuth.SigningAlgorithm)
+	assert.Equal(t, 24*time.Hour, cfg.BuiltinAuth.AccessTokenTTL)
+	assert.Equal(t, 24*time.Hour, cfg.BuiltinAuth.KeyRotationGracePeriod, "grace defaults to the access TTL")
+
+	write := func(yaml string) string {
+		tmp := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
+		return tmp
+	}
+
+	cfg, err = Load(write("builtin_auth:\n  access_token_ttl: 15m\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 15*time.Minute, cfg.BuiltinAuth.AccessTokenTTL)
+	assert.Equal(t, 15*time.Minute, cfg.BuiltinAuth.KeyRotationGracePeriod)
+
+	cfg, err = Load(write("builtin_auth:\n  access_token_ttl: 15m\n  key_rotation_grace_period: 1h\n"))
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, cfg.BuiltinAuth.KeyRotationGracePeriod)
+	assert.Zero(t, cfg.BuiltinAuth.RotationInterval)
+
+	cfg, err = Load(write("builtin_auth:\n  rotation_interval: 720h\n"))
+	require.NoError(t, err)
+	assert.Equal(t, 720*time.Hour, cfg.BuiltinAuth.RotationInterval)
+
+	for _, bad := range []string{
+		"builtin_auth:\n  access_token_ttl: 10s\n",
+		"builtin_auth:\n  access_token_ttl: 48h\n",
+		"builtin_auth:\n  access_token_ttl: 1h\n  key_rotation_grace_period: 30m\n",
+		"builtin_auth:\n  key_rotation_grace_period: 720h\n",
+		"builtin_auth:\n  rotation_interval: 10m\n",
+		"builtin_auth:\n  signing_algorithm: none\n",
+	} {
+		_, err = Load(write(bad))
+		assert.Error(t, err, bad)
+	}
+
+	t.Setenv("HERMES_ACCESS_TOKEN_TTL", "5m")
+	cfg, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 5*time.Minute, cfg.BuiltinAuth.AccessTokenTTL)
+
+	t.Setenv("HERMES_ACCESS_TOKEN_TTL", "soon")
+	_, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	assert.Error(t, err)
+}
+
+func TestLoad_ChangeLogArchive(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Zero(t, cfg.ChangeLog.ArchiveAfter)
+	assert.Equal(t, "table", cfg.ChangeLog.ArchiveTarget)
+	assert.Equal(t, time.Hour, cfg.ChangeLog.ArchiveInterval)
+	assert.Equal(t, 5000, cfg.ChangeLog.ArchiveBatchSize)
+
+	load := func(yaml string) error {
+		tmp := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
+		_, err := Load(tmp)
+		return err
+	}
+	assert.NoError(t, load("change_log:\n  archive_after: 720h\n"))
+	assert.Error(t, load("change_log:\n  archive_after: 1h\n"))
+	assert.Error(t, load("change_log:\n  archive_target: s3\n"))
+}
+
+func TestLoad_Rules(t *testing.T) {
+	load := func(yaml string) (*Config, error) {
+		tmp := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(tmp, []byte(yaml), 0644))
+		return Load(tmp)
+	}
+	cfg, err := load(`
+rules:
+  - name: redundant-nodes
+    kind: cluster
+    field: nodes
+    op: min
+    value: 2
+  - name: prod-tls
+    kind: domain
+    field: tls.certificate_ref
+    op: exists
+    severity: error
+    regions: [production]
+`)
+	require.NoError(t, err)
+	require.Len(t, cfg.Rules, 2)
+	assert.Equal(t, "warning", cfg.Rules[0].Severity)
+	assert.Equal(t, []string{"production"}, cfg.Rules[1].Regions)
+
+	_, err = load("rules:\n  - {name: r, kind: cluster, field: nodes, op: max, value: 2}\n")
+	assert.ErrorContains(t, err, "rules[0]")
+	_, err = load("rules:\n  - {name: r, kind: cluster, field: nodes, op: exists}\n  - {name: r, kind: domain, field: hosts, op: exists}\n")
+	assert.ErrorContains(t, err, "duplicate")
+}
+
+func TestLoad_MaxBodyBytes(t *testing.T) {
+	t.Setenv("HERMES_MAX_BODY_BYTES", "2048")
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2048), cfg.Server.MaxBodyBytes)
+
+	t.Setenv("HERMES_MAX_BODY_BYTES", "0")
+	_, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	assert.Error(t, err)
+
+	t.Setenv("HERMES_MAX_BODY_BYTES", "10MB")
+	_, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	assert.Error(t, err)
+}
+
+func TestLoad_ReadOnly(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.False(t, cfg.Server.ReadOnly)
+
+	t.Setenv("HERMES_READ_ONLY", "true")
+	cfg, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.True(t, cfg.Server.ReadOnly)
+
+	t.Setenv("HERMES_READ_ONLY", "maybe")
+	_, err = Load("/tmp/hermes_nonexistent_server_config.yaml")
+	assert.Error(t, err)
+}
+
+func TestServerConfig_TrustedProxyPrefixes(t *testing.T) {
+	prefixes, err := ServerConfig{TrustedProxies: []string{"10.1.2.3/8", "127.0.0.1", "::1"}}.TrustedProxyPrefixes()
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("127.0.0.1/32"),
+		netip.MustParsePrefix("::1/128"),
+	}, prefixes)
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  trusted_proxies: [\"proxy.internal\"]\n"), 0644))
+	_, err = Load(tmp)
+	assert.ErrorContains(t, err, "trusted_proxies")
+}
+
+func TestLoad_Timeouts(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, TimeoutsConfig{
+		Read: 15 * time.Second, Write: time.Minute, Idle: time.Minute, WatchWrite: 5 * time.Minute,
+		Request: 30 * time.Second, WatchRequest: 4 * time.Minute, StreamRequest: 10 * time.Minute,
+		StreamWrite: 15 * time.Minute,
+	}, cfg.Server.Timeouts)
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  timeouts:\n    write: 30s\n    watch_write: 0s\n"), 0644))
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, 30*time.Second, cfg.Server.Timeouts.Write)
+	assert.Zero(t, cfg.Server.Timeouts.WatchWrite)
+	assert.Equal(t, 15*time.Second, cfg.Server.Timeouts.Read)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  timeouts:\n    idle: -1s\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  timeouts:\n    request: -1s\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+}
+
+func TestLoad_AccessLogSampleRates(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  access_log:\n    sample_rates:\n      /api/v1/status/instances: 0.5\n"), 0644))
+	cfg, err := Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, cfg.Server.AccessLog.SampleRates["/api/v1/status/instances"])
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  access_log:\n    sample_rates:\n      /api/v1/config/watch: 2\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+}
+
+func TestLoad_Quotas(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Zero(t, cfg.Quotas.MaxDomains, "unlimited by default")
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("quotas:\n  max_domains: 100\n"), 0644))
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, 100, cfg.Quotas.MaxDomains)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("quotas:\n  max_clusters: -1\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+}
+
+func TestReload(t *testing.T) {
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  log_level: info\n"), 0644))
+	current, err := Load(tmp)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  log_level: debug\n  cors_origins: [https://ui.example.com]\nquotas:\n  max_domains: 10\n"), 0644))
+	next, restart, err := Reload(tmp, current)
+	require.NoError(t, err)
+	assert.Equal(t, "debug", next.Server.LogLevel)
+	assert.Equal(t, []string{"https://ui.example.com"}, next.Server.CORSOrigins)
+	assert.Equal(t, 10, next.Quotas.MaxDomains)
+	assert.Empty(t, restart, "hot settings need no restart")
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  listen: 0.0.0.0:9999\npostgres:\n  dsn: postgres://other/hermes\n"), 0644))
+	_, restart, err = Reload(tmp, current)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"server.listen", "postgres"}, restart)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server:\n  log_level: loud\n"), 0644))
+	_, _, err = Reload(tmp, current)
+	assert.Error(t, err)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("server: [\n"), 0644))
+	_, _, err = Reload(tmp, current)
+	assert.Error(t, err)
+}
+
+func TestDynamic(t *testing.T) {
+	var unset *Dynamic[QuotaConfig]
+	assert.Zero(t, unset.Load())
+
+	d := NewDynamic(QuotaConfig{MaxDomains: 1})
+	d.Store(QuotaConfig{MaxDomains: 2})
+	assert.Equal(t, 2, d.Load().MaxDomains)
+}
+
+func TestLoad_HealthThresholds(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, 0.5, cfg.Status.Health.RedOfflineRatio)
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("status:\n  health:\n    yellow_offline_ratio: 0.6\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err, "yellow above red")
+
+	require.NoError(t, os.WriteFile(tmp, []byte("status:\n  health:\n    yellow_out_of_sync_ratio: 1.5\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+}
+
+func TestLoad_Etcd(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.Etcd.Endpoints, "drift check disabled by default")
+	assert.Equal(t, EtcdKeyspace{DomainPrefix: "/hermes/domains", ClusterPrefix: "/hermes/clusters"}, cfg.Etcd.Keyspace("default"))
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("etcd:\n  endpoints: [http://etcd:2379]\n  regions:\n    staging:\n      domain_prefix: /staging/domains\n"), 0644))
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.Equal(t, EtcdKeyspace{DomainPrefix: "/staging/domains", ClusterPrefix: "/hermes/clusters"}, cfg.Etcd.Keyspace("staging"))
+	assert.Equal(t, "/hermes/domains", cfg.Etcd.Keyspace("prod").DomainPrefix)
+
+	require.NoError(t, os.WriteFile(tmp, []byte("etcd:\n  endpoints: [http://etcd:2379]\n  dial_timeout: 0s\n"), 0644))
+	_, err = Load(tmp)
+	assert.Error(t, err)
+}
+
+func TestLoad_CredentialNotifications(t *testing.T) {
+	cfg, err := Load("/tmp/hermes_nonexistent_server_config.yaml")
+	require.NoError(t, err)
+	assert.Empty(t, cfg.SMTP.Host, "mail disabled by default")
+	assert.Equal(t, 587, cfg.SMTP.Port)
+	assert.False(t, cfg.Notifications.Credentials.Enabled())
+
+	tmp := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(tmp, []byte("smtp:\n  host: mail.internal\n  from: hermes@example.com\nnotifications:\n  credentials:\n    webhook_url: https://siem.internal/hooks/hermes\n    email: [security@example.com]\n"), 0644))
+	cfg, err = Load(tmp)
+	require.NoError(t, err)
+	assert.True(t, cfg.Notifications.Credentials.Enabled())
+	assert.Equal(t, []string{"security@example.com"}, cfg.Notifications.Credentials.Email)
+
+	for _, bad := range []string{
+		"notifications:\n  credentials:\n    email: [security@example.com]\n",
+		"notifications:\n  credentials:\n    webhook_url: ftp://siem.internal\n",
+		"smtp:\n  host: mail.internal\n",
+		"smtp:\n  host: mail.internal\n  from: hermes@example.com\nnotifications:\n  credentials:\n    email: [not-an-address]\n",
+	} {
+		require.NoError(t, os.WriteFile(tmp, []byte(bad), 0644))
+		_, err = Load(tmp)
+		assert.Error(t, err, bad)
+	}
+}
@@ -0,0 +1,81 @@
+package config
+
+import (
+	"reflect"
+	"sync/atomic"
+)
+
+// Dynamic holds a setting that a config reload may replace while the server
+// is running. It is safe for concurrent use; a nil *Dynamic loads the zero
+// value.
+type Dynamic[T any] struct {
+	v atomic.Pointer[T]
+}
+
+func NewDynamic[T any](v T) *Dynamic[T] {
+	d := &Dynamic[T]{}
+	d.Store(v)
+	return d
+}
+
+func (d *Dynamic[T]) Load() T {
+	var zero T
+	if d == nil {
+		return zero
+	}
+	if p := d.v.Load(); p != nil {
+		return *p
+	}
+	return zero
+}
+
+func (d *Dynamic[T]) Store(v T) {
+	d.v.Store(&v)
+}
+
+// Reload re-reads the config file of a running server. It returns the new
+// config along with the changed settings that only take effect after a
+// restart. The file is fully loaded and validated first, so on error the
+// caller simply keeps running with current.
+//
+// Settings applied at runtime: server.log_level, server.cors_origins,
+// server.strict_json, server.read_only, quotas, rules and status. Everything
+// else needs a restart.
+func Reload(path string, current *Config) (*Config, []string, error) {
+	next, err := Load(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return next, restartRequired(current, next), nil
+}
+
+// restartRequired names the restart-only settings that differ between a and b.
+func restartRequired(a, b *Config) []string {
+	fields := []struct {
+		name string
+		a, b any
+	}{
+		{"server.listen", a.Server.Listen, b.Server.Listen},
+		{"server.max_body_bytes", a.Server.MaxBodyBytes, b.Server.MaxBodyBytes},
+		{"server.access_log", a.Server.AccessLog, b.Server.AccessLog},
+		{"server.trusted_proxies", a.Server.TrustedProxies, b.Server.TrustedProxies},
+		{"server.timeouts", a.Server.Timeouts, b.Server.Timeouts},
+		{"postgres", a.Postgres, b.Postgres},
+		{"auth_mode", a.AuthMode, b.AuthMode},
+		{"oidc", a.OIDC, b.OIDC},
+		{"builtin_auth", a.BuiltinAuth, b.BuiltinAuth},
+		{"master_key", a.MasterKey, b.MasterKey},
+		{"change_log", a.ChangeLog, b.ChangeLog},
+		{"tracing", a.Tracing, b.Tracing},
+		{"etcd", a.Etcd, b.Etcd},
+		{"smtp", a.SMTP, b.SMTP},
+		{"notifications", a.Notifications, b.Notifications},
+	}
+	var changed []string
+	for _, f := range fields {
+		if !reflect.DeepEqual(f.a, f.b) {
+			changed = append(changed, f.name)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,68 @@
+// Package etcdread is a read-only view of the domain and cluster keys the
+// controllers write to etcd. It exposes no way to write.
+package etcdread
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+)
+
+// Reader lists the etcd keys of a region's domains and clusters.
+type Reader struct {
+	client *clientv3.Client
+	cfg    config.EtcdConfig
+}
+
+// New connects to cfg.Endpoints. With no endpoints it returns nil, nil:
+// drift checks are then unavailable.
+func New(cfg config.EtcdConfig) (*Reader, error) {
+	if len(cfg.Endpoints) == 0 {
+		return nil, nil
+	}
+	etcdCfg := clientv3.Config{
+		Endpoints:   cfg.Endpoints,
+		DialTimeout: cfg.DialTimeout,
+	}
+	if cfg.Username != "" {
+		etcdCfg.Username = cfg.Username
+		etcdCfg.Password = cfg.Password
+	}
+	client, err := clientv3.New(etcdCfg)
+	if err != nil {
+		return nil, fmt.Errorf("etcd connect: %w", err)
+	}
+	return &Reader{client: client, cfg: cfg}, nil
+}
+
+// Domains returns the region's domain keys, keyed by domain name.
+func (r *Reader) Domains(ctx context.Context, region string) (map[string]string, error) {
+	return r.list(ctx, r.cfg.Keyspace(region).DomainPrefix)
+}
+
+// Clusters returns the region's cluster keys, keyed by cluster name.
+func (r *Reader) Clusters(ctx context.Context, region string) (map[string]string, error) {
+	return r.list(ctx, r.cfg.Keyspace(region).ClusterPrefix)
+}
+
+func (r *Reader) list(ctx context.Context, prefix string) (map[string]string, error) {
+	prefix = strings.TrimRight(prefix, "/") + "/"
+	resp, err := r.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, fmt.Errorf("etcd get %s: %w", prefix, err)
+	}
+	out := make(map[string]string, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		out[strings.TrimPrefix(string(kv.Key), prefix)] = string(kv.Value)
+	}
+	return out, nil
+}
+
+// Close releases the etcd connection.
+func (r *Reader) Close() error {
+	return r.client.Close()
+}
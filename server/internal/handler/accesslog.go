@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// requestInfo collects attributes resolved by inner middleware (region,
+// caller) so the access log, which runs outermost, can report them.
+type requestInfo struct {
+	region  string
+	subject string
+}
+
+type requestInfoKeyType struct{}
+
+var requestInfoKey = requestInfoKeyType{}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey).(*requestInfo)
+	return info
+}
+
+// statusRecorder captures the status code and body size written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// AccessLog logs one structured line per request. sampleRates maps a request
+// path to the fraction of its requests that are logged (paths not listed are
+// always logged); server errors are logged regardless of sampling.
+func AccessLog(logger *zap.SugaredLogger, sampleRates map[string]float64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			info := &requestInfo{}
+			rec := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey, info)))
+
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			if rate, ok := sampleRates[r.URL.Path]; ok && rec.status < 500 && rand.Float64() >= rate {
+				return
+			}
+			logger.Infow("request",
+				"request_id", RequestIDFromContext(r.Context()),
+				"trace_id", TraceIDFromContext(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"client_ip", ClientIP(r),
+				"region", info.region,
+				"status", rec.status,
+				"duration", time.Since(start),
+				"bytes", rec.bytes,
+				"subject", info.subject,
+			)
+		})
+	}
+}
@@ -1,8 +1,12 @@
 package handler
 
 import (
+	"encoding/base64"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
@@ -20,22 +24,121 @@ func NewAuditHandler(s store.Store, logger *zap.SugaredLogger) *AuditHandler {
 
 func (h *AuditHandler) ListAuditLog(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
-	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
-	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
-	if limit <= 0 {
-		limit = 50
+	p, err := parsePage(r, 50)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Clamp rather than let the store fall back to its default page, so a
+	// full page still signals that more entries follow.
+	p.limit = min(p.limit, store.MaxListLimit)
+	// since (RFC 3339) also searches archived change_log entries.
+	var since time.Time
+	if v := r.URL.Query().Get("since"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			ErrJSON(w, http.StatusBadRequest, "since must be an RFC 3339 timestamp")
+			return
+		}
+		since = t
+	}
+
+	// Cursor mode (?cursor= or ?before=<revision>) pages by revision, so
+	// entries arriving meanwhile neither shift nor repeat later pages.
+	before, err := parseAuditCursor(r)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cursorMode := r.URL.Query().Has("cursor") || r.URL.Query().Has("before")
+	if cursorMode && p.offset > 0 {
+		ErrJSON(w, http.StatusBadRequest, "offset cannot be combined with cursor or before")
+		return
 	}
 
-	entries, total, err := h.store.ListAuditLog(r.Context(), region, limit, offset)
+	entries, total, err := h.store.ListAuditLog(r.Context(), region, since, before, p.limit, p.offset)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	// history_truncated tells callers that since reaches back further than
+	// the oldest event on record, so the result may not be complete.
+	truncated := false
+	if !since.IsZero() {
+		_, oldest, err := h.store.OldestRetainedChange(r.Context(), region)
+		if err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		truncated = !oldest.IsZero() && since.Before(oldest)
+	}
+
+	if !cursorMode {
+		setPageHeaders(w, r, p, int(total))
+		JSON(w, http.StatusOK, map[string]any{
+			"entries":           entries,
+			"total":             total,
+			"limit":             p.limit,
+			"offset":            p.offset,
+			"history_truncated": truncated,
+		})
+		return
+	}
 
+	// A full page may have more behind it; the last page may come back empty.
+	var next string
+	if len(entries) == p.limit {
+		next = encodeAuditCursor(entries[len(entries)-1].Revision)
+		q := r.URL.Query()
+		q.Del("before")
+		q.Set("cursor", next)
+		w.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, r.URL.Path, q.Encode()))
+	}
+	w.Header().Set("X-Total-Count", strconv.FormatInt(total, 10))
 	JSON(w, http.StatusOK, map[string]any{
-		"entries": entries,
-		"total":   total,
-		"limit":   limit,
-		"offset":  offset,
+		"entries":           entries,
+		"total":             total,
+		"limit":             p.limit,
+		"next_cursor":       next,
+		"history_truncated": truncated,
 	})
 }
+
+// auditCursorPrefix versions the opaque cursor format.
+const auditCursorPrefix = "r1:"
+
+// encodeAuditCursor returns the cursor for the page after the entry at
+// revision.
+func encodeAuditCursor(revision int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(auditCursorPrefix + strconv.FormatInt(revision, 10)))
+}
+
+// parseAuditCursor reads ?cursor= (opaque, from next_cursor) or ?before=
+// (a plain revision) and returns the revision entries must precede; 0
+// when neither is given.
+func parseAuditCursor(r *http.Request) (int64, error) {
+	q := r.URL.Query()
+	if q.Has("cursor") && q.Has("before") {
+		return 0, fmt.Errorf("cursor and before are mutually exclusive")
+	}
+	if v := q.Get("before"); q.Has("before") {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 {
+			return 0, fmt.Errorf("before must be a positive revision")
+		}
+		return n, nil
+	}
+	if !q.Has("cursor") {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(q.Get("cursor"))
+	rev, ok := strings.CutPrefix(string(raw), auditCursorPrefix)
+	if err != nil || !ok {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	n, err := strconv.ParseInt(rev, 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid cursor")
+	}
+	return n, nil
+}
@@ -2,17 +2,18 @@ package handler
 
 import (
 	"context"
-	"crypto/hmac"
-	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
 	"go.uber.org/zap"
@@ -21,27 +22,44 @@ import (
 
 // Built-in Auth Handler (username/password login + self-signed JWT)
 // BuiltinAuthHandler handles username/password authentication without an
-// external OIDC provider. It issues self-signed JWTs (HMAC-SHA256) that
-// are verified by the same Authenticate middleware.
+// external OIDC provider. It issues self-signed JWTs (HS256 by default, or
+// RS256/ES256 with public keys at /api/auth/jwks) that are verified by the
+// same Authenticate middleware.
 //
 // Signing keys are persisted in PostgreSQL (jwt_signing_keys table) so that:
 //   - Tokens survive server restarts
 //   - Multiple replicas share the same key
 //   - Key rotation is graceful (old keys remain valid during a grace period)
 type BuiltinAuthHandler struct {
-	store    store.Store
-	logger   *zap.SugaredLogger
-	tokenTTL time.Duration
+	cfg    config.BuiltinAuthConfig
+	store  store.Store
+	box    *secretbox.Box // seals TOTP secrets; nil disables TOTP enrollment
+	mailer UserMailer     // tells users their account was locked; may be nil
+	logger *zap.SugaredLogger
 }
 
 // NewBuiltinAuthHandler creates a handler for built-in authentication.
 // It ensures a signing key exists in the database and seeds the initial
 // admin user if configured.
-func NewBuiltinAuthHandler(cfg config.BuiltinAuthConfig, s store.Store, logger *zap.SugaredLogger) (*BuiltinAuthHandler, error) {
+func NewBuiltinAuthHandler(cfg config.BuiltinAuthConfig, s store.Store, box *secretbox.Box, mailer UserMailer, logger *zap.SugaredLogger) (*BuiltinAuthHandler, error) {
 	h := &BuiltinAuthHandler{
-		store:    s,
-		logger:   logger,
-		tokenTTL: 24 * time.Hour,
+		cfg:    cfg,
+		store:  s,
+		box:    box,
+		mailer: mailer,
+		logger: logger,
+	}
+	if h.cfg.SigningAlgorithm == "" {
+		h.cfg.SigningAlgorithm = "HS256"
+	}
+	if h.cfg.AccessTokenTTL <= 0 {
+		h.cfg.AccessTokenTTL = 24 * time.Hour
+	}
+	if h.cfg.KeyRotationGracePeriod < h.cfg.AccessTokenTTL {
+		h.cfg.KeyRotationGracePeriod = h.cfg.AccessTokenTTL
+	}
+	if h.cfg.RefreshTokenTTL <= 0 {
+		h.cfg.RefreshTokenTTL = 7 * 24 * time.Hour
 	}
 
 	// Ensure a signing key exists in the DB.
@@ -67,37 +85,33 @@ func (h *BuiltinAuthHandler) ensureSigningKey() error {
 		return err
 	}
 	if existing != nil {
-		h.logger.Infof("JWT signing key loaded from DB (kid=%s, created=%s)", existing.KID, existing.CreatedAt.Format(time.RFC3339))
+		if keyAlgorithm(existing) == h.cfg.SigningAlgorithm {
+			h.logger.Infof("JWT signing key loaded from DB (kid=%s, created=%s)", existing.KID, existing.CreatedAt.Format(time.RFC3339))
+			return nil
+		}
+		// signing_algorithm changed: switch over, keeping the old key
+		// valid for the grace period.
+		key, err := h.store.RotateSigningKey(nil, h.cfg.SigningAlgorithm, h.cfg.KeyRotationGracePeriod)
+		if err != nil {
+			return err
+		}
+		h.logger.Infof("JWT signing key rotated from %s to %s (kid=%s)", keyAlgorithm(existing), key.Algorithm, key.KID)
 		return nil
 	}
 
-	// No active key — create one with a random secret.
-	secret := make([]byte, 32)
-	if _, err := rand.Read(secret); err != nil {
-		return fmt.Errorf("generate random key: %w", err)
-	}
-	h.logger.Info("Creating initial JWT signing key (random)")
-
-	kid := generateKeyKID()
-	key := &store.JWTSigningKey{
-		KID:       kid,
-		Secret:    secret,
-		Status:    "active",
-		CreatedAt: time.Now(),
+	// No active key — create one with fresh random material.
+	key, err := store.NewSigningKey(h.cfg.SigningAlgorithm)
+	if err != nil {
+		return err
 	}
+	h.logger.Infof("Creating initial JWT signing key (%s)", key.Algorithm)
 	if err := h.store.CreateSigningKey(nil, key); err != nil {
 		return err
 	}
-	h.logger.Infof("JWT signing key persisted to DB (kid=%s)", kid)
+	h.logger.Infof("JWT signing key persisted to DB (kid=%s)", key.KID)
 	return nil
 }
 
-func generateKeyKID() string {
-	b := make([]byte, 8)
-	rand.Read(b)
-	return fmt.Sprintf("k-%x", b)
-}
-
 // seedInitialAdmin creates the initial admin user if it doesn't already exist.
 // New initial admin users are flagged with must_change_password = true.
 func (h *BuiltinAuthHandler) seedInitialAdmin(email, password string) error {
@@ -136,17 +150,20 @@ func (h *BuiltinAuthHandler) seedInitialAdmin(email, password string) error {
 }
 
 // Login handles POST /api/auth/login with email/password.
+// Users with TOTP enabled must also send totp_code; without it the response
+// is 401 with "totp_required": true so the UI can prompt for a code.
 func (h *BuiltinAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
+		TOTPCode string `json:"totp_code"`
 	}
 	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
-	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+	req.Email = normalizeEmail(req.Email)
 	if req.Email == "" || req.Password == "" {
 		ErrJSON(w, http.StatusBadRequest, "email and password are required")
 		return
@@ -154,24 +171,79 @@ func (h *BuiltinAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 
 	sub := "builtin:" + req.Email
 
+	// Refuse logins while the account is locked out.
+	if h.cfg.LockoutThreshold > 0 {
+		until, err := h.store.GetLoginLockout(r.Context(), req.Email)
+		if err != nil {
+			h.logger.Warnf("get login lockout for %s: %v", req.Email, err)
+		} else if remaining := time.Until(until); remaining > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(remaining.Seconds())+1))
+			ErrJSON(w, http.StatusTooManyRequests, "too many failed login attempts, try again later")
+			return
+		}
+	}
+
 	// Lookup user and verify password.
 	passwordHash, err := h.store.GetUserPasswordHash(r.Context(), sub)
 	if err != nil || passwordHash == "" {
+		h.recordLoginFailure(r.Context(), req.Email)
 		ErrJSON(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
 
 	if err := bcrypt.CompareHashAndPassword([]byte(passwordHash), []byte(req.Password)); err != nil {
+		h.recordLoginFailure(r.Context(), req.Email)
 		ErrJSON(w, http.StatusUnauthorized, "invalid email or password")
 		return
 	}
 
+	// Second factor, if enrolled.
+	sealedTOTP, totpEnabled, err := h.store.GetUserTOTP(r.Context(), sub)
+	if err != nil {
+		h.logger.Errorf("get user totp: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "user lookup failed")
+		return
+	}
+	if totpEnabled {
+		if req.TOTPCode == "" {
+			JSON(w, http.StatusUnauthorized, map[string]any{"error": "totp_code is required", "totp_required": true})
+			return
+		}
+		secret, err := h.box.Open(sealedTOTP)
+		if err != nil {
+			h.logger.Errorf("open totp secret for %s: %v", sub, err)
+			ErrJSON(w, http.StatusInternalServerError, "two-factor verification unavailable")
+			return
+		}
+		ok, err := h.acceptTOTP(r.Context(), sub, string(secret), req.TOTPCode)
+		if err != nil {
+			h.logger.Errorf("claim totp step for %s: %v", sub, err)
+			ErrJSON(w, http.StatusInternalServerError, "two-factor verification unavailable")
+			return
+		}
+		if !ok {
+			h.recordLoginFailure(r.Context(), req.Email)
+			ErrJSON(w, http.StatusUnauthorized, "invalid totp code")
+			return
+		}
+	}
+
+	if h.cfg.LockoutThreshold > 0 {
+		if err := h.store.ResetLoginFailures(r.Context(), req.Email); err != nil {
+			h.logger.Warnf("reset login failures for %s: %v", req.Email, err)
+		}
+	}
+
 	// Get user info.
 	user, err := h.store.GetUser(r.Context(), sub)
 	if err != nil || user == nil {
 		ErrJSON(w, http.StatusInternalServerError, "user lookup failed")
 		return
 	}
+	if !user.Enabled {
+		ErrJSON(w, http.StatusForbidden, "account disabled")
+		return
+	}
 
 	// Issue JWT.
 	accessToken, err := h.issueJWT(r.Context(), user)
@@ -181,11 +253,19 @@ func (h *BuiltinAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	refreshToken, err := h.issueRefreshToken(r.Context(), user.Sub, "")
+	if err != nil {
+		h.logger.Errorf("issue refresh token: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "token generation failed")
+		return
+	}
+
 	// Update last_seen.
 	_ = h.store.UpsertUser(r.Context(), user)
 
 	resp := map[string]any{
-		"access_token": accessToken,
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
 	}
 	if user.MustChangePassword {
 		resp["must_change_password"] = true
@@ -193,8 +273,151 @@ func (h *BuiltinAuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, resp)
 }
 
-// issueJWT creates an HMAC-SHA256 signed JWT for the given user.
-// The active signing key is fetched from the database.
+// Refresh handles POST /api/auth/refresh {refresh_token} for builtin auth.
+// Every refresh token is single-use: a successful refresh returns a new
+// access/refresh pair and invalidates the presented token. Presenting an
+// already-used token means it was copied, so the whole chain from that login
+// is revoked and the user must sign in again.
+func (h *BuiltinAuthHandler) Refresh(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.RefreshToken == "" {
+		ErrJSON(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+
+	tok, reused, err := h.store.ConsumeRefreshToken(r.Context(), hashRefreshToken(req.RefreshToken))
+	if err != nil {
+		h.logger.Errorf("consume refresh token: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "refresh failed")
+		return
+	}
+	if tok == nil {
+		ErrJSON(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+	if reused {
+		if err := h.store.RevokeRefreshTokenFamily(r.Context(), tok.FamilyID); err != nil {
+			h.logger.Errorf("revoke refresh token family %s: %v", tok.FamilyID, err)
+		}
+		h.logger.Warnf("refresh token reuse detected for %s from %s; revoked session family %s", tok.UserSub, ClientIP(r), tok.FamilyID)
+		_ = h.store.InsertAuditLog(r.Context(), "_global", "user", tok.UserSub, "refresh_token_reuse", "system")
+		ErrJSON(w, http.StatusUnauthorized, "refresh token reuse detected; please sign in again")
+		return
+	}
+	if tok.Revoked || time.Now().After(tok.ExpiresAt) {
+		ErrJSON(w, http.StatusUnauthorized, "refresh token expired or revoked")
+		return
+	}
+
+	user, err := h.store.GetUser(r.Context(), tok.UserSub)
+	if err != nil || user == nil {
+		ErrJSON(w, http.StatusUnauthorized, "invalid refresh token")
+		return
+	}
+	if !user.Enabled {
+		ErrJSON(w, http.StatusForbidden, "account disabled")
+		return
+	}
+
+	accessToken, err := h.issueJWT(r.Context(), user)
+	if err != nil {
+		h.logger.Errorf("issue JWT: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "token generation failed")
+		return
+	}
+	refreshToken, err := h.issueRefreshToken(r.Context(), user.Sub, tok.FamilyID)
+	if err != nil {
+		h.logger.Errorf("issue refresh token: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "token generation failed")
+		return
+	}
+
+	JSON(w, http.StatusOK, map[string]any{
+		"access_token":  accessToken,
+		"refresh_token": refreshToken,
+	})
+}
+
+// issueRefreshToken creates and stores a new refresh token for sub. An empty
+// familyID starts a new family (fresh login); otherwise the token continues
+// an existing rotation chain.
+func (h *BuiltinAuthHandler) issueRefreshToken(ctx context.Context, sub, familyID string) (string, error) {
+	token, err := randomURLToken(32)
+	if err != nil {
+		return "", err
+	}
+	if familyID == "" {
+		if familyID, err = randomURLToken(16); err != nil {
+			return "", err
+		}
+	}
+	now := time.Now()
+	if err := h.store.CreateRefreshToken(ctx, &store.RefreshToken{
+		TokenHash: hashRefreshToken(token),
+		FamilyID:  familyID,
+		UserSub:   sub,
+		CreatedAt: now,
+		ExpiresAt: now.Add(h.cfg.RefreshTokenTTL),
+	}); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// hashRefreshToken returns the storage key for a refresh token. Tokens are
+// high-entropy random values, so an unsalted SHA-256 is sufficient.
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeEmail folds a submitted email to the form used for builtin subs
+// and the login lockout key, so "Alice@x" and " alice@x" share one counter.
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// recordLoginFailure counts a failed login for email and locks the account
+// once LockoutThreshold failures accumulate within LockoutWindow. Unknown
+// emails are counted too, so lockout does not reveal which accounts exist.
+func (h *BuiltinAuthHandler) recordLoginFailure(ctx context.Context, email string) {
+	if h.cfg.LockoutThreshold <= 0 {
+		return
+	}
+	email = normalizeEmail(email)
+	failures, err := h.store.RecordLoginFailure(ctx, email, h.cfg.LockoutWindow)
+	if err != nil {
+		h.logger.Warnf("record login failure for %s: %v", email, err)
+		return
+	}
+	if failures < h.cfg.LockoutThreshold {
+		return
+	}
+	until := time.Now().Add(h.cfg.LockoutCooldown)
+	if err := h.store.LockLogin(ctx, email, until); err != nil {
+		h.logger.Warnf("lock login for %s: %v", email, err)
+		return
+	}
+	h.logger.Warnf("builtin login locked for %s after %d failed attempts (last from %s)", email, failures, clientIPFromContext(ctx))
+	_ = h.store.InsertAuditLog(ctx, "_global", "user", "builtin:"+email, "login_lockout", "system")
+
+	// Only mail real accounts: the address comes from an unauthenticated form.
+	if user, err := h.store.GetUser(ctx, "builtin:"+email); err == nil && user != nil {
+		mailUser(h.mailer, user.Email, "Hermes: your account was locked",
+			fmt.Sprintf("Your Hermes account was locked after %d failed sign-in attempts.\n\n"+
+				"You can try again after %s. If this wasn't you, contact an administrator.\n",
+				failures, until.UTC().Format(time.RFC1123)))
+	}
+}
+
+// issueJWT creates a signed JWT for the given user using the active
+// signing key (HS256, RS256 or ES256) from the database.
 func (h *BuiltinAuthHandler) issueJWT(ctx context.Context, user *store.User) (string, error) {
 	// Get the active signing key from DB.
 	key, err := h.store.GetActiveSigningKey(ctx)
@@ -206,7 +429,7 @@ func (h *BuiltinAuthHandler) issueJWT(ctx context.Context, user *store.User) (st
 	}
 
 	// Build JWT header with kid.
-	headerObj := map[string]string{"alg": "HS256", "typ": "JWT", "kid": key.KID}
+	headerObj := map[string]string{"alg": keyAlgorithm(key), "typ": "JWT", "kid": key.KID}
 	headerJSON, _ := json.Marshal(headerObj)
 	header := base64.RawURLEncoding.EncodeToString(headerJSON)
 
@@ -217,7 +440,7 @@ func (h *BuiltinAuthHandler) issueJWT(ctx context.Context, user *store.User) (st
 		"email":              user.Email,
 		"name":               user.Name,
 		"iat":                now.Unix(),
-		"exp":                now.Add(h.tokenTTL).Unix(),
+		"exp":                now.Add(h.cfg.AccessTokenTTL).Unix(),
 		"iss":                "hermes-builtin",
 		"aud":                "hermes",
 	}
@@ -228,14 +451,15 @@ func (h *BuiltinAuthHandler) issueJWT(ctx context.Context, user *store.User) (st
 	payload := base64.RawURLEncoding.EncodeToString(claimsJSON)
 
 	signingInput := header + "." + payload
-	mac := hmac.New(sha256.New, key.Secret)
-	mac.Write([]byte(signingInput))
-	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	sig, err := signJWS(key, signingInput)
+	if err != nil {
+		return "", fmt.Errorf("sign token: %w", err)
+	}
 
-	return signingInput + "." + signature, nil
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig), nil
 }
 
-// NewBuiltinVerifier creates an OIDCVerifyFunc that verifies self-signed HS256 JWTs.
+// NewBuiltinVerifier creates an OIDCVerifyFunc that verifies self-signed JWTs.
 // It looks up the signing key from the database by kid (or falls back to trying all
 // valid keys). This allows the existing Authenticate middleware to work seamlessly.
 func NewBuiltinVerifier(s store.Store) OIDCVerifyFunc {
@@ -287,14 +511,19 @@ func verifyBuiltinJWT(tokenStr string, s store.Store) (*OIDCClaims, error) {
 		return nil, fmt.Errorf("no valid signing keys found")
 	}
 
-	// Try each key until one verifies.
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("decode signature: %w", err)
+	}
+
+	// Try each key until one verifies. The header alg must match the key's
+	// algorithm so a token can't downgrade e.g. RS256 to HS256.
 	signingInput := parts[0] + "." + parts[1]
 	for _, key := range keys {
-		mac := hmac.New(sha256.New, key.Secret)
-		mac.Write([]byte(signingInput))
-		expectedSig := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
-
-		if hmac.Equal([]byte(parts[2]), []byte(expectedSig)) {
+		if header.Alg != keyAlgorithm(&key) {
+			continue
+		}
+		if verifyJWS(&key, signingInput, sig) {
 			// Signature valid — decode and validate claims.
 			claimsBytes, err := base64.RawURLEncoding.DecodeString(parts[1])
 			if err != nil {
@@ -322,20 +551,55 @@ func verifyBuiltinJWT(tokenStr string, s store.Store) (*OIDCClaims, error) {
 	return nil, fmt.Errorf("signature verification failed")
 }
 
+// RunKeyRotation rotates the signing key whenever the active key is older
+// than cfg.RotationInterval, until ctx is done. Every replica runs it; the
+// store serializes rotation so only one of them actually rotates.
+func (h *BuiltinAuthHandler) RunKeyRotation(ctx context.Context) {
+	interval := h.cfg.RotationInterval
+	if interval <= 0 {
+		return
+	}
+	check := min(interval/10, time.Minute)
+	ticker := time.NewTicker(check)
+	defer ticker.Stop()
+	for {
+		h.rotateKeyIfDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *BuiltinAuthHandler) rotateKeyIfDue(ctx context.Context) {
+	key, err := h.store.RotateSigningKeyIfOlder(ctx, h.cfg.SigningAlgorithm, h.cfg.RotationInterval, h.cfg.KeyRotationGracePeriod)
+	if err != nil {
+		h.logger.Warnf("scheduled signing key rotation: %v", err)
+		return
+	}
+	if key == nil {
+		return
+	}
+	h.logger.Infof("JWT signing key rotated on schedule: new kid=%s, old keys valid for %s", key.KID, h.cfg.KeyRotationGracePeriod)
+	_ = h.store.InsertAuditLog(ctx, "_global", "signing_key", key.KID, "rotate", "system")
+}
+
 // RotateKey creates a new signing key and retires the old one.
-// The old key stays valid for gracePeriod so in-flight tokens don't break.
+// The old key stays valid for the configured grace period so in-flight
+// tokens don't break.
 func (h *BuiltinAuthHandler) RotateKey(w http.ResponseWriter, r *http.Request) {
-	newKey, err := h.store.RotateSigningKey(r.Context(), h.tokenTTL)
+	newKey, err := h.store.RotateSigningKey(r.Context(), h.cfg.SigningAlgorithm, h.cfg.KeyRotationGracePeriod)
 	if err != nil {
 		h.logger.Errorf("rotate signing key: %v", err)
 		ErrJSON(w, http.StatusInternalServerError, "key rotation failed")
 		return
 	}
 
-	h.logger.Infof("JWT signing key rotated: new kid=%s, old keys valid for %s", newKey.KID, h.tokenTTL)
+	h.logger.Infof("JWT signing key rotated: new kid=%s, old keys valid for %s", newKey.KID, h.cfg.KeyRotationGracePeriod)
 	JSON(w, http.StatusOK, map[string]any{
 		"kid":          newKey.KID,
-		"grace_period": h.tokenTTL.String(),
+		"grace_period": h.cfg.KeyRotationGracePeriod.String(),
 	})
 }
 
@@ -349,6 +613,121 @@ func (h *BuiltinAuthHandler) Userinfo(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, claims)
 }
 
+// EnrollTOTP handles POST /api/auth/totp/enroll. It generates a fresh secret
+// for the caller and returns it with an otpauth:// URL (render as a QR code).
+// TOTP is not enforced until VerifyTOTP confirms the authenticator works.
+func (h *BuiltinAuthHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	id := IdentityFromContext(r.Context())
+	if id == nil {
+		ErrJSON(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	if !strings.HasPrefix(id.Subject, "builtin:") {
+		ErrJSON(w, http.StatusBadRequest, "TOTP is only available for builtin users")
+		return
+	}
+	if h.box == nil {
+		ErrJSON(w, http.StatusServiceUnavailable, "TOTP requires master_key to be configured")
+		return
+	}
+
+	_, enabled, err := h.store.GetUserTOTP(r.Context(), id.Subject)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if enabled {
+		ErrJSON(w, http.StatusConflict, "TOTP is already enabled; ask an admin to reset it")
+		return
+	}
+
+	key, err := generateTOTPKey(strings.TrimPrefix(id.Subject, "builtin:"))
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, "generate secret failed")
+		return
+	}
+	sealed, err := h.box.Seal([]byte(key.Secret()))
+	if err != nil {
+		h.logger.Errorf("seal totp secret: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "store secret failed")
+		return
+	}
+	if err := h.store.SetUserTOTP(r.Context(), id.Subject, sealed, false); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, map[string]any{
+		"secret":      key.Secret(),
+		"otpauth_url": key.URL(),
+	})
+}
+
+// VerifyTOTP handles POST /api/auth/totp/verify {code}. A valid code for the
+// pending secret enables TOTP; from then on Login requires totp_code.
+func (h *BuiltinAuthHandler) VerifyTOTP(w http.ResponseWriter, r *http.Request) {
+	id := IdentityFromContext(r.Context())
+	if id == nil {
+		ErrJSON(w, http.StatusUnauthorized, "authentication required")
+		return
+	}
+	var req struct {
+		Code string `json:"code"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	sealed, enabled, err := h.store.GetUserTOTP(r.Context(), id.Subject)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if enabled {
+		ErrJSON(w, http.StatusConflict, "TOTP is already enabled")
+		return
+	}
+	if sealed == "" {
+		ErrJSON(w, http.StatusBadRequest, "no pending TOTP enrollment")
+		return
+	}
+	secret, err := h.box.Open(sealed)
+	if err != nil {
+		h.logger.Errorf("open totp secret for %s: %v", id.Subject, err)
+		ErrJSON(w, http.StatusInternalServerError, "two-factor verification unavailable")
+		return
+	}
+	ok, err := h.acceptTOTP(r.Context(), id.Subject, string(secret), req.Code)
+	if err != nil {
+		h.logger.Errorf("claim totp step for %s: %v", id.Subject, err)
+		ErrJSON(w, http.StatusInternalServerError, "two-factor verification unavailable")
+		return
+	}
+	if !ok {
+		ErrJSON(w, http.StatusBadRequest, "invalid totp code")
+		return
+	}
+	if err := h.store.SetUserTOTP(r.Context(), id.Subject, sealed, true); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", id.Subject, "enable_totp", Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
+// acceptTOTP validates code against secret and claims its time step for
+// sub. A code that was already accepted, e.g. replayed from a captured
+// login, is refused even while it is still inside its window.
+func (h *BuiltinAuthHandler) acceptTOTP(ctx context.Context, sub, secret, code string) (bool, error) {
+	step, ok := validateTOTP(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+	return h.store.ClaimTOTPStep(ctx, sub, step)
+}
+
 // ChangePassword allows an authenticated user to change their password.
 func (h *BuiltinAuthHandler) ChangePassword(w http.ResponseWriter, r *http.Request) {
 	id := IdentityFromContext(r.Context())
@@ -369,8 +748,8 @@ func (h *BuiltinAuthHandler) ChangePassword(w http.ResponseWriter, r *http.Reque
 		ErrJSON(w, http.StatusBadRequest, "new_password is required")
 		return
 	}
-	if len(req.NewPassword) < 6 {
-		ErrJSON(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if reason := ValidatePassword(req.NewPassword, h.cfg.PasswordPolicy); reason != "" {
+		ErrJSON(w, http.StatusBadRequest, reason)
 		return
 	}
 
@@ -384,6 +763,14 @@ func (h *BuiltinAuthHandler) ChangePassword(w http.ResponseWriter, r *http.Reque
 		ErrJSON(w, http.StatusUnauthorized, "incorrect current password")
 		return
 	}
+	if reused, err := passwordReused(r.Context(), h.store, id.Subject, req.NewPassword, h.cfg.PasswordPolicy); err != nil {
+		h.logger.Errorf("check password history: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "password history lookup failed")
+		return
+	} else if reused {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("password was used recently; choose one not among the last %d", h.cfg.PasswordPolicy.HistorySize))
+		return
+	}
 
 	// Hash new password and update.
 	newHash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
@@ -395,6 +782,7 @@ func (h *BuiltinAuthHandler) ChangePassword(w http.ResponseWriter, r *http.Reque
 		ErrJSON(w, http.StatusInternalServerError, "update password failed")
 		return
 	}
+	recordPasswordHistory(r.Context(), h.store, h.logger, id.Subject, string(newHash), h.cfg.PasswordPolicy)
 
 	// Clear the must_change_password flag after a successful password change.
 	_ = h.store.SetMustChangePassword(r.Context(), id.Subject, false)
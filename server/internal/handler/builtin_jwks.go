@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// Builtin token signing. HS256 keys are shared secrets; RS256/ES256 keys are
+// private keys whose public halves are published at /api/auth/jwks so other
+// services can verify Hermes tokens without the secret.
+
+// keyAlgorithm returns the JWS algorithm of a stored key. Keys created before
+// the algorithm column existed are HMAC secrets.
+func keyAlgorithm(key *store.JWTSigningKey) string {
+	if key.Algorithm == "" {
+		return "HS256"
+	}
+	return key.Algorithm
+}
+
+// signJWS signs signingInput with key and returns the raw signature.
+func signJWS(key *store.JWTSigningKey, signingInput string) ([]byte, error) {
+	hash := sha256.Sum256([]byte(signingInput))
+	switch keyAlgorithm(key) {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(signingInput))
+		return mac.Sum(nil), nil
+	case "RS256":
+		priv, err := parsePrivateKey[*rsa.PrivateKey](key)
+		if err != nil {
+			return nil, err
+		}
+		return rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hash[:])
+	case "ES256":
+		priv, err := parsePrivateKey[*ecdsa.PrivateKey](key)
+		if err != nil {
+			return nil, err
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, priv, hash[:])
+		if err != nil {
+			return nil, err
+		}
+		// JWS uses the fixed-width r||s encoding, not ASN.1.
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", key.Algorithm)
+}
+
+// verifyJWS reports whether sig is a valid signature of signingInput by key.
+func verifyJWS(key *store.JWTSigningKey, signingInput string, sig []byte) bool {
+	hash := sha256.Sum256([]byte(signingInput))
+	switch keyAlgorithm(key) {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.Secret)
+		mac.Write([]byte(signingInput))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case "RS256":
+		priv, err := parsePrivateKey[*rsa.PrivateKey](key)
+		if err != nil {
+			return false
+		}
+		return rsa.VerifyPKCS1v15(&priv.PublicKey, crypto.SHA256, hash[:], sig) == nil
+	case "ES256":
+		priv, err := parsePrivateKey[*ecdsa.PrivateKey](key)
+		if err != nil || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(&priv.PublicKey, hash[:], r, s)
+	}
+	return false
+}
+
+func parsePrivateKey[T any](key *store.JWTSigningKey) (T, error) {
+	var zero T
+	parsed, err := x509.ParsePKCS8PrivateKey(key.Secret)
+	if err != nil {
+		return zero, fmt.Errorf("parse signing key %s: %w", key.KID, err)
+	}
+	priv, ok := parsed.(T)
+	if !ok {
+		return zero, fmt.Errorf("signing key %s is not a %s key", key.KID, keyAlgorithm(key))
+	}
+	return priv, nil
+}
+
+// publicJWK returns the RFC 7517 public JWK for an asymmetric key, or false
+// for HMAC keys (which must never be published).
+func publicJWK(key *store.JWTSigningKey) (map[string]string, bool) {
+	b64 := base64.RawURLEncoding.EncodeToString
+	switch keyAlgorithm(key) {
+	case "RS256":
+		priv, err := parsePrivateKey[*rsa.PrivateKey](key)
+		if err != nil {
+			return nil, false
+		}
+		return map[string]string{
+			"kty": "RSA", "use": "sig", "alg": "RS256", "kid": key.KID,
+			"n": b64(priv.N.Bytes()),
+			"e": b64(big.NewInt(int64(priv.E)).Bytes()),
+		}, true
+	case "ES256":
+		priv, err := parsePrivateKey[*ecdsa.PrivateKey](key)
+		if err != nil {
+			return nil, false
+		}
+		x, y := make([]byte, 32), make([]byte, 32)
+		priv.X.FillBytes(x)
+		priv.Y.FillBytes(y)
+		return map[string]string{
+			"kty": "EC", "use": "sig", "alg": "ES256", "kid": key.KID,
+			"crv": "P-256", "x": b64(x), "y": b64(y),
+		}, true
+	}
+	return nil, false
+}
+
+// JWKS publishes the public keys of all currently valid asymmetric signing
+// keys (active and retired-but-unexpired), keyed by kid.
+func (h *BuiltinAuthHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.store.ListValidSigningKeys(r.Context())
+	if err != nil {
+		h.logger.Errorf("list signing keys: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "failed to list signing keys")
+		return
+	}
+	jwks := make([]map[string]string, 0, len(keys))
+	for i := range keys {
+		if jwk, ok := publicJWK(&keys[i]); ok {
+			jwks = append(jwks, jwk)
+		}
+	}
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	JSON(w, http.StatusOK, map[string]any{"keys": jwks})
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// maxBulkDelete caps how many resources one bulk delete may remove.
+const maxBulkDelete = 500
+
+// bulkDeleteRequest selects resources either by name or by label.
+type bulkDeleteRequest struct {
+	Names  []string          `json:"names"`
+	Labels map[string]string `json:"labels"`
+}
+
+// bulkDelete serves POST /api/v1/{domains,clusters}/delete. It requires
+// ?confirm=true, resolves the selection, deletes everything in one store
+// transaction and reports the outcome per name. list resolves a label
+// selector to names; del performs the delete.
+func bulkDelete(w http.ResponseWriter, r *http.Request, kind string,
+	list func(ctx context.Context, region string, selector map[string]string) ([]string, error),
+	del func(ctx context.Context, region string, names []string, operator string) ([]store.BulkDeleteResult, error),
+) (string, []store.BulkDeleteResult, bool) {
+	region := RegionFromContext(r.Context())
+	if r.URL.Query().Get("confirm") != "true" {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("bulk deleting %ss requires ?confirm=true", kind))
+		return region, nil, false
+	}
+	var req bulkDeleteRequest
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return region, nil, false
+	}
+	if (len(req.Names) > 0) == (len(req.Labels) > 0) {
+		ErrJSON(w, http.StatusBadRequest, "exactly one of names or labels is required")
+		return region, nil, false
+	}
+
+	names := req.Names
+	if len(req.Labels) > 0 {
+		var err error
+		if names, err = list(r.Context(), region, req.Labels); err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return region, nil, false
+		}
+	}
+	for _, name := range names {
+		if name == "" {
+			ErrJSON(w, http.StatusBadRequest, "names must not be empty")
+			return region, nil, false
+		}
+	}
+	if len(names) > maxBulkDelete {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("at most %d %ss can be deleted at once, got %d", maxBulkDelete, kind, len(names)))
+		return region, nil, false
+	}
+
+	results := []store.BulkDeleteResult{}
+	if len(names) > 0 {
+		var err error
+		if results, err = del(r.Context(), region, names, Operator(r)); err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return region, nil, false
+		}
+	}
+	deleted := 0
+	for _, res := range results {
+		if res.Deleted {
+			deleted++
+		}
+	}
+	JSON(w, http.StatusOK, map[string]any{"results": results, "deleted": deleted, "total": len(results)})
+	return region, results, true
+}
+
+// BulkDeleteDomains deletes the domains named in the body, or those
+// matching its label selector, in one transaction.
+func (h *DomainHandler) BulkDeleteDomains(w http.ResponseWriter, r *http.Request) {
+	list := func(ctx context.Context, region string, selector map[string]string) ([]string, error) {
+		domains, err := h.store.ListDomainsByLabels(ctx, region, selector)
+		names := make([]string, len(domains))
+		for i, d := range domains {
+			names[i] = d.Name
+		}
+		return names, err
+	}
+	region, results, ok := bulkDelete(w, r, "domain", list, h.store.DeleteDomains)
+	if ok {
+		h.logger.Infof("domains bulk deleted (ns=%s): %d requested by %s", region, len(results), Operator(r))
+	}
+}
+
+// BulkDeleteClusters deletes the clusters named in the body, or those
+// matching its label selector, in one transaction.
+func (h *ClusterHandler) BulkDeleteClusters(w http.ResponseWriter, r *http.Request) {
+	list := func(ctx context.Context, region string, selector map[string]string) ([]string, error) {
+		clusters, err := h.store.ListClustersByLabels(ctx, region, selector)
+		names := make([]string, len(clusters))
+		for i, c := range clusters {
+			names[i] = c.Name
+		}
+		return names, err
+	}
+	region, results, ok := bulkDelete(w, r, "cluster", list, h.store.DeleteClusters)
+	if ok {
+		h.logger.Infof("clusters bulk deleted (ns=%s): %d requested by %s", region, len(results), Operator(r))
+	}
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// Config channels.
+const (
+	ChannelStable = "stable"
+	ChannelCanary = "canary"
+)
+
+// canaryChannel assigns an instance to a channel. The hash is stable, so an
+// instance stays on its channel for the life of the canary, and raising the
+// percentage only moves stable instances over, never back.
+func canaryChannel(instanceID string, percent int) string {
+	h := fnv.New32a()
+	h.Write([]byte(instanceID))
+	if int(h.Sum32()%100) < percent {
+		return ChannelCanary
+	}
+	return ChannelStable
+}
+
+// resolveChannel picks the config channel for a GetConfig request:
+// ?channel= wins, otherwise ?instance= is hashed against the canary
+// percentage. Without a running canary everything is stable.
+func resolveChannel(r *http.Request, canary *store.ConfigCanary) (string, error) {
+	q := r.URL.Query()
+	channel := q.Get("channel")
+	switch channel {
+	case "", ChannelStable, ChannelCanary:
+	default:
+		return "", fmt.Errorf("channel must be stable or canary")
+	}
+	if canary == nil {
+		return ChannelStable, nil
+	}
+	if channel == "" {
+		if id := q.Get("instance"); id != "" {
+			return canaryChannel(id, canary.Percent), nil
+		}
+		return ChannelStable, nil
+	}
+	return channel, nil
+}
+
+// channelConfig returns the config served on channel: the canary's for
+// ChannelCanary, the region's stored config otherwise.
+func channelConfig(ctx context.Context, s store.Store, region, channel string, canary *store.ConfigCanary) (*model.GatewayConfig, error) {
+	if channel == ChannelCanary {
+		return &canary.Config, nil
+	}
+	return s.GetConfig(ctx, region)
+}
+
+// GetCanary returns the region's running canary and which gateway instances
+// it currently covers.
+func (h *RouteHandler) GetCanary(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	canary, err := h.store.GetConfigCanary(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if canary == nil {
+		ErrJSON(w, http.StatusNotFound, "no canary running")
+		return
+	}
+	instances, err := h.store.ListGatewayInstances(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	channels := map[string][]string{ChannelStable: {}, ChannelCanary: {}}
+	for _, inst := range instances {
+		ch := canaryChannel(inst.ID, canary.Percent)
+		channels[ch] = append(channels[ch], inst.ID)
+	}
+	JSON(w, http.StatusOK, map[string]any{"canary": canary, "instances": channels})
+}
+
+// PutCanary starts a canary of a full config, or replaces the running one
+// (e.g. to raise its percentage).
+func (h *RouteHandler) PutCanary(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	var req struct {
+		Percent int                 `json:"percent"`
+		Config  model.GatewayConfig `json:"config"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if req.Percent < 1 || req.Percent > 100 {
+		ErrJSON(w, http.StatusBadRequest, "percent must be between 1 and 100")
+		return
+	}
+	model.NormalizeConfigHosts(&req.Config)
+	if errs := model.ValidateConfig(&req.Config); len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+		return
+	}
+	if _, ok := h.rules.checkConfig(w, region, &req.Config); !ok {
+		return
+	}
+	if rejectMissingSecrets(w, r, h.store, region, req.Config.Domains...) {
+		return
+	}
+
+	// Promotion only goes ahead if the stable config is still at this
+	// revision; replacing the canary re-bases it.
+	base, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	canary := store.ConfigCanary{Region: region, Percent: req.Percent, Config: req.Config, BaseRevision: base, CreatedBy: Operator(r)}
+	if err := h.store.PutConfigCanary(r.Context(), region, &canary); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "canary", region, "start", Operator(r))
+
+	h.logger.Infof("canary started (ns=%s, percent=%d) by %s", region, req.Percent, Operator(r))
+	JSON(w, http.StatusOK, canary)
+}
+
+// AbortCanary ends the canary; canary gateways return to the stable config
+// on their next fetch.
+func (h *RouteHandler) AbortCanary(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	found, err := h.store.DeleteConfigCanary(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		ErrJSON(w, http.StatusNotFound, "no canary running")
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "canary", region, "abort", Operator(r))
+
+	h.logger.Infof("canary aborted (ns=%s) by %s", region, Operator(r))
+	JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// PromoteCanary makes the canary config the stable config, as a full
+// import, and ends the canary. It answers 409 if the stable config changed
+// after the canary was started, since promoting would undo those writes.
+func (h *RouteHandler) PromoteCanary(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	canary, err := h.store.GetConfigCanary(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if canary == nil {
+		ErrJSON(w, http.StatusNotFound, "no canary running")
+		return
+	}
+	cfg := canary.Config
+	// The rules may have been reloaded while the canary ran.
+	if _, ok := h.rules.checkConfig(w, region, &cfg); !ok {
+		return
+	}
+	// A secret may have been deleted while the canary ran.
+	if rejectMissingSecrets(w, r, h.store, region, cfg.Domains...) {
+		return
+	}
+	if h.quotas.rejectReplace(w, r, region, "domain", len(cfg.Domains)) ||
+		h.quotas.rejectReplace(w, r, region, "cluster", len(cfg.Clusters)) {
+		return
+	}
+
+	rev, err := h.store.PromoteConfigCanary(r.Context(), region, canary, Operator(r))
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, "conflict: the stable config or the canary changed since the canary was started, please restart the canary")
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "canary", region, "promote", Operator(r))
+
+	h.logger.Infof("canary promoted (ns=%s, revision=%d) by %s", region, rev, Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"resource_version": rev, "domains": len(cfg.Domains), "clusters": len(cfg.Clusters)})
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// maxChangeReasonLen bounds X-Hermes-Change-Reason; a ticket link and a
+// sentence fit comfortably.
+const maxChangeReasonLen = 512
+
+// ChangeReason records the X-Hermes-Change-Reason header on the request
+// context, so every history and change_log row the request writes carries
+// it. Over-long reasons are rejected with 400.
+func ChangeReason(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reason := strings.TrimSpace(r.Header.Get("X-Hermes-Change-Reason"))
+		if reason == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(reason) > maxChangeReasonLen {
+			ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("X-Hermes-Change-Reason exceeds %d bytes", maxChangeReasonLen))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(store.WithChangeReason(r.Context(), reason)))
+	})
+}
+
+// ChangeReasonGuard rejects config writes without a change reason with 400
+// when the caller's region sets require_change_reason. Must be applied after
+// Authenticate + RegionMiddleware, and inside ChangeReason.
+func ChangeReasonGuard(s store.Store, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if store.ChangeReasonFromContext(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			region := RegionFromContext(r.Context())
+			settings, err := s.GetRegionSettings(r.Context(), region)
+			if err != nil {
+				// Fail closed, as for the change freeze.
+				logger.Errorw("read region settings failed", "region", region, "error", err)
+				ErrJSON(w, http.StatusServiceUnavailable, "region settings unavailable, try again later")
+				return
+			}
+			if settings != nil && settings.RequireChangeReason {
+				ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("region %q requires an X-Hermes-Change-Reason header on config changes", region))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -3,9 +3,12 @@ package handler
 import (
 	"errors"
 	"fmt"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/model"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
@@ -15,26 +18,226 @@ import (
 type ClusterHandler struct {
 	store  store.Store
 	logger *zap.SugaredLogger
+	quotas quotas
+	rules  ruleSet
 }
 
-func NewClusterHandler(s store.Store, logger *zap.SugaredLogger) *ClusterHandler {
-	return &ClusterHandler{store: s, logger: logger}
+func NewClusterHandler(s store.Store, logger *zap.SugaredLogger, quota *config.Dynamic[config.QuotaConfig], rules *config.Dynamic[[]model.Rule]) *ClusterHandler {
+	return &ClusterHandler{store: s, logger: logger, quotas: quotas{store: s, defaults: quota}, rules: ruleSet{rules: rules}}
 }
 
+// ListClusters returns the region's clusters, narrowed to those carrying
+// every ?label=key=value given, a page at a time with ?limit=.
 func (h *ClusterHandler) ListClusters(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
+	selector, err := parseLabelSelector(r)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parsePage(r, 0)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	clusters, meta, err := h.store.ListClustersWithMeta(r.Context(), region, selector)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	items := make([]clusterListItem, 0, len(clusters))
+	for i, c := range clusters {
+		m := meta[i]
+		items = append(items, clusterListItem{c, m.ResourceVersion, m.UpdatedAt, m.LastOperator})
+	}
+	total := len(items)
+	setPageHeaders(w, r, p, total)
+	JSON(w, http.StatusOK, map[string]any{"clusters": paginate(items, p), "total": total})
+}
+
+// clusterListItem is a cluster in list responses, as domainListItem.
+type clusterListItem struct {
+	model.ClusterConfig
+	ResourceVersion int64     `json:"resource_version"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	LastOperator    string    `json:"last_operator"`
+}
+
+// referencedClusters returns the names of clusters that domains' routes
+// point to.
+func referencedClusters(domains []model.DomainConfig) map[string]bool {
+	referenced := make(map[string]bool)
+	for _, d := range domains {
+		for _, route := range d.Routes {
+			for _, wc := range route.Clusters {
+				referenced[wc.Name] = true
+			}
+		}
+	}
+	return referenced
+}
+
+// orphanClusters returns the region's clusters that no domain route
+// references.
+func (h *ClusterHandler) orphanClusters(r *http.Request, region string) ([]model.ClusterConfig, error) {
+	domains, err := h.store.ListDomains(r.Context(), region)
+	if err != nil {
+		return nil, err
+	}
 	clusters, err := h.store.ListClusters(r.Context(), region)
+	if err != nil {
+		return nil, err
+	}
+
+	referenced := referencedClusters(domains)
+	orphans := []model.ClusterConfig{}
+	for _, c := range clusters {
+		if !referenced[c.Name] {
+			orphans = append(orphans, c)
+		}
+	}
+	return orphans, nil
+}
+
+// clusterReference is one route that sends traffic to a cluster.
+type clusterReference struct {
+	Domain  string `json:"domain"`
+	Route   string `json:"route"`
+	URI     string `json:"uri"`
+	Enabled bool   `json:"enabled"`
+	// Weight is the cluster's weight on the route; Percent is its share of
+	// the route's total weight.
+	Weight  int     `json:"weight"`
+	Percent float64 `json:"percent"`
+}
+
+// clusterReferences lists the routes in domains that point at cluster, and
+// the cluster's share of the combined weight of the enabled ones.
+func clusterReferences(domains []model.DomainConfig, cluster string) ([]clusterReference, float64) {
+	refs := []clusterReference{}
+	var weight, total int
+	for _, d := range domains {
+		for _, route := range d.Routes {
+			routeTotal, own := 0, 0
+			found := false
+			for _, wc := range route.Clusters {
+				routeTotal += wc.Weight
+				if wc.Name == cluster {
+					own += wc.Weight
+					found = true
+				}
+			}
+			if !found {
+				continue
+			}
+			ref := clusterReference{
+				Domain:  d.Name,
+				Route:   route.Name,
+				URI:     route.URI,
+				Enabled: route.Status == model.RouteStatusEnabled,
+				Weight:  own,
+			}
+			if routeTotal > 0 {
+				ref.Percent = roundPercent(float64(own) / float64(routeTotal))
+			}
+			if ref.Enabled {
+				weight += own
+				total += routeTotal
+			}
+			refs = append(refs, ref)
+		}
+	}
+	if total == 0 {
+		return refs, 0
+	}
+	return refs, roundPercent(float64(weight) / float64(total))
+}
+
+// roundPercent turns a fraction into a percentage with two decimals.
+func roundPercent(f float64) float64 {
+	return math.Round(f*10000) / 100
+}
+
+// ListClusterReferences returns every route that sends traffic to the
+// cluster, with its weight, plus traffic_percent: the cluster's share of the
+// combined weight of those routes, counting enabled routes only. Routes are
+// treated as equally busy. The cluster need not exist, so dangling
+// references can be found too.
+func (h *ClusterHandler) ListClusterReferences(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	cluster, _, err := h.store.GetCluster(r.Context(), region, name)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	JSON(w, http.StatusOK, map[string]any{"clusters": clusters, "total": len(clusters)})
+	domains, err := h.store.FindDomainsByCluster(r.Context(), region, name)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if cluster == nil && len(domains) == 0 {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("cluster %q not found", name))
+		return
+	}
+
+	refs, percent := clusterReferences(domains, name)
+	domainNames := make([]string, len(domains))
+	for i, d := range domains {
+		domainNames[i] = d.Name
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"cluster":         name,
+		"exists":          cluster != nil,
+		"domains":         domainNames,
+		"references":      refs,
+		"total":           len(refs),
+		"traffic_percent": percent,
+	})
+}
+
+// ListOrphanClusters returns clusters no domain route points to. Clusters
+// reached only through a route's cluster_override_header are reported too.
+func (h *ClusterHandler) ListOrphanClusters(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	orphans, err := h.orphanClusters(r, region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, map[string]any{"clusters": orphans, "total": len(orphans)})
+}
+
+// DeleteOrphanClusters deletes every orphan cluster. It requires
+// ?confirm=true. Orphans are recomputed by the store in the delete's own
+// transaction rather than taken from the client or an earlier listing, so a
+// cluster that gained a reference meanwhile is kept.
+func (h *ClusterHandler) DeleteOrphanClusters(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	if r.URL.Query().Get("confirm") != "true" {
+		ErrJSON(w, http.StatusBadRequest, "deleting orphan clusters requires ?confirm=true")
+		return
+	}
+
+	deleted, err := h.store.DeleteOrphanClusters(r.Context(), region, Operator(r))
+	if err != nil {
+		h.logger.Errorf("delete orphan clusters (ns=%s): %v", region, err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if deleted == nil {
+		deleted = []string{}
+	}
+
+	h.logger.Infof("orphan clusters deleted (ns=%s): %v", region, deleted)
+	JSON(w, http.StatusOK, map[string]any{"deleted": deleted, "total": len(deleted)})
 }
 
 func (h *ClusterHandler) GetCluster(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 	name := r.PathValue("name")
-	cluster, rv, err := h.store.GetCluster(r.Context(), region, name)
+	cluster, meta, err := h.store.GetClusterWithMeta(r.Context(), region, name)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
@@ -43,7 +246,13 @@ func (h *ClusterHandler) GetCluster(w http.ResponseWriter, r *http.Request) {
 		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("cluster %q not found", name))
 		return
 	}
-	JSON(w, http.StatusOK, map[string]any{"cluster": cluster, "resource_version": rv})
+	if notModified(w, r, resourceETag(meta), meta.UpdatedAt) {
+		return
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"cluster": cluster, "resource_version": meta.ResourceVersion,
+		"updated_at": meta.UpdatedAt, "last_operator": meta.LastOperator,
+	})
 }
 
 func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
@@ -63,6 +272,13 @@ func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
 		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
 		return
 	}
+	ruleWarns, ok := h.rules.checkCluster(w, region, &cluster)
+	if !ok {
+		return
+	}
+	if h.quotas.rejectCreate(w, r, region, "cluster") {
+		return
+	}
 
 	ver, err := h.store.PutCluster(r.Context(), region, &cluster, "create", Operator(r), 0)
 	if err != nil {
@@ -75,24 +291,29 @@ func (h *ClusterHandler) CreateCluster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.logger.Infof("cluster created: %s (ns=%s), version=%d", cluster.Name, region, ver)
-	JSON(w, http.StatusCreated, map[string]any{"version": ver, "cluster": cluster, "resource_version": int64(1)})
+	JSON(w, http.StatusCreated, map[string]any{"version": ver, "cluster": cluster, "resource_version": int64(1), "warnings": warnings(append(model.WarnCluster(&cluster), ruleWarns...))})
 }
 
+// UpdateCluster replaces a cluster. The expected resource version is taken
+// from the If-Match header or the body's resource_version.
 func (h *ClusterHandler) UpdateCluster(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 	name := r.PathValue("name")
 
 	var body struct {
 		model.ClusterConfig
-		ResourceVersion int64 `json:"resource_version"`
+		ResourceVersion *int64 `json:"resource_version"`
 	}
 	if err := DecodeJSON(r, &body); err != nil {
 		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
 		return
 	}
 
-	if body.ResourceVersion <= 0 {
-		ErrJSON(w, http.StatusBadRequest, "resource_version is required for update (must be > 0)")
+	expected, fromHeader, ok := updatePrecondition(w, r, "cluster", body.ResourceVersion, func() (*store.ResourceMeta, error) {
+		_, meta, err := h.store.GetClusterWithMeta(r.Context(), region, name)
+		return meta, err
+	})
+	if !ok {
 		return
 	}
 
@@ -102,11 +323,16 @@ func (h *ClusterHandler) UpdateCluster(w http.ResponseWriter, r *http.Request) {
 		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
 		return
 	}
+	ruleWarns, ok := h.rules.checkCluster(w, region, &body.ClusterConfig)
+	if !ok {
+		return
+	}
 
-	ver, err := h.store.PutCluster(r.Context(), region, &body.ClusterConfig, "update", Operator(r), body.ResourceVersion)
+	ctx, noop := skipNoopContext(r)
+	ver, err := h.store.PutCluster(ctx, region, &body.ClusterConfig, "update", Operator(r), expected)
 	if err != nil {
 		if errors.Is(err, store.ErrConflict) {
-			ErrJSON(w, http.StatusConflict, "conflict: the cluster has been modified by another user, please refresh and try again")
+			updateConflict(w, "cluster", fromHeader)
 			return
 		}
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -114,7 +340,7 @@ func (h *ClusterHandler) UpdateCluster(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.logger.Infof("cluster updated: %s (ns=%s), version=%d", name, region, ver)
-	JSON(w, http.StatusOK, map[string]any{"version": ver, "cluster": body.ClusterConfig, "resource_version": body.ResourceVersion + 1})
+	JSON(w, http.StatusOK, map[string]any{"version": ver, "cluster": body.ClusterConfig, "resource_version": updatedVersion(expected, noop), "unchanged": noop.Skipped, "warnings": warnings(append(model.WarnCluster(&body.ClusterConfig), ruleWarns...))})
 }
 
 func (h *ClusterHandler) DeleteCluster(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// resourceETag is the strong ETag of a stored domain or cluster. The
+// resource version alone repeats when a resource is deleted and re-created,
+// so the modification time is folded in.
+func resourceETag(meta *store.ResourceMeta) string {
+	return fmt.Sprintf(`"%d-%x"`, meta.ResourceVersion, meta.UpdatedAt.UnixMicro())
+}
+
+// notModified sets ETag and Last-Modified and, if the request's
+// preconditions show the client already has this representation, writes 304
+// and returns true. If-None-Match takes precedence over If-Modified-Since
+// (RFC 9110 §13.2.2).
+func notModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		if !etagMatches(inm, etag) {
+			return false
+		}
+	} else if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		// Last-Modified has second precision.
+		if err != nil || modified.Truncate(time.Second).After(since) {
+			return false
+		}
+	} else {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// etagMatches reports whether an If-None-Match list matches etag, using weak
+// comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// updatePrecondition resolves the resource version an update expects, from
+// the If-Match header or else the body's resource_version. If-Match takes a
+// single strong ETag: either "<version>" or the ETag of a previous GET. A
+// full ETag is compared against the stored resource, loaded through meta.
+// On failure the response is written and ok is false: 428 if neither is
+// given, 412 if the ETag no longer matches, 400 if the body's version is not
+// positive or the header is malformed or disagrees with the body. fromHeader
+// reports whether If-Match was used, so that a later version conflict is
+// answered with 412 as well.
+func updatePrecondition(w http.ResponseWriter, r *http.Request, kind string, bodyVersion *int64,
+	meta func() (*store.ResourceMeta, error)) (expected int64, fromHeader, ok bool) {
+	if bodyVersion != nil && *bodyVersion <= 0 {
+		ErrJSON(w, http.StatusBadRequest, "resource_version must be > 0")
+		return 0, false, false
+	}
+	header := strings.TrimSpace(r.Header.Get("If-Match"))
+	if header == "" {
+		if bodyVersion == nil {
+			ErrJSON(w, http.StatusPreconditionRequired, "resource_version or an If-Match header is required for update")
+			return 0, false, false
+		}
+		return *bodyVersion, false, true
+	}
+
+	if len(header) < 2 || header[0] != '"' || header[len(header)-1] != '"' {
+		ErrJSON(w, http.StatusBadRequest, `If-Match must be a single strong ETag, e.g. "3"`)
+		return 0, true, false
+	}
+	version, _, full := strings.Cut(header[1:len(header)-1], "-")
+	expected, err := strconv.ParseInt(version, 10, 64)
+	if err != nil || expected <= 0 {
+		ErrJSON(w, http.StatusBadRequest, `If-Match must be a single strong ETag, e.g. "3"`)
+		return 0, true, false
+	}
+	if bodyVersion != nil && *bodyVersion != expected {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("resource_version %d does not match If-Match %s", *bodyVersion, header))
+		return 0, true, false
+	}
+	if full {
+		m, err := meta()
+		if err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return 0, true, false
+		}
+		if m == nil || resourceETag(m) != header {
+			preconditionFailed(w, kind)
+			return 0, true, false
+		}
+	}
+	return expected, true, true
+}
+
+// updateConflict answers an update the store rejected as stale: 412 when the
+// expected version came from If-Match, 409 otherwise.
+func updateConflict(w http.ResponseWriter, kind string, fromHeader bool) {
+	if fromHeader {
+		preconditionFailed(w, kind)
+		return
+	}
+	ErrJSON(w, http.StatusConflict, fmt.Sprintf("conflict: the %s has been modified by another user, please refresh and try again", kind))
+}
+
+func preconditionFailed(w http.ResponseWriter, kind string) {
+	ErrJSON(w, http.StatusPreconditionFailed, fmt.Sprintf("precondition failed: the %s has been modified by another user, please refresh and try again", kind))
+}
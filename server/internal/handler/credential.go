@@ -1,29 +1,101 @@
 package handler
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
 	"strconv"
+	"strings"
 
+	"github.com/jizhuozhi/hermes/server/internal/notify"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
 	"go.uber.org/zap"
 )
 
+// CredentialNotifier is told about credential lifecycle changes. It must
+// not block: delivery happens in the background.
+type CredentialNotifier interface {
+	Credential(ev notify.CredentialEvent)
+}
+
 type CredentialHandler struct {
-	store  store.Store
-	logger *zap.SugaredLogger
+	store    store.Store
+	box      *secretbox.Box // seals secret keys at rest; nil stores them as is
+	notifier CredentialNotifier
+	logger   *zap.SugaredLogger
+}
+
+// NewCredentialHandler returns a credential handler. box and notifier may be
+// nil.
+func NewCredentialHandler(s store.Store, box *secretbox.Box, notifier CredentialNotifier, logger *zap.SugaredLogger) *CredentialHandler {
+	return &CredentialHandler{store: s, box: box, notifier: notifier, logger: logger}
+}
+
+// create stores cred with its secret key sealed, when a master key is
+// configured, and returns it with the plaintext key for the one response
+// that carries it.
+func (h *CredentialHandler) create(ctx context.Context, region string, cred *store.APICredential) (*store.APICredential, error) {
+	stored := *cred
+	if h.box != nil {
+		sealed, err := h.box.Seal([]byte(cred.SecretKey))
+		if err != nil {
+			return nil, fmt.Errorf("seal secret key: %w", err)
+		}
+		stored.SecretKey = sealed
+	}
+	result, err := h.store.CreateAPICredential(ctx, region, &stored)
+	if err != nil {
+		return nil, err
+	}
+	out := *result
+	out.SecretKey = cred.SecretKey
+	return &out, nil
 }
 
-func NewCredentialHandler(s store.Store, logger *zap.SugaredLogger) *CredentialHandler {
-	return &CredentialHandler{store: s, logger: logger}
+func (h *CredentialHandler) notify(r *http.Request, action string, cred *store.APICredential) {
+	if h.notifier == nil {
+		return
+	}
+	h.notifier.Credential(notify.CredentialEvent{
+		Action:      action,
+		Region:      RegionFromContext(r.Context()),
+		Actor:       Operator(r),
+		ID:          cred.ID,
+		AccessKey:   cred.AccessKey,
+		Description: cred.Description,
+		Scopes:      cred.Scopes,
+	})
 }
 
-// ListCredentials returns all API credentials in the current region (secret keys are omitted).
+// findCredential returns the region's credential with the given id, or nil.
+func (h *CredentialHandler) findCredential(r *http.Request, id int64) *store.APICredential {
+	creds, err := h.store.ListAPICredentials(r.Context(), RegionFromContext(r.Context()))
+	if err != nil {
+		h.logger.Warnf("look up api credential %d: %v", id, err)
+		return nil
+	}
+	for _, c := range creds {
+		if c.ID == id {
+			return &c
+		}
+	}
+	return nil
+}
+
+// ListCredentials returns all API credentials in the current region (secret keys are omitted),
+// a page at a time with ?limit=.
 func (h *CredentialHandler) ListCredentials(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
+	p, err := parsePage(r, 0)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
 
 	creds, err := h.store.ListAPICredentials(r.Context(), region)
 	if err != nil {
@@ -34,24 +106,19 @@ func (h *CredentialHandler) ListCredentials(w http.ResponseWriter, r *http.Reque
 	if creds == nil {
 		creds = []store.APICredential{}
 	}
-	JSON(w, http.StatusOK, map[string]any{"credentials": creds})
+	setPageHeaders(w, r, p, len(creds))
+	JSON(w, http.StatusOK, map[string]any{"credentials": paginate(creds, p)})
 }
 
 // CreateCredential generates a new AK/SK pair and stores it in the current region.
 func (h *CredentialHandler) CreateCredential(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 
-	body, err := ReadBody(r)
-	if err != nil {
-		ErrJSON(w, http.StatusBadRequest, "read body: "+err.Error())
-		return
-	}
-
 	var req struct {
 		Description string   `json:"description"`
 		Scopes      []string `json:"scopes"`
 	}
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
 		return
 	}
@@ -66,29 +133,18 @@ func (h *CredentialHandler) CreateCredential(w http.ResponseWriter, r *http.Requ
 	if req.Scopes == nil {
 		req.Scopes = []string{}
 	}
-
-	ak, err := generateRandomHex(16)
-	if err != nil {
-		h.logger.Errorf("generate access key: %v", err)
-		ErrJSON(w, http.StatusInternalServerError, "generate key failed")
+	if rejectScopeEscalation(w, r, req.Scopes) {
 		return
 	}
-	sk, err := generateRandomHex(32)
+
+	cred, err := newCredential(req.Description, req.Scopes, true)
 	if err != nil {
-		h.logger.Errorf("generate secret key: %v", err)
+		h.logger.Errorf("generate credential keys: %v", err)
 		ErrJSON(w, http.StatusInternalServerError, "generate key failed")
 		return
 	}
 
-	cred := &store.APICredential{
-		AccessKey:   ak,
-		SecretKey:   sk,
-		Description: req.Description,
-		Scopes:      req.Scopes,
-		Enabled:     true,
-	}
-
-	result, err := h.store.CreateAPICredential(r.Context(), region, cred)
+	result, err := h.create(r.Context(), region, cred)
 	if err != nil {
 		h.logger.Errorf("create api credential: %v", err)
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -97,9 +153,32 @@ func (h *CredentialHandler) CreateCredential(w http.ResponseWriter, r *http.Requ
 
 	h.logger.Infof("api credential created: ns=%s ak=%s desc=%s scopes=%v", region, result.AccessKey, result.Description, result.Scopes)
 	_ = h.store.InsertAuditLog(r.Context(), region, "credential", result.AccessKey, "create", Operator(r))
+	h.notify(r, "created", result)
 	JSON(w, http.StatusCreated, result)
 }
 
+// rejectScopeEscalation answers 403 and returns true if scopes include any
+// the caller does not hold itself, so that a credential or service account
+// cannot mint a more powerful one. Admins (admin:users) may grant any scope.
+// With authentication disabled there is no caller to compare against.
+func rejectScopeEscalation(w http.ResponseWriter, r *http.Request, scopes []string) bool {
+	id := IdentityFromContext(r.Context())
+	if id == nil || id.HasScope(store.ScopeAdminUsers) {
+		return false
+	}
+	var missing []string
+	for _, s := range scopes {
+		if !id.HasScope(s) {
+			missing = append(missing, s)
+		}
+	}
+	if len(missing) == 0 {
+		return false
+	}
+	ErrJSON(w, http.StatusForbidden, fmt.Sprintf("cannot grant scopes you do not hold: %s", strings.Join(missing, ", ")))
+	return true
+}
+
 // UpdateCredential updates description/enabled of an existing credential.
 func (h *CredentialHandler) UpdateCredential(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
@@ -111,18 +190,12 @@ func (h *CredentialHandler) UpdateCredential(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	body, err := ReadBody(r)
-	if err != nil {
-		ErrJSON(w, http.StatusBadRequest, "read body: "+err.Error())
-		return
-	}
-
 	var req struct {
 		Description string   `json:"description"`
 		Enabled     *bool    `json:"enabled"`
 		Scopes      []string `json:"scopes"`
 	}
-	if err := json.Unmarshal(body, &req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
 		return
 	}
@@ -137,6 +210,9 @@ func (h *CredentialHandler) UpdateCredential(w http.ResponseWriter, r *http.Requ
 	if req.Scopes == nil {
 		req.Scopes = []string{}
 	}
+	if rejectScopeEscalation(w, r, req.Scopes) {
+		return
+	}
 
 	enabled := true
 	if req.Enabled != nil {
@@ -150,6 +226,7 @@ func (h *CredentialHandler) UpdateCredential(w http.ResponseWriter, r *http.Requ
 		Enabled:     enabled,
 	}
 
+	prev := h.findCredential(r, id)
 	if err := h.store.UpdateAPICredential(r.Context(), region, cred); err != nil {
 		h.logger.Errorf("update api credential: %v", err)
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -157,6 +234,14 @@ func (h *CredentialHandler) UpdateCredential(w http.ResponseWriter, r *http.Requ
 	}
 
 	_ = h.store.InsertAuditLog(r.Context(), region, "credential", idStr, "update", Operator(r))
+	action := "updated"
+	if prev != nil {
+		cred.AccessKey = prev.AccessKey
+		if prev.Enabled != enabled {
+			action = map[bool]string{true: "enabled", false: "disabled"}[enabled]
+		}
+	}
+	h.notify(r, action, cred)
 	JSON(w, http.StatusOK, map[string]string{"status": "updated"})
 }
 
@@ -171,6 +256,7 @@ func (h *CredentialHandler) DeleteCredential(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
+	prev := h.findCredential(r, id)
 	if err := h.store.DeleteAPICredential(r.Context(), region, id); err != nil {
 		h.logger.Errorf("delete api credential: %v", err)
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -179,9 +265,170 @@ func (h *CredentialHandler) DeleteCredential(w http.ResponseWriter, r *http.Requ
 
 	h.logger.Infof("api credential deleted: ns=%s id=%d", region, id)
 	_ = h.store.InsertAuditLog(r.Context(), region, "credential", idStr, "delete", Operator(r))
+	if prev != nil {
+		h.notify(r, "deleted", prev)
+	}
 	JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
 }
 
+// BulkDisableCredentials disables every credential in the region matching
+// the filter in the body, e.g. all holders of a scope during an incident.
+func (h *CredentialHandler) BulkDisableCredentials(w http.ResponseWriter, r *http.Request) {
+	h.bulkSetEnabled(w, r, false)
+}
+
+// BulkEnableCredentials re-enables the credentials matching the filter in
+// the body, undoing BulkDisableCredentials.
+func (h *CredentialHandler) BulkEnableCredentials(w http.ResponseWriter, r *http.Request) {
+	h.bulkSetEnabled(w, r, true)
+}
+
+func (h *CredentialHandler) bulkSetEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	region := RegionFromContext(r.Context())
+
+	var filter store.CredentialFilter
+	if err := DecodeJSON(r, &filter); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
+		return
+	}
+	// An empty filter would match every credential, the caller's own
+	// included; ask for an explicit description ".*" instead.
+	if filter.Scope == "" && filter.Description == "" {
+		ErrJSON(w, http.StatusBadRequest, "scope or description is required")
+		return
+	}
+	if filter.Scope != "" && !store.ValidScope(filter.Scope) {
+		ErrJSON(w, http.StatusBadRequest, "invalid scope: "+filter.Scope)
+		return
+	}
+	if _, err := filter.Matcher(); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid "+err.Error())
+		return
+	}
+
+	changed, err := h.store.SetAPICredentialsEnabled(r.Context(), region, filter, enabled, Operator(r))
+	if err != nil {
+		h.logger.Errorf("bulk set api credentials enabled: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	action := map[bool]string{true: "enabled", false: "disabled"}[enabled]
+	ids := make([]int64, 0, len(changed))
+	for i := range changed {
+		ids = append(ids, changed[i].ID)
+		h.notify(r, action, &changed[i])
+	}
+	h.logger.Infof("api credentials %s in bulk: ns=%s scope=%q description=%q ids=%v", action, region, filter.Scope, filter.Description, ids)
+	JSON(w, http.StatusOK, map[string]any{"ids": ids, "count": len(ids)})
+}
+
+// credentialMeta is the part of a credential that carries over between
+// environments: everything but its keys.
+type credentialMeta struct {
+	Description string   `json:"description"`
+	Scopes      []string `json:"scopes"`
+	Enabled     bool     `json:"enabled"`
+}
+
+// ExportCredentials returns the region's credentials as metadata only, for
+// ImportCredentials in another environment. Keys are never exported.
+func (h *CredentialHandler) ExportCredentials(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	creds, err := h.store.ListAPICredentials(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("list api credentials: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	metas := make([]credentialMeta, 0, len(creds))
+	for _, c := range creds {
+		metas = append(metas, credentialMeta{Description: c.Description, Scopes: c.Scopes, Enabled: c.Enabled})
+	}
+	JSON(w, http.StatusOK, map[string]any{"credentials": metas})
+}
+
+// ImportCredentials creates a credential with fresh keys for each exported
+// entry. The secret keys are in this response only, as with CreateCredential.
+// Entries are all validated before any is created.
+func (h *CredentialHandler) ImportCredentials(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	var req struct {
+		Credentials []credentialMeta `json:"credentials"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
+		return
+	}
+	if len(req.Credentials) == 0 {
+		ErrJSON(w, http.StatusBadRequest, "no credentials to import")
+		return
+	}
+	var scopes []string
+	for i, m := range req.Credentials {
+		for _, s := range m.Scopes {
+			if !store.ValidScope(s) {
+				ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("credentials[%d]: invalid scope: %s", i, s))
+				return
+			}
+			if !slices.Contains(scopes, s) {
+				scopes = append(scopes, s)
+			}
+		}
+	}
+	if rejectScopeEscalation(w, r, scopes) {
+		return
+	}
+
+	created := make([]*store.APICredential, 0, len(req.Credentials))
+	for _, m := range req.Credentials {
+		if m.Scopes == nil {
+			m.Scopes = []string{}
+		}
+		cred, err := newCredential(m.Description, m.Scopes, m.Enabled)
+		if err == nil {
+			cred, err = h.create(r.Context(), region, cred)
+		}
+		if err != nil {
+			// The ones already created exist; hand back their keys, which
+			// cannot be read again.
+			h.logger.Errorf("import api credential: %v", err)
+			JSON(w, http.StatusInternalServerError, map[string]any{
+				"error":       fmt.Sprintf("imported %d of %d credentials: %v", len(created), len(req.Credentials), err),
+				"credentials": created,
+			})
+			return
+		}
+		_ = h.store.InsertAuditLog(r.Context(), region, "credential", cred.AccessKey, "import", Operator(r))
+		h.notify(r, "created", cred)
+		created = append(created, cred)
+	}
+
+	h.logger.Infof("api credentials imported: ns=%s count=%d", region, len(created))
+	JSON(w, http.StatusCreated, map[string]any{"credentials": created})
+}
+
+// newCredential returns a credential with a fresh AK/SK pair.
+func newCredential(description string, scopes []string, enabled bool) (*store.APICredential, error) {
+	ak, err := generateRandomHex(16)
+	if err != nil {
+		return nil, fmt.Errorf("access key: %w", err)
+	}
+	sk, err := generateRandomHex(32)
+	if err != nil {
+		return nil, fmt.Errorf("secret key: %w", err)
+	}
+	return &store.APICredential{
+		AccessKey:   ak,
+		SecretKey:   sk,
+		Description: description,
+		Scopes:      scopes,
+		Enabled:     enabled,
+	}, nil
+}
+
 // generateRandomHex returns a random hex string of n bytes (2n chars).
 func generateRandomHex(n int) (string, error) {
 	b := make([]byte, n)
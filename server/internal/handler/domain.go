@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/model"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
@@ -15,26 +19,134 @@ import (
 type DomainHandler struct {
 	store  store.Store
 	logger *zap.SugaredLogger
+	quotas quotas
+	rules  ruleSet
 }
 
-func NewDomainHandler(s store.Store, logger *zap.SugaredLogger) *DomainHandler {
-	return &DomainHandler{store: s, logger: logger}
+func NewDomainHandler(s store.Store, logger *zap.SugaredLogger, quota *config.Dynamic[config.QuotaConfig], rules *config.Dynamic[[]model.Rule]) *DomainHandler {
+	return &DomainHandler{store: s, logger: logger, quotas: quotas{store: s, defaults: quota}, rules: ruleSet{rules: rules}}
 }
 
+// ListDomains returns the region's domains, narrowed to those carrying every
+// ?label=key=value given, a page at a time with ?limit=.
 func (h *DomainHandler) ListDomains(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
-	domains, err := h.store.ListDomains(r.Context(), region)
+	selector, err := parseLabelSelector(r)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := parsePage(r, 0)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	domains, meta, err := h.store.ListDomainsWithMeta(r.Context(), region, selector)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
-	JSON(w, http.StatusOK, map[string]any{"domains": domains, "total": len(domains)})
+	items := make([]domainListItem, 0, len(domains))
+	for i, d := range domains {
+		m := meta[i]
+		items = append(items, domainListItem{d, m.ResourceVersion, m.UpdatedAt, m.LastOperator})
+	}
+	total := len(items)
+	setPageHeaders(w, r, p, total)
+	JSON(w, http.StatusOK, map[string]any{"domains": paginate(items, p), "total": total})
+}
+
+// domainListItem is a domain in list responses, with when and by whom it
+// was last changed.
+type domainListItem struct {
+	model.DomainConfig
+	ResourceVersion int64     `json:"resource_version"`
+	UpdatedAt       time.Time `json:"updated_at"`
+	LastOperator    string    `json:"last_operator"`
+}
+
+// parseLabelSelector reads repeated ?label=key=value parameters into a
+// selector, or nil if there are none.
+func parseLabelSelector(r *http.Request) (map[string]string, error) {
+	params := r.URL.Query()["label"]
+	if len(params) == 0 {
+		return nil, nil
+	}
+	selector := make(map[string]string, len(params))
+	for _, p := range params {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("label selector %q must be key=value", p)
+		}
+		selector[key] = value
+	}
+	return selector, nil
+}
+
+// writeHostConflict writes a 409 and returns true if err is the store's
+// report that another domain in the region already claims one of the hosts.
+// The check runs inside PutDomain's transaction, so concurrent writes
+// cannot both claim a host.
+func writeHostConflict(w http.ResponseWriter, err error) bool {
+	var hc *store.HostConflictError
+	if !errors.As(err, &hc) {
+		return false
+	}
+	JSON(w, http.StatusConflict, map[string]any{
+		"error":  hc.Error(),
+		"host":   hc.Host,
+		"domain": hc.Domain,
+	})
+	return true
+}
+
+// rejectMissingSecrets writes a 400 and returns true if any of domains
+// references a secret that does not exist in the region. Every path that
+// writes domains checks this, since the gateway cannot serve TLS for a
+// dangling certificate_ref.
+func rejectMissingSecrets(w http.ResponseWriter, r *http.Request, s store.Store, region string, domains ...model.DomainConfig) bool {
+	for i := range domains {
+		d := &domains[i]
+		if d.TLS == nil {
+			continue
+		}
+		sec, err := s.GetSecret(r.Context(), region, d.TLS.CertificateRef)
+		if err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return true
+		}
+		if sec == nil {
+			ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("domain %q: tls.certificate_ref: secret %q not found", d.Name, d.TLS.CertificateRef))
+			return true
+		}
+	}
+	return false
+}
+
+// FindDomainsByHost returns the domains that claim ?host=. More than one
+// domain may claim the same host, so the result is always a list.
+func (h *DomainHandler) FindDomainsByHost(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	host := strings.TrimSpace(r.URL.Query().Get("host"))
+	if host == "" {
+		ErrJSON(w, http.StatusBadRequest, "host query parameter is required")
+		return
+	}
+	domains, err := h.store.FindDomainsByHost(r.Context(), region, host)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if domains == nil {
+		domains = []model.DomainConfig{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"host": host, "domains": domains, "total": len(domains)})
 }
 
 func (h *DomainHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 	name := r.PathValue("name")
-	domain, rv, err := h.store.GetDomain(r.Context(), region, name)
+	domain, meta, err := h.store.GetDomainWithMeta(r.Context(), region, name)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
@@ -43,7 +155,13 @@ func (h *DomainHandler) GetDomain(w http.ResponseWriter, r *http.Request) {
 		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("domain %q not found", name))
 		return
 	}
-	JSON(w, http.StatusOK, map[string]any{"domain": domain, "resource_version": rv})
+	if notModified(w, r, resourceETag(meta), meta.UpdatedAt) {
+		return
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"domain": domain, "resource_version": meta.ResourceVersion,
+		"updated_at": meta.UpdatedAt, "last_operator": meta.LastOperator,
+	})
 }
 
 func (h *DomainHandler) CreateDomain(w http.ResponseWriter, r *http.Request) {
@@ -59,13 +177,31 @@ func (h *DomainHandler) CreateDomain(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	model.NormalizeHosts(&domain)
 	if errs := model.ValidateDomain(&domain, nil); len(errs) > 0 {
 		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
 		return
 	}
+	if normalizeRequested(r) {
+		model.NormalizeRouteWeights(&domain)
+	}
+	ruleWarns, ok := h.rules.checkDomain(w, region, &domain)
+	if !ok {
+		return
+	}
+
+	if rejectMissingSecrets(w, r, h.store, region, domain) {
+		return
+	}
+	if h.quotas.rejectCreate(w, r, region, "domain") {
+		return
+	}
 
 	ver, err := h.store.PutDomain(r.Context(), region, &domain, "create", Operator(r), 0)
 	if err != nil {
+		if writeHostConflict(w, err) {
+			return
+		}
 		if errors.Is(err, store.ErrConflict) {
 			ErrJSON(w, http.StatusConflict, fmt.Sprintf("domain %q already exists", domain.Name))
 			return
@@ -75,38 +211,59 @@ func (h *DomainHandler) CreateDomain(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.logger.Infof("domain created: %s (ns=%s), version=%d", domain.Name, region, ver)
-	JSON(w, http.StatusCreated, map[string]any{"version": ver, "domain": domain, "resource_version": int64(1)})
+	JSON(w, http.StatusCreated, map[string]any{"version": ver, "domain": domain, "resource_version": int64(1), "warnings": warnings(append(model.WarnDomain(&domain), ruleWarns...))})
 }
 
+// UpdateDomain replaces a domain. The expected resource version is taken
+// from the If-Match header or the body's resource_version.
 func (h *DomainHandler) UpdateDomain(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 	name := r.PathValue("name")
 
 	var body struct {
 		model.DomainConfig
-		ResourceVersion int64 `json:"resource_version"`
+		ResourceVersion *int64 `json:"resource_version"`
 	}
 	if err := DecodeJSON(r, &body); err != nil {
 		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
 		return
 	}
 
-	if body.ResourceVersion <= 0 {
-		ErrJSON(w, http.StatusBadRequest, "resource_version is required for update (must be > 0)")
+	expected, fromHeader, ok := updatePrecondition(w, r, "domain", body.ResourceVersion, func() (*store.ResourceMeta, error) {
+		_, meta, err := h.store.GetDomainWithMeta(r.Context(), region, name)
+		return meta, err
+	})
+	if !ok {
 		return
 	}
 
 	body.DomainConfig.Name = name
 
+	model.NormalizeHosts(&body.DomainConfig)
 	if errs := model.ValidateDomain(&body.DomainConfig, nil); len(errs) > 0 {
 		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
 		return
 	}
+	if normalizeRequested(r) {
+		model.NormalizeRouteWeights(&body.DomainConfig)
+	}
+	ruleWarns, ok := h.rules.checkDomain(w, region, &body.DomainConfig)
+	if !ok {
+		return
+	}
 
-	ver, err := h.store.PutDomain(r.Context(), region, &body.DomainConfig, "update", Operator(r), body.ResourceVersion)
+	if rejectMissingSecrets(w, r, h.store, region, body.DomainConfig) {
+		return
+	}
+
+	ctx, noop := skipNoopContext(r)
+	ver, err := h.store.PutDomain(ctx, region, &body.DomainConfig, "update", Operator(r), expected)
 	if err != nil {
+		if writeHostConflict(w, err) {
+			return
+		}
 		if errors.Is(err, store.ErrConflict) {
-			ErrJSON(w, http.StatusConflict, "conflict: the domain has been modified by another user, please refresh and try again")
+			updateConflict(w, "domain", fromHeader)
 			return
 		}
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -114,7 +271,104 @@ func (h *DomainHandler) UpdateDomain(w http.ResponseWriter, r *http.Request) {
 	}
 
 	h.logger.Infof("domain updated: %s (ns=%s), version=%d", name, region, ver)
-	JSON(w, http.StatusOK, map[string]any{"version": ver, "domain": body.DomainConfig, "resource_version": body.ResourceVersion + 1})
+	JSON(w, http.StatusOK, map[string]any{"version": ver, "domain": body.DomainConfig, "resource_version": updatedVersion(expected, noop), "unchanged": noop.Skipped, "warnings": warnings(append(model.WarnDomain(&body.DomainConfig), ruleWarns...))})
+}
+
+// PatchDomain applies an RFC 6902 JSON Patch to the stored domain. The
+// expected resource version is passed as ?resource_version=; the patched
+// result is validated like a full update. The domain name cannot be patched.
+func (h *DomainHandler) PatchDomain(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	expected, err := strconv.ParseInt(r.URL.Query().Get("resource_version"), 10, 64)
+	if err != nil || expected <= 0 {
+		ErrJSON(w, http.StatusBadRequest, "resource_version query parameter is required for patch (must be > 0)")
+		return
+	}
+
+	var ops []patchOp
+	if err := DecodeJSON(r, &ops); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json patch: %v", err))
+		return
+	}
+
+	current, rv, err := h.store.GetDomain(r.Context(), region, name)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if current == nil {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("domain %q not found", name))
+		return
+	}
+	if rv != expected {
+		ErrJSON(w, http.StatusConflict, "conflict: the domain has been modified by another user, please refresh and try again")
+		return
+	}
+
+	raw, err := json.Marshal(current)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var doc any
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	doc, err = applyJSONPatch(doc, ops)
+	if err != nil {
+		ErrJSON(w, http.StatusUnprocessableEntity, fmt.Sprintf("apply patch: %v", err))
+		return
+	}
+	raw, err = json.Marshal(doc)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var patched model.DomainConfig
+	if err := json.Unmarshal(raw, &patched); err != nil {
+		ErrJSON(w, http.StatusUnprocessableEntity, fmt.Sprintf("patched document is not a valid domain: %v", err))
+		return
+	}
+	if patched.Name != name {
+		ErrJSON(w, http.StatusBadRequest, "domain name cannot be changed by patch")
+		return
+	}
+
+	model.NormalizeHosts(&patched)
+	if errs := model.ValidateDomain(&patched, nil); len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+		return
+	}
+	if normalizeRequested(r) {
+		model.NormalizeRouteWeights(&patched)
+	}
+	ruleWarns, ok := h.rules.checkDomain(w, region, &patched)
+	if !ok {
+		return
+	}
+	if rejectMissingSecrets(w, r, h.store, region, patched) {
+		return
+	}
+
+	ctx, noop := skipNoopContext(r)
+	ver, err := h.store.PutDomain(ctx, region, &patched, "update", Operator(r), expected)
+	if err != nil {
+		if writeHostConflict(w, err) {
+			return
+		}
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, "conflict: the domain has been modified by another user, please refresh and try again")
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("domain patched: %s (ns=%s), version=%d, ops=%d", name, region, ver, len(ops))
+	JSON(w, http.StatusOK, map[string]any{"version": ver, "domain": patched, "resource_version": updatedVersion(expected, noop), "unchanged": noop.Skipped, "warnings": warnings(append(model.WarnDomain(&patched), ruleWarns...))})
 }
 
 func (h *DomainHandler) DeleteDomain(w http.ResponseWriter, r *http.Request) {
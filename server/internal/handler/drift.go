@@ -0,0 +1,222 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// EtcdReader lists the domain and cluster values the controller wrote to
+// etcd for a region, keyed by name.
+type EtcdReader interface {
+	Domains(ctx context.Context, region string) (map[string]string, error)
+	Clusters(ctx context.Context, region string) (map[string]string, error)
+}
+
+// DriftHandler compares stored config with what is live in etcd. It only
+// reads: fixing drift is left to the controller's reconcile loop.
+type DriftHandler struct {
+	store  store.Store
+	etcd   EtcdReader
+	logger *zap.SugaredLogger
+}
+
+// NewDriftHandler returns a handler; etcd is nil when no etcd is configured.
+func NewDriftHandler(s store.Store, etcd EtcdReader, logger *zap.SugaredLogger) *DriftHandler {
+	return &DriftHandler{store: s, etcd: etcd, logger: logger}
+}
+
+// driftEntry is one domain or cluster that differs between store and etcd.
+type driftEntry struct {
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+}
+
+// ConfigDrift diffs the region's stored domains and clusters against the
+// live etcd keys. Missing entries are stored but absent from etcd, extra
+// ones are in etcd but not stored, and mismatched ones differ in value.
+// Values are compared as JSON, ignoring key order and whitespace.
+func (h *DriftHandler) ConfigDrift(w http.ResponseWriter, r *http.Request) {
+	if h.etcd == nil {
+		ErrJSON(w, http.StatusServiceUnavailable, "drift check requires etcd to be configured")
+		return
+	}
+	region := RegionFromContext(r.Context())
+
+	rev, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	cfg, err := h.store.GetConfig(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	liveDomains, err := h.etcd.Domains(r.Context(), region)
+	if err != nil {
+		h.logger.Warnf("drift check (ns=%s): %v", region, err)
+		ErrJSON(w, http.StatusBadGateway, "etcd read failed")
+		return
+	}
+	liveClusters, err := h.etcd.Clusters(r.Context(), region)
+	if err != nil {
+		h.logger.Warnf("drift check (ns=%s): %v", region, err)
+		ErrJSON(w, http.StatusBadGateway, "etcd read failed")
+		return
+	}
+
+	storedDomains := make(map[string]string, len(cfg.Domains))
+	for _, d := range cfg.Domains {
+		storedDomains[d.Name] = canonicalJSON(d)
+	}
+	storedClusters := make(map[string]string, len(cfg.Clusters))
+	for _, c := range cfg.Clusters {
+		storedClusters[c.Name] = canonicalJSON(c)
+	}
+
+	missing, extra, mismatched := []driftEntry{}, []driftEntry{}, []driftEntry{}
+	diff := func(kind string, stored, live map[string]string) {
+		for _, name := range sortedKeys(stored) {
+			v, ok := live[name]
+			switch {
+			case !ok:
+				missing = append(missing, driftEntry{kind, name})
+			case canonicalJSON(json.RawMessage(v)) != stored[name]:
+				mismatched = append(mismatched, driftEntry{kind, name})
+			}
+		}
+		for _, name := range sortedKeys(live) {
+			if _, ok := stored[name]; !ok {
+				extra = append(extra, driftEntry{kind, name})
+			}
+		}
+	}
+	diff("domain", storedDomains, liveDomains)
+	diff("cluster", storedClusters, liveClusters)
+
+	JSON(w, http.StatusOK, map[string]any{
+		"resource_version": rev,
+		"in_sync":          len(missing)+len(extra)+len(mismatched) == 0,
+		"missing":          missing,
+		"extra":            extra,
+		"mismatched":       mismatched,
+	})
+}
+
+// Controller defaults for the etcd key prefixes, used by EtcdPreview when a
+// prefix is not given.
+const (
+	defaultEtcdDomainPrefix  = "/hermes/domains"
+	defaultEtcdClusterPrefix = "/hermes/clusters"
+	defaultEtcdMetaPrefix    = "/hermes/meta"
+)
+
+// EtcdPreview renders the region's config into the etcd keys and values a
+// controller would write for it, without touching etcd: one key per domain
+// and cluster under ?domain_prefix= and ?cluster_prefix=, plus the
+// config_revision key under ?meta_prefix=. Prefixes default to the
+// controller's, and ?selector= narrows the preview to a sharded
+// controller's subset as in GetConfig (including its 422 for domains
+// routing to unselected clusters). Values are serialized as the
+// controller's reconcile does.
+func (h *DriftHandler) EtcdPreview(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	q := r.URL.Query()
+	prefix := func(param, def string) string {
+		if p := strings.TrimRight(q.Get(param), "/"); p != "" {
+			return p
+		}
+		return def
+	}
+	domainPrefix := prefix("domain_prefix", defaultEtcdDomainPrefix)
+	clusterPrefix := prefix("cluster_prefix", defaultEtcdClusterPrefix)
+	metaPrefix := prefix("meta_prefix", defaultEtcdMetaPrefix)
+	selector, err := parseConfigSelector(r)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Revision first, as in GetConfig.
+	rev, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	var cfg *model.GatewayConfig
+	if selector != nil {
+		cfg, err = h.store.GetConfigByLabels(r.Context(), region, selector)
+	} else {
+		cfg, err = h.store.GetConfig(r.Context(), region)
+	}
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if selector != nil && rejectUnselectedClusters(w, cfg) {
+		return
+	}
+
+	keys := make(map[string]json.RawMessage, len(cfg.Domains)+len(cfg.Clusters)+1)
+	for _, d := range cfg.Domains {
+		keys[domainPrefix+"/"+d.Name] = json.RawMessage(canonicalJSON(d))
+	}
+	for _, c := range cfg.Clusters {
+		keys[clusterPrefix+"/"+c.Name] = json.RawMessage(canonicalJSON(c))
+	}
+	// The controller publishes its watch revision, which audit-only events
+	// advance too, so the meta key carries CurrentRevision, not rev.
+	watchRev, err := h.store.CurrentRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	keys[metaPrefix+"/config_revision"] = json.RawMessage(strconv.FormatInt(watchRev, 10))
+
+	JSON(w, http.StatusOK, map[string]any{
+		"resource_version": rev,
+		"keys":             keys,
+		"total":            len(keys),
+	})
+}
+
+// canonicalJSON re-encodes v through a generic value so that equal JSON
+// documents compare equal as strings. Invalid JSON is returned as is, which
+// never matches a stored value.
+func canonicalJSON(v any) string {
+	raw, ok := v.(json.RawMessage)
+	if !ok {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return ""
+		}
+		raw = b
+	}
+	var obj any
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return string(raw)
+	}
+	out, err := json.Marshal(obj)
+	if err != nil {
+		return string(raw)
+	}
+	return string(out)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+)
+
+// graphNode is a domain, route or cluster in the routing topology. IDs are
+// prefixed by kind ("domain:api", "route:api/users", "cluster:backend") so
+// they are unique across kinds.
+type graphNode struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Domains only.
+	Hosts          []string `json:"hosts,omitempty"`
+	DuplicateHosts []string `json:"duplicate_hosts,omitempty"`
+	// Routes only.
+	URI string `json:"uri,omitempty"`
+	// Clusters only. Upstreams lists static nodes as host:port.
+	Upstreams []string `json:"upstreams,omitempty"`
+	Orphan    bool     `json:"orphan,omitempty"`
+	// Missing marks a cluster a route references but that does not exist.
+	Missing bool `json:"missing,omitempty"`
+}
+
+// graphEdge links a domain to its routes and a route to its clusters;
+// Weight is set on route→cluster edges.
+type graphEdge struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Weight *int   `json:"weight,omitempty"`
+}
+
+// ConfigGraph returns the region's domain → route → cluster topology as
+// nodes and edges for rendering. Clusters no route references are marked
+// orphan; hosts claimed by more than one domain are listed per domain.
+func (h *RouteHandler) ConfigGraph(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	cfg, err := h.store.GetConfig(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	nodes, edges := configGraph(cfg)
+	JSON(w, http.StatusOK, map[string]any{"nodes": nodes, "edges": edges})
+}
+
+func configGraph(cfg *model.GatewayConfig) ([]graphNode, []graphEdge) {
+	nodes := []graphNode{}
+	edges := []graphEdge{}
+
+	claims := make(map[string]int)
+	for _, d := range cfg.Domains {
+		for _, host := range d.Hosts {
+			claims[host]++
+		}
+	}
+
+	clusters := make(map[string]bool, len(cfg.Clusters))
+	for _, c := range cfg.Clusters {
+		clusters[c.Name] = true
+	}
+	missing := make(map[string]bool)
+
+	for _, d := range cfg.Domains {
+		node := graphNode{ID: "domain:" + d.Name, Kind: "domain", Name: d.Name, Hosts: d.Hosts}
+		for _, host := range d.Hosts {
+			if claims[host] > 1 {
+				node.DuplicateHosts = append(node.DuplicateHosts, host)
+			}
+		}
+		nodes = append(nodes, node)
+
+		for _, route := range d.Routes {
+			routeID := fmt.Sprintf("route:%s/%s", d.Name, route.Name)
+			nodes = append(nodes, graphNode{ID: routeID, Kind: "route", Name: route.Name, URI: route.URI})
+			edges = append(edges, graphEdge{From: node.ID, To: routeID})
+			for _, wc := range route.Clusters {
+				weight := wc.Weight
+				edges = append(edges, graphEdge{From: routeID, To: "cluster:" + wc.Name, Weight: &weight})
+				if !clusters[wc.Name] {
+					missing[wc.Name] = true
+				}
+			}
+		}
+	}
+
+	referenced := referencedClusters(cfg.Domains)
+	for _, c := range cfg.Clusters {
+		node := graphNode{ID: "cluster:" + c.Name, Kind: "cluster", Name: c.Name, Orphan: !referenced[c.Name]}
+		for _, n := range c.Nodes {
+			node.Upstreams = append(node.Upstreams, fmt.Sprintf("%s:%d", n.Host, n.Port))
+		}
+		nodes = append(nodes, node)
+	}
+	names := make([]string, 0, len(missing))
+	for name := range missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		nodes = append(nodes, graphNode{ID: "cluster:" + name, Kind: "cluster", Name: name, Missing: true})
+	}
+	return nodes, edges
+}
@@ -2,55 +2,146 @@ package handler
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/notify"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
+	"github.com/pquerna/otp/totp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"golang.org/x/crypto/bcrypt"
 )
 
+type mockTOTP struct {
+	sealed   string
+	enabled  bool
+	lastStep int64
+}
+
 type mockStore struct {
-	domains    map[string]map[string]*model.DomainConfig // ns → name → config
-	clusters   map[string]map[string]*model.ClusterConfig
-	domainRVs  map[string]map[string]int64 // ns → name → resource_version
-	clusterRVs map[string]map[string]int64
-	creds      map[string][]store.APICredential
-	credsByAK  map[string]*store.APICredential
-	dashboards map[string][]store.GrafanaDashboard
-	instances  map[string][]store.GatewayInstanceStatus
-	ctrl       map[string]*store.ControllerStatus
-	auditLog   []store.AuditEntry
-	changes    []store.ChangeEvent
-	revision   int64
-	nextID     int64
+	domains     map[string]map[string]*model.DomainConfig // ns → name → config
+	clusters    map[string]map[string]*model.ClusterConfig
+	domainRVs   map[string]map[string]int64 // ns → name → resource_version
+	clusterRVs  map[string]map[string]int64
+	modified    map[string]time.Time // kind/ns/name → updated_at
+	operators   map[string]string    // kind/ns/name → last operator
+	creds       map[string][]store.APICredential
+	credsByAK   map[string]*store.APICredential
+	svcAccounts map[string][]store.ServiceAccount
+	svcByHash   map[string]*store.ServiceAccount
+	members     map[string]store.RegionRole  // region/sub → role
+	groupRoles  map[string]store.RegionRole  // region/group → role
+	customRoles map[string]*store.CustomRole // region/name → role
+	secrets     map[string]*store.Secret     // region/name → secret
+	settings    map[string]*store.RegionSettings
+	frozen      map[string]bool
+	targets     map[string][]store.FanoutTarget
+	canaries    map[string]*store.ConfigCanary
+	scheduled   []store.ScheduledChange
+	maintenance store.Maintenance
+	maintaining bool  // MaintainTables reports ErrMaintenanceRunning
+	userErr     error // returned by GetUser when set
+	dashboards  map[string][]store.GrafanaDashboard
+	instances   map[string][]store.GatewayInstanceStatus
+	revHistory  map[string][]store.InstanceRevisionEvent // ns/id → events
+	elections   map[string][]store.LeadershipEvent       // ns → events
+	ctrl        map[string]*store.ControllerStatus
+	auditLog    []store.AuditEntry
+	history     []store.HistoryEntry
+	configAt    map[int64]*model.GatewayConfig // revision → config, for ConfigAtRevision
+	changes     []store.ChangeEvent
+	authStates  map[string]*store.OIDCAuthState
+	users       map[string]*store.User
+	passwords   map[string]string // sub → hash
+	signingKey  *store.JWTSigningKey
+	lastGrace   time.Duration // grace period passed to RotateSigningKey
+	retiredKeys []store.JWTSigningKey
+	pwHistory   map[string][]string // sub → hashes, newest first
+	totp        map[string]mockTOTP
+	refresh     map[string]*store.RefreshToken      // token hash → token
+	failures    map[string]int                      // email → failed logins
+	lockouts    map[string]time.Time                // email → locked until
+	idempotency map[string]*store.IdempotencyRecord // region/route/key → record
+	revision    int64
+	// auditRevisions advance CurrentRevision but not ConfigRevision, like
+	// the store's audit-only events.
+	auditRevisions int64
+	nextID         int64
 }
 
 func newMockStore() *mockStore {
 	return &mockStore{
-		domains:    make(map[string]map[string]*model.DomainConfig),
-		clusters:   make(map[string]map[string]*model.ClusterConfig),
-		domainRVs:  make(map[string]map[string]int64),
-		clusterRVs: make(map[string]map[string]int64),
-		creds:      make(map[string][]store.APICredential),
-		credsByAK:  make(map[string]*store.APICredential),
-		dashboards: make(map[string][]store.GrafanaDashboard),
-		instances:  make(map[string][]store.GatewayInstanceStatus),
-		ctrl:       make(map[string]*store.ControllerStatus),
-		nextID:     1,
+		domains:     make(map[string]map[string]*model.DomainConfig),
+		clusters:    make(map[string]map[string]*model.ClusterConfig),
+		domainRVs:   make(map[string]map[string]int64),
+		clusterRVs:  make(map[string]map[string]int64),
+		modified:    make(map[string]time.Time),
+		operators:   make(map[string]string),
+		creds:       make(map[string][]store.APICredential),
+		credsByAK:   make(map[string]*store.APICredential),
+		svcAccounts: make(map[string][]store.ServiceAccount),
+		svcByHash:   make(map[string]*store.ServiceAccount),
+		members:     make(map[string]store.RegionRole),
+		groupRoles:  make(map[string]store.RegionRole),
+		customRoles: make(map[string]*store.CustomRole),
+		secrets:     make(map[string]*store.Secret),
+		settings:    map[string]*store.RegionSettings{"default": {}},
+		frozen:      make(map[string]bool),
+		targets:     make(map[string][]store.FanoutTarget),
+		canaries:    make(map[string]*store.ConfigCanary),
+		dashboards:  make(map[string][]store.GrafanaDashboard),
+		instances:   make(map[string][]store.GatewayInstanceStatus),
+		revHistory:  make(map[string][]store.InstanceRevisionEvent),
+		elections:   make(map[string][]store.LeadershipEvent),
+		ctrl:        make(map[string]*store.ControllerStatus),
+		authStates:  make(map[string]*store.OIDCAuthState),
+		users:       make(map[string]*store.User),
+		passwords:   make(map[string]string),
+		pwHistory:   make(map[string][]string),
+		totp:        make(map[string]mockTOTP),
+		refresh:     make(map[string]*store.RefreshToken),
+		failures:    make(map[string]int),
+		lockouts:    make(map[string]time.Time),
+		idempotency: make(map[string]*store.IdempotencyRecord),
+		nextID:      1,
 	}
 }
 
 func (m *mockStore) Close() {}
 
+func (m *mockStore) Ping(_ context.Context) error { return nil }
+
 func (m *mockStore) ListDomains(_ context.Context, ns string) ([]model.DomainConfig, error) {
 	var result []model.DomainConfig
 	for _, d := range m.domains[ns] {
@@ -59,11 +150,81 @@ func (m *mockStore) ListDomains(_ context.Context, ns string) ([]model.DomainCon
 	return result, nil
 }
 
+func (m *mockStore) FindDomainsByHost(_ context.Context, ns string, hosts ...string) ([]model.DomainConfig, error) {
+	var result []model.DomainConfig
+	for _, d := range m.domains[ns] {
+		if slices.ContainsFunc(hosts, func(h string) bool { return slices.Contains(d.Hosts, h) }) {
+			result = append(result, *d)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockStore) FindDomainsByCluster(_ context.Context, ns, cluster string) ([]model.DomainConfig, error) {
+	var result []model.DomainConfig
+	for _, name := range slices.Sorted(maps.Keys(m.domains[ns])) {
+		d := m.domains[ns][name]
+		if referencedClusters([]model.DomainConfig{*d})[cluster] {
+			result = append(result, *d)
+		}
+	}
+	return result, nil
+}
+
+func (m *mockStore) Search(_ context.Context, ns, q string, limit int) ([]store.SearchResult, error) {
+	type hit struct {
+		store.SearchResult
+		rank int
+	}
+	rank := func(value string) int {
+		v, t := strings.ToLower(value), strings.ToLower(q)
+		switch {
+		case v == t:
+			return 0
+		case strings.HasPrefix(v, t):
+			return 1
+		case strings.Contains(v, t):
+			return 2
+		}
+		return -1
+	}
+	var hits []hit
+	best := func(typ, name string, fields [][2]string) {
+		h := hit{rank: -1}
+		for _, f := range fields {
+			if r := rank(f[1]); r >= 0 && (h.rank < 0 || r < h.rank) {
+				h = hit{store.SearchResult{Type: typ, Name: name, MatchField: f[0], Match: f[1]}, r}
+			}
+		}
+		if h.rank >= 0 {
+			hits = append(hits, h)
+		}
+	}
+	for name, d := range m.domains[ns] {
+		fields := [][2]string{{"name", name}}
+		for _, host := range d.Hosts {
+			fields = append(fields, [2]string{"host", host})
+		}
+		best("domain", name, fields)
+	}
+	for name := range m.clusters[ns] {
+		best("cluster", name, [][2]string{{"name", name}})
+	}
+	slices.SortFunc(hits, func(a, b hit) int {
+		return cmp.Or(cmp.Compare(a.rank, b.rank), cmp.Compare(a.Name, b.Name), cmp.Compare(a.Type, b.Type))
+	})
+	results := []store.SearchResult{}
+	for _, h := range hits[:min(limit, len(hits))] {
+		results = append(results, h.SearchResult)
+	}
+	return results, nil
+}
+
 func (m *mockStore) GetDomain(_ context.Context, region, name string) (*model.DomainConfig, int64, error) {
-	if nsm, ok := m.domains[ns]; ok {
+	if nsm, ok := m.domains[region]; ok {
 		if d, exists := nsm[name]; exists {
 			rv := int64(1)
-			if rvm, ok := m.domainRVs[ns]; ok {
+			if rvm, ok := m.domainRVs[region]; ok {
 				if r, ok := rvm[name]; ok {
 					rv = r
 				}
@@ -74,7 +235,35 @@ func (m *mockStore) GetDomain(_ context.Context, region, name string) (*model.Do
 	return nil, 0, nil
 }
 
-func (m *mockStore) PutDomain(_ context.Context, ns string, d *model.DomainConfig, action, operator string, expectedVersion int64) (int64, error) {
+func (m *mockStore) GetDomainWithMeta(ctx context.Context, region, name string) (*model.DomainConfig, *store.ResourceMeta, error) {
+	d, rv, err := m.GetDomain(ctx, region, name)
+	if d == nil {
+		return nil, nil, err
+	}
+	key := "domain/" + region + "/" + name
+	return d, &store.ResourceMeta{ResourceVersion: rv, UpdatedAt: m.modified[key], LastOperator: m.operators[key]}, nil
+}
+func (m *mockStore) ListDomainsWithMeta(ctx context.Context, region string, selector map[string]string) ([]model.DomainConfig, []store.ResourceMeta, error) {
+	items, _ := m.ListDomainsByLabels(ctx, region, selector)
+	metas := make([]store.ResourceMeta, len(items))
+	for i := range items {
+		_, meta, _ := m.GetDomainWithMeta(ctx, region, items[i].Name)
+		metas[i] = *meta
+	}
+	return items, metas, nil
+}
+
+func (m *mockStore) ListDomainsByLabels(ctx context.Context, region string, selector map[string]string) ([]model.DomainConfig, error) {
+	all, _ := m.ListDomains(ctx, region)
+	var result []model.DomainConfig
+	for _, d := range all {
+		if model.MatchLabels(d.Labels, selector) {
+			result = append(result, d)
+		}
+	}
+	return result, nil
+}
+func (m *mockStore) PutDomain(ctx context.Context, ns string, d *model.DomainConfig, action, operator string, expectedVersion int64) (int64, error) {
 	if m.domains[ns] == nil {
 		m.domains[ns] = make(map[string]*model.DomainConfig)
 	}
@@ -83,7 +272,26 @@ func (m *mockStore) PutDomain(_ context.Context, ns string, d *model.DomainConfi
 	}
 
 	currentRV := m.domainRVs[ns][d.Name]
-	_, exists := m.domains[ns][d.Name]
+	stored, exists := m.domains[ns][d.Name]
+
+	if expectedVersion >= 0 {
+		for name, other := range m.domains[ns] {
+			for _, host := range d.Hosts {
+				if name != d.Name && slices.Contains(other.Hosts, host) {
+					return 0, &store.HostConflictError{Host: host, Domain: name}
+				}
+			}
+		}
+	}
+
+	if noop := store.SkipNoopFromContext(ctx); noop != nil && expectedVersion != 0 {
+		a, _ := json.Marshal(stored)
+		b, _ := json.Marshal(d)
+		noop.Skipped = exists && bytes.Equal(a, b) && (expectedVersion < 0 || currentRV == expectedVersion)
+		if noop.Skipped {
+			return m.revision, nil
+		}
+	}
 
 	if expectedVersion == 0 {
 		if exists {
@@ -101,14 +309,17 @@ func (m *mockStore) PutDomain(_ context.Context, ns string, d *model.DomainConfi
 	}
 
 	m.domains[ns][d.Name] = d
+	m.modified["domain/"+ns+"/"+d.Name] = time.Now()
+	m.operators["domain/"+ns+"/"+d.Name] = operator
 	m.revision++
 	m.changes = append(m.changes, store.ChangeEvent{Revision: m.revision, Kind: "domain", Name: d.Name, Action: action, Domain: d})
-	m.auditLog = append(m.auditLog, store.AuditEntry{Revision: m.revision, Kind: "domain", Name: d.Name, Action: action, Operator: operator, Timestamp: time.Now()})
+	m.auditLog = append(m.auditLog, store.AuditEntry{Revision: m.revision, Kind: "domain", Name: d.Name, Action: action, Operator: operator,
+		Reason: store.ChangeReasonFromContext(ctx), Timestamp: time.Now()})
 	return m.revision, nil
 }
 
 func (m *mockStore) DeleteDomain(_ context.Context, region, name, operator string) (int64, error) {
-	if nsm, ok := m.domains[ns]; ok {
+	if nsm, ok := m.domains[region]; ok {
 		if _, exists := nsm[name]; exists {
 			delete(nsm, name)
 			m.revision++
@@ -127,10 +338,10 @@ func (m *mockStore) ListClusters(_ context.Context, ns string) ([]model.ClusterC
 }
 
 func (m *mockStore) GetCluster(_ context.Context, region, name string) (*model.ClusterConfig, int64, error) {
-	if nsm, ok := m.clusters[ns]; ok {
+	if nsm, ok := m.clusters[region]; ok {
 		if c, exists := nsm[name]; exists {
 			rv := int64(1)
-			if rvm, ok := m.clusterRVs[ns]; ok {
+			if rvm, ok := m.clusterRVs[region]; ok {
 				if r, ok := rvm[name]; ok {
 					rv = r
 				}
@@ -141,6 +352,34 @@ func (m *mockStore) GetCluster(_ context.Context, region, name string) (*model.C
 	return nil, 0, nil
 }
 
+func (m *mockStore) GetClusterWithMeta(ctx context.Context, region, name string) (*model.ClusterConfig, *store.ResourceMeta, error) {
+	c, rv, err := m.GetCluster(ctx, region, name)
+	if c == nil {
+		return nil, nil, err
+	}
+	key := "cluster/" + region + "/" + name
+	return c, &store.ResourceMeta{ResourceVersion: rv, UpdatedAt: m.modified[key], LastOperator: m.operators[key]}, nil
+}
+func (m *mockStore) ListClustersWithMeta(ctx context.Context, region string, selector map[string]string) ([]model.ClusterConfig, []store.ResourceMeta, error) {
+	items, _ := m.ListClustersByLabels(ctx, region, selector)
+	metas := make([]store.ResourceMeta, len(items))
+	for i := range items {
+		_, meta, _ := m.GetClusterWithMeta(ctx, region, items[i].Name)
+		metas[i] = *meta
+	}
+	return items, metas, nil
+}
+
+func (m *mockStore) ListClustersByLabels(ctx context.Context, region string, selector map[string]string) ([]model.ClusterConfig, error) {
+	all, _ := m.ListClusters(ctx, region)
+	var result []model.ClusterConfig
+	for _, c := range all {
+		if model.MatchLabels(c.Labels, selector) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
 func (m *mockStore) PutCluster(_ context.Context, ns string, c *model.ClusterConfig, action, operator string, expectedVersion int64) (int64, error) {
 	if m.clusters[ns] == nil {
 		m.clusters[ns] = make(map[string]*model.ClusterConfig)
@@ -167,12 +406,14 @@ func (m *mockStore) PutCluster(_ context.Context, ns string, c *model.ClusterCon
 	}
 
 	m.clusters[ns][c.Name] = c
+	m.modified["cluster/"+ns+"/"+c.Name] = time.Now()
+	m.operators["cluster/"+ns+"/"+c.Name] = operator
 	m.revision++
 	return m.revision, nil
 }
 
 func (m *mockStore) DeleteCluster(_ context.Context, region, name, operator string) (int64, error) {
-	if nsm, ok := m.clusters[ns]; ok {
+	if nsm, ok := m.clusters[region]; ok {
 		if _, exists := nsm[name]; exists {
 			delete(nsm, name)
 			m.revision++
@@ -182,7 +423,54 @@ func (m *mockStore) DeleteCluster(_ context.Context, region, name, operator stri
 	return 0, &notFoundError{name}
 }
 
-func (m *mockStore) PutAllConfig(_ context.Context, ns string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string) (int64, error) {
+func (m *mockStore) DeleteDomains(_ context.Context, ns string, names []string, operator string) ([]store.BulkDeleteResult, error) {
+	var results []store.BulkDeleteResult
+	for _, name := range names {
+		if _, ok := m.domains[ns][name]; !ok {
+			results = append(results, store.BulkDeleteResult{Name: name, Error: fmt.Sprintf("domain %q not found", name)})
+			continue
+		}
+		delete(m.domains[ns], name)
+		m.revision++
+		results = append(results, store.BulkDeleteResult{Name: name, Deleted: true, Version: m.revision})
+	}
+	return results, nil
+}
+func (m *mockStore) DeleteClusters(_ context.Context, ns string, names []string, operator string) ([]store.BulkDeleteResult, error) {
+	var results []store.BulkDeleteResult
+	for _, name := range names {
+		if _, ok := m.clusters[ns][name]; !ok {
+			results = append(results, store.BulkDeleteResult{Name: name, Error: fmt.Sprintf("cluster %q not found", name)})
+			continue
+		}
+		delete(m.clusters[ns], name)
+		m.revision++
+		results = append(results, store.BulkDeleteResult{Name: name, Deleted: true, Version: m.revision})
+	}
+	return results, nil
+}
+
+func (m *mockStore) DeleteOrphanClusters(_ context.Context, region, operator string) ([]string, error) {
+	var domains []model.DomainConfig
+	for _, d := range m.domains[region] {
+		domains = append(domains, *d)
+	}
+	referenced := referencedClusters(domains)
+	var deleted []string
+	for _, name := range slices.Sorted(maps.Keys(m.clusters[region])) {
+		if !referenced[name] {
+			delete(m.clusters[region], name)
+			m.revision++
+			deleted = append(deleted, name)
+		}
+	}
+	return deleted, nil
+}
+
+func (m *mockStore) PutAllConfig(_ context.Context, ns string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string, expectedRevision int64) (int64, error) {
+	if expectedRevision >= 0 && expectedRevision != m.revision {
+		return 0, store.ErrConflict
+	}
 	m.domains[ns] = make(map[string]*model.DomainConfig)
 	for i := range domains {
 		m.domains[ns][domains[i].Name] = &domains[i]
@@ -195,6 +483,46 @@ func (m *mockStore) PutAllConfig(_ context.Context, ns string, domains []model.D
 	return m.revision, nil
 }
 
+// ApplyBatch applies ops one by one; unlike PgStore it does not undo the
+// ops before a failing one.
+func (m *mockStore) ApplyBatch(ctx context.Context, ns string, ops []store.BatchOp, operator string) ([]store.BatchResult, error) {
+	results := make([]store.BatchResult, 0, len(ops))
+	for i, op := range ops {
+		res := store.BatchResult{Op: op.Op, Kind: op.Kind, Name: op.Name}
+		var err error
+		switch {
+		case op.Op == "put" && op.Domain != nil:
+			res.Name = op.Domain.Name
+			res.Version, err = m.PutDomain(ctx, ns, op.Domain, "update", operator, op.ExpectedVersion)
+		case op.Op == "put" && op.Cluster != nil:
+			res.Name = op.Cluster.Name
+			res.Version, err = m.PutCluster(ctx, ns, op.Cluster, "update", operator, op.ExpectedVersion)
+		case op.Op == "delete" && op.Kind == "domain":
+			res.Version, err = m.DeleteDomain(ctx, ns, op.Name, operator)
+		case op.Op == "delete" && op.Kind == "cluster":
+			res.Version, err = m.DeleteCluster(ctx, ns, op.Name, operator)
+		default:
+			err = fmt.Errorf("invalid %s %s", op.Kind, op.Op)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("batch op %d: %w", i, err)
+		}
+		results = append(results, res)
+	}
+	return results, nil
+}
+
+func (m *mockStore) ConfigRevision(_ context.Context, ns string) (int64, error) {
+	return m.revision, nil
+}
+
+func (m *mockStore) ConfigAtRevision(_ context.Context, ns string, revision int64) (*model.GatewayConfig, error) {
+	if cfg := m.configAt[revision]; cfg != nil {
+		return cfg, nil
+	}
+	return &model.GatewayConfig{}, nil
+}
+
 func (m *mockStore) GetConfig(_ context.Context, ns string) (*model.GatewayConfig, error) {
 	cfg := &model.GatewayConfig{}
 	for _, d := range m.domains[ns] {
@@ -206,6 +534,27 @@ func (m *mockStore) GetConfig(_ context.Context, ns string) (*model.GatewayConfi
 	return cfg, nil
 }
 
+func (m *mockStore) GetConfigByLabels(ctx context.Context, ns string, selector map[string]string) (*model.GatewayConfig, error) {
+	cfg, _ := m.GetConfig(ctx, ns)
+	return cfg.SelectLabels(selector), nil
+}
+
+func (m *mockStore) StreamConfig(_ context.Context, ns string, fn func(kind string, config json.RawMessage) error) error {
+	for _, name := range slices.Sorted(maps.Keys(m.domains[ns])) {
+		data, _ := json.Marshal(m.domains[ns][name])
+		if err := fn("domain", data); err != nil {
+			return err
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(m.clusters[ns])) {
+		data, _ := json.Marshal(m.clusters[ns][name])
+		if err := fn("cluster", data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (m *mockStore) GetDomainHistory(_ context.Context, region, name string) ([]store.HistoryEntry, error) {
 	return nil, nil
 }
@@ -227,31 +576,274 @@ func (m *mockStore) RollbackCluster(_ context.Context, region, name string, vers
 	return m.revision, nil
 }
 
-func (m *mockStore) ListAuditLog(_ context.Context, ns string, limit, offset int) ([]store.AuditEntry, int64, error) {
-	return m.auditLog, int64(len(m.auditLog)), nil
+func (m *mockStore) ListAuditLog(_ context.Context, ns string, since time.Time, before int64, limit, offset int) ([]store.AuditEntry, int64, error) {
+	if limit <= 0 || limit > store.MaxListLimit {
+		limit = 50
+	}
+	var total int64
+	var entries []store.AuditEntry
+	for _, e := range slices.Backward(m.auditLog) {
+		if e.Timestamp.Before(since) {
+			continue
+		}
+		total++
+		if before == 0 || e.Revision < before {
+			entries = append(entries, e)
+		}
+	}
+	entries = entries[min(offset, len(entries)):]
+	if limit > 0 && limit < len(entries) {
+		entries = entries[:limit]
+	}
+	return entries, total, nil
+}
+func (m *mockStore) ListHistory(_ context.Context, ns string, f store.HistoryFilter, limit, offset int) ([]store.HistoryEntry, int64, error) {
+	var entries []store.HistoryEntry
+	for _, e := range m.history {
+		if (f.Kind != "" && e.Kind != f.Kind) || (f.Name != "" && e.Name != f.Name) || (f.Operator != "" && e.Operator != f.Operator) ||
+			e.Timestamp.Before(f.Since) || (!f.Until.IsZero() && !e.Timestamp.Before(f.Until)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return paginate(entries, page{limit, offset}), int64(len(entries)), nil
+}
+func (m *mockStore) ArchiveChangeLog(_ context.Context, olderThan time.Duration, batchSize int) (int64, error) {
+	return 0, nil
+}
+func (m *mockStore) OldestRetainedChange(_ context.Context, ns string) (int64, time.Time, error) {
+	if len(m.auditLog) == 0 {
+		return 0, time.Time{}, nil
+	}
+	return m.auditLog[0].Revision, m.auditLog[0].Timestamp, nil
 }
+func (m *mockStore) PurgeHistory(_ context.Context, ns, kind, name, operator string) (int64, error) {
+	if (kind == "domain" && m.domains[ns][name] != nil) || (kind == "cluster" && m.clusters[ns][name] != nil) {
+		return 0, store.ErrResourceExists
+	}
+	var purged int64
+	m.history = slices.DeleteFunc(m.history, func(e store.HistoryEntry) bool {
+		match := e.Kind == kind && e.Name == name
+		if match {
+			purged++
+		}
+		return match
+	})
+	m.changes = slices.DeleteFunc(m.changes, func(e store.ChangeEvent) bool {
+		match := e.Kind == kind && e.Name == name
+		if match {
+			purged++
+		}
+		return match
+	})
+	if purged > 0 {
+		m.auditLog = append(m.auditLog, store.AuditEntry{Kind: "history", Name: kind + "/" + name, Action: "purge", Operator: operator, Timestamp: time.Now()})
+		m.revision++
+		m.changes = append(m.changes, store.ChangeEvent{Revision: m.revision, Kind: "sync", Action: "reconcile", Operator: operator})
+	}
+	return purged, nil
+}
+
 func (m *mockStore) InsertAuditLog(_ context.Context, region, kind, name, action, operator string) error {
 	m.auditLog = append(m.auditLog, store.AuditEntry{Kind: kind, Name: name, Action: action, Operator: operator, Timestamp: time.Now()})
 	return nil
 }
 
 func (m *mockStore) CurrentRevision(_ context.Context, ns string) (int64, error) {
+	return m.revision + m.auditRevisions, nil
+}
+func (m *mockStore) TriggerSync(_ context.Context, region, operator string) (int64, error) {
+	m.revision++
+	m.changes = append(m.changes, store.ChangeEvent{Revision: m.revision, Kind: "sync", Action: "reconcile", Operator: operator})
 	return m.revision, nil
 }
-func (m *mockStore) WatchFrom(_ context.Context, ns string, sinceRevision int64) ([]store.ChangeEvent, int64, error) {
+func (m *mockStore) WatchFrom(_ context.Context, ns string, sinceRevision int64, kinds []string) ([]store.ChangeEvent, int64, error) {
 	var events []store.ChangeEvent
+	var maxRev int64
 	for _, e := range m.changes {
-		if e.Revision > sinceRevision {
+		if e.Revision <= sinceRevision {
+			continue
+		}
+		maxRev = max(maxRev, e.Revision)
+		if len(kinds) == 0 || e.Kind == "sync" || slices.Contains(kinds, e.Kind) {
 			events = append(events, e)
 		}
 	}
-	return events, m.revision, nil
+	return events, maxRev, nil
+}
+
+func (m *mockStore) ConfigChangesSince(_ context.Context, ns string, sinceRevision int64) ([]store.ConfigChange, error) {
+	existed := map[string]bool{}
+	latest := map[string]store.ChangeEvent{}
+	var order []string
+	for _, e := range m.changes {
+		if e.Kind != "domain" && e.Kind != "cluster" {
+			continue
+		}
+		key := e.Kind + "/" + e.Name
+		if e.Revision <= sinceRevision {
+			existed[key] = e.Action != "delete"
+			continue
+		}
+		if _, ok := latest[key]; !ok {
+			order = append(order, key)
+		}
+		latest[key] = e
+	}
+	var result []store.ConfigChange
+	for _, key := range order {
+		e := latest[key]
+		c := store.ConfigChange{Kind: e.Kind, Name: e.Name, Revision: e.Revision, Operator: e.Operator}
+		switch {
+		case e.Action == "delete" && !existed[key]:
+			continue
+		case e.Action == "delete":
+			c.Change = store.ChangeDeleted
+		case existed[key]:
+			c.Change = store.ChangeUpdated
+		default:
+			c.Change = store.ChangeCreated
+		}
+		if c.Change != store.ChangeDeleted {
+			if e.Kind == "domain" {
+				c.Domain = m.domains[ns][e.Name]
+			} else {
+				c.Cluster = m.clusters[ns][e.Name]
+			}
+		}
+		result = append(result, c)
+	}
+	return result, nil
 }
 
 func (m *mockStore) ListRegions(_ context.Context) ([]string, error) {
 	return []string{"default"}, nil
 }
 func (m *mockStore) CreateRegion(_ context.Context, name string) error { return nil }
+func (m *mockStore) GetRegionSettings(_ context.Context, region string) (*store.RegionSettings, error) {
+	if st, ok := m.settings[region]; ok {
+		cp := *st
+		return &cp, nil
+	}
+	return nil, nil
+}
+func (m *mockStore) CreateScheduledChange(_ context.Context, region string, c *store.ScheduledChange) (int64, error) {
+	cp := *c
+	cp.ID = int64(len(m.scheduled) + 1)
+	cp.Region = region
+	cp.Status = store.ScheduledPending
+	m.scheduled = append(m.scheduled, cp)
+	return cp.ID, nil
+}
+func (m *mockStore) ListScheduledChanges(_ context.Context, region, status string) ([]store.ScheduledChange, error) {
+	var result []store.ScheduledChange
+	for _, c := range m.scheduled {
+		if c.Region == region && (status == "" || c.Status == status) {
+			result = append(result, c)
+		}
+	}
+	return result, nil
+}
+func (m *mockStore) CancelScheduledChange(_ context.Context, region string, id int64) (bool, error) {
+	for i := range m.scheduled {
+		if c := &m.scheduled[i]; c.ID == id && c.Region == region && c.Status == store.ScheduledPending {
+			c.Status = store.ScheduledCancelled
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockStore) ClaimDueScheduledChanges(_ context.Context, limit int) ([]store.ScheduledChange, error) {
+	var result []store.ScheduledChange
+	for i := range m.scheduled {
+		if c := &m.scheduled[i]; c.Status == store.ScheduledPending && !c.ApplyAt.After(time.Now()) && len(result) < limit {
+			c.Status = store.ScheduledApplying
+			result = append(result, *c)
+		}
+	}
+	return result, nil
+}
+func (m *mockStore) FinishScheduledChange(_ context.Context, id int64, applyErr string) error {
+	c := &m.scheduled[id-1]
+	c.Status, c.Error = store.ScheduledApplied, applyErr
+	if applyErr != "" {
+		c.Status = store.ScheduledFailed
+	}
+	return nil
+}
+func (m *mockStore) IsRegionFrozen(_ context.Context, region string) (bool, error) {
+	return m.frozen[region], nil
+}
+func (m *mockStore) SetRegionFrozen(_ context.Context, region string, frozen bool) (bool, error) {
+	if _, ok := m.settings[region]; !ok {
+		return false, nil
+	}
+	m.frozen[region] = frozen
+	return true, nil
+}
+func (m *mockStore) GetConfigCanary(_ context.Context, region string) (*store.ConfigCanary, error) {
+	return m.canaries[region], nil
+}
+func (m *mockStore) PutConfigCanary(_ context.Context, region string, c *store.ConfigCanary) error {
+	cp := *c
+	m.canaries[region] = &cp
+	return nil
+}
+func (m *mockStore) DeleteConfigCanary(_ context.Context, region string) (bool, error) {
+	_, ok := m.canaries[region]
+	delete(m.canaries, region)
+	return ok, nil
+}
+func (m *mockStore) PromoteConfigCanary(ctx context.Context, region string, c *store.ConfigCanary, operator string) (int64, error) {
+	running := m.canaries[region]
+	if running == nil || !running.CreatedAt.Equal(c.CreatedAt) || m.revision != c.BaseRevision {
+		return 0, store.ErrConflict
+	}
+	delete(m.canaries, region)
+	return m.PutAllConfig(ctx, region, c.Config.Domains, c.Config.Clusters, operator, -1)
+}
+func (m *mockStore) GetRegionTargets(_ context.Context, region string) ([]store.FanoutTarget, bool, error) {
+	if _, ok := m.settings[region]; !ok {
+		return nil, false, nil
+	}
+	return m.targets[region], true, nil
+}
+func (m *mockStore) SetRegionTargets(_ context.Context, region string, targets []store.FanoutTarget) (bool, error) {
+	if _, ok := m.settings[region]; !ok {
+		return false, nil
+	}
+	m.targets[region] = targets
+	return true, nil
+}
+func (m *mockStore) PutRegionSettings(_ context.Context, region string, st *store.RegionSettings) error {
+	if _, ok := m.settings[region]; !ok {
+		return fmt.Errorf("region %q not found", region)
+	}
+	cp := *st
+	m.settings[region] = &cp
+	return nil
+}
+func (m *mockStore) GetMaintenance(_ context.Context) (*store.Maintenance, error) {
+	cp := m.maintenance
+	return &cp, nil
+}
+func (m *mockStore) SetMaintenance(_ context.Context, mt *store.Maintenance) error {
+	mt.UpdatedAt = time.Now()
+	m.maintenance = *mt
+	return nil
+}
+func (m *mockStore) MaintainTables(_ context.Context, reindex bool) ([]store.TableMaintenance, error) {
+	if m.maintaining {
+		return nil, store.ErrMaintenanceRunning
+	}
+	return []store.TableMaintenance{{Table: "domains", Reindexed: reindex}, {Table: "clusters", Reindexed: reindex}}, nil
+}
+func (m *mockStore) CountDomains(_ context.Context, region string) (int, error) {
+	return len(m.domains[region]), nil
+}
+func (m *mockStore) CountClusters(_ context.Context, region string) (int, error) {
+	return len(m.clusters[region]), nil
+}
 
 func (m *mockStore) UpsertGatewayInstances(_ context.Context, ns string, instances []store.GatewayInstanceStatus) error {
 	m.instances[ns] = instances
@@ -267,6 +859,40 @@ func (m *mockStore) UpsertControllerStatus(_ context.Context, ns string, ctrl *s
 func (m *mockStore) GetControllerStatus(_ context.Context, ns string) (*store.ControllerStatus, error) {
 	return m.ctrl[ns], nil
 }
+func (m *mockStore) DeleteGatewayInstance(_ context.Context, region, id string) (bool, error) {
+	for i, inst := range m.instances[region] {
+		if inst.ID == id {
+			m.instances[region] = append(m.instances[region][:i], m.instances[region][i+1:]...)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+func (m *mockStore) ListInstanceRevisionHistory(_ context.Context, region, id string) ([]store.InstanceRevisionEvent, error) {
+	return m.revHistory[region+"/"+id], nil
+}
+func (m *mockStore) ListLeadershipEvents(_ context.Context, region string) ([]store.LeadershipEvent, error) {
+	return m.elections[region], nil
+}
+func (m *mockStore) ListRegionStatus(ctx context.Context) ([]store.RegionStatusSummary, error) {
+	regions, _ := m.ListRegions(ctx)
+	var result []store.RegionStatusSummary
+	for _, region := range regions {
+		rev, _ := m.ConfigRevision(ctx, region)
+		sum := store.RegionStatusSummary{Region: region, ConfigRevision: rev, Controller: m.ctrl[region]}
+		for _, inst := range m.instances[region] {
+			sum.Instances++
+			if inst.Status != "offline" {
+				sum.InstancesOnline++
+				if inst.ConfigRevision >= rev {
+					sum.InstancesInSync++
+				}
+			}
+		}
+		result = append(result, sum)
+	}
+	return result, nil
+}
 func (m *mockStore) MarkStaleInstances(_ context.Context, threshold time.Duration) ([]store.StaleEntry, error) {
 	return nil, nil
 }
@@ -311,8 +937,51 @@ func (m *mockStore) CreateAPICredential(_ context.Context, ns string, cred *stor
 	return cred, nil
 }
 func (m *mockStore) UpdateAPICredential(_ context.Context, ns string, cred *store.APICredential) error {
+	for i, c := range m.creds[ns] {
+		if c.ID == cred.ID {
+			m.creds[ns][i].Description = cred.Description
+			m.creds[ns][i].Scopes = cred.Scopes
+			m.creds[ns][i].Enabled = cred.Enabled
+		}
+	}
 	return nil
 }
+func (m *mockStore) SetAPICredentialsEnabled(_ context.Context, ns string, filter store.CredentialFilter, enabled bool, operator string) ([]store.APICredential, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
+	var changed []store.APICredential
+	for i := range m.creds[ns] {
+		c := &m.creds[ns][i]
+		if c.Enabled != enabled && match(c) {
+			c.Enabled = enabled
+			changed = append(changed, *c)
+			m.auditLog = append(m.auditLog, store.AuditEntry{Kind: "credential", Name: strconv.FormatInt(c.ID, 10),
+				Action: map[bool]string{true: "enable", false: "disable"}[enabled], Operator: operator, Timestamp: time.Now()})
+		}
+	}
+	return changed, nil
+}
+func (m *mockStore) SealAPICredentialSecrets(_ context.Context, seal func(string) (string, error)) (int, error) {
+	n := 0
+	for ns := range m.creds {
+		for i := range m.creds[ns] {
+			c := &m.creds[ns][i]
+			if secretbox.IsSealed(c.SecretKey) {
+				continue
+			}
+			sealed, err := seal(c.SecretKey)
+			if err != nil {
+				return 0, err
+			}
+			c.SecretKey = sealed
+			m.credsByAK[c.AccessKey].SecretKey = sealed
+			n++
+		}
+	}
+	return n, nil
+}
 func (m *mockStore) DeleteAPICredential(_ context.Context, ns string, id int64) error {
 	var filtered []store.APICredential
 	for _, c := range m.creds[ns] {
@@ -324,64 +993,381 @@ func (m *mockStore) DeleteAPICredential(_ context.Context, ns string, id int64)
 	return nil
 }
 
-func (m *mockStore) UpsertUser(_ context.Context, user *store.User) error { return nil }
+func (m *mockStore) ListServiceAccounts(_ context.Context, ns string) ([]store.ServiceAccount, error) {
+	return m.svcAccounts[ns], nil
+}
+func (m *mockStore) GetServiceAccountByTokenHash(_ context.Context, tokenHash string) (*store.ServiceAccount, error) {
+	return m.svcByHash[tokenHash], nil
+}
+func (m *mockStore) CreateServiceAccount(_ context.Context, ns string, sa *store.ServiceAccount, tokenHash string) (*store.ServiceAccount, error) {
+	for _, existing := range m.svcAccounts[ns] {
+		if existing.Name == sa.Name {
+			return nil, store.ErrConflict
+		}
+	}
+	sa.ID = m.nextID
+	m.nextID++
+	sa.Region = ns
+	m.svcAccounts[ns] = append(m.svcAccounts[ns], *sa)
+	stored := *sa
+	m.svcByHash[tokenHash] = &stored
+	return sa, nil
+}
+func (m *mockStore) DeleteServiceAccount(_ context.Context, ns string, id int64) error {
+	var filtered []store.ServiceAccount
+	for _, sa := range m.svcAccounts[ns] {
+		if sa.ID != id {
+			filtered = append(filtered, sa)
+		}
+	}
+	m.svcAccounts[ns] = filtered
+	for hash, sa := range m.svcByHash {
+		if sa.ID == id && sa.Region == ns {
+			delete(m.svcByHash, hash)
+		}
+	}
+	return nil
+}
+
+func (m *mockStore) UpsertUser(_ context.Context, user *store.User) error {
+	if existing, ok := m.users[user.Sub]; ok {
+		u := *user
+		u.IsAdmin = existing.IsAdmin
+		u.Enabled = existing.Enabled
+		u.SessionsValidAfter = existing.SessionsValidAfter
+		m.users[user.Sub] = &u
+		return nil
+	}
+	u := *user
+	u.Enabled = true // column default
+	m.users[user.Sub] = &u
+	return nil
+}
 func (m *mockStore) GetUser(_ context.Context, sub string) (*store.User, error) {
-	return nil, nil
+	if m.userErr != nil {
+		return nil, m.userErr
+	}
+	return m.users[sub], nil
 }
 func (m *mockStore) ListUsers(_ context.Context) ([]store.User, error) { return nil, nil }
 func (m *mockStore) SetUserAdmin(_ context.Context, sub string, isAdmin bool) error {
+	if u := m.users[sub]; u != nil {
+		u.IsAdmin = isAdmin
+	}
 	return nil
 }
 func (m *mockStore) GetUserPasswordHash(_ context.Context, sub string) (string, error) {
-	return "", nil
+	return m.passwords[sub], nil
 }
 func (m *mockStore) UpdateUserPassword(_ context.Context, sub, passwordHash string) error {
+	m.passwords[sub] = passwordHash
 	return nil
 }
 func (m *mockStore) SetMustChangePassword(_ context.Context, sub string, must bool) error {
+	if u := m.users[sub]; u != nil {
+		u.MustChangePassword = must
+	}
+	return nil
+}
+func (m *mockStore) SetUserEnabled(_ context.Context, sub string, enabled bool) error {
+	u := m.users[sub]
+	if u == nil {
+		return fmt.Errorf("user not found")
+	}
+	u.Enabled = enabled
+	return nil
+}
+func (m *mockStore) RevokeUserSessions(_ context.Context, sub string) (time.Time, error) {
+	u := m.users[sub]
+	if u == nil {
+		return time.Time{}, fmt.Errorf("user not found")
+	}
+	now := time.Now()
+	u.SessionsValidAfter = &now
+	for _, t := range m.refresh {
+		if t.UserSub == sub {
+			t.Revoked = true
+		}
+	}
+	return now, nil
+}
+func (m *mockStore) GetUserTOTP(_ context.Context, sub string) (string, bool, error) {
+	t := m.totp[sub]
+	return t.sealed, t.enabled, nil
+}
+func (m *mockStore) SetUserTOTP(_ context.Context, sub, sealedSecret string, enabled bool) error {
+	if m.users[sub] == nil {
+		return &notFoundError{name: "user"}
+	}
+	t := m.totp[sub]
+	t.sealed, t.enabled = sealedSecret, enabled
+	m.totp[sub] = t
+	return nil
+}
+func (m *mockStore) ClaimTOTPStep(_ context.Context, sub string, step int64) (bool, error) {
+	t := m.totp[sub]
+	if step <= t.lastStep {
+		return false, nil
+	}
+	t.lastStep = step
+	m.totp[sub] = t
+	return true, nil
+}
+func (m *mockStore) ListPasswordHistory(_ context.Context, sub string, limit int) ([]string, error) {
+	h := m.pwHistory[sub]
+	if len(h) > limit {
+		h = h[:limit]
+	}
+	return h, nil
+}
+func (m *mockStore) AddPasswordHistory(_ context.Context, sub, passwordHash string, keep int) error {
+	h := append([]string{passwordHash}, m.pwHistory[sub]...)
+	if len(h) > keep {
+		h = h[:keep]
+	}
+	m.pwHistory[sub] = h
 	return nil
 }
 func (m *mockStore) DeleteUser(_ context.Context, sub string) error {
 	return nil
 }
 func (m *mockStore) GetActiveSigningKey(_ context.Context) (*store.JWTSigningKey, error) {
-	return nil, nil
+	return m.signingKey, nil
 }
-func (m *mockStore) GetSigningKeyByID(_ context.Context, kid string) (*store.JWTSigningKey, error) {
+func (m *mockStore) GetSigningKeyByID(ctx context.Context, kid string) (*store.JWTSigningKey, error) {
+	keys, _ := m.ListValidSigningKeys(ctx)
+	for i := range keys {
+		if keys[i].KID == kid {
+			return &keys[i], nil
+		}
+	}
 	return nil, nil
 }
 func (m *mockStore) ListValidSigningKeys(_ context.Context) ([]store.JWTSigningKey, error) {
-	return nil, nil
+	var keys []store.JWTSigningKey
+	if m.signingKey != nil {
+		keys = append(keys, *m.signingKey)
+	}
+	for _, k := range m.retiredKeys {
+		if k.ExpiresAt.After(time.Now()) {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
 }
 func (m *mockStore) CreateSigningKey(_ context.Context, key *store.JWTSigningKey) error {
+	m.signingKey = key
 	return nil
 }
-func (m *mockStore) RotateSigningKey(_ context.Context, gracePeriod time.Duration) (*store.JWTSigningKey, error) {
-	return &store.JWTSigningKey{KID: "mock-kid"}, nil
+func (m *mockStore) RotateSigningKey(_ context.Context, alg string, gracePeriod time.Duration) (*store.JWTSigningKey, error) {
+	m.lastGrace = gracePeriod
+	return m.rotateSigningKey(alg, gracePeriod)
 }
-
-func (m *mockStore) ListRegionMembers(_ context.Context, ns string) ([]store.RegionMember, error) {
-	return nil, nil
+func (m *mockStore) RotateSigningKeyIfOlder(_ context.Context, alg string, maxAge, gracePeriod time.Duration) (*store.JWTSigningKey, error) {
+	if m.signingKey != nil && time.Since(m.signingKey.CreatedAt) < maxAge {
+		return nil, nil
+	}
+	m.lastGrace = gracePeriod
+	return m.rotateSigningKey(alg, gracePeriod)
 }
-func (m *mockStore) GetRegionMember(_ context.Context, region, userSub string) (*store.RegionMember, error) {
-	return nil, nil
+func (m *mockStore) rotateSigningKey(alg string, gracePeriod time.Duration) (*store.JWTSigningKey, error) {
+	key, err := store.NewSigningKey(alg)
+	if err != nil {
+		return nil, err
+	}
+	if m.signingKey != nil {
+		exp := time.Now().Add(gracePeriod)
+		old := *m.signingKey
+		old.Status, old.ExpiresAt = "retired", &exp
+		m.retiredKeys = append(m.retiredKeys, old)
+	}
+	m.signingKey = key
+	return key, nil
 }
-func (m *mockStore) SetRegionMember(_ context.Context, region, userSub string, role store.RegionRole) error {
+
+func (m *mockStore) CreateOIDCAuthState(_ context.Context, st *store.OIDCAuthState) error {
+	m.authStates[st.State] = st
 	return nil
 }
-func (m *mockStore) RemoveRegionMember(_ context.Context, region, userSub string) error {
-	return nil
+func (m *mockStore) ConsumeOIDCAuthState(_ context.Context, state string) (*store.OIDCAuthState, error) {
+	st := m.authStates[state]
+	delete(m.authStates, state)
+	if st == nil || time.Now().After(st.ExpiresAt) {
+		return nil, nil
+	}
+	return st, nil
 }
 
-func (m *mockStore) ListGroupBindings(_ context.Context, ns string) ([]store.GroupBinding, error) {
-	return nil, nil
-}
-func (m *mockStore) SetGroupBinding(_ context.Context, region, group string, role store.RegionRole) error {
+func (m *mockStore) CreateRefreshToken(_ context.Context, tok *store.RefreshToken) error {
+	t := *tok
+	m.refresh[tok.TokenHash] = &t
 	return nil
 }
-func (m *mockStore) RemoveGroupBinding(_ context.Context, region, group string) error { return nil }
-func (m *mockStore) GetEffectiveRoleByGroups(_ context.Context, ns string, groups []string) (*store.RegionRole, error) {
-	return nil, nil
+func (m *mockStore) ConsumeRefreshToken(_ context.Context, tokenHash string) (*store.RefreshToken, bool, error) {
+	t := m.refresh[tokenHash]
+	if t == nil {
+		return nil, false, nil
+	}
+	cp := *t
+	if t.UsedAt != nil {
+		return &cp, true, nil
+	}
+	now := time.Now()
+	t.UsedAt = &now
+	return &cp, false, nil
+}
+func (m *mockStore) RevokeRefreshTokenFamily(_ context.Context, familyID string) error {
+	for _, t := range m.refresh {
+		if t.FamilyID == familyID {
+			t.Revoked = true
+		}
+	}
+	return nil
+}
+
+func (m *mockStore) GetLoginLockout(_ context.Context, email string) (time.Time, error) {
+	return m.lockouts[email], nil
+}
+func (m *mockStore) RecordLoginFailure(_ context.Context, email string, window time.Duration) (int, error) {
+	m.failures[email]++
+	return m.failures[email], nil
+}
+func (m *mockStore) LockLogin(_ context.Context, email string, until time.Time) error {
+	m.lockouts[email] = until
+	m.failures[email] = 0
+	return nil
+}
+func (m *mockStore) ResetLoginFailures(_ context.Context, email string) error {
+	delete(m.failures, email)
+	delete(m.lockouts, email)
+	return nil
+}
+
+func (m *mockStore) ReserveIdempotencyKey(_ context.Context, region, route, key, fingerprint string, _ time.Duration) (*store.IdempotencyRecord, bool, error) {
+	k := region + "/" + route + "/" + key
+	if rec, ok := m.idempotency[k]; ok {
+		cp := *rec
+		return &cp, false, nil
+	}
+	m.idempotency[k] = &store.IdempotencyRecord{Fingerprint: fingerprint, CreatedAt: time.Now()}
+	return nil, true, nil
+}
+func (m *mockStore) CompleteIdempotencyKey(_ context.Context, region, route, key string, rec *store.IdempotencyRecord, _ time.Duration) error {
+	if cur, ok := m.idempotency[region+"/"+route+"/"+key]; ok {
+		cur.Status, cur.ContentType, cur.Body = rec.Status, rec.ContentType, rec.Body
+	}
+	return nil
+}
+func (m *mockStore) ReleaseIdempotencyKey(_ context.Context, region, route, key string) error {
+	delete(m.idempotency, region+"/"+route+"/"+key)
+	return nil
+}
+
+func (m *mockStore) ListRegionMembers(_ context.Context, ns string) ([]store.RegionMember, error) {
+	return nil, nil
+}
+func (m *mockStore) GetRegionMember(_ context.Context, region, userSub string) (*store.RegionMember, error) {
+	role, ok := m.members[region+"/"+userSub]
+	if !ok {
+		return nil, nil
+	}
+	return &store.RegionMember{Region: region, UserSub: userSub, Role: role}, nil
+}
+func (m *mockStore) SetRegionMember(_ context.Context, region, userSub string, role store.RegionRole) error {
+	m.members[region+"/"+userSub] = role
+	return nil
+}
+func (m *mockStore) RemoveRegionMember(_ context.Context, region, userSub string) error {
+	return nil
+}
+
+func (m *mockStore) ListGroupBindings(_ context.Context, ns string) ([]store.GroupBinding, error) {
+	return nil, nil
+}
+func (m *mockStore) SetGroupBinding(_ context.Context, region, group string, role store.RegionRole) error {
+	m.groupRoles[region+"/"+group] = role
+	return nil
+}
+func (m *mockStore) RemoveGroupBinding(_ context.Context, region, group string) error { return nil }
+func (m *mockStore) GetRolesByGroups(_ context.Context, ns string, groups []string) ([]store.RegionRole, error) {
+	var roles []store.RegionRole
+	for _, g := range groups {
+		if role, ok := m.groupRoles[ns+"/"+g]; ok {
+			roles = append(roles, role)
+		}
+	}
+	return roles, nil
+}
+func (m *mockStore) ListCustomRoles(_ context.Context, ns string) ([]store.CustomRole, error) {
+	var roles []store.CustomRole
+	for _, cr := range m.customRoles {
+		if cr.Region == ns {
+			roles = append(roles, *cr)
+		}
+	}
+	return roles, nil
+}
+func (m *mockStore) GetCustomRole(_ context.Context, ns, name string) (*store.CustomRole, error) {
+	return m.customRoles[ns+"/"+name], nil
+}
+func (m *mockStore) PutCustomRole(_ context.Context, role *store.CustomRole) error {
+	cr := *role
+	m.customRoles[role.Region+"/"+role.Name] = &cr
+	return nil
+}
+func (m *mockStore) DeleteCustomRole(_ context.Context, ns, name string) error {
+	for key, role := range m.members {
+		if strings.HasPrefix(key, ns+"/") && string(role) == name {
+			return store.ErrConflict
+		}
+	}
+	for key, role := range m.groupRoles {
+		if strings.HasPrefix(key, ns+"/") && string(role) == name {
+			return store.ErrConflict
+		}
+	}
+	delete(m.customRoles, ns+"/"+name)
+	return nil
+}
+func (m *mockStore) ListSecrets(_ context.Context, ns string) ([]store.Secret, error) {
+	var result []store.Secret
+	for _, sec := range m.secrets {
+		if sec.Region == ns {
+			meta := *sec
+			meta.SealedValue = ""
+			result = append(result, meta)
+		}
+	}
+	return result, nil
+}
+func (m *mockStore) GetSecret(_ context.Context, ns, name string) (*store.Secret, error) {
+	return m.secrets[ns+"/"+name], nil
+}
+func (m *mockStore) PutSecret(_ context.Context, sec *store.Secret) error {
+	cp := *sec
+	m.secrets[sec.Region+"/"+sec.Name] = &cp
+	return nil
+}
+func (m *mockStore) DeleteSecret(_ context.Context, ns, name string) error {
+	if _, ok := m.secrets[ns+"/"+name]; !ok {
+		return fmt.Errorf("secret %q not found", name)
+	}
+	for _, d := range m.domains[ns] {
+		if d.TLS != nil && d.TLS.CertificateRef == name {
+			return store.ErrConflict
+		}
+	}
+	delete(m.secrets, ns+"/"+name)
+	return nil
+}
+func (m *mockStore) GetEffectiveRoleByGroups(_ context.Context, ns string, groups []string) (*store.RegionRole, error) {
+	var best *store.RegionRole
+	for _, g := range groups {
+		if role, ok := m.groupRoles[ns+"/"+g]; ok && (best == nil || store.RolePriority(role) > store.RolePriority(*best)) {
+			best = &role
+		}
+	}
+	return best, nil
 }
 
 type notFoundError struct{ name string }
@@ -416,7 +1402,7 @@ func jsonBody(v any) *bytes.Buffer {
 
 func TestDomainHandler_CreateDomain(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	body := jsonBody(model.DomainConfig{
 		Name:  "api",
@@ -437,9 +1423,205 @@ func TestDomainHandler_CreateDomain(t *testing.T) {
 	assert.Equal(t, float64(1), resp["version"])
 }
 
+func TestReadOnly(t *testing.T) {
+	readOnly := config.NewDynamic(false)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	mw := ReadOnly(readOnly)(ok)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+	ready := func() (int, string) {
+		w := httptest.NewRecorder()
+		Ready(newMockStore(), readOnly)(w, httptest.NewRequest("GET", "/readyz", nil))
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp["status"].(string)
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/domains").Code)
+	code, status := ready()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", status)
+
+	readOnly.Store(true)
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		w := do(method, "/api/v1/domains/api")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, method)
+		assert.Contains(t, w.Body.String(), "read-only")
+	}
+	assert.Equal(t, http.StatusOK, do("POST", "/api/auth/login").Code, "users can still sign in to read")
+	assert.Equal(t, http.StatusOK, do("POST", "/api/auth/refresh").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/auth/change-password").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/domains").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/config/watch").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/config/validate").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/config/lint").Code)
+
+	code, status = ready()
+	assert.Equal(t, http.StatusOK, code, "read-only instances keep serving reads")
+	assert.Equal(t, "degraded", status)
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("::1/128")}
+	tests := []struct {
+		name   string
+		remote string
+		xff    []string
+		want   string
+	}{
+		{"direct", "203.0.113.7:5123", nil, "203.0.113.7"},
+		{"untrusted peer ignores header", "203.0.113.7:5123", []string{"1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.0.0.1:5123", []string{"198.51.100.9"}, "198.51.100.9"},
+		{"spoofed hop left of client", "10.0.0.1:5123", []string{"1.2.3.4, 198.51.100.9"}, "198.51.100.9"},
+		{"proxy chain", "10.0.0.1:5123", []string{"198.51.100.9, 10.0.0.2", "10.0.0.3"}, "198.51.100.9"},
+		{"all trusted", "10.0.0.1:5123", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"malformed hop", "10.0.0.1:5123", []string{"garbage, 10.0.0.2"}, "10.0.0.2"},
+		{"no header", "10.0.0.1:5123", nil, "10.0.0.1"},
+		{"ipv6 proxy", "[::1]:5123", []string{"2001:db8::1"}, "2001:db8::1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remote
+			for _, v := range tt.xff {
+				r.Header.Add("X-Forwarded-For", v)
+			}
+			var got string
+			RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	// Without RealIP the header is never trusted.
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5123"
+	r.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "10.0.0.1", ClientIP(r))
+}
+
+func TestWriteTimeout(t *testing.T) {
+	slow := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		JSON(w, http.StatusOK, map[string]any{"ok": true})
+	})
+	get := func(h http.Handler) error {
+		srv := httptest.NewUnstartedServer(h)
+		srv.Config.WriteTimeout = 50 * time.Millisecond
+		srv.Start()
+		defer srv.Close()
+		resp, err := http.Get(srv.URL)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.ReadAll(resp.Body)
+		return err
+	}
+
+	assert.Error(t, get(slow), "server write timeout cuts the response")
+	assert.NoError(t, get(WriteTimeout(time.Second, testLogger())(slow)))
+	// The deadline is reached through wrapping ResponseWriters.
+	assert.NoError(t, get(Tracing(WriteTimeout(0, testLogger())(slow))))
+}
+
+func TestRequestTimeout(t *testing.T) {
+	// Stands in for a store call that honours ctx.
+	query := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(200 * time.Millisecond):
+			JSON(w, http.StatusOK, map[string]any{"ok": true})
+		case <-r.Context().Done():
+			ErrJSON(w, http.StatusInternalServerError, "pg query: "+r.Context().Err().Error())
+		}
+	})
+	h := RequestTimeout(20*time.Millisecond, map[string]time.Duration{"/api/v1/config/watch": time.Second})(query)
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/api/v1/domains")
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.Equal(t, "request timed out", decodeResp(t, w)["error"])
+	assert.Equal(t, http.StatusOK, get("/api/v1/config/watch").Code, "the watch has its own deadline")
+
+	// Errors before the deadline are left alone.
+	failing := RequestTimeout(time.Second, nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ErrJSON(w, http.StatusInternalServerError, "boom")
+	}))
+	w = httptest.NewRecorder()
+	failing.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestRequestTimeout_StreamOutlivesGlobalDeadline(t *testing.T) {
+	// Stands in for StreamConfig scanning rows that honour ctx.
+	stream := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc := json.NewEncoder(w)
+		for i := 0; i < 5; i++ {
+			select {
+			case <-time.After(10 * time.Millisecond):
+				_ = enc.Encode(map[string]any{"type": "domain"})
+			case <-r.Context().Done():
+				_ = enc.Encode(map[string]any{"type": "error"})
+				return
+			}
+		}
+		_ = enc.Encode(map[string]any{"type": "end"})
+	})
+	timeouts := config.TimeoutsConfig{Request: 20 * time.Millisecond, StreamRequest: time.Second}
+	h := RequestTimeout(timeouts.Request, PathRequestTimeouts(timeouts))(stream)
+	get := func(path string) string {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w.Body.String()
+	}
+
+	assert.Contains(t, get("/api/v1/config/stream"), `"type":"end"`)
+	assert.Contains(t, get("/api/v1/config"), `"type":"error"`, "other routes keep the global deadline")
+}
+
+func TestStrictJSON(t *testing.T) {
+	body := map[string]any{
+		"name":   "api",
+		"hosts":  []string{"api.example.com"},
+		"hostss": []string{"typo.example.com"},
+		"routes": []model.RouteConfig{
+			{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}, Status: 1},
+		},
+	}
+	create := func(def bool, header string) *httptest.ResponseRecorder {
+		h := NewDomainHandler(newMockStore(), testLogger(), nil, nil)
+		mw := StrictJSON(config.NewDynamic(def))(http.HandlerFunc(h.CreateDomain))
+		r := withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(body)), "default")
+		if header != "" {
+			r.Header.Set(StrictJSONHeader, header)
+		}
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, create(false, "").Code, "unknown fields ignored by default")
+
+	w := create(false, "true")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `hostss`)
+
+	assert.Equal(t, http.StatusBadRequest, create(true, "").Code)
+	assert.Equal(t, http.StatusCreated, create(true, "false").Code, "header overrides the server default")
+	assert.Equal(t, http.StatusBadRequest, create(false, "yes").Code)
+}
+
 func TestDomainHandler_CreateDomain_Conflict(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	d := &model.DomainConfig{
 		Name:  "api",
@@ -461,7 +1643,7 @@ func TestDomainHandler_CreateDomain_Conflict(t *testing.T) {
 
 func TestDomainHandler_CreateDomain_MissingName(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	body := jsonBody(model.DomainConfig{Hosts: []string{"a.com"}})
 	r := httptest.NewRequest("POST", "/api/v1/domains", body)
@@ -474,7 +1656,7 @@ func TestDomainHandler_CreateDomain_MissingName(t *testing.T) {
 
 func TestDomainHandler_CreateDomain_InvalidJSON(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	r := httptest.NewRequest("POST", "/api/v1/domains", bytes.NewBufferString("{invalid"))
 	r = withRegion(r, "default")
@@ -486,7 +1668,7 @@ func TestDomainHandler_CreateDomain_InvalidJSON(t *testing.T) {
 
 func TestDomainHandler_GetDomain(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	d := &model.DomainConfig{
 		Name:  "api",
@@ -513,7 +1695,7 @@ func TestDomainHandler_GetDomain(t *testing.T) {
 
 func TestDomainHandler_GetDomain_NotFound(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	r := httptest.NewRequest("GET", "/api/v1/domains/nonexistent", nil)
 	r = withRegion(r, "default")
@@ -526,7 +1708,7 @@ func TestDomainHandler_GetDomain_NotFound(t *testing.T) {
 
 func TestDomainHandler_ListDomains(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	d := &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}
 	ms.PutDomain(context.Background(), "default", d, "create", "test", -1)
@@ -542,9 +1724,220 @@ func TestDomainHandler_ListDomains(t *testing.T) {
 	assert.Equal(t, float64(1), resp["total"])
 }
 
+func TestDomainHandler_ListDomains_Pagination(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		ms.PutDomain(context.Background(), "default", &model.DomainConfig{Name: name, Hosts: []string{name + ".example.com"}}, "create", "test", -1)
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("GET", "/api/v1/domains"+query, nil), "default")
+		w := httptest.NewRecorder()
+		h.ListDomains(w, r)
+		return w
+	}
+
+	w := list("?limit=2&offset=2&sort=name")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(5), resp["total"])
+	assert.Len(t, resp["domains"], 2)
+	assert.Equal(t, "5", w.Header().Get("X-Total-Count"))
+	link := w.Header().Get("Link")
+	assert.Contains(t, link, `</api/v1/domains?limit=2&offset=0&sort=name>; rel="first"`)
+	assert.Contains(t, link, `</api/v1/domains?limit=2&offset=0&sort=name>; rel="prev"`)
+	assert.Contains(t, link, `</api/v1/domains?limit=2&offset=4&sort=name>; rel="next"`)
+	assert.Contains(t, link, `</api/v1/domains?limit=2&offset=4&sort=name>; rel="last"`)
+
+	w = list("?limit=2&offset=4")
+	assert.Len(t, decodeResp(t, w)["domains"], 1)
+	assert.NotContains(t, w.Header().Get("Link"), `rel="next"`)
+
+	// Unpaginated: everything, a count, no links.
+	w = list("")
+	assert.Len(t, decodeResp(t, w)["domains"], 5)
+	assert.Equal(t, "5", w.Header().Get("X-Total-Count"))
+	assert.Empty(t, w.Header().Get("Link"))
+
+	assert.Equal(t, http.StatusBadRequest, list("?limit=0").Code)
+	assert.Equal(t, http.StatusBadRequest, list("?offset=-1").Code)
+}
+
+func TestDomainHandler_FindDomainsByHost(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api-v2", Hosts: []string{"api.example.com", "v2.example.com"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "web", Hosts: []string{"www.example.com"}}, "create", "test", -1)
+
+	find := func(host string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/domains/by-host?host="+url.QueryEscape(host), nil)
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.FindDomainsByHost(w, r)
+		return w
+	}
+
+	w := find("api.example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResp(t, w)["total"], "every claimant is returned")
+
+	w = find("unknown.example.com")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(0), decodeResp(t, w)["total"])
+
+	assert.Equal(t, http.StatusBadRequest, find("").Code)
+}
+
+func TestDomainHandler_RejectsDuplicateHosts(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	route := []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "c", Weight: 1}}}}
+	ms.PutDomain(context.Background(), "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Routes: route}, "create", "test", -1)
+
+	r := httptest.NewRequest("POST", "/api/v1/domains", jsonBody(model.DomainConfig{
+		Name: "api-copy", Hosts: []string{"copy.example.com", "api.example.com"}, Routes: route,
+	}))
+	w := httptest.NewRecorder()
+	h.CreateDomain(w, withRegion(r, "default"))
+	require.Equal(t, http.StatusConflict, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, "api", resp["domain"])
+	assert.Equal(t, "api.example.com", resp["host"])
+
+	// Other regions are independent.
+	r = httptest.NewRequest("POST", "/api/v1/domains", jsonBody(model.DomainConfig{
+		Name: "api-copy", Hosts: []string{"api.example.com"}, Routes: route,
+	}))
+	w = httptest.NewRecorder()
+	h.CreateDomain(w, withRegion(r, "staging"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// Updating a domain with its own hosts is not a conflict.
+	r = httptest.NewRequest("PUT", "/api/v1/domains/api", jsonBody(map[string]any{
+		"hosts": []string{"api.example.com", "api2.example.com"}, "routes": route, "resource_version": 1,
+	}))
+	r.SetPathValue("name", "api")
+	w = httptest.NewRecorder()
+	h.UpdateDomain(w, withRegion(r, "default"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name, doc, patch, want string
+		wantErr                bool
+	}{
+		{"add member", `{"a":1}`, `[{"op":"add","path":"/b","value":2}]`, `{"a":1,"b":2}`, false},
+		{"add array insert", `{"a":[1,3]}`, `[{"op":"add","path":"/a/1","value":2}]`, `{"a":[1,2,3]}`, false},
+		{"add array append", `{"a":[1]}`, `[{"op":"add","path":"/a/-","value":2}]`, `{"a":[1,2]}`, false},
+		{"add missing parent", `{}`, `[{"op":"add","path":"/x/y","value":1}]`, "", true},
+		{"remove", `{"a":[1,2,3]}`, `[{"op":"remove","path":"/a/1"}]`, `{"a":[1,3]}`, false},
+		{"remove missing", `{"a":1}`, `[{"op":"remove","path":"/b"}]`, "", true},
+		{"replace", `{"a":{"b":1}}`, `[{"op":"replace","path":"/a/b","value":"x"}]`, `{"a":{"b":"x"}}`, false},
+		{"replace missing", `{}`, `[{"op":"replace","path":"/a","value":1}]`, "", true},
+		{"move", `{"a":{"b":1},"c":{}}`, `[{"op":"move","from":"/a/b","path":"/c/d"}]`, `{"a":{},"c":{"d":1}}`, false},
+		{"move into child", `{"a":{"b":{}}}`, `[{"op":"move","from":"/a","path":"/a/b/c"}]`, "", true},
+		{"copy", `{"a":[1]}`, `[{"op":"copy","from":"/a","path":"/b"}]`, `{"a":[1],"b":[1]}`, false},
+		{"test pass", `{"a":"x"}`, `[{"op":"test","path":"/a","value":"x"},{"op":"add","path":"/b","value":1}]`, `{"a":"x","b":1}`, false},
+		{"test fail", `{"a":"x"}`, `[{"op":"test","path":"/a","value":"y"}]`, "", true},
+		{"escaped pointer", `{"a/b":1,"m~n":2}`, `[{"op":"remove","path":"/a~1b"},{"op":"remove","path":"/m~0n"}]`, `{}`, false},
+		{"bad index", `{"a":[1]}`, `[{"op":"remove","path":"/a/01"}]`, "", true},
+		{"unknown op", `{}`, `[{"op":"merge","path":"/a"}]`, "", true},
+		{"missing value", `{}`, `[{"op":"add","path":"/a"}]`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc any
+			require.NoError(t, json.Unmarshal([]byte(tt.doc), &doc))
+			var ops []patchOp
+			require.NoError(t, json.Unmarshal([]byte(tt.patch), &ops))
+			got, err := applyJSONPatch(doc, ops)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			out, _ := json.Marshal(got)
+			assert.JSONEq(t, tt.want, string(out))
+		})
+	}
+}
+
+func TestDomainHandler_PatchDomain(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	route := []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "c", Weight: 1}}}}
+	ms.PutDomain(context.Background(), "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Routes: route}, "create", "test", -1)
+	ms.PutDomain(context.Background(), "default", &model.DomainConfig{Name: "web", Hosts: []string{"www.example.com"}, Routes: route}, "create", "test", -1)
+
+	patch := func(name, query, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PATCH", "/api/v1/domains/"+name+query, strings.NewReader(body))
+		r.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		h.PatchDomain(w, withRegion(r, "default"))
+		return w
+	}
+
+	w := patch("api", "?resource_version=1", `[{"op":"add","path":"/hosts/-","value":"api2.example.com"}]`)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	d, rv, _ := ms.GetDomain(context.Background(), "default", "api")
+	assert.Equal(t, []string{"api.example.com", "api2.example.com"}, d.Hosts)
+	assert.Equal(t, int64(2), rv)
+	assert.Equal(t, float64(2), decodeResp(t, w)["resource_version"])
+
+	// Stale version.
+	assert.Equal(t, http.StatusConflict, patch("api", "?resource_version=1", `[{"op":"remove","path":"/hosts/1"}]`).Code)
+	// Missing version.
+	assert.Equal(t, http.StatusBadRequest, patch("api", "", `[]`).Code)
+	// Failed test op leaves the domain untouched.
+	assert.Equal(t, http.StatusUnprocessableEntity, patch("api", "?resource_version=2", `[{"op":"test","path":"/hosts/0","value":"nope"}]`).Code)
+	// Renames are rejected.
+	assert.Equal(t, http.StatusBadRequest, patch("api", "?resource_version=2", `[{"op":"replace","path":"/name","value":"other"}]`).Code)
+	// Result must still validate.
+	assert.Equal(t, http.StatusBadRequest, patch("api", "?resource_version=2", `[{"op":"replace","path":"/hosts","value":[]}]`).Code)
+	// And must not steal another domain's host.
+	assert.Equal(t, http.StatusConflict, patch("api", "?resource_version=2", `[{"op":"add","path":"/hosts/-","value":"www.example.com"}]`).Code)
+	assert.Equal(t, http.StatusNotFound, patch("nope", "?resource_version=1", `[]`).Code)
+
+	d, _, _ = ms.GetDomain(context.Background(), "default", "api")
+	assert.Equal(t, []string{"api.example.com", "api2.example.com"}, d.Hosts)
+}
+
+func TestDomainHandler_CreateDomain_NormalizeWeights(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	domain := model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Routes: []model.RouteConfig{
+		{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}}},
+	}}
+
+	r := httptest.NewRequest("POST", "/api/v1/domains?normalize=true", jsonBody(domain))
+	w := httptest.NewRecorder()
+	h.CreateDomain(w, withRegion(r, "default"))
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp struct {
+		Domain model.DomainConfig `json:"domain"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.Equal(t, 75, resp.Domain.Routes[0].Clusters[0].Weight)
+	assert.Equal(t, 25, resp.Domain.Routes[0].Clusters[1].Weight)
+	stored, _, _ := ms.GetDomain(context.Background(), "default", "api")
+	assert.Equal(t, resp.Domain, *stored)
+
+	// All-zero weights are rejected, normalize or not.
+	domain.Name, domain.Hosts = "zero", []string{"zero.example.com"}
+	domain.Routes[0].Clusters = []model.WeightedCluster{{Name: "a", Weight: 0}}
+	w = httptest.NewRecorder()
+	h.CreateDomain(w, withRegion(httptest.NewRequest("POST", "/api/v1/domains?normalize=true", jsonBody(domain)), "default"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
 func TestDomainHandler_UpdateDomain(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	d := &model.DomainConfig{
 		Name:  "api",
@@ -572,7 +1965,7 @@ func TestDomainHandler_UpdateDomain(t *testing.T) {
 
 func TestDomainHandler_UpdateDomain_NotFound(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	body := jsonBody(map[string]any{"hosts": []string{"a.com"}, "resource_version": 1})
 	r := httptest.NewRequest("PUT", "/api/v1/domains/nonexistent", body)
@@ -586,7 +1979,7 @@ func TestDomainHandler_UpdateDomain_NotFound(t *testing.T) {
 
 func TestDomainHandler_DeleteDomain(t *testing.T) {
 	ms := newMockStore()
-	h := NewDomainHandler(ms, testLogger())
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
 
 	d := &model.DomainConfig{Name: "api", Hosts: []string{"a.com"}}
 	ms.PutDomain(context.Background(), "default", d, "create", "test", -1)
@@ -602,7 +1995,7 @@ func TestDomainHandler_DeleteDomain(t *testing.T) {
 
 func TestClusterHandler_CreateCluster(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	body := jsonBody(model.ClusterConfig{
 		Name:    "backend",
@@ -621,7 +2014,7 @@ func TestClusterHandler_CreateCluster(t *testing.T) {
 
 func TestClusterHandler_CreateCluster_Conflict(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	c := &model.ClusterConfig{
 		Name:    "backend",
@@ -642,7 +2035,7 @@ func TestClusterHandler_CreateCluster_Conflict(t *testing.T) {
 
 func TestClusterHandler_CreateCluster_MissingName(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	body := jsonBody(model.ClusterConfig{LBType: "roundrobin"})
 	r := httptest.NewRequest("POST", "/api/v1/clusters", body)
@@ -655,7 +2048,7 @@ func TestClusterHandler_CreateCluster_MissingName(t *testing.T) {
 
 func TestClusterHandler_GetCluster(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	c := &model.ClusterConfig{
 		Name:    "backend",
@@ -676,7 +2069,7 @@ func TestClusterHandler_GetCluster(t *testing.T) {
 
 func TestClusterHandler_GetCluster_NotFound(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	r := httptest.NewRequest("GET", "/api/v1/clusters/nonexistent", nil)
 	r = withRegion(r, "default")
@@ -689,7 +2082,7 @@ func TestClusterHandler_GetCluster_NotFound(t *testing.T) {
 
 func TestClusterHandler_DeleteCluster(t *testing.T) {
 	ms := newMockStore()
-	h := NewClusterHandler(ms, testLogger())
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
 
 	c := &model.ClusterConfig{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}}
 	ms.PutCluster(context.Background(), "default", c, "create", "test", -1)
@@ -718,6 +2111,35 @@ func TestWatchHandler_GetRevision(t *testing.T) {
 	assert.Equal(t, float64(0), resp["revision"])
 }
 
+func TestUpdateDomainSkipNoop(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	d := &model.DomainConfig{Name: "api", Hosts: []string{"a.com"}, Routes: []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}}}}
+	ms.PutDomain(context.Background(), "default", d, "create", "test", 0)
+
+	update := func(query string, rv int64) map[string]any {
+		r := httptest.NewRequest("PUT", "/api/v1/domains/api"+query, jsonBody(map[string]any{
+			"name": d.Name, "hosts": d.Hosts, "routes": d.Routes, "resource_version": rv,
+		}))
+		r.SetPathValue("name", "api")
+		w := httptest.NewRecorder()
+		h.UpdateDomain(w, withRegion(r, "default"))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return decodeResp(t, w)
+	}
+
+	changes := len(ms.changes)
+	resp := update("?skip_noop=true", 1)
+	assert.Equal(t, true, resp["unchanged"])
+	assert.Equal(t, float64(1), resp["resource_version"])
+	assert.Len(t, ms.changes, changes, "a skipped update writes no change event")
+
+	resp = update("", 1)
+	assert.Equal(t, false, resp["unchanged"])
+	assert.Equal(t, float64(2), resp["resource_version"])
+	assert.Len(t, ms.changes, changes+1)
+}
+
 func TestWatchHandler_WatchConfig(t *testing.T) {
 	ms := newMockStore()
 	h := NewWatchHandler(ms, testLogger())
@@ -736,6 +2158,74 @@ func TestWatchHandler_WatchConfig(t *testing.T) {
 	assert.Equal(t, float64(1), resp["total"])
 }
 
+func TestWatchHandler_WatchConfig_Kind(t *testing.T) {
+	ms := newMockStore()
+	h := NewWatchHandler(ms, testLogger())
+	ms.PutDomain(context.Background(), "default", &model.DomainConfig{Name: "api"}, "create", "test", -1)
+	ms.changes = append(ms.changes,
+		store.ChangeEvent{Revision: 2, Kind: "cluster", Name: "backend", Action: "create"},
+		store.ChangeEvent{Revision: 3, Kind: "sync", Action: "reconcile"})
+
+	watch := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.WatchConfig(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/watch?revision=0"+query, nil), "default"))
+		return w
+	}
+
+	resp := decodeResp(t, watch("&kind=cluster"))
+	events := resp["events"].([]any)
+	require.Len(t, events, 2)
+	assert.Equal(t, "backend", events[0].(map[string]any)["name"])
+	assert.Equal(t, "sync", events[1].(map[string]any)["kind"])
+
+	assert.Equal(t, float64(3), decodeResp(t, watch("&kind=domain,cluster"))["total"])
+	assert.Equal(t, float64(3), decodeResp(t, watch(""))["total"])
+	assert.Equal(t, http.StatusBadRequest, watch("&kind=route").Code)
+}
+
+func TestWatchHandler_ConfigChanges(t *testing.T) {
+	ms := newMockStore()
+	h := NewWatchHandler(ms, testLogger())
+	ms.domains["default"] = map[string]*model.DomainConfig{
+		"api": {Name: "api", Hosts: []string{"api.example.com"}},
+		"web": {Name: "web", Hosts: []string{"web.example.com"}},
+	}
+	ms.changes = append(ms.changes,
+		store.ChangeEvent{Revision: 1, Kind: "domain", Name: "api", Action: "create"},
+		store.ChangeEvent{Revision: 2, Kind: "cluster", Name: "old", Action: "create"},
+		store.ChangeEvent{Revision: 3, Kind: "domain", Name: "api", Action: "update"},
+		store.ChangeEvent{Revision: 4, Kind: "domain", Name: "web", Action: "import"},
+		store.ChangeEvent{Revision: 5, Kind: "cluster", Name: "old", Action: "delete"},
+		store.ChangeEvent{Revision: 6, Kind: "cluster", Name: "tmp", Action: "create"},
+		store.ChangeEvent{Revision: 7, Kind: "cluster", Name: "tmp", Action: "delete"},
+		store.ChangeEvent{Revision: 8, Kind: "sync", Action: "reconcile"})
+	ms.revision = 7
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ConfigChanges(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/changes"+query, nil), "default"))
+		return w
+	}
+
+	w := get("?since=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(7), resp["revision"])
+	changes := resp["changes"].([]any)
+	require.Len(t, changes, 3)
+	api := changes[0].(map[string]any)
+	assert.Equal(t, "updated", api["change"])
+	assert.Equal(t, "api", api["domain"].(map[string]any)["name"])
+	assert.Equal(t, "created", changes[1].(map[string]any)["change"])
+	old := changes[2].(map[string]any)
+	assert.Equal(t, "deleted", old["change"])
+	assert.Nil(t, old["cluster"])
+
+	assert.Equal(t, float64(0), decodeResp(t, get("?since=7"))["total"])
+	assert.Equal(t, http.StatusBadRequest, get("").Code)
+	assert.Equal(t, http.StatusBadRequest, get("?since=-1").Code)
+}
+
 func TestWatchHandler_WatchConfig_InvalidRevision(t *testing.T) {
 	ms := newMockStore()
 	h := NewWatchHandler(ms, testLogger())
@@ -750,7 +2240,7 @@ func TestWatchHandler_WatchConfig_InvalidRevision(t *testing.T) {
 
 func TestRouteHandler_GetConfig(t *testing.T) {
 	ms := newMockStore()
-	h := NewRouteHandler(ms, testLogger())
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
 
 	r := httptest.NewRequest("GET", "/api/v1/config", nil)
 	r = withRegion(r, "default")
@@ -760,9 +2250,152 @@ func TestRouteHandler_GetConfig(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func TestRouteHandler_GetConfig_Selector(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	edge := map[string]string{"tier": "edge", "team": "net"}
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "cdn", Hosts: []string{"cdn.example.com"}, Labels: edge}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Labels: map[string]string{"tier": "core"}}, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "cdn-origin", Labels: edge}, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "api-backend"}, "create", "test", -1)
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.GetConfig(w, withRegion(httptest.NewRequest("GET", "/api/v1/config"+query, nil), "default"))
+		return w
+	}
+	names := func(w *httptest.ResponseRecorder) []string {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp struct {
+			Config model.GatewayConfig `json:"config"`
+		}
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		var out []string
+		for _, d := range resp.Config.Domains {
+			out = append(out, "domain/"+d.Name)
+		}
+		for _, c := range resp.Config.Clusters {
+			out = append(out, "cluster/"+c.Name)
+		}
+		slices.Sort(out)
+		return out
+	}
+
+	assert.Len(t, names(get("")), 4)
+	assert.Equal(t, []string{"cluster/cdn-origin", "domain/cdn"}, names(get("?selector=tier=edge")))
+	assert.Equal(t, []string{"cluster/cdn-origin", "domain/cdn"}, names(get("?selector=tier=edge,team=net")))
+	assert.Equal(t, []string{"cluster/cdn-origin", "domain/cdn"}, names(get("?selector=tier=edge&selector=team=net")))
+	assert.Empty(t, names(get("?selector=tier=edge,team=web")))
+
+	// The canary channel is filtered the same way.
+	ms.canaries["default"] = &store.ConfigCanary{Percent: 100, Config: model.GatewayConfig{
+		Domains: []model.DomainConfig{{Name: "cdn", Labels: edge}, {Name: "api"}},
+	}}
+	assert.Equal(t, []string{"domain/cdn"}, names(get("?channel=canary&selector=tier=edge")))
+
+	for _, q := range []string{"?selector=tier", "?selector==edge", "?selector=tier=edge,tier=core"} {
+		assert.Equal(t, http.StatusBadRequest, get(q).Code, q)
+	}
+
+	// A selected domain routing to an unselected cluster is refused rather
+	// than synced without its cluster.
+	delete(ms.canaries, "default")
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "edge-api", Hosts: []string{"edge-api.example.com"}, Labels: edge,
+		Routes: []model.RouteConfig{{Name: "r", URI: "/", Clusters: []model.WeightedCluster{{Name: "api-backend", Weight: 100}}}}}, "create", "test", -1)
+	w := get("?selector=tier=edge")
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "api-backend")
+}
+
+func TestRouteHandler_StreamConfig(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "backend"}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "web", Hosts: []string{"web.example.com"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", -1)
+
+	r := withRegion(httptest.NewRequest("GET", "/api/v1/config/stream", nil), "default")
+	w := httptest.NewRecorder()
+	h.StreamConfig(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/x-ndjson", w.Header().Get("Content-Type"))
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\n") {
+		var v map[string]any
+		require.NoError(t, json.Unmarshal([]byte(line), &v), line)
+		lines = append(lines, v)
+	}
+	require.Len(t, lines, 5)
+	assert.Equal(t, "revision", lines[0]["type"])
+	assert.Equal(t, float64(ms.revision), lines[0]["resource_version"])
+	assert.Equal(t, "domain", lines[1]["type"])
+	assert.Equal(t, "api", lines[1]["config"].(map[string]any)["name"])
+	assert.Equal(t, "web", lines[2]["config"].(map[string]any)["name"])
+	assert.Equal(t, "cluster", lines[3]["type"])
+	assert.Equal(t, "backend", lines[3]["config"].(map[string]any)["name"])
+	assert.Equal(t, map[string]any{"type": "end", "domains": float64(2), "clusters": float64(1)}, lines[4])
+}
+
+func TestRouteHandler_ConfigSchema(t *testing.T) {
+	h := NewRouteHandler(newMockStore(), testLogger(), nil, nil)
+	w := httptest.NewRecorder()
+	h.ConfigSchema(w, httptest.NewRequest("GET", "/api/v1/config/schema", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	cluster := resp["cluster"].(map[string]any)
+	assert.Contains(t, cluster["type"], "least_request")
+	route := resp["route"].(map[string]any)
+	assert.Equal(t, map[string]any{"0": "disabled", "1": "enabled"}, route["status"])
+}
+
+func TestRouteHandler_RestoreConfig(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	backend := model.ClusterConfig{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}}
+	route := []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}}}
+	ms.PutCluster(ctx, "default", &backend, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Routes: route}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api2.example.com"}, Routes: route}, "update", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "web", Hosts: []string{"web.example.com"}, Routes: route}, "create", "test", -1)
+	ms.configAt = map[int64]*model.GatewayConfig{2: {
+		Domains:  []model.DomainConfig{{Name: "api", Hosts: []string{"api.example.com"}, Routes: route}},
+		Clusters: []model.ClusterConfig{backend},
+	}}
+
+	restore := func(query string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("POST", "/api/v1/config/restore"+query, nil), "default")
+		w := httptest.NewRecorder()
+		h.RestoreConfig(w, r)
+		return w
+	}
+	want := map[string]any{
+		"domains":  map[string]any{"create": []any{}, "update": []any{"api"}, "delete": []any{"web"}},
+		"clusters": map[string]any{"create": []any{}, "update": []any{}, "delete": []any{}},
+	}
+
+	w := restore("?revision=2&dry_run=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, want, decodeResp(t, w)["changes"])
+	assert.Len(t, ms.domains["default"], 2, "dry run changes nothing")
+
+	w = restore("?revision=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, want, decodeResp(t, w)["changes"])
+	require.Len(t, ms.domains["default"], 1)
+	assert.Equal(t, []string{"api.example.com"}, ms.domains["default"]["api"].Hosts)
+
+	assert.Equal(t, http.StatusBadRequest, restore("?revision=99").Code, "ahead of current")
+	assert.Equal(t, http.StatusBadRequest, restore("?revision=0").Code)
+	assert.Equal(t, http.StatusBadRequest, restore("").Code)
+}
+
 func TestRouteHandler_ValidateConfig_Valid(t *testing.T) {
 	ms := newMockStore()
-	h := NewRouteHandler(ms, testLogger())
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
 
 	cfg := model.GatewayConfig{
 		Domains: []model.DomainConfig{
@@ -789,7 +2422,7 @@ func TestRouteHandler_ValidateConfig_Valid(t *testing.T) {
 
 func TestRouteHandler_ValidateConfig_Invalid(t *testing.T) {
 	ms := newMockStore()
-	h := NewRouteHandler(ms, testLogger())
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
 
 	cfg := model.GatewayConfig{
 		Domains: []model.DomainConfig{
@@ -809,9 +2442,37 @@ func TestRouteHandler_ValidateConfig_Invalid(t *testing.T) {
 	assert.Equal(t, false, resp["valid"])
 }
 
+func TestRouteHandler_ValidateConfig_Warnings(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+
+	cfg := model.GatewayConfig{
+		Domains: []model.DomainConfig{
+			{Name: "api", Hosts: []string{"intranet"}, Routes: []model.RouteConfig{
+				{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}},
+			}},
+		},
+		Clusters: []model.ClusterConfig{
+			{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}},
+		},
+	}
+
+	w := httptest.NewRecorder()
+	h.ValidateConfig(w, withRegion(httptest.NewRequest("POST", "/api/v1/config/validate", jsonBody(cfg)), "default"))
+	resp := decodeResp(t, w)
+	assert.Equal(t, true, resp["valid"])
+	assert.Len(t, resp["warnings"], 2)
+
+	// Writes succeed and echo the same warnings.
+	w = httptest.NewRecorder()
+	h.PutConfig(w, withRegion(httptest.NewRequest("PUT", "/api/v1/config", jsonBody(cfg)), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Len(t, decodeResp(t, w)["warnings"], 2)
+}
+
 func TestRouteHandler_PutConfig(t *testing.T) {
 	ms := newMockStore()
-	h := NewRouteHandler(ms, testLogger())
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
 
 	cfg := model.GatewayConfig{
 		Domains: []model.DomainConfig{
@@ -833,6 +2494,43 @@ func TestRouteHandler_PutConfig(t *testing.T) {
 	assert.Equal(t, http.StatusOK, w.Code)
 }
 
+func TestRouteHandler_PutConfig_ResourceVersion(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ms.PutCluster(context.Background(), "default", &model.ClusterConfig{Name: "backend"}, "create", "test", 0)
+
+	get := func() float64 {
+		w := httptest.NewRecorder()
+		h.GetConfig(w, withRegion(httptest.NewRequest("GET", "/api/v1/config", nil), "default"))
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeResp(t, w)["resource_version"].(float64)
+	}
+	put := func(rv float64) *httptest.ResponseRecorder {
+		body := map[string]any{
+			"clusters": []model.ClusterConfig{
+				{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}},
+			},
+			"resource_version": rv,
+		}
+		w := httptest.NewRecorder()
+		h.PutConfig(w, withRegion(httptest.NewRequest("PUT", "/api/v1/config", jsonBody(body)), "default"))
+		return w
+	}
+
+	rv := get()
+	assert.Equal(t, float64(1), rv)
+
+	// Someone else changes the region after our read.
+	ms.PutCluster(context.Background(), "default", &model.ClusterConfig{Name: "other"}, "create", "test", 0)
+	assert.Equal(t, http.StatusConflict, put(rv).Code)
+
+	w := put(get())
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, get(), decodeResp(t, w)["resource_version"])
+
+	assert.Equal(t, http.StatusBadRequest, put(-5).Code)
+}
+
 func TestAuditHandler_ListAuditLog(t *testing.T) {
 	ms := newMockStore()
 	h := NewAuditHandler(ms, testLogger())
@@ -850,24 +2548,154 @@ func TestAuditHandler_ListAuditLog(t *testing.T) {
 	assert.Equal(t, float64(1), resp["total"])
 }
 
-func TestAuditHandler_ListAuditLog_DefaultLimit(t *testing.T) {
+func TestAuditHandler_ListAuditLog_Since(t *testing.T) {
 	ms := newMockStore()
 	h := NewAuditHandler(ms, testLogger())
+	ms.auditLog = []store.AuditEntry{
+		{Kind: "domain", Name: "old", Action: "create", Timestamp: time.Now().Add(-90 * 24 * time.Hour)},
+		{Kind: "domain", Name: "new", Action: "create", Timestamp: time.Now()},
+	}
 
-	r := httptest.NewRequest("GET", "/api/v1/audit", nil)
-	r = withRegion(r, "default")
-	w := httptest.NewRecorder()
-
-	h.ListAuditLog(w, r)
-	assert.Equal(t, http.StatusOK, w.Code)
+	list := func(query string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("GET", "/api/v1/audit"+query, nil), "default")
+		w := httptest.NewRecorder()
+		h.ListAuditLog(w, r)
+		return w
+	}
+
+	w := list("?since=" + url.QueryEscape(time.Now().Add(-24*time.Hour).Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(1), resp["total"])
+	assert.Equal(t, false, resp["history_truncated"])
+
+	// Reaching back past the oldest retained event is flagged.
+	w = list("?since=" + url.QueryEscape(time.Now().Add(-365*24*time.Hour).Format(time.RFC3339)))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = decodeResp(t, w)
+	assert.Equal(t, float64(2), resp["total"])
+	assert.Equal(t, true, resp["history_truncated"])
+
+	assert.Equal(t, http.StatusBadRequest, list("?since=yesterday").Code)
+}
+
+func TestAuditHandler_ListAuditLog_Cursor(t *testing.T) {
+	ms := newMockStore()
+	h := NewAuditHandler(ms, testLogger())
+	for rev := int64(1); rev <= 5; rev++ {
+		ms.auditLog = append(ms.auditLog, store.AuditEntry{Revision: rev, Kind: "domain", Name: "api", Action: "update", Timestamp: time.Now()})
+	}
+	list := func(query string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("GET", "/api/v1/audit"+query, nil), "default")
+		w := httptest.NewRecorder()
+		h.ListAuditLog(w, r)
+		return w
+	}
+	revisions := func(resp map[string]any) []float64 {
+		var revs []float64
+		for _, e := range resp["entries"].([]any) {
+			revs = append(revs, e.(map[string]any)["revision"].(float64))
+		}
+		return revs
+	}
+
+	w := list("?before=6&limit=2")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, []float64{5, 4}, revisions(resp))
+	cursor := resp["next_cursor"].(string)
+	require.NotEmpty(t, cursor)
+	assert.Contains(t, w.Header().Get("Link"), "cursor="+cursor)
+
+	// New entries don't shift the next page.
+	ms.auditLog = append(ms.auditLog, store.AuditEntry{Revision: 6, Kind: "domain", Name: "api", Action: "update", Timestamp: time.Now()})
+	resp = decodeResp(t, list("?limit=2&cursor="+cursor))
+	assert.Equal(t, []float64{3, 2}, revisions(resp))
+	assert.Equal(t, float64(6), resp["total"])
+
+	resp = decodeResp(t, list("?limit=2&cursor="+resp["next_cursor"].(string)))
+	assert.Equal(t, []float64{1}, revisions(resp))
+	assert.Empty(t, resp["next_cursor"])
+
+	assert.Equal(t, http.StatusBadRequest, list("?cursor=bogus").Code)
+	assert.Equal(t, http.StatusBadRequest, list("?before=0").Code)
+	assert.Equal(t, http.StatusBadRequest, list("?before=3&cursor="+cursor).Code)
+	assert.Equal(t, http.StatusBadRequest, list("?before=3&offset=2").Code)
+
+	// A limit above the store's maximum is clamped, not reset to the
+	// default, so a full page still carries a cursor.
+	for rev := int64(7); rev <= 250; rev++ {
+		ms.auditLog = append(ms.auditLog, store.AuditEntry{Revision: rev, Kind: "domain", Name: "api", Action: "update", Timestamp: time.Now()})
+	}
+	resp = decodeResp(t, list("?before=251&limit=500"))
+	assert.Len(t, resp["entries"], store.MaxListLimit)
+	assert.Equal(t, float64(store.MaxListLimit), resp["limit"])
+	assert.NotEmpty(t, resp["next_cursor"])
+}
+
+func TestAuditHandler_ListAuditLog_DefaultLimit(t *testing.T) {
+	ms := newMockStore()
+	h := NewAuditHandler(ms, testLogger())
+
+	r := httptest.NewRequest("GET", "/api/v1/audit", nil)
+	r = withRegion(r, "default")
+	w := httptest.NewRecorder()
+
+	h.ListAuditLog(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
 
 	resp := decodeResp(t, w)
 	assert.Equal(t, float64(50), resp["limit"])
+	assert.Equal(t, "0", w.Header().Get("X-Total-Count"))
+	assert.Contains(t, w.Header().Get("Link"), `rel="first"`)
+}
+
+func TestAuditHandler_ListHistory(t *testing.T) {
+	ms := newMockStore()
+	h := NewAuditHandler(ms, testLogger())
+	day := time.Date(2026, 3, 10, 0, 0, 0, 0, time.UTC)
+	ms.history = []store.HistoryEntry{
+		{Version: 2, Kind: "domain", Name: "api", Action: "update", Operator: "bob", Timestamp: day.Add(3 * time.Hour),
+			Domain: &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}},
+		{Version: 1, Kind: "cluster", Name: "backend", Action: "create", Operator: "alice", Timestamp: day.Add(2 * time.Hour),
+			Cluster: &model.ClusterConfig{Name: "backend"}},
+		{Version: 1, Kind: "domain", Name: "api", Action: "create", Operator: "alice", Timestamp: day.Add(-time.Hour)},
+	}
+
+	list := func(query string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("GET", "/api/v1/history"+query, nil), "default")
+		w := httptest.NewRecorder()
+		h.ListHistory(w, r)
+		return w
+	}
+
+	w := list("")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(3), resp["total"])
+	first := resp["history"].([]any)[0].(map[string]any)
+	assert.Equal(t, "bob", first["operator"])
+	assert.Equal(t, "api.example.com", first["domain"].(map[string]any)["hosts"].([]any)[0], "entries carry the snapshot")
+
+	since := url.QueryEscape(day.Format(time.RFC3339))
+	until := url.QueryEscape(day.Add(24 * time.Hour).Format(time.RFC3339))
+	w = list("?since=" + since + "&until=" + until + "&operator=alice")
+	resp = decodeResp(t, w)
+	require.Equal(t, float64(1), resp["total"])
+	assert.Equal(t, "backend", resp["history"].([]any)[0].(map[string]any)["name"])
+
+	w = list("?kind=domain&name=api&limit=1")
+	assert.Equal(t, float64(2), decodeResp(t, w)["total"])
+	assert.Equal(t, "2", w.Header().Get("X-Total-Count"))
+	assert.Contains(t, w.Header().Get("Link"), `rel="next"`)
+
+	assert.Equal(t, http.StatusBadRequest, list("?kind=route").Code)
+	assert.Equal(t, http.StatusBadRequest, list("?until=tuesday").Code)
 }
 
 func TestStatusHandler_ReportAndGetController(t *testing.T) {
 	ms := newMockStore()
-	h := NewStatusHandler(ms, testLogger())
+	h := NewStatusHandler(ms, testLogger(), nil)
 
 	ctrl := store.ControllerStatus{
 		ID:              "ctrl-1",
@@ -898,7 +2726,7 @@ func TestStatusHandler_ReportAndGetController(t *testing.T) {
 
 func TestStatusHandler_ReportInstances(t *testing.T) {
 	ms := newMockStore()
-	h := NewStatusHandler(ms, testLogger())
+	h := NewStatusHandler(ms, testLogger(), nil)
 
 	body := jsonBody(map[string]any{
 		"instances": []store.GatewayInstanceStatus{
@@ -923,7 +2751,7 @@ func TestStatusHandler_ReportInstances(t *testing.T) {
 
 func TestStatusHandler_AggregateStatus(t *testing.T) {
 	ms := newMockStore()
-	h := NewStatusHandler(ms, testLogger())
+	h := NewStatusHandler(ms, testLogger(), nil)
 
 	r := httptest.NewRequest("GET", "/api/v1/status", nil)
 	r = withRegion(r, "default")
@@ -931,11 +2759,146 @@ func TestStatusHandler_AggregateStatus(t *testing.T) {
 
 	h.AggregateStatus(w, r)
 	assert.Equal(t, http.StatusOK, w.Code)
+	health := decodeResp(t, w)["health"].(map[string]any)
+	assert.Equal(t, HealthRed, health["status"], "no controller has reported")
+	assert.NotEmpty(t, health["reasons"])
+}
+
+func TestStatusHandler_AggregateStatusOwners(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	rh := NewRegionHandler(ms, testLogger(), nil)
+	ctx := context.Background()
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "pay", Owner: "payments", Contact: "@pay-oncall"}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "search", Owner: "search"}, "create", "test", -1)
+
+	r := httptest.NewRequest("PUT", "/api/v1/regions/default/settings", jsonBody(map[string]any{"default_owner": "platform", "default_contact": "@platform-oncall"}))
+	r.SetPathValue("name", "default")
+	w := httptest.NewRecorder()
+	rh.PutRegionSettings(w, r)
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	w = httptest.NewRecorder()
+	h.AggregateStatus(w, withRegion(httptest.NewRequest("GET", "/api/v1/status", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	owners := decodeResp(t, w)["owners"].([]any)
+	require.Len(t, owners, 2)
+	byDomain := make(map[string]map[string]any)
+	for _, o := range owners {
+		byDomain[o.(map[string]any)["domain"].(string)] = o.(map[string]any)
+	}
+	assert.Equal(t, map[string]any{"domain": "pay", "owner": "payments", "contact": "@pay-oncall"}, byDomain["pay"])
+	assert.Equal(t, map[string]any{"domain": "search", "owner": "search", "contact": "@platform-oncall", "inherited": true}, byDomain["search"])
+
+	r = httptest.NewRequest("PUT", "/api/v1/regions/default/settings", jsonBody(map[string]any{"default_owner": "bad\nowner"}))
+	r.SetPathValue("name", "default")
+	w = httptest.NewRecorder()
+	rh.PutRegionSettings(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPutRegionSettingsAccess(t *testing.T) {
+	ms := newMockStore()
+	rh := NewRegionHandler(ms, testLogger(), nil)
+	owner := &Identity{Subject: "u1", Scopes: store.RoleToScopes(store.RoleOwner, false)}
+	admin := &Identity{Subject: "root", Scopes: store.RoleToScopes("", true)}
+
+	put := func(id *Identity, body any) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/regions/default/settings", jsonBody(body))
+		r.SetPathValue("name", "default")
+		w := httptest.NewRecorder()
+		rh.PutRegionSettings(w, withIdentity(r, id))
+		return w
+	}
+
+	w := put(owner, map[string]any{"default_ownr": "platform"})
+	assert.Equal(t, http.StatusBadRequest, w.Code, "unknown keys are rejected")
+	assert.Contains(t, w.Body.String(), "default_ownr")
+
+	assert.Equal(t, http.StatusOK, put(admin, map[string]any{"max_domains": 5}).Code)
+	assert.Equal(t, http.StatusOK, put(owner, map[string]any{"max_domains": 5, "default_owner": "platform"}).Code,
+		"owners may change other settings while keeping the limits")
+	w = put(owner, map[string]any{"max_domains": 50})
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "max_domains")
+	assert.Equal(t, http.StatusForbidden, put(owner, map[string]any{}).Code, "dropping a limit is changing it")
+}
+
+func TestDefaultRole(t *testing.T) {
+	ms := newMockStore()
+	rh := NewRegionHandler(ms, testLogger(), nil)
+	ctx := context.Background()
+	newcomer := &OIDCClaims{Sub: "new-user", Groups: []string{"unbound"}}
+
+	putRole := func(role string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/regions/default/settings", jsonBody(map[string]any{"default_role": role}))
+		r.SetPathValue("name", "default")
+		w := httptest.NewRecorder()
+		rh.PutRegionSettings(w, r)
+		return w
+	}
+
+	// Off by default: no binding, no scopes.
+	role, scopes := resolveAccess(ctx, ms, "default", newcomer)
+	assert.Empty(t, role)
+	assert.Empty(t, scopes)
+
+	require.Equal(t, http.StatusOK, putRole("viewer").Code)
+	role, scopes = resolveAccess(ctx, ms, "default", newcomer)
+	assert.Equal(t, "viewer", role)
+	assert.Contains(t, scopes, store.ScopeConfigRead)
+	assert.NotContains(t, scopes, store.ScopeConfigWrite)
+
+	// An explicit binding wins over the default, even a weaker one.
+	ms.members["default/bound"] = store.RoleEditor
+	role, _ = resolveAccess(ctx, ms, "default", &OIDCClaims{Sub: "bound"})
+	assert.Equal(t, "editor", role)
+
+	assert.Equal(t, http.StatusBadRequest, putRole("owner").Code)
+	assert.Equal(t, http.StatusBadRequest, putRole("no-such-role").Code)
+	ms.customRoles["default/auditor"] = &store.CustomRole{Region: "default", Name: "auditor", Scopes: []string{store.ScopeAuditRead}}
+	assert.Equal(t, http.StatusOK, putRole("auditor").Code)
+	_, scopes = resolveAccess(ctx, ms, "default", newcomer)
+	assert.Equal(t, []string{store.ScopeAuditRead}, scopes)
+}
+
+func TestLastModifiedMetadata(t *testing.T) {
+	ms := newMockStore()
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	ch := NewClusterHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api"}, "create", "alice", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api"}, "update", "bob", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "backend"}, "create", "carol", -1)
+
+	r := httptest.NewRequest("GET", "/api/v1/domains/api", nil)
+	r.SetPathValue("name", "api")
+	w := httptest.NewRecorder()
+	dh.GetDomain(w, withRegion(r, "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, "bob", resp["last_operator"])
+	assert.NotEmpty(t, resp["updated_at"])
+
+	w = httptest.NewRecorder()
+	dh.ListDomains(w, withRegion(httptest.NewRequest("GET", "/api/v1/domains", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	item := decodeResp(t, w)["domains"].([]any)[0].(map[string]any)
+	assert.Equal(t, "api", item["name"])
+	assert.Equal(t, "bob", item["last_operator"])
+	assert.Equal(t, float64(2), item["resource_version"])
+
+	w = httptest.NewRecorder()
+	ch.ListClusters(w, withRegion(httptest.NewRequest("GET", "/api/v1/clusters", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	item = decodeResp(t, w)["clusters"].([]any)[0].(map[string]any)
+	assert.Equal(t, "backend", item["name"])
+	assert.Equal(t, "carol", item["last_operator"])
 }
 
 func TestCredentialHandler_CreateAndList(t *testing.T) {
 	ms := newMockStore()
-	h := NewCredentialHandler(ms, testLogger())
+	h := NewCredentialHandler(ms, nil, nil, testLogger())
 
 	body := jsonBody(map[string]any{
 		"description": "test credential",
@@ -962,7 +2925,7 @@ func TestCredentialHandler_CreateAndList(t *testing.T) {
 
 func TestCredentialHandler_CreateWithInvalidScope(t *testing.T) {
 	ms := newMockStore()
-	h := NewCredentialHandler(ms, testLogger())
+	h := NewCredentialHandler(ms, nil, nil, testLogger())
 
 	body := jsonBody(map[string]any{
 		"description": "bad",
@@ -976,6 +2939,425 @@ func TestCredentialHandler_CreateWithInvalidScope(t *testing.T) {
 	assert.Equal(t, http.StatusBadRequest, w.Code)
 }
 
+type recordingNotifier struct {
+	events []notify.CredentialEvent
+}
+
+func (n *recordingNotifier) Credential(ev notify.CredentialEvent) {
+	n.events = append(n.events, ev)
+}
+
+func TestCredentialScopeEscalation(t *testing.T) {
+	ms := newMockStore()
+	h := NewCredentialHandler(ms, nil, nil, testLogger())
+	sa := NewServiceAccountHandler(ms, testLogger())
+	limited := &Identity{Subject: "ak-limited", Source: "hmac", Scopes: []string{store.ScopeCredentialWrite, store.ScopeConfigRead}}
+	admin := &Identity{Subject: "root", Source: "oidc", Scopes: store.RoleToScopes("", true)}
+
+	call := func(fn http.HandlerFunc, id *Identity, scopes ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/", jsonBody(map[string]any{"name": "sa", "description": "d", "scopes": scopes}))
+		w := httptest.NewRecorder()
+		fn(w, withIdentity(withRegion(r, "default"), id))
+		return w
+	}
+
+	w := call(h.CreateCredential, limited, store.ScopeAdminUsers)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), store.ScopeAdminUsers)
+	assert.Equal(t, http.StatusForbidden, call(h.CreateCredential, limited, store.ScopeConfigRead, store.ScopeConfigWrite).Code)
+	assert.Equal(t, http.StatusForbidden, call(sa.CreateServiceAccount, limited, store.ScopeAdminUsers).Code)
+	assert.Empty(t, ms.creds["default"])
+
+	assert.Equal(t, http.StatusCreated, call(h.CreateCredential, limited, store.ScopeConfigRead).Code, "a subset of the caller's scopes is fine")
+	assert.Equal(t, http.StatusCreated, call(h.CreateCredential, admin, store.ScopeAdminUsers).Code)
+
+	// Updates cannot widen a credential past the caller either.
+	r := httptest.NewRequest("PUT", "/", jsonBody(map[string]any{"scopes": []string{store.ScopeAdminUsers}}))
+	r.SetPathValue("id", "1")
+	w = httptest.NewRecorder()
+	h.UpdateCredential(w, withIdentity(withRegion(r, "default"), limited))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestCredentialExportImport(t *testing.T) {
+	ms := newMockStore()
+	h := NewCredentialHandler(ms, nil, nil, testLogger())
+	ms.creds["staging"] = []store.APICredential{
+		{ID: 1, AccessKey: "ak-1", SecretKey: "sk-1", Description: "ci deploy", Scopes: []string{store.ScopeConfigWrite}, Enabled: true},
+		{ID: 2, AccessKey: "ak-2", SecretKey: "sk-2", Description: "old job", Scopes: []string{store.ScopeConfigRead}},
+	}
+
+	w := httptest.NewRecorder()
+	h.ExportCredentials(w, withRegion(httptest.NewRequest("GET", "/api/v1/credentials/export", nil), "staging"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "ak-1")
+	assert.NotContains(t, w.Body.String(), "sk-1")
+	exported := w.Body.Bytes()
+
+	importTo := func(region string, body io.Reader, id *Identity) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/credentials/import", body)
+		w := httptest.NewRecorder()
+		h.ImportCredentials(w, withIdentity(withRegion(r, region), id))
+		return w
+	}
+	w = importTo("prod", bytes.NewReader(exported), nil)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var resp struct {
+		Credentials []store.APICredential `json:"credentials"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Credentials, 2)
+	assert.Equal(t, "ci deploy", resp.Credentials[0].Description)
+	assert.NotEmpty(t, resp.Credentials[0].SecretKey)
+	assert.NotEqual(t, "ak-1", resp.Credentials[0].AccessKey)
+	assert.False(t, resp.Credentials[1].Enabled)
+	assert.Len(t, ms.creds["prod"], 2)
+
+	// A caller cannot import scopes it does not hold, and a bad entry
+	// creates nothing.
+	limited := &Identity{Subject: "ak-limited", Source: "hmac", Scopes: []string{store.ScopeCredentialWrite, store.ScopeConfigRead}}
+	assert.Equal(t, http.StatusForbidden, importTo("qa", bytes.NewReader(exported), limited).Code)
+	w = importTo("qa", jsonBody(map[string]any{"credentials": []map[string]any{
+		{"description": "ok", "scopes": []string{store.ScopeConfigRead}},
+		{"description": "bad", "scopes": []string{"nope"}},
+	}}), nil)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Empty(t, ms.creds["qa"])
+}
+
+func TestCredentialHandler_Notifications(t *testing.T) {
+	ms := newMockStore()
+	rec := &recordingNotifier{}
+	h := NewCredentialHandler(ms, nil, rec, testLogger())
+
+	call := func(fn http.HandlerFunc, method, path, id string, body any) int {
+		r := httptest.NewRequest(method, path, jsonBody(body))
+		if id != "" {
+			r.SetPathValue("id", id)
+		}
+		r = withIdentity(withRegion(r, "prod"), &Identity{Subject: "u1", Scopes: store.RoleToScopes(store.RoleOwner, false),
+			OIDCClaims: &OIDCClaims{Sub: "u1", PreferredUsername: "alice"}})
+		w := httptest.NewRecorder()
+		fn(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusCreated, call(h.CreateCredential, "POST", "/api/v1/credentials", "",
+		map[string]any{"description": "ci deploy", "scopes": []string{"config:write"}}))
+	require.Len(t, rec.events, 1)
+	created := rec.events[0]
+	assert.Equal(t, "created", created.Action)
+	assert.Equal(t, "prod", created.Region)
+	assert.Equal(t, "alice", created.Actor)
+	assert.Equal(t, "ci deploy", created.Description)
+	assert.Equal(t, []string{"config:write"}, created.Scopes)
+	id := strconv.FormatInt(created.ID, 10)
+
+	require.Equal(t, http.StatusOK, call(h.UpdateCredential, "PUT", "/api/v1/credentials/"+id, id,
+		map[string]any{"description": "ci deploy", "enabled": false, "scopes": []string{"config:write"}}))
+	require.Equal(t, http.StatusOK, call(h.UpdateCredential, "PUT", "/api/v1/credentials/"+id, id,
+		map[string]any{"description": "ci", "enabled": false, "scopes": []string{"config:read"}}))
+	require.Equal(t, http.StatusOK, call(h.DeleteCredential, "DELETE", "/api/v1/credentials/"+id, id, nil))
+
+	require.Len(t, rec.events, 4)
+	assert.Equal(t, "disabled", rec.events[1].Action)
+	assert.Equal(t, created.AccessKey, rec.events[1].AccessKey)
+	assert.Equal(t, "updated", rec.events[2].Action)
+	assert.Equal(t, []string{"config:read"}, rec.events[2].Scopes)
+	assert.Equal(t, "deleted", rec.events[3].Action)
+	assert.Equal(t, "ci", rec.events[3].Description)
+
+	// Failed requests notify nobody.
+	require.Equal(t, http.StatusBadRequest, call(h.CreateCredential, "POST", "/api/v1/credentials", "",
+		map[string]any{"scopes": []string{"bogus"}}))
+	assert.Len(t, rec.events, 4)
+}
+
+func TestCredentialHandler_BulkEnableDisable(t *testing.T) {
+	ms := newMockStore()
+	rec := &recordingNotifier{}
+	h := NewCredentialHandler(ms, nil, rec, testLogger())
+	ms.creds["prod"] = []store.APICredential{
+		{ID: 1, AccessKey: "ak-1", Description: "ci deploy", Scopes: []string{store.ScopeConfigWrite}, Enabled: true},
+		{ID: 2, AccessKey: "ak-2", Description: "ci read", Scopes: []string{store.ScopeConfigRead}, Enabled: true},
+		{ID: 3, AccessKey: "ak-3", Description: "batch deploy", Scopes: []string{store.ScopeConfigWrite}, Enabled: true},
+	}
+	call := func(fn http.HandlerFunc, body any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		fn(w, withRegion(httptest.NewRequest("POST", "/api/v1/credentials/bulk-disable", jsonBody(body)), "prod"))
+		return w
+	}
+	enabled := func() []bool {
+		var out []bool
+		for _, c := range ms.creds["prod"] {
+			out = append(out, c.Enabled)
+		}
+		return out
+	}
+
+	w := call(h.BulkDisableCredentials, map[string]string{"scope": store.ScopeConfigWrite, "description": "^ci "})
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, []any{float64(1)}, resp["ids"])
+	assert.Equal(t, []bool{false, true, true}, enabled())
+
+	w = call(h.BulkDisableCredentials, map[string]string{"scope": store.ScopeConfigWrite})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []any{float64(3)}, decodeResp(t, w)["ids"], "already disabled credentials are not reported")
+	assert.Equal(t, []bool{false, true, false}, enabled())
+
+	w = call(h.BulkEnableCredentials, map[string]string{"description": "deploy"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResp(t, w)["count"])
+	assert.Equal(t, []bool{true, true, true}, enabled())
+
+	require.Len(t, rec.events, 4)
+	assert.Equal(t, "disabled", rec.events[0].Action)
+	assert.Equal(t, "ak-1", rec.events[0].AccessKey)
+	assert.Equal(t, "enabled", rec.events[3].Action)
+	require.Len(t, ms.auditLog, 4)
+	assert.Equal(t, "disable", ms.auditLog[0].Action)
+	assert.Equal(t, "1", ms.auditLog[0].Name)
+
+	for _, body := range []map[string]string{
+		{},
+		{"scope": "bogus"},
+		{"description": "("},
+	} {
+		assert.Equal(t, http.StatusBadRequest, call(h.BulkDisableCredentials, body).Code, body)
+	}
+}
+
+func TestServiceAccount_CreateAuthenticateRevoke(t *testing.T) {
+	ms := newMockStore()
+	h := NewServiceAccountHandler(ms, testLogger())
+
+	create := func(name string, scopes []string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/service-accounts", jsonBody(map[string]any{"name": name, "scopes": scopes}))
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.CreateServiceAccount(w, r)
+		return w
+	}
+
+	w := create("deployer", []string{"config:read"})
+	require.Equal(t, http.StatusCreated, w.Code)
+	resp := decodeResp(t, w)
+	token, _ := resp["token"].(string)
+	require.True(t, strings.HasPrefix(token, serviceAccountTokenPrefix))
+	assert.True(t, strings.HasPrefix(token, resp["token_prefix"].(string)))
+	for hash := range ms.svcByHash {
+		assert.NotEqual(t, token, hash, "only the token hash is stored")
+	}
+
+	assert.Equal(t, http.StatusConflict, create("deployer", nil).Code)
+	assert.Equal(t, http.StatusBadRequest, create("bad", []string{"invalid:scope"}).Code)
+	assert.Equal(t, http.StatusBadRequest, create("", nil).Code)
+
+	// List never exposes the token.
+	r := withRegion(httptest.NewRequest("GET", "/api/v1/service-accounts", nil), "default")
+	w = httptest.NewRecorder()
+	h.ListServiceAccounts(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), token)
+
+	// The token authenticates with the account's scopes.
+	var got *Identity
+	protected := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFromContext(r.Context())
+		w.WriteHeader(http.StatusOK)
+	}), RegionMiddleware, Authenticate(ms, nil, nil, testLogger()), RequireScope(store.ScopeConfigRead))
+	call := func(tok string) int {
+		r := httptest.NewRequest("GET", "/api/v1/domains", nil)
+		r.Header.Set("Authorization", "Bearer "+tok)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, call(token))
+	require.NotNil(t, got)
+	assert.Equal(t, "service_account", got.Source)
+	assert.Equal(t, "service-account:deployer", got.Subject)
+	assert.Equal(t, "default", got.Region)
+
+	assert.Equal(t, http.StatusUnauthorized, call(serviceAccountTokenPrefix+"bogus"))
+
+	// Anonymous bootstrap access is closed once a service account exists.
+	w = httptest.NewRecorder()
+	protected.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/domains", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	// Revoke.
+	id := int64(resp["id"].(float64))
+	r = httptest.NewRequest("DELETE", "/api/v1/service-accounts/"+strconv.FormatInt(id, 10), nil)
+	r.SetPathValue("id", strconv.FormatInt(id, 10))
+	r = withRegion(r, "default")
+	w = httptest.NewRecorder()
+	h.DeleteServiceAccount(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusUnauthorized, call(token))
+	assert.Equal(t, "delete", ms.auditLog[len(ms.auditLog)-1].Action)
+}
+
+func TestAuthenticate_RegionScopedCredential(t *testing.T) {
+	ms := newMockStore()
+	ctx := context.Background()
+	ms.CreateAPICredential(ctx, "staging", &store.APICredential{AccessKey: "ak-staging", SecretKey: "sk", Scopes: []string{store.ScopeConfigRead}, Enabled: true})
+	ms.CreateAPICredential(ctx, "staging", &store.APICredential{AccessKey: "ak-admin", SecretKey: "sk", Scopes: []string{store.ScopeConfigRead, store.ScopeAdminUsers}, Enabled: true})
+
+	protected := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), RegionMiddleware, Authenticate(ms, nil, nil, testLogger()), RequireScope(store.ScopeConfigRead))
+	call := func(ak, region string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/domains", nil)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		sig := computeHMACSHA256("sk", "GET\n/api/v1/domains\n"+ts+"\n"+sha256Hex(nil))
+		r.Header.Set("Authorization", "HMAC-SHA256 Credential="+ak+",Signature="+sig)
+		r.Header.Set("X-Hermes-Timestamp", ts)
+		r.Header.Set("X-Hermes-Region", region)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, call("ak-staging", "staging").Code)
+	w := call("ak-staging", "default")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), `region \"staging\"`)
+	assert.Equal(t, http.StatusOK, call("ak-admin", "default").Code, "admins act across regions")
+}
+
+func TestAuthenticate_ServiceAccountBoundToRegion(t *testing.T) {
+	ms := newMockStore()
+	h := NewServiceAccountHandler(ms, testLogger())
+	r := withRegion(httptest.NewRequest("POST", "/api/v1/service-accounts",
+		jsonBody(map[string]any{"name": "deployer", "scopes": []string{store.ScopeConfigRead, store.ScopeConfigWrite}})), "staging")
+	w := httptest.NewRecorder()
+	h.CreateServiceAccount(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	token, _ := decodeResp(t, w)["token"].(string)
+
+	protected := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), RegionMiddleware, Authenticate(ms, nil, nil, testLogger()), RequireScope(store.ScopeConfigWrite))
+	call := func(region string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/domains/api", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Hermes-Region", region)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, call("staging").Code)
+	w = call("default")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "service-account:deployer")
+}
+
+// Region settings routes address the region by path, so the caller's scopes
+// must be checked against that region, not the X-Hermes-Region header.
+func TestRegionSettings_ScopedToPathRegion(t *testing.T) {
+	ms := newMockStore()
+	ms.settings["staging"] = &store.RegionSettings{}
+	sa := NewServiceAccountHandler(ms, testLogger())
+	r := withRegion(httptest.NewRequest("POST", "/api/v1/service-accounts",
+		jsonBody(map[string]any{"name": "ops", "scopes": []string{store.ScopeRegionRead, store.ScopeRegionWrite}})), "staging")
+	w := httptest.NewRecorder()
+	sa.CreateServiceAccount(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	token, _ := decodeResp(t, w)["token"].(string)
+
+	regions := NewRegionHandler(ms, testLogger(), nil)
+	authMW := Authenticate(ms, nil, nil, testLogger())
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/v1/regions/{name}/settings", Wrap(http.HandlerFunc(regions.GetRegionSettings), PathRegion, authMW, RequireScope(store.ScopeRegionRead)))
+	mux.Handle("PUT /api/v1/regions/{name}/settings", Wrap(http.HandlerFunc(regions.PutRegionSettings), PathRegion, authMW, RequireScope(store.ScopeRegionWrite)))
+	call := func(method, pathRegion, headerRegion string) int {
+		r := httptest.NewRequest(method, "/api/v1/regions/"+pathRegion+"/settings", jsonBody(map[string]any{}))
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Hermes-Region", headerRegion)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, call("GET", "default", "staging"))
+	assert.Equal(t, http.StatusForbidden, call("PUT", "default", "staging"))
+	assert.Equal(t, http.StatusOK, call("PUT", "staging", "default"))
+}
+
+func TestRegionTargets_ScopedToPathRegion(t *testing.T) {
+	ms := newMockStore()
+	ms.settings["staging"] = &store.RegionSettings{}
+	sa := NewServiceAccountHandler(ms, testLogger())
+	r := withRegion(httptest.NewRequest("POST", "/api/v1/service-accounts",
+		jsonBody(map[string]any{"name": "ops", "scopes": []string{store.ScopeRegionRead}})), "staging")
+	w := httptest.NewRecorder()
+	sa.CreateServiceAccount(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	token, _ := decodeResp(t, w)["token"].(string)
+
+	regions := NewRegionHandler(ms, testLogger(), nil)
+	mux := http.NewServeMux()
+	mux.Handle("GET /api/v1/regions/{name}/targets", Wrap(http.HandlerFunc(regions.GetRegionTargets),
+		PathRegion, Authenticate(ms, nil, nil, testLogger()), RequireScope(store.ScopeRegionRead)))
+	call := func(pathRegion, headerRegion string) int {
+		r := httptest.NewRequest("GET", "/api/v1/regions/"+pathRegion+"/targets", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		r.Header.Set("X-Hermes-Region", headerRegion)
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, call("default", "staging"))
+	assert.Equal(t, http.StatusOK, call("staging", "default"))
+}
+
+func TestCredentialSecretSealedAtRest(t *testing.T) {
+	box, err := secretbox.New(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	ms := newMockStore()
+	h := NewCredentialHandler(ms, box, nil, testLogger())
+
+	r := withRegion(httptest.NewRequest("POST", "/api/v1/credentials",
+		jsonBody(map[string]any{"description": "ci", "scopes": []string{store.ScopeConfigRead}})), "default")
+	w := httptest.NewRecorder()
+	h.CreateCredential(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var created store.APICredential
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+	require.Len(t, created.SecretKey, 64, "the response carries the plaintext key")
+
+	stored := ms.credsByAK[created.AccessKey].SecretKey
+	assert.True(t, secretbox.IsSealed(stored))
+	assert.NotContains(t, stored, created.SecretKey)
+
+	// A credential stored before master_key was set, sealed at startup.
+	ms.CreateAPICredential(context.Background(), "default", &store.APICredential{AccessKey: "ak-legacy", SecretKey: "sk-legacy", Scopes: []string{store.ScopeConfigRead}, Enabled: true})
+	n, err := ms.SealAPICredentialSecrets(context.Background(), func(sk string) (string, error) { return box.Seal([]byte(sk)) })
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	call := func(box *secretbox.Box, ak, sk string) int {
+		protected := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		}), RegionMiddleware, Authenticate(ms, box, nil, testLogger()))
+		r := httptest.NewRequest("GET", "/api/v1/domains", nil)
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		r.Header.Set("Authorization", "HMAC-SHA256 Credential="+ak+",Signature="+computeHMACSHA256(sk, "GET\n/api/v1/domains\n"+ts+"\n"+sha256Hex(nil)))
+		r.Header.Set("X-Hermes-Timestamp", ts)
+		w := httptest.NewRecorder()
+		protected.ServeHTTP(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, call(box, created.AccessKey, created.SecretKey))
+	assert.Equal(t, http.StatusOK, call(box, "ak-legacy", "sk-legacy"))
+	assert.Equal(t, http.StatusUnauthorized, call(box, created.AccessKey, stored), "the sealed value is not the key")
+	assert.Equal(t, http.StatusUnauthorized, call(nil, created.AccessKey, created.SecretKey), "sealed keys need the master key")
+}
+
 func TestGrafanaHandler_CreateAndDelete(t *testing.T) {
 	ms := newMockStore()
 	h := NewGrafanaHandler(ms, testLogger())
@@ -1143,6 +3525,170 @@ func TestRecovery_WithPanic(t *testing.T) {
 	assert.Equal(t, http.StatusInternalServerError, w.Code)
 }
 
+func TestMaxBodySize(t *testing.T) {
+	var got string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]string
+		if err := DecodeJSON(r, &v); err != nil {
+			ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		got = v["k"]
+		w.WriteHeader(http.StatusOK)
+	})
+	h := MaxBodySize(32)(next)
+
+	r := httptest.NewRequest("PUT", "/", strings.NewReader(`{"k":"small"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "small", got)
+
+	big := `{"k":"` + strings.Repeat("x", 64) + `"}`
+	r = httptest.NewRequest("PUT", "/", strings.NewReader(big))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	// Chunked bodies carry no Content-Length and are caught while reading.
+	r = httptest.NewRequest("PUT", "/", strings.NewReader(big))
+	r.ContentLength = -1
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+
+	r = httptest.NewRequest("GET", "/", nil)
+	w = httptest.NewRecorder()
+	MaxBodySize(32)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})).ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestAccessLog(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	logger := zap.New(core).Sugar()
+
+	app := http.NewServeMux()
+	app.Handle("PUT /api/v1/domains/api", RegionMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r = withIdentity(r, &Identity{Subject: "alice"})
+		JSON(w, http.StatusCreated, map[string]string{"name": "api"})
+	})))
+	app.HandleFunc("GET /api/v1/config/watch", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("fail") != "" {
+			ErrJSON(w, http.StatusInternalServerError, "boom")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := AccessLog(logger, map[string]float64{"/api/v1/config/watch": 0})(app)
+
+	r := httptest.NewRequest("PUT", "/api/v1/domains/api", nil)
+	r.Header.Set("X-Hermes-Region", "staging")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	require.Equal(t, 1, logs.Len())
+	fields := logs.All()[0].ContextMap()
+	assert.Equal(t, "PUT", fields["method"])
+	assert.Equal(t, "/api/v1/domains/api", fields["path"])
+	assert.Equal(t, "staging", fields["region"])
+	assert.Equal(t, int64(http.StatusCreated), fields["status"])
+	assert.Equal(t, "alice", fields["subject"])
+	assert.NotZero(t, fields["bytes"])
+
+	// Sampled out, except for server errors.
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/config/watch", nil))
+	assert.Equal(t, 1, logs.Len())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/config/watch?fail=1", nil))
+	assert.Equal(t, 2, logs.Len())
+}
+
+func TestRequestID(t *testing.T) {
+	var seen, trace string
+	h := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = RequestIDFromContext(r.Context())
+		trace = TraceIDFromContext(r.Context())
+		ErrJSON(w, http.StatusNotFound, "nope")
+	}))
+	serve := func(headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	// Client-supplied ID is kept and echoed in the header and error body.
+	w := serve(map[string]string{"X-Request-Id": "req-123"})
+	assert.Equal(t, "req-123", seen)
+	assert.Equal(t, "req-123", w.Header().Get("X-Request-Id"))
+	var body map[string]string
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	assert.Equal(t, "req-123", body["request_id"])
+	assert.Equal(t, "nope", body["error"])
+
+	// traceparent supplies the trace ID, and the request ID when none is given.
+	tp := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	serve(map[string]string{"traceparent": tp})
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace)
+	assert.Equal(t, trace, seen)
+	serve(map[string]string{"traceparent": tp, "X-Request-Id": "req-456"})
+	assert.Equal(t, "req-456", seen)
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", trace)
+
+	// Otherwise one is generated; malformed input is not trusted.
+	serve(nil)
+	assert.Len(t, seen, 32)
+	assert.Empty(t, trace)
+	serve(map[string]string{"X-Request-Id": "bad id\r\ninjected"})
+	assert.Len(t, seen, 32)
+	serve(map[string]string{"X-Request-Id": strings.Repeat("x", 200)})
+	assert.Len(t, seen, 32)
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", ""},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", ""},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", ""},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", ""},
+		{"00-4bf92f35-00f067aa0ba902b7-01", ""},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, parseTraceparent(tt.header), tt.header)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/domains/{name}", func(w http.ResponseWriter, r *http.Request) {
+		ErrJSON(w, http.StatusInternalServerError, "boom")
+	})
+	r := httptest.NewRequest("GET", "/api/v1/domains/api", nil)
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	Tracing(mux).ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	assert.Equal(t, "GET /api/v1/domains/{name}", span.Name(), "named after the route, not the path")
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", span.SpanContext().TraceID().String(), "continues the caller's trace")
+	assert.Equal(t, codes.Error, span.Status().Code)
+	assert.Contains(t, span.Attributes(), attribute.Int("http.response.status_code", http.StatusInternalServerError))
+}
+
 func TestIdentity_HasScope(t *testing.T) {
 	id := &Identity{Scopes: []string{"config:read", "config:write"}}
 	assert.True(t, id.HasScope("config:read"))
@@ -1345,6 +3891,26 @@ func TestCORS_Preflight(t *testing.T) {
 	assert.Contains(t, w.Header().Get("Access-Control-Allow-Headers"), "Authorization")
 }
 
+func TestCORSWithOrigins(t *testing.T) {
+	origins := config.NewDynamic([]string{"https://ui.example.com"})
+	h := CORSWithOrigins(origins)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	allowOrigin := func(origin string) string {
+		r := httptest.NewRequest("OPTIONS", "/api/v1/domains", nil)
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, http.StatusNoContent, w.Code)
+		return w.Header().Get("Access-Control-Allow-Origin")
+	}
+
+	assert.Equal(t, "https://ui.example.com", allowOrigin("https://ui.example.com"))
+	assert.Empty(t, allowOrigin("https://evil.example.com"))
+
+	// Reloaded origins apply to the next request.
+	origins.Store([]string{"*"})
+	assert.Equal(t, "*", allowOrigin("https://evil.example.com"))
+}
+
 func TestCORS_PassThrough(t *testing.T) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
@@ -1370,3 +3936,2600 @@ func TestParseHMACAuthHeader_EmptyString(t *testing.T) {
 	_, _, err := parseHMACAuthHeader("")
 	assert.Error(t, err)
 }
+
+func newTestOIDCHandler(ms *mockStore) *OIDCHandler {
+	return &OIDCHandler{
+		cfg: config.OIDCConfig{
+			ClientID: "hermes",
+			Scopes:   []string{"openid", "profile", "email"},
+			Claims: config.OIDCClaimsConfig{
+				Username: "preferred_username",
+				Email:    "email",
+				Name:     "name",
+				Groups:   "groups",
+			},
+		},
+		store:  ms,
+		logger: testLogger(),
+		endpoints: oidcEndpoints{
+			AuthorizationEndpoint: "https://idp.example.com/auth",
+			TokenEndpoint:         "https://idp.example.com/token",
+			JwksURI:               "https://idp.example.com/certs",
+		},
+	}
+}
+
+func TestOIDCHandler_TestConnection(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	var issuer string
+	secret := "s3cret"
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			JSON(w, http.StatusOK, map[string]string{
+				"issuer":                 issuer,
+				"authorization_endpoint": issuer + "/auth",
+				"token_endpoint":         issuer + "/token",
+				"jwks_uri":               issuer + "/certs",
+			})
+		case "/certs":
+			JSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+				"kid": "k1", "kty": "RSA",
+				"n": base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}}})
+		case "/token":
+			require.NoError(t, r.ParseForm())
+			if r.PostForm.Get("client_secret") != secret {
+				JSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client", "error_description": "bad secret"})
+				return
+			}
+			JSON(w, http.StatusBadRequest, map[string]string{"error": "unauthorized_client"})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer idp.Close()
+	issuer = idp.URL
+
+	h := newTestOIDCHandler(newMockStore())
+	h.cfg.Issuer = issuer
+	h.cfg.ClientSecret = secret
+	h.endpoints = oidcEndpoints{Issuer: issuer, AuthorizationEndpoint: issuer + "/auth", TokenEndpoint: issuer + "/token", JwksURI: issuer + "/certs"}
+	test := func() (bool, map[string]map[string]any) {
+		w := httptest.NewRecorder()
+		h.TestConnection(w, httptest.NewRequest("GET", "/api/v1/admin/oidc/test", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+		resp := decodeResp(t, w)
+		checks := map[string]map[string]any{}
+		for _, c := range resp["checks"].([]any) {
+			checks[c.(map[string]any)["name"].(string)] = c.(map[string]any)
+		}
+		return resp["passed"].(bool), checks
+	}
+
+	passed, checks := test()
+	assert.True(t, passed)
+	assert.Equal(t, "pass", checks["discovery"]["status"])
+	assert.Equal(t, "pass", checks["jwks"]["status"])
+	assert.Contains(t, checks["jwks"]["detail"], "1 RSA signing keys")
+	assert.Equal(t, "pass", checks["client_credentials"]["status"])
+
+	h.cfg.ClientSecret = "wrong"
+	passed, checks = test()
+	assert.False(t, passed)
+	assert.Equal(t, "fail", checks["client_credentials"]["status"])
+	assert.Contains(t, checks["client_credentials"]["detail"], "bad secret")
+
+	h.cfg.ClientSecret = ""
+	passed, checks = test()
+	assert.True(t, passed)
+	assert.Equal(t, "skip", checks["client_credentials"]["status"])
+
+	// Discovery against the wrong issuer fails; JWKS is still tried at the
+	// endpoint the handler started with.
+	h.cfg.Issuer = issuer + "/realms/missing"
+	passed, checks = test()
+	assert.False(t, passed)
+	assert.Equal(t, "fail", checks["discovery"]["status"])
+	assert.Equal(t, "pass", checks["jwks"]["status"])
+}
+
+func TestOIDCHandler_Login_PKCE(t *testing.T) {
+	ms := newMockStore()
+	h := newTestOIDCHandler(ms)
+
+	r := httptest.NewRequest("GET", "/api/auth/login?redirect=/clusters", nil)
+	w := httptest.NewRecorder()
+	h.Login(w, r)
+	require.Equal(t, http.StatusFound, w.Code)
+
+	loc, err := url.Parse(w.Header().Get("Location"))
+	require.NoError(t, err)
+	q := loc.Query()
+	assert.Equal(t, "S256", q.Get("code_challenge_method"))
+	assert.Equal(t, "openid profile email", q.Get("scope"))
+	require.NotEmpty(t, q.Get("state"))
+
+	st := ms.authStates[q.Get("state")]
+	require.NotNil(t, st)
+	assert.Len(t, st.CodeVerifier, 43)
+	assert.Equal(t, pkceChallengeS256(st.CodeVerifier), q.Get("code_challenge"))
+	assert.Equal(t, "/clusters", st.RedirectPath)
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, oidcStateCookie, cookies[0].Name)
+	assert.Equal(t, q.Get("state"), cookies[0].Value)
+	assert.True(t, cookies[0].HttpOnly)
+}
+
+func TestSafeRedirectPath(t *testing.T) {
+	assert.Equal(t, "/domains/api", safeRedirectPath("/domains/api"))
+	assert.Equal(t, "/domains?x=1", safeRedirectPath("/domains?x=1"))
+	assert.Equal(t, "/", safeRedirectPath(""))
+	assert.Equal(t, "/", safeRedirectPath("https://evil.example.com"))
+	assert.Equal(t, "/", safeRedirectPath("//evil.example.com"))
+	assert.Equal(t, "/", safeRedirectPath("/\\evil.example.com"))
+	assert.Equal(t, "/", safeRedirectPath("domains"))
+}
+
+func TestPKCEChallengeS256_RFC7636Vector(t *testing.T) {
+	// Appendix B of RFC 7636.
+	assert.Equal(t, "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		pkceChallengeS256("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"))
+}
+
+func TestOIDCHandler_Callback_UnknownState(t *testing.T) {
+	h := newTestOIDCHandler(newMockStore())
+
+	r := httptest.NewRequest("GET", "/api/auth/token?code=abc&state=unknown", nil)
+	r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "unknown"})
+	w := httptest.NewRecorder()
+	h.Callback(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "invalid or expired")
+}
+
+func TestOIDCHandler_Callback_MissingState(t *testing.T) {
+	h := newTestOIDCHandler(newMockStore())
+
+	r := httptest.NewRequest("GET", "/api/auth/token?code=abc", nil)
+	w := httptest.NewRecorder()
+	h.Callback(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "state is required")
+}
+
+func TestOIDCHandler_Callback_ForgedState(t *testing.T) {
+	ms := newMockStore()
+	h := newTestOIDCHandler(ms)
+
+	// Attacker starts a login and obtains a valid state of their own.
+	w := httptest.NewRecorder()
+	h.Login(w, httptest.NewRequest("GET", "/api/auth/login", nil))
+	loc, _ := url.Parse(w.Header().Get("Location"))
+	attackerState := loc.Query().Get("state")
+
+	// Victim's browser carries a different state cookie.
+	r := httptest.NewRequest("GET", "/api/auth/token?code=abc&state="+attackerState, nil)
+	r.AddCookie(&http.Cookie{Name: oidcStateCookie, Value: "victim-state"})
+	w = httptest.NewRecorder()
+	h.Callback(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "state mismatch")
+	// The attacker's pending login must not have been consumed.
+	assert.NotNil(t, ms.authStates[attackerState])
+
+	// No cookie at all is rejected too.
+	r = httptest.NewRequest("GET", "/api/auth/token?code=abc&state="+attackerState, nil)
+	w = httptest.NewRecorder()
+	h.Callback(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestMapClaims_CustomNames(t *testing.T) {
+	payload := []byte(`{
+		"sub": "u-1",
+		"exp": 1700000000,
+		"upn": "alice",
+		"mail": "alice@example.com",
+		"display_name": "Alice",
+		"realm_access": {"roles": ["ops", "dev"]}
+	}`)
+	c, err := mapClaims(payload, config.OIDCClaimsConfig{
+		Username: "upn",
+		Email:    "mail",
+		Name:     "display_name",
+		Groups:   "realm_access.roles",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "u-1", c.Sub)
+	assert.Equal(t, int64(1700000000), c.Exp)
+	assert.Equal(t, "alice", c.PreferredUsername)
+	assert.Equal(t, "alice@example.com", c.Email)
+	assert.Equal(t, "Alice", c.Name)
+	assert.Equal(t, []string{"ops", "dev"}, c.Groups)
+
+	// A single-string group claim is accepted; missing claims are empty.
+	c, err = mapClaims([]byte(`{"sub":"u-2","team":"ops"}`), config.OIDCClaimsConfig{Groups: "team"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ops"}, c.Groups)
+	assert.Empty(t, c.Email)
+}
+
+func TestJWKSCache_UnknownKidRefreshIsRateLimited(t *testing.T) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	var hits atomic.Int32
+	kid := "k1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		JSON(w, http.StatusOK, map[string]any{"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(priv.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(priv.E)).Bytes()),
+		}}})
+	}))
+	defer srv.Close()
+
+	c := newJWKSCache(srv.URL)
+	key, err := c.getKey("k1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, key.N.Cmp(priv.N))
+	assert.Equal(t, int32(1), hits.Load())
+
+	// Cached hit: no fetch.
+	_, err = c.getKey("k1")
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), hits.Load())
+
+	// IdP rotates keys, but the min refresh interval has not elapsed.
+	kid = "k2"
+	_, err = c.getKey("k2")
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), hits.Load())
+
+	// Once allowed, an unknown kid triggers a refresh that picks up the new key.
+	c.minInterval = 0
+	_, err = c.getKey("k2")
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), hits.Load())
+}
+
+func TestJWKSCache_RefreshFailureCounted(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	before := jwksRefreshFailures.Value()
+	c := newJWKSCache(srv.URL)
+	_, err := c.getKey("k1")
+	assert.Error(t, err)
+	assert.Equal(t, before+1, jwksRefreshFailures.Value())
+}
+
+// newTestBuiltinAuthHandler returns a handler with a signing key and one
+// builtin user (alice@example.com / "correct-password").
+func newTestBuiltinAuthHandler(t *testing.T, ms *mockStore, cfg config.BuiltinAuthConfig) *BuiltinAuthHandler {
+	t.Helper()
+	box, err := secretbox.New(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	h, err := NewBuiltinAuthHandler(cfg, ms, box, nil, testLogger())
+	require.NoError(t, err)
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct-password"), bcrypt.DefaultCost)
+	require.NoError(t, err)
+	sub := "builtin:alice@example.com"
+	require.NoError(t, ms.UpsertUser(context.Background(), &store.User{Sub: sub, Username: "alice", Email: "alice@example.com"}))
+	require.NoError(t, ms.UpdateUserPassword(context.Background(), sub, string(hash)))
+	return h
+}
+
+func builtinLogin(h *BuiltinAuthHandler, email, password string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/api/auth/login", jsonBody(map[string]string{"email": email, "password": password}))
+	w := httptest.NewRecorder()
+	h.Login(w, r)
+	return w
+}
+
+func TestBuiltinLogin_Lockout(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		LockoutThreshold: 3,
+		LockoutWindow:    time.Minute,
+		LockoutCooldown:  time.Minute,
+	})
+
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, http.StatusUnauthorized, builtinLogin(h, "alice@example.com", "wrong").Code)
+	}
+
+	// Locked: even the correct password is refused.
+	w := builtinLogin(h, "alice@example.com", "correct-password")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	require.NotEmpty(t, ms.auditLog)
+	last := ms.auditLog[len(ms.auditLog)-1]
+	assert.Equal(t, "login_lockout", last.Action)
+	assert.Equal(t, "builtin:alice@example.com", last.Name)
+
+	// Cooldown over: login succeeds and clears the counter.
+	ms.lockouts["alice@example.com"] = time.Now().Add(-time.Second)
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+	assert.Zero(t, ms.failures["alice@example.com"])
+}
+
+func TestBuiltinLogin_LockoutIgnoresEmailCase(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		LockoutThreshold: 3,
+		LockoutWindow:    time.Minute,
+		LockoutCooldown:  time.Minute,
+	})
+
+	// Case and surrounding whitespace variants share one failure counter.
+	for _, email := range []string{"Alice@Example.com", " alice@example.com", "ALICE@EXAMPLE.COM "} {
+		assert.Equal(t, http.StatusUnauthorized, builtinLogin(h, email, "wrong").Code)
+	}
+	assert.Equal(t, http.StatusTooManyRequests, builtinLogin(h, "alice@example.com", "correct-password").Code)
+	assert.Contains(t, ms.lockouts, "alice@example.com")
+}
+
+func TestBuiltinLogin_SuccessResetsFailures(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		LockoutThreshold: 3,
+		LockoutWindow:    time.Minute,
+		LockoutCooldown:  time.Minute,
+	})
+
+	builtinLogin(h, "alice@example.com", "wrong")
+	builtinLogin(h, "alice@example.com", "wrong")
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+	builtinLogin(h, "alice@example.com", "wrong")
+	builtinLogin(h, "alice@example.com", "wrong")
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+}
+
+func TestValidatePassword(t *testing.T) {
+	policy := config.PasswordPolicyConfig{MinLength: 8, MinCharClasses: 3, RejectCommon: true}
+
+	assert.Empty(t, ValidatePassword("Tr1cky-horse", policy))
+	assert.Contains(t, ValidatePassword("Ab1!", policy), "at least 8 characters")
+	assert.Contains(t, ValidatePassword("alllowercase", policy), "at least 3 of")
+	assert.Contains(t, ValidatePassword("P@ssw0rd", policy), "too common")
+
+	// Knobs can be relaxed.
+	assert.Empty(t, ValidatePassword("password", config.PasswordPolicyConfig{MinLength: 6}))
+}
+
+func TestChangePassword_PolicyRejected(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		PasswordPolicy: config.PasswordPolicyConfig{MinLength: 8, MinCharClasses: 2, RejectCommon: true},
+	})
+
+	change := func(newPassword string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/auth/change-password", jsonBody(map[string]string{
+			"old_password": "correct-password",
+			"new_password": newPassword,
+		}))
+		r = r.WithContext(context.WithValue(r.Context(), identityKey, &Identity{Subject: "builtin:alice@example.com"}))
+		w := httptest.NewRecorder()
+		h.ChangePassword(w, r)
+		return w
+	}
+
+	w := change("12345")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at least 8 characters")
+
+	w = change("password123")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "too common")
+
+	assert.Equal(t, http.StatusOK, change("Sturdy-Lantern-42").Code)
+}
+
+func TestCreateBuiltinUser_PolicyRejected(t *testing.T) {
+	h := NewMemberHandler(newMockStore(), nil, testLogger(), config.PasswordPolicyConfig{MinLength: 10})
+
+	r := httptest.NewRequest("POST", "/api/v1/users", jsonBody(map[string]string{
+		"email":    "bob@example.com",
+		"password": "short",
+	}))
+	w := httptest.NewRecorder()
+	h.CreateBuiltinUser(w, r)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "at least 10 characters")
+}
+
+type sentMail struct{ to, subject, body string }
+
+type recordingMailer struct{ sent []sentMail }
+
+func (m *recordingMailer) MailUser(to, subject, body string) {
+	m.sent = append(m.sent, sentMail{to, subject, body})
+}
+
+func TestBuiltinUserMail(t *testing.T) {
+	ms := newMockStore()
+	mailer := &recordingMailer{}
+	mh := NewMemberHandler(ms, mailer, testLogger(), config.PasswordPolicyConfig{})
+
+	r := httptest.NewRequest("POST", "/api/v1/users", jsonBody(map[string]string{
+		"email":    "Bob@Example.com",
+		"password": "Temp-Password-1",
+	}))
+	w := httptest.NewRecorder()
+	mh.CreateBuiltinUser(w, r)
+	require.Equal(t, http.StatusCreated, w.Code)
+	require.Len(t, mailer.sent, 1)
+	assert.Equal(t, "bob@example.com", mailer.sent[0].to)
+	assert.Contains(t, mailer.sent[0].body, "Temp-Password-1")
+
+	force := func(must bool) {
+		r := httptest.NewRequest("PUT", "/api/v1/users/builtin:bob@example.com/force-password-change",
+			jsonBody(map[string]bool{"must_change_password": must}))
+		r.SetPathValue("sub", "builtin:bob@example.com")
+		w := httptest.NewRecorder()
+		mh.ForcePasswordChange(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	force(false)
+	assert.Len(t, mailer.sent, 1, "clearing the flag sends nothing")
+	force(true)
+	require.Len(t, mailer.sent, 2)
+	assert.Equal(t, "bob@example.com", mailer.sent[1].to)
+	assert.Contains(t, mailer.sent[1].subject, "change your password")
+
+	// Lockout mails the account owner, but never an address with no account.
+	box, err := secretbox.New(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	h, err := NewBuiltinAuthHandler(config.BuiltinAuthConfig{
+		LockoutThreshold: 2, LockoutWindow: time.Minute, LockoutCooldown: time.Minute,
+	}, ms, box, mailer, testLogger())
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		builtinLogin(h, "bob@example.com", "wrong")
+		builtinLogin(h, "nobody@example.com", "wrong")
+	}
+	require.Len(t, mailer.sent, 3)
+	assert.Equal(t, "bob@example.com", mailer.sent[2].to)
+	assert.Contains(t, mailer.sent[2].subject, "locked")
+}
+
+func TestChangePassword_RejectsReuse(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		PasswordPolicy: config.PasswordPolicyConfig{MinLength: 8, HistorySize: 2},
+	})
+	sub := "builtin:alice@example.com"
+	current := "correct-password"
+
+	change := func(newPassword string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/auth/change-password", jsonBody(map[string]string{
+			"old_password": current,
+			"new_password": newPassword,
+		}))
+		r = r.WithContext(context.WithValue(r.Context(), identityKey, &Identity{Subject: sub}))
+		w := httptest.NewRecorder()
+		h.ChangePassword(w, r)
+		if w.Code == http.StatusOK {
+			current = newPassword
+		}
+		return w
+	}
+
+	// Current password cannot be reused.
+	w := change("correct-password")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "used recently")
+
+	require.Equal(t, http.StatusOK, change("second-password").Code)
+	require.Equal(t, http.StatusOK, change("third-password").Code)
+	assert.Equal(t, http.StatusBadRequest, change("second-password").Code)
+
+	// Older than the history window: allowed again.
+	require.Equal(t, http.StatusOK, change("fourth-password").Code)
+	assert.Equal(t, http.StatusOK, change("second-password").Code)
+	assert.Len(t, ms.pwHistory[sub], 2)
+}
+
+func TestResetUserPassword_RejectsReuse(t *testing.T) {
+	ms := newMockStore()
+	newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	h := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{MinLength: 8, HistorySize: 5})
+
+	reset := func(pw string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/users/builtin:alice@example.com/reset-password", jsonBody(map[string]string{"new_password": pw}))
+		r.SetPathValue("sub", "builtin:alice@example.com")
+		w := httptest.NewRecorder()
+		h.ResetUserPassword(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, reset("correct-password").Code)
+	assert.Equal(t, http.StatusOK, reset("brand-new-password").Code)
+	assert.Equal(t, http.StatusBadRequest, reset("brand-new-password").Code)
+}
+
+func TestValidateTOTP_Skew(t *testing.T) {
+	key, err := generateTOTPKey("alice@example.com")
+	require.NoError(t, err)
+	now := time.Unix(1234567890, 0)
+	step := now.Unix() / 30
+
+	code, err := totp.GenerateCodeCustom(key.Secret(), now, totpOpts)
+	require.NoError(t, err)
+	got, ok := validateTOTP(key.Secret(), code, now)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// One step of drift either way is accepted and reports the code's step.
+	prev, _ := totp.GenerateCodeCustom(key.Secret(), now.Add(-30*time.Second), totpOpts)
+	got, ok = validateTOTP(key.Secret(), prev, now)
+	assert.True(t, ok)
+	assert.Equal(t, step-1, got)
+
+	old, _ := totp.GenerateCodeCustom(key.Secret(), now.Add(-90*time.Second), totpOpts)
+	if old != code && old != prev {
+		_, ok = validateTOTP(key.Secret(), old, now)
+		assert.False(t, ok)
+	}
+}
+
+func TestBuiltinTOTP_EnrollVerifyLogin(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	sub := "builtin:alice@example.com"
+	asAlice := func(r *http.Request) *http.Request {
+		return r.WithContext(context.WithValue(r.Context(), identityKey, &Identity{Subject: sub}))
+	}
+
+	// Enroll.
+	w := httptest.NewRecorder()
+	h.EnrollTOTP(w, asAlice(httptest.NewRequest("POST", "/api/auth/totp/enroll", nil)))
+	require.Equal(t, http.StatusOK, w.Code)
+	enroll := decodeResp(t, w)
+	secret, _ := enroll["secret"].(string)
+	require.NotEmpty(t, secret)
+	assert.Contains(t, enroll["otpauth_url"], "otpauth://totp/")
+	assert.NotContains(t, ms.totp[sub].sealed, secret, "secret must be sealed at rest")
+
+	// Not enforced until verified.
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+
+	code, err := totp.GenerateCodeCustom(secret, time.Now(), totpOpts)
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	h.VerifyTOTP(w, asAlice(httptest.NewRequest("POST", "/api/auth/totp/verify", jsonBody(map[string]string{"code": "000000"}))))
+	if code != "000000" {
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	}
+	w = httptest.NewRecorder()
+	h.VerifyTOTP(w, asAlice(httptest.NewRequest("POST", "/api/auth/totp/verify", jsonBody(map[string]string{"code": code}))))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, ms.totp[sub].enabled)
+
+	// Login now requires the code.
+	w = builtinLogin(h, "alice@example.com", "correct-password")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "totp_required")
+
+	totpLogin := func(code string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/auth/login", jsonBody(map[string]string{
+			"email": "alice@example.com", "password": "correct-password", "totp_code": code,
+		}))
+		w := httptest.NewRecorder()
+		h.Login(w, r)
+		return w
+	}
+	// The code that verified enrollment is spent; the next step's is not.
+	assert.Equal(t, http.StatusUnauthorized, totpLogin(code).Code)
+	next, err := totp.GenerateCodeCustom(secret, time.Now().Add(30*time.Second), totpOpts)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, totpLogin(next).Code)
+	// Replaying it within its window is refused.
+	assert.Equal(t, http.StatusUnauthorized, totpLogin(next).Code)
+
+	// Admin reset removes the requirement.
+	mh := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{})
+	r := httptest.NewRequest("DELETE", "/api/v1/users/"+sub+"/totp", nil)
+	r.SetPathValue("sub", sub)
+	w = httptest.NewRecorder()
+	mh.ResetUserTOTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+}
+
+func TestAuthenticate_MustChangePasswordBlocksUntilChanged(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		PasswordPolicy: config.PasswordPolicyConfig{MinLength: 8},
+	})
+	sub := "builtin:alice@example.com"
+	ms.users[sub].IsAdmin = true
+	ms.users[sub].MustChangePassword = true
+
+	verify := func(string) (*OIDCClaims, error) { return &OIDCClaims{Sub: sub}, nil }
+	authMW := Authenticate(ms, nil, verify, testLogger())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+
+	call := func(method, path string, handler http.Handler, body any) int {
+		var r *http.Request
+		if body != nil {
+			r = httptest.NewRequest(method, path, jsonBody(body))
+		} else {
+			r = httptest.NewRequest(method, path, nil)
+		}
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		authMW(handler).ServeHTTP(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusForbidden, call("GET", "/api/v1/domains", ok, nil))
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/whoami", ok, nil))
+
+	code := call("POST", "/api/auth/change-password", http.HandlerFunc(h.ChangePassword), map[string]string{
+		"old_password": "correct-password",
+		"new_password": "a-fresh-password",
+	})
+	require.Equal(t, http.StatusOK, code)
+	assert.False(t, ms.users[sub].MustChangePassword)
+
+	assert.Equal(t, http.StatusOK, call("GET", "/api/v1/domains", ok, nil))
+}
+
+func TestRevokeSessions(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	mh := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{})
+	sub := "builtin:alice@example.com"
+
+	issuedAt := time.Now().Add(-time.Minute).Unix()
+	verify := func(string) (*OIDCClaims, error) { return &OIDCClaims{Sub: sub, Iat: issuedAt}, nil }
+	authMW := Authenticate(ms, nil, verify, testLogger())
+	call := func() int {
+		r := httptest.NewRequest("GET", "/api/v1/whoami", nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		authMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })).ServeHTTP(w, r)
+		return w.Code
+	}
+	require.Equal(t, http.StatusOK, call())
+	loginResp := decodeResp(t, builtinLogin(h, "alice@example.com", "correct-password"))
+	refresh := loginResp["refresh_token"].(string)
+
+	revoke := func(sub string) int {
+		r := httptest.NewRequest("POST", "/api/v1/users/"+sub+"/revoke-sessions", nil)
+		r.SetPathValue("sub", sub)
+		w := httptest.NewRecorder()
+		mh.RevokeSessions(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusNotFound, revoke("nobody"))
+	require.Equal(t, http.StatusOK, revoke(sub))
+
+	assert.Equal(t, http.StatusUnauthorized, call(), "token issued before the cutoff")
+	r := httptest.NewRequest("POST", "/api/auth/refresh", jsonBody(map[string]string{"refresh_token": refresh}))
+	w := httptest.NewRecorder()
+	h.Refresh(w, r)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "refresh tokens revoked too")
+
+	issuedAt = ms.users[sub].SessionsValidAfter.Unix()
+	assert.Equal(t, http.StatusOK, call(), "tokens from a login in the revocation's second work")
+	issuedAt--
+	assert.Equal(t, http.StatusUnauthorized, call())
+
+	// Without the user record the revocation cannot be checked, so the
+	// token is not let through.
+	issuedAt = time.Now().Add(time.Second).Unix()
+	ms.userErr = errors.New("connection refused")
+	assert.Equal(t, http.StatusServiceUnavailable, call())
+	ms.userErr = nil
+	assert.Equal(t, http.StatusOK, call(), "tokens from a new login work")
+}
+
+func TestDisableUser(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	mh := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{})
+	sub := "builtin:alice@example.com"
+	require.True(t, ms.users[sub].Enabled)
+
+	verify := func(string) (*OIDCClaims, error) { return &OIDCClaims{Sub: sub, Iat: time.Now().Unix()}, nil }
+	authMW := Authenticate(ms, nil, verify, testLogger())
+	call := func() int {
+		r := httptest.NewRequest("GET", "/api/v1/whoami", nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		authMW(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })).ServeHTTP(w, r)
+		return w.Code
+	}
+	refresh := decodeResp(t, builtinLogin(h, "alice@example.com", "correct-password"))["refresh_token"].(string)
+
+	update := func(enabled bool, caller string) int {
+		r := httptest.NewRequest("PUT", "/api/v1/users/"+sub, jsonBody(map[string]bool{"enabled": enabled}))
+		r.SetPathValue("sub", sub)
+		r = withIdentity(r, &Identity{Subject: caller, Source: "oidc"})
+		w := httptest.NewRecorder()
+		mh.UpdateUser(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, update(false, sub), "cannot disable yourself")
+	require.Equal(t, http.StatusOK, update(false, "builtin:admin@example.com"))
+	assert.False(t, ms.users[sub].Enabled)
+
+	assert.Equal(t, http.StatusForbidden, call(), "valid token, disabled account")
+	assert.Equal(t, http.StatusForbidden, builtinLogin(h, "alice@example.com", "correct-password").Code)
+	r := httptest.NewRequest("POST", "/api/auth/refresh", jsonBody(map[string]string{"refresh_token": refresh}))
+	w := httptest.NewRecorder()
+	h.Refresh(w, r)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	// Logging in on a disabled account must not re-enable it.
+	assert.False(t, ms.users[sub].Enabled)
+
+	require.Equal(t, http.StatusOK, update(true, "builtin:admin@example.com"))
+	assert.Equal(t, http.StatusOK, call())
+	assert.Equal(t, http.StatusOK, builtinLogin(h, "alice@example.com", "correct-password").Code)
+}
+
+func TestBuiltinRefresh_RotationAndReuseDetection(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+
+	refresh := func(token string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/auth/refresh", jsonBody(map[string]string{"refresh_token": token}))
+		w := httptest.NewRecorder()
+		h.Refresh(w, r)
+		return w
+	}
+
+	w := builtinLogin(h, "alice@example.com", "correct-password")
+	require.Equal(t, http.StatusOK, w.Code)
+	rt1, _ := decodeResp(t, w)["refresh_token"].(string)
+	require.NotEmpty(t, rt1)
+	for hash := range ms.refresh {
+		assert.NotEqual(t, rt1, hash, "only the hash is stored")
+	}
+
+	// Rotation: rt1 → rt2.
+	w = refresh(rt1)
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	rt2, _ := resp["refresh_token"].(string)
+	assert.NotEmpty(t, resp["access_token"])
+	require.NotEmpty(t, rt2)
+	assert.NotEqual(t, rt1, rt2)
+
+	// Replaying rt1 is reuse: the whole family is revoked, including rt2.
+	w = refresh(rt1)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Contains(t, w.Body.String(), "reuse detected")
+	assert.Equal(t, http.StatusUnauthorized, refresh(rt2).Code)
+	assert.Equal(t, "refresh_token_reuse", ms.auditLog[len(ms.auditLog)-1].Action)
+
+	assert.Equal(t, http.StatusUnauthorized, refresh("bogus").Code)
+}
+
+func TestBuiltinAuth_TokenLifetimes(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		AccessTokenTTL:         10 * time.Minute,
+		KeyRotationGracePeriod: time.Hour,
+	})
+
+	w := builtinLogin(h, "alice@example.com", "correct-password")
+	require.Equal(t, http.StatusOK, w.Code)
+	tok, _ := decodeResp(t, w)["access_token"].(string)
+	parts := strings.Split(tok, ".")
+	require.Len(t, parts, 3)
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.NoError(t, err)
+	var claims struct {
+		Iat int64 `json:"iat"`
+		Exp int64 `json:"exp"`
+	}
+	require.NoError(t, json.Unmarshal(payload, &claims))
+	assert.Equal(t, int64(600), claims.Exp-claims.Iat)
+
+	w = httptest.NewRecorder()
+	h.RotateKey(w, httptest.NewRequest("POST", "/api/auth/rotate-key", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, time.Hour, ms.lastGrace)
+	assert.Equal(t, "1h0m0s", decodeResp(t, w)["grace_period"])
+}
+
+func TestBuiltinAuth_ScheduledKeyRotation(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{
+		AccessTokenTTL:   time.Hour,
+		RotationInterval: 24 * time.Hour,
+	})
+
+	// Fresh key: nothing to do.
+	h.rotateKeyIfDue(context.Background())
+	assert.Empty(t, ms.auditLog)
+
+	oldKID := ms.signingKey.KID
+	ms.signingKey.CreatedAt = time.Now().Add(-25 * time.Hour)
+	h.rotateKeyIfDue(context.Background())
+	assert.NotEqual(t, oldKID, ms.signingKey.KID)
+	assert.Equal(t, time.Hour, ms.lastGrace)
+	require.Len(t, ms.auditLog, 1)
+	assert.Equal(t, "rotate", ms.auditLog[0].Action)
+	assert.Equal(t, "system", ms.auditLog[0].Operator)
+
+	// RunKeyRotation returns once its context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	h.RunKeyRotation(ctx)
+}
+
+func TestBuiltinAuth_AsymmetricSigningAndJWKS(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256"} {
+		t.Run(alg, func(t *testing.T) {
+			ms := newMockStore()
+			h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{SigningAlgorithm: alg})
+			verify := NewBuiltinVerifier(ms)
+
+			w := builtinLogin(h, "alice@example.com", "correct-password")
+			require.Equal(t, http.StatusOK, w.Code)
+			oldTok, _ := decodeResp(t, w)["access_token"].(string)
+			header, err := base64.RawURLEncoding.DecodeString(strings.Split(oldTok, ".")[0])
+			require.NoError(t, err)
+			assert.Contains(t, string(header), `"alg":"`+alg+`"`)
+
+			claims, err := verify(oldTok)
+			require.NoError(t, err)
+			assert.Equal(t, "builtin:alice@example.com", claims.Sub)
+
+			// After rotation both keys are published and the old token
+			// still verifies.
+			w = httptest.NewRecorder()
+			h.RotateKey(w, httptest.NewRequest("POST", "/api/auth/rotate-key", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			_, err = verify(oldTok)
+			assert.NoError(t, err)
+
+			w = httptest.NewRecorder()
+			h.JWKS(w, httptest.NewRequest("GET", "/api/auth/jwks", nil))
+			require.Equal(t, http.StatusOK, w.Code)
+			var jwks struct {
+				Keys []map[string]string `json:"keys"`
+			}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), &jwks))
+			require.Len(t, jwks.Keys, 2)
+			for _, k := range jwks.Keys {
+				assert.Equal(t, alg, k["alg"])
+				assert.NotEmpty(t, k["kid"])
+				assert.Empty(t, k["d"], "private material must not be published")
+			}
+
+			// A token whose header claims HS256 must not verify against an
+			// asymmetric key.
+			parts := strings.Split(oldTok, ".")
+			forged := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT","kid":"`+ms.retiredKeys[0].KID+`"}`)) + "." + parts[1] + "." + parts[2]
+			_, err = verify(forged)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestBuiltinAuth_JWKSOmitsHMACKeys(t *testing.T) {
+	ms := newMockStore()
+	h := newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	assert.Equal(t, "HS256", ms.signingKey.Algorithm)
+
+	w := httptest.NewRecorder()
+	h.JWKS(w, httptest.NewRequest("GET", "/api/auth/jwks", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"keys":[]}`, w.Body.String())
+}
+
+func TestBuiltinAuth_AlgorithmChangeRotatesKey(t *testing.T) {
+	ms := newMockStore()
+	newTestBuiltinAuthHandler(t, ms, config.BuiltinAuthConfig{})
+	oldKID := ms.signingKey.KID
+
+	_, err := NewBuiltinAuthHandler(config.BuiltinAuthConfig{SigningAlgorithm: "ES256"}, ms, nil, nil, testLogger())
+	require.NoError(t, err)
+	assert.Equal(t, "ES256", ms.signingKey.Algorithm)
+	require.Len(t, ms.retiredKeys, 1)
+	assert.Equal(t, oldKID, ms.retiredKeys[0].KID)
+}
+
+func TestMemberHandler_Impersonate(t *testing.T) {
+	ms := newMockStore()
+	h := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{})
+	ctx := context.Background()
+	require.NoError(t, ms.UpsertUser(ctx, &store.User{Sub: "bob", Username: "bob"}))
+	require.NoError(t, ms.SetRegionMember(ctx, "default", "bob", store.RoleViewer))
+	require.NoError(t, ms.SetGroupBinding(ctx, "default", "sre", store.RoleEditor))
+
+	impersonate := func(body map[string]any) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/admin/impersonate", jsonBody(body))
+		w := httptest.NewRecorder()
+		h.Impersonate(w, r)
+		return w
+	}
+
+	type result struct {
+		IsAdmin bool `json:"is_admin"`
+		Regions []struct {
+			Region     string   `json:"region"`
+			Role       string   `json:"role"`
+			DirectRole string   `json:"direct_role"`
+			GroupRoles []string `json:"group_roles"`
+			Scopes     []string `json:"scopes"`
+		} `json:"regions"`
+	}
+	decode := func(w *httptest.ResponseRecorder) result {
+		var res result
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return res
+	}
+
+	// Direct membership only.
+	w := impersonate(map[string]any{"sub": "bob"})
+	require.Equal(t, http.StatusOK, w.Code)
+	res := decode(w)
+	require.Len(t, res.Regions, 1)
+	assert.Equal(t, "viewer", res.Regions[0].Role)
+	assert.Equal(t, store.RoleToScopes(store.RoleViewer, false), res.Regions[0].Scopes)
+
+	// A group binding outranks the direct role, as in Authenticate.
+	res = decode(impersonate(map[string]any{"sub": "bob", "groups": []string{"sre"}}))
+	assert.Equal(t, "editor", res.Regions[0].Role)
+	assert.Equal(t, "viewer", res.Regions[0].DirectRole)
+	assert.Equal(t, []string{"editor"}, res.Regions[0].GroupRoles)
+	assert.Contains(t, res.Regions[0].Scopes, store.ScopeConfigWrite)
+
+	require.NoError(t, ms.SetUserAdmin(ctx, "bob", true))
+	res = decode(impersonate(map[string]any{"sub": "bob", "region": "other"}))
+	assert.True(t, res.IsAdmin)
+	require.Len(t, res.Regions, 1)
+	assert.Equal(t, "other", res.Regions[0].Region)
+	assert.Equal(t, store.AllScopes, res.Regions[0].Scopes)
+
+	assert.Equal(t, http.StatusNotFound, impersonate(map[string]any{"sub": "nobody"}).Code)
+	assert.Equal(t, http.StatusBadRequest, impersonate(map[string]any{}).Code)
+
+	last := ms.auditLog[len(ms.auditLog)-1]
+	assert.Equal(t, "impersonate", last.Action)
+	assert.Equal(t, "bob", last.Name)
+}
+
+func TestMemberHandler_ListRoles(t *testing.T) {
+	h := NewMemberHandler(newMockStore(), nil, testLogger(), config.PasswordPolicyConfig{})
+	w := httptest.NewRecorder()
+	h.ListRoles(w, httptest.NewRequest("GET", "/api/v1/roles", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Roles []struct {
+			Role   string   `json:"role"`
+			Scopes []string `json:"scopes"`
+		} `json:"roles"`
+		AdminScopes []string `json:"admin_scopes"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	require.Len(t, resp.Roles, 3)
+	for i, role := range store.AllRoles {
+		assert.Equal(t, string(role), resp.Roles[i].Role)
+		assert.Equal(t, store.RoleToScopes(role, false), resp.Roles[i].Scopes)
+	}
+	assert.Equal(t, store.AllScopes, resp.AdminScopes)
+}
+
+func TestSecrets(t *testing.T) {
+	ms := newMockStore()
+	box, err := secretbox.New(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	h := NewSecretHandler(ms, box, testLogger())
+
+	put := func(h *SecretHandler, name string, body any) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/secrets/"+name, jsonBody(body))
+		r.SetPathValue("name", name)
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.PutSecret(w, r)
+		return w
+	}
+	del := func(name string) int {
+		r := httptest.NewRequest("DELETE", "/api/v1/secrets/"+name, nil)
+		r.SetPathValue("name", name)
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.DeleteSecret(w, r)
+		return w.Code
+	}
+
+	pem := "-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----"
+	noKey := NewSecretHandler(ms, nil, testLogger())
+	assert.Equal(t, http.StatusServiceUnavailable, put(noKey, "api-cert", map[string]string{"value": pem}).Code)
+	assert.Equal(t, http.StatusBadRequest, put(h, "Bad_Name", map[string]string{"value": pem}).Code)
+	assert.Equal(t, http.StatusBadRequest, put(h, "api-cert", map[string]string{}).Code)
+
+	w := put(h, "api-cert", map[string]string{"value": pem, "description": "api.example.com"})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.NotContains(t, w.Body.String(), "BEGIN CERTIFICATE")
+	assert.NotContains(t, w.Body.String(), "v1:", "sealed value must not be returned either")
+
+	// Stored sealed, and only the master key opens it.
+	sealed := ms.secrets["default/api-cert"].SealedValue
+	assert.NotContains(t, sealed, "BEGIN CERTIFICATE")
+	plain, err := box.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, pem, string(plain))
+
+	r := httptest.NewRequest("GET", "/api/v1/secrets", nil)
+	r = withRegion(r, "default")
+	w = httptest.NewRecorder()
+	h.ListSecrets(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"api-cert"`)
+	assert.NotContains(t, w.Body.String(), "v1:")
+
+	// Domains may only reference secrets that exist.
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	createDomain := func(ref string) int {
+		d := model.DomainConfig{
+			Name: "api", Hosts: []string{"api.example.com"},
+			TLS:    &model.DomainTLSConfig{CertificateRef: ref},
+			Routes: []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}}},
+		}
+		r := httptest.NewRequest("POST", "/api/v1/domains", jsonBody(d))
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		dh.CreateDomain(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, createDomain("missing-cert"))
+	require.Equal(t, http.StatusCreated, createDomain("api-cert"))
+
+	// So may full imports and scheduled changes.
+	withRef := func(name, ref string) model.DomainConfig {
+		return model.DomainConfig{
+			Name: name, Hosts: []string{name + ".example.com"},
+			TLS:    &model.DomainTLSConfig{CertificateRef: ref},
+			Routes: []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}}},
+		}
+	}
+	rh := NewRouteHandler(ms, testLogger(), nil, nil)
+	r = httptest.NewRequest("PUT", "/api/v1/config", jsonBody(model.GatewayConfig{
+		Domains:  []model.DomainConfig{withRef("web", "missing-cert")},
+		Clusters: []model.ClusterConfig{{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}}},
+	}))
+	w = httptest.NewRecorder()
+	rh.PutConfig(w, withRegion(r, "default"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), `secret \"missing-cert\" not found`)
+
+	sh := NewScheduleHandler(ms, testLogger(), nil)
+	r = httptest.NewRequest("POST", "/api/v1/scheduled-changes", jsonBody(map[string]any{
+		"kind": "domain", "apply_at": time.Now().Add(time.Hour), "domain": withRef("web", "missing-cert"),
+	}))
+	w = httptest.NewRecorder()
+	sh.CreateScheduledChange(w, withRegion(r, "default"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	assert.Equal(t, http.StatusConflict, del("api-cert"), "referenced secrets cannot be deleted")
+	_, err = ms.DeleteDomain(context.Background(), "default", "api", "test")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, del("api-cert"))
+
+	// A secret deleted while a scheduled change waits fails the change.
+	require.Equal(t, http.StatusOK, put(h, "api-cert", map[string]string{"value": pem}).Code)
+	ms.PutCluster(context.Background(), "default", &model.ClusterConfig{Name: "backend"}, "create", "test", -1)
+	r = httptest.NewRequest("POST", "/api/v1/scheduled-changes", jsonBody(map[string]any{
+		"kind": "domain", "apply_at": time.Now().Add(time.Hour), "domain": withRef("web", "api-cert"),
+	}))
+	w = httptest.NewRecorder()
+	sh.CreateScheduledChange(w, withRegion(r, "default"))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	require.Equal(t, http.StatusOK, del("api-cert"))
+	ms.scheduled[0].ApplyAt = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	assert.Equal(t, store.ScheduledFailed, ms.scheduled[0].Status)
+	assert.Contains(t, ms.scheduled[0].Error, `secret "api-cert" not found`)
+	assert.Nil(t, ms.domains["default"]["web"])
+}
+
+func TestQuotas(t *testing.T) {
+	ms := newMockStore()
+	q := config.NewDynamic(config.QuotaConfig{MaxDomains: 2})
+	dh := NewDomainHandler(ms, testLogger(), q, nil)
+	ch := NewClusterHandler(ms, testLogger(), q, nil)
+	rh := NewRouteHandler(ms, testLogger(), q, nil)
+	regions := NewRegionHandler(ms, testLogger(), q)
+
+	domain := func(name string) model.DomainConfig {
+		return model.DomainConfig{
+			Name: name, Hosts: []string{name + ".example.com"},
+			Routes: []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}}},
+		}
+	}
+	createDomain := func(name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		dh.CreateDomain(w, withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(domain(name))), "default"))
+		return w
+	}
+	putSettings := func(body any) int {
+		r := httptest.NewRequest("PUT", "/api/v1/regions/default/settings", jsonBody(body))
+		r.SetPathValue("name", "default")
+		w := httptest.NewRecorder()
+		regions.PutRegionSettings(w, r)
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusCreated, createDomain("a").Code)
+	require.Equal(t, http.StatusCreated, createDomain("b").Code)
+	w := createDomain("c")
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Contains(t, w.Body.String(), "domain quota exceeded")
+
+	// Updates never count against the quota.
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("PUT", "/api/v1/domains/a", jsonBody(map[string]any{
+		"name": "a", "hosts": []string{"a2.example.com"}, "resource_version": 1,
+		"routes": domain("a").Routes,
+	}))
+	r.SetPathValue("name", "a")
+	dh.UpdateDomain(w, withRegion(r, "default"))
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Clusters have no default limit.
+	cluster := model.ClusterConfig{Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}}
+	w = httptest.NewRecorder()
+	ch.CreateCluster(w, withRegion(httptest.NewRequest("POST", "/api/v1/clusters", jsonBody(cluster)), "default"))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	// A region override replaces the default.
+	assert.Equal(t, http.StatusBadRequest, putSettings(map[string]int{"max_domains": -1}))
+	assert.Equal(t, http.StatusOK, putSettings(map[string]int{"max_domains": 3, "max_clusters": 1}))
+	assert.Equal(t, http.StatusCreated, createDomain("c").Code)
+	assert.Equal(t, http.StatusForbidden, createDomain("d").Code)
+
+	r = httptest.NewRequest("GET", "/api/v1/regions/default/settings", nil)
+	r.SetPathValue("name", "default")
+	w = httptest.NewRecorder()
+	regions.GetRegionSettings(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, map[string]any{"max_domains": float64(3), "max_clusters": float64(1)}, resp["effective"])
+	assert.Equal(t, map[string]any{"domains": float64(3), "clusters": float64(1)}, resp["usage"])
+
+	r = httptest.NewRequest("GET", "/api/v1/regions/nope/settings", nil)
+	r.SetPathValue("name", "nope")
+	w = httptest.NewRecorder()
+	regions.GetRegionSettings(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Bulk replace may shrink or keep an over-quota region, but not grow it.
+	put := func(domains []model.DomainConfig) int {
+		w := httptest.NewRecorder()
+		cfg := model.GatewayConfig{Domains: domains, Clusters: []model.ClusterConfig{cluster}}
+		rh.PutConfig(w, withRegion(httptest.NewRequest("PUT", "/api/v1/config", jsonBody(cfg)), "default"))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusForbidden, put([]model.DomainConfig{domain("a"), domain("b"), domain("c"), domain("d")}))
+	assert.Equal(t, http.StatusOK, putSettings(map[string]int{"max_domains": 1}))
+	assert.Equal(t, http.StatusOK, put([]model.DomainConfig{domain("a"), domain("b")}))
+	assert.Equal(t, http.StatusOK, put([]model.DomainConfig{domain("a"), domain("b")}))
+	assert.Equal(t, http.StatusForbidden, put([]model.DomainConfig{domain("a"), domain("b"), domain("c")}))
+}
+
+func TestMaintenance(t *testing.T) {
+	ms := newMockStore()
+	h := NewMaintenanceHandler(ms, testLogger())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	mw := Maintenance(ms, testLogger())(ok)
+	do := func(method, path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/domains").Code)
+
+	w := httptest.NewRecorder()
+	h.SetMaintenance(w, httptest.NewRequest("PUT", "/api/v1/admin/maintenance", jsonBody(map[string]any{"enabled": true, "message": "db upgrade"})))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.True(t, ms.maintenance.Enabled)
+
+	for _, method := range []string{"POST", "PUT", "PATCH", "DELETE"} {
+		w := do(method, "/api/v1/domains/api")
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, method)
+		assert.Contains(t, w.Body.String(), "db upgrade")
+	}
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/domains").Code)
+	assert.Equal(t, http.StatusOK, do("GET", "/api/v1/config/watch").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/auth/login").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/auth/refresh").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/config/validate").Code)
+	assert.Equal(t, http.StatusOK, do("POST", "/api/v1/config/lint").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/auth/rotate-key").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/auth/change-password").Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/status/instances").Code)
+	assert.Equal(t, http.StatusOK, do("PUT", "/api/v1/admin/maintenance").Code)
+	assert.Equal(t, http.StatusServiceUnavailable, do("POST", "/api/v1/admin/maintenance/tables").Code)
+
+	w = httptest.NewRecorder()
+	h.GetMaintenance(w, httptest.NewRequest("GET", "/api/v1/admin/maintenance", nil))
+	resp := decodeResp(t, w)
+	assert.Equal(t, true, resp["enabled"])
+	assert.Equal(t, "db upgrade", resp["message"])
+
+	w = httptest.NewRecorder()
+	h.SetMaintenance(w, httptest.NewRequest("PUT", "/api/v1/admin/maintenance", jsonBody(map[string]any{"enabled": false})))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", "/api/v1/domains/api").Code)
+}
+
+func TestRunTableMaintenance(t *testing.T) {
+	ms := newMockStore()
+	h := NewMaintenanceHandler(ms, testLogger())
+	run := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.RunTableMaintenance(w, httptest.NewRequest("POST", "/api/v1/admin/maintenance/tables"+query, nil))
+		return w
+	}
+
+	w := run("")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, false, resp["reindex"])
+	require.Len(t, resp["tables"], 2)
+	assert.Equal(t, false, resp["tables"].([]any)[0].(map[string]any)["reindexed"])
+
+	w = run("?reindex=true")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, true, decodeResp(t, w)["tables"].([]any)[0].(map[string]any)["reindexed"])
+	require.Len(t, ms.auditLog, 2)
+	assert.Equal(t, "analyze", ms.auditLog[0].Action)
+	assert.Equal(t, "reindex", ms.auditLog[1].Action)
+
+	ms.maintaining = true
+	assert.Equal(t, http.StatusConflict, run("?reindex=true").Code)
+}
+
+func TestLogLevel(t *testing.T) {
+	level := zap.NewAtomicLevelAt(zap.InfoLevel)
+	h := NewLogLevelHandler(level, testLogger())
+	set := func(body any) int {
+		w := httptest.NewRecorder()
+		h.SetLogLevel(w, httptest.NewRequest("PUT", "/api/v1/admin/log-level", jsonBody(body)))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, set(map[string]string{"level": "debug"}))
+	assert.True(t, level.Enabled(zap.DebugLevel))
+
+	w := httptest.NewRecorder()
+	h.GetLogLevel(w, httptest.NewRequest("GET", "/api/v1/admin/log-level", nil))
+	assert.Equal(t, "debug", decodeResp(t, w)["level"])
+
+	assert.Equal(t, http.StatusBadRequest, set(map[string]string{"level": "verbose"}))
+	assert.Equal(t, http.StatusBadRequest, set(map[string]string{"level": "fatal"}))
+	assert.Equal(t, zap.DebugLevel, level.Level())
+}
+
+func TestConditionalGet(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	_, err := ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", 0)
+	require.NoError(t, err)
+	lastWrite := time.Date(2026, 1, 2, 3, 4, 5, 600, time.UTC)
+	ms.modified["domain/default/api"] = lastWrite
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/domains/api", nil)
+		r.SetPathValue("name", "api")
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		w := httptest.NewRecorder()
+		h.GetDomain(w, withRegion(r, "default"))
+		return w
+	}
+
+	w := get("", "")
+	require.Equal(t, http.StatusOK, w.Code)
+	etag := w.Header().Get("ETag")
+	assert.NotEmpty(t, etag)
+	assert.Equal(t, "Fri, 02 Jan 2026 03:04:05 GMT", w.Header().Get("Last-Modified"))
+
+	w = get("If-None-Match", etag)
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Empty(t, w.Body.String())
+	assert.Equal(t, http.StatusNotModified, get("If-None-Match", `"other", `+etag).Code)
+	assert.Equal(t, http.StatusOK, get("If-None-Match", `"other"`).Code)
+	assert.Equal(t, http.StatusNotModified, get("If-Modified-Since", "Fri, 02 Jan 2026 03:04:05 GMT").Code)
+	assert.Equal(t, http.StatusOK, get("If-Modified-Since", "Fri, 02 Jan 2026 03:04:04 GMT").Code)
+
+	// Every write changes the ETag, even a delete and re-create that
+	// restarts the resource version.
+	_, err = ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "rollback", "test", -1)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, get("If-None-Match", etag).Code)
+
+	_, err = ms.DeleteDomain(ctx, "default", "api", "test")
+	require.NoError(t, err)
+	_, err = ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", 0)
+	require.NoError(t, err)
+	recreated := get("", "")
+	assert.NotEqual(t, etag, recreated.Header().Get("ETag"))
+}
+
+func TestUpdateIfMatch(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	_, err := ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", 0)
+	require.NoError(t, err)
+
+	update := func(ifMatch string, body map[string]any) *httptest.ResponseRecorder {
+		body["hosts"] = []string{"api.example.com"}
+		body["routes"] = []model.RouteConfig{{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "c", Weight: 1}}}}
+		r := httptest.NewRequest("PUT", "/api/v1/domains/api", jsonBody(body))
+		setPathValue(r, "name", "api")
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		h.UpdateDomain(w, withRegion(r, "default"))
+		return w
+	}
+	etag := func() string {
+		r := httptest.NewRequest("GET", "/api/v1/domains/api", nil)
+		setPathValue(r, "name", "api")
+		w := httptest.NewRecorder()
+		h.GetDomain(w, withRegion(r, "default"))
+		return w.Header().Get("ETag")
+	}
+
+	assert.Equal(t, http.StatusPreconditionRequired, update("", map[string]any{}).Code)
+	// An explicit version that is not positive is still a bad request.
+	assert.Equal(t, http.StatusBadRequest, update("", map[string]any{"resource_version": 0}).Code)
+
+	// The ETag of a GET works as is, once.
+	current := etag()
+	assert.Equal(t, http.StatusOK, update(current, map[string]any{}).Code)
+	assert.Equal(t, http.StatusPreconditionFailed, update(current, map[string]any{}).Code)
+
+	// So does a bare version; a stale one fails in the store.
+	w := update(`"2"`, map[string]any{})
+	require.Equal(t, http.StatusOK, w.Code)
+	var resp map[string]any
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	assert.EqualValues(t, 3, resp["resource_version"])
+	assert.Equal(t, http.StatusPreconditionFailed, update(`"2"`, map[string]any{}).Code)
+
+	assert.Equal(t, http.StatusBadRequest, update(`"3"`, map[string]any{"resource_version": 2}).Code)
+	assert.Equal(t, http.StatusOK, update(`"3"`, map[string]any{"resource_version": 3}).Code)
+	assert.Equal(t, http.StatusBadRequest, update(`W/"4"`, map[string]any{}).Code)
+	assert.Equal(t, http.StatusBadRequest, update(`4`, map[string]any{}).Code)
+
+	// The body field keeps its 409 on conflict.
+	assert.Equal(t, http.StatusConflict, update("", map[string]any{"resource_version": 1}).Code)
+	assert.Equal(t, http.StatusOK, update("", map[string]any{"resource_version": 4}).Code)
+
+	ch := NewClusterHandler(ms, testLogger(), nil, nil)
+	c := model.ClusterConfig{Name: "c", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 1}, Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}}}
+	_, err = ms.PutCluster(ctx, "default", &c, "create", "test", 0)
+	require.NoError(t, err)
+	putCluster := func(ifMatch string) int {
+		r := httptest.NewRequest("PUT", "/api/v1/clusters/c", jsonBody(c))
+		setPathValue(r, "name", "c")
+		r.Header.Set("If-Match", ifMatch)
+		w := httptest.NewRecorder()
+		ch.UpdateCluster(w, withRegion(r, "default"))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, putCluster(`"1"`))
+	assert.Equal(t, http.StatusPreconditionFailed, putCluster(`"1"`))
+}
+
+func TestSearch(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	for name, hosts := range map[string][]string{
+		"api":     {"api.example.com"},
+		"web":     {"www.example.com", "api-docs.example.com"},
+		"billing": {"pay.example.com"},
+	} {
+		_, err := ms.PutDomain(ctx, "default", &model.DomainConfig{Name: name, Hosts: hosts}, "create", "test", 0)
+		require.NoError(t, err)
+	}
+	for _, name := range []string{"api-backend", "legacy-api"} {
+		_, err := ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: name}, "create", "test", 0)
+		require.NoError(t, err)
+	}
+
+	search := func(query string) (int, map[string]any) {
+		w := httptest.NewRecorder()
+		h.Search(w, withRegion(httptest.NewRequest("GET", "/api/v1/search?"+query, nil), "default"))
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		return w.Code, resp
+	}
+
+	code, resp := search("q=API")
+	require.Equal(t, http.StatusOK, code)
+	var got []string
+	for _, res := range resp["results"].([]any) {
+		hit := res.(map[string]any)
+		got = append(got, fmt.Sprintf("%s:%s:%s", hit["type"], hit["name"], hit["match_field"]))
+	}
+	// Exact, then prefix, then substring; "web" matches once, by host.
+	assert.Equal(t, []string{"domain:api:name", "cluster:api-backend:name", "domain:web:host", "cluster:legacy-api:name"}, got)
+	assert.Equal(t, false, resp["truncated"])
+
+	_, resp = search("q=api&limit=2")
+	assert.Len(t, resp["results"], 2)
+	assert.Equal(t, true, resp["truncated"])
+
+	_, resp = search("q=nothing")
+	assert.Empty(t, resp["results"])
+
+	code, _ = search("q=")
+	assert.Equal(t, http.StatusBadRequest, code)
+	code, _ = search("q=api&limit=1000")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestPurgeHistory(t *testing.T) {
+	ms := newMockStore()
+	h := NewDomainHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	_, err := ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}, "create", "test", 0)
+	require.NoError(t, err)
+	ms.history = []store.HistoryEntry{
+		{Version: 1, Kind: "domain", Name: "api", Action: "create"},
+		{Version: 2, Kind: "domain", Name: "api", Action: "delete"},
+		{Version: 1, Kind: "domain", Name: "web", Action: "create"},
+	}
+	ms.changes = []store.ChangeEvent{{Revision: 1, Kind: "domain", Name: "api", Action: "create"}}
+
+	purge := func(query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("DELETE", "/api/v1/domains/api/history"+query, nil)
+		setPathValue(r, "name", "api")
+		w := httptest.NewRecorder()
+		h.PurgeDomainHistory(w, withRegion(r, "default"))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, purge("").Code)
+	assert.Equal(t, http.StatusConflict, purge("?confirm=true").Code, "live domains keep their history")
+
+	_, err = ms.DeleteDomain(ctx, "default", "api", "test")
+	require.NoError(t, err)
+	w := purge("?confirm=true")
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Contains(t, w.Body.String(), `"purged":3`)
+	require.Len(t, ms.history, 1)
+	assert.Equal(t, "web", ms.history[0].Name)
+
+	last := ms.auditLog[len(ms.auditLog)-1]
+	assert.Equal(t, "purge", last.Action)
+	assert.Equal(t, "domain/api", last.Name)
+	assert.Equal(t, "sync", ms.changes[len(ms.changes)-1].Kind)
+
+	assert.Equal(t, http.StatusNotFound, purge("?confirm=true").Code)
+}
+
+func TestAllStatus(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	ms.revision = 7
+	ms.instances["default"] = []store.GatewayInstanceStatus{
+		{ID: "gw-1", Status: "running", ConfigRevision: 7},
+		{ID: "gw-2", Status: "running", ConfigRevision: 5},
+		{ID: "gw-3", Status: "offline", ConfigRevision: 7},
+	}
+	ms.ctrl["default"] = &store.ControllerStatus{ID: "ctrl-1", Status: "running", ConfigRevision: 7}
+
+	w := httptest.NewRecorder()
+	h.AllStatus(w, httptest.NewRequest("GET", "/api/v1/status/all", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var resp struct {
+		Regions []store.RegionStatusSummary `json:"regions"`
+	}
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	require.Len(t, resp.Regions, 1)
+	sum := resp.Regions[0]
+	assert.Equal(t, "default", sum.Region)
+	assert.Equal(t, int64(7), sum.ConfigRevision)
+	assert.Equal(t, 3, sum.Instances)
+	assert.Equal(t, 2, sum.InstancesOnline)
+	assert.Equal(t, 1, sum.InstancesInSync)
+	require.NotNil(t, sum.Controller)
+	assert.Equal(t, "ctrl-1", sum.Controller.ID)
+}
+
+func TestInstanceHistory(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	ms.revHistory["default/gw-1"] = []store.InstanceRevisionEvent{{ConfigRevision: 3}, {ConfigRevision: 5}}
+
+	get := func(id string) map[string]any {
+		r := httptest.NewRequest("GET", "/api/v1/status/instances/"+id+"/history", nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		h.InstanceHistory(w, withRegion(r, "default"))
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeResp(t, w)
+	}
+
+	events := get("gw-1")["events"].([]any)
+	require.Len(t, events, 2)
+	assert.Equal(t, float64(5), events[1].(map[string]any)["config_revision"])
+	assert.Empty(t, get("gw-9")["events"])
+}
+
+func TestInstanceConfig(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	ms.domains["default"] = map[string]*model.DomainConfig{"api": {Name: "api"}}
+	ms.revision = 4
+	ms.instances["default"] = []store.GatewayInstanceStatus{{ID: "gw-1", ConfigRevision: 3}}
+
+	get := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/api/v1/status/instances/"+id+"/config", nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		h.InstanceConfig(w, withRegion(r, "default"))
+		return w
+	}
+
+	w := get("gw-1")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, ChannelStable, resp["channel"])
+	assert.Equal(t, false, resp["in_sync"])
+	assert.Len(t, resp["config"].(map[string]any)["domains"], 1)
+
+	ms.instances["default"][0].ConfigRevision = 4
+	assert.Equal(t, true, decodeResp(t, get("gw-1"))["in_sync"])
+
+	// With the canary at 100% every instance gets the canary config. Its
+	// reported revision is the stable one, so in_sync is left out rather
+	// than claiming it runs the stable config.
+	ms.canaries["default"] = &store.ConfigCanary{Percent: 100, Config: model.GatewayConfig{}, BaseRevision: 4}
+	resp = decodeResp(t, get("gw-1"))
+	assert.Equal(t, ChannelCanary, resp["channel"])
+	assert.Empty(t, resp["config"].(map[string]any)["domains"])
+	assert.NotContains(t, resp, "in_sync")
+
+	assert.Equal(t, http.StatusNotFound, get("gw-9").Code)
+}
+
+func TestControllerElections(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	ms.elections["default"] = []store.LeadershipEvent{
+		{ControllerID: "ctrl-1", IsLeader: true},
+		{ControllerID: "ctrl-1", IsLeader: false},
+		{ControllerID: "ctrl-2", IsLeader: true},
+	}
+
+	get := func(region string) map[string]any {
+		r := httptest.NewRequest("GET", "/api/v1/status/controller/elections", nil)
+		w := httptest.NewRecorder()
+		h.ControllerElections(w, withRegion(r, region))
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeResp(t, w)
+	}
+
+	events := get("default")["events"].([]any)
+	require.Len(t, events, 3)
+	assert.Equal(t, "ctrl-2", events[2].(map[string]any)["controller_id"])
+	assert.Equal(t, true, events[2].(map[string]any)["is_leader"])
+	assert.Empty(t, get("other")["events"])
+}
+
+func TestRegionHealth(t *testing.T) {
+	thresholds := config.HealthConfig{RedOfflineRatio: 0.5}
+	running := &store.ControllerStatus{ID: "ctrl-1", Status: "running", ConfigRevision: 7}
+
+	tests := []struct {
+		name                  string
+		total, online, inSync int
+		ctrl                  *store.ControllerStatus
+		want                  string
+		reasons               int
+	}{
+		{"all healthy", 3, 3, 3, running, HealthGreen, 0},
+		{"one lagging", 3, 3, 2, running, HealthYellow, 1},
+		{"minority offline", 3, 2, 2, running, HealthYellow, 1},
+		{"majority offline", 3, 1, 1, running, HealthRed, 1},
+		{"half offline", 4, 2, 2, running, HealthYellow, 1},
+		{"controller offline", 3, 3, 3, &store.ControllerStatus{ID: "ctrl-1", Status: "offline", ConfigRevision: 7}, HealthRed, 1},
+		{"controller behind", 3, 3, 3, &store.ControllerStatus{ID: "ctrl-1", Status: "running", ConfigRevision: 6}, HealthYellow, 1},
+		{"no controller", 3, 3, 3, nil, HealthRed, 1},
+		{"no instances", 0, 0, 0, running, HealthYellow, 1},
+		{"red and yellow", 3, 1, 0, running, HealthRed, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := regionHealth(thresholds, tt.total, tt.online, tt.inSync, tt.ctrl, 7)
+			assert.Equal(t, tt.want, h.Status)
+			assert.Len(t, h.Reasons, tt.reasons, h.Reasons)
+		})
+	}
+
+	// Tolerating some lag keeps the region green.
+	h := regionHealth(config.HealthConfig{RedOfflineRatio: 0.5, YellowOutOfSyncRatio: 0.5}, 3, 3, 2, running, 7)
+	assert.Equal(t, HealthGreen, h.Status)
+}
+
+func TestDeregisterInstance(t *testing.T) {
+	ms := newMockStore()
+	h := NewStatusHandler(ms, testLogger(), nil)
+	ms.instances["default"] = []store.GatewayInstanceStatus{{ID: "gw-1", Status: "running"}, {ID: "gw-2", Status: "running"}}
+
+	deregister := func(id string) int {
+		r := httptest.NewRequest("DELETE", "/api/v1/status/instances/"+id, nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		h.DeregisterInstance(w, withRegion(r, "default"))
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, deregister("gw-1"))
+	require.Len(t, ms.instances["default"], 1)
+	assert.Equal(t, "gw-2", ms.instances["default"][0].ID)
+	assert.Equal(t, http.StatusNotFound, deregister("gw-1"))
+}
+
+func TestFreezeGuard(t *testing.T) {
+	ms := newMockStore()
+	h := NewRegionHandler(ms, testLogger(), nil)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	mw := FreezeGuard(ms, testLogger())(ok)
+	do := func(path string, scopes ...string) int {
+		r := withRegion(httptest.NewRequest("PUT", path, nil), "default")
+		r = withIdentity(r, &Identity{Subject: "alice", Scopes: scopes})
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w.Code
+	}
+	freeze := func(region string, frozen bool) int {
+		r := httptest.NewRequest("PUT", "/api/v1/regions/"+region+"/freeze", jsonBody(map[string]any{"frozen": frozen}))
+		r.SetPathValue("name", region)
+		w := httptest.NewRecorder()
+		h.SetRegionFrozen(w, r)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("/api/v1/domains/api", store.ScopeConfigWrite))
+
+	require.Equal(t, http.StatusOK, freeze("default", true))
+	assert.True(t, ms.frozen["default"])
+	assert.Equal(t, http.StatusNotFound, freeze("nope", true))
+
+	assert.Equal(t, http.StatusLocked, do("/api/v1/domains/api", store.ScopeConfigWrite, store.ScopeRegionWrite))
+	// Editors cannot override.
+	assert.Equal(t, http.StatusLocked, do("/api/v1/domains/api?override=true", store.ScopeConfigWrite))
+	n := len(ms.auditLog)
+	assert.Equal(t, http.StatusOK, do("/api/v1/domains/api?override=true", store.ScopeConfigWrite, store.ScopeRegionWrite))
+	require.Len(t, ms.auditLog, n+1)
+	assert.Equal(t, "freeze_override", ms.auditLog[n].Action)
+
+	require.Equal(t, http.StatusOK, freeze("default", false))
+	assert.Equal(t, http.StatusOK, do("/api/v1/domains/api", store.ScopeConfigWrite))
+}
+
+func TestChangeReason(t *testing.T) {
+	ms := newMockStore()
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	mw := ChangeReason(ChangeReasonGuard(ms, testLogger())(http.HandlerFunc(dh.CreateDomain)))
+	create := func(name, reason string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("POST", "/api/v1/domains", jsonBody(model.DomainConfig{
+			Name:  name,
+			Hosts: []string{name + ".example.com"},
+			Routes: []model.RouteConfig{
+				{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}, Status: 1},
+			},
+		}))
+		if reason != "" {
+			r.Header.Set("X-Hermes-Change-Reason", reason)
+		}
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, withRegion(r, "default"))
+		return w
+	}
+
+	require.Equal(t, http.StatusCreated, create("a", "").Code)
+	require.Equal(t, http.StatusCreated, create("b", "  OPS-123: new tenant ").Code)
+	require.Len(t, ms.auditLog, 2)
+	assert.Empty(t, ms.auditLog[0].Reason)
+	assert.Equal(t, "OPS-123: new tenant", ms.auditLog[1].Reason)
+	assert.Equal(t, http.StatusBadRequest, create("c", strings.Repeat("x", maxChangeReasonLen+1)).Code)
+
+	ms.settings["default"] = &store.RegionSettings{RequireChangeReason: true}
+	w := create("d", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, decodeResp(t, w)["error"], "X-Hermes-Change-Reason")
+	assert.Equal(t, http.StatusCreated, create("d", "OPS-124").Code)
+}
+
+func TestIdempotency(t *testing.T) {
+	ms := newMockStore()
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	mw := Idempotency(ms, testLogger())(http.HandlerFunc(dh.CreateDomain))
+	domain := model.DomainConfig{
+		Name:  "api",
+		Hosts: []string{"api.example.com"},
+		Routes: []model.RouteConfig{
+			{Name: "r1", URI: "/", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}, Status: 1},
+		},
+	}
+	create := func(key string, d model.DomainConfig) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(d)), "default")
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w
+	}
+
+	first := create("k1", domain)
+	require.Equal(t, http.StatusCreated, first.Code)
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+
+	// A retry gets the original response instead of a 409.
+	retry := create("k1", domain)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, first.Header().Get("Content-Type"), retry.Header().Get("Content-Type"))
+
+	// Without the key, or with a fresh one, the create runs again.
+	assert.Equal(t, http.StatusConflict, create("", domain).Code)
+	assert.Equal(t, http.StatusConflict, create("k2", domain).Code)
+
+	other := domain
+	other.Name = "web"
+	assert.Equal(t, http.StatusUnprocessableEntity, create("k1", other).Code)
+
+	// Keys are scoped per region.
+	r := withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(domain)), "staging")
+	r.Header.Set("Idempotency-Key", "k1")
+	w := httptest.NewRecorder()
+	mw.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+
+	// In flight: a concurrent repeat is told to wait.
+	ms.idempotency["default/POST /api/v1/domains/0::k3"] = &store.IdempotencyRecord{
+		Fingerprint: ms.idempotency["default/POST /api/v1/domains/0::k1"].Fingerprint,
+	}
+	assert.Equal(t, http.StatusConflict, create("k3", domain).Code)
+
+	// Server errors release the key so the retry runs.
+	failing := Idempotency(ms, testLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ErrJSON(w, http.StatusInternalServerError, "boom")
+	}))
+	r = withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(domain)), "default")
+	r.Header.Set("Idempotency-Key", "k4")
+	w = httptest.NewRecorder()
+	failing.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.NotContains(t, ms.idempotency, "default/POST /api/v1/domains/0::k4")
+
+	// Keys are scoped per caller: another identity's k1 is a new request.
+	r = withRegion(httptest.NewRequest("POST", "/api/v1/domains", jsonBody(domain)), "default")
+	r.Header.Set("Idempotency-Key", "k1")
+	w = httptest.NewRecorder()
+	mw.ServeHTTP(w, withIdentity(r, &Identity{Subject: "mallory"}))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	assert.Empty(t, w.Header().Get("Idempotent-Replayed"))
+}
+
+func TestSealedIdempotency(t *testing.T) {
+	secret := func(w http.ResponseWriter, r *http.Request) {
+		JSON(w, http.StatusCreated, map[string]string{"secret_key": "sk-plaintext"})
+	}
+	send := func(mw http.Handler) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("POST", "/api/v1/credentials", strings.NewReader(`{}`)), "default")
+		r.Header.Set("Idempotency-Key", "k1")
+		w := httptest.NewRecorder()
+		mw.ServeHTTP(w, r)
+		return w
+	}
+
+	// With a master key the body is stored sealed and replayed in the clear.
+	box, err := secretbox.New(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte("k"), 32)))
+	require.NoError(t, err)
+	ms := newMockStore()
+	mw := SealedIdempotency(ms, box, testLogger())(http.HandlerFunc(secret))
+	first := send(mw)
+	require.Equal(t, http.StatusCreated, first.Code)
+	for _, rec := range ms.idempotency {
+		assert.NotContains(t, string(rec.Body), "sk-plaintext")
+	}
+	retry := send(mw)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Equal(t, first.Body.String(), retry.Body.String())
+
+	// Without one only the status is kept.
+	ms = newMockStore()
+	mw = SealedIdempotency(ms, nil, testLogger())(http.HandlerFunc(secret))
+	require.Equal(t, http.StatusCreated, send(mw).Code)
+	for _, rec := range ms.idempotency {
+		assert.Empty(t, rec.Body)
+	}
+	retry = send(mw)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, retry.Body.String())
+}
+
+func TestClusterHandler_ListClusterReferences(t *testing.T) {
+	ms := newMockStore()
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
+	ms.clusters["default"] = map[string]*model.ClusterConfig{"backend": {Name: "backend"}, "idle": {Name: "idle"}}
+	ms.domains["default"] = map[string]*model.DomainConfig{
+		"api": {Name: "api", Routes: []model.RouteConfig{
+			{Name: "all", URI: "/", Status: model.RouteStatusEnabled, Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}},
+			{Name: "canary", URI: "/v2", Status: model.RouteStatusEnabled, Clusters: []model.WeightedCluster{{Name: "backend", Weight: 10}, {Name: "next", Weight: 90}}},
+			{Name: "off", URI: "/old", Status: model.RouteStatusDisabled, Clusters: []model.WeightedCluster{{Name: "backend", Weight: 100}}},
+		}},
+		"web": {Name: "web", Routes: []model.RouteConfig{
+			{Name: "r1", URI: "/", Status: model.RouteStatusEnabled, Clusters: []model.WeightedCluster{{Name: "next", Weight: 100}}},
+		}},
+	}
+	get := func(name string) *httptest.ResponseRecorder {
+		r := withRegion(httptest.NewRequest("GET", "/api/v1/clusters/"+name+"/references", nil), "default")
+		r.SetPathValue("name", name)
+		w := httptest.NewRecorder()
+		h.ListClusterReferences(w, r)
+		return w
+	}
+
+	w := get("backend")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, true, resp["exists"])
+	assert.Equal(t, []any{"api"}, resp["domains"])
+	assert.Equal(t, float64(3), resp["total"])
+	refs := resp["references"].([]any)
+	canary := refs[1].(map[string]any)
+	assert.Equal(t, "canary", canary["route"])
+	assert.Equal(t, float64(10), canary["weight"])
+	assert.Equal(t, float64(10), canary["percent"])
+	assert.Equal(t, false, refs[2].(map[string]any)["enabled"])
+	// (100 + 10) / (100 + 100); the disabled route is left out.
+	assert.Equal(t, float64(55), resp["traffic_percent"])
+
+	// Unreferenced clusters have no references; dangling references are still listed.
+	resp = decodeResp(t, get("idle"))
+	assert.Equal(t, float64(0), resp["total"])
+	w = get("next")
+	require.Equal(t, http.StatusOK, w.Code)
+	resp = decodeResp(t, w)
+	assert.Equal(t, false, resp["exists"])
+	assert.Equal(t, []any{"api", "web"}, resp["domains"])
+
+	assert.Equal(t, http.StatusNotFound, get("nope").Code)
+}
+
+func TestBulkDelete(t *testing.T) {
+	ms := newMockStore()
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	ch := NewClusterHandler(ms, testLogger(), nil, nil)
+	ms.domains["default"] = map[string]*model.DomainConfig{
+		"a": {Name: "a", Labels: map[string]string{"env": "dev"}},
+		"b": {Name: "b", Labels: map[string]string{"env": "dev"}},
+		"c": {Name: "c", Labels: map[string]string{"env": "prod"}},
+	}
+	ms.clusters["default"] = map[string]*model.ClusterConfig{
+		"x": {Name: "x", Labels: map[string]string{"env": "dev"}},
+		"y": {Name: "y"},
+	}
+	do := func(fn http.HandlerFunc, path string, body any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		fn(w, withRegion(httptest.NewRequest("POST", path, jsonBody(body)), "default"))
+		return w
+	}
+
+	assert.Equal(t, http.StatusBadRequest, do(dh.BulkDeleteDomains, "/api/v1/domains/delete", map[string]any{"names": []string{"a"}}).Code, "confirm required")
+	assert.Equal(t, http.StatusBadRequest, do(dh.BulkDeleteDomains, "/api/v1/domains/delete?confirm=true", map[string]any{}).Code)
+	assert.Equal(t, http.StatusBadRequest, do(dh.BulkDeleteDomains, "/api/v1/domains/delete?confirm=true",
+		map[string]any{"names": []string{"a"}, "labels": map[string]string{"env": "dev"}}).Code)
+	assert.Len(t, ms.domains["default"], 3)
+
+	w := do(dh.BulkDeleteDomains, "/api/v1/domains/delete?confirm=true", map[string]any{"labels": map[string]string{"env": "dev"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResp(t, w)["deleted"])
+	assert.Equal(t, []string{"c"}, slices.Collect(maps.Keys(ms.domains["default"])))
+
+	w = do(ch.BulkDeleteClusters, "/api/v1/clusters/delete?confirm=true", map[string]any{"names": []string{"y", "missing"}})
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(1), resp["deleted"])
+	results := resp["results"].([]any)
+	require.Len(t, results, 2)
+	assert.Equal(t, true, results[0].(map[string]any)["deleted"])
+	assert.Contains(t, results[1].(map[string]any)["error"], "not found")
+	assert.Contains(t, ms.clusters["default"], "x")
+	assert.NotContains(t, ms.clusters["default"], "y")
+}
+
+func TestScheduledChanges(t *testing.T) {
+	ms := newMockStore()
+	h := NewScheduleHandler(ms, testLogger(), nil)
+	ms.domains["default"] = map[string]*model.DomainConfig{"old": {Name: "old", Hosts: []string{"old.example.com"}}}
+	ms.clusters["default"] = map[string]*model.ClusterConfig{"backend": {Name: "backend"}}
+	submit := func(body map[string]any) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.CreateScheduledChange(w, withRegion(httptest.NewRequest("POST", "/api/v1/scheduled-changes", jsonBody(body)), "default"))
+		return w
+	}
+	later := time.Now().Add(time.Hour).Format(time.RFC3339)
+
+	domain := map[string]any{"name": "api", "hosts": []string{"api.example.com"}, "routes": []map[string]any{
+		{"name": "r", "uri": "/", "clusters": []map[string]any{{"name": "backend", "weight": 100}}},
+	}}
+	w := submit(map[string]any{"kind": "domain", "apply_at": later, "domain": domain})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	w = submit(map[string]any{"kind": "domain", "action": "delete", "name": "old", "apply_at": later})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	cluster := model.ClusterConfig{
+		Name:    "c",
+		LBType:  "roundrobin",
+		Timeout: model.TimeoutConfig{Connect: 1, Read: 1},
+		Nodes:   []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}},
+	}
+	w = submit(map[string]any{"kind": "cluster", "apply_at": later, "cluster": cluster})
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+
+	// Rejected at submit time.
+	assert.Equal(t, http.StatusBadRequest, submit(map[string]any{"kind": "domain", "domain": domain}).Code)
+	assert.Equal(t, http.StatusBadRequest, submit(map[string]any{"kind": "domain", "apply_at": time.Now().Add(-time.Hour).Format(time.RFC3339), "domain": domain}).Code)
+	assert.Equal(t, http.StatusBadRequest, submit(map[string]any{"kind": "domain", "apply_at": later, "domain": map[string]any{"name": "bad"}}).Code)
+	assert.Equal(t, http.StatusNotFound, submit(map[string]any{"kind": "domain", "action": "delete", "name": "nope", "apply_at": later}).Code)
+	assert.Equal(t, http.StatusBadRequest, submit(map[string]any{"kind": "route", "apply_at": later}).Code)
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("DELETE", "/api/v1/scheduled-changes/3", nil)
+	r.SetPathValue("id", "3")
+	h.CancelScheduledChange(w, withRegion(r, "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	w = httptest.NewRecorder()
+	h.CancelScheduledChange(w, withRegion(r, "default"))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	w = httptest.NewRecorder()
+	h.ListScheduledChanges(w, withRegion(httptest.NewRequest("GET", "/api/v1/scheduled-changes?status=pending", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResp(t, w)["total"])
+
+	// Nothing is due yet.
+	assert.Equal(t, 0, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	for i := range ms.scheduled {
+		ms.scheduled[i].ApplyAt = time.Now().Add(-time.Second)
+	}
+	assert.Equal(t, 2, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	assert.NotNil(t, ms.domains["default"]["api"])
+	assert.Nil(t, ms.domains["default"]["old"])
+	assert.Equal(t, store.ScheduledApplied, ms.scheduled[0].Status)
+	assert.Equal(t, store.ScheduledCancelled, ms.scheduled[2].Status)
+
+	// A frozen region holds scheduled changes back.
+	w = submit(map[string]any{"kind": "domain", "action": "delete", "name": "api", "apply_at": later})
+	require.Equal(t, http.StatusCreated, w.Code)
+	ms.scheduled[3].ApplyAt = time.Now().Add(-time.Second)
+	ms.frozen["default"] = true
+	assert.Equal(t, 0, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	assert.Equal(t, store.ScheduledFailed, ms.scheduled[3].Status)
+	assert.Contains(t, ms.scheduled[3].Error, "frozen")
+	ms.frozen["default"] = false
+
+	// The region is checked again at apply time: the cluster a domain routes
+	// to may be gone, and another domain may have claimed its host.
+	missing := map[string]any{"name": "web", "hosts": []string{"web.example.com"}, "routes": []map[string]any{
+		{"name": "r", "uri": "/", "clusters": []map[string]any{{"name": "gone", "weight": 100}}},
+	}}
+	taken := map[string]any{"name": "api2", "hosts": []string{"api.example.com"}, "routes": []map[string]any{
+		{"name": "r", "uri": "/", "clusters": []map[string]any{{"name": "backend", "weight": 100}}},
+	}}
+	require.Equal(t, http.StatusCreated, submit(map[string]any{"kind": "domain", "apply_at": later, "domain": missing}).Code)
+	require.Equal(t, http.StatusCreated, submit(map[string]any{"kind": "domain", "apply_at": later, "domain": taken}).Code)
+	require.Equal(t, http.StatusCreated, submit(map[string]any{"kind": "cluster", "action": "delete", "name": "backend", "apply_at": later}).Code)
+	for i := 4; i < 7; i++ {
+		ms.scheduled[i].ApplyAt = time.Now().Add(-time.Second)
+	}
+	assert.Equal(t, 0, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	assert.Contains(t, ms.scheduled[4].Error, `cluster "gone" not found`)
+	assert.Contains(t, ms.scheduled[5].Error, "api.example.com")
+	assert.Contains(t, ms.scheduled[6].Error, "still referenced")
+
+	// So is the quota.
+	q := config.NewDynamic(config.QuotaConfig{MaxDomains: 1})
+	require.Equal(t, http.StatusCreated, submit(map[string]any{"kind": "domain", "apply_at": later, "domain": taken}).Code)
+	ms.scheduled[7].ApplyAt = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, RunScheduledChanges(context.Background(), ms, q, nil, testLogger()))
+	assert.Contains(t, ms.scheduled[7].Error, "quota exceeded")
+
+	// The change reason given at submit time is recorded at apply time.
+	w = httptest.NewRecorder()
+	r = withRegion(httptest.NewRequest("POST", "/api/v1/scheduled-changes", jsonBody(map[string]any{
+		"kind": "domain", "apply_at": later, "domain": domain,
+	})), "default")
+	h.CreateScheduledChange(w, r.WithContext(store.WithChangeReason(r.Context(), "OPS-9")))
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	assert.Equal(t, "OPS-9", decodeResp(t, w)["reason"])
+	ms.scheduled[8].ApplyAt = time.Now().Add(-time.Second)
+	assert.Equal(t, 1, RunScheduledChanges(context.Background(), ms, nil, nil, testLogger()))
+	last := ms.auditLog[len(ms.auditLog)-1]
+	assert.Equal(t, "api", last.Name)
+	assert.Equal(t, "OPS-9", last.Reason)
+}
+
+func TestLintConfig(t *testing.T) {
+	h := NewRouteHandler(newMockStore(), testLogger(), nil, nil)
+	cfg := model.GatewayConfig{
+		Domains: []model.DomainConfig{{
+			Name:   "api",
+			Hosts:  []string{"api.example.com"},
+			Routes: []model.RouteConfig{{Name: "default", URI: "/*", Clusters: []model.WeightedCluster{{Name: "a", Weight: 100}}}},
+		}},
+		Clusters: []model.ClusterConfig{{
+			Name: "a", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 5},
+			Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}},
+		}},
+	}
+	lint := func(query string) map[string]any {
+		w := httptest.NewRecorder()
+		h.LintConfig(w, httptest.NewRequest("POST", "/api/v1/config/lint"+query, jsonBody(cfg)))
+		require.Equal(t, http.StatusOK, w.Code)
+		return decodeResp(t, w)
+	}
+
+	resp := lint("")
+	assert.Equal(t, true, resp["passed"])
+	assert.Equal(t, float64(1), resp["warnings"])
+	finding := resp["findings"].([]any)[0].(map[string]any)
+	assert.Equal(t, "single-node", finding["rule"])
+	assert.Equal(t, "warning", finding["severity"])
+
+	resp = lint("?strict=true")
+	assert.Equal(t, false, resp["passed"])
+	assert.Equal(t, float64(1), resp["errors"])
+	assert.Equal(t, "error", resp["findings"].([]any)[0].(map[string]any)["severity"])
+}
+
+func TestConfiguredRules(t *testing.T) {
+	rules := []model.Rule{
+		{Name: "two-nodes", Kind: "cluster", Field: "nodes", Op: model.RuleOpMin, Value: 2, Severity: model.SeverityError, Regions: []string{"prod"}},
+		{Name: "slow-read", Kind: "cluster", Field: "timeout.read", Op: model.RuleOpMin, Value: 10},
+	}
+	for i := range rules {
+		require.NoError(t, rules[i].Validate())
+	}
+	dyn := config.NewDynamic(rules)
+	ms := newMockStore()
+	h := NewClusterHandler(ms, testLogger(), nil, dyn)
+	c := model.ClusterConfig{
+		Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 5},
+		Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}},
+	}
+	create := func(region string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.CreateCluster(w, withRegion(httptest.NewRequest("POST", "/api/v1/clusters", jsonBody(c)), region))
+		return w
+	}
+
+	// An error rule rejects the write.
+	w := create("prod")
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	errs := decodeResp(t, w)["errors"].([]any)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "nodes", errs[0].(map[string]any)["field"])
+	assert.Contains(t, errs[0].(map[string]any)["message"], "(rule two-nodes)")
+
+	// Outside its regions only the warning rule applies.
+	w = create("staging")
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Contains(t, w.Body.String(), "rule slow-read")
+
+	// Reloaded rules take effect on the next request.
+	dyn.Store(nil)
+	c.Name = "backend-2"
+	w = create("prod")
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.NotContains(t, w.Body.String(), "rule ")
+
+	// LintConfig reports rule findings with the built-in ones.
+	dyn.Store(rules)
+	rh := NewRouteHandler(ms, testLogger(), nil, dyn)
+	w = httptest.NewRecorder()
+	rh.LintConfig(w, withRegion(httptest.NewRequest("POST", "/api/v1/config/lint",
+		jsonBody(model.GatewayConfig{Clusters: []model.ClusterConfig{c}})), "prod"))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, false, resp["passed"])
+	var got []string
+	for _, f := range resp["findings"].([]any) {
+		got = append(got, f.(map[string]any)["rule"].(string))
+	}
+	assert.Contains(t, got, "single-node")
+	assert.Contains(t, got, "two-nodes")
+	assert.Contains(t, got, "slow-read")
+}
+
+func TestConfiguredRules_IndirectWrites(t *testing.T) {
+	rule := model.Rule{Name: "two-nodes", Kind: "cluster", Field: "nodes", Op: model.RuleOpMin, Value: 2, Severity: model.SeverityError}
+	require.NoError(t, rule.Validate())
+	dyn := config.NewDynamic([]model.Rule{rule})
+	ms := newMockStore()
+	ctx := context.Background()
+	c := model.ClusterConfig{
+		Name: "backend", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Read: 5},
+		Nodes: []model.UpstreamNode{{Host: "h", Port: 80, Weight: 1}},
+	}
+	cfg := model.GatewayConfig{Clusters: []model.ClusterConfig{c}}
+	call := func(fn http.HandlerFunc, method, url string, body any) *httptest.ResponseRecorder {
+		var r *http.Request
+		if body != nil {
+			r = httptest.NewRequest(method, url, jsonBody(body))
+		} else {
+			r = httptest.NewRequest(method, url, nil)
+		}
+		w := httptest.NewRecorder()
+		fn(w, withRegion(r, "default"))
+		return w
+	}
+
+	// Scheduled changes are checked at submit time...
+	sh := NewScheduleHandler(ms, testLogger(), dyn)
+	later := time.Now().Add(time.Hour).Format(time.RFC3339)
+	w := call(sh.CreateScheduledChange, "POST", "/api/v1/scheduled-changes", map[string]any{"kind": "cluster", "apply_at": later, "cluster": c})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "(rule two-nodes)")
+
+	// ...and again at apply time, against the rules loaded then.
+	dyn.Store(nil)
+	require.Equal(t, http.StatusCreated, call(sh.CreateScheduledChange, "POST", "/api/v1/scheduled-changes", map[string]any{"kind": "cluster", "apply_at": later, "cluster": c}).Code)
+	dyn.Store([]model.Rule{rule})
+	ms.scheduled[0].ApplyAt = time.Now().Add(-time.Second)
+	assert.Equal(t, 0, RunScheduledChanges(ctx, ms, nil, dyn, testLogger()))
+	assert.Equal(t, store.ScheduledFailed, ms.scheduled[0].Status)
+	assert.Contains(t, ms.scheduled[0].Error, "(rule two-nodes)")
+	assert.Empty(t, ms.clusters["default"])
+
+	// Starting a canary, and promoting one started under looser rules.
+	h := NewRouteHandler(ms, testLogger(), nil, dyn)
+	assert.Equal(t, http.StatusBadRequest, call(h.PutCanary, "PUT", "/api/v1/config/canary", map[string]any{"percent": 10, "config": cfg}).Code)
+	assert.Nil(t, ms.canaries["default"])
+	dyn.Store(nil)
+	require.Equal(t, http.StatusOK, call(h.PutCanary, "PUT", "/api/v1/config/canary", map[string]any{"percent": 10, "config": cfg}).Code)
+	dyn.Store([]model.Rule{rule})
+	w = call(h.PromoteCanary, "POST", "/api/v1/config/canary/promote", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "(rule two-nodes)")
+	assert.NotNil(t, ms.canaries["default"])
+	assert.Empty(t, ms.clusters["default"])
+
+	// Restoring a revision written before the rule existed.
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "other"}, "create", "test", -1)
+	ms.configAt = map[int64]*model.GatewayConfig{1: &cfg}
+	w = call(h.RestoreConfig, "POST", "/api/v1/config/restore?revision=1", nil)
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "(rule two-nodes)")
+	assert.NotContains(t, ms.clusters["default"], "backend")
+}
+
+func TestOrphanClusters(t *testing.T) {
+	ms := newMockStore()
+	h := NewClusterHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	for _, name := range []string{"used", "canary", "dead", "stale"} {
+		ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: name}, "create", "test", -1)
+	}
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Routes: []model.RouteConfig{
+		{Name: "r", URI: "/*", Clusters: []model.WeightedCluster{{Name: "used", Weight: 90}, {Name: "canary", Weight: 10}}},
+	}}, "create", "test", -1)
+
+	w := httptest.NewRecorder()
+	h.ListOrphanClusters(w, withRegion(httptest.NewRequest("GET", "/api/v1/clusters/orphans", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	var names []string
+	for _, c := range decodeResp(t, w)["clusters"].([]any) {
+		names = append(names, c.(map[string]any)["name"].(string))
+	}
+	assert.ElementsMatch(t, []string{"dead", "stale"}, names)
+
+	w = httptest.NewRecorder()
+	h.DeleteOrphanClusters(w, withRegion(httptest.NewRequest("DELETE", "/api/v1/clusters/orphans", nil), "default"))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, ms.clusters["default"], 4)
+
+	w = httptest.NewRecorder()
+	h.DeleteOrphanClusters(w, withRegion(httptest.NewRequest("DELETE", "/api/v1/clusters/orphans?confirm=true", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, float64(2), decodeResp(t, w)["total"])
+	assert.Len(t, ms.clusters["default"], 2)
+	assert.Contains(t, ms.clusters["default"], "canary")
+}
+
+func TestConfigGraph(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "backend", Nodes: []model.UpstreamNode{{Host: "10.0.0.1", Port: 80}}}, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "dead"}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"a.example.com", "shared.example.com"}, Routes: []model.RouteConfig{
+		{Name: "r", URI: "/*", Clusters: []model.WeightedCluster{{Name: "backend", Weight: 90}, {Name: "gone", Weight: 10}}},
+	}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "web", Hosts: []string{"shared.example.com"}}, "create", "test", -1)
+
+	w := httptest.NewRecorder()
+	h.ConfigGraph(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/graph", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+
+	nodes := make(map[string]map[string]any)
+	for _, n := range resp["nodes"].([]any) {
+		node := n.(map[string]any)
+		nodes[node["id"].(string)] = node
+	}
+	require.Len(t, nodes, 6)
+	assert.Equal(t, []any{"shared.example.com"}, nodes["domain:api"]["duplicate_hosts"])
+	assert.Equal(t, []any{"shared.example.com"}, nodes["domain:web"]["duplicate_hosts"])
+	assert.Equal(t, "/*", nodes["route:api/r"]["uri"])
+	assert.Nil(t, nodes["cluster:backend"]["orphan"])
+	assert.Equal(t, []any{"10.0.0.1:80"}, nodes["cluster:backend"]["upstreams"])
+	assert.Equal(t, true, nodes["cluster:dead"]["orphan"])
+	assert.Equal(t, true, nodes["cluster:gone"]["missing"])
+
+	edges := resp["edges"].([]any)
+	require.Len(t, edges, 3)
+	assert.Contains(t, edges, map[string]any{"from": "route:api/r", "to": "cluster:backend", "weight": float64(90)})
+	assert.Contains(t, edges, map[string]any{"from": "domain:api", "to": "route:api/r"})
+}
+
+func TestListByLabels(t *testing.T) {
+	ms := newMockStore()
+	dh := NewDomainHandler(ms, testLogger(), nil, nil)
+	ch := NewClusterHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "pay", Labels: map[string]string{"team": "payments", "env": "prod"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "pay-staging", Labels: map[string]string{"team": "payments", "env": "staging"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "search"}, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "pay", Labels: map[string]string{"team": "payments"}}, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "search"}, "create", "test", -1)
+
+	list := func(handle http.HandlerFunc, query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handle(w, withRegion(httptest.NewRequest("GET", "/"+query, nil), "default"))
+		return w
+	}
+	total := func(w *httptest.ResponseRecorder) float64 {
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		return decodeResp(t, w)["total"].(float64)
+	}
+
+	assert.Equal(t, float64(3), total(list(dh.ListDomains, "")))
+	assert.Equal(t, float64(2), total(list(dh.ListDomains, "?label=team=payments")))
+	assert.Equal(t, float64(1), total(list(dh.ListDomains, "?label=team=payments&label=env=prod")))
+	assert.Equal(t, float64(0), total(list(dh.ListDomains, "?label=team=search")))
+	assert.Equal(t, float64(1), total(list(ch.ListClusters, "?label=team=payments")))
+	assert.Equal(t, http.StatusBadRequest, list(dh.ListDomains, "?label=team").Code)
+}
+
+type fakeEtcd struct {
+	domains, clusters map[string]string
+	err               error
+}
+
+func (f *fakeEtcd) Domains(context.Context, string) (map[string]string, error) {
+	return f.domains, f.err
+}
+
+func (f *fakeEtcd) Clusters(context.Context, string) (map[string]string, error) {
+	return f.clusters, f.err
+}
+
+func TestEtcdPreview(t *testing.T) {
+	ms := newMockStore()
+	ctx := context.Background()
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "backend", Labels: map[string]string{"tier": "edge"}}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"a.example.com"}}, "create", "test", -1)
+	ms.auditRevisions = 2
+	h := NewDriftHandler(ms, nil, testLogger()) // no etcd needed
+
+	preview := func(query string) map[string]any {
+		w := httptest.NewRecorder()
+		h.EtcdPreview(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/etcd-preview"+query, nil), "default"))
+		require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var resp map[string]any
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &resp))
+		// Values are the exact strings the controller writes.
+		var raw struct {
+			Keys map[string]json.RawMessage `json:"keys"`
+		}
+		require.NoError(t, json.Unmarshal(w.Body.Bytes(), &raw))
+		for k, v := range raw.Keys {
+			assert.Equal(t, canonicalJSON(v), string(v), k)
+		}
+		return resp
+	}
+
+	resp := preview("")
+	keys := resp["keys"].(map[string]any)
+	assert.Equal(t, float64(3), resp["total"])
+	assert.Equal(t, "api", keys["/hermes/domains/api"].(map[string]any)["name"])
+	assert.Equal(t, "backend", keys["/hermes/clusters/backend"].(map[string]any)["name"])
+	// The controller publishes its watch revision, which counts every event.
+	assert.Equal(t, float64(ms.revision+ms.auditRevisions), keys["/hermes/meta/config_revision"])
+	assert.Equal(t, float64(ms.revision), resp["resource_version"])
+
+	resp = preview("?domain_prefix=/gw/d/&cluster_prefix=/gw/c&meta_prefix=/gw/m&selector=tier=edge")
+	keys = resp["keys"].(map[string]any)
+	assert.Len(t, keys, 2)
+	assert.Contains(t, keys, "/gw/c/backend")
+	assert.Contains(t, keys, "/gw/m/config_revision")
+
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "edge", Hosts: []string{"e.example.com"}, Labels: map[string]string{"tier": "edge"},
+		Routes: []model.RouteConfig{{Name: "r", URI: "/", Clusters: []model.WeightedCluster{{Name: "other", Weight: 100}}}}}, "create", "test", -1)
+	w := httptest.NewRecorder()
+	h.EtcdPreview(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/etcd-preview?selector=tier=edge", nil), "default"))
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+}
+
+func TestConfigDrift(t *testing.T) {
+	ms := newMockStore()
+	ctx := context.Background()
+	backend := &model.ClusterConfig{Name: "backend", Nodes: []model.UpstreamNode{{Host: "10.0.0.1", Port: 80}}}
+	ms.PutCluster(ctx, "default", backend, "create", "test", -1)
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "new"}, "create", "test", -1)
+	ms.PutDomain(ctx, "default", &model.DomainConfig{Name: "api", Hosts: []string{"a.example.com"}}, "create", "test", -1)
+
+	drift := func(h *DriftHandler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ConfigDrift(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/drift", nil), "default"))
+		return w
+	}
+
+	assert.Equal(t, http.StatusServiceUnavailable, drift(NewDriftHandler(ms, nil, testLogger())).Code, "no etcd configured")
+	assert.Equal(t, http.StatusBadGateway, drift(NewDriftHandler(ms, &fakeEtcd{err: fmt.Errorf("connection refused")}, testLogger())).Code)
+
+	// Same document, different key order and whitespace: in sync.
+	live, _ := json.Marshal(backend)
+	var obj map[string]any
+	require.NoError(t, json.Unmarshal(live, &obj))
+	indented, _ := json.MarshalIndent(obj, "", "  ")
+	etcd := &fakeEtcd{
+		domains:  map[string]string{"api": `{"hosts":["b.example.com"],"name":"api"}`, "stale": `{"name":"stale"}`},
+		clusters: map[string]string{"backend": string(indented)},
+	}
+	w := drift(NewDriftHandler(ms, etcd, testLogger()))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, false, resp["in_sync"])
+	assert.Equal(t, []any{map[string]any{"kind": "cluster", "name": "new"}}, resp["missing"])
+	assert.Equal(t, []any{map[string]any{"kind": "domain", "name": "stale"}}, resp["extra"])
+	assert.Equal(t, []any{map[string]any{"kind": "domain", "name": "api"}}, resp["mismatched"])
+}
+
+func TestTriggerSync(t *testing.T) {
+	ms := newMockStore()
+	h := NewWatchHandler(ms, testLogger())
+
+	w := httptest.NewRecorder()
+	h.TriggerSync(w, withRegion(httptest.NewRequest("POST", "/api/v1/config/trigger-sync", nil), "default"))
+	require.Equal(t, http.StatusAccepted, w.Code)
+	rev := decodeResp(t, w)["revision"].(float64)
+
+	w = httptest.NewRecorder()
+	h.WatchConfig(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/watch?revision=0", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	events := decodeResp(t, w)["events"].([]any)
+	require.Len(t, events, 1)
+	ev := events[0].(map[string]any)
+	assert.Equal(t, "sync", ev["kind"])
+	assert.Equal(t, rev, ev["revision"])
+}
+
+func TestRegionTargets(t *testing.T) {
+	ms := newMockStore()
+	h := NewRegionHandler(ms, testLogger(), nil)
+
+	put := func(region string, body any) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/regions/"+region+"/targets", jsonBody(body))
+		r.SetPathValue("name", region)
+		w := httptest.NewRecorder()
+		h.PutRegionTargets(w, r)
+		return w
+	}
+
+	targets := []store.FanoutTarget{
+		{Name: "us-east", Endpoints: []string{"http://etcd-use:2379"}},
+		{Name: "eu-west", Endpoints: []string{"https://etcd-euw:2379"}, DomainPrefix: "/hermes-eu/domains"},
+	}
+	require.Equal(t, http.StatusOK, put("default", map[string]any{"targets": targets}).Code)
+	assert.Equal(t, targets, ms.targets["default"])
+	assert.Equal(t, http.StatusNotFound, put("nope", map[string]any{"targets": targets}).Code)
+
+	bad := []store.FanoutTarget{
+		{Name: "us-east", Endpoints: []string{"etcd:2379"}},
+		{Name: "us-east", DomainPrefix: "hermes"},
+	}
+	w := put("default", map[string]any{"targets": bad})
+	require.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Len(t, decodeResp(t, w)["errors"], 4)
+
+	// Controllers read their own region's targets via the region header.
+	w = httptest.NewRecorder()
+	h.ConfigTargets(w, withRegion(httptest.NewRequest("GET", "/api/v1/config/targets", nil), "default"))
+	require.Equal(t, http.StatusOK, w.Code)
+	resp := decodeResp(t, w)
+	assert.Equal(t, float64(2), resp["total"])
+	assert.Equal(t, "eu-west", resp["targets"].([]any)[1].(map[string]any)["name"])
+}
+
+func TestConfigCanary(t *testing.T) {
+	ms := newMockStore()
+	h := NewRouteHandler(ms, testLogger(), nil, nil)
+	ctx := context.Background()
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "old", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Send: 5, Read: 5}, Nodes: []model.UpstreamNode{{Host: "10.0.0.1", Port: 80, Weight: 1}}}, "create", "test", -1)
+
+	newCfg := model.GatewayConfig{Clusters: []model.ClusterConfig{
+		{Name: "new", LBType: "roundrobin", Timeout: model.TimeoutConfig{Connect: 1, Send: 5, Read: 5}, Nodes: []model.UpstreamNode{{Host: "10.0.0.2", Port: 80, Weight: 1}}},
+	}}
+	call := func(fn http.HandlerFunc, method, url string, body any) *httptest.ResponseRecorder {
+		var r *http.Request
+		if body != nil {
+			r = httptest.NewRequest(method, url, jsonBody(body))
+		} else {
+			r = httptest.NewRequest(method, url, nil)
+		}
+		w := httptest.NewRecorder()
+		fn(w, withRegion(r, "default"))
+		return w
+	}
+	clusterOf := func(w *httptest.ResponseRecorder) string {
+		require.Equal(t, http.StatusOK, w.Code)
+		cfg := decodeResp(t, w)["config"].(map[string]any)
+		return cfg["clusters"].([]any)[0].(map[string]any)["name"].(string)
+	}
+
+	assert.Equal(t, http.StatusNotFound, call(h.GetCanary, "GET", "/api/v1/config/canary", nil).Code)
+	assert.Equal(t, "old", clusterOf(call(h.GetConfig, "GET", "/api/v1/config?channel=canary", nil)), "no canary: stable")
+	assert.Equal(t, http.StatusBadRequest, call(h.PutCanary, "PUT", "/api/v1/config/canary", map[string]any{"percent": 0, "config": newCfg}).Code)
+	assert.Equal(t, http.StatusBadRequest, call(h.GetConfig, "GET", "/api/v1/config?channel=beta", nil).Code)
+
+	require.Equal(t, http.StatusOK, call(h.PutCanary, "PUT", "/api/v1/config/canary", map[string]any{"percent": 10, "config": newCfg}).Code)
+	assert.Equal(t, "new", clusterOf(call(h.GetConfig, "GET", "/api/v1/config?channel=canary", nil)))
+	assert.Equal(t, "old", clusterOf(call(h.GetConfig, "GET", "/api/v1/config", nil)))
+
+	// Instances are split by a stable hash; 10% lands roughly one in ten.
+	onCanary := 0
+	for i := 0; i < 1000; i++ {
+		id := fmt.Sprintf("gw-%d", i)
+		ch := canaryChannel(id, 10)
+		assert.Equal(t, ch, canaryChannel(id, 10))
+		if ch == ChannelCanary {
+			onCanary++
+			assert.Equal(t, ChannelCanary, canaryChannel(id, 50), "raising the percentage keeps canary instances")
+		}
+	}
+	assert.InDelta(t, 100, onCanary, 40)
+	var canaryID, stableID string
+	for i := 0; canaryID == "" || stableID == ""; i++ {
+		id := fmt.Sprintf("gw-%d", i)
+		if canaryChannel(id, 10) == ChannelCanary {
+			canaryID = id
+		} else {
+			stableID = id
+		}
+	}
+	assert.Equal(t, "new", clusterOf(call(h.GetConfig, "GET", "/api/v1/config?instance="+canaryID, nil)))
+	assert.Equal(t, "old", clusterOf(call(h.GetConfig, "GET", "/api/v1/config?instance="+stableID, nil)))
+
+	ms.UpsertGatewayInstances(ctx, "default", []store.GatewayInstanceStatus{{ID: canaryID}, {ID: stableID}})
+	w := call(h.GetCanary, "GET", "/api/v1/config/canary", nil)
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]any{"canary": []any{canaryID}, "stable": []any{stableID}}, decodeResp(t, w)["instances"])
+
+	// A stable write after the canary started blocks promotion until the
+	// canary is replaced, so promoting cannot undo it.
+	ms.PutCluster(ctx, "default", &model.ClusterConfig{Name: "hotfix"}, "create", "test", -1)
+	assert.Equal(t, http.StatusConflict, call(h.PromoteCanary, "POST", "/api/v1/config/canary/promote", nil).Code)
+	assert.NotNil(t, ms.canaries["default"])
+	require.Equal(t, http.StatusOK, call(h.PutCanary, "PUT", "/api/v1/config/canary", map[string]any{"percent": 10, "config": newCfg}).Code)
+
+	require.Equal(t, http.StatusOK, call(h.PromoteCanary, "POST", "/api/v1/config/canary/promote", nil).Code)
+	assert.Nil(t, ms.canaries["default"])
+	assert.Equal(t, "new", clusterOf(call(h.GetConfig, "GET", "/api/v1/config", nil)))
+	assert.Equal(t, http.StatusNotFound, call(h.AbortCanary, "DELETE", "/api/v1/config/canary", nil).Code)
+}
+
+func TestCustomRoles(t *testing.T) {
+	ms := newMockStore()
+	h := NewMemberHandler(ms, nil, testLogger(), config.PasswordPolicyConfig{})
+	ctx := context.Background()
+	require.NoError(t, ms.UpsertUser(ctx, &store.User{Sub: "carol", Username: "carol"}))
+
+	owner := &Identity{Subject: "owner", Scopes: store.RoleToScopes(store.RoleOwner, false)}
+	put := func(caller *Identity, name string, scopes []string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("PUT", "/api/v1/custom-roles/"+name, jsonBody(map[string]any{"scopes": scopes}))
+		r.SetPathValue("name", name)
+		r = withRegion(r, "default")
+		r = r.WithContext(context.WithValue(r.Context(), identityKey, caller))
+		w := httptest.NewRecorder()
+		h.PutCustomRole(w, r)
+		return w
+	}
+	del := func(name string) int {
+		r := httptest.NewRequest("DELETE", "/api/v1/custom-roles/"+name, nil)
+		r.SetPathValue("name", name)
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.DeleteCustomRole(w, r)
+		return w.Code
+	}
+
+	release := []string{store.ScopeConfigRead, store.ScopeConfigWrite, store.ScopeConfigRollback}
+	require.Equal(t, http.StatusOK, put(owner, "release-manager", release).Code)
+
+	assert.Equal(t, http.StatusBadRequest, put(owner, "editor", release).Code, "builtin names are reserved")
+	assert.Equal(t, http.StatusBadRequest, put(owner, "Bad_Name", release).Code)
+	assert.Equal(t, http.StatusBadRequest, put(owner, "x", []string{"nope:nope"}).Code)
+	assert.Equal(t, http.StatusBadRequest, put(owner, "x", []string{store.ScopeAdminUsers}).Code)
+	editor := &Identity{Subject: "editor", Scopes: store.RoleToScopes(store.RoleEditor, false)}
+	assert.Equal(t, http.StatusForbidden, put(editor, "x", release).Code, "cannot grant scopes the caller lacks")
+
+	// Assign the custom role; unknown roles are rejected.
+	addMember := func(role string) int {
+		r := httptest.NewRequest("POST", "/api/v1/members", jsonBody(map[string]string{"user_sub": "carol", "role": role}))
+		r = withRegion(r, "default")
+		w := httptest.NewRecorder()
+		h.AddMember(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusBadRequest, addMember("ghost"))
+	require.Equal(t, http.StatusOK, addMember("release-manager"))
+
+	// Authenticate resolves the custom role to its scopes.
+	verify := func(string) (*OIDCClaims, error) { return &OIDCClaims{Sub: "carol"}, nil }
+	var got *Identity
+	protected := Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = IdentityFromContext(r.Context())
+	}), RegionMiddleware, Authenticate(ms, nil, verify, testLogger()))
+	r := httptest.NewRequest("GET", "/api/v1/domains", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	protected.ServeHTTP(httptest.NewRecorder(), r)
+	require.NotNil(t, got)
+	assert.Equal(t, release, got.Scopes)
+	assert.False(t, got.HasScope(store.ScopeMemberWrite))
+
+	// GET /api/v1/roles lists it next to the builtins.
+	w := httptest.NewRecorder()
+	h.ListRoles(w, withRegion(httptest.NewRequest("GET", "/api/v1/roles", nil), "default"))
+	assert.Contains(t, w.Body.String(), `"release-manager"`)
+
+	assert.Equal(t, http.StatusConflict, del("release-manager"), "still assigned")
+	assert.Equal(t, http.StatusBadRequest, del("owner"))
+	ms.members = map[string]store.RegionRole{}
+	assert.Equal(t, http.StatusOK, del("release-manager"))
+}
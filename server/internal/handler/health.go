@@ -0,0 +1,64 @@
+package handler
+
+import (
+	"fmt"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// Region health levels, from best to worst.
+const (
+	HealthGreen  = "green"
+	HealthYellow = "yellow"
+	HealthRed    = "red"
+)
+
+// Health is a region's overall status with the reasons it is not green.
+type Health struct {
+	Status  string   `json:"status"`
+	Reasons []string `json:"reasons"`
+}
+
+func (h *Health) raise(level, reason string) {
+	if level == HealthRed || (level == HealthYellow && h.Status == HealthGreen) {
+		h.Status = level
+	}
+	h.Reasons = append(h.Reasons, reason)
+}
+
+// regionHealth derives a region's health from its gateway instance counts
+// (total, not offline, and online at the latest config revision) and its
+// controller, which is nil if none has reported.
+func regionHealth(t config.HealthConfig, total, online, inSync int, ctrl *store.ControllerStatus, revision int64) Health {
+	h := Health{Status: HealthGreen, Reasons: []string{}}
+
+	switch {
+	case ctrl == nil:
+		h.raise(HealthRed, "no controller has reported")
+	case ctrl.Status == "offline":
+		h.raise(HealthRed, fmt.Sprintf("controller %s is offline", ctrl.ID))
+	case ctrl.Status != "running":
+		h.raise(HealthYellow, fmt.Sprintf("controller %s is %s", ctrl.ID, ctrl.Status))
+	case ctrl.ConfigRevision < revision:
+		h.raise(HealthYellow, fmt.Sprintf("controller %s is behind config revision %d", ctrl.ID, revision))
+	}
+
+	if total == 0 {
+		h.raise(HealthYellow, "no gateway instances registered")
+		return h
+	}
+	if offline := total - online; offline > 0 {
+		reason := fmt.Sprintf("%d of %d gateway instances offline", offline, total)
+		ratio := float64(offline) / float64(total)
+		if ratio > t.RedOfflineRatio {
+			h.raise(HealthRed, reason)
+		} else if ratio > t.YellowOfflineRatio {
+			h.raise(HealthYellow, reason)
+		}
+	}
+	if lagging := online - inSync; lagging > 0 && float64(lagging)/float64(online) > t.YellowOutOfSyncRatio {
+		h.raise(HealthYellow, fmt.Sprintf("%d of %d online gateway instances behind config revision %d", lagging, online, revision))
+	}
+	return h
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// purgeHistory serves DELETE /api/v1/{domains,clusters}/{name}/history,
+// which erases a deleted resource's history for data-deletion requests. It
+// requires ?confirm=true and refuses resources that still exist; the purge
+// itself is recorded without the purged content.
+func purgeHistory(w http.ResponseWriter, r *http.Request, s store.Store, logger *zap.SugaredLogger, kind string) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+	if r.URL.Query().Get("confirm") != "true" {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("purging %s history requires ?confirm=true", kind))
+		return
+	}
+
+	purged, err := s.PurgeHistory(r.Context(), region, kind, name, Operator(r))
+	if errors.Is(err, store.ErrResourceExists) {
+		ErrJSON(w, http.StatusConflict, fmt.Sprintf("%s %q still exists; delete it before purging its history", kind, name))
+		return
+	}
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if purged == 0 {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("no history for %s %q", kind, name))
+		return
+	}
+	logger.Warnf("%s history purged: %s (ns=%s), %d rows, by %s", kind, name, region, purged, Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"kind": kind, "name": name, "purged": purged})
+}
+
+// PurgeDomainHistory erases the history of a deleted domain.
+func (h *DomainHandler) PurgeDomainHistory(w http.ResponseWriter, r *http.Request) {
+	purgeHistory(w, r, h.store, h.logger, "domain")
+}
+
+// PurgeClusterHistory erases the history of a deleted cluster.
+func (h *ClusterHandler) PurgeClusterHistory(w http.ResponseWriter, r *http.Request) {
+	purgeHistory(w, r, h.store, h.logger, "cluster")
+}
+
+// ListHistory is the region's config timeline: every domain and cluster
+// version, newest first, with its full snapshot for point-in-time
+// inspection. Unlike the audit log it covers only config changes. Filters:
+// ?kind=, ?name=, ?operator=, and ?since= / ?until= (RFC 3339).
+func (h *AuditHandler) ListHistory(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	p, err := parsePage(r, 50)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	q := r.URL.Query()
+	filter := store.HistoryFilter{Kind: q.Get("kind"), Name: q.Get("name"), Operator: q.Get("operator")}
+	switch filter.Kind {
+	case "", "domain", "cluster":
+	default:
+		ErrJSON(w, http.StatusBadRequest, "kind must be domain or cluster")
+		return
+	}
+	for _, f := range []struct {
+		param string
+		dst   *time.Time
+	}{{"since", &filter.Since}, {"until", &filter.Until}} {
+		if v := q.Get(f.param); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("%s must be an RFC 3339 timestamp", f.param))
+				return
+			}
+			*f.dst = t
+		}
+	}
+
+	entries, total, err := h.store.ListHistory(r.Context(), region, filter, p.limit, p.offset)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if entries == nil {
+		entries = []store.HistoryEntry{}
+	}
+
+	setPageHeaders(w, r, p, int(total))
+	JSON(w, http.StatusOK, map[string]any{
+		"history": entries,
+		"total":   total,
+		"limit":   p.limit,
+		"offset":  p.offset,
+	})
+}
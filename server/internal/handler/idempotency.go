@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// idempotencyTTL is how long a completed request's response is replayed.
+	idempotencyTTL = 24 * time.Hour
+	// idempotencyLease bounds how long a request holds its key while running,
+	// so a replica that dies mid-request does not block retries for a day.
+	idempotencyLease = time.Minute
+	// maxIdempotencyKeyLen caps the Idempotency-Key header.
+	maxIdempotencyKeyLen = 255
+)
+
+// idempotencyRecorder buffers the response so it can be stored before it is
+// sent.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(code int) {
+	if rec.status == 0 {
+		rec.status = code
+	}
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	return rec.body.Write(b)
+}
+
+// Idempotency makes retries of a create safe. A request carrying an
+// Idempotency-Key header runs once per caller, key, region and route; repeats within
+// idempotencyTTL get the original status and body back with
+// Idempotent-Replayed: true instead of running again. Reusing a key with a
+// different body is rejected with 422, and a repeat that arrives while the
+// first is still running gets 409. Server errors are not kept, so the
+// request can be retried. Requests without the header are unaffected. Must
+// be applied after Authenticate + RegionMiddleware.
+func Idempotency(s store.Store, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return idempotency(s, false, nil, logger)
+}
+
+// SealedIdempotency is Idempotency for routes whose response carries a
+// secret, such as a new credential's secret key. The stored body is sealed
+// with box; without a master key only the status is kept, and a replay
+// returns it with no body.
+func SealedIdempotency(s store.Store, box *secretbox.Box, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return idempotency(s, true, box, logger)
+}
+
+// idempotencyKey scopes the client's key to the authenticated caller, so a
+// caller can neither replay nor block another's request by reusing its key.
+// The subject is length-prefixed so no subject/key pair can collide with
+// another.
+func idempotencyKey(r *http.Request, key string) string {
+	var subject string
+	if id := IdentityFromContext(r.Context()); id != nil {
+		subject = id.Subject
+	}
+	return fmt.Sprintf("%d:%s:%s", len(subject), subject, key)
+}
+
+func idempotency(s store.Store, sealed bool, box *secretbox.Box, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("Idempotency-Key")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLen {
+				ErrJSON(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+				return
+			}
+
+			body, err := ReadBody(r)
+			if err != nil {
+				ErrJSON(w, http.StatusBadRequest, "read body: "+err.Error())
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			key = idempotencyKey(r, key)
+			sum := sha256.Sum256(body)
+			fingerprint := hex.EncodeToString(sum[:])
+
+			region := RegionFromContext(r.Context())
+			route := r.Pattern
+			if route == "" {
+				route = r.Method + " " + r.URL.Path
+			}
+
+			prev, reserved, err := s.ReserveIdempotencyKey(r.Context(), region, route, key, fingerprint, idempotencyLease)
+			if err != nil {
+				logger.Errorw("reserve idempotency key failed", "region", region, "route", route, "error", err)
+				ErrJSON(w, http.StatusServiceUnavailable, "idempotency store unavailable, try again later")
+				return
+			}
+			if !reserved {
+				switch {
+				case prev.Fingerprint != fingerprint:
+					ErrJSON(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used with a different request body")
+				case prev.Status == 0:
+					ErrJSON(w, http.StatusConflict, "a request with this Idempotency-Key is still in progress")
+				default:
+					replay := prev.Body
+					if sealed && len(replay) > 0 {
+						if replay, err = box.Open(string(replay)); err != nil {
+							logger.Errorw("open idempotent response failed", "region", region, "route", route, "error", err)
+							ErrJSON(w, http.StatusInternalServerError, "stored response could not be read")
+							return
+						}
+					}
+					if prev.ContentType != "" {
+						w.Header().Set("Content-Type", prev.ContentType)
+					}
+					w.Header().Set("Idempotent-Replayed", "true")
+					w.WriteHeader(prev.Status)
+					_, _ = w.Write(replay)
+				}
+				return
+			}
+
+			rec := &idempotencyRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r)
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+
+			if rec.status >= 500 {
+				if err := s.ReleaseIdempotencyKey(r.Context(), region, route, key); err != nil {
+					logger.Warnw("release idempotency key failed", "region", region, "route", route, "error", err)
+				}
+			} else {
+				stored := &store.IdempotencyRecord{
+					Status:      rec.status,
+					ContentType: w.Header().Get("Content-Type"),
+					Body:        rec.body.Bytes(),
+				}
+				if sealed {
+					stored.ContentType, stored.Body = "", nil
+					if box != nil {
+						if v, err := box.Seal(rec.body.Bytes()); err != nil {
+							logger.Warnw("seal idempotent response failed", "region", region, "route", route, "error", err)
+						} else {
+							stored.ContentType, stored.Body = w.Header().Get("Content-Type"), []byte(v)
+						}
+					}
+				}
+				if err := s.CompleteIdempotencyKey(r.Context(), region, route, key, stored, idempotencyTTL); err != nil {
+					logger.Warnw("store idempotency key failed", "region", region, "route", route, "error", err)
+				}
+			}
+
+			w.WriteHeader(rec.status)
+			_, _ = w.Write(rec.body.Bytes())
+		})
+	}
+}
@@ -1,14 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"io"
 	"net/http"
 	"strings"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
 )
 
-// maxRequestBodySize is the maximum allowed request body size (1 MiB).
+// maxRequestBodySize bounds bodies read from the IdP (1 MiB). Incoming
+// request bodies are bounded by the MaxBodySize middleware instead.
 const maxRequestBodySize = 1 << 20
 
 // JSON writes a JSON response with the given status code.
@@ -23,21 +27,66 @@ func JSON(w http.ResponseWriter, code int, v any) {
 	}
 }
 
-// ErrJSON writes an error JSON response: {"error": msg}.
+// ErrJSON writes an error JSON response: {"error": msg}. When the RequestID
+// middleware has tagged the response, the body also carries "request_id".
 func ErrJSON(w http.ResponseWriter, code int, msg string) {
-	JSON(w, code, map[string]string{"error": msg})
+	body := map[string]string{"error": msg}
+	if id := w.Header().Get(RequestIDHeader); id != "" {
+		body["request_id"] = id
+	}
+	JSON(w, code, body)
 }
 
-// ReadBody reads the request body with a size limit to prevent OOM attacks.
-// Returns at most maxRequestBodySize bytes.
+// ReadBody reads the request body. Its size is bounded by MaxBodySize.
 func ReadBody(r *http.Request) ([]byte, error) {
-	return io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	return io.ReadAll(r.Body)
 }
 
-// DecodeJSON reads the request body as JSON into v with a size limit.
+// DecodeJSON reads the request body as JSON into v. Its size is bounded by
+// MaxBodySize. In strict mode (see StrictJSON) a field v does not declare is
+// an error naming the field.
 func DecodeJSON(r *http.Request, v any) error {
 	defer r.Body.Close()
-	return json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize+1)).Decode(v)
+	dec := json.NewDecoder(r.Body)
+	if strict, _ := r.Context().Value(strictJSONKey).(bool); strict {
+		dec.DisallowUnknownFields()
+	}
+	return dec.Decode(v)
+}
+
+// decodeJSONStrict is DecodeJSON in strict mode regardless of the request,
+// for bodies where a misspelled key would otherwise be silently ignored.
+func decodeJSONStrict(r *http.Request, v any) error {
+	return DecodeJSON(r.WithContext(context.WithValue(r.Context(), strictJSONKey, true)), v)
+}
+
+// StrictJSONHeader turns strict decoding on ("true") or off ("false") for
+// one request, overriding server.strict_json.
+const StrictJSONHeader = "X-Hermes-Strict-JSON"
+
+type strictJSONKeyType struct{}
+
+var strictJSONKey = strictJSONKeyType{}
+
+// StrictJSON decides per request whether DecodeJSON rejects unknown fields:
+// the StrictJSONHeader if present, the server default otherwise.
+func StrictJSON(def *config.Dynamic[bool]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			strict := def.Load()
+			switch r.Header.Get(StrictJSONHeader) {
+			case "":
+			case "true":
+				strict = true
+			case "false":
+				strict = false
+			default:
+				ErrJSON(w, http.StatusBadRequest, StrictJSONHeader+" must be true or false")
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), strictJSONKey, strict)))
+		})
+	}
 }
 
 // Operator extracts the operator identity from the OIDC claims in context
@@ -57,6 +106,9 @@ func Operator(r *http.Request) string {
 		}
 		return claims.Sub
 	}
+	if id := IdentityFromContext(r.Context()); id != nil && id.ServiceAccount != nil {
+		return id.Subject
+	}
 
 	// Fallback: parse JWT payload without verification (for HMAC-authed controller requests
 	// that may carry a Bearer token forwarded from the original user).
@@ -0,0 +1,282 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// JSON Patch (RFC 6902) applied to documents decoded into Go's generic JSON
+// representation (map[string]any, []any, float64, string, bool, nil).
+
+type patchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	From  string          `json:"from,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// applyJSONPatch applies ops to doc in order and returns the new document.
+// The patch is atomic: on error the returned document must be discarded.
+func applyJSONPatch(doc any, ops []patchOp) (any, error) {
+	for i, op := range ops {
+		var err error
+		doc, err = applyPatchOp(doc, op)
+		if err != nil {
+			return nil, fmt.Errorf("op %d (%s %s): %w", i, op.Op, op.Path, err)
+		}
+	}
+	return doc, nil
+}
+
+func applyPatchOp(doc any, op patchOp) (any, error) {
+	value := func() (any, error) {
+		if op.Value == nil {
+			return nil, fmt.Errorf("value is required")
+		}
+		var v any
+		if err := json.Unmarshal(op.Value, &v); err != nil {
+			return nil, fmt.Errorf("invalid value: %w", err)
+		}
+		return v, nil
+	}
+
+	switch op.Op {
+	case "add":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, v)
+	case "remove":
+		doc, _, err := patchRemove(doc, op.Path)
+		return doc, err
+	case "replace":
+		v, err := value()
+		if err != nil {
+			return nil, err
+		}
+		if op.Path == "" {
+			return v, nil
+		}
+		if _, err := patchGet(doc, op.Path); err != nil {
+			return nil, err
+		}
+		doc, _, err = patchRemove(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, v)
+	case "move":
+		if op.From == op.Path {
+			return doc, nil
+		}
+		if strings.HasPrefix(op.Path, op.From+"/") {
+			return nil, fmt.Errorf("cannot move %q into its own child", op.From)
+		}
+		doc, v, err := patchRemove(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, v)
+	case "copy":
+		v, err := patchGet(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return patchAdd(doc, op.Path, deepCopyJSON(v))
+	case "test":
+		want, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := patchGet(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(got, want) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unsupported op %q", op.Op)
+}
+
+// parsePointer splits an RFC 6901 JSON Pointer into unescaped tokens.
+func parsePointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(path, "/") {
+		return nil, fmt.Errorf("pointer must start with /")
+	}
+	tokens := strings.Split(path[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex parses tok as an index into arr. "-" (one past the end) is only
+// allowed when appending.
+func arrayIndex(tok string, arr []any, appending bool) (int, error) {
+	if tok == "-" && appending {
+		return len(arr), nil
+	}
+	idx, err := strconv.Atoi(tok)
+	if err != nil || idx < 0 || (tok != "0" && strings.HasPrefix(tok, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", tok)
+	}
+	limit := len(arr) - 1
+	if appending {
+		limit = len(arr)
+	}
+	if idx > limit {
+		return 0, fmt.Errorf("array index %d out of range", idx)
+	}
+	return idx, nil
+}
+
+func patchGet(doc any, path string) (any, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	cur := doc
+	for _, tok := range tokens {
+		switch c := cur.(type) {
+		case map[string]any:
+			v, ok := c[tok]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			cur = v
+		case []any:
+			idx, err := arrayIndex(tok, c, false)
+			if err != nil {
+				return nil, err
+			}
+			cur = c[idx]
+		default:
+			return nil, fmt.Errorf("path %q not found", path)
+		}
+	}
+	return cur, nil
+}
+
+// patchAdd sets the value at path, inserting into arrays and creating or
+// replacing object members. The parent must already exist.
+func patchAdd(doc any, path string, v any) (any, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return v, nil
+	}
+	return patchAt(doc, tokens, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			p[last] = v
+			return p, nil
+		case []any:
+			idx, err := arrayIndex(last, p, true)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[idx+1:], p[idx:])
+			p[idx] = v
+			return p, nil
+		}
+		return nil, fmt.Errorf("parent of %q is not a container", path)
+	})
+}
+
+// patchRemove deletes the value at path and returns it.
+func patchRemove(doc any, path string) (any, any, error) {
+	tokens, err := parsePointer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+	var removed any
+	doc, err = patchAt(doc, tokens, func(parent any, last string) (any, error) {
+		switch p := parent.(type) {
+		case map[string]any:
+			v, ok := p[last]
+			if !ok {
+				return nil, fmt.Errorf("path %q not found", path)
+			}
+			removed = v
+			delete(p, last)
+			return p, nil
+		case []any:
+			idx, err := arrayIndex(last, p, false)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[idx]
+			return append(p[:idx], p[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("path %q not found", path)
+	})
+	return doc, removed, err
+}
+
+// patchAt walks to the parent of the final token and replaces it with the
+// result of fn, re-linking the (possibly reallocated) parent into its own
+// parent on the way back up.
+func patchAt(cur any, tokens []string, fn func(parent any, last string) (any, error)) (any, error) {
+	if len(tokens) == 1 {
+		return fn(cur, tokens[0])
+	}
+	tok := tokens[0]
+	switch c := cur.(type) {
+	case map[string]any:
+		child, ok := c[tok]
+		if !ok {
+			return nil, fmt.Errorf("path segment %q not found", tok)
+		}
+		updated, err := patchAt(child, tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[tok] = updated
+		return c, nil
+	case []any:
+		idx, err := arrayIndex(tok, c, false)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := patchAt(c[idx], tokens[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		c[idx] = updated
+		return c, nil
+	}
+	return nil, fmt.Errorf("path segment %q not found", tok)
+}
+
+func deepCopyJSON(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		m := make(map[string]any, len(t))
+		for k, e := range t {
+			m[k] = deepCopyJSON(e)
+		}
+		return m
+	case []any:
+		a := make([]any, len(t))
+		for i, e := range t {
+			a[i] = deepCopyJSON(e)
+		}
+		return a
+	}
+	return v
+}
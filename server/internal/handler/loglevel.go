@@ -0,0 +1,48 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// LogLevelHandler reads and changes the logger's level at runtime. Changes
+// apply to this replica only and last until restart (or a config reload,
+// which restores server.log_level).
+type LogLevelHandler struct {
+	level  zap.AtomicLevel
+	logger *zap.SugaredLogger
+}
+
+func NewLogLevelHandler(level zap.AtomicLevel, logger *zap.SugaredLogger) *LogLevelHandler {
+	return &LogLevelHandler{level: level, logger: logger}
+}
+
+// GetLogLevel returns the current level.
+func (h *LogLevelHandler) GetLogLevel(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, map[string]any{"level": h.level.String()})
+}
+
+// SetLogLevel changes the level to one of debug, info, warn or error.
+func (h *LogLevelHandler) SetLogLevel(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	level, err := zapcore.ParseLevel(req.Level)
+	if err != nil || level < zapcore.DebugLevel || level > zapcore.ErrorLevel {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("level must be debug, info, warn or error, got %q", req.Level))
+		return
+	}
+
+	previous := h.level.Level()
+	h.level.SetLevel(level)
+	// Logged at warn so the change is visible whatever the new level is.
+	h.logger.Warnf("log level changed from %s to %s by %s", previous, level, Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"level": level.String()})
+}
@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// maintenanceExempt lists endpoints that stay writable in maintenance mode:
+// the switch itself so it can be turned off again, and logins and token
+// refresh, so operators can sign in to do that. Other /api/auth/ writes
+// (password changes, key rotation, TOTP enrollment) are held back like any
+// other write, as is table maintenance under the switch's path.
+var maintenanceExempt = []string{
+	"/api/v1/admin/maintenance",
+	"/api/auth/login",
+	"/api/auth/refresh",
+}
+
+// maintenanceExemptPrefixes lists path prefixes that stay writable:
+// gateway/controller status reports (not config).
+var maintenanceExemptPrefixes = []string{
+	"/api/v1/status/",
+}
+
+// Maintenance rejects mutating requests with 503 while maintenance mode is on.
+// Reads, watches, and POSTs that only read (validate, lint) are unaffected. The flag is read from the store on every
+// write so all replicas agree without coordination.
+func Maintenance(s store.Store, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if slices.Contains(nonMutatingPOSTs, r.URL.Path) || slices.Contains(maintenanceExempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			for _, prefix := range maintenanceExemptPrefixes {
+				if strings.HasPrefix(r.URL.Path, prefix) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			m, err := s.GetMaintenance(r.Context())
+			if err != nil {
+				// Fail closed: the write would most likely fail anyway.
+				logger.Errorw("read maintenance state failed", "error", err)
+				ErrJSON(w, http.StatusServiceUnavailable, "maintenance state unavailable, try again later")
+				return
+			}
+			if m.Enabled {
+				msg := "hermes is in maintenance mode, writes are disabled"
+				if m.Message != "" {
+					msg += ": " + m.Message
+				}
+				ErrJSON(w, http.StatusServiceUnavailable, msg)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaintenanceHandler reads and toggles maintenance mode.
+type MaintenanceHandler struct {
+	store  store.Store
+	logger *zap.SugaredLogger
+}
+
+func NewMaintenanceHandler(s store.Store, logger *zap.SugaredLogger) *MaintenanceHandler {
+	return &MaintenanceHandler{store: s, logger: logger}
+}
+
+// GetMaintenance returns the current state so clients can show a banner.
+func (h *MaintenanceHandler) GetMaintenance(w http.ResponseWriter, r *http.Request) {
+	m, err := h.store.GetMaintenance(r.Context())
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	JSON(w, http.StatusOK, m)
+}
+
+// SetMaintenance switches maintenance mode on or off.
+func (h *MaintenanceHandler) SetMaintenance(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Enabled bool   `json:"enabled"`
+		Message string `json:"message"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	m := &store.Maintenance{Enabled: req.Enabled, Message: strings.TrimSpace(req.Message), UpdatedBy: Operator(r)}
+	if err := h.store.SetMaintenance(r.Context(), m); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	action := "disable"
+	if m.Enabled {
+		action = "enable"
+	}
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "maintenance", "maintenance", action, Operator(r))
+
+	h.logger.Infof("maintenance mode %sd by %s", action, m.UpdatedBy)
+	JSON(w, http.StatusOK, m)
+}
+
+// RunTableMaintenance refreshes planner statistics on the config tables and,
+// with ?reindex=true, rebuilds their indexes, which bulk imports leave
+// bloated: POST /api/v1/admin/maintenance/tables. It answers once done, or
+// 409 while another run is in progress. The run is not cancelled when the
+// client goes away, so an interrupted REINDEX never leaves invalid indexes.
+func (h *MaintenanceHandler) RunTableMaintenance(w http.ResponseWriter, r *http.Request) {
+	reindex := r.URL.Query().Get("reindex") == "true"
+
+	tables, err := h.store.MaintainTables(context.WithoutCancel(r.Context()), reindex)
+	if errors.Is(err, store.ErrMaintenanceRunning) {
+		ErrJSON(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		h.logger.Errorw("table maintenance failed", "reindex", reindex, "completed", len(tables), "error", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	action := "analyze"
+	if reindex {
+		action = "reindex"
+	}
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "maintenance", "tables", action, Operator(r))
+
+	h.logger.Infof("table maintenance (%s) run by %s", action, Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"reindex": reindex, "tables": tables})
+}
@@ -1,24 +1,41 @@
 package handler
 
 import (
-	"encoding/json"
+	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
 	"go.uber.org/zap"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// UserMailer emails builtin users about changes to their own account. It
+// must not block: delivery happens in the background.
+type UserMailer interface {
+	MailUser(to, subject, body string)
+}
+
+// mailUser sends through m when one is configured.
+func mailUser(m UserMailer, to, subject, body string) {
+	if m != nil && to != "" {
+		m.MailUser(to, subject, body)
+	}
+}
+
 // MemberHandler handles region member management and user admin APIs.
 type MemberHandler struct {
-	store  store.Store
-	logger *zap.SugaredLogger
+	store          store.Store
+	mailer         UserMailer
+	logger         *zap.SugaredLogger
+	passwordPolicy config.PasswordPolicyConfig
 }
 
-func NewMemberHandler(s store.Store, logger *zap.SugaredLogger) *MemberHandler {
-	return &MemberHandler{store: s, logger: logger}
+// NewMemberHandler returns a member handler. mailer may be nil.
+func NewMemberHandler(s store.Store, mailer UserMailer, logger *zap.SugaredLogger, passwordPolicy config.PasswordPolicyConfig) *MemberHandler {
+	return &MemberHandler{store: s, mailer: mailer, logger: logger, passwordPolicy: passwordPolicy}
 }
 
 // Region Members
@@ -45,7 +62,7 @@ func (h *MemberHandler) AddMember(w http.ResponseWriter, r *http.Request) {
 		UserSub string `json:"user_sub"`
 		Role    string `json:"role"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
@@ -58,8 +75,11 @@ func (h *MemberHandler) AddMember(w http.ResponseWriter, r *http.Request) {
 	}
 
 	role := store.RegionRole(req.Role)
-	if role != store.RoleOwner && role != store.RoleEditor && role != store.RoleViewer {
-		ErrJSON(w, http.StatusBadRequest, "role must be owner, editor, or viewer")
+	if ok, err := h.roleExists(r.Context(), region, role); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if !ok {
+		ErrJSON(w, http.StatusBadRequest, "role must be owner, editor, viewer, or a custom role defined in this region")
 		return
 	}
 
@@ -128,7 +148,7 @@ func (h *MemberHandler) SetGroupBinding(w http.ResponseWriter, r *http.Request)
 		Group string `json:"group"`
 		Role  string `json:"role"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
@@ -141,8 +161,11 @@ func (h *MemberHandler) SetGroupBinding(w http.ResponseWriter, r *http.Request)
 	}
 
 	role := store.RegionRole(req.Role)
-	if role != store.RoleOwner && role != store.RoleEditor && role != store.RoleViewer {
-		ErrJSON(w, http.StatusBadRequest, "role must be owner, editor, or viewer")
+	if ok, err := h.roleExists(r.Context(), region, role); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if !ok {
+		ErrJSON(w, http.StatusBadRequest, "role must be owner, editor, viewer, or a custom role defined in this region")
 		return
 	}
 
@@ -177,8 +200,13 @@ func (h *MemberHandler) RemoveGroupBinding(w http.ResponseWriter, r *http.Reques
 }
 
 // Users (admin-only)
-// ListUsers returns all known users.
+// ListUsers returns all known users, a page at a time with ?limit=.
 func (h *MemberHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	p, err := parsePage(r, 0)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
 	users, err := h.store.ListUsers(r.Context())
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -187,7 +215,8 @@ func (h *MemberHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
 	if users == nil {
 		users = []store.User{}
 	}
-	JSON(w, http.StatusOK, map[string]any{"users": users})
+	setPageHeaders(w, r, p, len(users))
+	JSON(w, http.StatusOK, map[string]any{"users": paginate(users, p)})
 }
 
 // SetAdmin toggles the admin flag for a user.
@@ -201,7 +230,7 @@ func (h *MemberHandler) SetAdmin(w http.ResponseWriter, r *http.Request) {
 	var req struct {
 		IsAdmin bool `json:"is_admin"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
@@ -234,7 +263,7 @@ func (h *MemberHandler) ForcePasswordChange(w http.ResponseWriter, r *http.Reque
 	var req struct {
 		MustChangePassword bool `json:"must_change_password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
@@ -250,11 +279,39 @@ func (h *MemberHandler) ForcePasswordChange(w http.ResponseWriter, r *http.Reque
 	action := "clear_force_password_change"
 	if req.MustChangePassword {
 		action = "force_password_change"
+		if user, err := h.store.GetUser(r.Context(), userSub); err == nil && user != nil && strings.HasPrefix(user.Sub, "builtin:") {
+			mailUser(h.mailer, user.Email, "Hermes: please change your password",
+				fmt.Sprintf("An administrator (%s) requires you to change your Hermes password.\n\n"+
+					"You will be asked to choose a new one the next time you sign in.\n", Operator(r)))
+		}
 	}
 	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", userSub, action, Operator(r))
 	JSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
+// RevokeSessions invalidates every token the user currently holds (admin
+// only): access tokens issued before now are rejected and refresh tokens
+// are revoked, so the user has to sign in again.
+func (h *MemberHandler) RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	userSub := r.PathValue("sub")
+	if userSub == "" {
+		ErrJSON(w, http.StatusBadRequest, "user sub is required")
+		return
+	}
+
+	cutoff, err := h.store.RevokeUserSessions(r.Context(), userSub)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			ErrJSON(w, http.StatusNotFound, err.Error())
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", userSub, "revoke_sessions", Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"ok": true, "sessions_valid_after": cutoff})
+}
+
 // CreateBuiltinUser creates a new builtin (email/password) user (admin only).
 // Only valid when auth_mode is "builtin".
 func (h *MemberHandler) CreateBuiltinUser(w http.ResponseWriter, r *http.Request) {
@@ -264,17 +321,17 @@ func (h *MemberHandler) CreateBuiltinUser(w http.ResponseWriter, r *http.Request
 		Name     string `json:"name"`
 		IsAdmin  bool   `json:"is_admin"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
-	req.Email = strings.TrimSpace(strings.ToLower(req.Email))
+	req.Email = normalizeEmail(req.Email)
 	if req.Email == "" || req.Password == "" {
 		ErrJSON(w, http.StatusBadRequest, "email and password are required")
 		return
 	}
-	if len(req.Password) < 6 {
-		ErrJSON(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if reason := ValidatePassword(req.Password, h.passwordPolicy); reason != "" {
+		ErrJSON(w, http.StatusBadRequest, reason)
 		return
 	}
 
@@ -316,11 +373,37 @@ func (h *MemberHandler) CreateBuiltinUser(w http.ResponseWriter, r *http.Request
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	recordPasswordHistory(r.Context(), h.store, h.logger, sub, string(hash), h.passwordPolicy)
 
 	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", sub, "create_builtin_user", Operator(r))
+	mailUser(h.mailer, req.Email, "Your Hermes account",
+		fmt.Sprintf("An administrator (%s) created a Hermes account for you.\n\n"+
+			"Email:              %s\nTemporary password: %s\n\n"+
+			"You will be asked to choose a new password when you first sign in.\n", Operator(r), req.Email, req.Password))
 	JSON(w, http.StatusCreated, map[string]any{"sub": sub, "email": req.Email})
 }
 
+// ResetUserTOTP removes TOTP from a builtin user (admin only), e.g. after a
+// lost device. The user can enroll again after their next login.
+func (h *MemberHandler) ResetUserTOTP(w http.ResponseWriter, r *http.Request) {
+	userSub := r.PathValue("sub")
+	if !strings.HasPrefix(userSub, "builtin:") {
+		ErrJSON(w, http.StatusBadRequest, "can only reset TOTP for builtin users")
+		return
+	}
+	if err := h.store.SetUserTOTP(r.Context(), userSub, "", false); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			ErrJSON(w, http.StatusNotFound, "user not found")
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", userSub, "reset_totp", Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
+
 // DeleteUser removes a user (admin only).
 func (h *MemberHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
 	userSub := r.PathValue("sub")
@@ -366,7 +449,7 @@ func (h *MemberHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request
 	var req struct {
 		NewPassword string `json:"new_password"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
@@ -374,8 +457,8 @@ func (h *MemberHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request
 		ErrJSON(w, http.StatusBadRequest, "new_password is required")
 		return
 	}
-	if len(req.NewPassword) < 6 {
-		ErrJSON(w, http.StatusBadRequest, "password must be at least 6 characters")
+	if reason := ValidatePassword(req.NewPassword, h.passwordPolicy); reason != "" {
+		ErrJSON(w, http.StatusBadRequest, reason)
 		return
 	}
 
@@ -389,6 +472,13 @@ func (h *MemberHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request
 		ErrJSON(w, http.StatusNotFound, "user not found")
 		return
 	}
+	if reused, err := passwordReused(r.Context(), h.store, userSub, req.NewPassword, h.passwordPolicy); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	} else if reused {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("password was used recently; choose one not among the last %d", h.passwordPolicy.HistorySize))
+		return
+	}
 
 	hash, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
 	if err != nil {
@@ -399,6 +489,7 @@ func (h *MemberHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	recordPasswordHistory(r.Context(), h.store, h.logger, userSub, string(hash), h.passwordPolicy)
 	// Force the user to change password on next login.
 	_ = h.store.SetMustChangePassword(r.Context(), userSub, true)
 
@@ -406,7 +497,8 @@ func (h *MemberHandler) ResetUserPassword(w http.ResponseWriter, r *http.Request
 	JSON(w, http.StatusOK, map[string]any{"ok": true})
 }
 
-// UpdateUser updates a builtin user's profile (email, name, is_admin) (admin only).
+// UpdateUser updates a builtin user's profile (email, name, is_admin) and
+// account status (enabled) (admin only).
 func (h *MemberHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	userSub := r.PathValue("sub")
 	if userSub == "" {
@@ -418,12 +510,21 @@ func (h *MemberHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 		Email   *string `json:"email"`
 		Name    *string `json:"name"`
 		IsAdmin *bool   `json:"is_admin"`
+		Enabled *bool   `json:"enabled"`
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+	if err := DecodeJSON(r, &req); err != nil {
 		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
 		return
 	}
 
+	// Prevent locking yourself out.
+	if req.Enabled != nil && !*req.Enabled {
+		if id := IdentityFromContext(r.Context()); id != nil && id.Subject == userSub {
+			ErrJSON(w, http.StatusBadRequest, "cannot disable yourself")
+			return
+		}
+	}
+
 	user, err := h.store.GetUser(r.Context(), userSub)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
@@ -435,7 +536,7 @@ func (h *MemberHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if req.Email != nil {
-		user.Email = strings.TrimSpace(strings.ToLower(*req.Email))
+		user.Email = normalizeEmail(*req.Email)
 	}
 	if req.Name != nil {
 		user.Name = strings.TrimSpace(*req.Name)
@@ -452,6 +553,17 @@ func (h *MemberHandler) UpdateUser(w http.ResponseWriter, r *http.Request) {
 	if req.IsAdmin != nil {
 		_ = h.store.SetUserAdmin(r.Context(), userSub, *req.IsAdmin)
 	}
+	if req.Enabled != nil && *req.Enabled != user.Enabled {
+		if err := h.store.SetUserEnabled(r.Context(), userSub, *req.Enabled); err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		action := "disable_user"
+		if *req.Enabled {
+			action = "enable_user"
+		}
+		_ = h.store.InsertAuditLog(r.Context(), "_global", "user", userSub, action, Operator(r))
+	}
 
 	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", userSub, "update_user", Operator(r))
 	JSON(w, http.StatusOK, map[string]any{"ok": true})
@@ -476,6 +588,16 @@ func (h *MemberHandler) WhoAmI(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if id.Source == "service_account" {
+		JSON(w, http.StatusOK, map[string]any{
+			"source":          "service_account",
+			"subject":         id.Subject,
+			"region":          id.Region,
+			"scopes":          id.Scopes,
+			"service_account": id.ServiceAccount.Name,
+		})
+		return
+	}
 
 	// OIDC user
 	claims := id.OIDCClaims
@@ -531,3 +653,115 @@ func (h *MemberHandler) WhoAmI(w http.ResponseWriter, r *http.Request) {
 		"scopes":      id.Scopes,
 	})
 }
+
+// ListRoles returns each builtin role and the current region's custom roles
+// with the scopes they grant, plus the implicit scopes of global admins,
+// straight from the mapping Authenticate uses.
+func (h *MemberHandler) ListRoles(w http.ResponseWriter, r *http.Request) {
+	type roleScopes struct {
+		Role    string   `json:"role"`
+		Scopes  []string `json:"scopes"`
+		Builtin bool     `json:"builtin"`
+	}
+	roles := make([]roleScopes, 0, len(store.AllRoles))
+	for _, role := range store.AllRoles {
+		roles = append(roles, roleScopes{Role: string(role), Scopes: store.RoleToScopes(role, false), Builtin: true})
+	}
+	custom, err := h.store.ListCustomRoles(r.Context(), RegionFromContext(r.Context()))
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, cr := range custom {
+		roles = append(roles, roleScopes{Role: cr.Name, Scopes: cr.Scopes})
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"roles":        roles,
+		"admin_scopes": store.RoleToScopes("", true),
+		"all_scopes":   store.AllScopes,
+	})
+}
+
+// Impersonate reports the roles and scopes a user would be granted right now,
+// using the same resolution as Authenticate (admin flag, direct membership,
+// group bindings, custom roles). It is read-only: no token is issued. Groups come from the
+// user's IdP token, which Hermes does not persist, so callers may supply them.
+func (h *MemberHandler) Impersonate(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Sub    string   `json:"sub"`
+		Groups []string `json:"groups"`
+		Region string   `json:"region"` // optional: limit to one region
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
+		return
+	}
+	if req.Sub == "" {
+		ErrJSON(w, http.StatusBadRequest, "sub is required")
+		return
+	}
+
+	user, err := h.store.GetUser(r.Context(), req.Sub)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if user == nil {
+		ErrJSON(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	regions := []string{req.Region}
+	if req.Region == "" {
+		if regions, err = h.store.ListRegions(r.Context()); err != nil {
+			ErrJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+
+	claims := &OIDCClaims{Sub: user.Sub, Groups: req.Groups}
+	type regionAccess struct {
+		Region     string   `json:"region"`
+		Role       string   `json:"role"`
+		DirectRole string   `json:"direct_role,omitempty"`
+		GroupRoles []string `json:"group_roles,omitempty"`
+		Scopes     []string `json:"scopes"`
+	}
+	access := make([]regionAccess, 0, len(regions))
+	for _, region := range regions {
+		ra := regionAccess{Region: region}
+		if user.IsAdmin {
+			ra.Role = "admin"
+			ra.Scopes = store.RoleToScopes("", true)
+		} else {
+			if member, _ := h.store.GetRegionMember(r.Context(), region, user.Sub); member != nil {
+				ra.DirectRole = string(member.Role)
+			}
+			if len(req.Groups) > 0 {
+				if groupRoles, err := h.store.GetRolesByGroups(r.Context(), region, req.Groups); err == nil {
+					for _, gr := range groupRoles {
+						ra.GroupRoles = append(ra.GroupRoles, string(gr))
+					}
+				}
+			}
+			ra.Role, ra.Scopes = resolveAccess(r.Context(), h.store, region, claims)
+		}
+		if ra.Scopes == nil {
+			ra.Scopes = []string{}
+		}
+		access = append(access, ra)
+	}
+
+	h.logger.Infof("impersonation: %s inspected effective permissions of %s", Operator(r), user.Sub)
+	_ = h.store.InsertAuditLog(r.Context(), "_global", "user", user.Sub, "impersonate", Operator(r))
+
+	JSON(w, http.StatusOK, map[string]any{
+		"sub":                  user.Sub,
+		"username":             user.Username,
+		"email":                user.Email,
+		"is_admin":             user.IsAdmin,
+		"must_change_password": user.MustChangePassword,
+		"groups":               req.Groups,
+		"regions":              access,
+	})
+}
@@ -6,15 +6,19 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"math"
 	"net/http"
 	"runtime/debug"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
 	"go.uber.org/zap"
@@ -32,23 +36,37 @@ var (
 	regionKey   = regionKeyType{}
 )
 
-// Identity: unified caller identity (OIDC user or HMAC credential)
+// Identity: unified caller identity (OIDC user, HMAC credential or service account)
 
 // Identity is the unified representation of "who is calling".
 // Populated by the Authenticate middleware regardless of auth method.
 type Identity struct {
-	// Subject identifies the caller: OIDC sub or credential access_key.
+	// Subject identifies the caller: OIDC sub, credential access_key or
+	// service account name.
 	Subject string
 	// Region the caller is operating in.
 	Region string
 	// Scopes the caller is authorized for.
 	Scopes []string
-	// Source distinguishes auth method: "oidc" or "hmac".
+	// Source distinguishes auth method: "oidc", "hmac" or "service_account".
 	Source string
 	// OIDCClaims is non-nil only for OIDC-authenticated users.
 	OIDCClaims *OIDCClaims
 	// Credential is non-nil only for HMAC-authenticated callers.
 	Credential *store.APICredential
+	// ServiceAccount is non-nil only for service-account bearer tokens.
+	ServiceAccount *store.ServiceAccount
+	// MustChangePassword is set for users flagged by an admin (or the seeded
+	// initial admin). Authenticate confines them to passwordChangeAllowedPaths.
+	MustChangePassword bool
+}
+
+// passwordChangeAllowedPaths are the only endpoints reachable by a user whose
+// password must be changed before normal API access is restored.
+var passwordChangeAllowedPaths = map[string]bool{
+	"/api/auth/change-password": true,
+	"/api/auth/userinfo":        true,
+	"/api/v1/whoami":            true,
 }
 
 // HasScope returns true if the identity has the given scope.
@@ -67,6 +85,15 @@ func IdentityFromContext(ctx context.Context) *Identity {
 	return id
 }
 
+// withIdentity attaches the authenticated identity to the request and records
+// its subject for the access log.
+func withIdentity(r *http.Request, identity *Identity) *http.Request {
+	if info := requestInfoFromContext(r.Context()); info != nil {
+		info.subject = identity.Subject
+	}
+	return r.WithContext(context.WithValue(r.Context(), identityKey, identity))
+}
+
 // RegionFromContext returns the region from the request context.
 func RegionFromContext(ctx context.Context) string {
 	region, _ := ctx.Value(regionKey).(string)
@@ -76,6 +103,14 @@ func RegionFromContext(ctx context.Context) string {
 	return region
 }
 
+// ReadYourWrites scopes read-your-writes tracking to a single request, so
+// that store reads following a write in the same request skip the replica.
+func ReadYourWrites(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(store.WithReadYourWrites(r.Context())))
+	})
+}
+
 // Region Middleware
 // RegionMiddleware extracts the region from the X-Hermes-Region header
 // (or ?region= query param for web UI) and injects it into context.
@@ -88,6 +123,23 @@ func RegionMiddleware(next http.Handler) http.Handler {
 		if region == "" {
 			region = store.DefaultRegion
 		}
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.region = region
+		}
+		ctx := context.WithValue(r.Context(), regionKey, region)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// PathRegion is RegionMiddleware for routes that address a region by its
+// {name} path value, so that scopes are resolved for that region rather
+// than the one in the header.
+func PathRegion(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		region := r.PathValue("name")
+		if info := requestInfoFromContext(r.Context()); info != nil {
+			info.region = region
+		}
 		ctx := context.WithValue(r.Context(), regionKey, region)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
@@ -97,6 +149,7 @@ func RegionMiddleware(next http.Handler) http.Handler {
 //
 // Authenticate inspects the Authorization header and resolves a unified Identity:
 //   - "Bearer <jwt>"       → OIDC path: verify JWT, resolve role→scopes
+//   - "Bearer hsa_..."     → service account: hash lookup, use account scopes
 //   - "HMAC-SHA256 ..."    → HMAC path: verify signature, use credential scopes
 //   - missing header       → 401 (unless HMAC bootstrap: no credentials in DB yet)
 
@@ -104,33 +157,60 @@ const maxTimestampSkew = 5 * time.Minute
 
 // Authenticate returns a middleware that resolves the caller's Identity.
 // It supports both OIDC Bearer tokens and HMAC-SHA256 signatures.
-func Authenticate(s store.Store, oidcVerifier OIDCVerifyFunc, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+// box opens sealed credential secret keys and may be nil.
+func Authenticate(s store.Store, box *secretbox.Box, oidcVerifier OIDCVerifyFunc, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			authHeader := r.Header.Get("Authorization")
 			region := RegionFromContext(r.Context())
 
 			switch {
+			case strings.HasPrefix(authHeader, "Bearer "+serviceAccountTokenPrefix):
+				// Service account bearer token
+				identity, err := authenticateServiceAccount(r.Context(), s, logger, authHeader)
+				if err != nil {
+					ErrJSON(w, http.StatusUnauthorized, err.Error())
+					return
+				}
+				if rejectForeignRegion(w, r, identity) {
+					return
+				}
+				next.ServeHTTP(w, withIdentity(r, identity))
+
 			case strings.HasPrefix(authHeader, "Bearer "):
 				// OIDC Bearer token
 				identity, err := authenticateOIDC(r.Context(), s, oidcVerifier, authHeader, region)
+				if errors.Is(err, errUserDisabled) {
+					ErrJSON(w, http.StatusForbidden, err.Error())
+					return
+				}
+				if errors.Is(err, errUserLookup) {
+					logger.Errorf("OIDC auth: %v", err)
+					ErrJSON(w, http.StatusServiceUnavailable, errUserLookup.Error())
+					return
+				}
 				if err != nil {
 					logger.Debugf("OIDC auth failed: %v", err)
 					ErrJSON(w, http.StatusUnauthorized, err.Error())
 					return
 				}
-				ctx := context.WithValue(r.Context(), identityKey, identity)
-				next.ServeHTTP(w, r.WithContext(ctx))
+				if identity.MustChangePassword && !passwordChangeAllowedPaths[r.URL.Path] {
+					ErrJSON(w, http.StatusForbidden, "password change required")
+					return
+				}
+				next.ServeHTTP(w, withIdentity(r, identity))
 
 			case strings.HasPrefix(authHeader, "HMAC-SHA256 "):
 				// HMAC credential
-				identity, err := authenticateHMAC(r, s, logger, region)
+				identity, err := authenticateHMAC(r, s, box, logger, region)
 				if err != nil {
 					ErrJSON(w, http.StatusUnauthorized, err.Error())
 					return
 				}
-				ctx := context.WithValue(r.Context(), identityKey, identity)
-				next.ServeHTTP(w, r.WithContext(ctx))
+				if rejectForeignRegion(w, r, identity) {
+					return
+				}
+				next.ServeHTTP(w, withIdentity(r, identity))
 
 			case authHeader == "":
 				// No auth header. Allow through only for HMAC bootstrap
@@ -145,6 +225,16 @@ func Authenticate(s store.Store, oidcVerifier OIDCVerifyFunc, logger *zap.Sugare
 					ErrJSON(w, http.StatusUnauthorized, "authentication required")
 					return
 				}
+				accounts, err := s.ListServiceAccounts(r.Context(), region)
+				if err != nil {
+					logger.Errorf("auth: list service accounts: %v", err)
+					ErrJSON(w, http.StatusInternalServerError, "auth check failed")
+					return
+				}
+				if len(accounts) > 0 {
+					ErrJSON(w, http.StatusUnauthorized, "authentication required")
+					return
+				}
 				// Bootstrap mode: no credentials, no identity, allow through.
 				next.ServeHTTP(w, r)
 
@@ -155,10 +245,34 @@ func Authenticate(s store.Store, oidcVerifier OIDCVerifyFunc, logger *zap.Sugare
 	}
 }
 
+// rejectForeignRegion answers 403 and returns true if a region-scoped
+// identity (credential or service account) is used against another region
+// than its own, e.g. by sending a different X-Hermes-Region header. Holders
+// of admin:users may act across regions. Routes that resolve no region
+// (nothing ran RegionMiddleware or PathRegion) are not checked.
+func rejectForeignRegion(w http.ResponseWriter, r *http.Request, id *Identity) bool {
+	region, ok := r.Context().Value(regionKey).(string)
+	if !ok || id.Region == region || id.HasScope(store.ScopeAdminUsers) {
+		return false
+	}
+	ErrJSON(w, http.StatusForbidden, fmt.Sprintf("%s belongs to region %q, not %q", id.Subject, id.Region, region))
+	return true
+}
+
 // OIDCVerifyFunc verifies a Bearer JWT and returns claims.
 // This is injected by the OIDCAuth setup so the middleware doesn't depend on config.
 type OIDCVerifyFunc func(tokenStr string) (*OIDCClaims, error)
 
+// errUserDisabled rejects a valid token whose user an admin has disabled.
+// Authenticate answers it with 403 rather than 401: the token is fine, the
+// account is not allowed in.
+var errUserDisabled = errors.New("account disabled")
+
+// errUserLookup means the user record backing a valid token could not be
+// read. Authenticate answers it with 503: letting the token through would
+// skip the disabled and revoked-session checks.
+var errUserLookup = errors.New("user lookup failed, try again later")
+
 func authenticateOIDC(ctx context.Context, s store.Store, verify OIDCVerifyFunc, authHeader, region string) (*Identity, error) {
 	if verify == nil {
 		return nil, fmt.Errorf("OIDC authentication not configured")
@@ -171,29 +285,43 @@ func authenticateOIDC(ctx context.Context, s store.Store, verify OIDCVerifyFunc,
 	}
 
 	// Resolve role → scopes.
-	isAdmin := false
+	isAdmin, mustChangePassword := false, false
 	user, err := s.GetUser(ctx, claims.Sub)
-	if err == nil && user != nil {
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errUserLookup, err)
+	}
+	if user != nil {
+		if !user.Enabled {
+			return nil, errUserDisabled
+		}
+		// Tokens issued before a session revocation are dead even if
+		// unexpired; a token without iat cannot prove otherwise. iat has
+		// whole seconds, so compare at that precision: a token issued in
+		// the same second as the revocation (e.g. a login right after it)
+		// stays valid.
+		if cutoff := user.SessionsValidAfter; cutoff != nil && claims.Iat < cutoff.Truncate(time.Second).Unix() {
+			return nil, fmt.Errorf("token revoked")
+		}
 		isAdmin = user.IsAdmin
+		mustChangePassword = user.MustChangePassword
 	}
 
-	var role store.RegionRole
+	scopes := store.RoleToScopes("", true)
 	if !isAdmin {
-		role = store.RegionRole(resolveEffectiveRole(ctx, s, region, claims))
+		_, scopes = resolveAccess(ctx, s, region, claims)
 	}
 
-	scopes := store.RoleToScopes(role, isAdmin)
-
 	return &Identity{
-		Subject:    claims.Sub,
-		Region:     region,
-		Scopes:     scopes,
-		Source:     "oidc",
-		OIDCClaims: claims,
+		Subject:            claims.Sub,
+		Region:             region,
+		Scopes:             scopes,
+		Source:             "oidc",
+		OIDCClaims:         claims,
+		MustChangePassword: mustChangePassword,
 	}, nil
 }
 
-func authenticateHMAC(r *http.Request, s store.Store, logger *zap.SugaredLogger, region string) (*Identity, error) {
+func authenticateHMAC(r *http.Request, s store.Store, box *secretbox.Box, logger *zap.SugaredLogger, region string) (*Identity, error) {
 	authHeader := r.Header.Get("Authorization")
 
 	ak, sig, err := parseHMACAuthHeader(authHeader)
@@ -228,7 +356,7 @@ func authenticateHMAC(r *http.Request, s store.Store, logger *zap.SugaredLogger,
 	}
 
 	// Read and verify body hash.
-	bodyBytes, err := io.ReadAll(io.LimitReader(r.Body, maxRequestBodySize+1))
+	bodyBytes, err := io.ReadAll(r.Body)
 	if err != nil {
 		return nil, fmt.Errorf("read body failed")
 	}
@@ -243,7 +371,17 @@ func authenticateHMAC(r *http.Request, s store.Store, logger *zap.SugaredLogger,
 
 	// Compute expected signature.
 	stringToSign := r.Method + "\n" + r.URL.Path + "\n" + tsStr + "\n" + bodyHash
-	expected := computeHMACSHA256(cred.SecretKey, stringToSign)
+	secretKey := cred.SecretKey
+	if secretbox.IsSealed(secretKey) {
+		plain, err := box.Open(secretKey)
+		if err != nil {
+			// Sealed under a master key this server does not have.
+			logger.Errorf("HMAC auth: open secret key of ak=%s: %v", ak, err)
+			return nil, fmt.Errorf("auth lookup failed")
+		}
+		secretKey = string(plain)
+	}
+	expected := computeHMACSHA256(secretKey, stringToSign)
 
 	if !hmac.Equal([]byte(sig), []byte(expected)) {
 		logger.Warnf("HMAC signature mismatch: path=%s ak=%s", r.URL.Path, ak)
@@ -259,26 +397,90 @@ func authenticateHMAC(r *http.Request, s store.Store, logger *zap.SugaredLogger,
 	}, nil
 }
 
-// resolveEffectiveRole returns the highest role for the user in the given region,
-// considering both direct membership and group bindings.
-func resolveEffectiveRole(ctx context.Context, s store.Store, region string, claims *OIDCClaims) string {
-	var role string
+// authenticateServiceAccount resolves a bearer token to its service account.
+// The account is bound to sa.Region: Authenticate passes the identity to
+// rejectForeignRegion before anything else sees it, so a token minted in one
+// region cannot authorize requests to another.
+func authenticateServiceAccount(ctx context.Context, s store.Store, logger *zap.SugaredLogger, authHeader string) (*Identity, error) {
+	token := strings.TrimPrefix(authHeader, "Bearer ")
+	sa, err := s.GetServiceAccountByTokenHash(ctx, sha256Hex([]byte(token)))
+	if err != nil {
+		logger.Errorf("service account auth: lookup: %v", err)
+		return nil, fmt.Errorf("auth lookup failed")
+	}
+	if sa == nil {
+		return nil, fmt.Errorf("invalid service account token")
+	}
+
+	return &Identity{
+		Subject:        "service-account:" + sa.Name,
+		Region:         sa.Region,
+		Scopes:         sa.Scopes,
+		Source:         "service_account",
+		ServiceAccount: sa,
+	}, nil
+}
+
+// resolveAccess returns the user's highest role in the given region and the
+// scopes they hold there, considering both direct membership and group
+// bindings. Scopes are the union over all of the user's roles: the builtin
+// roles nest, so for them this equals the highest role's scopes, while custom
+// roles contribute their own scope sets.
+func resolveAccess(ctx context.Context, s store.Store, region string, claims *OIDCClaims) (string, []string) {
+	var roles []store.RegionRole
 
 	member, _ := s.GetRegionMember(ctx, region, claims.Sub)
 	if member != nil {
-		role = string(member.Role)
+		roles = append(roles, member.Role)
 	}
 
 	if len(claims.Groups) > 0 {
-		groupRole, err := s.GetEffectiveRoleByGroups(ctx, region, claims.Groups)
-		if err == nil && groupRole != nil {
-			if role == "" || store.RolePriority(*groupRole) > store.RolePriority(store.RegionRole(role)) {
-				role = string(*groupRole)
-			}
+		groupRoles, err := s.GetRolesByGroups(ctx, region, claims.Groups)
+		if err == nil {
+			roles = append(roles, groupRoles...)
+		}
+	}
+
+	// Users nothing matched fall back to the region's default role, if it
+	// has opted into one.
+	if len(roles) == 0 {
+		if settings, err := s.GetRegionSettings(ctx, region); err == nil && settings != nil && settings.DefaultRole != "" {
+			roles = append(roles, settings.DefaultRole)
+		}
+	}
+
+	var role string
+	granted := make(map[string]bool)
+	for _, r := range roles {
+		if role == "" || store.RolePriority(r) > store.RolePriority(store.RegionRole(role)) {
+			role = string(r)
+		}
+		for _, sc := range roleScopes(ctx, s, region, r) {
+			granted[sc] = true
 		}
 	}
 
-	return role
+	var scopes []string
+	for _, sc := range store.AllScopes {
+		if granted[sc] {
+			scopes = append(scopes, sc)
+		}
+	}
+	return role, scopes
+}
+
+// roleScopes resolves a builtin or custom role to its scopes in region.
+// Unknown roles (e.g. a custom role deleted out from under a binding) grant
+// nothing.
+func roleScopes(ctx context.Context, s store.Store, region string, role store.RegionRole) []string {
+	if store.IsBuiltinRole(role) {
+		return store.RoleToScopes(role, false)
+	}
+	cr, err := s.GetCustomRole(ctx, region, string(role))
+	if err != nil || cr == nil {
+		return nil
+	}
+	return cr.Scopes
 }
 
 // Scope-based Authorization
@@ -305,18 +507,149 @@ func RequireScope(scope string) func(http.Handler) http.Handler {
 // Global Middleware
 // CORS wraps a handler with permissive CORS headers.
 func CORS(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-Hermes-Timestamp, X-Hermes-Body-SHA256, X-Hermes-Region")
-		w.Header().Set("Access-Control-Max-Age", "43200")
-
-		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusNoContent)
-			return
-		}
-		next.ServeHTTP(w, r)
-	})
+	return CORSWithOrigins(nil)(next)
+}
+
+// CORSWithOrigins is CORS restricted to the given origins: requests from
+// other origins get no Access-Control-Allow-Origin, so browsers block them.
+// "*" or an empty list allows any origin. The list may be replaced on config
+// reload.
+func CORSWithOrigins(origins *config.Dynamic[[]string]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			allowed := origins.Load()
+			if len(allowed) == 0 || slices.Contains(allowed, "*") {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Add("Vary", "Origin")
+				if origin := r.Header.Get("Origin"); origin != "" && slices.Contains(allowed, origin) {
+					w.Header().Set("Access-Control-Allow-Origin", origin)
+				}
+			}
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, PATCH, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-Hermes-Timestamp, X-Hermes-Body-SHA256, X-Hermes-Region, X-Request-Id, Idempotency-Key, X-Hermes-Strict-JSON, X-Hermes-Change-Reason, If-Match, traceparent")
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-Id, X-Total-Count, Link, Idempotent-Replayed, ETag")
+			w.Header().Set("Access-Control-Max-Age", "43200")
+
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// MaxBodySize rejects request bodies larger than limit bytes with 413. The
+// body is read up front so that every handler, however it decodes, sees
+// either the complete body or nothing.
+func MaxBodySize(limit int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tooLarge := fmt.Sprintf("request body exceeds %d bytes", limit)
+			if r.ContentLength > limit {
+				ErrJSON(w, http.StatusRequestEntityTooLarge, tooLarge)
+				return
+			}
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+			if err != nil {
+				var mbe *http.MaxBytesError
+				if errors.As(err, &mbe) {
+					ErrJSON(w, http.StatusRequestEntityTooLarge, tooLarge)
+					return
+				}
+				ErrJSON(w, http.StatusBadRequest, "read body failed")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// WriteTimeout replaces the server's write timeout for one route, so
+// responses held open longer than the server-wide limit (e.g. watches) are
+// not cut off. 0 removes the deadline. Every ResponseWriter wrapper between
+// the server and here must implement Unwrap.
+func WriteTimeout(d time.Duration, logger *zap.SugaredLogger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var deadline time.Time
+			if d > 0 {
+				deadline = time.Now().Add(d)
+			}
+			if err := http.NewResponseController(w).SetWriteDeadline(deadline); err != nil {
+				logger.Warnw("set write deadline failed", "path", r.URL.Path, "error", err)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// PathRequestTimeouts returns the per-path deadlines for RequestTimeout:
+// routes that legitimately run longer than t.Request.
+func PathRequestTimeouts(t config.TimeoutsConfig) map[string]time.Duration {
+	return map[string]time.Duration{
+		"/api/v1/config/watch":  t.WatchRequest,
+		"/api/v1/config/stream": t.StreamRequest,
+		// ANALYZE / REINDEX; the store serializes runs.
+		"/api/v1/admin/maintenance/tables": 0,
+	}
+}
+
+// RequestTimeout puts a deadline of d on each request's context, or of
+// perPath[path] for the paths listed there, so that store calls are
+// cancelled rather than left hanging on a slow database. A 5xx written
+// after the deadline passed is replaced by 504. 0 means no deadline.
+func RequestTimeout(d time.Duration, perPath map[string]time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			timeout := d
+			if t, ok := perPath[r.URL.Path]; ok {
+				timeout = t
+			}
+			if timeout <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(&timeoutWriter{ResponseWriter: w, ctx: ctx}, r.WithContext(ctx))
+		})
+	}
+}
+
+// timeoutWriter turns an error response caused by the request deadline
+// into a 504, dropping the handler's own body.
+type timeoutWriter struct {
+	http.ResponseWriter
+	ctx      context.Context
+	timedOut bool
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	if code >= 500 && errors.Is(tw.ctx.Err(), context.DeadlineExceeded) {
+		tw.timedOut = true
+		ErrJSON(tw.ResponseWriter, http.StatusGatewayTimeout, "request timed out")
+		return
+	}
+	tw.ResponseWriter.WriteHeader(code)
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	if tw.timedOut {
+		return len(b), nil
+	}
+	return tw.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.ResponseWriter
 }
 
 // Recovery catches panics and returns a 500 response.
@@ -324,7 +657,7 @@ func Recovery(logger *zap.SugaredLogger, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				logger.Errorf("panic recovered: %v\n%s", err, debug.Stack())
+				logger.Errorf("panic recovered: request_id=%s %v\n%s", RequestIDFromContext(r.Context()), err, debug.Stack())
 				ErrJSON(w, http.StatusInternalServerError, "internal server error")
 			}
 		}()
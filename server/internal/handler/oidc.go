@@ -3,10 +3,13 @@ package handler
 import (
 	"context"
 	"crypto"
+	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"expvar"
 	"fmt"
 	"io"
 	"math/big"
@@ -14,6 +17,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jizhuozhi/hermes/server/internal/config"
@@ -26,6 +30,7 @@ import (
 // OIDC Auth Handler (login / callback / userinfo / config)
 // oidcEndpoints holds the discovered OIDC provider endpoints.
 type oidcEndpoints struct {
+	Issuer                string `json:"issuer"`
 	AuthorizationEndpoint string `json:"authorization_endpoint"`
 	TokenEndpoint         string `json:"token_endpoint"`
 	JwksURI               string `json:"jwks_uri"`
@@ -102,12 +107,24 @@ func NewOIDCHandler(cfg config.OIDCConfig, s store.Store, logger *zap.SugaredLog
 	}, nil
 }
 
+// oidcAuthStateTTL bounds how long a user may take at the IdP between
+// Login and Callback before the pending login is discarded.
+const oidcAuthStateTTL = 10 * time.Minute
+
+// oidcStateCookie binds a pending login to the browser that started it.
+// Callback rejects a state that does not match this cookie, so an attacker
+// cannot make a victim complete a login the attacker initiated (login CSRF).
+const oidcStateCookie = "hermes_oidc_state"
+
 // JwksURI returns the discovered JWKS endpoint URL.
 func (h *OIDCHandler) JwksURI() string {
 	return h.endpoints.JwksURI
 }
 
 // Login redirects the user to the OIDC provider's authorization endpoint.
+// A PKCE (RFC 7636) code_verifier is generated per login and stored
+// server-side keyed by state; only its S256 challenge is sent to the IdP.
+// The optional ?redirect= path is remembered and returned by Callback.
 func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
 	scheme := "https"
 	if fwd := r.Header.Get("X-Forwarded-Proto"); fwd != "" {
@@ -117,15 +134,80 @@ func (h *OIDCHandler) Login(w http.ResponseWriter, r *http.Request) {
 	}
 	redirectURI := scheme + "://" + r.Host + "/auth/callback"
 
-	params := url.Values{
-		"response_type": {"code"},
-		"client_id":     {h.cfg.ClientID},
-		"redirect_uri":  {redirectURI},
-		"scope":         {"openid profile email"},
+	state, err := randomURLToken(16)
+	if err != nil {
+		h.logger.Errorf("generate OIDC state: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "login initialization failed")
+		return
 	}
+	verifier, err := randomURLToken(32)
+	if err != nil {
+		h.logger.Errorf("generate PKCE verifier: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "login initialization failed")
+		return
+	}
+	now := time.Now()
+	if err := h.store.CreateOIDCAuthState(r.Context(), &store.OIDCAuthState{
+		State:        state,
+		CodeVerifier: verifier,
+		RedirectPath: safeRedirectPath(r.URL.Query().Get("redirect")),
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(oidcAuthStateTTL),
+	}); err != nil {
+		h.logger.Errorf("store OIDC auth state: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "login initialization failed")
+		return
+	}
+
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {h.cfg.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(h.cfg.Scopes, " ")},
+		"state":                 {state},
+		"code_challenge":        {pkceChallengeS256(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    state,
+		Path:     "/api/auth",
+		MaxAge:   int(oidcAuthStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   scheme == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
 	http.Redirect(w, r, h.endpoints.AuthorizationEndpoint+"?"+params.Encode(), http.StatusFound)
 }
 
+// randomURLToken returns n random bytes encoded as unpadded base64url.
+// With n=32 the result is a 43-char string, a valid PKCE code_verifier.
+func randomURLToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// safeRedirectPath accepts only same-origin absolute paths so the post-login
+// redirect cannot be abused as an open redirect. Anything else becomes "/".
+func safeRedirectPath(p string) string {
+	if p == "" || p[0] != '/' || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") {
+		return "/"
+	}
+	if u, err := url.Parse(p); err != nil || u.Scheme != "" || u.Host != "" {
+		return "/"
+	}
+	return p
+}
+
+// pkceChallengeS256 derives the S256 code_challenge for a code_verifier.
+func pkceChallengeS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
 // Callback handles the OIDC provider redirect: exchanges the authorization code for tokens.
 func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
 	code := r.URL.Query().Get("code")
@@ -133,6 +215,29 @@ func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		ErrJSON(w, http.StatusBadRequest, "code is required")
 		return
 	}
+	state := r.URL.Query().Get("state")
+	if state == "" {
+		ErrJSON(w, http.StatusBadRequest, "state is required")
+		return
+	}
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(state)) != 1 {
+		ErrJSON(w, http.StatusBadRequest, "state mismatch")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/auth", MaxAge: -1, HttpOnly: true})
+
+	// Look up (and consume) the PKCE verifier generated in Login.
+	pending, err := h.store.ConsumeOIDCAuthState(r.Context(), state)
+	if err != nil {
+		h.logger.Errorf("load OIDC auth state: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "login state lookup failed")
+		return
+	}
+	if pending == nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid or expired login state")
+		return
+	}
 
 	// Reconstruct redirect_uri: must match exactly what was sent in Login.
 	scheme := "https"
@@ -149,6 +254,7 @@ func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		"client_secret": {h.cfg.ClientSecret},
 		"code":          {code},
 		"redirect_uri":  {redirectURI},
+		"code_verifier": {pending.CodeVerifier},
 	})
 	if err != nil {
 		h.logger.Errorf("OIDC token exchange failed: %v", err)
@@ -175,6 +281,7 @@ func (h *OIDCHandler) Callback(w http.ResponseWriter, r *http.Request) {
 		h.syncUser(r.Context(), accessToken)
 	}
 
+	tokenResp["redirect_path"] = pending.RedirectPath
 	JSON(w, http.StatusOK, tokenResp)
 }
 
@@ -190,17 +297,8 @@ func (h *OIDCHandler) syncUser(ctx context.Context, tokenStr string) {
 	if err != nil {
 		return
 	}
-	var claims struct {
-		Sub               string   `json:"sub"`
-		PreferredUsername string   `json:"preferred_username"`
-		Email             string   `json:"email"`
-		Name              string   `json:"name"`
-		Groups            []string `json:"groups"`
-	}
-	if json.Unmarshal(payload, &claims) != nil {
-		return
-	}
-	if claims.Sub == "" {
+	claims, err := mapClaims(payload, h.cfg.Claims)
+	if err != nil || claims.Sub == "" {
 		return
 	}
 
@@ -306,6 +404,7 @@ type OIDCClaims struct {
 	Name              string   `json:"name,omitempty"`
 	Groups            []string `json:"groups,omitempty"`
 	Exp               int64    `json:"exp,omitempty"`
+	Iat               int64    `json:"iat,omitempty"`
 }
 
 // OIDCClaimsFromContext returns OIDC claims from the request context.
@@ -319,15 +418,88 @@ func OIDCClaimsFromContext(ctx context.Context) *OIDCClaims {
 }
 
 // NewOIDCVerifier creates an OIDCVerifyFunc from the OIDC config and JWKS URI.
-func NewOIDCVerifier(cfg config.OIDCConfig, jwksURI string) OIDCVerifyFunc {
+// The signing keys are refreshed in the background until ctx is cancelled,
+// and on demand (rate-limited) when a token carries an unknown kid.
+func NewOIDCVerifier(ctx context.Context, cfg config.OIDCConfig, jwksURI string, logger *zap.SugaredLogger) OIDCVerifyFunc {
 	cache := newJWKSCache(jwksURI)
+	if err := cache.refreshOnce(true); err != nil {
+		// Not fatal: the first verification retries.
+		logger.Warnf("initial JWKS fetch failed: %v", err)
+	}
+	go cache.run(ctx, jwksRefreshInterval, logger)
 	return func(tokenStr string) (*OIDCClaims, error) {
-		return verifyJWT(tokenStr, cache, cfg.ClientID)
+		return verifyJWT(tokenStr, cache, cfg.ClientID, cfg.Claims)
+	}
+}
+
+// mapClaims builds OIDCClaims from a JWT payload using the configured claim
+// names for username, email, name and groups. sub and exp are always read
+// from their standard claims.
+func mapClaims(payload []byte, m config.OIDCClaimsConfig) (*OIDCClaims, error) {
+	var raw map[string]any
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, err
 	}
+	c := &OIDCClaims{
+		PreferredUsername: claimString(raw, m.Username),
+		Email:             claimString(raw, m.Email),
+		Name:              claimString(raw, m.Name),
+		Groups:            claimStrings(raw, m.Groups),
+	}
+	c.Sub, _ = raw["sub"].(string)
+	if exp, ok := raw["exp"].(float64); ok {
+		c.Exp = int64(exp)
+	}
+	if iat, ok := raw["iat"].(float64); ok {
+		c.Iat = int64(iat)
+	}
+	return c, nil
+}
+
+// lookupClaim resolves a claim name, treating dots as nested object access
+// unless a top-level claim with the literal dotted name exists.
+func lookupClaim(raw map[string]any, name string) any {
+	if name == "" {
+		return nil
+	}
+	if v, ok := raw[name]; ok {
+		return v
+	}
+	var cur any = raw
+	for _, part := range strings.Split(name, ".") {
+		obj, ok := cur.(map[string]any)
+		if !ok {
+			return nil
+		}
+		cur = obj[part]
+	}
+	return cur
+}
+
+func claimString(raw map[string]any, name string) string {
+	s, _ := lookupClaim(raw, name).(string)
+	return s
+}
+
+// claimStrings accepts either a string array or a single string claim.
+func claimStrings(raw map[string]any, name string) []string {
+	switch v := lookupClaim(raw, name).(type) {
+	case string:
+		return []string{v}
+	case []any:
+		out := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
 }
 
 // JWT Verification
-func verifyJWT(tokenStr string, cache *jwksCache, expectedAudience string) (*OIDCClaims, error) {
+func verifyJWT(tokenStr string, cache *jwksCache, expectedAudience string, mapping config.OIDCClaimsConfig) (*OIDCClaims, error) {
 	parts := strings.SplitN(tokenStr, ".", 3)
 	if len(parts) != 3 {
 		return nil, fmt.Errorf("malformed JWT")
@@ -413,40 +585,74 @@ func verifyJWT(tokenStr string, cache *jwksCache, expectedAudience string) (*OID
 		return nil, fmt.Errorf("audience mismatch")
 	}
 
-	return &rawClaims.OIDCClaims, nil
+	return mapClaims(claimsBytes, mapping)
 }
 
 // JWKS Cache
+const (
+	// jwksRefreshInterval is how often the key set is re-fetched in the
+	// background so IdP key rotations are picked up without a restart.
+	jwksRefreshInterval = 5 * time.Minute
+	// jwksMinRefreshInterval rate-limits on-demand refreshes triggered by an
+	// unknown kid, so a flood of bogus tokens cannot hammer the IdP.
+	jwksMinRefreshInterval = 30 * time.Second
+)
+
+// JWKS metrics, exported via expvar (GET /debug/vars).
+var (
+	jwksRefreshFailures = expvar.NewInt("hermes_oidc_jwks_refresh_failures_total")
+	jwksLastRefresh     atomic.Int64 // unix nanos of the last successful refresh
+)
+
+func init() {
+	expvar.Publish("hermes_oidc_jwks_cache_age_seconds", expvar.Func(func() any {
+		last := jwksLastRefresh.Load()
+		if last == 0 {
+			return -1
+		}
+		return time.Since(time.Unix(0, last)).Seconds()
+	}))
+}
+
 type jwksCache struct {
-	url     string
-	mu      sync.RWMutex
-	keys    map[string]*rsa.PublicKey
-	fetched time.Time
-	sf      singleflight.Group
+	url         string
+	minInterval time.Duration
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastAttempt time.Time
+	sf          singleflight.Group
 }
 
 func newJWKSCache(url string) *jwksCache {
-	return &jwksCache{url: url, keys: make(map[string]*rsa.PublicKey)}
+	return &jwksCache{url: url, minInterval: jwksMinRefreshInterval, keys: make(map[string]*rsa.PublicKey)}
+}
+
+// run refreshes the key set every interval until ctx is done.
+func (c *jwksCache) run(ctx context.Context, interval time.Duration, logger *zap.SugaredLogger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.refreshOnce(true); err != nil {
+				logger.Warnf("JWKS background refresh failed: %v", err)
+			}
+		}
+	}
 }
 
 func (c *jwksCache) getKey(kid string) (*rsa.PublicKey, error) {
 	c.mu.RLock()
 	key, ok := c.keys[kid]
-	age := time.Since(c.fetched)
 	c.mu.RUnlock()
-
-	if ok && age < 5*time.Minute {
+	if ok {
 		return key, nil
 	}
 
-	// Use singleflight to coalesce concurrent refresh calls.
-	_, err, _ := c.sf.Do("refresh", func() (any, error) {
-		return nil, c.refresh()
-	})
-	if err != nil {
-		if ok {
-			return key, nil
-		}
+	// Unknown kid: the IdP may have rotated keys since the last refresh.
+	if err := c.refreshOnce(false); err != nil {
 		return nil, err
 	}
 
@@ -459,6 +665,27 @@ func (c *jwksCache) getKey(kid string) (*rsa.PublicKey, error) {
 	return key, nil
 }
 
+// refreshOnce coalesces concurrent refreshes via singleflight. Unless force
+// is set, it is a no-op when the previous attempt was within minInterval.
+func (c *jwksCache) refreshOnce(force bool) error {
+	_, err, _ := c.sf.Do("refresh", func() (any, error) {
+		c.mu.Lock()
+		if !force && time.Since(c.lastAttempt) < c.minInterval {
+			c.mu.Unlock()
+			return nil, nil
+		}
+		c.lastAttempt = time.Now()
+		c.mu.Unlock()
+
+		if err := c.refresh(); err != nil {
+			jwksRefreshFailures.Add(1)
+			return nil, err
+		}
+		return nil, nil
+	})
+	return err
+}
+
 func (c *jwksCache) refresh() error {
 	client := &http.Client{Timeout: 10 * time.Second}
 	resp, err := client.Get(c.url)
@@ -507,7 +734,7 @@ func (c *jwksCache) refresh() error {
 
 	c.mu.Lock()
 	c.keys = newKeys
-	c.fetched = time.Now()
 	c.mu.Unlock()
+	jwksLastRefresh.Store(time.Now().UnixNano())
 	return nil
 }
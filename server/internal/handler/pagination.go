@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// page is a limit/offset window over a list. A zero limit means the whole
+// list.
+type page struct {
+	limit  int
+	offset int
+}
+
+// parsePage reads ?limit= and ?offset=. defaultLimit applies when no limit
+// is given; 0 keeps the endpoint unpaginated by default.
+func parsePage(r *http.Request, defaultLimit int) (page, error) {
+	p := page{limit: defaultLimit}
+	q := r.URL.Query()
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return page{}, fmt.Errorf("limit must be a positive integer")
+		}
+		p.limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page{}, fmt.Errorf("offset must be a non-negative integer")
+		}
+		p.offset = n
+	}
+	return p, nil
+}
+
+// paginate returns the window of items selected by p.
+func paginate[T any](items []T, p page) []T {
+	if p.offset >= len(items) {
+		return items[:0]
+	}
+	items = items[p.offset:]
+	if p.limit > 0 && p.limit < len(items) {
+		items = items[:p.limit]
+	}
+	return items
+}
+
+// setPageHeaders sets X-Total-Count and, for a limited page, an RFC 8288
+// Link header with first/prev/next/last relations. The links keep the
+// request's other query parameters, so filters carry over between pages.
+// Call before writing the body.
+func setPageHeaders(w http.ResponseWriter, r *http.Request, p page, total int) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	if p.limit <= 0 {
+		return
+	}
+
+	link := func(offset int, rel string) string {
+		q := r.URL.Query()
+		q.Set("limit", strconv.Itoa(p.limit))
+		q.Set("offset", strconv.Itoa(offset))
+		return fmt.Sprintf(`<%s?%s>; rel="%s"`, r.URL.Path, q.Encode(), rel)
+	}
+	last := 0
+	if total > 0 {
+		last = (total - 1) / p.limit * p.limit
+	}
+	links := []string{link(0, "first")}
+	if p.offset > 0 {
+		links = append(links, link(max(p.offset-p.limit, 0), "prev"))
+	}
+	if p.offset+p.limit < total {
+		links = append(links, link(p.offset+p.limit, "next"))
+	}
+	links = append(links, link(last, "last"))
+	w.Header().Set("Link", strings.Join(links, ", "))
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password policy for builtin users
+
+// commonPasswordsFile is a small deny-list of frequently used passwords.
+//
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+// commonPasswords is the embedded deny-list, lower-cased.
+var commonPasswords = func() map[string]bool {
+	m := make(map[string]bool)
+	for _, line := range strings.Split(commonPasswordsFile, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			m[strings.ToLower(line)] = true
+		}
+	}
+	return m
+}()
+
+// ValidatePassword checks a candidate password against the policy.
+// Returns an empty string if valid, or a human-readable reason otherwise.
+func ValidatePassword(password string, policy config.PasswordPolicyConfig) string {
+	if n := len([]rune(password)); n < policy.MinLength {
+		return fmt.Sprintf("password must be at least %d characters", policy.MinLength)
+	}
+	if policy.MinCharClasses > 1 {
+		var lower, upper, digit, symbol bool
+		for _, c := range password {
+			switch {
+			case unicode.IsLower(c):
+				lower = true
+			case unicode.IsUpper(c):
+				upper = true
+			case unicode.IsDigit(c):
+				digit = true
+			default:
+				symbol = true
+			}
+		}
+		classes := 0
+		for _, ok := range []bool{lower, upper, digit, symbol} {
+			if ok {
+				classes++
+			}
+		}
+		if classes < policy.MinCharClasses {
+			return fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols", policy.MinCharClasses)
+		}
+	}
+	if policy.RejectCommon && commonPasswords[strings.ToLower(password)] {
+		return "password is too common"
+	}
+	return ""
+}
+
+// passwordReused reports whether password matches the user's current password
+// or any of their last policy.HistorySize passwords.
+func passwordReused(ctx context.Context, s store.Store, sub, password string, policy config.PasswordPolicyConfig) (bool, error) {
+	if policy.HistorySize <= 0 {
+		return false, nil
+	}
+	current, err := s.GetUserPasswordHash(ctx, sub)
+	if err != nil {
+		return false, err
+	}
+	history, err := s.ListPasswordHistory(ctx, sub, policy.HistorySize)
+	if err != nil {
+		return false, err
+	}
+	for _, h := range append(history, current) {
+		if h != "" && bcrypt.CompareHashAndPassword([]byte(h), []byte(password)) == nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// recordPasswordHistory remembers a newly set hash for reuse checks.
+// Failures are logged, not returned: the password change itself succeeded.
+func recordPasswordHistory(ctx context.Context, s store.Store, logger *zap.SugaredLogger, sub, hash string, policy config.PasswordPolicyConfig) {
+	if policy.HistorySize <= 0 {
+		return
+	}
+	if err := s.AddPasswordHistory(ctx, sub, hash, policy.HistorySize); err != nil {
+		logger.Warnf("record password history for %s: %v", sub, err)
+	}
+}
@@ -0,0 +1,105 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// quotas enforces per-region resource limits: the region's own setting when
+// present, else the server default. A limit of 0 means unlimited, as is a nil
+// defaults.
+type quotas struct {
+	store    store.Store
+	defaults *config.Dynamic[config.QuotaConfig] // replaced on config reload
+}
+
+// limit returns the effective limit of kind ("domain" or "cluster") in region.
+func (q quotas) limit(ctx context.Context, region, kind string) (int, error) {
+	defaults := q.defaults.Load()
+	limit := defaults.MaxDomains
+	if kind == "cluster" {
+		limit = defaults.MaxClusters
+	}
+	settings, err := q.store.GetRegionSettings(ctx, region)
+	if err != nil || settings == nil {
+		return limit, err
+	}
+	override := settings.MaxDomains
+	if kind == "cluster" {
+		override = settings.MaxClusters
+	}
+	if override != nil {
+		limit = *override
+	}
+	return limit, nil
+}
+
+// rejectCreate writes a 403 and returns true if creating one more resource
+// of kind would take region over its limit.
+func (q quotas) rejectCreate(w http.ResponseWriter, r *http.Request, region, kind string) bool {
+	return q.reject(w, r, region, kind, func(current int) int { return current + 1 })
+}
+
+// rejectReplace is rejectCreate for a bulk replace leaving total resources.
+func (q quotas) rejectReplace(w http.ResponseWriter, r *http.Request, region, kind string, total int) bool {
+	return q.reject(w, r, region, kind, func(int) int { return total })
+}
+
+// checkCreate is rejectCreate for callers without a request, such as the
+// scheduler: it returns the quota error instead of writing it.
+func (q quotas) checkCreate(ctx context.Context, region, kind string) error {
+	msg, err := q.exceeded(ctx, region, kind, func(current int) int { return current + 1 })
+	if err != nil {
+		return err
+	}
+	if msg != "" {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// reject checks the count a write would leave (next, given the current
+// count) and writes a 403 if it is over the limit.
+func (q quotas) reject(w http.ResponseWriter, r *http.Request, region, kind string, next func(current int) int) bool {
+	msg, err := q.exceeded(r.Context(), region, kind, next)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return true
+	}
+	if msg == "" {
+		return false
+	}
+	ErrJSON(w, http.StatusForbidden, msg)
+	return true
+}
+
+// exceeded returns why the count a write would leave is over region's limit
+// of kind, or "" if it is within it. Writes that don't grow the region pass
+// even when it is already over its limit, so lowering a quota never blocks
+// updates.
+func (q quotas) exceeded(ctx context.Context, region, kind string, next func(current int) int) (string, error) {
+	limit, err := q.limit(ctx, region, kind)
+	if err != nil {
+		return "", err
+	}
+	if limit == 0 {
+		return "", nil
+	}
+	count := q.store.CountDomains
+	if kind == "cluster" {
+		count = q.store.CountClusters
+	}
+	current, err := count(ctx, region)
+	if err != nil {
+		return "", err
+	}
+	if n := next(current); n <= limit || n <= current {
+		return "", nil
+	}
+	return fmt.Sprintf("%s quota exceeded: region %q allows at most %d %ss", kind, region, limit, kind), nil
+}
@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// nonMutatingPOSTs lists POST endpoints that only read: they take a config
+// in the body and write nothing, so neither read-only nor maintenance mode
+// holds them back.
+var nonMutatingPOSTs = []string{
+	"/api/v1/config/validate",
+	"/api/v1/config/lint",
+}
+
+// readOnlyExempt lists the other endpoints that keep working in read-only
+// mode: logins, without which nobody could sign in to read anything.
+var readOnlyExempt = []string{
+	"/api/auth/login",
+	"/api/auth/refresh",
+}
+
+// ReadOnly rejects mutating requests with 503 while server.read_only is set,
+// before they reach a database that cannot take them. Unlike maintenance
+// mode it is local config rather than store state, so it works when the
+// primary is unavailable. Logins and token refreshes are let through, and
+// fail on their own only if a write they need (such as storing a rotated
+// refresh token) cannot be made. Status reports are rejected, since they
+// write.
+func ReadOnly(enabled *config.Dynamic[bool]) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !enabled.Load() {
+				next.ServeHTTP(w, r)
+				return
+			}
+			switch r.Method {
+			case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+			default:
+				next.ServeHTTP(w, r)
+				return
+			}
+			if slices.Contains(nonMutatingPOSTs, r.URL.Path) || slices.Contains(readOnlyExempt, r.URL.Path) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ErrJSON(w, http.StatusServiceUnavailable, "hermes is in read-only mode, writes are disabled")
+		})
+	}
+}
+
+// Ready serves GET /readyz. It returns 503 when the database is unreachable.
+// In read-only mode it still returns 200, so the instance keeps serving
+// reads, but reports status "degraded".
+func Ready(s store.Store, readOnly *config.Dynamic[bool]) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.Ping(r.Context()); err != nil {
+			JSON(w, http.StatusServiceUnavailable, map[string]any{"status": "unavailable", "error": err.Error()})
+			return
+		}
+		if readOnly.Load() {
+			JSON(w, http.StatusOK, map[string]any{"status": "degraded", "read_only": true})
+			return
+		}
+		JSON(w, http.StatusOK, map[string]any{"status": "ready", "read_only": false})
+	}
+}
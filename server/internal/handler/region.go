@@ -0,0 +1,147 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// RegionHandler manages per-region settings. Routes address the region by
+// path rather than by the region header, since admins edit other regions.
+type RegionHandler struct {
+	store  store.Store
+	logger *zap.SugaredLogger
+	quotas quotas
+}
+
+func NewRegionHandler(s store.Store, logger *zap.SugaredLogger, quota *config.Dynamic[config.QuotaConfig]) *RegionHandler {
+	return &RegionHandler{store: s, logger: logger, quotas: quotas{store: s, defaults: quota}}
+}
+
+// GetRegionSettings returns the region's overrides together with the
+// effective limits and current usage.
+func (h *RegionHandler) GetRegionSettings(w http.ResponseWriter, r *http.Request) {
+	region := r.PathValue("name")
+
+	settings, err := h.store.GetRegionSettings(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if settings == nil {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("region %q not found", region))
+		return
+	}
+	h.writeSettings(w, r, region, settings)
+}
+
+// PutRegionSettings replaces the region's settings. Unknown keys are
+// rejected. Omitted limits fall back to the server defaults. Lowering a
+// limit below current usage is allowed: it only blocks further growth.
+// Region owners may change every setting but the limits, which take
+// admin:users so that owners cannot lift their own quotas.
+func (h *RegionHandler) PutRegionSettings(w http.ResponseWriter, r *http.Request) {
+	region := r.PathValue("name")
+
+	var settings store.RegionSettings
+	if err := decodeJSONStrict(r, &settings); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if settings.MaxDomains != nil && *settings.MaxDomains < 0 {
+		ErrJSON(w, http.StatusBadRequest, "max_domains must be >= 0")
+		return
+	}
+	if settings.MaxClusters != nil && *settings.MaxClusters < 0 {
+		ErrJSON(w, http.StatusBadRequest, "max_clusters must be >= 0")
+		return
+	}
+	if errs := model.ValidateOwnership(settings.DefaultOwner, settings.DefaultContact, "settings"); len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+		return
+	}
+	if role := settings.DefaultRole; role != "" {
+		// Owner would hand every signed-in user control of the region.
+		if role == store.RoleOwner {
+			ErrJSON(w, http.StatusBadRequest, "default_role cannot be owner")
+			return
+		}
+		if !store.IsBuiltinRole(role) {
+			cr, err := h.store.GetCustomRole(r.Context(), region, string(role))
+			if err != nil {
+				ErrJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if cr == nil {
+				ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("default_role %q is not a builtin or custom role of region %q", role, region))
+				return
+			}
+		}
+	}
+
+	existing, err := h.store.GetRegionSettings(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if existing == nil {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("region %q not found", region))
+		return
+	}
+	if id := IdentityFromContext(r.Context()); id != nil && !id.HasScope(store.ScopeAdminUsers) &&
+		(!limitEqual(settings.MaxDomains, existing.MaxDomains) || !limitEqual(settings.MaxClusters, existing.MaxClusters)) {
+		ErrJSON(w, http.StatusForbidden, "max_domains and max_clusters can only be changed by an admin")
+		return
+	}
+
+	if err := h.store.PutRegionSettings(r.Context(), region, &settings); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "region_settings", region, "update", Operator(r))
+
+	h.logger.Infof("region settings updated: %s", region)
+	h.writeSettings(w, r, region, &settings)
+}
+
+// limitEqual reports whether two optional limits are the same override.
+func limitEqual(a, b *int) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func (h *RegionHandler) writeSettings(w http.ResponseWriter, r *http.Request, region string, settings *store.RegionSettings) {
+	maxDomains, err := h.quotas.limit(r.Context(), region, "domain")
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	maxClusters, err := h.quotas.limit(r.Context(), region, "cluster")
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	domains, err := h.store.CountDomains(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	clusters, err := h.store.CountClusters(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	JSON(w, http.StatusOK, map[string]any{
+		"settings":  settings,
+		"effective": map[string]int{"max_domains": maxDomains, "max_clusters": maxClusters},
+		"usage":     map[string]int{"domains": domains, "clusters": clusters},
+	})
+}
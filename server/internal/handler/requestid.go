@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// RequestIDHeader carries the request ID on requests and responses.
+const RequestIDHeader = "X-Request-Id"
+
+// maxRequestIDLen bounds client-supplied request IDs.
+const maxRequestIDLen = 128
+
+type requestIDKeyType struct{}
+type traceIDKeyType struct{}
+
+var (
+	requestIDKey = requestIDKeyType{}
+	traceIDKey   = traceIDKeyType{}
+)
+
+// RequestIDFromContext returns the request ID set by RequestID, or "".
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// TraceIDFromContext returns the W3C trace ID from the request's traceparent
+// header, or "" if it had none.
+func TraceIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(traceIDKey).(string)
+	return id
+}
+
+// RequestID assigns every request an ID: the client's X-Request-Id if it is
+// well formed, else the trace ID of a valid W3C traceparent, else a random
+// one. The ID is stored in the context and echoed in the response header,
+// from where ErrJSON copies it into error bodies.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		traceID := parseTraceparent(r.Header.Get("traceparent"))
+		if traceID != "" {
+			ctx = context.WithValue(ctx, traceIDKey, traceID)
+		}
+
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = traceID
+		}
+		if id == "" {
+			id = newRequestID()
+		}
+		ctx = context.WithValue(ctx, requestIDKey, id)
+
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID accepts short IDs of printable ASCII without spaces, so a
+// client-chosen ID can't inject into log lines or headers.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseTraceparent returns the trace ID of a W3C traceparent header
+// ("00-<32 hex trace-id>-<16 hex parent-id>-<2 hex flags>"), or "" if the
+// header is missing or malformed.
+func parseTraceparent(h string) string {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || !isLowerHex(parts[0], 2) || parts[0] == "ff" {
+		return ""
+	}
+	// Version 00 has exactly four fields; later versions may append more.
+	if parts[0] == "00" && len(parts) != 4 {
+		return ""
+	}
+	traceID, parentID := parts[1], parts[2]
+	if !isLowerHex(traceID, 32) || !isLowerHex(parentID, 16) || !isLowerHex(parts[3], 2) {
+		return ""
+	}
+	if strings.Trim(traceID, "0") == "" || strings.Trim(parentID, "0") == "" {
+		return ""
+	}
+	return traceID
+}
+
+func isLowerHex(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// restoreChanges is what a restore does to one kind of resource, by name.
+type restoreChanges struct {
+	Create []string `json:"create"`
+	Update []string `json:"update"`
+	Delete []string `json:"delete"`
+}
+
+// diffResources compares the current and target resources, keyed by name
+// and compared as canonical JSON.
+func diffResources(current, target map[string]any) restoreChanges {
+	c := restoreChanges{Create: []string{}, Update: []string{}, Delete: []string{}}
+	for name, t := range target {
+		cur, ok := current[name]
+		switch {
+		case !ok:
+			c.Create = append(c.Create, name)
+		case canonicalJSON(cur) != canonicalJSON(t):
+			c.Update = append(c.Update, name)
+		}
+	}
+	for name := range current {
+		if _, ok := target[name]; !ok {
+			c.Delete = append(c.Delete, name)
+		}
+	}
+	sort.Strings(c.Create)
+	sort.Strings(c.Update)
+	sort.Strings(c.Delete)
+	return c
+}
+
+func diffConfigs(current, target *model.GatewayConfig) map[string]restoreChanges {
+	byName := func(cfg *model.GatewayConfig) (domains, clusters map[string]any) {
+		domains, clusters = make(map[string]any), make(map[string]any)
+		for _, d := range cfg.Domains {
+			domains[d.Name] = d
+		}
+		for _, c := range cfg.Clusters {
+			clusters[c.Name] = c
+		}
+		return domains, clusters
+	}
+	curDomains, curClusters := byName(current)
+	tgtDomains, tgtClusters := byName(target)
+	return map[string]restoreChanges{
+		"domains":  diffResources(curDomains, tgtDomains),
+		"clusters": diffResources(curClusters, tgtClusters),
+	}
+}
+
+// RestoreConfig puts the region's config back to how it stood at
+// ?revision=, replayed from change_log, and applies it as a full import.
+// With ?dry_run=true it only reports what would change.
+func (h *RouteHandler) RestoreConfig(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	revision, err := strconv.ParseInt(r.URL.Query().Get("revision"), 10, 64)
+	if err != nil || revision < 1 {
+		ErrJSON(w, http.StatusBadRequest, "revision must be a positive integer")
+		return
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "true"
+
+	current, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if revision > current {
+		ErrJSON(w, http.StatusBadRequest, "revision is ahead of the region's current revision "+strconv.FormatInt(current, 10))
+		return
+	}
+	target, err := h.store.ConfigAtRevision(r.Context(), region, revision)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	live, err := h.store.GetConfig(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	model.NormalizeConfigHosts(target)
+	changes := diffConfigs(live, target)
+
+	// Validation rules may have tightened since the revision was written.
+	if errs := model.ValidateConfig(target); len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs, "changes": changes})
+		return
+	}
+	if _, ok := h.rules.checkConfig(w, region, target); !ok {
+		return
+	}
+	if rejectMissingSecrets(w, r, h.store, region, target.Domains...) {
+		return
+	}
+	if dryRun {
+		JSON(w, http.StatusOK, map[string]any{"revision": revision, "resource_version": current, "dry_run": true, "changes": changes})
+		return
+	}
+
+	if h.quotas.rejectReplace(w, r, region, "domain", len(target.Domains)) ||
+		h.quotas.rejectReplace(w, r, region, "cluster", len(target.Clusters)) {
+		return
+	}
+	rev, err := h.store.PutAllConfig(r.Context(), region, target.Domains, target.Clusters, Operator(r), current)
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, "conflict: the region config changed during the restore, please try again")
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "config", strconv.FormatInt(revision, 10), "restore", Operator(r))
+
+	h.logger.Infof("config restored (ns=%s, to revision=%d, new revision=%d) by %s", region, revision, rev, Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"revision": revision, "resource_version": rev, "changes": changes})
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// roleExists reports whether role is a builtin role or a custom role defined
+// in region, i.e. whether it may be assigned to members and group bindings.
+func (h *MemberHandler) roleExists(ctx context.Context, region string, role store.RegionRole) (bool, error) {
+	if store.IsBuiltinRole(role) {
+		return true, nil
+	}
+	cr, err := h.store.GetCustomRole(ctx, region, string(role))
+	if err != nil {
+		return false, err
+	}
+	return cr != nil, nil
+}
+
+// ListCustomRoles returns the custom roles defined in the current region.
+func (h *MemberHandler) ListCustomRoles(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	roles, err := h.store.ListCustomRoles(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if roles == nil {
+		roles = []store.CustomRole{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"custom_roles": roles})
+}
+
+// PutCustomRole creates or replaces a custom role's scope set. Callers can
+// only grant scopes they hold themselves, and admin:users is never grantable
+// because it is global rather than region-scoped.
+func (h *MemberHandler) PutCustomRole(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	if msg := store.ValidateRoleName(name); msg != "" {
+		ErrJSON(w, http.StatusBadRequest, msg)
+		return
+	}
+
+	var req struct {
+		Scopes []string `json:"scopes"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+
+	caller := IdentityFromContext(r.Context())
+	for _, sc := range req.Scopes {
+		if !store.ValidScope(sc) {
+			ErrJSON(w, http.StatusBadRequest, "invalid scope: "+sc)
+			return
+		}
+		if sc == store.ScopeAdminUsers {
+			ErrJSON(w, http.StatusBadRequest, "scope admin:users cannot be granted by a region role")
+			return
+		}
+		if caller != nil && !caller.HasScope(sc) {
+			ErrJSON(w, http.StatusForbidden, fmt.Sprintf("cannot grant scope %q you do not hold", sc))
+			return
+		}
+	}
+
+	role := &store.CustomRole{Region: region, Name: name, Scopes: req.Scopes}
+	if err := h.store.PutCustomRole(r.Context(), role); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("custom role saved: ns=%s name=%s scopes=%v", region, name, role.Scopes)
+	_ = h.store.InsertAuditLog(r.Context(), region, "custom_role", name, "put", Operator(r))
+	JSON(w, http.StatusOK, role)
+}
+
+// DeleteCustomRole removes a custom role. Builtin roles can't be deleted, and
+// roles still assigned to members or group bindings are rejected with 409.
+func (h *MemberHandler) DeleteCustomRole(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	if store.IsBuiltinRole(store.RegionRole(name)) {
+		ErrJSON(w, http.StatusBadRequest, "builtin roles cannot be deleted")
+		return
+	}
+
+	if err := h.store.DeleteCustomRole(r.Context(), region, name); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, fmt.Sprintf("custom role %q is still assigned to members or group bindings", name))
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("custom role deleted: ns=%s name=%s", region, name)
+	_ = h.store.InsertAuditLog(r.Context(), region, "custom_role", name, "delete", Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
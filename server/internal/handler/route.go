@@ -1,9 +1,16 @@
 package handler
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"maps"
 	"net/http"
+	"slices"
+	"strings"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/model"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
@@ -15,43 +22,219 @@ import (
 type RouteHandler struct {
 	store  store.Store
 	logger *zap.SugaredLogger
+	quotas quotas
+	rules  ruleSet
 }
 
-func NewRouteHandler(s store.Store, logger *zap.SugaredLogger) *RouteHandler {
-	return &RouteHandler{store: s, logger: logger}
+func NewRouteHandler(s store.Store, logger *zap.SugaredLogger, quota *config.Dynamic[config.QuotaConfig], rules *config.Dynamic[[]model.Rule]) *RouteHandler {
+	return &RouteHandler{store: s, logger: logger, quotas: quotas{store: s, defaults: quota}, rules: ruleSet{rules: rules}}
 }
 
 func (h *RouteHandler) GetConfig(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
-	cfg, err := h.store.GetConfig(r.Context(), region)
+	// Read the revision first: if a write lands in between, the client holds
+	// an older revision than the config it saw and PutConfig errs on the
+	// side of a 409 rather than a silent overwrite.
+	rev, err := h.store.ConfigRevision(r.Context(), region)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
+	canary, err := h.store.GetConfigCanary(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	channel, err := resolveChannel(r, canary)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	selector, err := parseConfigSelector(r)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var cfg *model.GatewayConfig
+	switch {
+	case selector == nil:
+		cfg, err = channelConfig(r.Context(), h.store, region, channel, canary)
+	case channel == ChannelCanary:
+		cfg = canary.Config.SelectLabels(selector)
+	default:
+		cfg, err = h.store.GetConfigByLabels(r.Context(), region, selector)
+	}
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if selector != nil && rejectUnselectedClusters(w, cfg) {
+		return
+	}
+
+	resp := map[string]any{"config": cfg, "resource_version": rev, "channel": channel}
+	if selector != nil {
+		resp["selector"] = selector
+	}
+	JSON(w, http.StatusOK, resp)
+}
+
+// parseConfigSelector reads ?selector=key=value[,key=value...] (repeatable)
+// into a label selector for GetConfig, or nil if there is none. A sharded
+// controller passes its selector so it syncs only its subset of the region.
+// Clusters are selected by their own labels, not pulled in by the domains
+// routing to them, so a selected domain's clusters must carry the labels
+// too; GetConfig rejects a selection that breaks this with 422.
+func parseConfigSelector(r *http.Request) (map[string]string, error) {
+	params := r.URL.Query()["selector"]
+	if len(params) == 0 {
+		return nil, nil
+	}
+	selector := make(map[string]string)
+	for _, p := range params {
+		for _, term := range strings.Split(p, ",") {
+			key, value, ok := strings.Cut(strings.TrimSpace(term), "=")
+			if !ok || key == "" {
+				return nil, fmt.Errorf("selector term %q must be key=value", term)
+			}
+			if prev, dup := selector[key]; dup && prev != value {
+				return nil, fmt.Errorf("selector sets label %q twice", key)
+			}
+			selector[key] = value
+		}
+	}
+	return selector, nil
+}
+
+// rejectUnselectedClusters responds 422 and returns true if a selected
+// config's domains route to clusters it does not hold.
+func rejectUnselectedClusters(w http.ResponseWriter, cfg *model.GatewayConfig) bool {
+	missing := referencedClusters(cfg.Domains)
+	for _, c := range cfg.Clusters {
+		delete(missing, c.Name)
+	}
+	if len(missing) == 0 {
+		return false
+	}
+	ErrJSON(w, http.StatusUnprocessableEntity, fmt.Sprintf(
+		"selected domains route to clusters the selector leaves out: %s; label them to match",
+		strings.Join(slices.Sorted(maps.Keys(missing)), ", ")))
+	return true
+}
+
+// StreamConfig exports the region's config as JSON Lines, reading rows
+// straight through to the response so memory stays flat however large the
+// region is. Lines carry a "type": one "revision" line first, then a
+// "domain" or "cluster" line per resource, then "end" with the counts. A
+// failure mid-stream can no longer change the status code, so it ends the
+// stream with an "error" line instead; a stream without "end" is truncated.
+func (h *RouteHandler) StreamConfig(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	// Revision first, as in GetConfig.
+	rev, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(map[string]any{"type": "revision", "resource_version": rev}); err != nil {
+		return
+	}
+	counts := map[string]int{}
+	err = h.store.StreamConfig(r.Context(), region, func(kind string, config json.RawMessage) error {
+		counts[kind]++
+		return enc.Encode(struct {
+			Type   string          `json:"type"`
+			Config json.RawMessage `json:"config"`
+		}{kind, config})
+	})
+	if err != nil {
+		h.logger.Errorf("stream config (ns=%s): %v", region, err)
+		_ = enc.Encode(map[string]string{"type": "error", "error": err.Error()})
+		return
+	}
+	_ = enc.Encode(map[string]any{"type": "end", "domains": counts["domain"], "clusters": counts["cluster"]})
+}
 
-	JSON(w, http.StatusOK, map[string]any{"config": cfg})
+// ConfigSchema lists the values accepted by the config's enumerated fields,
+// so clients can offer them instead of learning them from a 400.
+func (h *RouteHandler) ConfigSchema(w http.ResponseWriter, r *http.Request) {
+	JSON(w, http.StatusOK, map[string]any{
+		"cluster": map[string][]string{
+			"type":      model.LBTypes,
+			"scheme":    {"http", "https"},
+			"pass_host": {"pass", "node", "rewrite"},
+		},
+		"route": map[string]any{
+			"status": model.RouteStatuses,
+		},
+	})
 }
 
+// PutConfig replaces the region's whole config. When resource_version is
+// given it must equal the region's current config revision (as returned by
+// GetConfig), otherwise the import is rejected with 409.
 func (h *RouteHandler) PutConfig(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
-	var cfg model.GatewayConfig
-	if err := DecodeJSON(r, &cfg); err != nil {
+	var body struct {
+		model.GatewayConfig
+		ResourceVersion *int64 `json:"resource_version"`
+	}
+	if err := DecodeJSON(r, &body); err != nil {
 		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
 		return
 	}
+	cfg := body.GatewayConfig
+	model.NormalizeConfigHosts(&cfg)
 
 	if errs := model.ValidateConfig(&cfg); len(errs) > 0 {
 		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
 		return
 	}
+	if normalizeRequested(r) {
+		for i := range cfg.Domains {
+			model.NormalizeRouteWeights(&cfg.Domains[i])
+		}
+	}
+	ruleWarns, ok := h.rules.checkConfig(w, region, &cfg)
+	if !ok {
+		return
+	}
+	if rejectMissingSecrets(w, r, h.store, region, cfg.Domains...) {
+		return
+	}
 
-	_, err := h.store.PutAllConfig(r.Context(), region, cfg.Domains, cfg.Clusters, Operator(r))
+	expected := int64(-1)
+	if body.ResourceVersion != nil {
+		if *body.ResourceVersion < 0 {
+			ErrJSON(w, http.StatusBadRequest, "resource_version must be >= 0")
+			return
+		}
+		expected = *body.ResourceVersion
+	}
+	if h.quotas.rejectReplace(w, r, region, "domain", len(cfg.Domains)) ||
+		h.quotas.rejectReplace(w, r, region, "cluster", len(cfg.Clusters)) {
+		return
+	}
+
+	rev, err := h.store.PutAllConfig(r.Context(), region, cfg.Domains, cfg.Clusters, Operator(r), expected)
 	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, "conflict: the region config has changed since resource_version, please refresh and try again")
+			return
+		}
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
 	}
 
-	JSON(w, http.StatusOK, map[string]any{"domains": len(cfg.Domains), "clusters": len(cfg.Clusters)})
+	JSON(w, http.StatusOK, map[string]any{
+		"domains": len(cfg.Domains), "clusters": len(cfg.Clusters), "resource_version": rev,
+		"config": cfg, "warnings": warnings(append(model.WarnConfig(&cfg), ruleWarns...)),
+	})
 }
 
 func (h *RouteHandler) ValidateConfig(w http.ResponseWriter, r *http.Request) {
@@ -61,9 +244,81 @@ func (h *RouteHandler) ValidateConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	warns := warnings(model.WarnConfig(&cfg))
 	if errs := model.ValidateConfig(&cfg); len(errs) > 0 {
-		JSON(w, http.StatusOK, map[string]any{"valid": false, "errors": errs})
+		JSON(w, http.StatusOK, map[string]any{"valid": false, "errors": errs, "warnings": warns})
+		return
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"valid": true, "domains": len(cfg.Domains), "clusters": len(cfg.Clusters),
+		"errors": []model.ValidationError{}, "warnings": warns,
+	})
+}
+
+// LintConfig runs validation, the best-practice lint rules and the
+// configured rules for the caller's region over a full config. With
+// ?strict=true warnings are reported as errors, so "passed" requires a
+// clean config.
+func (h *RouteHandler) LintConfig(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	var cfg model.GatewayConfig
+	if err := DecodeJSON(r, &cfg); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
 		return
 	}
-	JSON(w, http.StatusOK, map[string]any{"valid": true, "domains": len(cfg.Domains), "clusters": len(cfg.Clusters)})
+	strict := r.URL.Query().Get("strict") == "true"
+
+	findings := append(model.LintConfig(&cfg), h.rules.lint(region, &cfg)...)
+	if findings == nil {
+		findings = []model.LintFinding{}
+	}
+	errCount, warnCount := 0, 0
+	for i := range findings {
+		if strict {
+			findings[i].Severity = model.SeverityError
+		}
+		if findings[i].Severity == model.SeverityError {
+			errCount++
+		} else {
+			warnCount++
+		}
+	}
+	JSON(w, http.StatusOK, map[string]any{
+		"passed": errCount == 0, "strict": strict,
+		"errors": errCount, "warnings": warnCount, "findings": findings,
+	})
+}
+
+// normalizeRequested reports whether the client asked (?normalize=true) for
+// route cluster weights to be rescaled to sum to 100 before storing.
+func normalizeRequested(r *http.Request) bool {
+	return r.URL.Query().Get("normalize") == "true"
+}
+
+// skipNoopContext returns the context to update a resource with. If the
+// client asked (?skip_noop=true), an update that changes nothing is not
+// written, and the returned SkipNoop reports it.
+func skipNoopContext(r *http.Request) (context.Context, *store.SkipNoop) {
+	if r.URL.Query().Get("skip_noop") == "true" {
+		return store.WithSkipNoop(r.Context())
+	}
+	return r.Context(), &store.SkipNoop{}
+}
+
+// updatedVersion is the resource version after an update expecting
+// version expected.
+func updatedVersion(expected int64, noop *store.SkipNoop) int64 {
+	if noop.Skipped {
+		return expected
+	}
+	return expected + 1
+}
+
+// warnings normalizes nil to an empty list so responses always carry a
+// "warnings" array.
+func warnings(w []model.ValidationError) []model.ValidationError {
+	if w == nil {
+		return []model.ValidationError{}
+	}
+	return w
 }
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/model"
+)
+
+// ruleSet applies the configured rules (config "rules") to writes.
+type ruleSet struct {
+	rules *config.Dynamic[[]model.Rule] // replaced on config reload
+}
+
+// checkDomain evaluates the rules for d in region. If an error rule fails
+// it writes a 400 and returns false; otherwise it returns the failed
+// warning rules as response warnings.
+func (rs ruleSet) checkDomain(w http.ResponseWriter, region string, d *model.DomainConfig) ([]model.ValidationError, bool) {
+	return rejectRuleErrors(w, model.EvaluateDomainRules(rs.rules.Load(), region, d))
+}
+
+// checkCluster is checkDomain for a cluster.
+func (rs ruleSet) checkCluster(w http.ResponseWriter, region string, c *model.ClusterConfig) ([]model.ValidationError, bool) {
+	return rejectRuleErrors(w, model.EvaluateClusterRules(rs.rules.Load(), region, c))
+}
+
+// checkConfig is checkDomain for a whole config.
+func (rs ruleSet) checkConfig(w http.ResponseWriter, region string, cfg *model.GatewayConfig) ([]model.ValidationError, bool) {
+	return rejectRuleErrors(w, rs.lint(region, cfg))
+}
+
+// lint returns every rule finding for cfg in region.
+func (rs ruleSet) lint(region string, cfg *model.GatewayConfig) []model.LintFinding {
+	return model.EvaluateRules(rs.rules.Load(), region, cfg)
+}
+
+// rejectRuleErrors answers 400 with the error findings, in the shape of a
+// validation failure, or returns the warnings as validation warnings. Each
+// message names its rule.
+func rejectRuleErrors(w http.ResponseWriter, findings []model.LintFinding) ([]model.ValidationError, bool) {
+	var errs, warns []model.ValidationError
+	for _, f := range findings {
+		e := ruleViolation(f)
+		if f.Severity == model.SeverityError {
+			errs = append(errs, e)
+		} else {
+			warns = append(warns, e)
+		}
+	}
+	if len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+		return nil, false
+	}
+	return warns, true
+}
+
+// ruleError returns the first failed error rule among findings, for writes
+// with no client to answer, such as scheduled changes.
+func ruleError(findings []model.LintFinding) error {
+	for _, f := range findings {
+		if f.Severity == model.SeverityError {
+			return ruleViolation(f)
+		}
+	}
+	return nil
+}
+
+func ruleViolation(f model.LintFinding) model.ValidationError {
+	return model.ValidationError{Field: f.Field, Message: fmt.Sprintf("%s (rule %s)", f.Message, f.Rule)}
+}
@@ -0,0 +1,323 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// scheduledChangeBatch caps how many due changes one scheduler run applies.
+const scheduledChangeBatch = 50
+
+// ScheduleHandler submits, lists and cancels scheduled domain/cluster
+// changes. RunScheduledChanges applies them once due.
+type ScheduleHandler struct {
+	store  store.Store
+	logger *zap.SugaredLogger
+	rules  ruleSet
+}
+
+func NewScheduleHandler(s store.Store, logger *zap.SugaredLogger, rules *config.Dynamic[[]model.Rule]) *ScheduleHandler {
+	return &ScheduleHandler{store: s, logger: logger, rules: ruleSet{rules: rules}}
+}
+
+// CreateScheduledChange accepts a change to apply at apply_at. The config is
+// validated now so mistakes surface at submit time rather than when the
+// change is due. A "put" creates the resource or replaces it whatever its
+// version at apply time; a "delete" must name an existing resource. The
+// change reason, if any, is kept and recorded when the change is applied.
+func (h *ScheduleHandler) CreateScheduledChange(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	var req struct {
+		Kind    string               `json:"kind"`
+		Action  string               `json:"action"`
+		Name    string               `json:"name"`
+		ApplyAt time.Time            `json:"apply_at"`
+		Domain  *model.DomainConfig  `json:"domain"`
+		Cluster *model.ClusterConfig `json:"cluster"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if req.ApplyAt.IsZero() {
+		ErrJSON(w, http.StatusBadRequest, "apply_at is required")
+		return
+	}
+	if !req.ApplyAt.After(time.Now()) {
+		ErrJSON(w, http.StatusBadRequest, "apply_at must be in the future")
+		return
+	}
+	if req.Action == "" {
+		req.Action = "put"
+	}
+
+	change := store.ScheduledChange{Kind: req.Kind, Action: req.Action, ApplyAt: req.ApplyAt, CreatedBy: Operator(r),
+		Reason: store.ChangeReasonFromContext(r.Context())}
+	switch req.Action {
+	case "put":
+		switch req.Kind {
+		case "domain":
+			if req.Domain == nil || req.Domain.Name == "" {
+				ErrJSON(w, http.StatusBadRequest, "domain with a name is required")
+				return
+			}
+			model.NormalizeHosts(req.Domain)
+			if errs := model.ValidateDomain(req.Domain, nil); len(errs) > 0 {
+				JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+				return
+			}
+			if _, ok := h.rules.checkDomain(w, region, req.Domain); !ok {
+				return
+			}
+			if rejectMissingSecrets(w, r, h.store, region, *req.Domain) {
+				return
+			}
+			change.Name, change.Domain = req.Domain.Name, req.Domain
+		case "cluster":
+			if req.Cluster == nil || req.Cluster.Name == "" {
+				ErrJSON(w, http.StatusBadRequest, "cluster with a name is required")
+				return
+			}
+			if errs := model.ValidateCluster(req.Cluster); len(errs) > 0 {
+				JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+				return
+			}
+			if _, ok := h.rules.checkCluster(w, region, req.Cluster); !ok {
+				return
+			}
+			change.Name, change.Cluster = req.Cluster.Name, req.Cluster
+		default:
+			ErrJSON(w, http.StatusBadRequest, "kind must be domain or cluster")
+			return
+		}
+	case "delete":
+		if req.Name == "" {
+			ErrJSON(w, http.StatusBadRequest, "name is required for delete")
+			return
+		}
+		var exists bool
+		switch req.Kind {
+		case "domain":
+			d, _, err := h.store.GetDomain(r.Context(), region, req.Name)
+			if err != nil {
+				ErrJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			exists = d != nil
+		case "cluster":
+			c, _, err := h.store.GetCluster(r.Context(), region, req.Name)
+			if err != nil {
+				ErrJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			exists = c != nil
+		default:
+			ErrJSON(w, http.StatusBadRequest, "kind must be domain or cluster")
+			return
+		}
+		if !exists {
+			ErrJSON(w, http.StatusNotFound, fmt.Sprintf("%s %q not found", req.Kind, req.Name))
+			return
+		}
+		change.Name = req.Name
+	default:
+		ErrJSON(w, http.StatusBadRequest, "action must be put or delete")
+		return
+	}
+
+	id, err := h.store.CreateScheduledChange(r.Context(), region, &change)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	change.ID, change.Region, change.Status = id, region, store.ScheduledPending
+	_ = h.store.InsertAuditLog(r.Context(), region, "scheduled_change", change.Kind+"/"+change.Name, "schedule", Operator(r))
+
+	h.logger.Infof("change #%d scheduled: %s %s/%s (ns=%s) at %s", id, change.Action, change.Kind, change.Name, region, change.ApplyAt.Format(time.RFC3339))
+	JSON(w, http.StatusCreated, change)
+}
+
+// ListScheduledChanges returns the region's scheduled changes by apply time,
+// optionally filtered by ?status=.
+func (h *ScheduleHandler) ListScheduledChanges(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", store.ScheduledPending, store.ScheduledApplying, store.ScheduledApplied, store.ScheduledFailed, store.ScheduledCancelled:
+	default:
+		ErrJSON(w, http.StatusBadRequest, "status must be pending, applying, applied, failed or cancelled")
+		return
+	}
+
+	changes, err := h.store.ListScheduledChanges(r.Context(), region, status)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if changes == nil {
+		changes = []store.ScheduledChange{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"scheduled_changes": changes, "total": len(changes)})
+}
+
+// CancelScheduledChange cancels a change that is still pending.
+func (h *ScheduleHandler) CancelScheduledChange(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid id")
+		return
+	}
+
+	cancelled, err := h.store.CancelScheduledChange(r.Context(), region, id)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !cancelled {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("no pending scheduled change %d", id))
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "scheduled_change", strconv.FormatInt(id, 10), "cancel", Operator(r))
+
+	h.logger.Infof("scheduled change #%d cancelled (ns=%s) by %s", id, region, Operator(r))
+	JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// RunScheduledChanges applies due scheduled changes through the regular
+// store writes, so they land in history and the change log like any other
+// edit, attributed to the submitter. Safe to call from every replica: the
+// store lets only one claim at a time. Returns how many were applied.
+func RunScheduledChanges(ctx context.Context, s store.Store, quota *config.Dynamic[config.QuotaConfig], rules *config.Dynamic[[]model.Rule], logger *zap.SugaredLogger) int {
+	changes, err := s.ClaimDueScheduledChanges(ctx, scheduledChangeBatch)
+	if err != nil {
+		logger.Warnf("claim scheduled changes: %v", err)
+		return 0
+	}
+
+	q := quotas{store: s, defaults: quota}
+	rs := ruleSet{rules: rules}
+	applied := 0
+	for _, c := range changes {
+		var msg string
+		if err := applyScheduledChange(ctx, s, q, rs, &c); err != nil {
+			msg = err.Error()
+			logger.Warnf("scheduled change #%d (%s %s/%s, ns=%s) failed: %v", c.ID, c.Action, c.Kind, c.Name, c.Region, err)
+		} else {
+			applied++
+			logger.Infof("scheduled change #%d applied: %s %s/%s (ns=%s)", c.ID, c.Action, c.Kind, c.Name, c.Region)
+		}
+		if err := s.FinishScheduledChange(ctx, c.ID, msg); err != nil {
+			logger.Warnf("finish scheduled change #%d: %v", c.ID, err)
+		}
+	}
+	return applied
+}
+
+// applyScheduledChange re-runs the checks an interactive write would get
+// against the region as it is now, since it may have changed a lot since
+// the change was submitted: validation rules, TLS secrets, quotas, cluster
+// references, and (inside PutDomain, given the current version) host
+// conflicts.
+func applyScheduledChange(ctx context.Context, s store.Store, q quotas, rs ruleSet, c *store.ScheduledChange) error {
+	// A change freeze also holds back scheduled changes.
+	frozen, err := s.IsRegionFrozen(ctx, c.Region)
+	if err != nil {
+		return err
+	}
+	if frozen {
+		return fmt.Errorf("region %q is frozen", c.Region)
+	}
+
+	operator := fmt.Sprintf("%s (scheduled #%d)", c.CreatedBy, c.ID)
+	if c.Reason != "" {
+		ctx = store.WithChangeReason(ctx, c.Reason)
+	}
+	switch {
+	case c.Action == "delete" && c.Kind == "domain":
+		_, err = s.DeleteDomain(ctx, c.Region, c.Name, operator)
+	case c.Action == "delete" && c.Kind == "cluster":
+		domains, lerr := s.ListDomains(ctx, c.Region)
+		if lerr != nil {
+			return lerr
+		}
+		if referencedClusters(domains)[c.Name] {
+			return fmt.Errorf("cluster %q is still referenced by domain routes", c.Name)
+		}
+		_, err = s.DeleteCluster(ctx, c.Region, c.Name, operator)
+	case c.Domain != nil:
+		existing, version, gerr := s.GetDomain(ctx, c.Region, c.Name)
+		if gerr != nil {
+			return gerr
+		}
+		clusters, lerr := s.ListClusters(ctx, c.Region)
+		if lerr != nil {
+			return lerr
+		}
+		names := make(map[string]bool, len(clusters))
+		for _, cl := range clusters {
+			names[cl.Name] = true
+		}
+		if errs := model.ValidateDomain(c.Domain, names); len(errs) > 0 {
+			return errs[0]
+		}
+		// The secret may have been deleted since submit.
+		if c.Domain.TLS != nil {
+			sec, serr := s.GetSecret(ctx, c.Region, c.Domain.TLS.CertificateRef)
+			if serr != nil {
+				return serr
+			}
+			if sec == nil {
+				return fmt.Errorf("domain %q: tls.certificate_ref: secret %q not found", c.Domain.Name, c.Domain.TLS.CertificateRef)
+			}
+		}
+		// The rules may have been reloaded since submit.
+		if err := ruleError(model.EvaluateDomainRules(rs.rules.Load(), c.Region, c.Domain)); err != nil {
+			return err
+		}
+		action := "update"
+		if existing == nil {
+			action = "create"
+			if err := q.checkCreate(ctx, c.Region, "domain"); err != nil {
+				return err
+			}
+		}
+		// Writing at the version just read makes PutDomain check host
+		// conflicts, and turns a concurrent edit into a conflict rather than
+		// overwriting it.
+		_, err = s.PutDomain(ctx, c.Region, c.Domain, action, operator, version)
+	case c.Cluster != nil:
+		if err := ruleError(model.EvaluateClusterRules(rs.rules.Load(), c.Region, c.Cluster)); err != nil {
+			return err
+		}
+		existing, version, gerr := s.GetCluster(ctx, c.Region, c.Name)
+		if gerr != nil {
+			return gerr
+		}
+		action := "update"
+		if existing == nil {
+			action = "create"
+			if err := q.checkCreate(ctx, c.Region, "cluster"); err != nil {
+				return err
+			}
+		}
+		_, err = s.PutCluster(ctx, c.Region, c.Cluster, action, operator, version)
+	default:
+		err = fmt.Errorf("malformed scheduled change: %s %s", c.Action, c.Kind)
+	}
+	if errors.Is(err, store.ErrConflict) {
+		return fmt.Errorf("%s %q changed while the scheduled change was applied", c.Kind, c.Name)
+	}
+	return err
+}
@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// Search serves GET /api/v1/search?q=, matching q against domain names,
+// domain hosts and cluster names in the caller's region. Results are ranked
+// exact match, prefix, then substring, and capped by ?limit= (default 20,
+// at most 100); truncated reports whether more matched.
+func (h *RouteHandler) Search(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		ErrJSON(w, http.StatusBadRequest, "q is required")
+		return
+	}
+	limit := defaultSearchLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxSearchLimit {
+			ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxSearchLimit))
+			return
+		}
+		limit = n
+	}
+
+	// One extra row tells whether the results were cut off.
+	results, err := h.store.Search(r.Context(), region, q, limit+1)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	truncated := len(results) > limit
+	if truncated {
+		results = results[:limit]
+	}
+	JSON(w, http.StatusOK, map[string]any{"query": q, "results": results, "truncated": truncated})
+}
@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// maxSecretValueBytes bounds a secret value; a PEM chain plus key fits easily.
+const maxSecretValueBytes = 64 << 10
+
+// SecretHandler manages region secrets. Values are sealed with the master key
+// on write and never returned: config references secrets by name only.
+type SecretHandler struct {
+	store  store.Store
+	box    *secretbox.Box // nil disables secret writes
+	logger *zap.SugaredLogger
+}
+
+func NewSecretHandler(s store.Store, box *secretbox.Box, logger *zap.SugaredLogger) *SecretHandler {
+	return &SecretHandler{store: s, box: box, logger: logger}
+}
+
+// ListSecrets returns metadata for the region's secrets.
+func (h *SecretHandler) ListSecrets(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	secrets, err := h.store.ListSecrets(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if secrets == nil {
+		secrets = []store.Secret{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"secrets": secrets, "total": len(secrets)})
+}
+
+// GetSecret returns a secret's metadata.
+func (h *SecretHandler) GetSecret(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	sec, err := h.store.GetSecret(r.Context(), region, name)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if sec == nil {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("secret %q not found", name))
+		return
+	}
+	JSON(w, http.StatusOK, sec)
+}
+
+// PutSecret creates or replaces a secret. The value is sealed before it
+// reaches the store; the response carries metadata only.
+func (h *SecretHandler) PutSecret(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	if h.box == nil {
+		ErrJSON(w, http.StatusServiceUnavailable, "secrets require master_key to be configured")
+		return
+	}
+	if !model.ValidSecretName(name) {
+		ErrJSON(w, http.StatusBadRequest, "secret name must be lowercase alphanumerics, '-' or '.', starting and ending with an alphanumeric character")
+		return
+	}
+
+	var req struct {
+		Value       string `json:"value"`
+		Description string `json:"description"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "invalid JSON")
+		return
+	}
+	if req.Value == "" {
+		ErrJSON(w, http.StatusBadRequest, "value is required")
+		return
+	}
+	if len(req.Value) > maxSecretValueBytes {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("value exceeds %d bytes", maxSecretValueBytes))
+		return
+	}
+
+	sealed, err := h.box.Seal([]byte(req.Value))
+	if err != nil {
+		h.logger.Errorf("seal secret: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "store secret failed")
+		return
+	}
+	sec := &store.Secret{Region: region, Name: name, Description: req.Description, SealedValue: sealed}
+	if err := h.store.PutSecret(r.Context(), sec); err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("secret saved: ns=%s name=%s", region, name)
+	_ = h.store.InsertAuditLog(r.Context(), region, "secret", name, "put", Operator(r))
+	JSON(w, http.StatusOK, sec)
+}
+
+// DeleteSecret removes a secret. Secrets still referenced by a domain are
+// rejected with 409.
+func (h *SecretHandler) DeleteSecret(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	name := r.PathValue("name")
+
+	if err := h.store.DeleteSecret(r.Context(), region, name); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, fmt.Sprintf("secret %q is still referenced by a domain", name))
+			return
+		}
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("secret deleted: ns=%s name=%s", region, name)
+	_ = h.store.InsertAuditLog(r.Context(), region, "secret", name, "delete", Operator(r))
+	JSON(w, http.StatusOK, map[string]any{"ok": true})
+}
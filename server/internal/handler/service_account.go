@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/jizhuozhi/hermes/server/internal/store"
+
+	"go.uber.org/zap"
+)
+
+// serviceAccountTokenPrefix marks opaque service-account tokens so that
+// Authenticate can tell them apart from JWTs on the Bearer path.
+const serviceAccountTokenPrefix = "hsa_"
+
+type ServiceAccountHandler struct {
+	store  store.Store
+	logger *zap.SugaredLogger
+}
+
+func NewServiceAccountHandler(s store.Store, logger *zap.SugaredLogger) *ServiceAccountHandler {
+	return &ServiceAccountHandler{store: s, logger: logger}
+}
+
+// ListServiceAccounts returns all service accounts in the current region (tokens are never returned).
+func (h *ServiceAccountHandler) ListServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	accounts, err := h.store.ListServiceAccounts(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("list service accounts: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if accounts == nil {
+		accounts = []store.ServiceAccount{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"service_accounts": accounts})
+}
+
+// CreateServiceAccount issues a new bearer token in the current region.
+// The token is returned once; only its hash is stored.
+func (h *ServiceAccountHandler) CreateServiceAccount(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	var req struct {
+		Name        string   `json:"name"`
+		Description string   `json:"description"`
+		Scopes      []string `json:"scopes"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, "decode: "+err.Error())
+		return
+	}
+	if req.Name == "" {
+		ErrJSON(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	for _, s := range req.Scopes {
+		if !store.ValidScope(s) {
+			ErrJSON(w, http.StatusBadRequest, "invalid scope: "+s)
+			return
+		}
+	}
+	if req.Scopes == nil {
+		req.Scopes = []string{}
+	}
+	if rejectScopeEscalation(w, r, req.Scopes) {
+		return
+	}
+
+	secret, err := generateRandomHex(32)
+	if err != nil {
+		h.logger.Errorf("generate service account token: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "generate token failed")
+		return
+	}
+	token := serviceAccountTokenPrefix + secret
+
+	sa := &store.ServiceAccount{
+		Name:        req.Name,
+		Description: req.Description,
+		Scopes:      req.Scopes,
+		TokenPrefix: token[:len(serviceAccountTokenPrefix)+8],
+	}
+	result, err := h.store.CreateServiceAccount(r.Context(), region, sa, sha256Hex([]byte(token)))
+	if err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			ErrJSON(w, http.StatusConflict, fmt.Sprintf("service account %q already exists", req.Name))
+			return
+		}
+		h.logger.Errorf("create service account: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	result.Token = token
+
+	h.logger.Infof("service account created: ns=%s name=%s scopes=%v", region, result.Name, result.Scopes)
+	_ = h.store.InsertAuditLog(r.Context(), region, "service_account", result.Name, "create", Operator(r))
+	JSON(w, http.StatusCreated, result)
+}
+
+// DeleteServiceAccount revokes a service account; its token stops working immediately.
+func (h *ServiceAccountHandler) DeleteServiceAccount(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	idStr := r.PathValue("id")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || id <= 0 {
+		ErrJSON(w, http.StatusBadRequest, "invalid service account id")
+		return
+	}
+
+	if err := h.store.DeleteServiceAccount(r.Context(), region, id); err != nil {
+		h.logger.Errorf("delete service account: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.logger.Infof("service account revoked: ns=%s id=%d", region, id)
+	_ = h.store.InsertAuditLog(r.Context(), region, "service_account", idStr, "delete", Operator(r))
+	JSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
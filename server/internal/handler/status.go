@@ -1,9 +1,13 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"slices"
 
+	"github.com/jizhuozhi/hermes/server/internal/config"
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
 	"go.uber.org/zap"
@@ -12,10 +16,11 @@ import (
 type StatusHandler struct {
 	store  store.Store
 	logger *zap.SugaredLogger
+	cfg    *config.Dynamic[config.StatusConfig] // health thresholds; replaced on config reload
 }
 
-func NewStatusHandler(s store.Store, logger *zap.SugaredLogger) *StatusHandler {
-	return &StatusHandler{store: s, logger: logger}
+func NewStatusHandler(s store.Store, logger *zap.SugaredLogger, cfg *config.Dynamic[config.StatusConfig]) *StatusHandler {
+	return &StatusHandler{store: s, logger: logger, cfg: cfg}
 }
 
 // ReportInstances accepts a PUT/POST from the controller with the current
@@ -47,7 +52,8 @@ func (h *StatusHandler) ReportInstances(w http.ResponseWriter, r *http.Request)
 	JSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
-// AggregateStatus returns the current gateway instance list, controller status and metadata.
+// AggregateStatus returns the current gateway instance list, controller
+// status and the region's computed health.
 func (h *StatusHandler) AggregateStatus(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 
@@ -68,9 +74,51 @@ func (h *StatusHandler) AggregateStatus(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	revision, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("config revision: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	online, inSync := 0, 0
+	for _, inst := range instances {
+		if inst.Status != "offline" {
+			online++
+			if inst.ConfigRevision >= revision {
+				inSync++
+			}
+		}
+	}
+
+	owners, err := h.domainOwners(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("domain owners: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	canary, err := h.store.GetConfigCanary(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("get canary: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
 	result := map[string]any{
-		"instances": instances,
-		"total":     len(instances),
+		"instances":       instances,
+		"total":           len(instances),
+		"config_revision": revision,
+		"health":          regionHealth(h.cfg.Load().Health, len(instances), online, inSync, ctrl, revision),
+		"owners":          owners,
+	}
+
+	// While a canary runs, show which channel each instance is assigned.
+	if canary != nil {
+		channels := make(map[string]string, len(instances))
+		for _, inst := range instances {
+			channels[inst.ID] = canaryChannel(inst.ID, canary.Percent)
+		}
+		result["canary"] = map[string]any{"percent": canary.Percent, "channels": channels}
 	}
 
 	if ctrl != nil {
@@ -81,6 +129,69 @@ func (h *StatusHandler) AggregateStatus(w http.ResponseWriter, r *http.Request)
 	JSON(w, http.StatusOK, result)
 }
 
+// domainOwner is who to contact about a domain. Inherited is set when the
+// owner or contact came from the region defaults.
+type domainOwner struct {
+	Domain    string `json:"domain"`
+	Owner     string `json:"owner"`
+	Contact   string `json:"contact"`
+	Inherited bool   `json:"inherited,omitempty"`
+}
+
+// domainOwners resolves each domain's effective owner and contact so alerts
+// on the status view can be routed to a team.
+func (h *StatusHandler) domainOwners(ctx context.Context, region string) ([]domainOwner, error) {
+	domains, err := h.store.ListDomains(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := h.store.GetRegionSettings(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	if settings == nil {
+		settings = &store.RegionSettings{}
+	}
+
+	owners := make([]domainOwner, 0, len(domains))
+	for _, d := range domains {
+		o := domainOwner{Domain: d.Name, Owner: d.Owner, Contact: d.Contact}
+		if o.Owner == "" && settings.DefaultOwner != "" {
+			o.Owner, o.Inherited = settings.DefaultOwner, true
+		}
+		if o.Contact == "" && settings.DefaultContact != "" {
+			o.Contact, o.Inherited = settings.DefaultContact, true
+		}
+		owners = append(owners, o)
+	}
+	return owners, nil
+}
+
+// AllStatus summarizes status across every region for dashboards that would
+// otherwise call AggregateStatus once per region.
+func (h *StatusHandler) AllStatus(w http.ResponseWriter, r *http.Request) {
+	summaries, err := h.store.ListRegionStatus(r.Context())
+	if err != nil {
+		h.logger.Errorf("list region status: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	type regionStatus struct {
+		store.RegionStatusSummary
+		Health Health `json:"health"`
+	}
+	thresholds := h.cfg.Load().Health
+	regions := make([]regionStatus, 0, len(summaries))
+	for _, sum := range summaries {
+		regions = append(regions, regionStatus{
+			RegionStatusSummary: sum,
+			Health:              regionHealth(thresholds, sum.Instances, sum.InstancesOnline, sum.InstancesInSync, sum.Controller, sum.ConfigRevision),
+		})
+	}
+
+	JSON(w, http.StatusOK, map[string]any{"regions": regions, "total": len(regions)})
+}
+
 // ListInstances returns the raw instance list.
 func (h *StatusHandler) ListInstances(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
@@ -98,6 +209,127 @@ func (h *StatusHandler) ListInstances(w http.ResponseWriter, r *http.Request) {
 	JSON(w, http.StatusOK, map[string]any{"instances": instances})
 }
 
+// DeregisterInstance removes an instance on graceful shutdown so it does not
+// linger until the stale reaper marks it offline. Crashed instances are
+// still left to the reaper.
+func (h *StatusHandler) DeregisterInstance(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	id := r.PathValue("id")
+
+	deleted, err := h.store.DeleteGatewayInstance(r.Context(), region, id)
+	if err != nil {
+		h.logger.Errorf("delete instance: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, "store: "+err.Error())
+		return
+	}
+	if !deleted {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("instance %q not found", id))
+		return
+	}
+
+	h.logger.Infow("gateway instance deregistered", "region", region, "id", id, "operator", Operator(r))
+	JSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// InstanceHistory returns the config revisions an instance moved through,
+// oldest first, for charting how a rollout converged.
+func (h *StatusHandler) InstanceHistory(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	id := r.PathValue("id")
+
+	events, err := h.store.ListInstanceRevisionHistory(r.Context(), region, id)
+	if err != nil {
+		h.logger.Errorf("list instance history: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if events == nil {
+		events = []store.InstanceRevisionEvent{}
+	}
+
+	JSON(w, http.StatusOK, map[string]any{"id": id, "events": events})
+}
+
+// InstanceConfig returns the config an instance should be running: the
+// channel GetConfig assigns it and that channel's config, next to the
+// revision it last reported, for debugging a gateway serving stale routes.
+func (h *StatusHandler) InstanceConfig(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	id := r.PathValue("id")
+
+	instances, err := h.store.ListGatewayInstances(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("list instances: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	idx := slices.IndexFunc(instances, func(inst store.GatewayInstanceStatus) bool { return inst.ID == id })
+	if idx < 0 {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("instance %q not found", id))
+		return
+	}
+	inst := instances[idx]
+
+	// Same order as GetConfig: revision before config.
+	revision, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("config revision: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	canary, err := h.store.GetConfigCanary(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("get canary: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	channel := ChannelStable
+	if canary != nil {
+		channel = canaryChannel(id, canary.Percent)
+	}
+	cfg, err := channelConfig(r.Context(), h.store, region, channel, canary)
+	if err != nil {
+		h.logger.Errorf("get config: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := map[string]any{
+		"id":                id,
+		"status":            inst.Status,
+		"channel":           channel,
+		"config":            cfg,
+		"config_revision":   revision,
+		"reported_revision": inst.ConfigRevision,
+	}
+	// The canary config has no revision of its own: canary instances are
+	// served the stable revision alongside it, so the one they report says
+	// nothing about which canary config they run.
+	if channel == ChannelStable {
+		resp["in_sync"] = inst.ConfigRevision >= revision
+	}
+	JSON(w, http.StatusOK, resp)
+}
+
+// ControllerElections returns the region's controller leadership
+// transitions, oldest first, for lining up config sync gaps with leader
+// flaps.
+func (h *StatusHandler) ControllerElections(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+
+	events, err := h.store.ListLeadershipEvents(r.Context(), region)
+	if err != nil {
+		h.logger.Errorf("list leadership events: %v", err)
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if events == nil {
+		events = []store.LeadershipEvent{}
+	}
+
+	JSON(w, http.StatusOK, map[string]any{"events": events})
+}
+
 // ReportController accepts a PUT from the controller with its own status.
 func (h *StatusHandler) ReportController(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
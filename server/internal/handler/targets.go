@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/store"
+)
+
+// maxFanoutTargets caps how many etcd clusters one region fans out to.
+const maxFanoutTargets = 16
+
+// GetRegionTargets returns the etcd clusters the region's config is synced
+// to.
+func (h *RegionHandler) GetRegionTargets(w http.ResponseWriter, r *http.Request) {
+	h.writeTargets(w, r, r.PathValue("name"))
+}
+
+// ConfigTargets is GetRegionTargets for the region in the request header,
+// so a controller can discover its fan-out targets with its usual
+// credentials.
+func (h *RegionHandler) ConfigTargets(w http.ResponseWriter, r *http.Request) {
+	h.writeTargets(w, r, RegionFromContext(r.Context()))
+}
+
+func (h *RegionHandler) writeTargets(w http.ResponseWriter, r *http.Request, region string) {
+	targets, found, err := h.store.GetRegionTargets(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("region %q not found", region))
+		return
+	}
+	if targets == nil {
+		targets = []store.FanoutTarget{}
+	}
+	JSON(w, http.StatusOK, map[string]any{"region": region, "targets": targets, "total": len(targets)})
+}
+
+// PutRegionTargets replaces the region's fan-out targets. An empty list
+// means the region is served from a single etcd.
+func (h *RegionHandler) PutRegionTargets(w http.ResponseWriter, r *http.Request) {
+	region := r.PathValue("name")
+
+	var req struct {
+		Targets []store.FanoutTarget `json:"targets"`
+	}
+	if err := DecodeJSON(r, &req); err != nil {
+		ErrJSON(w, http.StatusBadRequest, fmt.Sprintf("invalid json: %v", err))
+		return
+	}
+	if errs := validateTargets(req.Targets); len(errs) > 0 {
+		JSON(w, http.StatusBadRequest, map[string]any{"errors": errs})
+		return
+	}
+
+	found, err := h.store.SetRegionTargets(r.Context(), region, req.Targets)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if !found {
+		ErrJSON(w, http.StatusNotFound, fmt.Sprintf("region %q not found", region))
+		return
+	}
+	_ = h.store.InsertAuditLog(r.Context(), region, "region", region, "set_targets", Operator(r))
+
+	h.logger.Infof("region %s: %d fan-out targets set by %s", region, len(req.Targets), Operator(r))
+	h.writeTargets(w, r, region)
+}
+
+func validateTargets(targets []store.FanoutTarget) []model.ValidationError {
+	var errs []model.ValidationError
+	if len(targets) > maxFanoutTargets {
+		return append(errs, model.ValidationError{Field: "targets", Message: fmt.Sprintf("at most %d targets allowed", maxFanoutTargets)})
+	}
+	seen := make(map[string]bool)
+	for i, t := range targets {
+		prefix := fmt.Sprintf("targets[%d]", i)
+		// Targets are physical regions, so they follow the region name rules.
+		if msg := store.ValidateRegionName(t.Name); msg != "" {
+			errs = append(errs, model.ValidationError{Field: prefix + ".name", Message: msg})
+		} else if seen[t.Name] {
+			errs = append(errs, model.ValidationError{Field: prefix + ".name", Message: fmt.Sprintf("duplicate target %q", t.Name)})
+		}
+		seen[t.Name] = true
+
+		if len(t.Endpoints) == 0 {
+			errs = append(errs, model.ValidationError{Field: prefix + ".endpoints", Message: "at least one endpoint is required"})
+		}
+		for j, ep := range t.Endpoints {
+			u, err := url.Parse(ep)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, model.ValidationError{Field: fmt.Sprintf("%s.endpoints[%d]", prefix, j), Message: "must be an http(s) URL"})
+			}
+		}
+		for _, f := range []struct{ name, value string }{{"domain_prefix", t.DomainPrefix}, {"cluster_prefix", t.ClusterPrefix}} {
+			if f.value != "" && !strings.HasPrefix(f.value, "/") {
+				errs = append(errs, model.ValidationError{Field: prefix + "." + f.name, Message: "must start with /"})
+			}
+		}
+	}
+	return errs
+}
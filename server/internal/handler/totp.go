@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+// TOTP (RFC 6238) with the parameters every authenticator app supports:
+// HMAC-SHA1, 6 digits, 30-second steps.
+const (
+	totpPeriod = 30 * time.Second
+	// totpSkew is how many steps before/after the current one are accepted,
+	// to tolerate clock drift and slow typing.
+	totpSkew   = 1
+	totpIssuer = "Hermes"
+)
+
+var totpOpts = totp.ValidateOpts{
+	Period:    uint(totpPeriod / time.Second),
+	Digits:    otp.DigitsSix,
+	Algorithm: otp.AlgorithmSHA1,
+}
+
+// generateTOTPKey returns a new random 160-bit secret for account. The key
+// carries the base32 secret and the otpauth:// URL authenticator apps scan
+// as a QR code.
+func generateTOTPKey(account string) (*otp.Key, error) {
+	return totp.Generate(totp.GenerateOpts{
+		Issuer:      totpIssuer,
+		AccountName: account,
+		Period:      totpOpts.Period,
+		Digits:      totpOpts.Digits,
+		Algorithm:   totpOpts.Algorithm,
+	})
+}
+
+// validateTOTP reports whether code is valid for the base32 secret at now,
+// allowing ±totpSkew steps, and returns the time step it matched. Callers
+// record the step so a code cannot be used twice (see acceptTOTP).
+func validateTOTP(secret, code string, now time.Time) (int64, bool) {
+	for d := -totpSkew; d <= totpSkew; d++ {
+		t := now.Add(time.Duration(d) * totpPeriod)
+		if ok, err := totp.ValidateCustom(code, secret, t, totpOpts); err == nil && ok {
+			return t.Unix() / int64(totpOpts.Period), true
+		}
+	}
+	return 0, false
+}
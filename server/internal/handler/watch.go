@@ -3,6 +3,7 @@ package handler
 import (
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/jizhuozhi/hermes/server/internal/store"
 
@@ -19,8 +20,12 @@ func NewWatchHandler(s store.Store, logger *zap.SugaredLogger) *WatchHandler {
 }
 
 // WatchConfig implements long-poll: GET /api/v1/config/watch?revision=N
-// Returns changes since revision N. If no changes, blocks up to 30s.
-// Region is determined from context (X-Hermes-Region header).
+// Returns changes since revision N. ?kind=domain or ?kind=cluster (repeated
+// or comma-separated) narrows the events to those kinds; sync requests are
+// always included. Region is determined from context
+// (X-Hermes-Region header). The route runs under
+// server.timeouts.watch_write instead of the server's write timeout, so any
+// wait for changes must end before that.
 func (h *WatchHandler) WatchConfig(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
 	sinceStr := r.URL.Query().Get("revision")
@@ -34,7 +39,18 @@ func (h *WatchHandler) WatchConfig(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	events, maxRev, err := h.store.WatchFrom(r.Context(), region, since)
+	var kinds []string
+	for _, v := range r.URL.Query()["kind"] {
+		for _, kind := range strings.Split(v, ",") {
+			if kind != "domain" && kind != "cluster" {
+				ErrJSON(w, http.StatusBadRequest, "kind must be domain or cluster")
+				return
+			}
+			kinds = append(kinds, kind)
+		}
+	}
+
+	events, maxRev, err := h.store.WatchFrom(r.Context(), region, since, kinds)
 	if err != nil {
 		ErrJSON(w, http.StatusInternalServerError, err.Error())
 		return
@@ -47,6 +63,44 @@ func (h *WatchHandler) WatchConfig(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// ConfigChanges summarizes what changed since a revision the client has
+// seen: GET /api/v1/config/changes?since=N. Each changed domain or cluster
+// appears once as created, updated or deleted, with its current config. The
+// returned revision is the one to pass as since next time. Classification
+// relies on the retained change history; see store.ConfigChangesSince.
+func (h *WatchHandler) ConfigChanges(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	since, err := strconv.ParseInt(r.URL.Query().Get("since"), 10, 64)
+	if err != nil || since < 0 {
+		ErrJSON(w, http.StatusBadRequest, "since must be a non-negative revision")
+		return
+	}
+
+	// Read the revision first so a change landing in between is reported
+	// again next time rather than skipped.
+	rev, err := h.store.ConfigRevision(r.Context(), region)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	changes, err := h.store.ConfigChangesSince(r.Context(), region, since)
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if changes == nil {
+		changes = []store.ConfigChange{}
+	}
+	rev = max(rev, since)
+
+	JSON(w, http.StatusOK, map[string]any{
+		"since":    since,
+		"revision": rev,
+		"changes":  changes,
+		"total":    len(changes),
+	})
+}
+
 // GetRevision returns the current max revision: GET /api/v1/config/revision
 func (h *WatchHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
 	region := RegionFromContext(r.Context())
@@ -57,3 +111,17 @@ func (h *WatchHandler) GetRevision(w http.ResponseWriter, r *http.Request) {
 	}
 	JSON(w, http.StatusOK, map[string]any{"revision": rev})
 }
+
+// TriggerSync asks the region's controller to reconcile now instead of at
+// its next reconcile interval: POST /api/v1/config/trigger-sync. The
+// controller picks the request up from the watch stream on its next poll.
+func (h *WatchHandler) TriggerSync(w http.ResponseWriter, r *http.Request) {
+	region := RegionFromContext(r.Context())
+	rev, err := h.store.TriggerSync(r.Context(), region, Operator(r))
+	if err != nil {
+		ErrJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.logger.Infof("sync triggered (ns=%s, revision=%d) by %s", region, rev, Operator(r))
+	JSON(w, http.StatusAccepted, map[string]any{"revision": rev})
+}
@@ -12,6 +12,35 @@ type GatewayConfig struct {
 	InstanceRegistry InstanceRegistryConfig `json:"instance_registry"`
 }
 
+// SelectLabels returns a copy of c holding only the domains and clusters
+// whose labels include every key=value of selector.
+func (c *GatewayConfig) SelectLabels(selector map[string]string) *GatewayConfig {
+	out := *c
+	out.Domains = []DomainConfig{}
+	for _, d := range c.Domains {
+		if MatchLabels(d.Labels, selector) {
+			out.Domains = append(out.Domains, d)
+		}
+	}
+	out.Clusters = []ClusterConfig{}
+	for _, cl := range c.Clusters {
+		if MatchLabels(cl.Labels, selector) {
+			out.Clusters = append(out.Clusters, cl)
+		}
+	}
+	return &out
+}
+
+// MatchLabels reports whether labels include every key=value of selector.
+func MatchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
 type ConsulConfig struct {
 	Address          string  `json:"address"`
 	Datacenter       *string `json:"datacenter,omitempty"`
@@ -35,6 +64,32 @@ type DomainConfig struct {
 	Name   string        `json:"name"`
 	Hosts  []string      `json:"hosts"`
 	Routes []RouteConfig `json:"routes"`
+	// TLS enables HTTPS termination for the domain's hosts. Nil means
+	// plaintext only.
+	TLS *DomainTLSConfig `json:"tls,omitempty"`
+	// Labels are free-form organizational metadata (team, environment, ...).
+	// The gateway ignores them.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Owner (team name) and Contact (on-call handle) say who to page about
+	// the domain. Empty falls back to the region's defaults.
+	Owner   string `json:"owner,omitempty"`
+	Contact string `json:"contact,omitempty"`
+}
+
+// DomainTLSConfig configures TLS termination for a domain. The certificate is
+// referenced by name rather than inlined so that PEM material (and its private
+// key) never lives in config JSONB; the gateway serves it for SNI names
+// matching the domain's hosts.
+type DomainTLSConfig struct {
+	// CertificateRef names the secret holding the PEM certificate chain and key.
+	CertificateRef string `json:"certificate_ref"`
+	// MinVersion is the minimum TLS version: "1.2" (default) or "1.3".
+	MinVersion string `json:"min_version,omitempty"`
+	// ALPN lists application protocols to advertise, in preference order
+	// ("h2", "http/1.1"). Empty means the gateway default.
+	ALPN []string `json:"alpn,omitempty"`
+	// RequireSNI rejects handshakes that don't send a server name.
+	RequireSNI bool `json:"require_sni,omitempty"`
 }
 
 // RouteConfig references one or more clusters by name with weights.
@@ -53,10 +108,23 @@ type RouteConfig struct {
 	ResponseHeaderTransforms []HeaderTransform `json:"response_header_transforms,omitempty"`
 	MaxBodyBytes             *int64            `json:"max_body_bytes,omitempty"`
 	EnableCompression        bool              `json:"enable_compression"`
-	Status                   int               `json:"status"`
+	Status                   int               `json:"status"` // RouteStatusEnabled or RouteStatusDisabled
 	Plugins                  interface{}       `json:"plugins,omitempty"`
 }
 
+// Route status values. The gateway only serves routes whose status is
+// RouteStatusEnabled.
+const (
+	RouteStatusDisabled = 0
+	RouteStatusEnabled  = 1
+)
+
+// RouteStatuses maps each allowed route status to its meaning.
+var RouteStatuses = map[int]string{
+	RouteStatusDisabled: "disabled",
+	RouteStatusEnabled:  "enabled",
+}
+
 // HeaderMatcher defines a header matching condition for a route.
 // Multiple matchers on a route use AND semantics.
 type HeaderMatcher struct {
@@ -98,10 +166,19 @@ type ClusterConfig struct {
 	HealthCheck    *HealthCheckConfig    `json:"health_check,omitempty"`
 	Retry          *RetryConfig          `json:"retry,omitempty"`
 	CircuitBreaker *CircuitBreakerConfig `json:"circuit_breaker,omitempty"`
+	// Subset and Affinity describe node selection by node metadata. Hermes
+	// validates them and passes them to etcd unchanged, but the gateway does
+	// not act on them yet: it balances over every node whatever they say.
+	// Node metadata is only used today to filter discovered nodes
+	// (DiscoveryArgs.MetadataMatch).
+	Subset   *SubsetConfig   `json:"subset,omitempty"`
+	Affinity *AffinityConfig `json:"affinity,omitempty"`
 	// TLSVerify controls whether the gateway verifies upstream TLS certificates.
 	// Default false — typical for gateway scenarios where upstreams are internal
 	// services using self-signed or private CA certificates.
 	TLSVerify bool `json:"tls_verify"`
+	// Labels are free-form organizational metadata, as on DomainConfig.
+	Labels map[string]string `json:"labels,omitempty"`
 }
 
 type TimeoutConfig struct {
@@ -121,6 +198,39 @@ type DiscoveryArgs struct {
 	MetadataMatch map[string][]string `json:"metadata_match"`
 }
 
+// Subset fallback policies, for requests no subset matches.
+const (
+	SubsetFallbackAny     = "any"     // balance over every node
+	SubsetFallbackDefault = "default" // use DefaultSubset
+	SubsetFallbackNone    = "none"    // fail the request
+)
+
+// SubsetConfig describes routing requests to the nodes whose metadata
+// matches values taken from the request, e.g. a version header for
+// canary-by-version. Not yet implemented by the gateway; see
+// ClusterConfig.Subset.
+type SubsetConfig struct {
+	// Selectors are the metadata key sets subsets are built on, tried in
+	// order, e.g. [["version", "zone"], ["version"]].
+	Selectors [][]string `json:"selectors"`
+	// Headers maps a selector key to the request header carrying its value.
+	Headers map[string]string `json:"headers"`
+	// Fallback is one of the SubsetFallback policies; empty means "any".
+	Fallback      string            `json:"fallback,omitempty"`
+	DefaultSubset map[string]string `json:"default_subset,omitempty"`
+}
+
+// AffinityConfig describes preferring nodes that share the gateway's own
+// value of a metadata key, e.g. its zone, spilling over to the rest when too
+// few of them are healthy. Not yet implemented by the gateway; see
+// ClusterConfig.Subset.
+type AffinityConfig struct {
+	Key string `json:"key"`
+	// MinHealthyPercent of the preferred nodes below which traffic spills
+	// over; 0 only spills when none are healthy.
+	MinHealthyPercent int `json:"min_healthy_percent,omitempty"`
+}
+
 type KeepalivePoolConfig struct {
 	IdleTimeout int `json:"idle_timeout"`
 	Requests    int `json:"requests"`
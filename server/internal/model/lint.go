@@ -0,0 +1,102 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Lint findings are opinionated best-practice checks layered on top of
+// validation. Validation errors are reported as rule "invalid" with severity
+// error; the lint rules themselves only warn.
+
+// Lint severities.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// Lint rule IDs.
+const (
+	RuleInvalid       = "invalid"
+	RuleNoCatchAll    = "no-catch-all"
+	RuleSingleNode    = "single-node"
+	RuleShortTimeout  = "short-timeout"
+	RuleWildcardHost  = "wildcard-host"
+	RuleUnusedCluster = "unused-cluster"
+)
+
+// Timeouts below these (in seconds) are flagged by RuleShortTimeout.
+const (
+	minConnectTimeout = 0.1
+	minReadTimeout    = 1.0
+)
+
+// LintFinding is one issue reported by LintConfig.
+type LintFinding struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	Field    string `json:"field"`
+	Message  string `json:"message"`
+}
+
+// LintConfig validates cfg and then runs the lint rules over it. Validation
+// errors come first, as RuleInvalid findings.
+func LintConfig(cfg *GatewayConfig) []LintFinding {
+	var findings []LintFinding
+	for _, e := range ValidateConfig(cfg) {
+		findings = append(findings, LintFinding{RuleInvalid, SeverityError, e.Field, e.Message})
+	}
+	warn := func(rule, field, msg string) {
+		findings = append(findings, LintFinding{rule, SeverityWarning, field, msg})
+	}
+
+	used := make(map[string]bool)
+	for i, d := range cfg.Domains {
+		prefix := fmt.Sprintf("domains[%d]", i)
+
+		for j, host := range d.Hosts {
+			if strings.Contains(host, "*") {
+				warn(RuleWildcardHost, fmt.Sprintf("%s.hosts[%d]", prefix, j),
+					fmt.Sprintf("wildcard host %q matches names nobody reviewed; list hosts explicitly", host))
+			}
+		}
+
+		catchAll := false
+		for _, r := range d.Routes {
+			if r.URI == "/*" && len(r.Methods) == 0 && len(r.Headers) == 0 {
+				catchAll = true
+			}
+			for _, wc := range r.Clusters {
+				used[wc.Name] = true
+			}
+		}
+		if len(d.Routes) > 0 && !catchAll {
+			warn(RuleNoCatchAll, prefix+".routes",
+				"no catch-all route (uri /* without method or header matchers): unmatched requests get 404")
+		}
+	}
+
+	for i, c := range cfg.Clusters {
+		prefix := fmt.Sprintf("clusters[%d]", i)
+
+		if c.DiscoveryType == nil && len(c.Nodes) == 1 {
+			warn(RuleSingleNode, prefix+".nodes", "single node: no redundancy if it goes down")
+		}
+		if c.Timeout.Connect > 0 && c.Timeout.Connect < minConnectTimeout {
+			warn(RuleShortTimeout, prefix+".timeout.connect",
+				fmt.Sprintf("connect timeout %gs is below %gs", c.Timeout.Connect, minConnectTimeout))
+		}
+		if c.Timeout.Read > 0 && c.Timeout.Read < minReadTimeout {
+			warn(RuleShortTimeout, prefix+".timeout.read",
+				fmt.Sprintf("read timeout %gs is below %gs", c.Timeout.Read, minReadTimeout))
+		}
+		if c.Timeout.Send > 0 && c.Timeout.Send < minReadTimeout {
+			warn(RuleShortTimeout, prefix+".timeout.send",
+				fmt.Sprintf("send timeout %gs is below %gs", c.Timeout.Send, minReadTimeout))
+		}
+		if c.Name != "" && !used[c.Name] {
+			warn(RuleUnusedCluster, prefix, fmt.Sprintf("cluster %q is not referenced by any route", c.Name))
+		}
+	}
+	return findings
+}
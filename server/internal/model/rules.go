@@ -0,0 +1,274 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Rules are site-specific checks declared in the server config rather than
+// written in Go: each names a field of a domain or cluster (by its JSON
+// path), an operator and a value. They run on writes and in LintConfig;
+// failing an error rule rejects a write, failing a warning rule is
+// reported alongside it.
+
+// Rule operators.
+const (
+	RuleOpExists = "exists" // field is set and non-empty
+	RuleOpMin    = "min"    // number >= value; string, list or map length >= value
+	RuleOpEquals = "equals" // field equals value
+	RuleOpRegex  = "regex"  // string field (or every string in a list) matches value
+)
+
+// Rule is one configured check.
+type Rule struct {
+	// Name identifies the rule in findings.
+	Name string `yaml:"name" json:"name"`
+	// Kind is "domain" or "cluster".
+	Kind string `yaml:"kind" json:"kind"`
+	// Field is a dot-separated JSON path, e.g. "tls.certificate_ref" or
+	// "timeout.read". A list along the path applies the rest of the path to
+	// each element: "routes.uri" checks every route's uri.
+	Field string `yaml:"field" json:"field"`
+	// Op is one of exists, min, equals or regex.
+	Op string `yaml:"op" json:"op"`
+	// Value is the operand; exists takes none.
+	Value any `yaml:"value" json:"value,omitempty"`
+	// Severity is "error" (reject the write) or "warning" (default).
+	Severity string `yaml:"severity" json:"severity"`
+	// Regions limits the rule to these regions; empty applies everywhere.
+	Regions []string `yaml:"regions" json:"regions,omitempty"`
+	// Message replaces the generated finding message.
+	Message string `yaml:"message" json:"message,omitempty"`
+
+	re *regexp.Regexp // compiled Value for regex, set by Validate
+}
+
+// Validate checks the rule's definition and applies defaults.
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if r.Kind != "domain" && r.Kind != "cluster" {
+		return fmt.Errorf("rule %q: kind must be domain or cluster", r.Name)
+	}
+	if r.Field == "" || slices.Contains(strings.Split(r.Field, "."), "") {
+		return fmt.Errorf("rule %q: field must be a dot-separated path", r.Name)
+	}
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityWarning
+	case SeverityError, SeverityWarning:
+	default:
+		return fmt.Errorf("rule %q: severity must be error or warning", r.Name)
+	}
+	switch r.Op {
+	case RuleOpExists:
+		if r.Value != nil {
+			return fmt.Errorf("rule %q: exists takes no value", r.Name)
+		}
+	case RuleOpMin:
+		if _, ok := ruleNumber(r.Value); !ok {
+			return fmt.Errorf("rule %q: min needs a numeric value", r.Name)
+		}
+	case RuleOpEquals:
+		if r.Value == nil {
+			return fmt.Errorf("rule %q: equals needs a value", r.Name)
+		}
+		if _, err := json.Marshal(r.Value); err != nil {
+			return fmt.Errorf("rule %q: value: %w", r.Name, err)
+		}
+	case RuleOpRegex:
+		pattern, ok := r.Value.(string)
+		if !ok {
+			return fmt.Errorf("rule %q: regex needs a string value", r.Name)
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("rule %q: %w", r.Name, err)
+		}
+		r.re = re
+	default:
+		return fmt.Errorf("rule %q: op must be exists, min, equals or regex", r.Name)
+	}
+	return nil
+}
+
+// appliesTo reports whether the rule checks resources of kind in region.
+func (r Rule) appliesTo(kind, region string) bool {
+	return r.Kind == kind && (len(r.Regions) == 0 || slices.Contains(r.Regions, region))
+}
+
+// EvaluateRules checks every domain and cluster of cfg against the rules
+// that apply in region.
+func EvaluateRules(rules []Rule, region string, cfg *GatewayConfig) []LintFinding {
+	var findings []LintFinding
+	for i := range cfg.Domains {
+		findings = append(findings, evaluateRules(rules, "domain", region, fmt.Sprintf("domains[%d]", i), &cfg.Domains[i])...)
+	}
+	for i := range cfg.Clusters {
+		findings = append(findings, evaluateRules(rules, "cluster", region, fmt.Sprintf("clusters[%d]", i), &cfg.Clusters[i])...)
+	}
+	return findings
+}
+
+// EvaluateDomainRules checks one domain against the rules that apply in
+// region.
+func EvaluateDomainRules(rules []Rule, region string, d *DomainConfig) []LintFinding {
+	return evaluateRules(rules, "domain", region, "", d)
+}
+
+// EvaluateClusterRules checks one cluster against the rules that apply in
+// region.
+func EvaluateClusterRules(rules []Rule, region string, c *ClusterConfig) []LintFinding {
+	return evaluateRules(rules, "cluster", region, "", c)
+}
+
+func evaluateRules(rules []Rule, kind, region, prefix string, resource any) []LintFinding {
+	if !slices.ContainsFunc(rules, func(r Rule) bool { return r.appliesTo(kind, region) }) {
+		return nil
+	}
+	// Rules address fields by their JSON names, as API clients see them.
+	var doc any
+	data, err := json.Marshal(resource)
+	if err != nil || json.Unmarshal(data, &doc) != nil {
+		return nil
+	}
+
+	var findings []LintFinding
+	for i := range rules {
+		r := &rules[i]
+		if !r.appliesTo(kind, region) {
+			continue
+		}
+		for _, v := range resolvePath(doc, strings.Split(r.Field, "."), prefix) {
+			if ok, why := r.check(v.value, v.present); !ok {
+				msg := r.Message
+				if msg == "" {
+					msg = fmt.Sprintf("%s %s", r.Field, why)
+				}
+				findings = append(findings, LintFinding{r.Name, r.Severity, v.path, msg})
+			}
+		}
+	}
+	return findings
+}
+
+// pathValue is one value a rule path resolved to.
+type pathValue struct {
+	path    string // e.g. "routes[1].uri", under the caller's prefix
+	value   any
+	present bool
+}
+
+// resolvePath walks doc along segs, fanning out over lists before the last
+// segment. A missing key yields a single absent value.
+func resolvePath(doc any, segs []string, path string) []pathValue {
+	if len(segs) == 0 {
+		return []pathValue{{path, doc, doc != nil}}
+	}
+	join := func(seg string) string {
+		if path == "" {
+			return seg
+		}
+		return path + "." + seg
+	}
+	switch v := doc.(type) {
+	case map[string]any:
+		next, ok := v[segs[0]]
+		if !ok {
+			return []pathValue{{join(strings.Join(segs, ".")), nil, false}}
+		}
+		return resolvePath(next, segs[1:], join(segs[0]))
+	case []any:
+		var out []pathValue
+		for i, elem := range v {
+			out = append(out, resolvePath(elem, segs, fmt.Sprintf("%s[%d]", path, i))...)
+		}
+		return out
+	default:
+		return []pathValue{{join(strings.Join(segs, ".")), nil, false}}
+	}
+}
+
+// check applies the rule's operator to v, returning why it failed.
+func (r *Rule) check(v any, present bool) (bool, string) {
+	switch r.Op {
+	case RuleOpExists:
+		return present && !ruleEmpty(v), "must be set"
+	case RuleOpMin:
+		want, _ := ruleNumber(r.Value)
+		var got float64
+		switch x := v.(type) {
+		case float64:
+			got = x
+		case string:
+			got = float64(len(x))
+		case []any:
+			got = float64(len(x))
+		case map[string]any:
+			got = float64(len(x))
+		}
+		return present && got >= want, fmt.Sprintf("must be at least %g", want)
+	case RuleOpEquals:
+		// Compare in JSON form, so a YAML 2 equals a JSON 2.0.
+		data, _ := json.Marshal(r.Value)
+		var want any
+		_ = json.Unmarshal(data, &want)
+		return present && reflect.DeepEqual(v, want), fmt.Sprintf("must equal %s", data)
+	case RuleOpRegex:
+		re := r.re
+		if re == nil {
+			// Not validated; config.Load always validates.
+			pattern, _ := r.Value.(string)
+			var err error
+			if re, err = regexp.Compile(pattern); err != nil {
+				return false, "has an invalid rule pattern"
+			}
+		}
+		why := fmt.Sprintf("must match %s", re)
+		if list, ok := v.([]any); ok {
+			for _, elem := range list {
+				if s, ok := elem.(string); !ok || !re.MatchString(s) {
+					return false, why
+				}
+			}
+			return true, ""
+		}
+		s, ok := v.(string)
+		return ok && re.MatchString(s), why
+	}
+	return true, ""
+}
+
+func ruleEmpty(v any) bool {
+	switch x := v.(type) {
+	case nil:
+		return true
+	case string:
+		return x == ""
+	case []any:
+		return len(x) == 0
+	case map[string]any:
+		return len(x) == 0
+	}
+	return false
+}
+
+// ruleNumber converts a YAML or JSON number to float64.
+func ruleNumber(v any) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case uint64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
@@ -2,7 +2,12 @@ package model
 
 import (
 	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
 	"strings"
+	"unicode"
 )
 
 type ValidationError struct {
@@ -36,6 +41,7 @@ func ValidateDomains(domains []DomainConfig, clusterNames map[string]bool) []Val
 	var errs []ValidationError
 
 	seen := make(map[string]bool)
+	hostOwner := make(map[string]string) // host → first domain claiming it
 	for i, d := range domains {
 		prefix := fmt.Sprintf("domains[%d]", i)
 
@@ -55,9 +61,30 @@ func ValidateDomains(domains []DomainConfig, clusterNames map[string]bool) []Val
 				errs = append(errs, ValidationError{
 					fmt.Sprintf("%s.hosts[%d]", prefix, j), "empty host",
 				})
+				continue
+			}
+			host = NormalizeHost(host)
+			if msg := validateHost(host); msg != "" {
+				errs = append(errs, ValidationError{
+					fmt.Sprintf("%s.hosts[%d]", prefix, j), fmt.Sprintf("invalid host %q: %s", d.Hosts[j], msg),
+				})
+				continue
+			}
+			if owner, ok := hostOwner[host]; ok && owner != d.Name {
+				errs = append(errs, ValidationError{
+					fmt.Sprintf("%s.hosts[%d]", prefix, j), fmt.Sprintf("host %q is already claimed by domain %q", host, owner),
+				})
+			} else {
+				hostOwner[host] = d.Name
 			}
 		}
 
+		if d.TLS != nil {
+			errs = append(errs, validateDomainTLS(d.TLS, prefix+".tls")...)
+		}
+		errs = append(errs, validateLabels(d.Labels, prefix+".labels")...)
+		errs = append(errs, ValidateOwnership(d.Owner, d.Contact, prefix)...)
+
 		routePrefix := fmt.Sprintf("%s.routes", prefix)
 		errs = append(errs, ValidateRoutes(d.Routes, clusterNames, routePrefix)...)
 	}
@@ -65,6 +92,156 @@ func ValidateDomains(domains []DomainConfig, clusterNames map[string]bool) []Val
 	return errs
 }
 
+// CatchAllHost is the host of the fallback domain, matched when no other
+// host does.
+const CatchAllHost = "_"
+
+// hostLabelRe matches one DNS label.
+var hostLabelRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// NormalizeHost returns host in the form the gateway matches on: lowercase,
+// without a trailing dot.
+func NormalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+// NormalizeHosts normalizes the domain's hosts in place (see NormalizeHost).
+// Validation accepts either form; callers normalize before storing.
+func NormalizeHosts(d *DomainConfig) {
+	for i, h := range d.Hosts {
+		d.Hosts[i] = NormalizeHost(h)
+	}
+}
+
+// NormalizeConfigHosts applies NormalizeHosts to every domain in cfg.
+func NormalizeConfigHosts(cfg *GatewayConfig) {
+	for i := range cfg.Domains {
+		NormalizeHosts(&cfg.Domains[i])
+	}
+}
+
+// validateHost checks a normalized host and returns why it is invalid, or
+// "". Besides plain names, a host may be CatchAllHost or have a wildcard as
+// its whole first label ("*.example.com") or whole last label ("api.*").
+func validateHost(host string) string {
+	if host == CatchAllHost {
+		return ""
+	}
+	if len(host) > 253 {
+		return "longer than 253 characters"
+	}
+	labels := strings.Split(host, ".")
+	if len(labels) == 1 && labels[0] == "*" {
+		return fmt.Sprintf("a wildcard needs at least one other label; use %q for a catch-all", CatchAllHost)
+	}
+	for i, label := range labels {
+		if label == "*" {
+			if i != 0 && i != len(labels)-1 {
+				return "a wildcard may only be the first or last label"
+			}
+			if i == len(labels)-1 && labels[0] == "*" {
+				return "only one wildcard label is allowed"
+			}
+			continue
+		}
+		if strings.Contains(label, "*") {
+			return "a wildcard must be a whole label, e.g. *.example.com"
+		}
+		if label == "" {
+			return "empty label"
+		}
+		if !hostLabelRe.MatchString(label) {
+			return fmt.Sprintf("label %q must be 1-63 letters, digits or hyphens, not starting or ending with a hyphen", label)
+		}
+	}
+	return ""
+}
+
+// secretRefRe matches names usable as secret references (lowercase DNS-label style).
+var secretRefRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9.-]{0,251}[a-z0-9])?$`)
+
+// ValidSecretName reports whether name can be used as a secret name and
+// therefore be referenced from config.
+func ValidSecretName(name string) bool {
+	return secretRefRe.MatchString(name)
+}
+
+func validateDomainTLS(t *DomainTLSConfig, prefix string) []ValidationError {
+	var errs []ValidationError
+	if t.CertificateRef == "" {
+		errs = append(errs, ValidationError{prefix + ".certificate_ref", "required"})
+	} else if !ValidSecretName(t.CertificateRef) {
+		errs = append(errs, ValidationError{prefix + ".certificate_ref", "must be a secret name (lowercase alphanumerics, '-' or '.')"})
+	}
+	switch t.MinVersion {
+	case "", "1.2", "1.3":
+		// valid
+	default:
+		errs = append(errs, ValidationError{prefix + ".min_version", "must be '1.2' or '1.3'"})
+	}
+	seen := make(map[string]bool)
+	for i, p := range t.ALPN {
+		ap := fmt.Sprintf("%s.alpn[%d]", prefix, i)
+		switch {
+		case p != "h2" && p != "http/1.1":
+			errs = append(errs, ValidationError{ap, "must be 'h2' or 'http/1.1'"})
+		case seen[p]:
+			errs = append(errs, ValidationError{ap, fmt.Sprintf("duplicate protocol: %s", p)})
+		}
+		seen[p] = true
+	}
+	return errs
+}
+
+// Label limits. Keys and values share one charset; values may be empty.
+const (
+	maxLabels        = 32
+	maxLabelKeyLen   = 63
+	maxLabelValueLen = 63
+)
+
+var labelRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+func validateLabels(labels map[string]string, prefix string) []ValidationError {
+	var errs []ValidationError
+	if len(labels) > maxLabels {
+		errs = append(errs, ValidationError{prefix, fmt.Sprintf("at most %d labels allowed", maxLabels)})
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := labels[k]
+		lp := fmt.Sprintf("%s[%s]", prefix, k)
+		if len(k) > maxLabelKeyLen || !labelRe.MatchString(k) {
+			errs = append(errs, ValidationError{lp, fmt.Sprintf("key must be 1-%d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", maxLabelKeyLen)})
+		}
+		if len(v) > maxLabelValueLen || (v != "" && !labelRe.MatchString(v)) {
+			errs = append(errs, ValidationError{lp, fmt.Sprintf("value must be at most %d alphanumerics, '-', '_' or '.', starting and ending with an alphanumeric", maxLabelValueLen)})
+		}
+	}
+	return errs
+}
+
+// maxOwnershipLen caps owner and contact strings.
+const maxOwnershipLen = 128
+
+// ValidateOwnership checks owner/contact metadata: free text, but short and
+// on one line. Fields are reported as prefix.owner and prefix.contact.
+func ValidateOwnership(owner, contact, prefix string) []ValidationError {
+	var errs []ValidationError
+	for _, f := range []struct{ name, value string }{{"owner", owner}, {"contact", contact}} {
+		if len(f.value) > maxOwnershipLen {
+			errs = append(errs, ValidationError{prefix + "." + f.name, fmt.Sprintf("must be at most %d characters", maxOwnershipLen)})
+		} else if strings.ContainsFunc(f.value, unicode.IsControl) {
+			errs = append(errs, ValidationError{prefix + "." + f.name, "must not contain control characters"})
+		}
+	}
+	return errs
+}
+
 // ValidateDomain validates a single domain config.
 func ValidateDomain(d *DomainConfig, clusterNames map[string]bool) []ValidationError {
 	return ValidateDomains([]DomainConfig{*d}, clusterNames)
@@ -96,7 +273,9 @@ func ValidateRoutes(routes []RouteConfig, clusterNames map[string]bool, pathPref
 			errs = append(errs, ValidationError{prefix + ".clusters", "at least one cluster reference is required"})
 		}
 
+		totalWeight := 0
 		for j, wc := range r.Clusters {
+			totalWeight += max(wc.Weight, 0)
 			cp := fmt.Sprintf("%s.clusters[%d]", prefix, j)
 			if wc.Name == "" {
 				errs = append(errs, ValidationError{cp + ".name", "required"})
@@ -107,6 +286,9 @@ func ValidateRoutes(routes []RouteConfig, clusterNames map[string]bool, pathPref
 				errs = append(errs, ValidationError{cp + ".weight", "must be >= 0"})
 			}
 		}
+		if len(r.Clusters) > 0 && totalWeight == 0 {
+			errs = append(errs, ValidationError{prefix + ".clusters", "at least one cluster must have weight > 0"})
+		}
 
 		// Validate header matchers
 		for j, h := range r.Headers {
@@ -175,11 +357,104 @@ func ValidateRoutes(routes []RouteConfig, clusterNames map[string]bool, pathPref
 			errs = append(errs, ValidationError{prefix + ".max_body_bytes", "must be >= 0"})
 		}
 
-		if r.Status != 0 && r.Status != 1 {
-			errs = append(errs, ValidationError{prefix + ".status", "must be 0 or 1"})
+		if _, ok := RouteStatuses[r.Status]; !ok {
+			errs = append(errs, ValidationError{prefix + ".status", fmt.Sprintf("must be 0 or 1 (%d = disabled, %d = enabled), got %d",
+				RouteStatusDisabled, RouteStatusEnabled, r.Status)})
+		}
+	}
+
+	return errs
+}
+
+// NormalizeRouteWeights rescales each route's cluster weights in place so
+// they sum to 100, keeping their proportions (largest-remainder rounding).
+// Routes whose weights are all zero or negative are left untouched; callers
+// validate first.
+func NormalizeRouteWeights(d *DomainConfig) {
+	for i := range d.Routes {
+		normalizeWeights(d.Routes[i].Clusters)
+	}
+}
+
+func normalizeWeights(clusters []WeightedCluster) {
+	total := 0
+	for _, wc := range clusters {
+		if wc.Weight < 0 {
+			return
+		}
+		total += wc.Weight
+	}
+	if total == 0 || total == 100 {
+		return
+	}
+
+	assigned := 0
+	rem := make([]int, len(clusters))
+	for i := range clusters {
+		scaled := clusters[i].Weight * 100
+		clusters[i].Weight = scaled / total
+		rem[i] = scaled % total
+		assigned += clusters[i].Weight
+	}
+	// Hand the leftover points to the largest remainders; ties go to the
+	// earlier cluster so the result is deterministic.
+	for ; assigned < 100; assigned++ {
+		best := 0
+		for i := range rem {
+			if rem[i] > rem[best] {
+				best = i
+			}
 		}
+		clusters[best].Weight++
+		rem[best] = -1
 	}
+}
 
+// LBTypes are the cluster load-balancer types the gateway implements,
+// aliases included. The gateway falls back to round robin for anything else,
+// so an unknown type is rejected here rather than silently changing
+// behaviour there.
+var LBTypes = []string{"roundrobin", "random", "weighted_random", "least_request", "least_conn", "peak_ewma", "ewma"}
+
+func validateSubset(s *SubsetConfig, prefix string) []ValidationError {
+	var errs []ValidationError
+	if len(s.Selectors) == 0 {
+		errs = append(errs, ValidationError{prefix + ".selectors", "at least one selector is required"})
+	}
+	keys := make(map[string]bool)
+	for i, sel := range s.Selectors {
+		if len(sel) == 0 {
+			errs = append(errs, ValidationError{fmt.Sprintf("%s.selectors[%d]", prefix, i), "must list at least one key"})
+		}
+		for _, k := range sel {
+			if k == "" {
+				errs = append(errs, ValidationError{fmt.Sprintf("%s.selectors[%d]", prefix, i), "keys must not be empty"})
+			}
+			keys[k] = true
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(keys)) {
+		if k != "" && s.Headers[k] == "" {
+			errs = append(errs, ValidationError{prefix + ".headers", fmt.Sprintf("no header for selector key %q", k)})
+		}
+	}
+	for _, k := range slices.Sorted(maps.Keys(s.Headers)) {
+		if !keys[k] {
+			errs = append(errs, ValidationError{prefix + ".headers", fmt.Sprintf("key %q is not in any selector", k)})
+		}
+	}
+	switch s.Fallback {
+	case "", SubsetFallbackAny, SubsetFallbackNone:
+		if len(s.DefaultSubset) > 0 {
+			errs = append(errs, ValidationError{prefix + ".default_subset", "only allowed with fallback 'default'"})
+		}
+	case SubsetFallbackDefault:
+		if len(s.DefaultSubset) == 0 {
+			errs = append(errs, ValidationError{prefix + ".default_subset", "required when fallback is 'default'"})
+		}
+	default:
+		errs = append(errs, ValidationError{prefix + ".fallback", "must be 'any', 'default', or 'none'"})
+	}
 	return errs
 }
 
@@ -201,6 +476,8 @@ func ValidateClusters(clusters []ClusterConfig) []ValidationError {
 
 		if c.LBType == "" {
 			errs = append(errs, ValidationError{prefix + ".type", "required"})
+		} else if !slices.Contains(LBTypes, c.LBType) {
+			errs = append(errs, ValidationError{prefix + ".type", fmt.Sprintf("unsupported type %q; must be one of: %s", c.LBType, strings.Join(LBTypes, ", "))})
 		}
 
 		switch c.Scheme {
@@ -220,6 +497,26 @@ func ValidateClusters(clusters []ClusterConfig) []ValidationError {
 		if c.PassHost == "rewrite" && (c.UpstreamHost == nil || *c.UpstreamHost == "") {
 			errs = append(errs, ValidationError{prefix + ".upstream_host", "required when pass_host is 'rewrite'"})
 		}
+		errs = append(errs, validateLabels(c.Labels, prefix+".labels")...)
+		for j, n := range c.Nodes {
+			for k := range n.Metadata {
+				if k == "" {
+					errs = append(errs, ValidationError{fmt.Sprintf("%s.nodes[%d].metadata", prefix, j), "keys must not be empty"})
+					break
+				}
+			}
+		}
+		if c.Subset != nil {
+			errs = append(errs, validateSubset(c.Subset, prefix+".subset")...)
+		}
+		if c.Affinity != nil {
+			if c.Affinity.Key == "" {
+				errs = append(errs, ValidationError{prefix + ".affinity.key", "required"})
+			}
+			if c.Affinity.MinHealthyPercent < 0 || c.Affinity.MinHealthyPercent > 100 {
+				errs = append(errs, ValidationError{prefix + ".affinity.min_healthy_percent", "must be 0-100"})
+			}
+		}
 
 		hasStatic := len(c.Nodes) > 0
 		hasDiscovery := c.DiscoveryType != nil && c.ServiceName != nil
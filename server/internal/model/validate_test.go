@@ -1,6 +1,9 @@
 package model
 
 import (
+	"encoding/json"
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -56,6 +59,48 @@ func TestValidateDomain_EmptyHost(t *testing.T) {
 	assert.Contains(t, errs[0].Message, "empty host")
 }
 
+func TestValidateDomain_Hosts(t *testing.T) {
+	route := []RouteConfig{{Name: "r1", URI: "/", Clusters: []WeightedCluster{{Name: "c", Weight: 1}}}}
+	valid := []string{"api.example.com", "API.Example.com.", "*.example.com", "api.*", "_", "intranet", "10.0.0.1", "a-b.example.com"}
+	for _, host := range valid {
+		errs := ValidateDomain(&DomainConfig{Name: "api", Hosts: []string{host}, Routes: route}, nil)
+		assert.Empty(t, errs, host)
+	}
+
+	invalid := map[string]string{
+		"*.*.com":              "first or last label",
+		"foo.*.com":            "first or last label",
+		"*foo.example.com":     "whole label",
+		"*.*":                  "only one wildcard",
+		"*":                    "catch-all",
+		"a..example.com":       "empty label",
+		"-api.example.com":     "label",
+		"api.example.com:8080": "label",
+		"api_v2.example.com":   "label",
+	}
+	for host, msg := range invalid {
+		errs := ValidateDomain(&DomainConfig{Name: "api", Hosts: []string{host}, Routes: route}, nil)
+		require.Len(t, errs, 1, host)
+		assert.Contains(t, errs[0].Message, "invalid host", host)
+		assert.Contains(t, errs[0].Message, msg, host)
+	}
+}
+
+func TestNormalizeHosts(t *testing.T) {
+	d := &DomainConfig{Hosts: []string{"API.Example.COM.", "*.Example.com", "_"}}
+	NormalizeHosts(d)
+	assert.Equal(t, []string{"api.example.com", "*.example.com", "_"}, d.Hosts)
+
+	// Hosts differing only in case or a trailing dot are the same host.
+	route := []RouteConfig{{Name: "r1", URI: "/", Clusters: []WeightedCluster{{Name: "c", Weight: 1}}}}
+	errs := ValidateDomains([]DomainConfig{
+		{Name: "api", Hosts: []string{"shared.com"}, Routes: route},
+		{Name: "web", Hosts: []string{"Shared.com."}, Routes: route},
+	}, nil)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Message, "already claimed")
+}
+
 // ValidateRoutes Tests
 func TestValidateRoutes_MissingRouteName(t *testing.T) {
 	routes := []RouteConfig{
@@ -233,6 +278,15 @@ func TestValidateRoutes_InvalidStatus(t *testing.T) {
 	errs := ValidateRoutes(routes, nil, "routes")
 	require.NotEmpty(t, errs)
 	assert.Contains(t, errs[0].Message, "0 or 1")
+	assert.Equal(t, "routes[0].status", errs[0].Field)
+
+	routes[0].Status = -1
+	errs = ValidateRoutes(routes, nil, "routes")
+	require.NotEmpty(t, errs)
+	assert.Contains(t, errs[0].Message, "got -1")
+
+	routes[0].Status = RouteStatusDisabled
+	assert.Empty(t, ValidateRoutes(routes, nil, "routes"))
 }
 
 // ValidateCluster Tests
@@ -272,6 +326,68 @@ func TestValidateCluster_MissingLBType(t *testing.T) {
 	assert.Contains(t, errs[0].Field, "type")
 }
 
+func TestValidateCluster_UnknownLBType(t *testing.T) {
+	c := &ClusterConfig{
+		Name:    "backend",
+		LBType:  "roundrobbin",
+		Timeout: TimeoutConfig{Connect: 1, Read: 1},
+		Nodes:   []UpstreamNode{{Host: "10.0.0.1", Port: 8080, Weight: 100}},
+	}
+	errs := ValidateCluster(c)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "clusters[0].type", errs[0].Field)
+	assert.Contains(t, errs[0].Message, "roundrobin, random")
+
+	for _, lb := range LBTypes {
+		c.LBType = lb
+		assert.Empty(t, ValidateCluster(c), lb)
+	}
+}
+
+func TestValidateCluster_SubsetAndAffinity(t *testing.T) {
+	c := &ClusterConfig{
+		Name:    "backend",
+		LBType:  "roundrobin",
+		Timeout: TimeoutConfig{Connect: 1, Read: 1},
+		Nodes: []UpstreamNode{
+			{Host: "10.0.0.1", Port: 8080, Weight: 100, Metadata: map[string]string{"zone": "a", "version": "v1"}},
+			{Host: "10.0.0.2", Port: 8080, Weight: 100, Metadata: map[string]string{"zone": "b", "version": "v2"}},
+		},
+		Subset: &SubsetConfig{
+			Selectors:     [][]string{{"version"}},
+			Headers:       map[string]string{"version": "x-canary-version"},
+			Fallback:      SubsetFallbackDefault,
+			DefaultSubset: map[string]string{"version": "v1"},
+		},
+		Affinity: &AffinityConfig{Key: "zone", MinHealthyPercent: 50},
+	}
+	assert.Empty(t, ValidateCluster(c))
+
+	c.Subset = &SubsetConfig{
+		Selectors: [][]string{{}},
+		Headers:   map[string]string{"zone": "x-zone"},
+		Fallback:  "nearest",
+	}
+	c.Affinity = &AffinityConfig{MinHealthyPercent: 101}
+	fields := map[string]bool{}
+	for _, e := range ValidateCluster(c) {
+		fields[e.Field] = true
+	}
+	assert.Equal(t, map[string]bool{
+		"clusters[0].subset.selectors[0]":          true,
+		"clusters[0].subset.headers":               true,
+		"clusters[0].subset.fallback":              true,
+		"clusters[0].affinity.key":                 true,
+		"clusters[0].affinity.min_healthy_percent": true,
+	}, fields)
+
+	c.Subset = &SubsetConfig{Selectors: [][]string{{"version"}}, Headers: map[string]string{"version": "x-v"}, Fallback: SubsetFallbackDefault}
+	c.Affinity = nil
+	errs := ValidateCluster(c)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "clusters[0].subset.default_subset", errs[0].Field)
+}
+
 func TestValidateCluster_NoNodesOrDiscovery(t *testing.T) {
 	c := &ClusterConfig{
 		Name:    "backend",
@@ -377,6 +493,50 @@ func TestValidateDomains_DuplicateName(t *testing.T) {
 	assert.Contains(t, errs[0].Message, "duplicate")
 }
 
+func TestValidateDomain_TLS(t *testing.T) {
+	domain := func(tls *DomainTLSConfig) *DomainConfig {
+		return &DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, TLS: tls}
+	}
+	assert.Empty(t, ValidateDomain(domain(nil), nil))
+	assert.Empty(t, ValidateDomain(domain(&DomainTLSConfig{CertificateRef: "api-example-com"}), nil))
+	assert.Empty(t, ValidateDomain(domain(&DomainTLSConfig{
+		CertificateRef: "wildcard.example.com", MinVersion: "1.3", ALPN: []string{"h2", "http/1.1"}, RequireSNI: true,
+	}), nil))
+
+	tests := []struct {
+		field string
+		tls   DomainTLSConfig
+	}{
+		{"domains[0].tls.certificate_ref", DomainTLSConfig{}},
+		{"domains[0].tls.certificate_ref", DomainTLSConfig{CertificateRef: "-----BEGIN CERTIFICATE-----"}},
+		{"domains[0].tls.min_version", DomainTLSConfig{CertificateRef: "c", MinVersion: "1.0"}},
+		{"domains[0].tls.alpn[0]", DomainTLSConfig{CertificateRef: "c", ALPN: []string{"spdy/3"}}},
+		{"domains[0].tls.alpn[1]", DomainTLSConfig{CertificateRef: "c", ALPN: []string{"h2", "h2"}}},
+	}
+	for _, tt := range tests {
+		errs := ValidateDomain(domain(&tt.tls), nil)
+		require.Len(t, errs, 1, tt.field)
+		assert.Equal(t, tt.field, errs[0].Field)
+	}
+
+	// Omitted when unset so existing domains serialize unchanged.
+	data, err := json.Marshal(domain(nil))
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "tls")
+}
+
+func TestValidateDomains_DuplicateHostAcrossDomains(t *testing.T) {
+	route := []RouteConfig{{Name: "r1", URI: "/", Clusters: []WeightedCluster{{Name: "c", Weight: 1}}}}
+	domains := []DomainConfig{
+		{Name: "api", Hosts: []string{"a.com", "shared.com"}, Routes: route},
+		{Name: "web", Hosts: []string{"b.com", "shared.com"}, Routes: route},
+	}
+	errs := ValidateDomains(domains, nil)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "domains[1].hosts[1]", errs[0].Field)
+	assert.Contains(t, errs[0].Message, `claimed by domain "api"`)
+}
+
 // New Rate Limit Validation Tests
 func TestValidateRoutes_RateLimitBurstNegative(t *testing.T) {
 	rate := 10.0
@@ -510,6 +670,66 @@ func TestValidateCluster_InvalidPassHost(t *testing.T) {
 	assert.Contains(t, errs[0].Field, "pass_host")
 }
 
+func TestValidateCluster_HealthCheck(t *testing.T) {
+	port := 9000
+	valid := func() *ClusterConfig {
+		return &ClusterConfig{
+			Name:    "backend",
+			LBType:  "roundrobin",
+			Timeout: TimeoutConfig{Connect: 1, Read: 1},
+			Nodes:   []UpstreamNode{{Host: "10.0.0.1", Port: 8080, Weight: 100}},
+			HealthCheck: &HealthCheckConfig{Active: &ActiveHealthCheck{
+				Interval: 5, Path: "/healthz", Port: &port, Timeout: 2,
+				HealthyStatuses: []int{200, 204}, HealthyThreshold: 2, UnhealthyThreshold: 3,
+			}},
+		}
+	}
+	assert.Empty(t, ValidateCluster(valid()))
+
+	tests := []struct {
+		field  string
+		mutate func(a *ActiveHealthCheck)
+	}{
+		{"interval", func(a *ActiveHealthCheck) { a.Interval = 0 }},
+		{"path", func(a *ActiveHealthCheck) { a.Path = "healthz" }},
+		{"port", func(a *ActiveHealthCheck) { bad := 70000; a.Port = &bad }},
+		{"timeout", func(a *ActiveHealthCheck) { a.Timeout = 0 }},
+		{"healthy_threshold", func(a *ActiveHealthCheck) { a.HealthyThreshold = 0 }},
+		{"unhealthy_threshold", func(a *ActiveHealthCheck) { a.UnhealthyThreshold = -1 }},
+		{"healthy_statuses", func(a *ActiveHealthCheck) { a.HealthyStatuses = nil }},
+		{"healthy_statuses[0]", func(a *ActiveHealthCheck) { a.HealthyStatuses = []int{42} }},
+		{"concurrency", func(a *ActiveHealthCheck) { a.Concurrency = -1 }},
+	}
+	for _, tt := range tests {
+		c := valid()
+		tt.mutate(c.HealthCheck.Active)
+		errs := ValidateCluster(c)
+		require.Len(t, errs, 1, tt.field)
+		assert.Equal(t, "clusters[0].health_check.active."+tt.field, errs[0].Field)
+	}
+
+	c := valid()
+	c.HealthCheck.Active = nil
+	errs := ValidateCluster(c)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "clusters[0].health_check", errs[0].Field)
+}
+
+func TestClusterConfig_HealthCheckJSON(t *testing.T) {
+	// Disabled by default: no health_check key, so the gateway sees nothing.
+	data, err := json.Marshal(ClusterConfig{Name: "c"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), "health_check")
+
+	// Field names match the gateway's ActiveHealthCheck and survive a round trip.
+	in := `{"active":{"interval":5,"path":"/healthz","port":9000,"healthy_statuses":[200],"healthy_threshold":2,"unhealthy_threshold":3,"timeout":2,"concurrency":10}}`
+	var hc HealthCheckConfig
+	require.NoError(t, json.Unmarshal([]byte(in), &hc))
+	out, err := json.Marshal(hc)
+	require.NoError(t, err)
+	assert.JSONEq(t, in, string(out))
+}
+
 func TestValidateCluster_RewriteRequiresUpstreamHost(t *testing.T) {
 	c := &ClusterConfig{
 		Name:     "backend",
@@ -536,3 +756,243 @@ func TestValidateCluster_RewriteWithUpstreamHost(t *testing.T) {
 	errs := ValidateCluster(c)
 	assert.Empty(t, errs)
 }
+
+func TestWarnConfig(t *testing.T) {
+	cfg := &GatewayConfig{
+		Domains: []DomainConfig{{
+			Name:  "api",
+			Hosts: []string{"api.example.com", "intranet"},
+			Routes: []RouteConfig{
+				{Name: "split", URI: "/", Clusters: []WeightedCluster{{Name: "a", Weight: 50}, {Name: "b", Weight: 30}}},
+				{Name: "even", URI: "/even", Clusters: []WeightedCluster{{Name: "a", Weight: 90}, {Name: "b", Weight: 10}}},
+				{Name: "single", URI: "/one", Clusters: []WeightedCluster{{Name: "a", Weight: 1}}},
+			},
+		}},
+		Clusters: []ClusterConfig{
+			{Name: "a", Nodes: []UpstreamNode{{Host: "h", Port: 80, Weight: 1}}},
+			{Name: "b", Nodes: []UpstreamNode{{Host: "h", Port: 80, Weight: 1}}, HealthCheck: &HealthCheckConfig{}},
+			{Name: "c", Nodes: []UpstreamNode{{Host: "h1", Port: 80, Weight: 1}, {Host: "h2", Port: 80, Weight: 1}}},
+		},
+	}
+	warns := WarnConfig(cfg)
+	var fields []string
+	for _, w := range warns {
+		fields = append(fields, w.Field)
+	}
+	assert.Equal(t, []string{"clusters[0].nodes", "domains[0].hosts[1]", "domains[0].routes[0].clusters"}, fields)
+	assert.Empty(t, ValidateConfig(&GatewayConfig{Domains: []DomainConfig{{Name: "x", Hosts: []string{"intranet"}}}}),
+		"warnings never turn into errors")
+}
+
+func TestValidateLabels(t *testing.T) {
+	d := &DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Labels: map[string]string{
+		"team": "payments", "app.kubernetes.io_name": "api", "tier": "",
+	}}
+	assert.Empty(t, ValidateDomain(d, nil))
+
+	d.Labels = map[string]string{"-bad": "x", "ok": "has space", strings.Repeat("k", 64): "v"}
+	errs := ValidateDomain(d, nil)
+	require.Len(t, errs, 3)
+	assert.Equal(t, "domains[0].labels[-bad]", errs[0].Field)
+	assert.Equal(t, "domains[0].labels[ok]", errs[2].Field)
+
+	c := &ClusterConfig{Name: "c", LBType: "roundrobin", Timeout: TimeoutConfig{Connect: 1, Read: 1},
+		Nodes: []UpstreamNode{{Host: "h", Port: 80, Weight: 1}}, Labels: map[string]string{"team": "pay ments"}}
+	errs = ValidateCluster(c)
+	require.Len(t, errs, 1)
+	assert.Equal(t, "clusters[0].labels[team]", errs[0].Field)
+}
+
+func TestValidateOwnership(t *testing.T) {
+	d := &DomainConfig{Name: "api", Hosts: []string{"api.example.com"}, Owner: "Payments Team", Contact: "@pay-oncall"}
+	assert.Empty(t, ValidateDomain(d, nil))
+
+	d.Owner = strings.Repeat("x", 129)
+	d.Contact = "line\nbreak"
+	errs := ValidateDomain(d, nil)
+	require.Len(t, errs, 2)
+	assert.Equal(t, "domains[0].owner", errs[0].Field)
+	assert.Equal(t, "domains[0].contact", errs[1].Field)
+}
+
+func TestLintConfig(t *testing.T) {
+	static := func(name string, hosts ...string) ClusterConfig {
+		c := ClusterConfig{Name: name, LBType: "roundrobin", Timeout: TimeoutConfig{Connect: 1, Send: 5, Read: 5}}
+		for _, h := range hosts {
+			c.Nodes = append(c.Nodes, UpstreamNode{Host: h, Port: 80, Weight: 1})
+		}
+		return c
+	}
+	clean := &GatewayConfig{
+		Domains: []DomainConfig{{
+			Name:  "api",
+			Hosts: []string{"api.example.com"},
+			Routes: []RouteConfig{
+				{Name: "users", URI: "/users/*", Clusters: []WeightedCluster{{Name: "a", Weight: 100}}},
+				{Name: "default", URI: "/*", Clusters: []WeightedCluster{{Name: "a", Weight: 100}}},
+			},
+		}},
+		Clusters: []ClusterConfig{static("a", "h1", "h2")},
+	}
+	assert.Empty(t, LintConfig(clean))
+
+	cfg := &GatewayConfig{
+		Domains: []DomainConfig{{
+			Name:  "api",
+			Hosts: []string{"*.example.com"},
+			Routes: []RouteConfig{
+				{Name: "get-all", URI: "/*", Methods: []string{"GET"}, Clusters: []WeightedCluster{{Name: "a", Weight: 100}}},
+			},
+		}},
+		Clusters: []ClusterConfig{static("a", "h1"), static("b", "h1", "h2")},
+	}
+	cfg.Clusters[1].Timeout.Connect = 0.05
+	var rules []string
+	for _, f := range LintConfig(cfg) {
+		assert.Equal(t, SeverityWarning, f.Severity)
+		rules = append(rules, f.Rule+" "+f.Field)
+	}
+	assert.Equal(t, []string{
+		"wildcard-host domains[0].hosts[0]",
+		"no-catch-all domains[0].routes",
+		"single-node clusters[0].nodes",
+		"short-timeout clusters[1].timeout.connect",
+		"unused-cluster clusters[1]",
+	}, rules)
+
+	invalid := LintConfig(&GatewayConfig{Clusters: []ClusterConfig{{Name: "x"}}})
+	require.NotEmpty(t, invalid)
+	assert.Equal(t, RuleInvalid, invalid[0].Rule)
+	assert.Equal(t, SeverityError, invalid[0].Severity)
+}
+
+func TestGatewayConfig_SelectLabels(t *testing.T) {
+	cfg := &GatewayConfig{
+		Domains: []DomainConfig{
+			{Name: "cdn", Labels: map[string]string{"tier": "edge", "team": "net"}},
+			{Name: "api", Labels: map[string]string{"tier": "core"}},
+			{Name: "bare"},
+		},
+		Clusters: []ClusterConfig{{Name: "origin", Labels: map[string]string{"tier": "edge"}}},
+	}
+	got := cfg.SelectLabels(map[string]string{"tier": "edge"})
+	require.Len(t, got.Domains, 1)
+	assert.Equal(t, "cdn", got.Domains[0].Name)
+	require.Len(t, got.Clusters, 1)
+	assert.Len(t, cfg.Domains, 3, "the original is untouched")
+
+	got = cfg.SelectLabels(map[string]string{"tier": "edge", "team": "web"})
+	assert.Empty(t, got.Domains)
+	assert.NotNil(t, got.Domains)
+	assert.Empty(t, got.Clusters)
+	assert.Len(t, cfg.SelectLabels(nil).Domains, 3)
+}
+
+func TestEvaluateRules(t *testing.T) {
+	rules := []Rule{
+		{Name: "prod-tls", Kind: "domain", Field: "tls.certificate_ref", Op: RuleOpExists, Severity: SeverityError, Regions: []string{"prod"}},
+		{Name: "route-prefix", Kind: "domain", Field: "routes.uri", Op: RuleOpRegex, Value: "^/api/"},
+		{Name: "two-nodes", Kind: "cluster", Field: "nodes", Op: RuleOpMin, Value: 2},
+		{Name: "read-timeout", Kind: "cluster", Field: "timeout.read", Op: RuleOpMin, Value: 5},
+		{Name: "https", Kind: "cluster", Field: "scheme", Op: RuleOpEquals, Value: "https", Message: "use https upstreams"},
+	}
+	for i := range rules {
+		require.NoError(t, rules[i].Validate())
+	}
+	cfg := &GatewayConfig{
+		Domains: []DomainConfig{{
+			Name:  "api",
+			Hosts: []string{"api.example.com"},
+			Routes: []RouteConfig{
+				{Name: "users", URI: "/api/users"},
+				{Name: "legacy", URI: "/v1/*"},
+			},
+		}},
+		Clusters: []ClusterConfig{{
+			Name: "a", Scheme: "http", Timeout: TimeoutConfig{Read: 10},
+			Nodes: []UpstreamNode{{Host: "h1", Port: 80, Weight: 1}},
+		}},
+	}
+
+	var got []string
+	for _, f := range EvaluateRules(rules, "prod", cfg) {
+		got = append(got, f.Rule+" "+f.Severity+" "+f.Field)
+	}
+	assert.Equal(t, []string{
+		"prod-tls error domains[0].tls.certificate_ref",
+		"route-prefix warning domains[0].routes[1].uri",
+		"two-nodes warning clusters[0].nodes",
+		"https warning clusters[0].scheme",
+	}, got)
+
+	// Region-scoped rules stay out of other regions.
+	for _, f := range EvaluateRules(rules, "staging", cfg) {
+		assert.NotEqual(t, "prod-tls", f.Rule)
+	}
+
+	findings := EvaluateClusterRules(rules, "prod", &cfg.Clusters[0])
+	require.Len(t, findings, 2)
+	assert.Equal(t, "nodes", findings[0].Field)
+	assert.Equal(t, "nodes must be at least 2", findings[0].Message)
+	assert.Equal(t, "use https upstreams", findings[1].Message)
+}
+
+func TestRuleValidate(t *testing.T) {
+	valid := Rule{Name: "r", Kind: "cluster", Field: "nodes", Op: RuleOpMin, Value: 2}
+	require.NoError(t, valid.Validate())
+	assert.Equal(t, SeverityWarning, valid.Severity)
+
+	for name, r := range map[string]Rule{
+		"no name":       {Kind: "cluster", Field: "nodes", Op: RuleOpExists},
+		"bad kind":      {Name: "r", Kind: "route", Field: "uri", Op: RuleOpExists},
+		"bad field":     {Name: "r", Kind: "cluster", Field: "timeout..read", Op: RuleOpExists},
+		"bad op":        {Name: "r", Kind: "cluster", Field: "nodes", Op: "max", Value: 2},
+		"bad severity":  {Name: "r", Kind: "cluster", Field: "nodes", Op: RuleOpExists, Severity: "fatal"},
+		"exists value":  {Name: "r", Kind: "cluster", Field: "nodes", Op: RuleOpExists, Value: true},
+		"min string":    {Name: "r", Kind: "cluster", Field: "nodes", Op: RuleOpMin, Value: "two"},
+		"equals empty":  {Name: "r", Kind: "cluster", Field: "scheme", Op: RuleOpEquals},
+		"invalid regex": {Name: "r", Kind: "domain", Field: "hosts", Op: RuleOpRegex, Value: "("},
+	} {
+		assert.Error(t, r.Validate(), name)
+	}
+}
+
+func TestValidateRoutes_AllZeroWeights(t *testing.T) {
+	routes := []RouteConfig{{Name: "r1", URI: "/", Clusters: []WeightedCluster{{Name: "a", Weight: 0}, {Name: "b", Weight: 0}}}}
+	errs := ValidateRoutes(routes, nil, "routes")
+	require.Len(t, errs, 1)
+	assert.Equal(t, "routes[0].clusters", errs[0].Field)
+
+	routes[0].Clusters[1].Weight = 1
+	assert.Empty(t, ValidateRoutes(routes, nil, "routes"), "a zero weight next to a positive one is a valid drain")
+}
+
+func TestNormalizeRouteWeights(t *testing.T) {
+	weights := func(ws ...int) []WeightedCluster {
+		out := make([]WeightedCluster, len(ws))
+		for i, w := range ws {
+			out[i] = WeightedCluster{Name: fmt.Sprintf("c%d", i), Weight: w}
+		}
+		return out
+	}
+	tests := []struct {
+		in, want []int
+	}{
+		{[]int{1}, []int{100}},
+		{[]int{1, 1}, []int{50, 50}},
+		{[]int{1, 1, 1}, []int{34, 33, 33}},
+		{[]int{3, 1}, []int{75, 25}},
+		{[]int{2, 0, 1}, []int{67, 0, 33}},
+		{[]int{60, 40}, []int{60, 40}},
+		{[]int{0, 0}, []int{0, 0}},
+	}
+	for _, tt := range tests {
+		d := &DomainConfig{Routes: []RouteConfig{{Clusters: weights(tt.in...)}}}
+		NormalizeRouteWeights(d)
+		var got []int
+		for _, wc := range d.Routes[0].Clusters {
+			got = append(got, wc.Weight)
+		}
+		assert.Equal(t, tt.want, got, "in=%v", tt.in)
+	}
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Warnings flag configuration that is valid but probably not what the user
+// meant. Unlike validation errors they never block a write; handlers return
+// them alongside the result so the UI can surface them.
+
+// WarnConfig returns warnings for domains and clusters together.
+func WarnConfig(cfg *GatewayConfig) []ValidationError {
+	warns := WarnClusters(cfg.Clusters)
+	return append(warns, WarnDomains(cfg.Domains)...)
+}
+
+// WarnDomains returns warnings for domain definitions.
+func WarnDomains(domains []DomainConfig) []ValidationError {
+	var warns []ValidationError
+	for i, d := range domains {
+		prefix := fmt.Sprintf("domains[%d]", i)
+
+		for j, host := range d.Hosts {
+			if host != "" && !strings.Contains(host, ".") {
+				warns = append(warns, ValidationError{
+					fmt.Sprintf("%s.hosts[%d]", prefix, j), fmt.Sprintf("host %q has no TLD", host),
+				})
+			}
+		}
+
+		for j, r := range d.Routes {
+			// Weights only matter when traffic is split.
+			if len(r.Clusters) < 2 {
+				continue
+			}
+			total := 0
+			for _, wc := range r.Clusters {
+				total += wc.Weight
+			}
+			if total != 100 {
+				warns = append(warns, ValidationError{
+					fmt.Sprintf("%s.routes[%d].clusters", prefix, j), fmt.Sprintf("cluster weights sum to %d, not 100", total),
+				})
+			}
+		}
+	}
+	return warns
+}
+
+// WarnDomain returns warnings for a single domain config.
+func WarnDomain(d *DomainConfig) []ValidationError {
+	return WarnDomains([]DomainConfig{*d})
+}
+
+// WarnClusters returns warnings for cluster definitions.
+func WarnClusters(clusters []ClusterConfig) []ValidationError {
+	var warns []ValidationError
+	for i, c := range clusters {
+		prefix := fmt.Sprintf("clusters[%d]", i)
+
+		if len(c.Nodes) == 1 && c.HealthCheck == nil {
+			warns = append(warns, ValidationError{
+				prefix + ".nodes", "single node with no health check: failures will not be detected",
+			})
+		}
+	}
+	return warns
+}
+
+// WarnCluster returns warnings for a single cluster config.
+func WarnCluster(c *ClusterConfig) []ValidationError {
+	return WarnClusters([]ClusterConfig{*c})
+}
@@ -0,0 +1,175 @@
+// Package notify delivers security-relevant events to the webhook and mail
+// recipients configured under notifications, and account mail to builtin
+// users. Delivery happens on a background worker so callers never wait on a
+// slow receiver.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+)
+
+// queueSize bounds the deliveries waiting for the worker. Past it new
+// deliveries are dropped (and logged) rather than blocking the caller.
+const queueSize = 256
+
+// Mailer sends plain-text mail through the configured SMTP relay.
+type Mailer struct {
+	cfg config.SMTPConfig
+}
+
+// NewMailer returns a mailer for cfg, or nil when smtp.host is unset.
+func NewMailer(cfg config.SMTPConfig) *Mailer {
+	if cfg.Host == "" {
+		return nil
+	}
+	return &Mailer{cfg: cfg}
+}
+
+// Send mails body to the recipients. net/smtp upgrades to STARTTLS when the
+// relay offers it.
+func (m *Mailer) Send(to []string, subject, body string) error {
+	var auth smtp.Auth
+	if m.cfg.Username != "" {
+		auth = smtp.PlainAuth("", m.cfg.Username, m.cfg.Password, m.cfg.Host)
+	}
+	// Header values must not smuggle in extra headers.
+	subject = strings.NewReplacer("\r", " ", "\n", " ").Replace(subject)
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.cfg.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	addr := net.JoinHostPort(m.cfg.Host, strconv.Itoa(m.cfg.Port))
+	if err := smtp.SendMail(addr, auth, m.cfg.From, to, msg.Bytes()); err != nil {
+		return fmt.Errorf("smtp send: %w", err)
+	}
+	return nil
+}
+
+// CredentialEvent is one API credential lifecycle change.
+type CredentialEvent struct {
+	// Action is created, updated, enabled, disabled or deleted.
+	Action      string    `json:"action"`
+	Region      string    `json:"region"`
+	Actor       string    `json:"actor"`
+	ID          int64     `json:"id"`
+	AccessKey   string    `json:"access_key"`
+	Description string    `json:"description"`
+	Scopes      []string  `json:"scopes"`
+	Time        time.Time `json:"time"`
+}
+
+// Notifier queues events for delivery. The zero channel config sends
+// nothing, so a Notifier is always safe to call.
+type Notifier struct {
+	credentials config.NotifyChannel
+	mailer      *Mailer
+	client      *http.Client
+	queue       chan func() error
+	logger      *zap.SugaredLogger
+}
+
+// New builds a notifier for the configured channels. Call Run to start
+// delivering.
+func New(smtpCfg config.SMTPConfig, cfg config.NotificationsConfig, logger *zap.SugaredLogger) *Notifier {
+	return &Notifier{
+		credentials: cfg.Credentials,
+		mailer:      NewMailer(smtpCfg),
+		client:      &http.Client{Timeout: 10 * time.Second},
+		queue:       make(chan func() error, queueSize),
+		logger:      logger,
+	}
+}
+
+// Run delivers queued events until ctx is done.
+func (n *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case deliver := <-n.queue:
+			if err := deliver(); err != nil {
+				n.logger.Warnf("notification delivery failed: %v", err)
+			}
+		}
+	}
+}
+
+// Credential queues ev for the credentials channel.
+func (n *Notifier) Credential(ev CredentialEvent) {
+	if !n.credentials.Enabled() {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Scopes == nil {
+		ev.Scopes = []string{}
+	}
+	if url := n.credentials.WebhookURL; url != "" {
+		n.enqueue("credential webhook", func() error {
+			return n.postJSON(url, map[string]any{"type": "credential", "event": ev})
+		})
+	}
+	if to := n.credentials.Email; len(to) > 0 && n.mailer != nil {
+		subject := fmt.Sprintf("[hermes] API credential %s %s in region %s", ev.AccessKey, ev.Action, ev.Region)
+		body := fmt.Sprintf("API credential %s was %s by %s.\n\nRegion:      %s\nCredential:  #%d %s\nDescription: %s\nScopes:      %s\nTime:        %s\n",
+			ev.AccessKey, ev.Action, ev.Actor, ev.Region, ev.ID, ev.AccessKey, ev.Description,
+			strings.Join(ev.Scopes, ", "), ev.Time.Format(time.RFC3339))
+		n.enqueue("credential email", func() error {
+			return n.mailer.Send(to, subject, body)
+		})
+	}
+}
+
+// MailUser queues a mail to a user about their own account. It is a no-op
+// when SMTP is not configured.
+func (n *Notifier) MailUser(to, subject, body string) {
+	if n.mailer == nil || to == "" {
+		return
+	}
+	n.enqueue("user email", func() error {
+		return n.mailer.Send([]string{to}, subject, body)
+	})
+}
+
+func (n *Notifier) enqueue(what string, deliver func() error) {
+	select {
+	case n.queue <- deliver:
+	default:
+		n.logger.Warnf("notification queue full, dropping %s", what)
+	}
+}
+
+func (n *Notifier) postJSON(url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("webhook post: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s returned %s", url, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/jizhuozhi/hermes/server/internal/config"
+)
+
+func TestCredentialWebhook(t *testing.T) {
+	got := make(chan map[string]any, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]any
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		got <- body
+	}))
+	defer srv.Close()
+
+	n := New(config.SMTPConfig{}, config.NotificationsConfig{
+		Credentials: config.NotifyChannel{WebhookURL: srv.URL},
+	}, zap.NewNop().Sugar())
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.Run(ctx)
+
+	n.Credential(CredentialEvent{Action: "created", Region: "prod", Actor: "alice", ID: 7, AccessKey: "ak1", Description: "ci", Scopes: []string{"config:write"}})
+
+	select {
+	case body := <-got:
+		assert.Equal(t, "credential", body["type"])
+		ev := body["event"].(map[string]any)
+		assert.Equal(t, "created", ev["action"])
+		assert.Equal(t, "prod", ev["region"])
+		assert.Equal(t, "alice", ev["actor"])
+		assert.Equal(t, "ak1", ev["access_key"])
+		assert.Equal(t, []any{"config:write"}, ev["scopes"])
+		assert.NotEmpty(t, ev["time"])
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook not called")
+	}
+}
+
+func TestCredential_NeverBlocks(t *testing.T) {
+	n := New(config.SMTPConfig{}, config.NotificationsConfig{
+		Credentials: config.NotifyChannel{WebhookURL: "http://127.0.0.1:1/hook"},
+	}, zap.NewNop().Sugar())
+
+	// No worker is running: once the queue fills, events are dropped.
+	for i := 0; i < queueSize+10; i++ {
+		n.Credential(CredentialEvent{Action: "deleted"})
+	}
+	assert.Len(t, n.queue, queueSize)
+}
+
+func TestCredential_Disabled(t *testing.T) {
+	n := New(config.SMTPConfig{}, config.NotificationsConfig{}, zap.NewNop().Sugar())
+	n.Credential(CredentialEvent{Action: "created"})
+	assert.Empty(t, n.queue)
+	require.Nil(t, NewMailer(config.SMTPConfig{}))
+}
+
+func TestMailUser_NoSMTP(t *testing.T) {
+	n := New(config.SMTPConfig{}, config.NotificationsConfig{}, zap.NewNop().Sugar())
+	n.MailUser("bob@example.com", "hello", "body")
+	assert.Empty(t, n.queue, "no-op without smtp.host")
+
+	n = New(config.SMTPConfig{Host: "127.0.0.1", Port: 1, From: "hermes@example.com"}, config.NotificationsConfig{}, zap.NewNop().Sugar())
+	n.MailUser("bob@example.com", "hello", "body")
+	assert.Len(t, n.queue, 1)
+}
@@ -0,0 +1,87 @@
+// Package secretbox encrypts small secrets (TOTP seeds, credentials) at rest
+// with the server master key using AES-256-GCM.
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix versions the sealed format so the scheme can evolve.
+const sealedPrefix = "v1:"
+
+// ErrNoKey is returned by a nil Box, i.e. when no master key is configured.
+var ErrNoKey = errors.New("master key is not configured")
+
+// Box seals and opens values with a fixed AES-256-GCM key.
+// A nil *Box is valid and fails every operation with ErrNoKey.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New creates a Box from a base64-encoded 32-byte master key.
+// An empty key returns a nil Box (encryption disabled) and no error.
+func New(encodedKey string) (*Box, error) {
+	if encodedKey == "" {
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode master key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts plaintext and returns a printable "v1:<base64>" string.
+func (b *Box) Seal(plaintext []byte) (string, error) {
+	if b == nil {
+		return "", ErrNoKey
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := b.aead.Seal(nonce, nonce, plaintext, nil)
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// IsSealed reports whether s is in the format Seal produces, telling sealed
+// values apart from ones stored before encryption was enabled.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, sealedPrefix)
+}
+
+// Open decrypts a value produced by Seal.
+func (b *Box) Open(sealed string) ([]byte, error) {
+	if b == nil {
+		return nil, ErrNoKey
+	}
+	if !strings.HasPrefix(sealed, sealedPrefix) {
+		return nil, errors.New("unsupported sealed value format")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(sealed, sealedPrefix))
+	if err != nil {
+		return nil, fmt.Errorf("decode sealed value: %w", err)
+	}
+	n := b.aead.NonceSize()
+	if len(raw) < n {
+		return nil, errors.New("sealed value too short")
+	}
+	return b.aead.Open(nil, raw[:n], raw[n:], nil)
+}
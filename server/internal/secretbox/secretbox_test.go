@@ -0,0 +1,47 @@
+package secretbox
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testKey() string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+}
+
+func TestSealOpen(t *testing.T) {
+	b, err := New(testKey())
+	require.NoError(t, err)
+
+	sealed, err := b.Seal([]byte("JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, "v1:"))
+	assert.True(t, IsSealed(sealed))
+	assert.False(t, IsSealed("JBSWY3DPEHPK3PXP"))
+	assert.NotContains(t, sealed, "JBSWY3DPEHPK3PXP")
+
+	plain, err := b.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", string(plain))
+
+	// Tampering is detected.
+	_, err = b.Open(sealed[:len(sealed)-2] + "AA")
+	assert.Error(t, err)
+}
+
+func TestNew_Invalid(t *testing.T) {
+	b, err := New("")
+	require.NoError(t, err)
+	assert.Nil(t, b)
+	_, err = b.Seal([]byte("x"))
+	assert.ErrorIs(t, err, ErrNoKey)
+
+	_, err = New("not base64!")
+	assert.Error(t, err)
+	_, err = New(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
@@ -2,13 +2,23 @@ package store
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/jizhuozhi/hermes/server/internal/model"
+	"github.com/jizhuozhi/hermes/server/internal/secretbox"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/lib/pq"
@@ -17,12 +27,42 @@ import (
 
 // PgStore implements Store backed by PostgreSQL.
 type PgStore struct {
-	db         *sql.DB
+	db         *tracedDB
+	read       *tracedDB // read replica pool; same as db when no replica is configured
 	logger     *zap.SugaredLogger
 	maxHistory int
 }
 
-func NewPgStore(dsn string, logger *zap.SugaredLogger) (*PgStore, error) {
+// NewPgStore connects to the primary at dsn and, when readDSN is non-empty,
+// opens a second pool against a read replica for read-heavy queries.
+func NewPgStore(dsn, readDSN string, logger *zap.SugaredLogger) (*PgStore, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	db, err := openPool(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &PgStore{db: db, read: db, logger: logger, maxHistory: 50}
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("pg migrate: %w", err)
+	}
+
+	if readDSN != "" {
+		read, err := openPool(ctx, readDSN)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("read replica: %w", err)
+		}
+		s.read = read
+		logger.Infof("postgres read replica enabled")
+	}
+	return s, nil
+}
+
+func openPool(ctx context.Context, dsn string) (*tracedDB, error) {
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, fmt.Errorf("pg open: %w", err)
@@ -31,23 +71,105 @@ func NewPgStore(dsn string, logger *zap.SugaredLogger) (*PgStore, error) {
 	db.SetMaxIdleConns(5)
 	db.SetConnMaxLifetime(5 * time.Minute)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
 	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("pg ping: %w", err)
 	}
+	return &tracedDB{db}, nil
+}
 
-	s := &PgStore{db: db, logger: logger, maxHistory: 50}
-	if err := s.migrate(ctx); err != nil {
-		return nil, fmt.Errorf("pg migrate: %w", err)
+func (s *PgStore) Ping(ctx context.Context) error {
+	if err := s.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("pg ping: %w", err)
 	}
-	return s, nil
+	return nil
 }
 
 func (s *PgStore) Close() {
+	if s.read != s.db {
+		s.read.Close()
+	}
 	s.db.Close()
 }
 
+// Read-your-writes routing
+//
+// Replica reads may lag the primary, so a request that has already written
+// must keep reading from the primary to observe its own change. The request
+// carries a marker (installed by WithReadYourWrites) that write methods flip.
+
+type writeMarkerKey struct{}
+
+type writeMarker struct{ wrote atomic.Bool }
+
+// WithReadYourWrites returns a context that tracks whether a write has been
+// issued through it, so that later reads on the same context use the primary.
+func WithReadYourWrites(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeMarkerKey{}, &writeMarker{})
+}
+
+// markWrite records that ctx has issued a write.
+func markWrite(ctx context.Context) {
+	if m, ok := ctx.Value(writeMarkerKey{}).(*writeMarker); ok {
+		m.wrote.Store(true)
+	}
+}
+
+// Skipping no-op writes
+//
+// Re-applying an unchanged config would otherwise add a history entry, bump
+// the resource version and wake every controller. A request can opt out of
+// that by carrying a SkipNoop (installed by WithSkipNoop).
+
+// SkipNoop asks PutDomain and PutCluster to leave a resource whose stored
+// config already equals the incoming one untouched, returning its current
+// history version. Skipped reports whether the last such write was skipped.
+type SkipNoop struct{ Skipped bool }
+
+type skipNoopKey struct{}
+
+// WithSkipNoop returns a context under which no-op domain and cluster
+// updates are skipped, and the SkipNoop reporting whether they were.
+func WithSkipNoop(ctx context.Context) (context.Context, *SkipNoop) {
+	n := &SkipNoop{}
+	return context.WithValue(ctx, skipNoopKey{}, n), n
+}
+
+// SkipNoopFromContext returns the SkipNoop installed by WithSkipNoop, or nil.
+func SkipNoopFromContext(ctx context.Context) *SkipNoop {
+	n, _ := ctx.Value(skipNoopKey{}).(*SkipNoop)
+	return n
+}
+
+// Change reasons
+//
+// Writers may say why they changed something. The reason travels on the
+// context, like the operator's read-your-writes marker, and is recorded on
+// every config_history and change_log row the request writes.
+
+type changeReasonKey struct{}
+
+// WithChangeReason returns a context whose writes record reason.
+func WithChangeReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, changeReasonKey{}, reason)
+}
+
+// ChangeReasonFromContext returns the reason installed by WithChangeReason,
+// or "".
+func ChangeReasonFromContext(ctx context.Context) string {
+	reason, _ := ctx.Value(changeReasonKey{}).(string)
+	return reason
+}
+
+// reader returns the pool for replica-eligible reads: the replica, unless
+// ctx has already written.
+func (s *PgStore) reader(ctx context.Context) *tracedDB {
+	if m, ok := ctx.Value(writeMarkerKey{}).(*writeMarker); ok && m.wrote.Load() {
+		return s.db
+	}
+	return s.read
+}
+
 // Schema migration
 func (s *PgStore) migrate(ctx context.Context) error {
 	ddl := `
@@ -57,6 +179,12 @@ CREATE TABLE IF NOT EXISTS regions (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 INSERT INTO regions (name) VALUES ('default') ON CONFLICT DO NOTHING;
+-- Migration: per-region settings overriding server defaults (idempotent).
+ALTER TABLE regions ADD COLUMN IF NOT EXISTS settings JSONB NOT NULL DEFAULT '{}';
+-- Migration: change-freeze flag blocking config writes (idempotent).
+ALTER TABLE regions ADD COLUMN IF NOT EXISTS frozen BOOLEAN NOT NULL DEFAULT FALSE;
+-- Migration: etcd fan-out targets (idempotent).
+ALTER TABLE regions ADD COLUMN IF NOT EXISTS targets JSONB NOT NULL DEFAULT '[]';
 
 -- ── Configuration ────────────────────────────────
 CREATE TABLE IF NOT EXISTS domains (
@@ -68,6 +196,10 @@ CREATE TABLE IF NOT EXISTS domains (
     PRIMARY KEY (region, name)
 );
 
+-- Host lookup: jsonb_ops GIN over the hosts array serves ?| (any of).
+CREATE INDEX IF NOT EXISTS idx_domains_hosts ON domains USING GIN ((config->'hosts'));
+CREATE INDEX IF NOT EXISTS idx_domains_labels ON domains USING GIN ((config->'labels'));
+
 CREATE TABLE IF NOT EXISTS clusters (
     region     TEXT NOT NULL DEFAULT 'default',
     name       TEXT NOT NULL,
@@ -76,6 +208,7 @@ CREATE TABLE IF NOT EXISTS clusters (
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     PRIMARY KEY (region, name)
 );
+CREATE INDEX IF NOT EXISTS idx_clusters_labels ON clusters USING GIN ((config->'labels'));
 
 -- ── Change tracking ──────────────────────────────
 CREATE TABLE IF NOT EXISTS config_history (
@@ -90,6 +223,7 @@ CREATE TABLE IF NOT EXISTS config_history (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 CREATE INDEX IF NOT EXISTS idx_history_region_kind_name ON config_history(region, kind, name, version DESC);
+CREATE INDEX IF NOT EXISTS idx_history_region_created ON config_history(region, created_at DESC);
 
 CREATE TABLE IF NOT EXISTS change_log (
     revision   BIGSERIAL PRIMARY KEY,
@@ -103,6 +237,56 @@ CREATE TABLE IF NOT EXISTS change_log (
 );
 CREATE INDEX IF NOT EXISTS idx_changelog_region_revision ON change_log(region, revision);
 
+-- Archived change_log rows (same shape; populated by ArchiveChangeLog).
+CREATE TABLE IF NOT EXISTS change_log_archive (
+    revision   BIGINT PRIMARY KEY,
+    region     TEXT NOT NULL DEFAULT 'default',
+    kind       TEXT NOT NULL,
+    name       TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    operator   TEXT NOT NULL DEFAULT '',
+    config     JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_changelog_archive_region_created ON change_log_archive(region, created_at);
+-- A resource's events newest first, for ConfigChangesSince's per-resource lookups.
+CREATE INDEX IF NOT EXISTS idx_changelog_region_kind_name ON change_log(region, kind, name, revision DESC);
+CREATE INDEX IF NOT EXISTS idx_changelog_archive_region_kind_name ON change_log_archive(region, kind, name, revision DESC);
+-- Migration: why a change was made, from X-Hermes-Change-Reason (idempotent).
+ALTER TABLE config_history ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE change_log ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+ALTER TABLE change_log_archive ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+
+-- Domain/cluster writes deferred to apply_at; see ClaimDueScheduledChanges.
+CREATE TABLE IF NOT EXISTS scheduled_changes (
+    id          BIGSERIAL PRIMARY KEY,
+    region      TEXT NOT NULL,
+    kind        TEXT NOT NULL,
+    name        TEXT NOT NULL,
+    action      TEXT NOT NULL,
+    config      JSONB,
+    apply_at    TIMESTAMPTZ NOT NULL,
+    status      TEXT NOT NULL DEFAULT 'pending',
+    error       TEXT NOT NULL DEFAULT '',
+    created_by  TEXT NOT NULL DEFAULT '',
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_scheduled_changes_region ON scheduled_changes(region, apply_at);
+CREATE INDEX IF NOT EXISTS idx_scheduled_changes_due ON scheduled_changes(apply_at) WHERE status = 'pending';
+ALTER TABLE scheduled_changes ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;
+ALTER TABLE scheduled_changes ADD COLUMN IF NOT EXISTS reason TEXT NOT NULL DEFAULT '';
+
+-- Full config served to a percentage of gateways; at most one per region.
+CREATE TABLE IF NOT EXISTS config_canaries (
+    region     TEXT PRIMARY KEY,
+    percent    INT NOT NULL,
+    config     JSONB NOT NULL,
+    created_by TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+ALTER TABLE config_canaries ADD COLUMN IF NOT EXISTS base_revision BIGINT NOT NULL DEFAULT 0;
+
 -- ── Runtime status ───────────────────────────────
 CREATE TABLE IF NOT EXISTS gateway_instances (
     region            TEXT NOT NULL DEFAULT 'default',
@@ -117,6 +301,18 @@ CREATE TABLE IF NOT EXISTS gateway_instances (
     PRIMARY KEY (region, id)
 ) WITH (fillfactor = 70);
 
+-- Revision transitions per gateway instance, for rollout convergence charts.
+-- Pruned on write to revisionHistoryPerInstance rows and revisionHistoryMaxAge.
+CREATE TABLE IF NOT EXISTS gateway_revision_events (
+    id              BIGSERIAL PRIMARY KEY,
+    region          TEXT NOT NULL,
+    instance_id     TEXT NOT NULL,
+    config_revision BIGINT NOT NULL,
+    observed_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_gateway_revision_events_instance ON gateway_revision_events(region, instance_id, id);
+CREATE INDEX IF NOT EXISTS idx_gateway_revision_events_observed ON gateway_revision_events(observed_at);
+
 CREATE TABLE IF NOT EXISTS controller_status (
     region            TEXT NOT NULL DEFAULT 'default',
     id                TEXT NOT NULL,
@@ -129,6 +325,20 @@ CREATE TABLE IF NOT EXISTS controller_status (
     PRIMARY KEY (region, id)
 ) WITH (fillfactor = 70);
 
+-- Controller leadership transitions, for correlating sync gaps with leader
+-- flaps. Pruned on write to leadershipHistoryPerRegion rows and
+-- leadershipHistoryMaxAge.
+CREATE TABLE IF NOT EXISTS controller_leadership_events (
+    id              BIGSERIAL PRIMARY KEY,
+    region          TEXT NOT NULL,
+    controller_id   TEXT NOT NULL,
+    is_leader       BOOLEAN NOT NULL,
+    config_revision BIGINT NOT NULL DEFAULT 0,
+    occurred_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_controller_leadership_events_region ON controller_leadership_events(region, id);
+CREATE INDEX IF NOT EXISTS idx_controller_leadership_events_occurred ON controller_leadership_events(occurred_at);
+
 -- ── Credentials (HMAC) ──────────────────────────
 CREATE TABLE IF NOT EXISTS api_credentials (
     id          BIGSERIAL PRIMARY KEY,
@@ -142,6 +352,19 @@ CREATE TABLE IF NOT EXISTS api_credentials (
     updated_at  TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
 
+-- ── Service accounts (bearer token) ─────────────
+CREATE TABLE IF NOT EXISTS service_accounts (
+    id           BIGSERIAL PRIMARY KEY,
+    region       TEXT NOT NULL DEFAULT 'default',
+    name         TEXT NOT NULL,
+    description  TEXT NOT NULL DEFAULT '',
+    scopes       TEXT[] NOT NULL DEFAULT '{}',
+    token_hash   TEXT NOT NULL UNIQUE,             -- SHA-256 hex of the token
+    token_prefix TEXT NOT NULL DEFAULT '',
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (region, name)
+);
+
 -- ── RBAC ─────────────────────────────────────────
 CREATE TABLE IF NOT EXISTS users (
     sub        TEXT PRIMARY KEY,
@@ -162,6 +385,39 @@ DO $$ BEGIN
     ALTER TABLE users ADD COLUMN IF NOT EXISTS must_change_password BOOLEAN NOT NULL DEFAULT FALSE;
 EXCEPTION WHEN others THEN NULL;
 END $$;
+-- Migration: add TOTP two-factor columns (idempotent). totp_secret is sealed
+-- with the server master key, never stored in plaintext.
+DO $$ BEGIN
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret TEXT NOT NULL DEFAULT '';
+    ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+EXCEPTION WHEN others THEN NULL;
+END $$;
+-- Migration: last accepted TOTP time step, for replay protection (idempotent).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT NOT NULL DEFAULT 0;
+-- Migration: per-user token cutoff for revoking active sessions (idempotent).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sessions_valid_after TIMESTAMPTZ;
+-- Migration: reversible account disable (idempotent).
+ALTER TABLE users ADD COLUMN IF NOT EXISTS enabled BOOLEAN NOT NULL DEFAULT TRUE;
+
+CREATE TABLE IF NOT EXISTS password_history (
+    id            BIGSERIAL PRIMARY KEY,
+    user_sub      TEXT NOT NULL REFERENCES users(sub) ON DELETE CASCADE,
+    password_hash TEXT NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS idx_password_history_user ON password_history(user_sub, id DESC);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    token_hash TEXT PRIMARY KEY,                 -- SHA-256 hex of the token
+    family_id  TEXT NOT NULL,                    -- shared by all rotations of one login
+    user_sub   TEXT NOT NULL REFERENCES users(sub) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    used_at    TIMESTAMPTZ,
+    revoked    BOOLEAN NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family ON refresh_tokens(family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires ON refresh_tokens(expires_at);
 
 CREATE TABLE IF NOT EXISTS region_members (
     region     TEXT NOT NULL,
@@ -182,6 +438,26 @@ CREATE TABLE IF NOT EXISTS group_bindings (
 );
 CREATE INDEX IF NOT EXISTS idx_group_bindings_region ON group_bindings(region);
 
+CREATE TABLE IF NOT EXISTS custom_roles (
+    region     TEXT NOT NULL,
+    name       TEXT NOT NULL,
+    scopes     TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (region, name)
+);
+
+-- ── Secrets (sealed with the master key) ────────
+CREATE TABLE IF NOT EXISTS secrets (
+    region       TEXT NOT NULL,
+    name         TEXT NOT NULL,
+    description  TEXT NOT NULL DEFAULT '',
+    sealed_value TEXT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (region, name)
+);
+
 -- ── Misc ─────────────────────────────────────────
 CREATE TABLE IF NOT EXISTS grafana_dashboards (
     id     BIGSERIAL PRIMARY KEY,
@@ -199,6 +475,53 @@ CREATE TABLE IF NOT EXISTS jwt_signing_keys (
     expires_at TIMESTAMPTZ                        -- NULL for active, set when retired
 );
 CREATE INDEX IF NOT EXISTS idx_jwt_keys_status ON jwt_signing_keys(status);
+-- Migration: signing algorithm (idempotent). Existing keys are HMAC secrets.
+DO $$ BEGIN
+    ALTER TABLE jwt_signing_keys ADD COLUMN IF NOT EXISTS algorithm TEXT NOT NULL DEFAULT 'HS256';
+EXCEPTION WHEN others THEN NULL;
+END $$;
+
+-- ── OIDC login state (PKCE) ─────────────────────
+CREATE TABLE IF NOT EXISTS oidc_auth_states (
+    state         TEXT PRIMARY KEY,
+    code_verifier TEXT NOT NULL,
+    redirect_path TEXT NOT NULL DEFAULT '/',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_oidc_auth_states_expires ON oidc_auth_states(expires_at);
+
+-- ── Builtin login throttling ────────────────────
+CREATE TABLE IF NOT EXISTS login_attempts (
+    email        TEXT PRIMARY KEY,
+    failures     INT NOT NULL DEFAULT 0,
+    window_start TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMPTZ
+);
+
+-- ── Idempotency keys ────────────────────────────
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+    key          TEXT NOT NULL,
+    region       TEXT NOT NULL,
+    route        TEXT NOT NULL,
+    fingerprint  TEXT NOT NULL,
+    status       INT NOT NULL DEFAULT 0,
+    content_type TEXT NOT NULL DEFAULT '',
+    body         BYTEA,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    expires_at   TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (key, region, route)
+);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires ON idempotency_keys(expires_at);
+
+-- ── Maintenance mode (single row) ───────────────
+CREATE TABLE IF NOT EXISTS maintenance (
+    id         BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+    enabled    BOOLEAN NOT NULL DEFAULT FALSE,
+    message    TEXT NOT NULL DEFAULT '',
+    updated_by TEXT NOT NULL DEFAULT '',
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
 `
 	if _, err := s.db.ExecContext(ctx, ddl); err != nil {
 		return fmt.Errorf("pg migrate: %w", err)
@@ -208,7 +531,7 @@ CREATE INDEX IF NOT EXISTS idx_jwt_keys_status ON jwt_signing_keys(status);
 
 // Domain CRUD
 func (s *PgStore) ListDomains(ctx context.Context, region string) ([]model.DomainConfig, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT config FROM domains WHERE region = $1 ORDER BY name`, region)
+	rows, err := s.reader(ctx).QueryContext(ctx, `SELECT config FROM domains WHERE region = $1 ORDER BY name`, region)
 	if err != nil {
 		return nil, fmt.Errorf("pg list domains: %w", err)
 	}
@@ -230,21 +553,217 @@ func (s *PgStore) ListDomains(ctx context.Context, region string) ([]model.Domai
 	return domains, rows.Err()
 }
 
+func (s *PgStore) ListDomainsByLabels(ctx context.Context, region string, selector map[string]string) ([]model.DomainConfig, error) {
+	sel, err := json.Marshal(selector)
+	if err != nil {
+		return nil, fmt.Errorf("marshal label selector: %w", err)
+	}
+	rows, err := s.reader(ctx).QueryContext(ctx,
+		`SELECT config FROM domains WHERE region = $1 AND config->'labels' @> $2::jsonb ORDER BY name`, region, sel)
+	if err != nil {
+		return nil, fmt.Errorf("pg list domains by labels: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []model.DomainConfig
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("pg scan domain: %w", err)
+		}
+		var d model.DomainConfig
+		if err := json.Unmarshal(data, &d); err != nil {
+			s.logger.Warnf("skipping corrupt domain: %v", err)
+			continue
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
 func (s *PgStore) GetDomain(ctx context.Context, region, name string) (*model.DomainConfig, int64, error) {
+	d, meta, err := s.GetDomainWithMeta(ctx, region, name)
+	if err != nil || meta == nil {
+		return nil, 0, err
+	}
+	return d, meta.ResourceVersion, nil
+}
+
+func (s *PgStore) GetDomainWithMeta(ctx context.Context, region, name string) (*model.DomainConfig, *ResourceMeta, error) {
 	var data []byte
-	var rv int64
-	err := s.db.QueryRowContext(ctx, `SELECT config, resource_version FROM domains WHERE region = $1 AND name = $2`, region, name).Scan(&data, &rv)
+	var meta ResourceMeta
+	err := s.db.QueryRowContext(ctx, `
+		SELECT r.config, r.resource_version, r.updated_at, COALESCE(h.operator, '')
+		FROM domains r
+		LEFT JOIN LATERAL (
+			SELECT operator FROM config_history
+			WHERE region = r.region AND kind = 'domain' AND name = r.name
+			ORDER BY version DESC LIMIT 1
+		) h ON TRUE
+		WHERE r.region = $1 AND r.name = $2`, region, name).
+		Scan(&data, &meta.ResourceVersion, &meta.UpdatedAt, &meta.LastOperator)
 	if err == sql.ErrNoRows {
-		return nil, 0, nil
+		return nil, nil, nil
 	}
 	if err != nil {
-		return nil, 0, fmt.Errorf("pg get domain: %w", err)
+		return nil, nil, fmt.Errorf("pg get domain: %w", err)
 	}
 	var d model.DomainConfig
 	if err := json.Unmarshal(data, &d); err != nil {
-		return nil, 0, fmt.Errorf("unmarshal domain: %w", err)
+		return nil, nil, fmt.Errorf("unmarshal domain: %w", err)
+	}
+	return &d, &meta, nil
+}
+
+func (s *PgStore) ListDomainsWithMeta(ctx context.Context, region string, selector map[string]string) ([]model.DomainConfig, []ResourceMeta, error) {
+	return listWithMeta[model.DomainConfig](ctx, s, "domains", "domain", region, selector)
+}
+
+// listWithMeta reads a domains-shaped table together with each row's
+// metadata in one query, the latest operator coming from a lateral join on
+// config_history. A nil selector matches every row.
+func listWithMeta[T any](ctx context.Context, s *PgStore, table, kind, region string, selector map[string]string) ([]T, []ResourceMeta, error) {
+	var sel any
+	if selector != nil {
+		data, err := json.Marshal(selector)
+		if err != nil {
+			return nil, nil, fmt.Errorf("marshal label selector: %w", err)
+		}
+		sel = data
+	}
+	rows, err := s.reader(ctx).QueryContext(ctx, `
+		SELECT r.config, r.resource_version, r.updated_at, COALESCE(h.operator, '')
+		FROM `+table+` r
+		LEFT JOIN LATERAL (
+			SELECT operator FROM config_history
+			WHERE region = r.region AND kind = $2 AND name = r.name
+			ORDER BY version DESC LIMIT 1
+		) h ON TRUE
+		WHERE r.region = $1 AND ($3::jsonb IS NULL OR r.config->'labels' @> $3::jsonb)
+		ORDER BY r.name`, region, kind, sel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("pg list %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	var items []T
+	var metas []ResourceMeta
+	for rows.Next() {
+		var data []byte
+		var meta ResourceMeta
+		if err := rows.Scan(&data, &meta.ResourceVersion, &meta.UpdatedAt, &meta.LastOperator); err != nil {
+			return nil, nil, fmt.Errorf("pg scan %s: %w", kind, err)
+		}
+		var item T
+		if err := json.Unmarshal(data, &item); err != nil {
+			s.logger.Warnf("skipping corrupt %s: %v", kind, err)
+			continue
+		}
+		items = append(items, item)
+		metas = append(metas, meta)
+	}
+	return items, metas, rows.Err()
+}
+
+func (s *PgStore) FindDomainsByHost(ctx context.Context, region string, hosts ...string) ([]model.DomainConfig, error) {
+	if len(hosts) == 0 {
+		return nil, nil
+	}
+	rows, err := s.reader(ctx).QueryContext(ctx,
+		`SELECT config FROM domains WHERE region = $1 AND config->'hosts' ?| $2 ORDER BY name`,
+		region, pq.Array(hosts))
+	if err != nil {
+		return nil, fmt.Errorf("pg find domains by host: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []model.DomainConfig
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("pg scan domain: %w", err)
+		}
+		var d model.DomainConfig
+		if err := json.Unmarshal(data, &d); err != nil {
+			s.logger.Warnf("skipping corrupt domain: %v", err)
+			continue
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+func (s *PgStore) FindDomainsByCluster(ctx context.Context, region, cluster string) ([]model.DomainConfig, error) {
+	match, err := json.Marshal([]map[string]any{{"clusters": []map[string]string{{"name": cluster}}}})
+	if err != nil {
+		return nil, fmt.Errorf("marshal cluster match: %w", err)
+	}
+	rows, err := s.reader(ctx).QueryContext(ctx,
+		`SELECT config FROM domains WHERE region = $1 AND config->'routes' @> $2::jsonb ORDER BY name`,
+		region, match)
+	if err != nil {
+		return nil, fmt.Errorf("pg find domains by cluster: %w", err)
+	}
+	defer rows.Close()
+
+	var domains []model.DomainConfig
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("pg scan domain: %w", err)
+		}
+		var d model.DomainConfig
+		if err := json.Unmarshal(data, &d); err != nil {
+			s.logger.Warnf("skipping corrupt domain: %v", err)
+			continue
+		}
+		domains = append(domains, d)
+	}
+	return domains, rows.Err()
+}
+
+// likeEscaper escapes LIKE wildcards so a search term matches literally.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func (s *PgStore) Search(ctx context.Context, region, q string, limit int) ([]SearchResult, error) {
+	term := likeEscaper.Replace(q)
+	rows, err := s.reader(ctx).QueryContext(ctx, `
+		WITH hits AS (
+			SELECT 'domain' AS type, name, 'name' AS match_field, name AS value
+			FROM domains WHERE region = $1 AND name ILIKE $2
+			UNION ALL
+			SELECT 'domain', d.name, 'host', h.host
+			FROM domains d CROSS JOIN LATERAL jsonb_array_elements_text(d.config->'hosts') AS h(host)
+			WHERE d.region = $1 AND h.host ILIKE $2
+			UNION ALL
+			SELECT 'cluster', name, 'name', name
+			FROM clusters WHERE region = $1 AND name ILIKE $2
+		), ranked AS (
+			SELECT type, name, match_field, value,
+				CASE WHEN lower(value) = lower($3) THEN 0 WHEN value ILIKE $4 THEN 1 ELSE 2 END AS rank
+			FROM hits
+		), best AS (
+			SELECT DISTINCT ON (type, name) type, name, match_field, value, rank
+			FROM ranked ORDER BY type, name, rank, match_field DESC
+		)
+		SELECT type, name, match_field, value FROM best
+		ORDER BY rank, name, type
+		LIMIT $5`,
+		region, "%"+term+"%", q, term+"%", limit)
+	if err != nil {
+		return nil, fmt.Errorf("pg search: %w", err)
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var res SearchResult
+		if err := rows.Scan(&res.Type, &res.Name, &res.MatchField, &res.Match); err != nil {
+			return nil, fmt.Errorf("pg scan search result: %w", err)
+		}
+		results = append(results, res)
 	}
-	return &d, rv, nil
+	return results, rows.Err()
 }
 
 func (s *PgStore) PutDomain(ctx context.Context, region string, domain *model.DomainConfig, action, operator string, expectedVersion int64) (int64, error) {
@@ -253,25 +772,128 @@ func (s *PgStore) PutDomain(ctx context.Context, region string, domain *model.Do
 		return 0, fmt.Errorf("marshal domain: %w", err)
 	}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+	// The host check runs under the region lock on the primary, so two
+	// concurrent writes cannot both claim a host. Rollbacks and imports
+	// (expectedVersion -1) restore a prior state as-is.
+	var version int64
+	err = s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		if expectedVersion >= 0 {
+			if err := checkHostConflictTx(ctx, tx, region, domain); err != nil {
+				return err
+			}
+		}
+		version, err = s.putResourceTx(ctx, tx, region, "domain", domain.Name, data, action, operator, expectedVersion)
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("pg begin tx: %w", err)
+		return 0, err
 	}
-	defer tx.Rollback()
 
-	// Optimistic concurrency control.
-	// expectedVersion == 0 means "create" — the row must NOT exist.
-	// expectedVersion == -1 means "bypass OCC" (used by rollback/import).
-	// expectedVersion > 0 means "update" — the current resource_version must match.
+	if noop := SkipNoopFromContext(ctx); noop != nil && noop.Skipped {
+		s.logger.Infof("domain unchanged: region=%s name=%s, operator=%s, version=%d", region, domain.Name, operator, version)
+		return version, nil
+	}
+
+	go s.pruneHistory(context.Background(), region, "domain", domain.Name)
+
+	s.logger.Infof("domain written: region=%s name=%s, action=%s, operator=%s, version=%d", region, domain.Name, action, operator, version)
+	return version, nil
+}
+
+func (s *PgStore) DeleteDomain(ctx context.Context, region, name, operator string) (int64, error) {
+	var version int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		var err error
+		version, err = s.deleteResourceTx(ctx, tx, region, "domain", name, operator)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Infof("domain deleted: region=%s name=%s, operator=%s, version=%d", region, name, operator, version)
+	return version, nil
+}
+
+// checkHostConflictTx returns a *HostConflictError if a domain other than d
+// claims one of d's hosts.
+func checkHostConflictTx(ctx context.Context, tx *tracedTx, region string, d *model.DomainConfig) error {
+	if len(d.Hosts) == 0 {
+		return nil
+	}
+	rows, err := tx.QueryContext(ctx,
+		`SELECT name, config->'hosts' FROM domains WHERE region = $1 AND name <> $2 AND config->'hosts' ?| $3 ORDER BY name`,
+		region, d.Name, pq.Array(d.Hosts))
+	if err != nil {
+		return fmt.Errorf("pg check host conflict: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var data []byte
+		if err := rows.Scan(&name, &data); err != nil {
+			return fmt.Errorf("pg scan host claimant: %w", err)
+		}
+		var hosts []string
+		if err := json.Unmarshal(data, &hosts); err != nil {
+			continue
+		}
+		for _, host := range d.Hosts {
+			if slices.Contains(hosts, host) {
+				return &HostConflictError{Host: host, Domain: name}
+			}
+		}
+	}
+	return rows.Err()
+}
+
+// resourceTables maps a resource kind to its table.
+var resourceTables = map[string]string{"domain": "domains", "cluster": "clusters"}
+
+// putResourceTx writes one domain or cluster inside tx and records its
+// history and change_log rows. The caller holds the region config lock.
+//
+// expectedVersion == 0 means "create" — the row must NOT exist.
+// expectedVersion == -1 means "bypass OCC" (used by rollback/import).
+// expectedVersion > 0 means "update" — the current resource_version must match.
+func (s *PgStore) putResourceTx(ctx context.Context, tx *tracedTx, region, kind, name string, data []byte, action, operator string, expectedVersion int64) (int64, error) {
+	table := resourceTables[kind]
+	if noop := SkipNoopFromContext(ctx); noop != nil && expectedVersion != 0 {
+		noop.Skipped = false
+		// jsonb equality ignores key order and whitespace.
+		var rv int64
+		var same bool
+		err := tx.QueryRowContext(ctx,
+			`SELECT resource_version, config = $3::jsonb FROM `+table+` WHERE region = $1 AND name = $2`,
+			region, name, data).Scan(&rv, &same)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, fmt.Errorf("pg compare %s: %w", kind, err)
+		}
+		// A stale expected version still conflicts below.
+		if err == nil && same && (expectedVersion < 0 || rv == expectedVersion) {
+			noop.Skipped = true
+			version, err := s.nextVersionTx(ctx, tx, region, kind, name)
+			if err != nil {
+				return 0, err
+			}
+			return version - 1, nil
+		}
+	}
 	if expectedVersion == 0 {
 		// Create: INSERT ... ON CONFLICT DO NOTHING, then check affected rows.
 		res, err := tx.ExecContext(ctx,
-			`INSERT INTO domains (region, name, config, resource_version, updated_at)
+			`INSERT INTO `+table+` (region, name, config, resource_version, updated_at)
 			 VALUES ($1, $2, $3, 1, NOW())
 			 ON CONFLICT (region, name) DO NOTHING`,
-			region, domain.Name, data)
+			region, name, data)
 		if err != nil {
-			return 0, fmt.Errorf("pg insert domain: %w", err)
+			return 0, fmt.Errorf("pg insert %s: %w", kind, err)
 		}
 		n, _ := res.RowsAffected()
 		if n == 0 {
@@ -280,11 +902,11 @@ func (s *PgStore) PutDomain(ctx context.Context, region string, domain *model.Do
 	} else if expectedVersion > 0 {
 		// Update with OCC: only update if resource_version matches.
 		res, err := tx.ExecContext(ctx,
-			`UPDATE domains SET config = $3, resource_version = resource_version + 1, updated_at = NOW()
+			`UPDATE `+table+` SET config = $3, resource_version = resource_version + 1, updated_at = NOW()
 			 WHERE region = $1 AND name = $2 AND resource_version = $4`,
-			region, domain.Name, data, expectedVersion)
+			region, name, data, expectedVersion)
 		if err != nil {
-			return 0, fmt.Errorf("pg update domain: %w", err)
+			return 0, fmt.Errorf("pg update %s: %w", kind, err)
 		}
 		n, _ := res.RowsAffected()
 		if n == 0 {
@@ -292,97 +914,81 @@ func (s *PgStore) PutDomain(ctx context.Context, region string, domain *model.Do
 		}
 	} else {
 		// Bypass OCC (expectedVersion == -1): unconditional upsert.
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO domains (region, name, config, resource_version, updated_at)
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO `+table+` (region, name, config, resource_version, updated_at)
 			 VALUES ($1, $2, $3, 1, NOW())
-			 ON CONFLICT (region, name) DO UPDATE SET config = $3, resource_version = domains.resource_version + 1, updated_at = NOW()`,
-			region, domain.Name, data)
+			 ON CONFLICT (region, name) DO UPDATE SET config = $3, resource_version = `+table+`.resource_version + 1, updated_at = NOW()`,
+			region, name, data)
 		if err != nil {
-			return 0, fmt.Errorf("pg upsert domain: %w", err)
+			return 0, fmt.Errorf("pg upsert %s: %w", kind, err)
 		}
 	}
 
-	version, err := s.nextVersion(ctx, tx, region, "domain", domain.Name)
+	version, err := s.nextVersion(ctx, tx, region, kind, name)
 	if err != nil {
 		return 0, err
 	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'domain', $2, $3, $4, $5, $6)`,
-		region, domain.Name, version, action, operator, data)
+		`INSERT INTO config_history (region, kind, name, version, action, operator, config, reason) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		region, kind, name, version, action, operator, data, ChangeReasonFromContext(ctx))
 	if err != nil {
-		return 0, fmt.Errorf("pg insert domain history: %w", err)
+		return 0, fmt.Errorf("pg insert %s history: %w", kind, err)
 	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'domain', $2, $3, $4, $5)`,
-		region, domain.Name, action, operator, data)
+		`INSERT INTO change_log (region, kind, name, action, operator, config, reason) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		region, kind, name, action, operator, data, ChangeReasonFromContext(ctx))
 	if err != nil {
 		return 0, fmt.Errorf("pg insert change_log: %w", err)
 	}
-
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("pg commit: %w", err)
-	}
-
-	go s.pruneHistory(context.Background(), region, "domain", domain.Name)
-
-	s.logger.Infof("domain written: region=%s name=%s, action=%s, operator=%s, version=%d", region, domain.Name, action, operator, version)
 	return version, nil
 }
 
-func (s *PgStore) DeleteDomain(ctx context.Context, region, name, operator string) (int64, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return 0, fmt.Errorf("pg begin tx: %w", err)
-	}
-	defer tx.Rollback()
+// deleteResourceTx deletes one domain or cluster inside tx and records its
+// history and change_log rows. The caller holds the region config lock.
+func (s *PgStore) deleteResourceTx(ctx context.Context, tx *tracedTx, region, kind, name, operator string) (int64, error) {
+	table := resourceTables[kind]
 
 	// Read current value inside the transaction to avoid TOCTOU.
 	var configData []byte
-	err = tx.QueryRowContext(ctx, `SELECT config FROM domains WHERE region = $1 AND name = $2`, region, name).Scan(&configData)
+	err := tx.QueryRowContext(ctx, `SELECT config FROM `+table+` WHERE region = $1 AND name = $2`, region, name).Scan(&configData)
 	if err == sql.ErrNoRows {
-		return 0, fmt.Errorf("domain %q not found", name)
+		return 0, fmt.Errorf("%s %q not found", kind, name)
 	}
 	if err != nil {
-		return 0, fmt.Errorf("pg get domain for delete: %w", err)
+		return 0, fmt.Errorf("pg get %s for delete: %w", kind, err)
 	}
 
-	_, err = tx.ExecContext(ctx, `DELETE FROM domains WHERE region = $1 AND name = $2`, region, name)
+	_, err = tx.ExecContext(ctx, `DELETE FROM `+table+` WHERE region = $1 AND name = $2`, region, name)
 	if err != nil {
-		return 0, fmt.Errorf("pg delete domain: %w", err)
+		return 0, fmt.Errorf("pg delete %s: %w", kind, err)
 	}
 
-	version, err := s.nextVersion(ctx, tx, region, "domain", name)
+	version, err := s.nextVersion(ctx, tx, region, kind, name)
 	if err != nil {
 		return 0, err
 	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'domain', $2, $3, 'delete', $4, $5)`,
-		region, name, version, operator, configData)
+		`INSERT INTO config_history (region, kind, name, version, action, operator, config, reason) VALUES ($1, $2, $3, $4, 'delete', $5, $6, $7)`,
+		region, kind, name, version, operator, configData, ChangeReasonFromContext(ctx))
 	if err != nil {
-		return 0, fmt.Errorf("pg insert domain delete history: %w", err)
+		return 0, fmt.Errorf("pg insert %s delete history: %w", kind, err)
 	}
 
 	_, err = tx.ExecContext(ctx,
-		`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'domain', $2, 'delete', $3, NULL)`,
-		region, name, operator)
+		`INSERT INTO change_log (region, kind, name, action, operator, config, reason) VALUES ($1, $2, $3, 'delete', $4, NULL, $5)`,
+		region, kind, name, operator, ChangeReasonFromContext(ctx))
 	if err != nil {
 		return 0, fmt.Errorf("pg insert change_log: %w", err)
 	}
-
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("pg commit: %w", err)
-	}
-
-	s.logger.Infof("domain deleted: region=%s name=%s, operator=%s, version=%d", region, name, operator, version)
 	return version, nil
 }
 
 // Cluster CRUD
 func (s *PgStore) ListClusters(ctx context.Context, region string) ([]model.ClusterConfig, error) {
-	rows, err := s.db.QueryContext(ctx, `SELECT config FROM clusters WHERE region = $1 ORDER BY name`, region)
+	rows, err := s.reader(ctx).QueryContext(ctx, `SELECT config FROM clusters WHERE region = $1 ORDER BY name`, region)
 	if err != nil {
 		return nil, fmt.Errorf("pg list clusters: %w", err)
 	}
@@ -404,93 +1010,94 @@ func (s *PgStore) ListClusters(ctx context.Context, region string) ([]model.Clus
 	return clusters, rows.Err()
 }
 
-func (s *PgStore) GetCluster(ctx context.Context, region, name string) (*model.ClusterConfig, int64, error) {
-	var data []byte
-	var rv int64
-	err := s.db.QueryRowContext(ctx, `SELECT config, resource_version FROM clusters WHERE region = $1 AND name = $2`, region, name).Scan(&data, &rv)
-	if err == sql.ErrNoRows {
-		return nil, 0, nil
+func (s *PgStore) ListClustersByLabels(ctx context.Context, region string, selector map[string]string) ([]model.ClusterConfig, error) {
+	sel, err := json.Marshal(selector)
+	if err != nil {
+		return nil, fmt.Errorf("marshal label selector: %w", err)
 	}
+	rows, err := s.reader(ctx).QueryContext(ctx,
+		`SELECT config FROM clusters WHERE region = $1 AND config->'labels' @> $2::jsonb ORDER BY name`, region, sel)
 	if err != nil {
-		return nil, 0, fmt.Errorf("pg get cluster: %w", err)
+		return nil, fmt.Errorf("pg list clusters by labels: %w", err)
 	}
-	var c model.ClusterConfig
-	if err := json.Unmarshal(data, &c); err != nil {
-		return nil, 0, fmt.Errorf("unmarshal cluster: %w", err)
+	defer rows.Close()
+
+	var clusters []model.ClusterConfig
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("pg scan cluster: %w", err)
+		}
+		var c model.ClusterConfig
+		if err := json.Unmarshal(data, &c); err != nil {
+			s.logger.Warnf("skipping corrupt cluster: %v", err)
+			continue
+		}
+		clusters = append(clusters, c)
 	}
-	return &c, rv, nil
+	return clusters, rows.Err()
 }
 
-func (s *PgStore) PutCluster(ctx context.Context, region string, cluster *model.ClusterConfig, action, operator string, expectedVersion int64) (int64, error) {
-	data, err := json.Marshal(cluster)
-	if err != nil {
-		return 0, fmt.Errorf("marshal cluster: %w", err)
+func (s *PgStore) GetCluster(ctx context.Context, region, name string) (*model.ClusterConfig, int64, error) {
+	c, meta, err := s.GetClusterWithMeta(ctx, region, name)
+	if err != nil || meta == nil {
+		return nil, 0, err
 	}
+	return c, meta.ResourceVersion, nil
+}
 
-	tx, err := s.db.BeginTx(ctx, nil)
+func (s *PgStore) GetClusterWithMeta(ctx context.Context, region, name string) (*model.ClusterConfig, *ResourceMeta, error) {
+	var data []byte
+	var meta ResourceMeta
+	err := s.db.QueryRowContext(ctx, `
+		SELECT r.config, r.resource_version, r.updated_at, COALESCE(h.operator, '')
+		FROM clusters r
+		LEFT JOIN LATERAL (
+			SELECT operator FROM config_history
+			WHERE region = r.region AND kind = 'cluster' AND name = r.name
+			ORDER BY version DESC LIMIT 1
+		) h ON TRUE
+		WHERE r.region = $1 AND r.name = $2`, region, name).
+		Scan(&data, &meta.ResourceVersion, &meta.UpdatedAt, &meta.LastOperator)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
 	if err != nil {
-		return 0, fmt.Errorf("pg begin tx: %w", err)
+		return nil, nil, fmt.Errorf("pg get cluster: %w", err)
 	}
-	defer tx.Rollback()
-
-	// Optimistic concurrency control (same semantics as PutDomain).
-	if expectedVersion == 0 {
-		res, err := tx.ExecContext(ctx,
-			`INSERT INTO clusters (region, name, config, resource_version, updated_at)
-			 VALUES ($1, $2, $3, 1, NOW())
-			 ON CONFLICT (region, name) DO NOTHING`,
-			region, cluster.Name, data)
-		if err != nil {
-			return 0, fmt.Errorf("pg insert cluster: %w", err)
-		}
-		n, _ := res.RowsAffected()
-		if n == 0 {
-			return 0, ErrConflict
-		}
-	} else if expectedVersion > 0 {
-		res, err := tx.ExecContext(ctx,
-			`UPDATE clusters SET config = $3, resource_version = resource_version + 1, updated_at = NOW()
-			 WHERE region = $1 AND name = $2 AND resource_version = $4`,
-			region, cluster.Name, data, expectedVersion)
-		if err != nil {
-			return 0, fmt.Errorf("pg update cluster: %w", err)
-		}
-		n, _ := res.RowsAffected()
-		if n == 0 {
-			return 0, ErrConflict
-		}
-	} else {
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO clusters (region, name, config, resource_version, updated_at)
-			 VALUES ($1, $2, $3, 1, NOW())
-			 ON CONFLICT (region, name) DO UPDATE SET config = $3, resource_version = clusters.resource_version + 1, updated_at = NOW()`,
-			region, cluster.Name, data)
-		if err != nil {
-			return 0, fmt.Errorf("pg upsert cluster: %w", err)
-		}
+	var c model.ClusterConfig
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, nil, fmt.Errorf("unmarshal cluster: %w", err)
 	}
+	return &c, &meta, nil
+}
 
-	version, err := s.nextVersion(ctx, tx, region, "cluster", cluster.Name)
-	if err != nil {
-		return 0, err
-	}
+func (s *PgStore) ListClustersWithMeta(ctx context.Context, region string, selector map[string]string) ([]model.ClusterConfig, []ResourceMeta, error) {
+	return listWithMeta[model.ClusterConfig](ctx, s, "clusters", "cluster", region, selector)
+}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'cluster', $2, $3, $4, $5, $6)`,
-		region, cluster.Name, version, action, operator, data)
+func (s *PgStore) PutCluster(ctx context.Context, region string, cluster *model.ClusterConfig, action, operator string, expectedVersion int64) (int64, error) {
+	data, err := json.Marshal(cluster)
 	if err != nil {
-		return 0, fmt.Errorf("pg insert cluster history: %w", err)
+		return 0, fmt.Errorf("marshal cluster: %w", err)
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'cluster', $2, $3, $4, $5)`,
-		region, cluster.Name, action, operator, data)
+	// Optimistic concurrency control has the same semantics as PutDomain.
+	var version int64
+	err = s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		version, err = s.putResourceTx(ctx, tx, region, "cluster", cluster.Name, data, action, operator, expectedVersion)
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("pg insert change_log: %w", err)
+		return 0, err
 	}
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("pg commit: %w", err)
+	if noop := SkipNoopFromContext(ctx); noop != nil && noop.Skipped {
+		s.logger.Infof("cluster unchanged: region=%s name=%s, operator=%s, version=%d", region, cluster.Name, operator, version)
+		return version, nil
 	}
 
 	go s.pruneHistory(context.Background(), region, "cluster", cluster.Name)
@@ -500,260 +1107,785 @@ func (s *PgStore) PutCluster(ctx context.Context, region string, cluster *model.
 }
 
 func (s *PgStore) DeleteCluster(ctx context.Context, region, name, operator string) (int64, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return 0, fmt.Errorf("pg begin tx: %w", err)
-	}
-	defer tx.Rollback()
-
-	// Read current value inside the transaction to avoid TOCTOU.
-	var configData []byte
-	err = tx.QueryRowContext(ctx, `SELECT config FROM clusters WHERE region = $1 AND name = $2`, region, name).Scan(&configData)
-	if err == sql.ErrNoRows {
-		return 0, fmt.Errorf("cluster %q not found", name)
-	}
-	if err != nil {
-		return 0, fmt.Errorf("pg get cluster for delete: %w", err)
-	}
-
-	_, err = tx.ExecContext(ctx, `DELETE FROM clusters WHERE region = $1 AND name = $2`, region, name)
-	if err != nil {
-		return 0, fmt.Errorf("pg delete cluster: %w", err)
-	}
-
-	version, err := s.nextVersion(ctx, tx, region, "cluster", name)
+	var version int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		var err error
+		version, err = s.deleteResourceTx(ctx, tx, region, "cluster", name, operator)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'cluster', $2, $3, 'delete', $4, $5)`,
-		region, name, version, operator, configData)
-	if err != nil {
-		return 0, fmt.Errorf("pg insert cluster delete history: %w", err)
-	}
-
-	_, err = tx.ExecContext(ctx,
-		`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'cluster', $2, 'delete', $3, NULL)`,
-		region, name, operator)
-	if err != nil {
-		return 0, fmt.Errorf("pg insert change_log: %w", err)
-	}
-
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("pg commit: %w", err)
-	}
-
 	s.logger.Infof("cluster deleted: region=%s name=%s, operator=%s, version=%d", region, name, operator, version)
 	return version, nil
 }
 
 // Bulk operations
-func (s *PgStore) PutAllConfig(ctx context.Context, region string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string) (int64, error) {
-	tx, err := s.db.BeginTx(ctx, nil)
+func (s *PgStore) PutAllConfig(ctx context.Context, region string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string, expectedRevision int64) (int64, error) {
+	var newRevision int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		var err error
+		newRevision, err = s.putAllConfigTx(ctx, tx, region, domains, clusters, operator, expectedRevision)
+		return err
+	})
 	if err != nil {
-		return 0, fmt.Errorf("pg begin tx: %w", err)
+		return 0, err
+	}
+
+	s.logger.Infof("all config replaced: region=%s, domains=%d, clusters=%d, revision=%d", region, len(domains), len(clusters), newRevision)
+	return newRevision, nil
+}
+
+// putAllConfigTx is PutAllConfig within tx, which must hold the region
+// config lock.
+func (s *PgStore) putAllConfigTx(ctx context.Context, tx *tracedTx, region string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string, expectedRevision int64) (int64, error) {
+	if expectedRevision >= 0 {
+		current, err := configRevision(ctx, tx, region)
+		if err != nil {
+			return 0, err
+		}
+		if current != expectedRevision {
+			return 0, ErrConflict
+		}
 	}
-	defer tx.Rollback()
 
-	// Clear existing within region
-	if _, err := tx.ExecContext(ctx, `DELETE FROM domains WHERE region = $1`, region); err != nil {
+	// Clear existing within region, keeping what was there so resources the
+	// import drops get delete events like any other delete.
+	oldDomains, err := deleteRegionRowsTx(ctx, tx, "domains", region)
+	if err != nil {
 		return 0, fmt.Errorf("pg truncate domains: %w", err)
 	}
-	if _, err := tx.ExecContext(ctx, `DELETE FROM clusters WHERE region = $1`, region); err != nil {
+	oldClusters, err := deleteRegionRowsTx(ctx, tx, "clusters", region)
+	if err != nil {
 		return 0, fmt.Errorf("pg truncate clusters: %w", err)
 	}
 
-	// Insert clusters
+	// Batch the inserts: one multi-row statement per table instead of four
+	// round-trips per item. Clusters come first so change_log revisions keep
+	// the same cluster-then-domain order as before.
+	versions, err := s.latestVersionsTx(ctx, tx, region)
+	if err != nil {
+		return 0, err
+	}
+	type item struct {
+		kind, name string
+		data       []byte
+	}
+	items := make([]item, 0, len(clusters)+len(domains))
 	for i := range clusters {
 		data, err := json.Marshal(&clusters[i])
 		if err != nil {
 			return 0, fmt.Errorf("marshal cluster %s: %w", clusters[i].Name, err)
 		}
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO clusters (region, name, config) VALUES ($1, $2, $3)`,
-			region, clusters[i].Name, data)
-		if err != nil {
-			return 0, fmt.Errorf("pg insert cluster %s: %w", clusters[i].Name, err)
-		}
-		ver, err := s.nextVersionTx(ctx, tx, region, "cluster", clusters[i].Name)
-		if err != nil {
-			return 0, err
-		}
-		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'cluster', $2, $3, 'import', $4, $5)`,
-			region, clusters[i].Name, ver, operator, data); err != nil {
-			return 0, fmt.Errorf("pg insert cluster history (import): %w", err)
-		}
-		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'cluster', $2, 'import', $3, $4)`,
-			region, clusters[i].Name, operator, data); err != nil {
-			return 0, fmt.Errorf("pg insert cluster change_log (import): %w", err)
-		}
+		items = append(items, item{"cluster", clusters[i].Name, data})
 	}
-
-	// Insert domains
 	for i := range domains {
 		data, err := json.Marshal(&domains[i])
 		if err != nil {
 			return 0, fmt.Errorf("marshal domain %s: %w", domains[i].Name, err)
 		}
-		_, err = tx.ExecContext(ctx,
-			`INSERT INTO domains (region, name, config) VALUES ($1, $2, $3)`,
-			region, domains[i].Name, data)
-		if err != nil {
-			return 0, fmt.Errorf("pg insert domain %s: %w", domains[i].Name, err)
-		}
-		ver, err := s.nextVersionTx(ctx, tx, region, "domain", domains[i].Name)
-		if err != nil {
-			return 0, err
-		}
-		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO config_history (region, kind, name, version, action, operator, config) VALUES ($1, 'domain', $2, $3, 'import', $4, $5)`,
-			region, domains[i].Name, ver, operator, data); err != nil {
-			return 0, fmt.Errorf("pg insert domain history (import): %w", err)
+		items = append(items, item{"domain", domains[i].Name, data})
+	}
+
+	var clusterRows, domainRows, historyRows, changeRows [][]any
+	reason := ChangeReasonFromContext(ctx)
+	imported := make(map[string]bool, len(items))
+	for _, it := range items {
+		imported[it.kind+"/"+it.name] = true
+	}
+	// Dropped domains go before dropped clusters, as a consumer applying the
+	// events in order would delete them.
+	for _, old := range []struct {
+		kind string
+		rows map[string][]byte
+	}{{"domain", oldDomains}, {"cluster", oldClusters}} {
+		for _, name := range slices.Sorted(maps.Keys(old.rows)) {
+			key := old.kind + "/" + name
+			if imported[key] {
+				continue
+			}
+			versions[key]++
+			historyRows = append(historyRows, []any{region, old.kind, name, versions[key], "delete", operator, old.rows[name], reason})
+			changeRows = append(changeRows, []any{region, old.kind, name, "delete", operator, nil, reason})
 		}
-		if _, err := tx.ExecContext(ctx,
-			`INSERT INTO change_log (region, kind, name, action, operator, config) VALUES ($1, 'domain', $2, 'import', $3, $4)`,
-			region, domains[i].Name, operator, data); err != nil {
-			return 0, fmt.Errorf("pg insert domain change_log (import): %w", err)
+	}
+	for _, it := range items {
+		row := []any{region, it.name, it.data}
+		if it.kind == "cluster" {
+			clusterRows = append(clusterRows, row)
+		} else {
+			domainRows = append(domainRows, row)
 		}
+		ver := versions[it.kind+"/"+it.name] + 1
+		historyRows = append(historyRows, []any{region, it.kind, it.name, ver, "import", operator, it.data, reason})
+		changeRows = append(changeRows, []any{region, it.kind, it.name, "import", operator, it.data, reason})
 	}
 
-	if err := tx.Commit(); err != nil {
-		return 0, fmt.Errorf("pg commit: %w", err)
+	if err := insertRowsTx(ctx, tx, "clusters", []string{"region", "name", "config"}, clusterRows); err != nil {
+		return 0, fmt.Errorf("pg insert clusters (import): %w", err)
+	}
+	if err := insertRowsTx(ctx, tx, "domains", []string{"region", "name", "config"}, domainRows); err != nil {
+		return 0, fmt.Errorf("pg insert domains (import): %w", err)
+	}
+	if err := insertRowsTx(ctx, tx, "config_history",
+		[]string{"region", "kind", "name", "version", "action", "operator", "config", "reason"}, historyRows); err != nil {
+		return 0, fmt.Errorf("pg insert history (import): %w", err)
+	}
+	if err := insertRowsTx(ctx, tx, "change_log",
+		[]string{"region", "kind", "name", "action", "operator", "config", "reason"}, changeRows); err != nil {
+		return 0, fmt.Errorf("pg insert change_log (import): %w", err)
 	}
 
-	s.logger.Infof("all config replaced: region=%s, domairegion=%d, clusters=%d", region, len(domains), len(clusters))
-	return 0, nil
+	return configRevision(ctx, tx, region)
 }
 
-func (s *PgStore) GetConfig(ctx context.Context, region string) (*model.GatewayConfig, error) {
-	domains, err := s.ListDomains(ctx, region)
-	if err != nil {
-		return nil, err
+func (s *PgStore) ApplyBatch(ctx context.Context, region string, ops []BatchOp, operator string) ([]BatchResult, error) {
+	type write struct {
+		kind, name string
+		data       []byte
+	}
+	writes := make([]write, len(ops))
+	for i, op := range ops {
+		w := write{kind: op.Kind, name: op.Name}
+		var cfg any
+		switch {
+		case op.Op == "put" && op.Kind == "domain" && op.Domain != nil:
+			w.name, cfg = op.Domain.Name, op.Domain
+		case op.Op == "put" && op.Kind == "cluster" && op.Cluster != nil:
+			w.name, cfg = op.Cluster.Name, op.Cluster
+		case op.Op == "delete" && resourceTables[op.Kind] != "" && op.Name != "":
+		default:
+			return nil, fmt.Errorf("batch op %d: invalid %s %s", i, op.Kind, op.Op)
+		}
+		if cfg != nil {
+			data, err := json.Marshal(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("batch op %d: marshal %s: %w", i, op.Kind, err)
+			}
+			w.data = data
+		}
+		writes[i] = w
 	}
-	clusters, err := s.ListClusters(ctx, region)
+
+	results := make([]BatchResult, len(ops))
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		for i, op := range ops {
+			w := writes[i]
+			var version int64
+			var err error
+			if op.Op == "delete" {
+				version, err = s.deleteResourceTx(ctx, tx, region, w.kind, w.name, operator)
+			} else {
+				action := "update"
+				if op.ExpectedVersion == 0 {
+					action = "create"
+				}
+				if op.Kind == "domain" && op.ExpectedVersion >= 0 {
+					if err := checkHostConflictTx(ctx, tx, region, op.Domain); err != nil {
+						return fmt.Errorf("batch op %d (%s %s %q): %w", i, op.Op, w.kind, w.name, err)
+					}
+				}
+				version, err = s.putResourceTx(ctx, tx, region, w.kind, w.name, w.data, action, operator, op.ExpectedVersion)
+			}
+			if err != nil {
+				return fmt.Errorf("batch op %d (%s %s %q): %w", i, op.Op, w.kind, w.name, err)
+			}
+			results[i] = BatchResult{Op: op.Op, Kind: w.kind, Name: w.name, Version: version}
+		}
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	if domains == nil {
-		domains = []model.DomainConfig{}
-	}
-	if clusters == nil {
-		clusters = []model.ClusterConfig{}
+
+	for _, w := range writes {
+		if w.data != nil {
+			go s.pruneHistory(context.Background(), region, w.kind, w.name)
+		}
 	}
-	return &model.GatewayConfig{Domains: domains, Clusters: clusters}, nil
+	s.logger.Infof("batch applied: region=%s, ops=%d, operator=%s", region, len(ops), operator)
+	return results, nil
 }
 
-// Per-domain History
-func (s *PgStore) GetDomainHistory(ctx context.Context, region, name string) ([]HistoryEntry, error) {
-	return s.getHistory(ctx, region, "domain", name)
+func (s *PgStore) DeleteDomains(ctx context.Context, region string, names []string, operator string) ([]BulkDeleteResult, error) {
+	return s.deleteResources(ctx, region, "domain", "domains", names, operator)
 }
 
-func (s *PgStore) GetDomainVersion(ctx context.Context, region, name string, version int64) (*HistoryEntry, error) {
-	return s.getVersion(ctx, region, "domain", name, version)
+func (s *PgStore) DeleteClusters(ctx context.Context, region string, names []string, operator string) ([]BulkDeleteResult, error) {
+	return s.deleteResources(ctx, region, "cluster", "clusters", names, operator)
 }
 
-func (s *PgStore) RollbackDomain(ctx context.Context, region, name string, version int64, operator string) (int64, error) {
-	entry, err := s.GetDomainVersion(ctx, region, name, version)
+// DeleteOrphanClusters deletes the region's clusters that no domain route
+// references and returns their names. References are read under the region
+// config lock, in the same transaction as the delete, so a domain written
+// meanwhile cannot start routing to a cluster that is being deleted.
+func (s *PgStore) DeleteOrphanClusters(ctx context.Context, region, operator string) ([]string, error) {
+	var deleted []string
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		rows, err := tx.QueryContext(ctx, `
+			SELECT c.name FROM clusters c
+			WHERE c.region = $1 AND NOT EXISTS (
+				SELECT 1 FROM domains d
+				WHERE d.region = c.region
+				  AND jsonb_path_exists(d.config, '$.routes[*].clusters[*] ? (@.name == $n)', jsonb_build_object('n', c.name))
+			)
+			ORDER BY c.name`, region)
+		if err != nil {
+			return fmt.Errorf("pg find orphan clusters: %w", err)
+		}
+		var names []string
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return fmt.Errorf("pg scan orphan cluster: %w", err)
+			}
+			names = append(names, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("pg find orphan clusters: %w", err)
+		}
+		if len(names) == 0 {
+			return nil
+		}
+
+		results, err := s.deleteResourcesTx(ctx, tx, region, "cluster", "clusters", names, operator)
+		if err != nil {
+			return err
+		}
+		for _, res := range results {
+			if res.Deleted {
+				deleted = append(deleted, res.Name)
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		return 0, err
-	}
-	if entry == nil {
-		return 0, fmt.Errorf("domain %q version %d not found", name, version)
-	}
-	if entry.Domain == nil {
-		return 0, fmt.Errorf("domain %q version %d is a delete entry, cannot rollback", name, version)
+		return nil, err
 	}
-	return s.PutDomain(ctx, region, entry.Domain, "rollback", operator, -1)
-}
-
-// Per-cluster History
-func (s *PgStore) GetClusterHistory(ctx context.Context, region, name string) ([]HistoryEntry, error) {
-	return s.getHistory(ctx, region, "cluster", name)
-}
 
-func (s *PgStore) GetClusterVersion(ctx context.Context, region, name string, version int64) (*HistoryEntry, error) {
-	return s.getVersion(ctx, region, "cluster", name, version)
+	s.logger.Infof("orphan clusters deleted: region=%s, deleted=%v, operator=%s", region, deleted, operator)
+	return deleted, nil
 }
 
-func (s *PgStore) RollbackCluster(ctx context.Context, region, name string, version int64, operator string) (int64, error) {
-	entry, err := s.GetClusterVersion(ctx, region, name, version)
+// deleteResources deletes names from a domains-shaped table in one
+// transaction, recording each delete like DeleteDomain does.
+func (s *PgStore) deleteResources(ctx context.Context, region, kind, table string, names []string, operator string) ([]BulkDeleteResult, error) {
+	var results []BulkDeleteResult
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		var err error
+		results, err = s.deleteResourcesTx(ctx, tx, region, kind, table, names, operator)
+		return err
+	})
 	if err != nil {
-		return 0, err
-	}
-	if entry == nil {
-		return 0, fmt.Errorf("cluster %q version %d not found", name, version)
+		return nil, err
 	}
-	if entry.Cluster == nil {
-		return 0, fmt.Errorf("cluster %q version %d is a delete entry, cannot rollback", name, version)
+
+	deletedCount := 0
+	for _, res := range results {
+		if res.Deleted {
+			deletedCount++
+		}
 	}
-	return s.PutCluster(ctx, region, entry.Cluster, "rollback", operator, -1)
+	s.logger.Infof("%ss deleted in bulk: region=%s, deleted=%d, operator=%s", kind, region, deletedCount, operator)
+	return results, nil
 }
 
-// Watch (long-poll for controller)
-func (s *PgStore) CurrentRevision(ctx context.Context, region string) (int64, error) {
-	var rev sql.NullInt64
-	err := s.db.QueryRowContext(ctx, `SELECT MAX(revision) FROM change_log WHERE region = $1`, region).Scan(&rev)
+// deleteResourcesTx is deleteResources within tx, which must hold the
+// region config lock.
+func (s *PgStore) deleteResourcesTx(ctx context.Context, tx *tracedTx, region, kind, table string, names []string, operator string) ([]BulkDeleteResult, error) {
+	rows, err := tx.QueryContext(ctx,
+		`DELETE FROM `+table+` WHERE region = $1 AND name = ANY($2) RETURNING name, config`, region, pq.Array(names))
 	if err != nil {
-		return 0, fmt.Errorf("pg current revision: %w", err)
+		return nil, fmt.Errorf("pg bulk delete %s: %w", table, err)
 	}
-	if !rev.Valid {
-		return 0, nil
+	deleted := make(map[string][]byte)
+	for rows.Next() {
+		var name string
+		var data []byte
+		if err := rows.Scan(&name, &data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("pg scan deleted %s: %w", kind, err)
+		}
+		deleted[name] = data
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("pg bulk delete %s: %w", table, err)
 	}
-	return rev.Int64, nil
-}
 
-func (s *PgStore) WatchFrom(ctx context.Context, region string, sinceRevision int64) ([]ChangeEvent, int64, error) {
-	// Simple short-poll: query once and return immediately.
-	return s.queryChanges(ctx, region, sinceRevision)
+	results := make([]BulkDeleteResult, 0, len(names))
+	var historyRows, changeRows [][]any
+	reason := ChangeReasonFromContext(ctx)
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		data, ok := deleted[name]
+		if !ok {
+			results = append(results, BulkDeleteResult{Name: name, Error: fmt.Sprintf("%s %q not found", kind, name)})
+			continue
+		}
+		version, err := s.nextVersionTx(ctx, tx, region, kind, name)
+		if err != nil {
+			return nil, err
+		}
+		historyRows = append(historyRows, []any{region, kind, name, version, "delete", operator, data, reason})
+		changeRows = append(changeRows, []any{region, kind, name, "delete", operator, nil, reason})
+		results = append(results, BulkDeleteResult{Name: name, Deleted: true, Version: version})
+	}
+
+	if len(historyRows) > 0 {
+		if err := insertRowsTx(ctx, tx, "config_history",
+			[]string{"region", "kind", "name", "version", "action", "operator", "config", "reason"}, historyRows); err != nil {
+			return nil, fmt.Errorf("pg insert %s delete history: %w", kind, err)
+		}
+		if err := insertRowsTx(ctx, tx, "change_log",
+			[]string{"region", "kind", "name", "action", "operator", "config", "reason"}, changeRows); err != nil {
+			return nil, fmt.Errorf("pg insert change_log: %w", err)
+		}
+	}
+	return results, nil
 }
 
-func (s *PgStore) queryChanges(ctx context.Context, region string, sinceRevision int64) ([]ChangeEvent, int64, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT revision, kind, name, action, config FROM change_log WHERE region = $1 AND revision > $2 ORDER BY revision LIMIT 100`,
-		region, sinceRevision)
+// deleteRegionRowsTx deletes all of a region's rows from a domains-shaped
+// table and returns their configs by name.
+func deleteRegionRowsTx(ctx context.Context, tx *tracedTx, table, region string) (map[string][]byte, error) {
+	rows, err := tx.QueryContext(ctx, `DELETE FROM `+table+` WHERE region = $1 RETURNING name, config`, region)
 	if err != nil {
-		return nil, 0, fmt.Errorf("pg query changes: %w", err)
+		return nil, err
 	}
 	defer rows.Close()
-
-	var events []ChangeEvent
-	var maxRev int64
+	deleted := make(map[string][]byte)
 	for rows.Next() {
-		var e ChangeEvent
+		var name string
 		var data []byte
-		if err := rows.Scan(&e.Revision, &e.Kind, &e.Name, &e.Action, &data); err != nil {
-			return nil, 0, fmt.Errorf("pg scan change: %w", err)
-		}
-		if data != nil {
-			switch e.Kind {
-			case "domain":
-				var d model.DomainConfig
-				if json.Unmarshal(data, &d) == nil {
-					e.Domain = &d
-				}
-			case "cluster":
-				var c model.ClusterConfig
-				if json.Unmarshal(data, &c) == nil {
-					e.Cluster = &c
-				}
-			}
-		}
-		if e.Revision > maxRev {
-			maxRev = e.Revision
+		if err := rows.Scan(&name, &data); err != nil {
+			return nil, err
 		}
-		events = append(events, e)
+		deleted[name] = data
 	}
-	return events, maxRev, rows.Err()
+	return deleted, rows.Err()
 }
 
-// Regions
-// ListRegions returns all registered regions.
-func (s *PgStore) ListRegions(ctx context.Context) ([]string, error) {
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT name FROM regions ORDER BY name`)
+// withTx runs fn in a transaction, committing if fn returns nil and rolling
+// back otherwise. Statements on tx are traced under one transaction span.
+func (s *PgStore) withTx(ctx context.Context, fn func(tx *tracedTx) error) error {
+	markWrite(ctx)
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("pg begin tx: %w", err)
+	}
+	defer tx.Rollback()
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("pg commit: %w", err)
+	}
+	return nil
+}
+
+// WithTx is withTx for multi-statement writes built outside the store, such
+// as batch, copy and restore. fn must not commit or roll back tx itself.
+// The transaction is traced as one span, but statements run on tx are not
+// traced individually.
+func (s *PgStore) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	return s.withTx(ctx, func(tx *tracedTx) error { return fn(tx.Tx) })
+}
+
+// regionConfigLockClass namespaces the per-region advisory locks taken by
+// config writers (two-key form, so it can't collide with the single-key locks).
+const regionConfigLockClass = 0x68726d73 // "hrms"
+
+// lockRegionConfigTx serializes domain/cluster writes within a region until
+// tx ends, so a revision check made under it stays valid until commit.
+func lockRegionConfigTx(ctx context.Context, tx *tracedTx, region string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2))`,
+		int32(regionConfigLockClass), region); err != nil {
+		return fmt.Errorf("pg lock region config: %w", err)
+	}
+	return nil
+}
+
+// queryRower is satisfied by *tracedDB and *tracedTx.
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func configRevision(ctx context.Context, q queryRower, region string) (int64, error) {
+	var rev int64
+	err := q.QueryRowContext(ctx,
+		`SELECT revision FROM change_log WHERE region = $1 AND kind IN ('domain', 'cluster') ORDER BY revision DESC LIMIT 1`,
+		region).Scan(&rev)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("pg config revision: %w", err)
+	}
+	return rev, nil
+}
+
+func (s *PgStore) ConfigRevision(ctx context.Context, region string) (int64, error) {
+	return configRevision(ctx, s.reader(ctx), region)
+}
+
+func (s *PgStore) ConfigAtRevision(ctx context.Context, region string, revision int64) (*model.GatewayConfig, error) {
+	// Config events carry the config, deletes excepted; anything else under
+	// these kinds is audit-only and must not mask the resource's state.
+	const events = `SELECT revision, kind, name, action, config, created_at FROM %s
+		WHERE region = $1 AND kind IN ('domain', 'cluster') AND revision <= $2
+		AND (config IS NOT NULL OR action = 'delete')`
+	// The latest full import is a snapshot: a resource whose last event
+	// predates it was not imported, so the import dropped it. Imports have
+	// emitted delete events for dropped resources only since restore was
+	// added; this covers history written before that. An import's events
+	// share one transaction, hence one created_at.
+	rows, err := s.reader(ctx).QueryContext(ctx, `
+		WITH ev AS (`+fmt.Sprintf(events, "change_log")+` UNION ALL `+fmt.Sprintf(events, "change_log_archive")+`),
+		last_import AS (
+			SELECT MIN(revision) AS start FROM ev
+			WHERE action = 'import' AND created_at = (
+				SELECT created_at FROM ev WHERE action = 'import' ORDER BY revision DESC LIMIT 1)
+		)
+		SELECT kind, name, action, config FROM (
+			SELECT DISTINCT ON (kind, name) kind, name, action, config, revision
+			FROM ev ORDER BY kind, name, revision DESC
+		) latest
+		WHERE revision >= COALESCE((SELECT start FROM last_import), 0)`, region, revision)
+	if err != nil {
+		return nil, fmt.Errorf("pg config at revision: %w", err)
+	}
+	defer rows.Close()
+
+	cfg := &model.GatewayConfig{Domains: []model.DomainConfig{}, Clusters: []model.ClusterConfig{}}
+	for rows.Next() {
+		var kind, name, action string
+		var data []byte
+		if err := rows.Scan(&kind, &name, &action, &data); err != nil {
+			return nil, fmt.Errorf("pg scan config event: %w", err)
+		}
+		if action == "delete" {
+			continue
+		}
+		switch kind {
+		case "domain":
+			var d model.DomainConfig
+			if err := json.Unmarshal(data, &d); err != nil {
+				return nil, fmt.Errorf("decode domain %s: %w", name, err)
+			}
+			cfg.Domains = append(cfg.Domains, d)
+		case "cluster":
+			var c model.ClusterConfig
+			if err := json.Unmarshal(data, &c); err != nil {
+				return nil, fmt.Errorf("decode cluster %s: %w", name, err)
+			}
+			cfg.Clusters = append(cfg.Clusters, c)
+		}
+	}
+	return cfg, rows.Err()
+}
+
+func (s *PgStore) GetConfig(ctx context.Context, region string) (*model.GatewayConfig, error) {
+	domains, err := s.ListDomains(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := s.ListClusters(ctx, region)
+	if err != nil {
+		return nil, err
+	}
+	if domains == nil {
+		domains = []model.DomainConfig{}
+	}
+	if clusters == nil {
+		clusters = []model.ClusterConfig{}
+	}
+	return &model.GatewayConfig{Domains: domains, Clusters: clusters}, nil
+}
+
+func (s *PgStore) GetConfigByLabels(ctx context.Context, region string, selector map[string]string) (*model.GatewayConfig, error) {
+	domains, err := s.ListDomainsByLabels(ctx, region, selector)
+	if err != nil {
+		return nil, err
+	}
+	clusters, err := s.ListClustersByLabels(ctx, region, selector)
+	if err != nil {
+		return nil, err
+	}
+	if domains == nil {
+		domains = []model.DomainConfig{}
+	}
+	if clusters == nil {
+		clusters = []model.ClusterConfig{}
+	}
+	return &model.GatewayConfig{Domains: domains, Clusters: clusters}, nil
+}
+
+func (s *PgStore) StreamConfig(ctx context.Context, region string, fn func(kind string, config json.RawMessage) error) error {
+	tx, err := s.reader(ctx).BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return fmt.Errorf("pg begin tx: %w", err)
+	}
+	defer tx.Rollback()
+
+	for _, q := range []struct{ kind, query string }{
+		{"domain", `SELECT config FROM domains WHERE region = $1 ORDER BY name`},
+		{"cluster", `SELECT config FROM clusters WHERE region = $1 ORDER BY name`},
+	} {
+		if err := streamRows(ctx, tx, q.query, region, func(data []byte) error { return fn(q.kind, data) }); err != nil {
+			return fmt.Errorf("pg stream %ss: %w", q.kind, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// streamRows runs a single-column query and passes each value to fn. The
+// scan buffer is reused, so fn must not keep it.
+func streamRows(ctx context.Context, tx *tracedTx, query, region string, fn func([]byte) error) error {
+	rows, err := tx.QueryContext(ctx, query, region)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var data sql.RawBytes
+	for rows.Next() {
+		if err := rows.Scan(&data); err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// Per-domain History
+func (s *PgStore) GetDomainHistory(ctx context.Context, region, name string) ([]HistoryEntry, error) {
+	return s.getHistory(ctx, region, "domain", name)
+}
+
+func (s *PgStore) GetDomainVersion(ctx context.Context, region, name string, version int64) (*HistoryEntry, error) {
+	return s.getVersion(ctx, region, "domain", name, version)
+}
+
+func (s *PgStore) RollbackDomain(ctx context.Context, region, name string, version int64, operator string) (int64, error) {
+	entry, err := s.GetDomainVersion(ctx, region, name, version)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, fmt.Errorf("domain %q version %d not found", name, version)
+	}
+	if entry.Domain == nil {
+		return 0, fmt.Errorf("domain %q version %d is a delete entry, cannot rollback", name, version)
+	}
+	return s.PutDomain(ctx, region, entry.Domain, "rollback", operator, -1)
+}
+
+// Per-cluster History
+func (s *PgStore) GetClusterHistory(ctx context.Context, region, name string) ([]HistoryEntry, error) {
+	return s.getHistory(ctx, region, "cluster", name)
+}
+
+func (s *PgStore) GetClusterVersion(ctx context.Context, region, name string, version int64) (*HistoryEntry, error) {
+	return s.getVersion(ctx, region, "cluster", name, version)
+}
+
+func (s *PgStore) RollbackCluster(ctx context.Context, region, name string, version int64, operator string) (int64, error) {
+	entry, err := s.GetClusterVersion(ctx, region, name, version)
+	if err != nil {
+		return 0, err
+	}
+	if entry == nil {
+		return 0, fmt.Errorf("cluster %q version %d not found", name, version)
+	}
+	if entry.Cluster == nil {
+		return 0, fmt.Errorf("cluster %q version %d is a delete entry, cannot rollback", name, version)
+	}
+	return s.PutCluster(ctx, region, entry.Cluster, "rollback", operator, -1)
+}
+
+// Watch (long-poll for controller)
+func (s *PgStore) CurrentRevision(ctx context.Context, region string) (int64, error) {
+	// Spelled as ORDER BY ... LIMIT 1 so the plan is always a single-row
+	// backward scan of idx_changelog_region_revision (region, revision),
+	// independent of how much history the region has accumulated.
+	var rev int64
+	err := s.reader(ctx).QueryRowContext(ctx, currentRevisionQuery, region).Scan(&rev)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("pg current revision: %w", err)
+	}
+	return rev, nil
+}
+
+const currentRevisionQuery = `SELECT revision FROM change_log WHERE region = $1 ORDER BY revision DESC LIMIT 1`
+
+func (s *PgStore) WatchFrom(ctx context.Context, region string, sinceRevision int64, kinds []string) ([]ChangeEvent, int64, error) {
+	// Simple short-poll: query once and return immediately.
+	return s.queryChanges(ctx, region, sinceRevision, kinds)
+}
+
+func (s *PgStore) TriggerSync(ctx context.Context, region, operator string) (int64, error) {
+	markWrite(ctx)
+	var rev int64
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO change_log (region, kind, name, action, operator, reason) VALUES ($1, 'sync', '', 'reconcile', $2, $3) RETURNING revision`,
+		region, operator, ChangeReasonFromContext(ctx)).Scan(&rev)
+	if err != nil {
+		return 0, fmt.Errorf("pg trigger sync: %w", err)
+	}
+	return rev, nil
+}
+
+func (s *PgStore) queryChanges(ctx context.Context, region string, sinceRevision int64, kinds []string) ([]ChangeEvent, int64, error) {
+	// With kinds, the next 100 events are still scanned whatever their kind,
+	// so the revision returned moves past the ones filtered out; only
+	// matching events carry their config. Sync requests concern every
+	// consumer, whatever it watches.
+	query := `SELECT revision, kind, name, action, config FROM change_log`
+	args := []any{region, sinceRevision}
+	if len(kinds) > 0 {
+		query = `SELECT revision, kind, name, action,
+			CASE WHEN kind = ANY($3) OR kind = 'sync' THEN config END FROM change_log`
+		args = append(args, pq.Array(kinds))
+	}
+	rows, err := s.reader(ctx).QueryContext(ctx, query+`
+		WHERE region = $1 AND revision > $2 ORDER BY revision LIMIT 100`, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pg query changes: %w", err)
+	}
+	defer rows.Close()
+
+	var events []ChangeEvent
+	var maxRev int64
+	for rows.Next() {
+		var e ChangeEvent
+		var data []byte
+		if err := rows.Scan(&e.Revision, &e.Kind, &e.Name, &e.Action, &data); err != nil {
+			return nil, 0, fmt.Errorf("pg scan change: %w", err)
+		}
+		if e.Revision > maxRev {
+			maxRev = e.Revision
+		}
+		if len(kinds) > 0 && e.Kind != "sync" && !slices.Contains(kinds, e.Kind) {
+			continue
+		}
+		e.Domain, e.Cluster = decodeChangeConfig(e.Kind, data)
+		events = append(events, e)
+	}
+	return events, maxRev, rows.Err()
+}
+
+// decodeChangeConfig decodes a change_log config column for kind. Nil or
+// undecodable data yields nil configs.
+func decodeChangeConfig(kind string, data []byte) (*model.DomainConfig, *model.ClusterConfig) {
+	if data == nil {
+		return nil, nil
+	}
+	switch kind {
+	case "domain":
+		var d model.DomainConfig
+		if json.Unmarshal(data, &d) == nil {
+			return &d, nil
+		}
+	case "cluster":
+		var c model.ClusterConfig
+		if json.Unmarshal(data, &c) == nil {
+			return nil, &c
+		}
+	}
+	return nil, nil
+}
+
+func (s *PgStore) ConfigChangesSince(ctx context.Context, region string, sinceRevision int64) ([]ConfigChange, error) {
+	// Same event filter as ConfigAtRevision: the state before the window
+	// may only survive in the archive. The window is filtered inside each
+	// table's scan, and the state before it is looked up only for the
+	// resources that changed, newest event first.
+	const events = `SELECT revision, kind, name, action, operator FROM %s
+		WHERE region = $1 AND kind IN ('domain', 'cluster') AND revision > $2
+		AND (config IS NOT NULL OR action = 'delete')`
+	const before = `(SELECT revision, action FROM %s
+		WHERE region = $1 AND kind = c.kind AND name = c.name AND revision <= $2
+		AND (config IS NOT NULL OR action = 'delete')
+		ORDER BY revision DESC LIMIT 1)`
+	rows, err := s.reader(ctx).QueryContext(ctx, `
+		WITH changed AS (
+			SELECT DISTINCT ON (kind, name) kind, name, revision, action, operator FROM (`+
+		fmt.Sprintf(events, "change_log")+` UNION ALL `+fmt.Sprintf(events, "change_log_archive")+`
+			) e ORDER BY kind, name, revision DESC
+		)
+		SELECT c.kind, c.name, c.revision, c.action, c.operator,
+			COALESCE(b.action <> 'delete', false), COALESCE(d.config, cl.config)
+		FROM changed c
+		LEFT JOIN LATERAL (
+			SELECT action FROM (`+fmt.Sprintf(before, "change_log")+` UNION ALL `+fmt.Sprintf(before, "change_log_archive")+`
+			) p ORDER BY revision DESC LIMIT 1
+		) b ON true
+		LEFT JOIN domains d ON c.kind = 'domain' AND d.region = $1 AND d.name = c.name
+		LEFT JOIN clusters cl ON c.kind = 'cluster' AND cl.region = $1 AND cl.name = c.name
+		ORDER BY c.revision`, region, sinceRevision)
+	if err != nil {
+		return nil, fmt.Errorf("pg config changes since: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ConfigChange
+	for rows.Next() {
+		var c ConfigChange
+		var action string
+		var existed bool
+		var data []byte
+		if err := rows.Scan(&c.Kind, &c.Name, &c.Revision, &action, &c.Operator, &existed, &data); err != nil {
+			return nil, fmt.Errorf("pg scan config change: %w", err)
+		}
+		switch {
+		case action == "delete" && !existed:
+			continue
+		case action == "delete":
+			c.Change = ChangeDeleted
+		case existed:
+			c.Change = ChangeUpdated
+		default:
+			c.Change = ChangeCreated
+		}
+		if action != "delete" {
+			c.Domain, c.Cluster = decodeChangeConfig(c.Kind, data)
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+// Regions
+// ListRegions returns all registered regions.
+func (s *PgStore) ListRegions(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT name FROM regions ORDER BY name`)
 	if err != nil {
 		return nil, fmt.Errorf("pg list regions: %w", err)
 	}
@@ -780,12 +1912,112 @@ func (s *PgStore) CreateRegion(ctx context.Context, name string) error {
 	return nil
 }
 
+func (s *PgStore) GetRegionSettings(ctx context.Context, region string) (*RegionSettings, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `SELECT settings FROM regions WHERE name = $1`, region).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get region settings: %w", err)
+	}
+	var settings RegionSettings
+	if err := json.Unmarshal(raw, &settings); err != nil {
+		return nil, fmt.Errorf("pg decode region settings: %w", err)
+	}
+	return &settings, nil
+}
+
+func (s *PgStore) PutRegionSettings(ctx context.Context, region string, settings *RegionSettings) error {
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return fmt.Errorf("pg encode region settings: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE regions SET settings = $2 WHERE name = $1`, region, raw)
+	if err != nil {
+		return fmt.Errorf("pg put region settings: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("region %q not found", region)
+	}
+	return nil
+}
+
+func (s *PgStore) IsRegionFrozen(ctx context.Context, region string) (bool, error) {
+	var frozen bool
+	err := s.db.QueryRowContext(ctx, `SELECT frozen FROM regions WHERE name = $1`, region).Scan(&frozen)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("pg get region frozen: %w", err)
+	}
+	return frozen, nil
+}
+
+func (s *PgStore) SetRegionFrozen(ctx context.Context, region string, frozen bool) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `UPDATE regions SET frozen = $2 WHERE name = $1`, region, frozen)
+	if err != nil {
+		return false, fmt.Errorf("pg set region frozen: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *PgStore) GetRegionTargets(ctx context.Context, region string) ([]FanoutTarget, bool, error) {
+	var raw []byte
+	err := s.db.QueryRowContext(ctx, `SELECT targets FROM regions WHERE name = $1`, region).Scan(&raw)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("pg get region targets: %w", err)
+	}
+	var targets []FanoutTarget
+	if err := json.Unmarshal(raw, &targets); err != nil {
+		return nil, false, fmt.Errorf("pg decode region targets: %w", err)
+	}
+	return targets, true, nil
+}
+
+func (s *PgStore) SetRegionTargets(ctx context.Context, region string, targets []FanoutTarget) (bool, error) {
+	if targets == nil {
+		targets = []FanoutTarget{}
+	}
+	raw, err := json.Marshal(targets)
+	if err != nil {
+		return false, fmt.Errorf("pg encode region targets: %w", err)
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE regions SET targets = $2 WHERE name = $1`, region, raw)
+	if err != nil {
+		return false, fmt.Errorf("pg set region targets: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *PgStore) CountDomains(ctx context.Context, region string) (int, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM domains WHERE region = $1`, region).Scan(&n); err != nil {
+		return 0, fmt.Errorf("pg count domains: %w", err)
+	}
+	return n, nil
+}
+
+func (s *PgStore) CountClusters(ctx context.Context, region string) (int, error) {
+	var n int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM clusters WHERE region = $1`, region).Scan(&n); err != nil {
+		return 0, fmt.Errorf("pg count clusters: %w", err)
+	}
+	return n, nil
+}
+
 // Shared helpers
-func (s *PgStore) nextVersion(ctx context.Context, tx *sql.Tx, region, kind, name string) (int64, error) {
+func (s *PgStore) nextVersion(ctx context.Context, tx *tracedTx, region, kind, name string) (int64, error) {
 	return s.nextVersionTx(ctx, tx, region, kind, name)
 }
 
-func (s *PgStore) nextVersionTx(ctx context.Context, tx *sql.Tx, region, kind, name string) (int64, error) {
+func (s *PgStore) nextVersionTx(ctx context.Context, tx *tracedTx, region, kind, name string) (int64, error) {
 	var maxVer sql.NullInt64
 	err := tx.QueryRowContext(ctx,
 		`SELECT MAX(version) FROM config_history WHERE region = $1 AND kind = $2 AND name = $3`,
@@ -799,9 +2031,67 @@ func (s *PgStore) nextVersionTx(ctx context.Context, tx *sql.Tx, region, kind, n
 	return maxVer.Int64 + 1, nil
 }
 
+// latestVersionsTx returns the highest history version of every resource in
+// region, keyed by "kind/name", in a single query.
+func (s *PgStore) latestVersionsTx(ctx context.Context, tx *tracedTx, region string) (map[string]int64, error) {
+	rows, err := tx.QueryContext(ctx,
+		`SELECT kind, name, MAX(version) FROM config_history WHERE region = $1 GROUP BY kind, name`, region)
+	if err != nil {
+		return nil, fmt.Errorf("pg latest versions: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[string]int64)
+	for rows.Next() {
+		var kind, name string
+		var ver int64
+		if err := rows.Scan(&kind, &name, &ver); err != nil {
+			return nil, fmt.Errorf("pg scan version: %w", err)
+		}
+		versions[kind+"/"+name] = ver
+	}
+	return versions, rows.Err()
+}
+
+// maxBindParams is PostgreSQL's limit on bind parameters per statement.
+const maxBindParams = 65535
+
+// insertRowsTx inserts rows into table with multi-row INSERT statements,
+// splitting into as few statements as the bind-parameter limit allows.
+// Rows are inserted in order, so serial columns are assigned in slice order.
+func insertRowsTx(ctx context.Context, tx *tracedTx, table string, columns []string, rows [][]any) error {
+	perStmt := maxBindParams / len(columns)
+	for len(rows) > 0 {
+		batch := rows[:min(perStmt, len(rows))]
+		rows = rows[len(batch):]
+
+		var sb strings.Builder
+		args := make([]any, 0, len(batch)*len(columns))
+		fmt.Fprintf(&sb, "INSERT INTO %s (%s) VALUES ", table, strings.Join(columns, ", "))
+		for i, row := range batch {
+			if i > 0 {
+				sb.WriteString(", ")
+			}
+			sb.WriteByte('(')
+			for j, v := range row {
+				if j > 0 {
+					sb.WriteString(", ")
+				}
+				args = append(args, v)
+				fmt.Fprintf(&sb, "$%d", len(args))
+			}
+			sb.WriteByte(')')
+		}
+		if _, err := tx.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (s *PgStore) getHistory(ctx context.Context, region, kind, name string) ([]HistoryEntry, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT version, created_at, kind, name, action, operator, config FROM config_history
+		`SELECT version, created_at, kind, name, action, operator, reason, config FROM config_history
 		 WHERE region = $1 AND kind = $2 AND name = $3 ORDER BY version DESC LIMIT $4`,
 		region, kind, name, s.maxHistory)
 	if err != nil {
@@ -813,23 +2103,10 @@ func (s *PgStore) getHistory(ctx context.Context, region, kind, name string) ([]
 	for rows.Next() {
 		var e HistoryEntry
 		var data []byte
-		if err := rows.Scan(&e.Version, &e.Timestamp, &e.Kind, &e.Name, &e.Action, &e.Operator, &data); err != nil {
+		if err := rows.Scan(&e.Version, &e.Timestamp, &e.Kind, &e.Name, &e.Action, &e.Operator, &e.Reason, &data); err != nil {
 			return nil, fmt.Errorf("pg scan history: %w", err)
 		}
-		if data != nil {
-			switch kind {
-			case "domain":
-				var d model.DomainConfig
-				if json.Unmarshal(data, &d) == nil {
-					e.Domain = &d
-				}
-			case "cluster":
-				var c model.ClusterConfig
-				if json.Unmarshal(data, &c) == nil {
-					e.Cluster = &c
-				}
-			}
-		}
+		decodeHistoryConfig(&e, data)
 		entries = append(entries, e)
 	}
 	return entries, rows.Err()
@@ -839,47 +2116,132 @@ func (s *PgStore) getVersion(ctx context.Context, region, kind, name string, ver
 	var e HistoryEntry
 	var data []byte
 	err := s.db.QueryRowContext(ctx,
-		`SELECT version, created_at, kind, name, action, operator, config FROM config_history
+		`SELECT version, created_at, kind, name, action, operator, reason, config FROM config_history
 		 WHERE region = $1 AND kind = $2 AND name = $3 AND version = $4`,
-		region, kind, name, version).Scan(&e.Version, &e.Timestamp, &e.Kind, &e.Name, &e.Action, &e.Operator, &data)
+		region, kind, name, version).Scan(&e.Version, &e.Timestamp, &e.Kind, &e.Name, &e.Action, &e.Operator, &e.Reason, &data)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("pg get version: %w", err)
 	}
-	if data != nil {
-		switch kind {
-		case "domain":
-			var d model.DomainConfig
-			if json.Unmarshal(data, &d) == nil {
-				e.Domain = &d
-			}
-		case "cluster":
-			var c model.ClusterConfig
-			if json.Unmarshal(data, &c) == nil {
-				e.Cluster = &c
-			}
+	decodeHistoryConfig(&e, data)
+	return &e, nil
+}
+
+// decodeHistoryConfig sets the entry's snapshot from its stored config.
+// Delete entries may have none.
+func decodeHistoryConfig(e *HistoryEntry, data []byte) {
+	if data == nil {
+		return
+	}
+	switch e.Kind {
+	case "domain":
+		var d model.DomainConfig
+		if json.Unmarshal(data, &d) == nil {
+			e.Domain = &d
+		}
+	case "cluster":
+		var c model.ClusterConfig
+		if json.Unmarshal(data, &c) == nil {
+			e.Cluster = &c
 		}
 	}
-	return &e, nil
+}
+
+func (s *PgStore) ListHistory(ctx context.Context, region string, filter HistoryFilter, limit, offset int) ([]HistoryEntry, int64, error) {
+	if limit <= 0 || limit > MaxListLimit {
+		limit = 50
+	}
+
+	where := []string{"region = $1"}
+	args := []any{region}
+	add := func(cond string, v any) {
+		args = append(args, v)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if filter.Kind != "" {
+		add("kind = $%d", filter.Kind)
+	}
+	if filter.Name != "" {
+		add("name = $%d", filter.Name)
+	}
+	if filter.Operator != "" {
+		add("operator = $%d", filter.Operator)
+	}
+	if !filter.Since.IsZero() {
+		add("created_at >= $%d", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		add("created_at < $%d", filter.Until)
+	}
+	cond := strings.Join(where, " AND ")
+
+	db := s.reader(ctx)
+	var total int64
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM config_history WHERE `+cond, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("pg count history: %w", err)
+	}
+
+	n := len(args)
+	rows, err := db.QueryContext(ctx,
+		fmt.Sprintf(`SELECT version, created_at, kind, name, action, operator, reason, config FROM config_history
+		 WHERE %s ORDER BY created_at DESC, id DESC LIMIT $%d OFFSET $%d`, cond, n+1, n+2),
+		append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("pg list history: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []HistoryEntry
+	for rows.Next() {
+		var e HistoryEntry
+		var data []byte
+		if err := rows.Scan(&e.Version, &e.Timestamp, &e.Kind, &e.Name, &e.Action, &e.Operator, &e.Reason, &data); err != nil {
+			return nil, 0, fmt.Errorf("pg scan history: %w", err)
+		}
+		decodeHistoryConfig(&e, data)
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
 }
 
 // Audit log (global change event stream)
-func (s *PgStore) ListAuditLog(ctx context.Context, region string, limit, offset int) ([]AuditEntry, int64, error) {
-	if limit <= 0 || limit > 200 {
+func (s *PgStore) ListAuditLog(ctx context.Context, region string, since time.Time, before int64, limit, offset int) ([]AuditEntry, int64, error) {
+	if limit <= 0 || limit > MaxListLimit {
 		limit = 50
 	}
 
+	// Without since, only the live table is read. With since, both tables
+	// are filtered by created_at; the archive's (region, created_at) index
+	// keeps that cheap when since is recent.
+	source := `SELECT revision, kind, name, action, operator, reason, created_at FROM change_log WHERE region = $1`
+	args := []any{region}
+	if !since.IsZero() {
+		source = `SELECT revision, kind, name, action, operator, reason, created_at FROM change_log WHERE region = $1 AND created_at >= $2
+		UNION ALL
+		SELECT revision, kind, name, action, operator, reason, created_at FROM change_log_archive WHERE region = $1 AND created_at >= $2`
+		args = append(args, since)
+	}
+
+	db := s.reader(ctx)
 	var total int64
-	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM change_log WHERE region = $1`, region).Scan(&total)
+	err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM (`+source+`) t`, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("pg count audit: %w", err)
 	}
 
-	rows, err := s.db.QueryContext(ctx,
-		`SELECT revision, kind, name, action, operator, created_at FROM change_log WHERE region = $1 ORDER BY revision DESC LIMIT $2 OFFSET $3`,
-		region, limit, offset)
+	// before narrows the page, not the total: revisions only grow, so a
+	// page keyed on it stays put while new entries arrive.
+	page := `SELECT * FROM (` + source + `) t`
+	if before > 0 {
+		args = append(args, before)
+		page += fmt.Sprintf(` WHERE revision < $%d`, len(args))
+	}
+	n := len(args)
+	rows, err := db.QueryContext(ctx,
+		fmt.Sprintf(`%s ORDER BY revision DESC LIMIT $%d OFFSET $%d`, page, n+1, n+2),
+		append(args, limit, offset)...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("pg list audit: %w", err)
 	}
@@ -888,22 +2250,438 @@ func (s *PgStore) ListAuditLog(ctx context.Context, region string, limit, offset
 	var entries []AuditEntry
 	for rows.Next() {
 		var e AuditEntry
-		if err := rows.Scan(&e.Revision, &e.Kind, &e.Name, &e.Action, &e.Operator, &e.Timestamp); err != nil {
+		if err := rows.Scan(&e.Revision, &e.Kind, &e.Name, &e.Action, &e.Operator, &e.Reason, &e.Timestamp); err != nil {
 			return nil, 0, fmt.Errorf("pg scan audit: %w", err)
 		}
-		entries = append(entries, e)
-	}
-	return entries, total, rows.Err()
-}
+		entries = append(entries, e)
+	}
+	return entries, total, rows.Err()
+}
+
+// changeLogArchiveLockID is the advisory lock key that keeps change_log
+// archival to one replica at a time.
+const changeLogArchiveLockID = 0x6865726d65730002 // "hermes" + 2
+
+func (s *PgStore) ArchiveChangeLog(ctx context.Context, olderThan time.Duration, batchSize int) (int64, error) {
+	var total int64
+	for {
+		n, err := s.archiveChangeLogBatch(ctx, olderThan, batchSize)
+		total += n
+		if err != nil || n < int64(batchSize) {
+			return total, err
+		}
+	}
+}
+
+// archiveChangeLogBatch moves up to batchSize rows in one transaction, so a
+// large backlog never holds locks on change_log for long.
+func (s *PgStore) archiveChangeLogBatch(ctx context.Context, olderThan time.Duration, batchSize int) (int64, error) {
+	var n int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT pg_try_advisory_xact_lock($1)`, int64(changeLogArchiveLockID)).Scan(&locked); err != nil {
+			return fmt.Errorf("pg archive lock: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		// latest is computed once per batch rather than per candidate row.
+		res, err := tx.ExecContext(ctx, `
+			WITH latest AS (
+				SELECT region, MAX(revision) AS revision FROM change_log GROUP BY region
+			), moved AS (
+				DELETE FROM change_log WHERE revision IN (
+					SELECT c.revision FROM change_log c JOIN latest l ON l.region = c.region
+					WHERE c.created_at < NOW() - make_interval(secs => $1)
+					  AND c.revision < l.revision
+					ORDER BY c.revision
+					LIMIT $2
+				)
+				RETURNING revision, region, kind, name, action, operator, config, reason, created_at
+			)
+			INSERT INTO change_log_archive (revision, region, kind, name, action, operator, config, reason, created_at)
+			SELECT revision, region, kind, name, action, operator, config, reason, created_at FROM moved`,
+			olderThan.Seconds(), batchSize)
+		if err != nil {
+			return fmt.Errorf("pg archive change_log: %w", err)
+		}
+		n, _ = res.RowsAffected()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *PgStore) OldestRetainedChange(ctx context.Context, region string) (int64, time.Time, error) {
+	var rev int64
+	var at time.Time
+	err := s.reader(ctx).QueryRowContext(ctx, `
+		(SELECT revision, created_at FROM change_log WHERE region = $1 ORDER BY revision LIMIT 1)
+		UNION ALL
+		(SELECT revision, created_at FROM change_log_archive WHERE region = $1 ORDER BY revision LIMIT 1)
+		ORDER BY revision LIMIT 1`, region).Scan(&rev, &at)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, fmt.Errorf("pg oldest change: %w", err)
+	}
+	return rev, at, nil
+}
+
+// tableMaintenanceLockID is the advisory lock key that keeps table
+// maintenance to one run at a time.
+const tableMaintenanceLockID = 0x6865726d65730005 // "hermes" + 5
+
+// maintainedTables are the tables MaintainTables works on: the config and
+// its history, which bulk imports churn the most.
+var maintainedTables = []string{"domains", "clusters", "config_history", "change_log", "change_log_archive"}
+
+func (s *PgStore) MaintainTables(ctx context.Context, reindex bool) ([]TableMaintenance, error) {
+	// REINDEX CONCURRENTLY cannot run inside a transaction, so the lock is
+	// a session lock held on one pinned connection.
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("pg maintenance conn: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx,
+		`SELECT pg_try_advisory_lock($1)`, int64(tableMaintenanceLockID)).Scan(&locked); err != nil {
+		return nil, fmt.Errorf("pg maintenance lock: %w", err)
+	}
+	if !locked {
+		return nil, ErrMaintenanceRunning
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.WithoutCancel(ctx),
+			`SELECT pg_advisory_unlock($1)`, int64(tableMaintenanceLockID)); err != nil {
+			s.logger.Warnf("release table maintenance lock: %v", err)
+		}
+	}()
+
+	results := make([]TableMaintenance, 0, len(maintainedTables))
+	for _, table := range maintainedTables {
+		start := time.Now()
+		if _, err := conn.ExecContext(ctx, `ANALYZE `+table); err != nil {
+			return results, fmt.Errorf("pg analyze %s: %w", table, err)
+		}
+		if reindex {
+			if _, err := conn.ExecContext(ctx, `REINDEX TABLE CONCURRENTLY `+table); err != nil {
+				return results, fmt.Errorf("pg reindex %s: %w", table, err)
+			}
+		}
+		results = append(results, TableMaintenance{Table: table, Reindexed: reindex, DurationMS: time.Since(start).Milliseconds()})
+	}
+	s.logger.Infof("table maintenance done: tables=%d, reindex=%v", len(results), reindex)
+	return results, nil
+}
+
+// Scheduled changes
+func (s *PgStore) CreateScheduledChange(ctx context.Context, region string, c *ScheduledChange) (int64, error) {
+	markWrite(ctx)
+	var config any
+	switch {
+	case c.Domain != nil:
+		data, err := json.Marshal(c.Domain)
+		if err != nil {
+			return 0, fmt.Errorf("marshal domain: %w", err)
+		}
+		config = data
+	case c.Cluster != nil:
+		data, err := json.Marshal(c.Cluster)
+		if err != nil {
+			return 0, fmt.Errorf("marshal cluster: %w", err)
+		}
+		config = data
+	}
+	var id int64
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO scheduled_changes (region, kind, name, action, config, apply_at, created_by, reason)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING id`,
+		region, c.Kind, c.Name, c.Action, config, c.ApplyAt, c.CreatedBy, c.Reason).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("pg insert scheduled change: %w", err)
+	}
+	return id, nil
+}
+
+const scheduledChangeColumns = `id, region, kind, name, action, config, apply_at, status, error, created_by, reason, created_at, finished_at`
+
+func scanScheduledChange(rows *tracedRows) (ScheduledChange, error) {
+	var c ScheduledChange
+	var data []byte
+	var finished sql.NullTime
+	if err := rows.Scan(&c.ID, &c.Region, &c.Kind, &c.Name, &c.Action, &data, &c.ApplyAt,
+		&c.Status, &c.Error, &c.CreatedBy, &c.Reason, &c.CreatedAt, &finished); err != nil {
+		return c, fmt.Errorf("pg scan scheduled change: %w", err)
+	}
+	if finished.Valid {
+		c.FinishedAt = &finished.Time
+	}
+	if data != nil {
+		switch c.Kind {
+		case "domain":
+			var d model.DomainConfig
+			if err := json.Unmarshal(data, &d); err != nil {
+				return c, fmt.Errorf("decode scheduled domain %d: %w", c.ID, err)
+			}
+			c.Domain = &d
+		case "cluster":
+			var cl model.ClusterConfig
+			if err := json.Unmarshal(data, &cl); err != nil {
+				return c, fmt.Errorf("decode scheduled cluster %d: %w", c.ID, err)
+			}
+			c.Cluster = &cl
+		}
+	}
+	return c, nil
+}
+
+func (s *PgStore) ListScheduledChanges(ctx context.Context, region, status string) ([]ScheduledChange, error) {
+	rows, err := s.reader(ctx).QueryContext(ctx, `
+		SELECT `+scheduledChangeColumns+` FROM scheduled_changes
+		WHERE region = $1 AND ($2 = '' OR status = $2)
+		ORDER BY apply_at, id`, region, status)
+	if err != nil {
+		return nil, fmt.Errorf("pg list scheduled changes: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ScheduledChange
+	for rows.Next() {
+		c, err := scanScheduledChange(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, c)
+	}
+	return result, rows.Err()
+}
+
+func (s *PgStore) CancelScheduledChange(ctx context.Context, region string, id int64) (bool, error) {
+	markWrite(ctx)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE scheduled_changes SET status = 'cancelled', finished_at = NOW()
+		WHERE region = $1 AND id = $2 AND status = 'pending'`, region, id)
+	if err != nil {
+		return false, fmt.Errorf("pg cancel scheduled change: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// scheduledChangeLockID is the advisory lock key that keeps claiming due
+// scheduled changes to one replica at a time.
+const scheduledChangeLockID = 0x6865726d65730004 // "hermes" + 4
+
+// scheduledChangeLease is how long a claimed change may stay applying before
+// another run claims it again, e.g. after the replica that claimed it died
+// mid-apply. Applying a change takes a few statements, so this is generous.
+const scheduledChangeLease = 5 * time.Minute
+
+func (s *PgStore) ClaimDueScheduledChanges(ctx context.Context, limit int) ([]ScheduledChange, error) {
+	var result []ScheduledChange
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		var locked bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT pg_try_advisory_xact_lock($1)`, int64(scheduledChangeLockID)).Scan(&locked); err != nil {
+			return fmt.Errorf("pg scheduled change lock: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE scheduled_changes SET status = 'applying', claimed_at = NOW()
+			WHERE id IN (
+				SELECT id FROM scheduled_changes
+				WHERE (status = 'pending' AND apply_at <= NOW())
+				   OR (status = 'applying' AND (claimed_at IS NULL OR claimed_at < NOW() - $2 * INTERVAL '1 second'))
+				ORDER BY apply_at, id LIMIT $1
+			)
+			RETURNING `+scheduledChangeColumns, limit, scheduledChangeLease.Seconds())
+		if err != nil {
+			return fmt.Errorf("pg claim scheduled changes: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			c, err := scanScheduledChange(rows)
+			if err != nil {
+				return err
+			}
+			result = append(result, c)
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING does not preserve the subquery's order.
+	sort.Slice(result, func(i, j int) bool {
+		if !result[i].ApplyAt.Equal(result[j].ApplyAt) {
+			return result[i].ApplyAt.Before(result[j].ApplyAt)
+		}
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (s *PgStore) FinishScheduledChange(ctx context.Context, id int64, applyErr string) error {
+	status := ScheduledApplied
+	if applyErr != "" {
+		status = ScheduledFailed
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE scheduled_changes SET status = $2, error = $3, finished_at = NOW()
+		WHERE id = $1 AND status = 'applying'`,
+		id, status, applyErr)
+	if err != nil {
+		return fmt.Errorf("pg finish scheduled change: %w", err)
+	}
+	return nil
+}
+
+// Canary config
+func (s *PgStore) GetConfigCanary(ctx context.Context, region string) (*ConfigCanary, error) {
+	c := ConfigCanary{Region: region}
+	var data []byte
+	err := s.reader(ctx).QueryRowContext(ctx,
+		`SELECT percent, config, base_revision, created_by, created_at FROM config_canaries WHERE region = $1`,
+		region).Scan(&c.Percent, &data, &c.BaseRevision, &c.CreatedBy, &c.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get config canary: %w", err)
+	}
+	if err := json.Unmarshal(data, &c.Config); err != nil {
+		return nil, fmt.Errorf("pg decode config canary: %w", err)
+	}
+	return &c, nil
+}
+
+func (s *PgStore) PutConfigCanary(ctx context.Context, region string, c *ConfigCanary) error {
+	markWrite(ctx)
+	data, err := json.Marshal(c.Config)
+	if err != nil {
+		return fmt.Errorf("marshal canary config: %w", err)
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO config_canaries (region, percent, config, base_revision, created_by) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (region) DO UPDATE SET percent = EXCLUDED.percent, config = EXCLUDED.config,
+			base_revision = EXCLUDED.base_revision, created_by = EXCLUDED.created_by, created_at = NOW()`,
+		region, c.Percent, data, c.BaseRevision, c.CreatedBy)
+	if err != nil {
+		return fmt.Errorf("pg put config canary: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) DeleteConfigCanary(ctx context.Context, region string) (bool, error) {
+	markWrite(ctx)
+	res, err := s.db.ExecContext(ctx, `DELETE FROM config_canaries WHERE region = $1`, region)
+	if err != nil {
+		return false, fmt.Errorf("pg delete config canary: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+func (s *PgStore) PromoteConfigCanary(ctx context.Context, region string, c *ConfigCanary, operator string) (int64, error) {
+	var newRevision int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+		// Deleting the row both ends the canary and checks it is still the
+		// one the caller validated.
+		var createdAt time.Time
+		err := tx.QueryRowContext(ctx,
+			`DELETE FROM config_canaries WHERE region = $1 RETURNING created_at`, region).Scan(&createdAt)
+		if err == sql.ErrNoRows {
+			return ErrConflict
+		}
+		if err != nil {
+			return fmt.Errorf("pg delete config canary: %w", err)
+		}
+		if !createdAt.Equal(c.CreatedAt) {
+			return ErrConflict
+		}
+		newRevision, err = s.putAllConfigTx(ctx, tx, region, c.Config.Domains, c.Config.Clusters, operator, c.BaseRevision)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	s.logger.Infof("canary promoted: region=%s, domains=%d, clusters=%d, revision=%d", region, len(c.Config.Domains), len(c.Config.Clusters), newRevision)
+	return newRevision, nil
+}
+
+func (s *PgStore) InsertAuditLog(ctx context.Context, region, kind, name, action, operator string) error {
+	markWrite(ctx)
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO change_log (region, kind, name, action, operator, reason) VALUES ($1, $2, $3, $4, $5, $6)`,
+		region, kind, name, action, operator, ChangeReasonFromContext(ctx))
+	if err != nil {
+		return fmt.Errorf("pg insert audit log: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) PurgeHistory(ctx context.Context, region, kind, name, operator string) (int64, error) {
+	table := map[string]string{"domain": "domains", "cluster": "clusters"}[kind]
+	if table == "" {
+		return 0, fmt.Errorf("pg purge history: unknown kind %q", kind)
+	}
+	var purged int64
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		if err := lockRegionConfigTx(ctx, tx, region); err != nil {
+			return err
+		}
+
+		var exists bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT EXISTS (SELECT 1 FROM `+table+` WHERE region = $1 AND name = $2)`, region, name).Scan(&exists); err != nil {
+			return fmt.Errorf("pg purge history: %w", err)
+		}
+		if exists {
+			return ErrResourceExists
+		}
+
+		for _, t := range []string{"config_history", "change_log", "change_log_archive"} {
+			res, err := tx.ExecContext(ctx,
+				`DELETE FROM `+t+` WHERE region = $1 AND kind = $2 AND name = $3`, region, kind, name)
+			if err != nil {
+				return fmt.Errorf("pg purge %s: %w", t, err)
+			}
+			n, _ := res.RowsAffected()
+			purged += n
+		}
+		if purged == 0 {
+			return nil
+		}
 
-func (s *PgStore) InsertAuditLog(ctx context.Context, region, kind, name, action, operator string) error {
-	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO change_log (region, kind, name, action, operator) VALUES ($1, $2, $3, $4, $5)`,
-		region, kind, name, action, operator)
-	if err != nil {
-		return fmt.Errorf("pg insert audit log: %w", err)
+		reason := ChangeReasonFromContext(ctx)
+		if err := insertRowsTx(ctx, tx, "change_log", []string{"region", "kind", "name", "action", "operator", "reason"}, [][]any{
+			{region, "history", kind + "/" + name, "purge", operator, reason},
+			{region, "sync", "", "reconcile", operator, reason},
+		}); err != nil {
+			return fmt.Errorf("pg insert change_log: %w", err)
+		}
+		return nil
+	})
+	if err != nil || purged == 0 {
+		return 0, err
 	}
-	return nil
+	s.logger.Infof("%s history purged: region=%s, name=%s, rows=%d, operator=%s", kind, region, name, purged, operator)
+	return purged, nil
 }
 
 func (s *PgStore) pruneHistory(ctx context.Context, region, kind, name string) {
@@ -921,53 +2699,51 @@ func (s *PgStore) pruneHistory(ctx context.Context, region, kind, name string) {
 
 // Status (region-scoped)
 func (s *PgStore) UpsertGatewayInstances(ctx context.Context, region string, instances []GatewayInstanceStatus) error {
-	tx, err := s.db.BeginTx(ctx, nil)
-	if err != nil {
-		return fmt.Errorf("pg begin tx: %w", err)
-	}
-	defer tx.Rollback()
-
-	if len(instances) == 0 {
-		if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_instances WHERE region = $1`, region); err != nil {
-			return fmt.Errorf("pg clear instances: %w", err)
-		}
-	} else {
-		ids := make([]any, len(instances)+1)
-		ids[0] = region
-		placeholders := ""
-		for i, inst := range instances {
-			ids[i+1] = inst.ID
-			if i > 0 {
-				placeholders += ","
+	return s.withTx(ctx, func(tx *tracedTx) error {
+		if len(instances) == 0 {
+			if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_instances WHERE region = $1`, region); err != nil {
+				return fmt.Errorf("pg clear instances: %w", err)
+			}
+		} else {
+			ids := make([]any, len(instances)+1)
+			ids[0] = region
+			placeholders := ""
+			for i, inst := range instances {
+				ids[i+1] = inst.ID
+				if i > 0 {
+					placeholders += ","
+				}
+				placeholders += fmt.Sprintf("$%d", i+2)
+			}
+			q := fmt.Sprintf(`DELETE FROM gateway_instances WHERE region = $1 AND id NOT IN (%s)`, placeholders)
+			if _, err := tx.ExecContext(ctx, q, ids...); err != nil {
+				return fmt.Errorf("pg prune stale instances: %w", err)
 			}
-			placeholders += fmt.Sprintf("$%d", i+2)
-		}
-		q := fmt.Sprintf(`DELETE FROM gateway_instances WHERE region = $1 AND id NOT IN (%s)`, placeholders)
-		if _, err := tx.ExecContext(ctx, q, ids...); err != nil {
-			return fmt.Errorf("pg prune stale instances: %w", err)
 		}
-	}
 
-	for _, inst := range instances {
-		_, err := tx.ExecContext(ctx, `
-			INSERT INTO gateway_instances (region, id, status, started_at, registered_at, last_keepalive_at, config_revision, last_seen_at, updated_at)
-			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
-			ON CONFLICT (region, id) DO UPDATE SET
-				status = EXCLUDED.status,
-				started_at = EXCLUDED.started_at,
-				registered_at = EXCLUDED.registered_at,
-				last_keepalive_at = EXCLUDED.last_keepalive_at,
-				config_revision = EXCLUDED.config_revision,
-				last_seen_at = EXCLUDED.last_seen_at,
-				updated_at = NOW()`,
-			region, inst.ID, inst.Status, inst.StartedAt, inst.RegisteredAt,
-			inst.LastKeepaliveAt, inst.ConfigRevision, inst.LastSeenAt)
-		if err != nil {
-			return fmt.Errorf("pg upsert instance %s: %w", inst.ID, err)
+		for _, inst := range instances {
+			if err := s.recordRevisionTransition(ctx, tx, region, inst); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, `
+				INSERT INTO gateway_instances (region, id, status, started_at, registered_at, last_keepalive_at, config_revision, last_seen_at, updated_at)
+				VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NOW())
+				ON CONFLICT (region, id) DO UPDATE SET
+					status = EXCLUDED.status,
+					started_at = EXCLUDED.started_at,
+					registered_at = EXCLUDED.registered_at,
+					last_keepalive_at = EXCLUDED.last_keepalive_at,
+					config_revision = EXCLUDED.config_revision,
+					last_seen_at = EXCLUDED.last_seen_at,
+					updated_at = NOW()`,
+				region, inst.ID, inst.Status, inst.StartedAt, inst.RegisteredAt,
+				inst.LastKeepaliveAt, inst.ConfigRevision, inst.LastSeenAt)
+			if err != nil {
+				return fmt.Errorf("pg upsert instance %s: %w", inst.ID, err)
+			}
 		}
-	}
-
-	return tx.Commit()
+		return nil
+	})
 }
 
 func (s *PgStore) ListGatewayInstances(ctx context.Context, region string) ([]GatewayInstanceStatus, error) {
@@ -991,24 +2767,158 @@ func (s *PgStore) ListGatewayInstances(ctx context.Context, region string) ([]Ga
 	return result, rows.Err()
 }
 
+func (s *PgStore) DeleteGatewayInstance(ctx context.Context, region, id string) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM gateway_instances WHERE region = $1 AND id = $2`, region, id)
+	if err != nil {
+		return false, fmt.Errorf("pg delete instance: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// Retention of gateway_revision_events.
+const (
+	revisionHistoryPerInstance = 100
+	revisionHistoryMaxAge      = 7 * 24 * time.Hour
+)
+
+// recordRevisionTransition appends an event when inst reports a config
+// revision different from the stored one (or is new), then prunes that
+// instance's history and anything past revisionHistoryMaxAge. Must run
+// before the instance row is upserted.
+func (s *PgStore) recordRevisionTransition(ctx context.Context, tx *tracedTx, region string, inst GatewayInstanceStatus) error {
+	if inst.ConfigRevision == 0 {
+		return nil // nothing applied yet
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO gateway_revision_events (region, instance_id, config_revision)
+		SELECT $1, $2, $3
+		WHERE NOT EXISTS (
+			SELECT 1 FROM gateway_instances WHERE region = $1 AND id = $2 AND config_revision = $3
+		)`, region, inst.ID, inst.ConfigRevision)
+	if err != nil {
+		return fmt.Errorf("pg record revision event: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM gateway_revision_events
+		WHERE region = $1 AND instance_id = $2 AND id <= (
+			SELECT id FROM gateway_revision_events WHERE region = $1 AND instance_id = $2
+			ORDER BY id DESC OFFSET $3 LIMIT 1
+		)`, region, inst.ID, revisionHistoryPerInstance); err != nil {
+		return fmt.Errorf("pg prune revision events: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM gateway_revision_events WHERE observed_at < NOW() - make_interval(secs => $1)`,
+		revisionHistoryMaxAge.Seconds()); err != nil {
+		return fmt.Errorf("pg expire revision events: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ListInstanceRevisionHistory(ctx context.Context, region, id string) ([]InstanceRevisionEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT config_revision, observed_at FROM gateway_revision_events
+		WHERE region = $1 AND instance_id = $2 ORDER BY id`, region, id)
+	if err != nil {
+		return nil, fmt.Errorf("pg list revision history: %w", err)
+	}
+	defer rows.Close()
+
+	var result []InstanceRevisionEvent
+	for rows.Next() {
+		var e InstanceRevisionEvent
+		if err := rows.Scan(&e.ConfigRevision, &e.ObservedAt); err != nil {
+			return nil, fmt.Errorf("pg scan revision event: %w", err)
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
 func (s *PgStore) UpsertControllerStatus(ctx context.Context, region string, ctrl *ControllerStatus) error {
-	_, err := s.db.ExecContext(ctx, `
-		INSERT INTO controller_status (region, id, status, is_leader, started_at, last_heartbeat_at, config_revision, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
-		ON CONFLICT (region, id) DO UPDATE SET
-			status = EXCLUDED.status,
-			is_leader = EXCLUDED.is_leader,
-			started_at = EXCLUDED.started_at,
-			last_heartbeat_at = EXCLUDED.last_heartbeat_at,
-			config_revision = EXCLUDED.config_revision,
-			updated_at = NOW()`,
-		region, ctrl.ID, ctrl.Status, ctrl.IsLeader, ctrl.StartedAt, ctrl.LastHeartbeatAt, ctrl.ConfigRevision)
-	if err != nil {
-		return fmt.Errorf("pg upsert controller: %w", err)
+	return s.withTx(ctx, func(tx *tracedTx) error {
+		if err := s.recordLeadershipTransition(ctx, tx, region, ctrl); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO controller_status (region, id, status, is_leader, started_at, last_heartbeat_at, config_revision, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, NOW())
+			ON CONFLICT (region, id) DO UPDATE SET
+				status = EXCLUDED.status,
+				is_leader = EXCLUDED.is_leader,
+				started_at = EXCLUDED.started_at,
+				last_heartbeat_at = EXCLUDED.last_heartbeat_at,
+				config_revision = EXCLUDED.config_revision,
+				updated_at = NOW()`,
+			region, ctrl.ID, ctrl.Status, ctrl.IsLeader, ctrl.StartedAt, ctrl.LastHeartbeatAt, ctrl.ConfigRevision)
+		if err != nil {
+			return fmt.Errorf("pg upsert controller: %w", err)
+		}
+		return nil
+	})
+}
+
+// Retention of controller_leadership_events.
+const (
+	leadershipHistoryPerRegion = 500
+	leadershipHistoryMaxAge    = 30 * 24 * time.Hour
+)
+
+// recordLeadershipTransition appends an event when ctrl's is_leader differs
+// from the stored row, or when a new controller first reports as leader,
+// then prunes the region's history and anything past leadershipHistoryMaxAge.
+// Must run before the controller row is upserted.
+func (s *PgStore) recordLeadershipTransition(ctx context.Context, tx *tracedTx, region string, ctrl *ControllerStatus) error {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO controller_leadership_events (region, controller_id, is_leader, config_revision)
+		SELECT $1, $2, $3, $4
+		WHERE COALESCE(
+			(SELECT is_leader FROM controller_status WHERE region = $1 AND id = $2 FOR UPDATE),
+			FALSE
+		) <> $3`, region, ctrl.ID, ctrl.IsLeader, ctrl.ConfigRevision)
+	if err != nil {
+		return fmt.Errorf("pg record leadership event: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil
+	}
+	if _, err := tx.ExecContext(ctx, `
+		DELETE FROM controller_leadership_events
+		WHERE region = $1 AND id <= (
+			SELECT id FROM controller_leadership_events WHERE region = $1
+			ORDER BY id DESC OFFSET $2 LIMIT 1
+		)`, region, leadershipHistoryPerRegion); err != nil {
+		return fmt.Errorf("pg prune leadership events: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM controller_leadership_events WHERE occurred_at < NOW() - make_interval(secs => $1)`,
+		leadershipHistoryMaxAge.Seconds()); err != nil {
+		return fmt.Errorf("pg expire leadership events: %w", err)
 	}
 	return nil
 }
 
+func (s *PgStore) ListLeadershipEvents(ctx context.Context, region string) ([]LeadershipEvent, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT controller_id, is_leader, config_revision, occurred_at FROM controller_leadership_events
+		WHERE region = $1 ORDER BY id`, region)
+	if err != nil {
+		return nil, fmt.Errorf("pg list leadership events: %w", err)
+	}
+	defer rows.Close()
+
+	var result []LeadershipEvent
+	for rows.Next() {
+		var e LeadershipEvent
+		if err := rows.Scan(&e.ControllerID, &e.IsLeader, &e.ConfigRevision, &e.OccurredAt); err != nil {
+			return nil, fmt.Errorf("pg scan leadership event: %w", err)
+		}
+		result = append(result, e)
+	}
+	return result, rows.Err()
+}
+
 func (s *PgStore) GetControllerStatus(ctx context.Context, region string) (*ControllerStatus, error) {
 	var ctrl ControllerStatus
 	err := s.db.QueryRowContext(ctx,
@@ -1024,6 +2934,64 @@ func (s *PgStore) GetControllerStatus(ctx context.Context, region string) (*Cont
 	return &ctrl, nil
 }
 
+func (s *PgStore) ListRegionStatus(ctx context.Context) ([]RegionStatusSummary, error) {
+	// The revision is looked up per region with the same index-backed
+	// ORDER BY ... LIMIT 1 as configRevision; a GROUP BY over change_log
+	// would scan all of history.
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT r.name, COALESCE(rev.revision, 0),
+		       COUNT(gi.id),
+		       COUNT(gi.id) FILTER (WHERE gi.status <> 'offline'),
+		       COUNT(gi.id) FILTER (WHERE gi.status <> 'offline' AND gi.config_revision >= COALESCE(rev.revision, 0))
+		FROM regions r
+		LEFT JOIN LATERAL (
+			SELECT revision FROM change_log
+			WHERE region = r.name AND kind IN ('domain', 'cluster')
+			ORDER BY revision DESC LIMIT 1
+		) rev ON TRUE
+		LEFT JOIN gateway_instances gi ON gi.region = r.name
+		GROUP BY r.name, rev.revision
+		ORDER BY r.name`)
+	if err != nil {
+		return nil, fmt.Errorf("pg list region status: %w", err)
+	}
+	defer rows.Close()
+
+	var result []RegionStatusSummary
+	index := make(map[string]int)
+	for rows.Next() {
+		var sum RegionStatusSummary
+		if err := rows.Scan(&sum.Region, &sum.ConfigRevision, &sum.Instances, &sum.InstancesOnline, &sum.InstancesInSync); err != nil {
+			return nil, fmt.Errorf("pg scan region status: %w", err)
+		}
+		index[sum.Region] = len(result)
+		result = append(result, sum)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Latest controller per region, as in GetControllerStatus.
+	rows, err = s.db.QueryContext(ctx, `
+		SELECT DISTINCT ON (region) region, id, status, is_leader, started_at, last_heartbeat_at, config_revision, updated_at
+		FROM controller_status ORDER BY region, updated_at DESC`)
+	if err != nil {
+		return nil, fmt.Errorf("pg list controllers: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var region string
+		var ctrl ControllerStatus
+		if err := rows.Scan(&region, &ctrl.ID, &ctrl.Status, &ctrl.IsLeader, &ctrl.StartedAt, &ctrl.LastHeartbeatAt, &ctrl.ConfigRevision, &ctrl.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("pg scan controller: %w", err)
+		}
+		if i, ok := index[region]; ok {
+			result[i].Controller = &ctrl
+		}
+	}
+	return result, rows.Err()
+}
+
 // Stale reaper (idempotent, lock-free)
 // MarkStaleInstances marks gateway instances as "offline" whose updated_at is
 // older than now()-threshold. Uses RETURNING to report exactly which rows changed.
@@ -1216,6 +3184,193 @@ func (s *PgStore) DeleteAPICredential(ctx context.Context, region string, id int
 	return nil
 }
 
+func (s *PgStore) SetAPICredentialsEnabled(ctx context.Context, region string, filter CredentialFilter, enabled bool, operator string) ([]APICredential, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, fmt.Errorf("pg set api credentials enabled: %w", err)
+	}
+	var changed []APICredential
+	var updatedAt time.Time
+	err = s.withTx(ctx, func(tx *tracedTx) error {
+		// Lock the candidates so a concurrent update cannot slip in between
+		// matching and flipping them.
+		rows, err := tx.QueryContext(ctx,
+			`SELECT id, region, access_key, description, scopes, enabled, created_at, updated_at
+			 FROM api_credentials WHERE region = $1 AND enabled <> $2 ORDER BY id FOR UPDATE`, region, enabled)
+		if err != nil {
+			return fmt.Errorf("pg list api credentials: %w", err)
+		}
+		for rows.Next() {
+			var c APICredential
+			if err := rows.Scan(&c.ID, &c.Region, &c.AccessKey, &c.Description, pq.Array(&c.Scopes), &c.Enabled, &c.CreatedAt, &c.UpdatedAt); err != nil {
+				rows.Close()
+				return fmt.Errorf("pg scan api credential: %w", err)
+			}
+			if c.Scopes == nil {
+				c.Scopes = []string{}
+			}
+			if match(&c) {
+				changed = append(changed, c)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("pg list api credentials: %w", err)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(changed))
+		auditRows := make([][]any, len(changed))
+		action := map[bool]string{true: "enable", false: "disable"}[enabled]
+		reason := ChangeReasonFromContext(ctx)
+		for i := range changed {
+			ids[i] = changed[i].ID
+			auditRows[i] = []any{region, "credential", fmt.Sprint(changed[i].ID), action, operator, reason}
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE api_credentials SET enabled = $1, updated_at = NOW()
+			 WHERE region = $2 AND id = ANY($3)`,
+			enabled, region, pq.Array(ids)); err != nil {
+			return fmt.Errorf("pg set api credentials enabled: %w", err)
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT NOW()`).Scan(&updatedAt); err != nil {
+			return fmt.Errorf("pg set api credentials enabled: %w", err)
+		}
+		if err := insertRowsTx(ctx, tx, "change_log",
+			[]string{"region", "kind", "name", "action", "operator", "reason"}, auditRows); err != nil {
+			return fmt.Errorf("pg insert change_log: %w", err)
+		}
+		return nil
+	})
+	if err != nil || len(changed) == 0 {
+		return nil, err
+	}
+	for i := range changed {
+		changed[i].Enabled = enabled
+		changed[i].UpdatedAt = updatedAt
+	}
+	return changed, nil
+}
+
+func (s *PgStore) SealAPICredentialSecrets(ctx context.Context, seal func(secretKey string) (string, error)) (int, error) {
+	sealed := map[int64]string{}
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		rows, err := tx.QueryContext(ctx, `SELECT id, secret_key FROM api_credentials ORDER BY id FOR UPDATE`)
+		if err != nil {
+			return fmt.Errorf("pg list api credential secrets: %w", err)
+		}
+		for rows.Next() {
+			var id int64
+			var sk string
+			if err := rows.Scan(&id, &sk); err != nil {
+				rows.Close()
+				return fmt.Errorf("pg scan api credential secret: %w", err)
+			}
+			if secretbox.IsSealed(sk) {
+				continue
+			}
+			if sealed[id], err = seal(sk); err != nil {
+				rows.Close()
+				return fmt.Errorf("pg seal api credential %d: %w", id, err)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("pg list api credential secrets: %w", err)
+		}
+
+		for id, sk := range sealed {
+			if _, err := tx.ExecContext(ctx,
+				`UPDATE api_credentials SET secret_key = $1 WHERE id = $2`, sk, id); err != nil {
+				return fmt.Errorf("pg seal api credential %d: %w", id, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(sealed), nil
+}
+
+// Service Accounts
+func (s *PgStore) ListServiceAccounts(ctx context.Context, region string) ([]ServiceAccount, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT id, region, name, description, scopes, token_prefix, created_at
+		 FROM service_accounts WHERE region = $1 ORDER BY id`, region)
+	if err != nil {
+		return nil, fmt.Errorf("pg list service accounts: %w", err)
+	}
+	defer rows.Close()
+
+	var result []ServiceAccount
+	for rows.Next() {
+		var sa ServiceAccount
+		if err := rows.Scan(&sa.ID, &sa.Region, &sa.Name, &sa.Description, pq.Array(&sa.Scopes), &sa.TokenPrefix, &sa.CreatedAt); err != nil {
+			return nil, fmt.Errorf("pg scan service account: %w", err)
+		}
+		if sa.Scopes == nil {
+			sa.Scopes = []string{}
+		}
+		result = append(result, sa)
+	}
+	return result, rows.Err()
+}
+
+func (s *PgStore) GetServiceAccountByTokenHash(ctx context.Context, tokenHash string) (*ServiceAccount, error) {
+	var sa ServiceAccount
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, region, name, description, scopes, token_prefix, created_at
+		 FROM service_accounts WHERE token_hash = $1`, tokenHash).
+		Scan(&sa.ID, &sa.Region, &sa.Name, &sa.Description, pq.Array(&sa.Scopes), &sa.TokenPrefix, &sa.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get service account: %w", err)
+	}
+	if sa.Scopes == nil {
+		sa.Scopes = []string{}
+	}
+	return &sa, nil
+}
+
+func (s *PgStore) CreateServiceAccount(ctx context.Context, region string, sa *ServiceAccount, tokenHash string) (*ServiceAccount, error) {
+	sa.Region = region
+	if sa.Scopes == nil {
+		sa.Scopes = []string{}
+	}
+	err := s.db.QueryRowContext(ctx,
+		`INSERT INTO service_accounts (region, name, description, scopes, token_hash, token_prefix)
+		 VALUES ($1, $2, $3, $4, $5, $6)
+		 ON CONFLICT (region, name) DO NOTHING
+		 RETURNING id, created_at`,
+		region, sa.Name, sa.Description, pq.Array(sa.Scopes), tokenHash, sa.TokenPrefix).
+		Scan(&sa.ID, &sa.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg create service account: %w", err)
+	}
+	return sa, nil
+}
+
+func (s *PgStore) DeleteServiceAccount(ctx context.Context, region string, id int64) error {
+	res, err := s.db.ExecContext(ctx,
+		`DELETE FROM service_accounts WHERE id = $1 AND region = $2`, id, region)
+	if err != nil {
+		return fmt.Errorf("pg delete service account: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("service account %d not found", id)
+	}
+	return nil
+}
+
 // Users (OIDC-synced)
 func (s *PgStore) UpsertUser(ctx context.Context, user *User) error {
 	if ctx == nil {
@@ -1242,8 +3397,8 @@ func (s *PgStore) GetUser(ctx context.Context, sub string) (*User, error) {
 	}
 	var u User
 	err := s.db.QueryRowContext(ctx,
-		`SELECT sub, username, email, name, is_admin, must_change_password, last_seen FROM users WHERE sub = $1`, sub).
-		Scan(&u.Sub, &u.Username, &u.Email, &u.Name, &u.IsAdmin, &u.MustChangePassword, &u.LastSeen)
+		`SELECT sub, username, email, name, is_admin, must_change_password, totp_enabled, last_seen, sessions_valid_after, enabled FROM users WHERE sub = $1`, sub).
+		Scan(&u.Sub, &u.Username, &u.Email, &u.Name, &u.IsAdmin, &u.MustChangePassword, &u.TOTPEnabled, &u.LastSeen, &u.SessionsValidAfter, &u.Enabled)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -1255,7 +3410,7 @@ func (s *PgStore) GetUser(ctx context.Context, sub string) (*User, error) {
 
 func (s *PgStore) ListUsers(ctx context.Context) ([]User, error) {
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT sub, username, email, name, is_admin, must_change_password, last_seen FROM users ORDER BY username`)
+		`SELECT sub, username, email, name, is_admin, must_change_password, totp_enabled, last_seen, sessions_valid_after, enabled FROM users ORDER BY username`)
 	if err != nil {
 		return nil, fmt.Errorf("pg list users: %w", err)
 	}
@@ -1264,7 +3419,7 @@ func (s *PgStore) ListUsers(ctx context.Context) ([]User, error) {
 	var result []User
 	for rows.Next() {
 		var u User
-		if err := rows.Scan(&u.Sub, &u.Username, &u.Email, &u.Name, &u.IsAdmin, &u.MustChangePassword, &u.LastSeen); err != nil {
+		if err := rows.Scan(&u.Sub, &u.Username, &u.Email, &u.Name, &u.IsAdmin, &u.MustChangePassword, &u.TOTPEnabled, &u.LastSeen, &u.SessionsValidAfter, &u.Enabled); err != nil {
 			return nil, fmt.Errorf("pg scan user: %w", err)
 		}
 		result = append(result, u)
@@ -1282,33 +3437,112 @@ func (s *PgStore) SetUserAdmin(ctx context.Context, sub string, isAdmin bool) er
 	if n == 0 {
 		return fmt.Errorf("user not found")
 	}
-	return nil
+	return nil
+}
+
+func (s *PgStore) GetUserPasswordHash(ctx context.Context, sub string) (string, error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var hash string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT password_hash FROM users WHERE sub = $1`, sub).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("pg get password hash: %w", err)
+	}
+	return hash, nil
+}
+
+func (s *PgStore) UpdateUserPassword(ctx context.Context, sub, passwordHash string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET password_hash = $1 WHERE sub = $2`, passwordHash, sub)
+	if err != nil {
+		return fmt.Errorf("pg update password: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PgStore) SetMustChangePassword(ctx context.Context, sub string, must bool) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET must_change_password = $1 WHERE sub = $2`, must, sub)
+	if err != nil {
+		return fmt.Errorf("pg set must_change_password: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PgStore) SetUserEnabled(ctx context.Context, sub string, enabled bool) error {
+	res, err := s.db.ExecContext(ctx,
+		`UPDATE users SET enabled = $1 WHERE sub = $2`, enabled, sub)
+	if err != nil {
+		return fmt.Errorf("pg set user enabled: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("user not found")
+	}
+	return nil
+}
+
+func (s *PgStore) RevokeUserSessions(ctx context.Context, sub string) (time.Time, error) {
+	var cutoff time.Time
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		err := tx.QueryRowContext(ctx,
+			`UPDATE users SET sessions_valid_after = NOW() WHERE sub = $1 RETURNING sessions_valid_after`, sub).Scan(&cutoff)
+		if err == sql.ErrNoRows {
+			return fmt.Errorf("user not found")
+		}
+		if err != nil {
+			return fmt.Errorf("pg revoke user sessions: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE refresh_tokens SET revoked = TRUE WHERE user_sub = $1 AND NOT revoked`, sub); err != nil {
+			return fmt.Errorf("pg revoke user refresh tokens: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cutoff, nil
 }
 
-func (s *PgStore) GetUserPasswordHash(ctx context.Context, sub string) (string, error) {
-	if ctx == nil {
-		ctx = context.Background()
-	}
-	var hash string
+func (s *PgStore) GetUserTOTP(ctx context.Context, sub string) (string, bool, error) {
+	var secret string
+	var enabled bool
 	err := s.db.QueryRowContext(ctx,
-		`SELECT password_hash FROM users WHERE sub = $1`, sub).Scan(&hash)
+		`SELECT totp_secret, totp_enabled FROM users WHERE sub = $1`, sub).Scan(&secret, &enabled)
 	if err == sql.ErrNoRows {
-		return "", nil
+		return "", false, nil
 	}
 	if err != nil {
-		return "", fmt.Errorf("pg get password hash: %w", err)
+		return "", false, fmt.Errorf("pg get user totp: %w", err)
 	}
-	return hash, nil
+	return secret, enabled, nil
 }
 
-func (s *PgStore) UpdateUserPassword(ctx context.Context, sub, passwordHash string) error {
-	if ctx == nil {
-		ctx = context.Background()
-	}
+func (s *PgStore) SetUserTOTP(ctx context.Context, sub, sealedSecret string, enabled bool) error {
 	res, err := s.db.ExecContext(ctx,
-		`UPDATE users SET password_hash = $1 WHERE sub = $2`, passwordHash, sub)
+		`UPDATE users SET totp_secret = $1, totp_enabled = $2 WHERE sub = $3`, sealedSecret, enabled, sub)
 	if err != nil {
-		return fmt.Errorf("pg update password: %w", err)
+		return fmt.Errorf("pg set user totp: %w", err)
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
@@ -1317,20 +3551,51 @@ func (s *PgStore) UpdateUserPassword(ctx context.Context, sub, passwordHash stri
 	return nil
 }
 
-func (s *PgStore) SetMustChangePassword(ctx context.Context, sub string, must bool) error {
-	if ctx == nil {
-		ctx = context.Background()
-	}
+func (s *PgStore) ClaimTOTPStep(ctx context.Context, sub string, step int64) (bool, error) {
+	// The conditional UPDATE makes concurrent logins with one code race on
+	// the row lock; only the first sees a row affected.
 	res, err := s.db.ExecContext(ctx,
-		`UPDATE users SET must_change_password = $1 WHERE sub = $2`, must, sub)
+		`UPDATE users SET totp_last_step = $2 WHERE sub = $1 AND totp_last_step < $2`, sub, step)
 	if err != nil {
-		return fmt.Errorf("pg set must_change_password: %w", err)
+		return false, fmt.Errorf("pg claim totp step: %w", err)
 	}
 	n, _ := res.RowsAffected()
-	if n == 0 {
-		return fmt.Errorf("user not found")
+	return n > 0, nil
+}
+
+func (s *PgStore) ListPasswordHistory(ctx context.Context, sub string, limit int) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT password_hash FROM password_history WHERE user_sub = $1 ORDER BY id DESC LIMIT $2`, sub, limit)
+	if err != nil {
+		return nil, fmt.Errorf("pg list password history: %w", err)
 	}
-	return nil
+	defer rows.Close()
+
+	var hashes []string
+	for rows.Next() {
+		var h string
+		if err := rows.Scan(&h); err != nil {
+			return nil, fmt.Errorf("pg scan password history: %w", err)
+		}
+		hashes = append(hashes, h)
+	}
+	return hashes, rows.Err()
+}
+
+func (s *PgStore) AddPasswordHistory(ctx context.Context, sub, passwordHash string, keep int) error {
+	return s.withTx(ctx, func(tx *tracedTx) error {
+		if _, err := tx.ExecContext(ctx,
+			`INSERT INTO password_history (user_sub, password_hash) VALUES ($1, $2)`, sub, passwordHash); err != nil {
+			return fmt.Errorf("pg insert password history: %w", err)
+		}
+		if _, err := tx.ExecContext(ctx, `
+			DELETE FROM password_history WHERE user_sub = $1 AND id NOT IN (
+				SELECT id FROM password_history WHERE user_sub = $1 ORDER BY id DESC LIMIT $2
+			)`, sub, keep); err != nil {
+			return fmt.Errorf("pg prune password history: %w", err)
+		}
+		return nil
+	})
 }
 
 func (s *PgStore) DeleteUser(ctx context.Context, sub string) error {
@@ -1357,8 +3622,8 @@ func (s *PgStore) GetActiveSigningKey(ctx context.Context) (*JWTSigningKey, erro
 	var k JWTSigningKey
 	var expiresAt sql.NullTime
 	err := s.db.QueryRowContext(ctx,
-		`SELECT kid, secret, status, created_at, expires_at FROM jwt_signing_keys WHERE status = 'active' ORDER BY created_at DESC LIMIT 1`).
-		Scan(&k.KID, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt)
+		`SELECT kid, algorithm, secret, status, created_at, expires_at FROM jwt_signing_keys WHERE status = 'active' ORDER BY created_at DESC LIMIT 1`).
+		Scan(&k.KID, &k.Algorithm, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -1378,9 +3643,9 @@ func (s *PgStore) GetSigningKeyByID(ctx context.Context, kid string) (*JWTSignin
 	var k JWTSigningKey
 	var expiresAt sql.NullTime
 	err := s.db.QueryRowContext(ctx,
-		`SELECT kid, secret, status, created_at, expires_at FROM jwt_signing_keys
+		`SELECT kid, algorithm, secret, status, created_at, expires_at FROM jwt_signing_keys
 		 WHERE kid = $1 AND (expires_at IS NULL OR expires_at > NOW())`, kid).
-		Scan(&k.KID, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt)
+		Scan(&k.KID, &k.Algorithm, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt)
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
@@ -1398,7 +3663,7 @@ func (s *PgStore) ListValidSigningKeys(ctx context.Context) ([]JWTSigningKey, er
 		ctx = context.Background()
 	}
 	rows, err := s.db.QueryContext(ctx,
-		`SELECT kid, secret, status, created_at, expires_at FROM jwt_signing_keys
+		`SELECT kid, algorithm, secret, status, created_at, expires_at FROM jwt_signing_keys
 		 WHERE status = 'active' OR (status = 'retired' AND expires_at > NOW())
 		 ORDER BY created_at DESC`)
 	if err != nil {
@@ -1410,7 +3675,7 @@ func (s *PgStore) ListValidSigningKeys(ctx context.Context) ([]JWTSigningKey, er
 	for rows.Next() {
 		var k JWTSigningKey
 		var expiresAt sql.NullTime
-		if err := rows.Scan(&k.KID, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt); err != nil {
+		if err := rows.Scan(&k.KID, &k.Algorithm, &k.Secret, &k.Status, &k.CreatedAt, &expiresAt); err != nil {
 			return nil, fmt.Errorf("pg scan signing key: %w", err)
 		}
 		if expiresAt.Valid {
@@ -1426,24 +3691,76 @@ func (s *PgStore) CreateSigningKey(ctx context.Context, key *JWTSigningKey) erro
 		ctx = context.Background()
 	}
 	_, err := s.db.ExecContext(ctx,
-		`INSERT INTO jwt_signing_keys (kid, secret, status, created_at, expires_at)
-		 VALUES ($1, $2, $3, $4, $5) ON CONFLICT (kid) DO NOTHING`,
-		key.KID, key.Secret, key.Status, key.CreatedAt, key.ExpiresAt)
+		`INSERT INTO jwt_signing_keys (kid, algorithm, secret, status, created_at, expires_at)
+		 VALUES ($1, $2, $3, $4, $5, $6) ON CONFLICT (kid) DO NOTHING`,
+		key.KID, key.Algorithm, key.Secret, key.Status, key.CreatedAt, key.ExpiresAt)
 	if err != nil {
 		return fmt.Errorf("pg create signing key: %w", err)
 	}
 	return nil
 }
 
-func (s *PgStore) RotateSigningKey(ctx context.Context, gracePeriod time.Duration) (*JWTSigningKey, error) {
+func (s *PgStore) RotateSigningKey(ctx context.Context, alg string, gracePeriod time.Duration) (*JWTSigningKey, error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
-	tx, err := s.db.BeginTx(ctx, nil)
+	var key *JWTSigningKey
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		var err error
+		key, err = s.rotateSigningKeyTx(ctx, tx, alg, gracePeriod)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("pg begin tx: %w", err)
+		return nil, err
+	}
+	return key, nil
+}
+
+// signingKeyRotationLockID is the advisory lock key that serializes
+// scheduled key rotation across replicas.
+const signingKeyRotationLockID = 0x6865726d65730001 // "hermes" + 1
+
+func (s *PgStore) RotateSigningKeyIfOlder(ctx context.Context, alg string, maxAge, gracePeriod time.Duration) (*JWTSigningKey, error) {
+	var key *JWTSigningKey
+	err := s.withTx(ctx, func(tx *tracedTx) error {
+		// Transaction-scoped: released on commit/rollback. Replicas that lose
+		// the race skip this round instead of waiting.
+		var locked bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT pg_try_advisory_xact_lock($1)`, int64(signingKeyRotationLockID)).Scan(&locked); err != nil {
+			return fmt.Errorf("pg rotation lock: %w", err)
+		}
+		if !locked {
+			return nil
+		}
+
+		// Re-check under the lock so a rotation by another replica is seen.
+		var due bool
+		if err := tx.QueryRowContext(ctx,
+			`SELECT COALESCE(MAX(created_at), 'epoch') < NOW() - make_interval(secs => $1)
+			 FROM jwt_signing_keys WHERE status = 'active'`, maxAge.Seconds()).Scan(&due); err != nil {
+			return fmt.Errorf("pg check key age: %w", err)
+		}
+		if !due {
+			return nil
+		}
+
+		var err error
+		key, err = s.rotateSigningKeyTx(ctx, tx, alg, gracePeriod)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (s *PgStore) rotateSigningKeyTx(ctx context.Context, tx *tracedTx, alg string, gracePeriod time.Duration) (*JWTSigningKey, error) {
+	// Generate new key material before touching the table.
+	key, err := NewSigningKey(alg)
+	if err != nil {
+		return nil, err
 	}
-	defer tx.Rollback()
 
 	// Retire all currently active keys with a grace period.
 	expiresAt := time.Now().Add(gracePeriod)
@@ -1453,17 +3770,9 @@ func (s *PgStore) RotateSigningKey(ctx context.Context, gracePeriod time.Duratio
 		return nil, fmt.Errorf("pg retire old keys: %w", err)
 	}
 
-	// Generate new active key.
-	secret := make([]byte, 32)
-	if _, err := rand.Read(secret); err != nil {
-		return nil, fmt.Errorf("generate key: %w", err)
-	}
-
-	kid := generateKeyID()
-	now := time.Now()
 	if _, err := tx.ExecContext(ctx,
-		`INSERT INTO jwt_signing_keys (kid, secret, status, created_at) VALUES ($1, $2, 'active', $3)`,
-		kid, secret, now); err != nil {
+		`INSERT INTO jwt_signing_keys (kid, algorithm, secret, status, created_at) VALUES ($1, $2, $3, 'active', $4)`,
+		key.KID, key.Algorithm, key.Secret, key.CreatedAt); err != nil {
 		return nil, fmt.Errorf("pg insert new signing key: %w", err)
 	}
 
@@ -1473,11 +3782,253 @@ func (s *PgStore) RotateSigningKey(ctx context.Context, gracePeriod time.Duratio
 		s.logger.Warnf("cleanup expired jwt keys: %v", err)
 	}
 
-	if err := tx.Commit(); err != nil {
-		return nil, fmt.Errorf("pg commit rotate key: %w", err)
+	return key, nil
+}
+
+// OIDC login state
+func (s *PgStore) CreateOIDCAuthState(ctx context.Context, st *OIDCAuthState) error {
+	// Housekeeping: drop abandoned logins.
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM oidc_auth_states WHERE expires_at < NOW()`); err != nil {
+		s.logger.Warnf("cleanup expired oidc auth states: %v", err)
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO oidc_auth_states (state, code_verifier, redirect_path, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		st.State, st.CodeVerifier, st.RedirectPath, st.CreatedAt, st.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("pg create oidc auth state: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ConsumeOIDCAuthState(ctx context.Context, state string) (*OIDCAuthState, error) {
+	var st OIDCAuthState
+	err := s.db.QueryRowContext(ctx,
+		`DELETE FROM oidc_auth_states WHERE state = $1
+		 RETURNING state, code_verifier, redirect_path, created_at, expires_at`, state).
+		Scan(&st.State, &st.CodeVerifier, &st.RedirectPath, &st.CreatedAt, &st.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg consume oidc auth state: %w", err)
+	}
+	if time.Now().After(st.ExpiresAt) {
+		return nil, nil
+	}
+	return &st, nil
+}
+
+// Idempotency keys
+
+// maxIdempotencyReserveAttempts bounds how often ReserveIdempotencyKey
+// retries a key that keeps disappearing between its insert and read.
+const maxIdempotencyReserveAttempts = 3
+
+func (s *PgStore) ReserveIdempotencyKey(ctx context.Context, region, route, key, fingerprint string, lease time.Duration) (*IdempotencyRecord, bool, error) {
+	// Housekeeping: drop keys past their retention window.
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at < NOW()`); err != nil {
+		s.logger.Warnf("cleanup expired idempotency keys: %v", err)
+	}
+	// A key released or expired between the insert and the read is claimed
+	// again, a bounded number of times.
+	for attempt := 0; attempt < maxIdempotencyReserveAttempts; attempt++ {
+		res, err := s.db.ExecContext(ctx, `
+			INSERT INTO idempotency_keys (key, region, route, fingerprint, expires_at)
+			VALUES ($1, $2, $3, $4, NOW() + $5 * INTERVAL '1 second')
+			ON CONFLICT (key, region, route) DO NOTHING`,
+			key, region, route, fingerprint, lease.Seconds())
+		if err != nil {
+			return nil, false, fmt.Errorf("pg reserve idempotency key: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 1 {
+			return nil, true, nil
+		}
+
+		var rec IdempotencyRecord
+		err = s.db.QueryRowContext(ctx, `
+			SELECT fingerprint, status, content_type, COALESCE(body, ''::bytea), created_at
+			FROM idempotency_keys WHERE key = $1 AND region = $2 AND route = $3`,
+			key, region, route).Scan(&rec.Fingerprint, &rec.Status, &rec.ContentType, &rec.Body, &rec.CreatedAt)
+		if err == sql.ErrNoRows {
+			continue
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("pg get idempotency key: %w", err)
+		}
+		return &rec, false, nil
+	}
+	return nil, false, fmt.Errorf("pg reserve idempotency key: released %d times while claiming it", maxIdempotencyReserveAttempts)
+}
+
+func (s *PgStore) CompleteIdempotencyKey(ctx context.Context, region, route, key string, rec *IdempotencyRecord, ttl time.Duration) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE idempotency_keys
+		SET status = $4, content_type = $5, body = $6, expires_at = NOW() + $7 * INTERVAL '1 second'
+		WHERE key = $1 AND region = $2 AND route = $3`,
+		key, region, route, rec.Status, rec.ContentType, rec.Body, ttl.Seconds())
+	if err != nil {
+		return fmt.Errorf("pg complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ReleaseIdempotencyKey(ctx context.Context, region, route, key string) error {
+	_, err := s.db.ExecContext(ctx,
+		`DELETE FROM idempotency_keys WHERE key = $1 AND region = $2 AND route = $3`, key, region, route)
+	if err != nil {
+		return fmt.Errorf("pg release idempotency key: %w", err)
+	}
+	return nil
+}
+
+// Builtin refresh tokens
+func (s *PgStore) CreateRefreshToken(ctx context.Context, tok *RefreshToken) error {
+	// Housekeeping: drop tokens that can no longer be used.
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE expires_at < NOW()`); err != nil {
+		s.logger.Warnf("cleanup expired refresh tokens: %v", err)
+	}
+	_, err := s.db.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, family_id, user_sub, created_at, expires_at) VALUES ($1, $2, $3, $4, $5)`,
+		tok.TokenHash, tok.FamilyID, tok.UserSub, tok.CreatedAt, tok.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("pg create refresh token: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ConsumeRefreshToken(ctx context.Context, tokenHash string) (*RefreshToken, bool, error) {
+	scan := func(row *sql.Row) (*RefreshToken, error) {
+		var t RefreshToken
+		var usedAt sql.NullTime
+		if err := row.Scan(&t.TokenHash, &t.FamilyID, &t.UserSub, &t.CreatedAt, &t.ExpiresAt, &usedAt, &t.Revoked); err != nil {
+			return nil, err
+		}
+		if usedAt.Valid {
+			t.UsedAt = &usedAt.Time
+		}
+		return &t, nil
+	}
+
+	// Fast path: first use. The used_at IS NULL guard makes concurrent
+	// consumers race safely: exactly one of them wins.
+	tok, err := scan(s.db.QueryRowContext(ctx, `
+		UPDATE refresh_tokens SET used_at = NOW()
+		WHERE token_hash = $1 AND used_at IS NULL
+		RETURNING token_hash, family_id, user_sub, created_at, expires_at, used_at, revoked`, tokenHash))
+	if err == nil {
+		return tok, false, nil
+	}
+	if err != sql.ErrNoRows {
+		return nil, false, fmt.Errorf("pg consume refresh token: %w", err)
+	}
+
+	// Either unknown or already used.
+	tok, err = scan(s.db.QueryRowContext(ctx, `
+		SELECT token_hash, family_id, user_sub, created_at, expires_at, used_at, revoked
+		FROM refresh_tokens WHERE token_hash = $1`, tokenHash))
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("pg get refresh token: %w", err)
+	}
+	return tok, true, nil
+}
+
+func (s *PgStore) RevokeRefreshTokenFamily(ctx context.Context, familyID string) error {
+	if _, err := s.db.ExecContext(ctx,
+		`UPDATE refresh_tokens SET revoked = TRUE WHERE family_id = $1`, familyID); err != nil {
+		return fmt.Errorf("pg revoke refresh token family: %w", err)
+	}
+	return nil
+}
+
+// Builtin login throttling
+func (s *PgStore) GetLoginLockout(ctx context.Context, email string) (time.Time, error) {
+	var until sql.NullTime
+	err := s.db.QueryRowContext(ctx,
+		`SELECT locked_until FROM login_attempts WHERE email = $1`, email).Scan(&until)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("pg get login lockout: %w", err)
+	}
+	if !until.Valid {
+		return time.Time{}, nil
+	}
+	return until.Time, nil
+}
+
+func (s *PgStore) RecordLoginFailure(ctx context.Context, email string, window time.Duration) (int, error) {
+	var failures int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO login_attempts (email, failures, window_start) VALUES ($1, 1, NOW())
+		ON CONFLICT (email) DO UPDATE SET
+			failures = CASE WHEN login_attempts.window_start < NOW() - make_interval(secs => $2)
+				THEN 1 ELSE login_attempts.failures + 1 END,
+			window_start = CASE WHEN login_attempts.window_start < NOW() - make_interval(secs => $2)
+				THEN NOW() ELSE login_attempts.window_start END
+		RETURNING failures`, email, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("pg record login failure: %w", err)
+	}
+	return failures, nil
+}
+
+func (s *PgStore) LockLogin(ctx context.Context, email string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO login_attempts (email, failures, window_start, locked_until) VALUES ($1, 0, NOW(), $2)
+		ON CONFLICT (email) DO UPDATE SET failures = 0, window_start = NOW(), locked_until = $2`,
+		email, until)
+	if err != nil {
+		return fmt.Errorf("pg lock login: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) ResetLoginFailures(ctx context.Context, email string) error {
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE email = $1`, email); err != nil {
+		return fmt.Errorf("pg reset login failures: %w", err)
 	}
+	return nil
+}
 
-	return &JWTSigningKey{KID: kid, Secret: secret, Status: "active", CreatedAt: now}, nil
+// NewSigningKey generates an active signing key for alg ("HS256", "RS256"
+// or "ES256"). Asymmetric keys are stored as PKCS#8 DER.
+func NewSigningKey(alg string) (*JWTSigningKey, error) {
+	var secret []byte
+	switch alg {
+	case "", "HS256":
+		alg = "HS256"
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate key: %w", err)
+		}
+	case "RS256", "ES256":
+		var priv any
+		var err error
+		if alg == "RS256" {
+			priv, err = rsa.GenerateKey(rand.Reader, 2048)
+		} else {
+			priv, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("generate key: %w", err)
+		}
+		if secret, err = x509.MarshalPKCS8PrivateKey(priv); err != nil {
+			return nil, fmt.Errorf("marshal key: %w", err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return &JWTSigningKey{
+		KID:       generateKeyID(),
+		Algorithm: alg,
+		Secret:    secret,
+		Status:    "active",
+		CreatedAt: time.Now(),
+	}, nil
 }
 
 func generateKeyID() string {
@@ -1601,40 +4152,189 @@ func (s *PgStore) RemoveGroupBinding(ctx context.Context, region, group string)
 // GetEffectiveRoleByGroups returns the highest-privilege role among all bindings for the given groups.
 // Role priority: owner > editor > viewer. Returns nil if no binding matches.
 func (s *PgStore) GetEffectiveRoleByGroups(ctx context.Context, region string, groups []string) (*RegionRole, error) {
-	if len(groups) == 0 {
-		return nil, nil
+	roles, err := s.GetRolesByGroups(ctx, region, groups)
+	if err != nil {
+		return nil, err
 	}
-
-	// Build placeholders for the group list.
-	args := []any{region}
-	placeholders := ""
-	for i, g := range groups {
-		if i > 0 {
-			placeholders += ","
+	var best *RegionRole
+	for _, role := range roles {
+		if best == nil || RolePriority(role) > RolePriority(*best) {
+			best = &role
 		}
-		placeholders += fmt.Sprintf("$%d", i+2)
-		args = append(args, g)
 	}
+	return best, nil
+}
 
+func (s *PgStore) GetRolesByGroups(ctx context.Context, region string, groups []string) ([]RegionRole, error) {
+	if len(groups) == 0 {
+		return nil, nil
+	}
 	rows, err := s.db.QueryContext(ctx,
-		fmt.Sprintf(`SELECT role FROM group_bindings WHERE region = $1 AND group_name IN (%s)`, placeholders),
-		args...)
+		`SELECT DISTINCT role FROM group_bindings WHERE region = $1 AND group_name = ANY($2)`,
+		region, pq.Array(groups))
 	if err != nil {
-		return nil, fmt.Errorf("pg get effective role by groups: %w", err)
+		return nil, fmt.Errorf("pg get roles by groups: %w", err)
 	}
 	defer rows.Close()
 
-	var best *RegionRole
+	var roles []RegionRole
 	for rows.Next() {
 		var role RegionRole
 		if err := rows.Scan(&role); err != nil {
 			return nil, fmt.Errorf("pg scan group role: %w", err)
 		}
-		if best == nil || RolePriority(role) > RolePriority(*best) {
-			best = &role
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// Custom Roles
+func (s *PgStore) ListCustomRoles(ctx context.Context, region string) ([]CustomRole, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT region, name, scopes, created_at, updated_at FROM custom_roles WHERE region = $1 ORDER BY name`, region)
+	if err != nil {
+		return nil, fmt.Errorf("pg list custom roles: %w", err)
+	}
+	defer rows.Close()
+
+	var result []CustomRole
+	for rows.Next() {
+		var cr CustomRole
+		if err := rows.Scan(&cr.Region, &cr.Name, pq.Array(&cr.Scopes), &cr.CreatedAt, &cr.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("pg scan custom role: %w", err)
+		}
+		if cr.Scopes == nil {
+			cr.Scopes = []string{}
+		}
+		result = append(result, cr)
+	}
+	return result, rows.Err()
+}
+
+func (s *PgStore) GetCustomRole(ctx context.Context, region, name string) (*CustomRole, error) {
+	var cr CustomRole
+	err := s.db.QueryRowContext(ctx,
+		`SELECT region, name, scopes, created_at, updated_at FROM custom_roles WHERE region = $1 AND name = $2`, region, name).
+		Scan(&cr.Region, &cr.Name, pq.Array(&cr.Scopes), &cr.CreatedAt, &cr.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get custom role: %w", err)
+	}
+	if cr.Scopes == nil {
+		cr.Scopes = []string{}
+	}
+	return &cr, nil
+}
+
+func (s *PgStore) PutCustomRole(ctx context.Context, role *CustomRole) error {
+	if role.Scopes == nil {
+		role.Scopes = []string{}
+	}
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO custom_roles (region, name, scopes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (region, name) DO UPDATE SET scopes = EXCLUDED.scopes, updated_at = NOW()
+		RETURNING created_at, updated_at`,
+		role.Region, role.Name, pq.Array(role.Scopes)).Scan(&role.CreatedAt, &role.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("pg put custom role: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) DeleteCustomRole(ctx context.Context, region, name string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM custom_roles
+		WHERE region = $1 AND name = $2
+		  AND NOT EXISTS (SELECT 1 FROM region_members WHERE region = $1 AND role = $2)
+		  AND NOT EXISTS (SELECT 1 FROM group_bindings WHERE region = $1 AND role = $2)`,
+		region, name)
+	if err != nil {
+		return fmt.Errorf("pg delete custom role: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	existing, err := s.GetCustomRole(ctx, region, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrConflict
+	}
+	return fmt.Errorf("custom role %q not found", name)
+}
+
+// Secrets
+func (s *PgStore) ListSecrets(ctx context.Context, region string) ([]Secret, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT region, name, description, created_at, updated_at FROM secrets WHERE region = $1 ORDER BY name`, region)
+	if err != nil {
+		return nil, fmt.Errorf("pg list secrets: %w", err)
+	}
+	defer rows.Close()
+
+	var result []Secret
+	for rows.Next() {
+		var sec Secret
+		if err := rows.Scan(&sec.Region, &sec.Name, &sec.Description, &sec.CreatedAt, &sec.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("pg scan secret: %w", err)
 		}
+		result = append(result, sec)
+	}
+	return result, rows.Err()
+}
+
+func (s *PgStore) GetSecret(ctx context.Context, region, name string) (*Secret, error) {
+	var sec Secret
+	err := s.db.QueryRowContext(ctx,
+		`SELECT region, name, description, sealed_value, created_at, updated_at FROM secrets WHERE region = $1 AND name = $2`, region, name).
+		Scan(&sec.Region, &sec.Name, &sec.Description, &sec.SealedValue, &sec.CreatedAt, &sec.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get secret: %w", err)
+	}
+	return &sec, nil
+}
+
+func (s *PgStore) PutSecret(ctx context.Context, secret *Secret) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO secrets (region, name, description, sealed_value)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (region, name) DO UPDATE
+		SET description = EXCLUDED.description, sealed_value = EXCLUDED.sealed_value, updated_at = NOW()
+		RETURNING created_at, updated_at`,
+		secret.Region, secret.Name, secret.Description, secret.SealedValue).Scan(&secret.CreatedAt, &secret.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("pg put secret: %w", err)
+	}
+	return nil
+}
+
+func (s *PgStore) DeleteSecret(ctx context.Context, region, name string) error {
+	res, err := s.db.ExecContext(ctx, `
+		DELETE FROM secrets
+		WHERE region = $1 AND name = $2
+		  AND NOT EXISTS (SELECT 1 FROM domains WHERE region = $1 AND config->'tls'->>'certificate_ref' = $2)`,
+		region, name)
+	if err != nil {
+		return fmt.Errorf("pg delete secret: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	existing, err := s.GetSecret(ctx, region, name)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return ErrConflict
 	}
-	return best, rows.Err()
+	return fmt.Errorf("secret %q not found", name)
 }
 
 // RolePriority returns numeric priority for role comparison.
@@ -1650,3 +4350,36 @@ func RolePriority(r RegionRole) int {
 		return 0
 	}
 }
+
+// Maintenance mode
+// GetMaintenance always reads the primary: a replica lagging behind would
+// let writes through after maintenance was switched on.
+func (s *PgStore) GetMaintenance(ctx context.Context) (*Maintenance, error) {
+	var m Maintenance
+	err := s.db.QueryRowContext(ctx,
+		`SELECT enabled, message, updated_by, updated_at FROM maintenance`,
+	).Scan(&m.Enabled, &m.Message, &m.UpdatedBy, &m.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return &Maintenance{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("pg get maintenance: %w", err)
+	}
+	return &m, nil
+}
+
+func (s *PgStore) SetMaintenance(ctx context.Context, m *Maintenance) error {
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO maintenance (id, enabled, message, updated_by, updated_at)
+		VALUES (TRUE, $1, $2, $3, NOW())
+		ON CONFLICT (id) DO UPDATE SET
+			enabled = EXCLUDED.enabled, message = EXCLUDED.message,
+			updated_by = EXCLUDED.updated_by, updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		m.Enabled, m.Message, m.UpdatedBy,
+	).Scan(&m.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("pg set maintenance: %w", err)
+	}
+	return nil
+}
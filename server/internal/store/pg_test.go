@@ -2,6 +2,10 @@ package store
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"strings"
 	"testing"
 	"time"
@@ -16,7 +20,7 @@ import (
 	"go.uber.org/zap"
 )
 
-func startPostgres(t *testing.T, ctx context.Context) (*PgStore, func()) {
+func startPostgres(t testing.TB, ctx context.Context) (*PgStore, func()) {
 	t.Helper()
 
 	pgContainer, err := postgres.Run(ctx,
@@ -36,7 +40,7 @@ func startPostgres(t *testing.T, ctx context.Context) (*PgStore, func()) {
 	require.NoError(t, err)
 
 	logger, _ := zap.NewDevelopment()
-	store, err := NewPgStore(connStr, logger.Sugar())
+	store, err := NewPgStore(connStr, "", logger.Sugar())
 	require.NoError(t, err)
 
 	return store, func() {
@@ -45,6 +49,76 @@ func startPostgres(t *testing.T, ctx context.Context) (*PgStore, func()) {
 	}
 }
 
+func TestCurrentRevision_UsesIndex(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	rev, err := s.CurrentRevision(ctx, "empty")
+	require.NoError(t, err)
+	assert.Zero(t, rev)
+
+	for i := 0; i < 50; i++ {
+		require.NoError(t, s.InsertAuditLog(ctx, "default", "domain", fmt.Sprintf("d%d", i), "update", "test"))
+		require.NoError(t, s.InsertAuditLog(ctx, "other", "domain", fmt.Sprintf("d%d", i), "update", "test"))
+	}
+	rev, err = s.CurrentRevision(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, int64(99), rev)
+
+	_, err = s.db.ExecContext(ctx, `ANALYZE change_log`)
+	require.NoError(t, err)
+	rows, err := s.db.QueryContext(ctx, `EXPLAIN `+currentRevisionQuery, "default")
+	require.NoError(t, err)
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var line string
+		require.NoError(t, rows.Scan(&line))
+		plan = append(plan, line)
+	}
+	joined := strings.Join(plan, "\n")
+	assert.Contains(t, joined, "Limit")
+	assert.Contains(t, joined, "Index")
+	assert.Contains(t, joined, "Backward")
+	assert.NotContains(t, joined, "Seq Scan")
+}
+
+func TestReaderRouting(t *testing.T) {
+	primary, replica := &tracedDB{}, &tracedDB{}
+	s := &PgStore{db: primary, read: replica}
+
+	ctx := context.Background()
+	assert.Same(t, replica, s.reader(ctx))
+	markWrite(ctx) // no marker installed: no-op
+	assert.Same(t, replica, s.reader(ctx))
+
+	reqCtx := WithReadYourWrites(ctx)
+	assert.Same(t, replica, s.reader(reqCtx))
+	markWrite(reqCtx)
+	assert.Same(t, primary, s.reader(reqCtx), "reads after a write must see the primary")
+	assert.Same(t, replica, s.reader(WithReadYourWrites(ctx)), "tracking is per request")
+}
+
+func TestStatementName(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT revision FROM change_log WHERE region=$1", "SELECT change_log"},
+		{"\n\t\tINSERT INTO domains (region, name) VALUES ($1, $2)", "INSERT domains"},
+		{"UPDATE users SET is_admin = $1", "UPDATE users"},
+		{"DELETE FROM secrets WHERE region = $1", "DELETE secrets"},
+		{"SELECT pg_try_advisory_xact_lock($1)", "SELECT"},
+		{"WITH moved AS (\n DELETE FROM change_log WHERE revision IN (SELECT revision FROM change_log)\n RETURNING *\n)\nINSERT INTO change_log_archive SELECT * FROM moved", "INSERT change_log_archive"},
+		{"-- ── Regions ──\nCREATE TABLE IF NOT EXISTS regions (name TEXT)", "CREATE"},
+		{"", "SQL"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, statementName(tt.query), tt.query)
+	}
+}
+
 func sampleDomain(name string) *model.DomainConfig {
 	return &model.DomainConfig{
 		Name:  name,
@@ -84,7 +158,7 @@ func TestDomainCRUD(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Create
 	ver, err := s.PutDomain(ctx, region, sampleDomain("api"), "create", "test", 0)
@@ -135,13 +209,285 @@ func TestDeleteDomainNotFound(t *testing.T) {
 	assert.Contains(t, err.Error(), "not found")
 }
 
+func TestFindDomainsByCluster(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	api := sampleDomain("api")
+	api.Routes[0].Clusters = []model.WeightedCluster{{Name: "backend", Weight: 90}, {Name: "canary", Weight: 10}}
+	_, err := s.PutDomain(ctx, "default", api, "create", "test", 0)
+	require.NoError(t, err)
+	web := sampleDomain("web")
+	web.Routes[0].Clusters = []model.WeightedCluster{{Name: "frontend", Weight: 100}}
+	_, err = s.PutDomain(ctx, "default", web, "create", "test", 0)
+	require.NoError(t, err)
+
+	domains, err := s.FindDomainsByCluster(ctx, "default", "canary")
+	require.NoError(t, err)
+	require.Len(t, domains, 1)
+	assert.Equal(t, "api", domains[0].Name)
+
+	domains, err = s.FindDomainsByCluster(ctx, "default", "other")
+	require.NoError(t, err)
+	assert.Empty(t, domains)
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	api := sampleDomain("api")
+	api.Hosts = []string{"api.example.com"}
+	_, err := s.PutDomain(ctx, "default", api, "create", "test", 0)
+	require.NoError(t, err)
+	web := sampleDomain("web")
+	web.Hosts = []string{"www.example.com", "api-docs.example.com"}
+	_, err = s.PutDomain(ctx, "default", web, "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.PutCluster(ctx, "default", &model.ClusterConfig{Name: "legacy-api"}, "create", "test", 0)
+	require.NoError(t, err)
+
+	results, err := s.Search(ctx, "default", "API", 10)
+	require.NoError(t, err)
+	assert.Equal(t, []SearchResult{
+		{Type: "domain", Name: "api", MatchField: "name", Match: "api"},
+		{Type: "domain", Name: "web", MatchField: "host", Match: "api-docs.example.com"},
+		{Type: "cluster", Name: "legacy-api", MatchField: "name", Match: "legacy-api"},
+	}, results)
+
+	// Wildcards in the term match literally.
+	results, err = s.Search(ctx, "default", "a_i", 10)
+	require.NoError(t, err)
+	assert.Empty(t, results)
+}
+
+func TestPurgeHistory(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.PutDomain(ctx, "default", sampleDomain("web"), "create", "test", 0)
+	require.NoError(t, err)
+
+	_, err = s.PurgeHistory(ctx, "default", "domain", "api", "admin")
+	assert.ErrorIs(t, err, ErrResourceExists)
+
+	_, err = s.DeleteDomain(ctx, "default", "api", "test")
+	require.NoError(t, err)
+	purged, err := s.PurgeHistory(ctx, "default", "domain", "api", "admin")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), purged) // create and delete, in history and change_log
+
+	history, err := s.GetDomainHistory(ctx, "default", "api")
+	require.NoError(t, err)
+	assert.Empty(t, history)
+	history, err = s.GetDomainHistory(ctx, "default", "web")
+	require.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	events, _, err := s.WatchFrom(ctx, "default", 0, nil)
+	require.NoError(t, err)
+	for _, e := range events {
+		assert.NotEqual(t, "api", e.Name)
+	}
+	assert.Equal(t, "sync", events[len(events)-1].Kind)
+
+	purged, err = s.PurgeHistory(ctx, "default", "domain", "api", "admin")
+	require.NoError(t, err)
+	assert.Zero(t, purged)
+}
+
+func TestDeleteDomainsBulk(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	for _, name := range []string{"a", "b", "c"} {
+		_, err := s.PutDomain(ctx, "default", sampleDomain(name), "create", "test", 0)
+		require.NoError(t, err)
+	}
+	_, rev, err := s.WatchFrom(ctx, "default", 0, nil)
+	require.NoError(t, err)
+
+	results, err := s.DeleteDomains(ctx, "default", []string{"b", "missing", "a", "b"}, "alice")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, BulkDeleteResult{Name: "b", Deleted: true, Version: 2}, results[0])
+	assert.False(t, results[1].Deleted)
+	assert.Contains(t, results[1].Error, "not found")
+	assert.True(t, results[2].Deleted)
+
+	remaining, err := s.ListDomains(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, "c", remaining[0].Name)
+
+	// One delete event per resource, so the controller removes each key.
+	events, _, err := s.WatchFrom(ctx, "default", rev, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	for _, ev := range events {
+		assert.Equal(t, "delete", ev.Action)
+		assert.Equal(t, "alice", ev.Operator)
+	}
+
+	results, err = s.DeleteClusters(ctx, "default", []string{"none"}, "alice")
+	require.NoError(t, err)
+	require.Len(t, results, 1)
+	assert.False(t, results[0].Deleted)
+}
+
+func TestDeleteOrphanClusters(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	for _, name := range []string{"backend", "stale", "unused"} {
+		_, err := s.PutCluster(ctx, "default", sampleCluster(name), "create", "test", 0)
+		require.NoError(t, err)
+	}
+	_, err := s.PutDomain(ctx, "default", sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	// A domain without routes references nothing.
+	_, err = s.PutDomain(ctx, "default", &model.DomainConfig{Name: "bare", Hosts: []string{"bare.example.com"}}, "create", "test", 0)
+	require.NoError(t, err)
+
+	deleted, err := s.DeleteOrphanClusters(ctx, "default", "alice")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stale", "unused"}, deleted)
+
+	remaining, err := s.ListClusters(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, remaining, 1)
+	assert.Equal(t, "backend", remaining[0].Name)
+
+	deleted, err = s.DeleteOrphanClusters(ctx, "default", "alice")
+	require.NoError(t, err)
+	assert.Empty(t, deleted)
+}
+
+func TestPutDomainSkipNoop(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	ver, err := s.PutDomain(ctx, "default", sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	_, rev, err := s.WatchFrom(ctx, "default", 0, nil)
+	require.NoError(t, err)
+
+	noopCtx, noop := WithSkipNoop(ctx)
+	got, err := s.PutDomain(noopCtx, "default", sampleDomain("api"), "update", "test", 1)
+	require.NoError(t, err)
+	assert.True(t, noop.Skipped)
+	assert.Equal(t, ver, got)
+	_, rv, err := s.GetDomain(ctx, "default", "api")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), rv)
+	events, _, err := s.WatchFrom(ctx, "default", rev, nil)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	// A stale version still conflicts, and a real change is written.
+	_, err = s.PutDomain(noopCtx, "default", sampleDomain("api"), "update", "test", 7)
+	assert.ErrorIs(t, err, ErrConflict)
+	changed := sampleDomain("api")
+	changed.Hosts = append(changed.Hosts, "other.example.com")
+	got, err = s.PutDomain(noopCtx, "default", changed, "update", "test", 1)
+	require.NoError(t, err)
+	assert.False(t, noop.Skipped)
+	assert.Equal(t, ver+1, got)
+
+	// Without the opt-in an identical write is still recorded.
+	got, err = s.PutDomain(ctx, "default", changed, "update", "test", 2)
+	require.NoError(t, err)
+	assert.Equal(t, ver+2, got)
+}
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	deleteAll := func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, `DELETE FROM domains WHERE region = $1`, "default")
+		return err
+	}
+
+	// An error from fn rolls back what it did and is returned as is.
+	errBoom := errors.New("boom")
+	err = s.WithTx(ctx, func(tx *sql.Tx) error {
+		if err := deleteAll(tx); err != nil {
+			return err
+		}
+		return errBoom
+	})
+	assert.ErrorIs(t, err, errBoom)
+	d, _, err := s.GetDomain(ctx, "default", "api")
+	require.NoError(t, err)
+	assert.NotNil(t, d)
+
+	require.NoError(t, s.WithTx(ctx, deleteAll))
+	d, _, err = s.GetDomain(ctx, "default", "api")
+	require.NoError(t, err)
+	assert.Nil(t, d)
+}
+
+func TestApplyBatch(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("old"), "create", "test", 0)
+	require.NoError(t, err)
+	_, rev, err := s.WatchFrom(ctx, "default", 0, nil)
+	require.NoError(t, err)
+
+	results, err := s.ApplyBatch(ctx, "default", []BatchOp{
+		{Op: "put", Kind: "cluster", Cluster: sampleCluster("backend")},
+		{Op: "put", Kind: "domain", Domain: sampleDomain("new")},
+		{Op: "delete", Kind: "domain", Name: "old"},
+	}, "alice")
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+	assert.Equal(t, BatchResult{Op: "delete", Kind: "domain", Name: "old", Version: 2}, results[2])
+
+	events, rev, err := s.WatchFrom(ctx, "default", rev, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "create", events[0].Action)
+	assert.Equal(t, "delete", events[2].Action)
+
+	// A failing op rolls back the ops before it.
+	_, err = s.ApplyBatch(ctx, "default", []BatchOp{
+		{Op: "delete", Kind: "domain", Name: "new"},
+		{Op: "put", Kind: "cluster", Cluster: sampleCluster("backend")}, // already exists
+	}, "alice")
+	assert.ErrorIs(t, err, ErrConflict)
+	d, _, err := s.GetDomain(ctx, "default", "new")
+	require.NoError(t, err)
+	assert.NotNil(t, d)
+	events, _, err = s.WatchFrom(ctx, "default", rev, nil)
+	require.NoError(t, err)
+	assert.Empty(t, events)
+
+	_, err = s.ApplyBatch(ctx, "default", []BatchOp{{Op: "put", Kind: "domain"}}, "alice")
+	assert.Error(t, err)
+}
+
 // Cluster CRUD Tests
 func TestClusterCRUD(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	ver, err := s.PutCluster(ctx, region, sampleCluster("backend"), "create", "test", 0)
 	require.NoError(t, err)
@@ -171,7 +517,7 @@ func TestDomainHistory(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Create v1
 	d := sampleDomain("hist")
@@ -206,12 +552,43 @@ func TestDomainHistory(t *testing.T) {
 	assert.Equal(t, "hist.example.com", d2.Hosts[0])
 }
 
+func TestClusterHealthCheckRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	c := sampleCluster("hc")
+	c.HealthCheck = &model.HealthCheckConfig{Active: &model.ActiveHealthCheck{
+		Interval: 5, Path: "/healthz", Timeout: 2,
+		HealthyStatuses: []int{200}, HealthyThreshold: 2, UnhealthyThreshold: 3,
+	}}
+	_, err := s.PutCluster(ctx, "default", c, "create", "test", 0)
+	require.NoError(t, err)
+
+	got, _, err := s.GetCluster(ctx, "default", "hc")
+	require.NoError(t, err)
+	assert.Equal(t, c, got)
+
+	// The watch stream (what the controller writes to etcd) carries it too.
+	events, _, err := s.WatchFrom(ctx, "default", 0, nil)
+	require.NoError(t, err)
+	require.NotEmpty(t, events)
+	assert.Equal(t, c.HealthCheck, events[len(events)-1].Cluster.HealthCheck)
+
+	plain := sampleCluster("plain")
+	_, err = s.PutCluster(ctx, "default", plain, "create", "test", 0)
+	require.NoError(t, err)
+	got, _, err = s.GetCluster(ctx, "default", "plain")
+	require.NoError(t, err)
+	assert.Nil(t, got.HealthCheck, "health checks stay disabled unless configured")
+}
+
 func TestClusterHistory(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 	c := sampleCluster("hist-cluster")
 	s.PutCluster(ctx, region, c, "create", "alice", 0)
 
@@ -237,7 +614,7 @@ func TestDomainOCC_CreateConflict(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// First create succeeds
 	ver, err := s.PutDomain(ctx, region, sampleDomain("occ"), "create", "alice", 0)
@@ -254,7 +631,7 @@ func TestDomainOCC_UpdateStaleVersion(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Create
 	s.PutDomain(ctx, region, sampleDomain("occ2"), "create", "alice", 0)
@@ -286,7 +663,7 @@ func TestDomainOCC_BypassMode(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Create with bypass (-1)
 	_, err := s.PutDomain(ctx, region, sampleDomain("bypass"), "create", "test", -1)
@@ -307,7 +684,7 @@ func TestClusterOCC_CreateConflict(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	_, err := s.PutCluster(ctx, region, sampleCluster("occ-c"), "create", "alice", 0)
 	require.NoError(t, err)
@@ -321,7 +698,7 @@ func TestClusterOCC_UpdateStaleVersion(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	s.PutCluster(ctx, region, sampleCluster("occ-c2"), "create", "alice", 0)
 	_, rv1, _ := s.GetCluster(ctx, region, "occ-c2")
@@ -348,7 +725,7 @@ func TestWatchFrom(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Initial revision should be 0
 	rev, err := s.CurrentRevision(ctx, region)
@@ -360,23 +737,131 @@ func TestWatchFrom(t *testing.T) {
 	s.PutCluster(ctx, region, sampleCluster("watch-c1"), "create", "test", 0)
 
 	// Watch from 0
-	events, maxRev, err := s.WatchFrom(ctx, region, 0)
+	events, maxRev, err := s.WatchFrom(ctx, region, 0, nil)
 	require.NoError(t, err)
 	assert.Len(t, events, 2)
 	assert.True(t, maxRev > 0)
 
 	// Watch from maxRev should return no events
-	events2, _, err := s.WatchFrom(ctx, region, maxRev)
+	events2, _, err := s.WatchFrom(ctx, region, maxRev, nil)
 	require.NoError(t, err)
 	assert.Empty(t, events2)
 
 	// One more change
 	s.PutDomain(ctx, region, sampleDomain("watch2"), "create", "test", 0)
-	events3, _, err := s.WatchFrom(ctx, region, maxRev)
+	events3, _, err := s.WatchFrom(ctx, region, maxRev, nil)
 	require.NoError(t, err)
 	assert.Len(t, events3, 1)
 	assert.Equal(t, "domain", events3[0].Kind)
 	assert.Equal(t, "watch2", events3[0].Name)
+
+	// A kind filter keeps matching events and sync requests.
+	_, err = s.TriggerSync(ctx, region, "test")
+	require.NoError(t, err)
+	events4, _, err := s.WatchFrom(ctx, region, 0, []string{"cluster"})
+	require.NoError(t, err)
+	require.Len(t, events4, 2)
+	assert.Equal(t, "watch-c1", events4[0].Name)
+	assert.Equal(t, "sync", events4[1].Kind)
+
+	// The revision returned covers the events filtered out too, so a
+	// filtered watcher moves past them.
+	_, err = s.PutDomain(ctx, region, sampleDomain("watch3"), "create", "test", 0)
+	require.NoError(t, err)
+	rev, err = s.CurrentRevision(ctx, region)
+	require.NoError(t, err)
+	events5, maxRev5, err := s.WatchFrom(ctx, region, events4[1].Revision, []string{"cluster"})
+	require.NoError(t, err)
+	assert.Empty(t, events5)
+	assert.Equal(t, rev, maxRev5)
+}
+
+func TestConfigChangesSince(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("kept"), "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.PutCluster(ctx, "default", sampleCluster("gone"), "create", "test", 0)
+	require.NoError(t, err)
+	since, err := s.ConfigRevision(ctx, "default")
+	require.NoError(t, err)
+
+	_, err = s.PutDomain(ctx, "default", sampleDomain("kept"), "update", "alice", -1)
+	require.NoError(t, err)
+	_, err = s.PutDomain(ctx, "default", sampleDomain("fresh"), "import", "bob", -1)
+	require.NoError(t, err)
+	_, err = s.DeleteCluster(ctx, "default", "gone", "alice")
+	require.NoError(t, err)
+	_, err = s.PutCluster(ctx, "default", sampleCluster("tmp"), "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.DeleteCluster(ctx, "default", "tmp", "test")
+	require.NoError(t, err)
+	_, err = s.TriggerSync(ctx, "default", "test")
+	require.NoError(t, err)
+
+	changes, err := s.ConfigChangesSince(ctx, "default", since)
+	require.NoError(t, err)
+	require.Len(t, changes, 3)
+	assert.Equal(t, "kept", changes[0].Name)
+	assert.Equal(t, ChangeUpdated, changes[0].Change)
+	assert.Equal(t, "alice", changes[0].Operator)
+	require.NotNil(t, changes[0].Domain)
+	assert.Equal(t, "fresh", changes[1].Name)
+	assert.Equal(t, ChangeCreated, changes[1].Change)
+	assert.Equal(t, "gone", changes[2].Name)
+	assert.Equal(t, ChangeDeleted, changes[2].Change)
+	assert.Nil(t, changes[2].Cluster)
+
+	rev, err := s.ConfigRevision(ctx, "default")
+	require.NoError(t, err)
+	changes, err = s.ConfigChangesSince(ctx, "default", rev)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestChangeReason(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("d1"), "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.DeleteDomain(WithChangeReason(ctx, "OPS-1: decommission"), "default", "d1", "alice")
+	require.NoError(t, err)
+
+	history, err := s.GetDomainHistory(ctx, "default", "d1")
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "OPS-1: decommission", history[0].Reason)
+	assert.Empty(t, history[1].Reason)
+
+	audit, _, err := s.ListAuditLog(ctx, "default", time.Time{}, 0, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, audit, 2)
+	assert.Equal(t, "delete", audit[0].Action)
+	assert.Equal(t, "OPS-1: decommission", audit[0].Reason)
+}
+
+func TestTriggerSync(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	s.PutDomain(ctx, "default", sampleDomain("d1"), "create", "test", 0)
+	before, err := s.CurrentRevision(ctx, "default")
+	require.NoError(t, err)
+
+	rev, err := s.TriggerSync(ctx, "default", "alice")
+	require.NoError(t, err)
+	assert.Greater(t, rev, before)
+
+	events, maxRev, err := s.WatchFrom(ctx, "default", before, nil)
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	assert.Equal(t, "sync", events[0].Kind)
+	assert.Equal(t, rev, maxRev)
 }
 
 // Region Tests
@@ -411,12 +896,73 @@ func TestRegions(t *testing.T) {
 }
 
 // Bulk Config Tests
+func TestFindDomainsByHost(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	a := sampleDomain("a")
+	a.Hosts = []string{"shared.example.com", "a.example.com"}
+	b := sampleDomain("b")
+	b.Hosts = []string{"shared.example.com"}
+	_, err := s.PutDomain(ctx, "default", a, "create", "test", 0)
+	require.NoError(t, err)
+	// Only imports and rollbacks (-1) can leave two claimants behind.
+	_, err = s.PutDomain(ctx, "default", b, "import", "test", -1)
+	require.NoError(t, err)
+	_, err = s.PutDomain(ctx, "other", a, "create", "test", 0)
+	require.NoError(t, err)
+
+	found, err := s.FindDomainsByHost(ctx, "default", "shared.example.com")
+	require.NoError(t, err)
+	require.Len(t, found, 2)
+	assert.Equal(t, "a", found[0].Name)
+	assert.Equal(t, "b", found[1].Name)
+
+	found, err = s.FindDomainsByHost(ctx, "default", "a.example.com")
+	require.NoError(t, err)
+	assert.Len(t, found, 1)
+
+	found, err = s.FindDomainsByHost(ctx, "default", "example.com")
+	require.NoError(t, err)
+	assert.Empty(t, found, "matching is exact, not substring")
+
+	found, err = s.FindDomainsByHost(ctx, "default", "a.example.com", "nope.example.com")
+	require.NoError(t, err)
+	assert.Len(t, found, 1, "any of the given hosts matches")
+}
+
+func TestPutDomain_HostConflict(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	a := sampleDomain("a")
+	a.Hosts = []string{"shared.example.com"}
+	_, err := s.PutDomain(ctx, "default", a, "create", "test", 0)
+	require.NoError(t, err)
+
+	b := sampleDomain("b")
+	b.Hosts = []string{"b.example.com", "shared.example.com"}
+	_, err = s.PutDomain(ctx, "default", b, "create", "test", 0)
+	var hc *HostConflictError
+	require.ErrorAs(t, err, &hc)
+	assert.Equal(t, "shared.example.com", hc.Host)
+	assert.Equal(t, "a", hc.Domain)
+
+	// A domain keeps its own hosts on update; other regions are independent.
+	_, err = s.PutDomain(ctx, "default", a, "update", "test", 1)
+	require.NoError(t, err)
+	_, err = s.PutDomain(ctx, "other", b, "create", "test", 0)
+	require.NoError(t, err)
+}
+
 func TestPutAllConfig(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Pre-populate
 	s.PutDomain(ctx, region, sampleDomain("old"), "create", "test", 0)
@@ -425,7 +971,7 @@ func TestPutAllConfig(t *testing.T) {
 	// Replace all
 	newDomains := []model.DomainConfig{*sampleDomain("new1"), *sampleDomain("new2")}
 	newClusters := []model.ClusterConfig{*sampleCluster("new-c")}
-	_, err := s.PutAllConfig(ctx, region, newDomains, newClusters, "import-test")
+	_, err := s.PutAllConfig(ctx, region, newDomains, newClusters, "import-test", -1)
 	require.NoError(t, err)
 
 	// Old data should be gone
@@ -439,82 +985,887 @@ func TestPutAllConfig(t *testing.T) {
 	assert.Len(t, domains, 2)
 	clusters, _ := s.ListClusters(ctx, region)
 	assert.Len(t, clusters, 1)
+
+	// Each imported resource gets one history version and one change event,
+	// clusters first.
+	h, err := s.GetDomainHistory(ctx, region, "new1")
+	require.NoError(t, err)
+	require.Len(t, h, 1)
+	assert.Equal(t, "import", h[0].Action)
+	oldHist, err := s.GetDomainHistory(ctx, region, "old")
+	require.NoError(t, err)
+	require.NotEmpty(t, oldHist)
+	_, err = s.PutAllConfig(ctx, region, []model.DomainConfig{*sampleDomain("old")}, nil, "import-test", -1)
+	require.NoError(t, err)
+	h, err = s.GetDomainHistory(ctx, region, "old")
+	require.NoError(t, err)
+	assert.Equal(t, oldHist[0].Version+1, h[0].Version, "import continues the existing version sequence")
+
+	events, _, err := s.WatchFrom(ctx, region, 0, nil)
+	require.NoError(t, err)
+	var imported, deleted []string
+	for _, e := range events {
+		switch e.Action {
+		case "import":
+			imported = append(imported, e.Kind+"/"+e.Name)
+		case "delete":
+			deleted = append(deleted, e.Kind+"/"+e.Name)
+		}
+	}
+	assert.Equal(t, []string{"cluster/new-c", "domain/new1", "domain/new2", "domain/old"}, imported)
+	assert.Equal(t, []string{"domain/old", "cluster/old-c", "domain/new1", "domain/new2", "cluster/new-c"}, deleted,
+		"resources an import drops get delete events, domains first")
 }
 
-// Audit Log Tests
-func TestAuditLog(t *testing.T) {
+func TestConfigAtRevision(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
+	s.PutCluster(ctx, region, sampleCluster("backend"), "create", "test", 0)
+	rev, err := s.PutDomain(ctx, region, sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	s.InsertAuditLog(ctx, region, "domain", "api", "lock", "test") // audit-only, no config
 
-	s.PutDomain(ctx, region, sampleDomain("audit1"), "create", "alice", 0)
-	s.PutDomain(ctx, region, sampleDomain("audit2"), "create", "bob", 0)
-	s.DeleteDomain(ctx, region, "audit1", "charlie")
+	updated := sampleDomain("api")
+	updated.Hosts = []string{"changed.example.com"}
+	s.PutDomain(ctx, region, updated, "update", "test", -1)
+	s.PutDomain(ctx, region, sampleDomain("web"), "create", "test", 0)
+	s.DeleteCluster(ctx, region, "backend", "test")
 
-	entries, total, err := s.ListAuditLog(ctx, region, 50, 0)
+	cfg, err := s.ConfigAtRevision(ctx, region, rev)
 	require.NoError(t, err)
-	assert.True(t, total >= 3)
+	require.Len(t, cfg.Domains, 1)
+	assert.Equal(t, sampleDomain("api").Hosts, cfg.Domains[0].Hosts)
+	require.Len(t, cfg.Clusters, 1)
+	assert.Equal(t, "backend", cfg.Clusters[0].Name)
+
+	// Archived events still count.
+	_, err = s.ArchiveChangeLog(ctx, -time.Hour, 100)
+	require.NoError(t, err)
+	current, err := s.ConfigRevision(ctx, region)
+	require.NoError(t, err)
+	cfg, err = s.ConfigAtRevision(ctx, region, current)
+	require.NoError(t, err)
+	assert.Len(t, cfg.Domains, 2)
+	assert.Empty(t, cfg.Clusters)
+}
+
+func TestConfigAtRevision_ImportWithoutDeleteEvents(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	s.PutCluster(ctx, region, sampleCluster("backend"), "create", "test", 0)
+	s.PutDomain(ctx, region, sampleDomain("old"), "create", "test", 0)
+	rev, err := s.PutAllConfig(ctx, region, []model.DomainConfig{*sampleDomain("new")},
+		[]model.ClusterConfig{*sampleCluster("backend")}, "test", -1)
+	require.NoError(t, err)
+	// History written before imports recorded the resources they dropped.
+	_, err = s.db.ExecContext(ctx, `DELETE FROM change_log WHERE action = 'delete'`)
+	require.NoError(t, err)
+
+	cfg, err := s.ConfigAtRevision(ctx, region, rev)
+	require.NoError(t, err)
+	require.Len(t, cfg.Domains, 1)
+	assert.Equal(t, "new", cfg.Domains[0].Name)
+	assert.Len(t, cfg.Clusters, 1)
+}
+
+func TestStreamConfig(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	s.PutCluster(ctx, region, sampleCluster("backend"), "create", "test", 0)
+	s.PutDomain(ctx, region, sampleDomain("web"), "create", "test", 0)
+	s.PutDomain(ctx, region, sampleDomain("api"), "create", "test", 0)
+
+	var got []string
+	err := s.StreamConfig(ctx, region, func(kind string, config json.RawMessage) error {
+		var v struct {
+			Name string `json:"name"`
+		}
+		require.NoError(t, json.Unmarshal(config, &v))
+		got = append(got, kind+"/"+v.Name)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"domain/api", "domain/web", "cluster/backend"}, got)
+
+	stop := errors.New("stop")
+	calls := 0
+	err = s.StreamConfig(ctx, region, func(string, json.RawMessage) error { calls++; return stop })
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestListHistory(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	s.PutDomain(ctx, region, sampleDomain("api"), "create", "alice", 0)
+	s.PutCluster(ctx, region, sampleCluster("backend"), "create", "bob", 0)
+	s.PutDomain(ctx, region, sampleDomain("api"), "update", "bob", -1)
+
+	entries, total, err := s.ListHistory(ctx, region, HistoryFilter{}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, entries, 3)
+	assert.Equal(t, "update", entries[0].Action, "newest first")
+	assert.NotNil(t, entries[0].Domain)
+	assert.NotNil(t, entries[1].Cluster)
+
+	entries, total, err = s.ListHistory(ctx, region, HistoryFilter{Kind: "domain", Operator: "bob"}, 10, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "api", entries[0].Name)
+
+	_, total, err = s.ListHistory(ctx, region, HistoryFilter{Until: time.Now().Add(-time.Hour)}, 10, 0)
+	require.NoError(t, err)
+	assert.Zero(t, total)
+
+	entries, total, err = s.ListHistory(ctx, region, HistoryFilter{}, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "backend", entries[0].Name)
+}
+
+func TestPutAllConfig_ExpectedRevision(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	rev, err := s.ConfigRevision(ctx, region)
+	require.NoError(t, err)
+	assert.Zero(t, rev)
+
+	_, err = s.PutDomain(ctx, region, sampleDomain("a"), "create", "test", 0)
+	require.NoError(t, err)
+	rev, err = s.ConfigRevision(ctx, region)
+	require.NoError(t, err)
+	assert.NotZero(t, rev)
+
+	// Audit-only events don't advance the config revision.
+	require.NoError(t, s.InsertAuditLog(ctx, region, "credential", "ci", "create", "test"))
+	after, err := s.ConfigRevision(ctx, region)
+	require.NoError(t, err)
+	assert.Equal(t, rev, after)
+
+	_, err = s.PutAllConfig(ctx, region, []model.DomainConfig{*sampleDomain("b")}, nil, "test", rev-1)
+	assert.ErrorIs(t, err, ErrConflict)
+	d, _, _ := s.GetDomain(ctx, region, "a")
+	assert.NotNil(t, d, "rejected import must not change anything")
+
+	newRev, err := s.PutAllConfig(ctx, region, []model.DomainConfig{*sampleDomain("b")}, nil, "test", rev)
+	require.NoError(t, err)
+	assert.Greater(t, newRev, rev)
+	current, err := s.ConfigRevision(ctx, region)
+	require.NoError(t, err)
+	assert.Equal(t, newRev, current)
+}
+
+// BenchmarkPutAllConfig imports 1000 domains and 1000 clusters per iteration.
+func BenchmarkPutAllConfig(b *testing.B) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(b, ctx)
+	defer cleanup()
+
+	domains := make([]model.DomainConfig, 1000)
+	clusters := make([]model.ClusterConfig, 1000)
+	for i := range domains {
+		domains[i] = *sampleDomain(fmt.Sprintf("d%04d", i))
+		clusters[i] = *sampleCluster(fmt.Sprintf("c%04d", i))
+	}
+
+	b.ResetTimer()
+	for b.Loop() {
+		if _, err := s.PutAllConfig(ctx, "default", domains, clusters, "bench", -1); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// Audit Log Tests
+func TestMaintainTables(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.PutDomain(ctx, "default", sampleDomain("d1"), "create", "test", 0)
+	require.NoError(t, err)
+
+	results, err := s.MaintainTables(ctx, true)
+	require.NoError(t, err)
+	require.Len(t, results, len(maintainedTables))
+	assert.Equal(t, "domains", results[0].Table)
+	assert.True(t, results[0].Reindexed)
+
+	// A run holding the lock elsewhere turns others away.
+	conn, err := s.db.Conn(ctx)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, int64(tableMaintenanceLockID))
+	require.NoError(t, err)
+	_, err = s.MaintainTables(ctx, false)
+	assert.ErrorIs(t, err, ErrMaintenanceRunning)
+	_, err = conn.ExecContext(ctx, `SELECT pg_advisory_unlock($1)`, int64(tableMaintenanceLockID))
+	require.NoError(t, err)
+
+	_, err = s.MaintainTables(ctx, false)
+	assert.NoError(t, err)
+}
+
+func TestArchiveChangeLog(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, s.InsertAuditLog(ctx, "default", "domain", name, "create", "test"))
+	}
+	require.NoError(t, s.InsertAuditLog(ctx, "other", "domain", "x", "create", "test"))
+	_, err := s.db.ExecContext(ctx, `UPDATE change_log SET created_at = NOW() - INTERVAL '100 days'`)
+	require.NoError(t, err)
+	rev, err := s.CurrentRevision(ctx, "default")
+	require.NoError(t, err)
+
+	// Batches of 1 still drain everything eligible; the newest row of each
+	// region stays live.
+	n, err := s.ArchiveChangeLog(ctx, 30*24*time.Hour, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	after, err := s.CurrentRevision(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, rev, after)
+
+	live, total, err := s.ListAuditLog(ctx, "default", time.Time{}, 0, 50, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, "c", live[0].Name)
+
+	all, total, err := s.ListAuditLog(ctx, "default", time.Now().Add(-365*24*time.Hour), 0, 50, 0)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, all, 3)
+	assert.Equal(t, []string{"c", "b", "a"}, []string{all[0].Name, all[1].Name, all[2].Name})
+
+	n, err = s.ArchiveChangeLog(ctx, 30*24*time.Hour, 100)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+
+	// The oldest event on record is found in the archive.
+	oldestRev, oldestAt, err := s.OldestRetainedChange(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, all[2].Revision, oldestRev)
+	assert.WithinDuration(t, time.Now().Add(-100*24*time.Hour), oldestAt, time.Hour)
+	oldestRev, _, err = s.OldestRetainedChange(ctx, "nowhere")
+	require.NoError(t, err)
+	assert.Zero(t, oldestRev)
+}
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+
+	s.PutDomain(ctx, region, sampleDomain("audit1"), "create", "alice", 0)
+	s.PutDomain(ctx, region, sampleDomain("audit2"), "create", "bob", 0)
+	s.DeleteDomain(ctx, region, "audit1", "charlie")
+
+	entries, total, err := s.ListAuditLog(ctx, region, time.Time{}, 0, 50, 0)
+	require.NoError(t, err)
+	assert.True(t, total >= 3)
 	assert.True(t, len(entries) >= 3)
+
+	// Cursor paging: entries below the cursor, newest first; total unchanged.
+	page, pageTotal, err := s.ListAuditLog(ctx, region, time.Time{}, entries[0].Revision, 1, 0)
+	require.NoError(t, err)
+	assert.Equal(t, total, pageTotal)
+	require.Len(t, page, 1)
+	assert.Equal(t, entries[1].Revision, page[0].Revision)
+}
+
+// API Credentials Tests
+func TestAPICredentialsCRUD(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+
+	// Create
+	cred := &APICredential{
+		AccessKey:   "test-ak-12345",
+		SecretKey:   "test-sk-secret",
+		Description: "test credential",
+		Scopes:      []string{ScopeConfigRead, ScopeConfigWrite},
+		Enabled:     true,
+	}
+	result, err := s.CreateAPICredential(ctx, region, cred)
+	require.NoError(t, err)
+	assert.True(t, result.ID > 0)
+	assert.Equal(t, region, result.Region)
+
+	// List
+	creds, err := s.ListAPICredentials(ctx, region)
+	require.NoError(t, err)
+	assert.Len(t, creds, 1)
+	assert.Equal(t, "test-ak-12345", creds[0].AccessKey)
+
+	// Get by AK (global lookup)
+	found, err := s.GetAPICredentialByAK(ctx, "test-ak-12345")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "test-sk-secret", found.SecretKey)
+	assert.True(t, found.Enabled)
+
+	// Update
+	found.Description = "updated"
+	found.Scopes = []string{ScopeConfigRead}
+	err = s.UpdateAPICredential(ctx, region, found)
+	require.NoError(t, err)
+
+	// Delete
+	err = s.DeleteAPICredential(ctx, region, found.ID)
+	require.NoError(t, err)
+
+	creds2, _ := s.ListAPICredentials(ctx, region)
+	assert.Empty(t, creds2)
+}
+
+func TestSetAPICredentialsEnabled(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	for _, c := range []*APICredential{
+		{AccessKey: "ak-1", SecretKey: "sk", Description: "ci deploy", Scopes: []string{ScopeConfigWrite}, Enabled: true},
+		{AccessKey: "ak-2", SecretKey: "sk", Description: "ci read", Scopes: []string{ScopeConfigRead}, Enabled: true},
+		{AccessKey: "ak-3", SecretKey: "sk", Description: "other region", Scopes: []string{ScopeConfigWrite}, Enabled: true},
+	} {
+		region := "default"
+		if c.AccessKey == "ak-3" {
+			region = "staging"
+		}
+		_, err := s.CreateAPICredential(ctx, region, c)
+		require.NoError(t, err)
+	}
+
+	changed, err := s.SetAPICredentialsEnabled(ctx, "default", CredentialFilter{Scope: ScopeConfigWrite}, false, "alice")
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.Equal(t, "ak-1", changed[0].AccessKey)
+	assert.False(t, changed[0].Enabled)
+
+	found, err := s.GetAPICredentialByAK(ctx, "ak-1")
+	require.NoError(t, err)
+	assert.False(t, found.Enabled)
+	found, err = s.GetAPICredentialByAK(ctx, "ak-3")
+	require.NoError(t, err)
+	assert.True(t, found.Enabled, "other regions are untouched")
+
+	// Already disabled: nothing changes, nothing is audited.
+	changed, err = s.SetAPICredentialsEnabled(ctx, "default", CredentialFilter{Description: "deploy"}, false, "alice")
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	changed, err = s.SetAPICredentialsEnabled(ctx, "default", CredentialFilter{Description: "^ci"}, true, "alice")
+	require.NoError(t, err)
+	require.Len(t, changed, 1)
+	assert.True(t, changed[0].Enabled)
+
+	entries, _, err := s.ListAuditLog(ctx, "default", time.Time{}, 0, 10, 0)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "enable", entries[0].Action)
+	assert.Equal(t, "disable", entries[1].Action)
+	assert.Equal(t, "credential", entries[1].Kind)
+
+	_, err = s.SetAPICredentialsEnabled(ctx, "default", CredentialFilter{Description: "("}, false, "alice")
+	assert.Error(t, err)
+}
+
+func TestSealAPICredentialSecrets(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	_, err := s.CreateAPICredential(ctx, "default", &APICredential{AccessKey: "ak-1", SecretKey: "sk-1", Enabled: true})
+	require.NoError(t, err)
+	_, err = s.CreateAPICredential(ctx, "staging", &APICredential{AccessKey: "ak-2", SecretKey: "v1:already", Enabled: true})
+	require.NoError(t, err)
+
+	seal := func(sk string) (string, error) { return "v1:" + sk, nil }
+	n, err := s.SealAPICredentialSecrets(ctx, seal)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	found, err := s.GetAPICredentialByAK(ctx, "ak-1")
+	require.NoError(t, err)
+	assert.Equal(t, "v1:sk-1", found.SecretKey)
+	found, err = s.GetAPICredentialByAK(ctx, "ak-2")
+	require.NoError(t, err)
+	assert.Equal(t, "v1:already", found.SecretKey)
+
+	// Idempotent: a second run finds nothing left to seal.
+	n, err = s.SealAPICredentialSecrets(ctx, seal)
+	require.NoError(t, err)
+	assert.Zero(t, n)
+}
+
+func TestGetAPICredentialByAK_NotFound(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	found, err := s.GetAPICredentialByAK(ctx, "nonexistent-ak")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+}
+
+func TestServiceAccountsCRUD(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	sa, err := s.CreateServiceAccount(ctx, region, &ServiceAccount{
+		Name:        "deployer",
+		Scopes:      []string{"config:read"},
+		TokenPrefix: "hsa_abcd1234",
+	}, "hash-1")
+	require.NoError(t, err)
+	assert.NotZero(t, sa.ID)
+
+	_, err = s.CreateServiceAccount(ctx, region, &ServiceAccount{Name: "deployer"}, "hash-2")
+	assert.ErrorIs(t, err, ErrConflict)
+
+	found, err := s.GetServiceAccountByTokenHash(ctx, "hash-1")
+	require.NoError(t, err)
+	require.NotNil(t, found)
+	assert.Equal(t, "deployer", found.Name)
+	assert.Equal(t, region, found.Region)
+	assert.Equal(t, []string{"config:read"}, found.Scopes)
+
+	list, err := s.ListServiceAccounts(ctx, region)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Empty(t, list[0].Token)
+
+	require.NoError(t, s.DeleteServiceAccount(ctx, region, sa.ID))
+	found, err = s.GetServiceAccountByTokenHash(ctx, "hash-1")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+	assert.Error(t, s.DeleteServiceAccount(ctx, region, sa.ID))
+}
+
+func TestCustomRoles(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	require.NoError(t, s.PutCustomRole(ctx, &CustomRole{
+		Region: region, Name: "release-manager",
+		Scopes: []string{ScopeConfigRead, ScopeConfigRollback},
+	}))
+	cr, err := s.GetCustomRole(ctx, region, "release-manager")
+	require.NoError(t, err)
+	require.NotNil(t, cr)
+	assert.Equal(t, []string{ScopeConfigRead, ScopeConfigRollback}, cr.Scopes)
+
+	missing, err := s.GetCustomRole(ctx, "other", "release-manager")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	require.NoError(t, s.SetGroupBinding(ctx, region, "releasers", "release-manager"))
+	require.NoError(t, s.SetGroupBinding(ctx, region, "devs", RoleEditor))
+	roles, err := s.GetRolesByGroups(ctx, region, []string{"releasers", "devs", "unknown"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []RegionRole{"release-manager", RoleEditor}, roles)
+
+	assert.ErrorIs(t, s.DeleteCustomRole(ctx, region, "release-manager"), ErrConflict)
+	require.NoError(t, s.RemoveGroupBinding(ctx, region, "releasers"))
+	require.NoError(t, s.DeleteCustomRole(ctx, region, "release-manager"))
+
+	list, err := s.ListCustomRoles(ctx, region)
+	require.NoError(t, err)
+	assert.Empty(t, list)
+	assert.Error(t, s.DeleteCustomRole(ctx, region, "release-manager"))
+}
+
+func TestSecrets(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	sec := &Secret{Region: region, Name: "api-cert", Description: "api.example.com", SealedValue: "v1:first"}
+	require.NoError(t, s.PutSecret(ctx, sec))
+	assert.False(t, sec.CreatedAt.IsZero())
+
+	sec.SealedValue = "v1:second"
+	require.NoError(t, s.PutSecret(ctx, sec))
+	got, err := s.GetSecret(ctx, region, "api-cert")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "v1:second", got.SealedValue)
+
+	list, err := s.ListSecrets(ctx, region)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Empty(t, list[0].SealedValue, "list must not load sealed values")
+
+	missing, err := s.GetSecret(ctx, "other", "api-cert")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	d := sampleDomain("api")
+	d.TLS = &model.DomainTLSConfig{CertificateRef: "api-cert"}
+	_, err = s.PutDomain(ctx, region, d, "create", "test", 0)
+	require.NoError(t, err)
+	assert.ErrorIs(t, s.DeleteSecret(ctx, region, "api-cert"), ErrConflict)
+
+	_, err = s.DeleteDomain(ctx, region, "api", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.DeleteSecret(ctx, region, "api-cert"))
+	assert.Error(t, s.DeleteSecret(ctx, region, "api-cert"))
+}
+
+func TestRegionSettingsAndCounts(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	settings, err := s.GetRegionSettings(ctx, region)
+	require.NoError(t, err)
+	require.NotNil(t, settings)
+	assert.Nil(t, settings.MaxDomains)
+
+	limit := 5
+	require.NoError(t, s.PutRegionSettings(ctx, region, &RegionSettings{MaxDomains: &limit}))
+	settings, err = s.GetRegionSettings(ctx, region)
+	require.NoError(t, err)
+	require.NotNil(t, settings.MaxDomains)
+	assert.Equal(t, 5, *settings.MaxDomains)
+	assert.Nil(t, settings.MaxClusters)
+
+	missing, err := s.GetRegionSettings(ctx, "nope")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+	assert.Error(t, s.PutRegionSettings(ctx, "nope", &RegionSettings{}))
+
+	_, err = s.PutDomain(ctx, region, sampleDomain("api"), "create", "test", 0)
+	require.NoError(t, err)
+	_, err = s.PutCluster(ctx, region, sampleCluster("backend"), "create", "test", 0)
+	require.NoError(t, err)
+	n, err := s.CountDomains(ctx, region)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = s.CountClusters(ctx, region)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+}
+
+func TestListByLabels(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	for _, d := range []*model.DomainConfig{
+		{Name: "pay", Hosts: []string{"pay.example.com"}, Labels: map[string]string{"team": "payments", "env": "prod"}},
+		{Name: "pay-staging", Hosts: []string{"pay.staging.example.com"}, Labels: map[string]string{"team": "payments", "env": "staging"}},
+		{Name: "search", Hosts: []string{"search.example.com"}},
+	} {
+		_, err := s.PutDomain(ctx, region, d, "create", "test", 0)
+		require.NoError(t, err)
+	}
+	_, err := s.PutCluster(ctx, region, &model.ClusterConfig{Name: "pay", Labels: map[string]string{"team": "payments"}}, "create", "test", 0)
+	require.NoError(t, err)
+
+	domains, err := s.ListDomainsByLabels(ctx, region, map[string]string{"team": "payments"})
+	require.NoError(t, err)
+	require.Len(t, domains, 2)
+	assert.Equal(t, "pay", domains[0].Name)
+	assert.Equal(t, "prod", domains[0].Labels["env"])
+
+	domains, err = s.ListDomainsByLabels(ctx, region, map[string]string{"team": "payments", "env": "staging"})
+	require.NoError(t, err)
+	require.Len(t, domains, 1)
+	assert.Equal(t, "pay-staging", domains[0].Name)
+
+	clusters, err := s.ListClustersByLabels(ctx, region, map[string]string{"team": "payments"})
+	require.NoError(t, err)
+	assert.Len(t, clusters, 1)
+	clusters, err = s.ListClustersByLabels(ctx, region, map[string]string{"team": "search"})
+	require.NoError(t, err)
+	assert.Empty(t, clusters)
+
+	cfg, err := s.GetConfigByLabels(ctx, region, map[string]string{"env": "prod"})
+	require.NoError(t, err)
+	require.Len(t, cfg.Domains, 1)
+	assert.Equal(t, "pay", cfg.Domains[0].Name)
+	assert.NotNil(t, cfg.Clusters)
+	assert.Empty(t, cfg.Clusters)
+}
+
+func TestRegionFrozen(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	frozen, err := s.IsRegionFrozen(ctx, "default")
+	require.NoError(t, err)
+	assert.False(t, frozen)
+
+	found, err := s.SetRegionFrozen(ctx, "default", true)
+	require.NoError(t, err)
+	assert.True(t, found)
+	frozen, err = s.IsRegionFrozen(ctx, "default")
+	require.NoError(t, err)
+	assert.True(t, frozen)
+
+	found, err = s.SetRegionFrozen(ctx, "nope", true)
+	require.NoError(t, err)
+	assert.False(t, found)
+	frozen, err = s.IsRegionFrozen(ctx, "nope")
+	require.NoError(t, err)
+	assert.False(t, frozen)
+}
+
+func TestRegionTargets(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	targets, found, err := s.GetRegionTargets(ctx, "default")
+	require.NoError(t, err)
+	assert.True(t, found)
+	assert.Empty(t, targets)
+
+	want := []FanoutTarget{{Name: "us-east", Endpoints: []string{"http://etcd:2379"}, DomainPrefix: "/hermes/domains"}}
+	found, err = s.SetRegionTargets(ctx, "default", want)
+	require.NoError(t, err)
+	assert.True(t, found)
+	targets, _, err = s.GetRegionTargets(ctx, "default")
+	require.NoError(t, err)
+	assert.Equal(t, want, targets)
+
+	found, err = s.SetRegionTargets(ctx, "nope", want)
+	require.NoError(t, err)
+	assert.False(t, found)
+	_, found, err = s.GetRegionTargets(ctx, "nope")
+	require.NoError(t, err)
+	assert.False(t, found)
+}
+
+func TestConfigCanary(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	c, err := s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	assert.Nil(t, c)
+
+	cfg := model.GatewayConfig{Clusters: []model.ClusterConfig{*sampleCluster("canary-c")}}
+	require.NoError(t, s.PutConfigCanary(ctx, "default", &ConfigCanary{Percent: 10, Config: cfg, CreatedBy: "alice"}))
+	require.NoError(t, s.PutConfigCanary(ctx, "default", &ConfigCanary{Percent: 25, Config: cfg, CreatedBy: "bob"}))
+	c, err = s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	require.NotNil(t, c)
+	assert.Equal(t, 25, c.Percent)
+	assert.Equal(t, "bob", c.CreatedBy)
+	assert.Equal(t, "canary-c", c.Config.Clusters[0].Name)
+
+	found, err := s.DeleteConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	assert.True(t, found)
+	found, err = s.DeleteConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	// Promotion is refused once the stable config moved past the canary's base.
+	require.NoError(t, s.PutConfigCanary(ctx, "default", &ConfigCanary{Percent: 10, Config: cfg, BaseRevision: 0}))
+	_, err = s.PutCluster(ctx, "default", sampleCluster("hotfix"), "create", "test", 0)
+	require.NoError(t, err)
+	c, err = s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	_, err = s.PromoteConfigCanary(ctx, "default", c, "alice")
+	assert.ErrorIs(t, err, ErrConflict)
+	c, err = s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	require.NotNil(t, c, "a refused promotion keeps the canary")
+
+	base, err := s.ConfigRevision(ctx, "default")
+	require.NoError(t, err)
+	require.NoError(t, s.PutConfigCanary(ctx, "default", &ConfigCanary{Percent: 10, Config: cfg, BaseRevision: base}))
+	c, err = s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	rev, err := s.PromoteConfigCanary(ctx, "default", c, "alice")
+	require.NoError(t, err)
+	assert.Greater(t, rev, base)
+	c, err = s.GetConfigCanary(ctx, "default")
+	require.NoError(t, err)
+	assert.Nil(t, c)
+	clusters, err := s.ListClusters(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "canary-c", clusters[0].Name)
 }
 
-// API Credentials Tests
-func TestAPICredentialsCRUD(t *testing.T) {
+func TestMaintenance(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	m, err := s.GetMaintenance(ctx)
+	require.NoError(t, err)
+	assert.False(t, m.Enabled)
 
-	// Create
-	cred := &APICredential{
-		AccessKey:   "test-ak-12345",
-		SecretKey:   "test-sk-secret",
-		Description: "test credential",
-		Scopes:      []string{ScopeConfigRead, ScopeConfigWrite},
-		Enabled:     true,
-	}
-	result, err := s.CreateAPICredential(ctx, region, cred)
+	require.NoError(t, s.SetMaintenance(ctx, &Maintenance{Enabled: true, Message: "db upgrade", UpdatedBy: "admin"}))
+	m, err = s.GetMaintenance(ctx)
 	require.NoError(t, err)
-	assert.True(t, result.ID > 0)
-	assert.Equal(t, region, result.Region)
+	assert.True(t, m.Enabled)
+	assert.Equal(t, "db upgrade", m.Message)
+	assert.Equal(t, "admin", m.UpdatedBy)
 
-	// List
-	creds, err := s.ListAPICredentials(ctx, region)
+	require.NoError(t, s.SetMaintenance(ctx, &Maintenance{UpdatedBy: "admin"}))
+	m, err = s.GetMaintenance(ctx)
 	require.NoError(t, err)
-	assert.Len(t, creds, 1)
-	assert.Equal(t, "test-ak-12345", creds[0].AccessKey)
+	assert.False(t, m.Enabled)
+	assert.Empty(t, m.Message)
+}
 
-	// Get by AK (global lookup)
-	found, err := s.GetAPICredentialByAK(ctx, "test-ak-12345")
+func TestGetDomainWithMeta(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	_, err := s.PutDomain(ctx, region, sampleDomain("api"), "create", "test", 0)
 	require.NoError(t, err)
-	require.NotNil(t, found)
-	assert.Equal(t, "test-sk-secret", found.SecretKey)
-	assert.True(t, found.Enabled)
+	d, meta, err := s.GetDomainWithMeta(ctx, region, "api")
+	require.NoError(t, err)
+	require.NotNil(t, d)
+	assert.Equal(t, int64(1), meta.ResourceVersion)
+	assert.False(t, meta.UpdatedAt.IsZero())
 
-	// Update
-	found.Description = "updated"
-	found.Scopes = []string{ScopeConfigRead}
-	err = s.UpdateAPICredential(ctx, region, found)
+	_, err = s.PutDomain(ctx, region, sampleDomain("api"), "update", "test", 1)
 	require.NoError(t, err)
+	_, err = s.RollbackDomain(ctx, region, "api", 1, "test")
+	require.NoError(t, err)
+	_, after, err := s.GetDomainWithMeta(ctx, region, "api")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), after.ResourceVersion, "rollbacks bump the resource version")
+	assert.False(t, after.UpdatedAt.Before(meta.UpdatedAt))
 
-	// Delete
-	err = s.DeleteAPICredential(ctx, region, found.ID)
+	_, missing, err := s.GetClusterWithMeta(ctx, region, "nope")
 	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
 
-	creds2, _ := s.ListAPICredentials(ctx, region)
-	assert.Empty(t, creds2)
+func TestLastOperatorMeta(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	d := &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}
+	_, err := s.PutDomain(ctx, region, d, "create", "alice", 0)
+	require.NoError(t, err)
+	_, err = s.PutDomain(ctx, region, d, "update", "bob", 1)
+	require.NoError(t, err)
+	_, err = s.PutCluster(ctx, region, &model.ClusterConfig{Name: "backend"}, "create", "carol", 0)
+	require.NoError(t, err)
+
+	_, meta, err := s.GetDomainWithMeta(ctx, region, "api")
+	require.NoError(t, err)
+	assert.Equal(t, "bob", meta.LastOperator)
+	assert.Equal(t, int64(2), meta.ResourceVersion)
+
+	domains, metas, err := s.ListDomainsWithMeta(ctx, region, nil)
+	require.NoError(t, err)
+	require.Len(t, domains, 1)
+	assert.Equal(t, "api", domains[0].Name)
+	assert.Equal(t, "bob", metas[0].LastOperator)
+	domains, _, err = s.ListDomainsWithMeta(ctx, region, map[string]string{"team": "payments"})
+	require.NoError(t, err)
+	assert.Empty(t, domains)
+
+	_, cmeta, err := s.GetClusterWithMeta(ctx, region, "backend")
+	require.NoError(t, err)
+	assert.Equal(t, "carol", cmeta.LastOperator)
+	clusters, cmetas, err := s.ListClustersWithMeta(ctx, region, nil)
+	require.NoError(t, err)
+	require.Len(t, clusters, 1)
+	assert.Equal(t, "carol", cmetas[0].LastOperator)
 }
 
-func TestGetAPICredentialByAK_NotFound(t *testing.T) {
+func TestListRegionStatus(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	found, err := s.GetAPICredentialByAK(ctx, "nonexistent-ak")
+	require.NoError(t, s.CreateRegion(ctx, "empty"))
+	rev, err := s.PutDomain(ctx, "default", sampleDomain("api"), "create", "test", 0)
 	require.NoError(t, err)
-	assert.Nil(t, found)
+	require.NoError(t, s.UpsertGatewayInstances(ctx, "default", []GatewayInstanceStatus{
+		{ID: "gw-1", Status: "running", ConfigRevision: rev},
+		{ID: "gw-2", Status: "running", ConfigRevision: rev - 1},
+	}))
+	require.NoError(t, s.UpsertControllerStatus(ctx, "default", &ControllerStatus{ID: "ctrl-1", Status: "running", ConfigRevision: rev}))
+
+	summaries, err := s.ListRegionStatus(ctx)
+	require.NoError(t, err)
+	require.Len(t, summaries, 2)
+	got := summaries[0]
+	require.NotNil(t, got.Controller)
+	assert.Equal(t, "ctrl-1", got.Controller.ID)
+	got.Controller = nil
+	assert.Equal(t, RegionStatusSummary{Region: "default", ConfigRevision: rev, Instances: 2, InstancesOnline: 2, InstancesInSync: 1}, got)
+	assert.Equal(t, RegionStatusSummary{Region: "empty"}, summaries[1])
+}
+
+func TestInstanceRevisionHistory(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	report := func(rev int64) {
+		require.NoError(t, s.UpsertGatewayInstances(ctx, region, []GatewayInstanceStatus{{ID: "gw-1", Status: "running", ConfigRevision: rev}}))
+	}
+	report(0)
+	report(3)
+	report(3) // keepalive, no transition
+	report(5)
+
+	events, err := s.ListInstanceRevisionHistory(ctx, region, "gw-1")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, int64(3), events[0].ConfigRevision)
+	assert.Equal(t, int64(5), events[1].ConfigRevision)
+
+	// History is capped per instance.
+	for rev := int64(6); rev < 6+revisionHistoryPerInstance; rev++ {
+		report(rev)
+	}
+	events, err = s.ListInstanceRevisionHistory(ctx, region, "gw-1")
+	require.NoError(t, err)
+	assert.Len(t, events, revisionHistoryPerInstance)
+	assert.Equal(t, int64(6), events[0].ConfigRevision)
 }
 
 // Gateway Status Tests
@@ -523,7 +1874,7 @@ func TestGatewayInstanceStatus(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 	instances := []GatewayInstanceStatus{
 		{ID: "gw-1", Status: "running", ConfigRevision: 10},
 		{ID: "gw-2", Status: "running", ConfigRevision: 10},
@@ -544,12 +1895,124 @@ func TestGatewayInstanceStatus(t *testing.T) {
 	assert.Len(t, list2, 1)
 }
 
+func TestScheduledChanges(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	due := &ScheduledChange{Kind: "domain", Name: "api", Action: "put", ApplyAt: time.Now().Add(-time.Minute), CreatedBy: "alice", Reason: "OPS-1",
+		Domain: &model.DomainConfig{Name: "api", Hosts: []string{"api.example.com"}}}
+	dueID, err := s.CreateScheduledChange(ctx, region, due)
+	require.NoError(t, err)
+	laterID, err := s.CreateScheduledChange(ctx, region, &ScheduledChange{Kind: "cluster", Name: "c", Action: "delete", ApplyAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	pending, err := s.ListScheduledChanges(ctx, region, ScheduledPending)
+	require.NoError(t, err)
+	require.Len(t, pending, 2)
+	assert.Equal(t, dueID, pending[0].ID)
+	require.NotNil(t, pending[0].Domain)
+	assert.Equal(t, []string{"api.example.com"}, pending[0].Domain.Hosts)
+
+	claimed, err := s.ClaimDueScheduledChanges(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, dueID, claimed[0].ID)
+	assert.Equal(t, region, claimed[0].Region)
+	assert.Equal(t, ScheduledApplying, claimed[0].Status)
+	assert.Equal(t, "OPS-1", claimed[0].Reason)
+
+	again, err := s.ClaimDueScheduledChanges(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, again)
+
+	// A claim whose lease ran out (the claimer died mid-apply) is taken again.
+	_, err = s.db.ExecContext(ctx, `UPDATE scheduled_changes SET claimed_at = NOW() - INTERVAL '1 hour' WHERE id = $1`, dueID)
+	require.NoError(t, err)
+	again, err = s.ClaimDueScheduledChanges(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, again, 1)
+	assert.Equal(t, dueID, again[0].ID)
+
+	require.NoError(t, s.FinishScheduledChange(ctx, dueID, ""))
+	ok, err := s.CancelScheduledChange(ctx, region, dueID)
+	require.NoError(t, err)
+	assert.False(t, ok, "only pending changes can be cancelled")
+	ok, err = s.CancelScheduledChange(ctx, region, laterID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	all, err := s.ListScheduledChanges(ctx, region, "")
+	require.NoError(t, err)
+	require.Len(t, all, 2)
+	assert.Equal(t, ScheduledApplied, all[0].Status)
+	assert.NotNil(t, all[0].FinishedAt)
+	assert.Equal(t, ScheduledCancelled, all[1].Status)
+}
+
+func TestDeleteGatewayInstance(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	require.NoError(t, s.UpsertGatewayInstances(ctx, region, []GatewayInstanceStatus{
+		{ID: "gw-1", Status: "running"},
+		{ID: "gw-2", Status: "running"},
+	}))
+
+	deleted, err := s.DeleteGatewayInstance(ctx, region, "gw-1")
+	require.NoError(t, err)
+	assert.True(t, deleted)
+
+	list, err := s.ListGatewayInstances(ctx, region)
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "gw-2", list[0].ID)
+
+	deleted, err = s.DeleteGatewayInstance(ctx, region, "gw-1")
+	require.NoError(t, err)
+	assert.False(t, deleted)
+}
+
+func TestLeadershipEvents(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	region := "default"
+	report := func(id string, leader bool) {
+		require.NoError(t, s.UpsertControllerStatus(ctx, region, &ControllerStatus{ID: id, Status: "running", IsLeader: leader}))
+	}
+
+	report("ctrl-1", true)
+	report("ctrl-1", true) // heartbeat, no transition
+	report("ctrl-2", false)
+	report("ctrl-1", false)
+	report("ctrl-2", true)
+
+	events, err := s.ListLeadershipEvents(ctx, region)
+	require.NoError(t, err)
+	require.Len(t, events, 3)
+	assert.Equal(t, "ctrl-1", events[0].ControllerID)
+	assert.True(t, events[0].IsLeader)
+	assert.Equal(t, "ctrl-1", events[1].ControllerID)
+	assert.False(t, events[1].IsLeader)
+	assert.Equal(t, "ctrl-2", events[2].ControllerID)
+	assert.True(t, events[2].IsLeader)
+
+	other, err := s.ListLeadershipEvents(ctx, "other")
+	require.NoError(t, err)
+	assert.Empty(t, other)
+}
+
 func TestControllerStatus(t *testing.T) {
 	ctx := context.Background()
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 	ctrl := &ControllerStatus{
 		ID:              "ctrl-1",
 		Status:          "running",
@@ -587,7 +2050,7 @@ func TestGrafanaDashboards(t *testing.T) {
 	s, cleanup := startPostgres(t, ctx)
 	defer cleanup()
 
-	ns := "default"
+	region := "default"
 
 	// Create
 	d1, err := s.PutGrafanaDashboard(ctx, region, &GrafanaDashboard{
@@ -615,6 +2078,305 @@ func TestGrafanaDashboards(t *testing.T) {
 	assert.Empty(t, dashboards2)
 }
 
+// OIDC Auth State Tests
+func TestOIDCAuthState(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	now := time.Now()
+	err := s.CreateOIDCAuthState(ctx, &OIDCAuthState{
+		State:        "state-1",
+		CodeVerifier: "verifier-1",
+		RedirectPath: "/clusters",
+		ExpiresAt:    now.Add(time.Minute),
+	})
+	require.NoError(t, err)
+
+	got, err := s.ConsumeOIDCAuthState(ctx, "state-1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "verifier-1", got.CodeVerifier)
+	assert.Equal(t, "/clusters", got.RedirectPath)
+
+	// Single-use
+	got, err = s.ConsumeOIDCAuthState(ctx, "state-1")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+
+	// Expired
+	err = s.CreateOIDCAuthState(ctx, &OIDCAuthState{
+		State:        "state-2",
+		CodeVerifier: "verifier-2",
+		ExpiresAt:    now.Add(-time.Minute),
+	})
+	require.NoError(t, err)
+	got, err = s.ConsumeOIDCAuthState(ctx, "state-2")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+// Password History Tests
+func TestPasswordHistory(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	sub := "builtin:alice@example.com"
+	require.NoError(t, s.UpsertUser(ctx, &User{Sub: sub, Username: "alice", Email: "alice@example.com"}))
+
+	for _, h := range []string{"h1", "h2", "h3"} {
+		require.NoError(t, s.AddPasswordHistory(ctx, sub, h, 2))
+	}
+	hashes, err := s.ListPasswordHistory(ctx, sub, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"h3", "h2"}, hashes, "pruned to newest 2, newest first")
+
+	// History goes away with the user.
+	require.NoError(t, s.DeleteUser(ctx, sub))
+	hashes, err = s.ListPasswordHistory(ctx, sub, 10)
+	require.NoError(t, err)
+	assert.Empty(t, hashes)
+}
+
+// Signing Key Tests
+func TestRotateSigningKeyIfOlder(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	// No active key yet: always due.
+	first, err := s.RotateSigningKeyIfOlder(ctx, "HS256", time.Hour, time.Hour)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	// Fresh key: not due.
+	key, err := s.RotateSigningKeyIfOlder(ctx, "HS256", time.Hour, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, key)
+
+	_, err = s.db.ExecContext(ctx, `UPDATE jwt_signing_keys SET created_at = NOW() - INTERVAL '2 hours' WHERE kid = $1`, first.KID)
+	require.NoError(t, err)
+	key, err = s.RotateSigningKeyIfOlder(ctx, "ES256", time.Hour, 30*time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, key)
+	assert.Equal(t, "ES256", key.Algorithm)
+
+	active, err := s.GetActiveSigningKey(ctx)
+	require.NoError(t, err)
+	require.NotNil(t, active)
+	assert.Equal(t, key.KID, active.KID)
+	assert.Equal(t, "ES256", active.Algorithm)
+	assert.Equal(t, key.Secret, active.Secret)
+
+	old, err := s.GetSigningKeyByID(ctx, first.KID)
+	require.NoError(t, err)
+	require.NotNil(t, old)
+	assert.Equal(t, "retired", old.Status)
+	require.NotNil(t, old.ExpiresAt)
+}
+
+// Refresh Token Tests
+func TestRefreshTokens(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	now := time.Now()
+	for _, h := range []string{"hash-1", "hash-2"} {
+		require.NoError(t, s.CreateRefreshToken(ctx, &RefreshToken{
+			TokenHash: h, FamilyID: "fam-1", UserSub: "builtin:alice@example.com",
+			CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+		}))
+	}
+
+	tok, reused, err := s.ConsumeRefreshToken(ctx, "hash-1")
+	require.NoError(t, err)
+	require.NotNil(t, tok)
+	assert.False(t, reused)
+	assert.Equal(t, "fam-1", tok.FamilyID)
+
+	// Second use is reported as reuse.
+	tok, reused, err = s.ConsumeRefreshToken(ctx, "hash-1")
+	require.NoError(t, err)
+	require.NotNil(t, tok)
+	assert.True(t, reused)
+
+	require.NoError(t, s.RevokeRefreshTokenFamily(ctx, "fam-1"))
+	tok, _, err = s.ConsumeRefreshToken(ctx, "hash-2")
+	require.NoError(t, err)
+	require.NotNil(t, tok)
+	assert.True(t, tok.Revoked)
+
+	tok, _, err = s.ConsumeRefreshToken(ctx, "missing")
+	require.NoError(t, err)
+	assert.Nil(t, tok)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	sub := "builtin:alice@example.com"
+	require.NoError(t, s.UpsertUser(ctx, &User{Sub: sub, Username: "alice", Email: "alice@example.com"}))
+	now := time.Now()
+	require.NoError(t, s.CreateRefreshToken(ctx, &RefreshToken{
+		TokenHash: "hash-1", FamilyID: "fam-1", UserSub: sub, CreatedAt: now, ExpiresAt: now.Add(time.Hour),
+	}))
+
+	u, err := s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	assert.Nil(t, u.SessionsValidAfter)
+
+	cutoff, err := s.RevokeUserSessions(ctx, sub)
+	require.NoError(t, err)
+	u, err = s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	require.NotNil(t, u.SessionsValidAfter)
+	assert.WithinDuration(t, cutoff, *u.SessionsValidAfter, time.Millisecond)
+
+	tok, _, err := s.ConsumeRefreshToken(ctx, "hash-1")
+	require.NoError(t, err)
+	require.NotNil(t, tok)
+	assert.True(t, tok.Revoked)
+
+	_, err = s.RevokeUserSessions(ctx, "nobody")
+	assert.Error(t, err)
+}
+
+func TestSetUserEnabled(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	sub := "builtin:alice@example.com"
+	require.NoError(t, s.UpsertUser(ctx, &User{Sub: sub, Username: "alice", Email: "alice@example.com"}))
+	u, err := s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	assert.True(t, u.Enabled, "new users are enabled")
+
+	require.NoError(t, s.SetUserEnabled(ctx, sub, false))
+	// Re-syncing the user (login, OIDC callback) must not re-enable it.
+	require.NoError(t, s.UpsertUser(ctx, &User{Sub: sub, Username: "alice", Email: "alice@example.com", Enabled: true}))
+	u, err = s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	assert.False(t, u.Enabled)
+
+	require.NoError(t, s.SetUserEnabled(ctx, sub, true))
+	u, err = s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	assert.True(t, u.Enabled)
+
+	assert.Error(t, s.SetUserEnabled(ctx, "nobody", false))
+}
+
+func TestIdempotencyKeys(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	const route = "POST /api/v1/domains"
+	prev, ok, err := s.ReserveIdempotencyKey(ctx, "default", route, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, prev)
+
+	// Held while in flight.
+	prev, ok, err = s.ReserveIdempotencyKey(ctx, "default", route, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, "fp", prev.Fingerprint)
+	assert.Zero(t, prev.Status)
+
+	// Same key in another region is independent.
+	_, ok, err = s.ReserveIdempotencyKey(ctx, "staging", route, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, s.CompleteIdempotencyKey(ctx, "default", route, "k1",
+		&IdempotencyRecord{Status: 201, ContentType: "application/json", Body: []byte(`{"version":1}`)}, time.Hour))
+	prev, ok, err = s.ReserveIdempotencyKey(ctx, "default", route, "k1", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, 201, prev.Status)
+	assert.JSONEq(t, `{"version":1}`, string(prev.Body))
+
+	require.NoError(t, s.ReleaseIdempotencyKey(ctx, "default", route, "k1"))
+	_, ok, err = s.ReserveIdempotencyKey(ctx, "default", route, "k1", "fp2", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Expired leases are reclaimed.
+	_, ok, err = s.ReserveIdempotencyKey(ctx, "default", route, "k2", "fp", -time.Second)
+	require.NoError(t, err)
+	require.True(t, ok)
+	_, ok, err = s.ReserveIdempotencyKey(ctx, "default", route, "k2", "fp", time.Minute)
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+// TOTP Tests
+func TestUserTOTP(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	sub := "builtin:alice@example.com"
+	require.NoError(t, s.UpsertUser(ctx, &User{Sub: sub, Username: "alice", Email: "alice@example.com"}))
+
+	secret, enabled, err := s.GetUserTOTP(ctx, sub)
+	require.NoError(t, err)
+	assert.Empty(t, secret)
+	assert.False(t, enabled)
+
+	require.NoError(t, s.SetUserTOTP(ctx, sub, "v1:sealed", true))
+	secret, enabled, err = s.GetUserTOTP(ctx, sub)
+	require.NoError(t, err)
+	assert.Equal(t, "v1:sealed", secret)
+	assert.True(t, enabled)
+
+	u, err := s.GetUser(ctx, sub)
+	require.NoError(t, err)
+	assert.True(t, u.TOTPEnabled)
+
+	assert.Error(t, s.SetUserTOTP(ctx, "builtin:nobody", "", false))
+}
+
+// Login Throttling Tests
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	s, cleanup := startPostgres(t, ctx)
+	defer cleanup()
+
+	email := "alice@example.com"
+	until, err := s.GetLoginLockout(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	for i := 1; i <= 3; i++ {
+		n, err := s.RecordLoginFailure(ctx, email, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, i, n)
+	}
+
+	lockUntil := time.Now().Add(time.Minute).Truncate(time.Microsecond)
+	require.NoError(t, s.LockLogin(ctx, email, lockUntil))
+	until, err = s.GetLoginLockout(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, until.Equal(lockUntil))
+
+	// Lock clears the counter.
+	n, err := s.RecordLoginFailure(ctx, email, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	require.NoError(t, s.ResetLoginFailures(ctx, email))
+	until, err = s.GetLoginLockout(ctx, email)
+	require.NoError(t, err)
+	assert.True(t, until.IsZero())
+}
+
 // Scope / Role Tests
 func TestValidScope(t *testing.T) {
 	assert.True(t, ValidScope(ScopeConfigRead))
@@ -629,10 +2391,17 @@ func TestRoleToScopes(t *testing.T) {
 
 	ownerScopes := RoleToScopes(RoleOwner, false)
 	assert.Contains(t, ownerScopes, ScopeConfigWrite)
+	assert.Contains(t, ownerScopes, ScopeConfigRollback)
 	assert.Contains(t, ownerScopes, ScopeMemberWrite)
 
+	editorScopes := RoleToScopes(RoleEditor, false)
+	assert.Contains(t, editorScopes, ScopeConfigWrite)
+	assert.NotContains(t, editorScopes, ScopeConfigRollback)
+
 	viewerScopes := RoleToScopes(RoleViewer, false)
 	assert.Contains(t, viewerScopes, ScopeConfigRead)
+	assert.Contains(t, viewerScopes, ScopeSecretRead)
+	assert.NotContains(t, viewerScopes, ScopeSecretWrite)
 	assert.NotContains(t, viewerScopes, ScopeConfigWrite)
 	assert.NotContains(t, viewerScopes, ScopeMemberWrite)
 
@@ -672,3 +2441,12 @@ func TestValidateRegionName(t *testing.T) {
 	// 64 chars should fail
 	assert.NotEmpty(t, ValidateRegionName(strings.Repeat("a", 64)))
 }
+
+func TestValidateRoleName(t *testing.T) {
+	assert.Empty(t, ValidateRoleName("release-manager"))
+	assert.NotEmpty(t, ValidateRoleName(""))
+	assert.NotEmpty(t, ValidateRoleName("Release"))
+	assert.NotEmpty(t, ValidateRoleName(string(RoleOwner)))
+	assert.True(t, IsBuiltinRole(RoleViewer))
+	assert.False(t, IsBuiltinRole("release-manager"))
+}
@@ -2,7 +2,9 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"regexp"
 	"time"
 
@@ -15,6 +17,25 @@ import (
 // the resource concurrently.
 var ErrConflict = errors.New("optimistic concurrency conflict: resource has been modified by another user")
 
+// ErrResourceExists is returned by PurgeHistory for a resource that has not
+// been deleted.
+var ErrResourceExists = errors.New("resource still exists")
+
+// ErrMaintenanceRunning is returned by MaintainTables while another run,
+// on this or another replica, holds the maintenance lock.
+var ErrMaintenanceRunning = errors.New("table maintenance already running")
+
+// HostConflictError is returned by PutDomain when another domain in the
+// region already claims one of the domain's hosts.
+type HostConflictError struct {
+	Host   string
+	Domain string
+}
+
+func (e *HostConflictError) Error() string {
+	return fmt.Sprintf("host %q is already claimed by domain %q", e.Host, e.Domain)
+}
+
 // DefaultRegion is used when no region is specified.
 const DefaultRegion = "default"
 
@@ -36,6 +57,51 @@ func ValidateRegionName(name string) string {
 	return ""
 }
 
+// ValidateRoleName returns an error message if name can't be used for a
+// custom role, or "" if valid. Names follow the region name rules and may not
+// shadow a builtin role.
+func ValidateRoleName(name string) string {
+	if name == "" {
+		return "role name is required"
+	}
+	if !regionRe.MatchString(name) {
+		return "role name must be at most 63 lowercase alphanumeric characters or hyphens, starting and ending with an alphanumeric character"
+	}
+	if IsBuiltinRole(RegionRole(name)) {
+		return "builtin role " + name + " cannot be redefined"
+	}
+	return ""
+}
+
+// RegionSettings holds per-region overrides of server-wide defaults. Nil
+// fields fall back to the server config.
+type RegionSettings struct {
+	// MaxDomains and MaxClusters cap how many resources the region may
+	// hold; 0 means unlimited.
+	MaxDomains  *int `json:"max_domains,omitempty"`
+	MaxClusters *int `json:"max_clusters,omitempty"`
+	// DefaultOwner and DefaultContact apply to domains that set no owner
+	// or contact of their own.
+	DefaultOwner   string `json:"default_owner,omitempty"`
+	DefaultContact string `json:"default_contact,omitempty"`
+	// DefaultRole is granted to signed-in users who have no membership or
+	// group binding in the region. Empty (default) grants nothing.
+	DefaultRole RegionRole `json:"default_role,omitempty"`
+	// RequireChangeReason rejects config writes that carry no
+	// X-Hermes-Change-Reason header.
+	RequireChangeReason bool `json:"require_change_reason,omitempty"`
+}
+
+// FanoutTarget is one etcd cluster a region's config is synced to, for
+// regions whose config is served from several physical locations. Empty
+// prefixes mean the controller's own defaults.
+type FanoutTarget struct {
+	Name          string   `json:"name"`
+	Endpoints     []string `json:"endpoints"`
+	DomainPrefix  string   `json:"domain_prefix,omitempty"`
+	ClusterPrefix string   `json:"cluster_prefix,omitempty"`
+}
+
 // HistoryEntry records a single version of one domain or cluster.
 type HistoryEntry struct {
 	Version   int64                `json:"version"`
@@ -44,30 +110,157 @@ type HistoryEntry struct {
 	Name      string               `json:"name"`
 	Action    string               `json:"action"` // "create", "update", "delete", "rollback", "import"
 	Operator  string               `json:"operator,omitempty"`
+	Reason    string               `json:"reason,omitempty"`
 	Domain    *model.DomainConfig  `json:"domain,omitempty"`
 	Cluster   *model.ClusterConfig `json:"cluster,omitempty"`
 }
 
+// HistoryFilter narrows ListHistory. Zero fields match everything; Since
+// is inclusive and Until exclusive.
+type HistoryFilter struct {
+	Kind     string
+	Name     string
+	Operator string
+	Since    time.Time
+	Until    time.Time
+}
+
+// Scheduled change states.
+const (
+	ScheduledPending   = "pending"
+	ScheduledApplying  = "applying"
+	ScheduledApplied   = "applied"
+	ScheduledFailed    = "failed"
+	ScheduledCancelled = "cancelled"
+)
+
+// MaxListLimit is the largest page ListAuditLog and ListHistory return; a
+// larger or missing limit gets the default page of 50.
+const MaxListLimit = 200
+
+// ScheduledChange is a domain or cluster write deferred until ApplyAt.
+// Action is "put" (create or replace) or "delete"; for "put" exactly one of
+// Domain or Cluster is set, matching Kind.
+type ScheduledChange struct {
+	ID        int64                `json:"id"`
+	Region    string               `json:"region"`
+	Kind      string               `json:"kind"` // "domain" or "cluster"
+	Name      string               `json:"name"`
+	Action    string               `json:"action"`
+	Domain    *model.DomainConfig  `json:"domain,omitempty"`
+	Cluster   *model.ClusterConfig `json:"cluster,omitempty"`
+	ApplyAt   time.Time            `json:"apply_at"`
+	Status    string               `json:"status"`
+	Error     string               `json:"error,omitempty"`
+	CreatedBy string               `json:"created_by,omitempty"`
+	// Reason is the change reason given at submit time; it is recorded
+	// with the change when it is applied.
+	Reason     string     `json:"reason,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// ConfigCanary is a full region config served to Percent of gateways while
+// the rest stay on the stable config. Which gateways get it is decided by a
+// stable hash of their instance ID.
+//
+// BaseRevision is the stable config's ConfigRevision when the canary was
+// started or last replaced. Promotion fails if the stable config has moved
+// on since, so the canary cannot silently undo those writes.
+type ConfigCanary struct {
+	Region       string              `json:"region"`
+	Percent      int                 `json:"percent"`
+	Config       model.GatewayConfig `json:"config"`
+	BaseRevision int64               `json:"base_revision"`
+	CreatedBy    string              `json:"created_by,omitempty"`
+	CreatedAt    time.Time           `json:"created_at"`
+}
+
 // Store is the interface that both handlers and the watch API depend on.
 // All data methods are region-scoped.
 type Store interface {
 	Close()
+	// Ping checks that the database the store writes to is reachable.
+	Ping(ctx context.Context) error
 
 	// Domain CRUD
 	ListDomains(ctx context.Context, region string) ([]model.DomainConfig, error)
 	GetDomain(ctx context.Context, region, name string) (*model.DomainConfig, int64, error) // returns (config, resourceVersion, err)
+	// GetDomainWithMeta is GetDomain plus the row's last-modified time and
+	// operator, read in the same query. Returns nil meta if the domain does
+	// not exist.
+	GetDomainWithMeta(ctx context.Context, region, name string) (*model.DomainConfig, *ResourceMeta, error)
+	// ListDomainsWithMeta is ListDomains, or ListDomainsByLabels for a
+	// non-nil selector, with each domain's metadata read in the same query;
+	// meta[i] belongs to domains[i].
+	ListDomainsWithMeta(ctx context.Context, region string, selector map[string]string) (domains []model.DomainConfig, meta []ResourceMeta, err error)
+	// FindDomainsByHost returns every domain in region whose hosts list
+	// contains any of hosts exactly.
+	FindDomainsByHost(ctx context.Context, region string, hosts ...string) ([]model.DomainConfig, error)
+	// FindDomainsByCluster returns every domain in region with a route that
+	// sends traffic to cluster.
+	FindDomainsByCluster(ctx context.Context, region, cluster string) ([]model.DomainConfig, error)
+	// Search matches q case-insensitively against domain names, domain
+	// hosts and cluster names in region. Exact matches rank first, then
+	// prefixes, then substrings; a resource matching in several fields is
+	// returned once, for its best match. At most limit results are returned.
+	Search(ctx context.Context, region, q string, limit int) ([]SearchResult, error)
+	// ListDomainsByLabels returns the domains carrying every label in
+	// selector.
+	ListDomainsByLabels(ctx context.Context, region string, selector map[string]string) ([]model.DomainConfig, error)
 	PutDomain(ctx context.Context, region string, domain *model.DomainConfig, action, operator string, expectedVersion int64) (int64, error)
 	DeleteDomain(ctx context.Context, region, name, operator string) (int64, error)
+	// DeleteDomains deletes the named domains in one transaction, with a
+	// history entry and change_log delete event for each. Names that do not
+	// exist are reported, not treated as errors. Results follow names' order.
+	DeleteDomains(ctx context.Context, region string, names []string, operator string) ([]BulkDeleteResult, error)
 
 	// Cluster CRUD
 	ListClusters(ctx context.Context, region string) ([]model.ClusterConfig, error)
 	GetCluster(ctx context.Context, region, name string) (*model.ClusterConfig, int64, error) // returns (config, resourceVersion, err)
+	GetClusterWithMeta(ctx context.Context, region, name string) (*model.ClusterConfig, *ResourceMeta, error)
+	// ListClustersWithMeta is ListDomainsWithMeta for clusters.
+	ListClustersWithMeta(ctx context.Context, region string, selector map[string]string) (clusters []model.ClusterConfig, meta []ResourceMeta, err error)
+	ListClustersByLabels(ctx context.Context, region string, selector map[string]string) ([]model.ClusterConfig, error)
 	PutCluster(ctx context.Context, region string, cluster *model.ClusterConfig, action, operator string, expectedVersion int64) (int64, error)
 	DeleteCluster(ctx context.Context, region, name, operator string) (int64, error)
+	// DeleteClusters is DeleteDomains for clusters.
+	DeleteClusters(ctx context.Context, region string, names []string, operator string) ([]BulkDeleteResult, error)
+	// DeleteOrphanClusters deletes the clusters no domain route references,
+	// deciding which in the same transaction as the delete, and returns
+	// their names.
+	DeleteOrphanClusters(ctx context.Context, region, operator string) ([]string, error)
 
 	// Bulk
-	PutAllConfig(ctx context.Context, region string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string) (int64, error)
+	// PutAllConfig replaces the region's config and returns the new config
+	// revision. expectedRevision is compared against ConfigRevision; a
+	// mismatch returns ErrConflict. -1 skips the check.
+	PutAllConfig(ctx context.Context, region string, domains []model.DomainConfig, clusters []model.ClusterConfig, operator string, expectedRevision int64) (int64, error)
+	// ApplyBatch applies ops in order in one transaction: either every op is
+	// recorded, as the matching PutDomain/DeleteDomain call would record it,
+	// or none is. The error for a failed op names its index and wraps the
+	// cause, so errors.Is(err, ErrConflict) still reports an OCC mismatch.
+	ApplyBatch(ctx context.Context, region string, ops []BatchOp, operator string) ([]BatchResult, error)
 	GetConfig(ctx context.Context, region string) (*model.GatewayConfig, error)
+	// GetConfigByLabels is GetConfig restricted to the domains and clusters
+	// matching a label selector.
+	GetConfigByLabels(ctx context.Context, region string, selector map[string]string) (*model.GatewayConfig, error)
+	// StreamConfig calls fn with each of the region's domains ("domain"),
+	// then each of its clusters ("cluster"), in name order and as stored.
+	// Rows come from one snapshot and are never all held in memory, so config
+	// is only valid during the call. An error from fn stops the stream and
+	// is returned.
+	StreamConfig(ctx context.Context, region string, fn func(kind string, config json.RawMessage) error) error
+	// ConfigRevision is the change_log revision of the region's latest domain
+	// or cluster change (0 if none). Audit-only events do not advance it.
+	ConfigRevision(ctx context.Context, region string) (int64, error)
+	// ConfigAtRevision reconstructs the region's config as it stood at a
+	// change_log revision, from each resource's latest event at or before
+	// it (archived events included). A full import counts as a snapshot, so
+	// resources it dropped are left out even in history written before
+	// imports recorded delete events. An import that left the region empty
+	// recorded no events at all then, and cannot be seen.
+	ConfigAtRevision(ctx context.Context, region string, revision int64) (*model.GatewayConfig, error)
 
 	// Per-domain History
 	GetDomainHistory(ctx context.Context, region, name string) ([]HistoryEntry, error)
@@ -79,23 +272,136 @@ type Store interface {
 	GetClusterVersion(ctx context.Context, region, name string, version int64) (*HistoryEntry, error)
 	RollbackCluster(ctx context.Context, region, name string, version int64, operator string) (int64, error)
 
+	// PurgeHistory removes every config_history, change_log and
+	// change_log_archive row of a deleted domain or cluster in one
+	// transaction, returning how many rows went. A purge is recorded as a
+	// "history"/"purge" change event without the purged content, followed
+	// by a sync event so controllers that had not yet seen the delete
+	// reconcile. Fails with ErrResourceExists if the resource still exists.
+	PurgeHistory(ctx context.Context, region, kind, name, operator string) (int64, error)
+
+	// ListHistory pages through the region's config history across all
+	// domains and clusters, newest first, with each entry's snapshot.
+	ListHistory(ctx context.Context, region string, filter HistoryFilter, limit, offset int) ([]HistoryEntry, int64, error)
+
 	// Audit log (global change event stream)
-	ListAuditLog(ctx context.Context, region string, limit, offset int) ([]AuditEntry, int64, error)
+	// ListAuditLog pages through the region's change events, newest first.
+	// A non-zero since restricts results to events at or after it and also
+	// searches change_log_archive, so archived history stays queryable.
+	// A non-zero before keeps only events with a lower revision, for cursor
+	// paging; total still counts every event.
+	ListAuditLog(ctx context.Context, region string, since time.Time, before int64, limit, offset int) ([]AuditEntry, int64, error)
 	InsertAuditLog(ctx context.Context, region, kind, name, action, operator string) error
 
+	// ArchiveChangeLog moves change_log rows older than olderThan into
+	// change_log_archive in batches of batchSize. The newest row of each region
+	// is always kept so CurrentRevision never goes backwards. Only one replica
+	// archives at a time; the others return 0 immediately.
+	ArchiveChangeLog(ctx context.Context, olderThan time.Duration, batchSize int) (int64, error)
+	// OldestRetainedChange returns the revision and time of the region's
+	// oldest change event still on record, live or archived; zero values
+	// when it has none. History before it is not queryable.
+	OldestRetainedChange(ctx context.Context, region string) (int64, time.Time, error)
+
+	// MaintainTables runs ANALYZE on the config tables and, with reindex,
+	// rebuilds their indexes with REINDEX CONCURRENTLY. Neither blocks
+	// reads or writes. Only one run at a time across replicas; others get
+	// ErrMaintenanceRunning.
+	MaintainTables(ctx context.Context, reindex bool) ([]TableMaintenance, error)
+
 	// Watch (for controller long-poll)
 	CurrentRevision(ctx context.Context, region string) (int64, error)
-	WatchFrom(ctx context.Context, region string, sinceRevision int64) ([]ChangeEvent, int64, error)
+	// WatchFrom returns up to 100 change events after sinceRevision and the
+	// highest revision among them. Non-empty kinds keeps only events of those
+	// kinds, plus sync requests, out of the next 100; the revision returned
+	// is still the highest of those scanned, so the caller moves past the
+	// events filtered out.
+	WatchFrom(ctx context.Context, region string, sinceRevision int64, kinds []string) ([]ChangeEvent, int64, error)
+	// ConfigChangesSince summarizes the domain and cluster changes after
+	// sinceRevision: one entry per resource, classified against its state at
+	// sinceRevision, carrying the current config unless it was deleted.
+	// Resources created and deleted within the window are left out. The
+	// state at sinceRevision comes from the change_log and its archive
+	// alone: if older events were pruned from the archive by hand, a
+	// resource whose events before the window are gone reads as created.
+	ConfigChangesSince(ctx context.Context, region string, sinceRevision int64) ([]ConfigChange, error)
+	// TriggerSync appends a "sync" event to the region's change stream so
+	// watching controllers run a full reconcile right away. Returns the
+	// event's revision.
+	TriggerSync(ctx context.Context, region, operator string) (int64, error)
+
+	// Scheduled changes
+	CreateScheduledChange(ctx context.Context, region string, c *ScheduledChange) (int64, error)
+	// ListScheduledChanges returns the region's scheduled changes ordered by
+	// apply time; status filters by state unless empty.
+	ListScheduledChanges(ctx context.Context, region, status string) ([]ScheduledChange, error)
+	// CancelScheduledChange cancels a pending change. Returns false if no
+	// pending change with that id exists in the region.
+	CancelScheduledChange(ctx context.Context, region string, id int64) (bool, error)
+	// ClaimDueScheduledChanges marks up to limit due pending changes across
+	// all regions as applying and returns them. Only one replica claims at a
+	// time; the others get nothing. A change left applying past its lease
+	// (its claimer died before finishing it) is claimed again.
+	ClaimDueScheduledChanges(ctx context.Context, limit int) ([]ScheduledChange, error)
+	// FinishScheduledChange records the outcome of applying a claimed
+	// change: applied if applyErr is empty, failed otherwise.
+	FinishScheduledChange(ctx context.Context, id int64, applyErr string) error
+
+	// Canary config
+	// GetConfigCanary returns the region's canary, or nil if none is running.
+	GetConfigCanary(ctx context.Context, region string) (*ConfigCanary, error)
+	// PutConfigCanary starts a canary or replaces the running one.
+	PutConfigCanary(ctx context.Context, region string, c *ConfigCanary) error
+	// DeleteConfigCanary ends the region's canary. Returns false if none was
+	// running.
+	DeleteConfigCanary(ctx context.Context, region string) (bool, error)
+	// PromoteConfigCanary makes the canary c the stable config, as a full
+	// import, and ends it, in one transaction. It returns ErrConflict if the
+	// stable config changed since c.BaseRevision, or if c is no longer the
+	// running canary (it was aborted or replaced). Returns the new revision.
+	PromoteConfigCanary(ctx context.Context, region string, c *ConfigCanary, operator string) (int64, error)
 
 	// Regions
 	ListRegions(ctx context.Context) ([]string, error)
 	CreateRegion(ctx context.Context, name string) error
+	// GetRegionSettings returns the region's settings, or nil if the region does not exist.
+	GetRegionSettings(ctx context.Context, region string) (*RegionSettings, error)
+	// PutRegionSettings replaces the region's settings. Returns an error if the region does not exist.
+	PutRegionSettings(ctx context.Context, region string, settings *RegionSettings) error
+	// IsRegionFrozen reports whether config writes to the region are frozen.
+	// A region that does not exist is not frozen.
+	IsRegionFrozen(ctx context.Context, region string) (bool, error)
+	// SetRegionFrozen sets the region's freeze flag. Returns false if the
+	// region does not exist.
+	SetRegionFrozen(ctx context.Context, region string, frozen bool) (bool, error)
+	// GetRegionTargets returns the region's fan-out targets; found is false
+	// if the region does not exist.
+	GetRegionTargets(ctx context.Context, region string) (targets []FanoutTarget, found bool, err error)
+	// SetRegionTargets replaces the region's fan-out targets. Returns false
+	// if the region does not exist.
+	SetRegionTargets(ctx context.Context, region string, targets []FanoutTarget) (bool, error)
+	// CountDomains and CountClusters return how many resources the region holds.
+	CountDomains(ctx context.Context, region string) (int, error)
+	CountClusters(ctx context.Context, region string) (int, error)
 
 	// Status (region-scoped)
 	UpsertGatewayInstances(ctx context.Context, region string, instances []GatewayInstanceStatus) error
 	ListGatewayInstances(ctx context.Context, region string) ([]GatewayInstanceStatus, error)
+	// DeleteGatewayInstance removes an instance that shut down cleanly.
+	// Returns false if it was not registered.
+	DeleteGatewayInstance(ctx context.Context, region, id string) (bool, error)
 	UpsertControllerStatus(ctx context.Context, region string, ctrl *ControllerStatus) error
 	GetControllerStatus(ctx context.Context, region string) (*ControllerStatus, error)
+	// ListInstanceRevisionHistory returns the config revisions an instance
+	// has reported, oldest first. History outlives the instance row and is
+	// capped per instance and by age.
+	ListInstanceRevisionHistory(ctx context.Context, region, id string) ([]InstanceRevisionEvent, error)
+	// ListLeadershipEvents returns the region's controller leadership
+	// transitions, oldest first. Capped per region and by age.
+	ListLeadershipEvents(ctx context.Context, region string) ([]LeadershipEvent, error)
+	// ListRegionStatus summarizes every region's status in one round of
+	// grouped queries, ordered by region name.
+	ListRegionStatus(ctx context.Context) ([]RegionStatusSummary, error)
 
 	// Stale instance/controller reaper
 	// MarkStaleInstances marks gateway instances as "offline" if their updated_at
@@ -117,6 +423,22 @@ type Store interface {
 	CreateAPICredential(ctx context.Context, region string, cred *APICredential) (*APICredential, error)
 	UpdateAPICredential(ctx context.Context, region string, cred *APICredential) error
 	DeleteAPICredential(ctx context.Context, region string, id int64) error
+	// SetAPICredentialsEnabled enables or disables the region's credentials
+	// matching filter in one transaction, with an audit entry for each, and
+	// returns those it changed. Credentials already in that state are left
+	// alone.
+	SetAPICredentialsEnabled(ctx context.Context, region string, filter CredentialFilter, enabled bool, operator string) ([]APICredential, error)
+	// SealAPICredentialSecrets replaces every secret key not yet sealed with
+	// seal(key), in one transaction, and returns how many it sealed.
+	SealAPICredentialSecrets(ctx context.Context, seal func(secretKey string) (string, error)) (int, error)
+
+	// Service accounts (region-scoped bearer-token identities)
+	ListServiceAccounts(ctx context.Context, region string) ([]ServiceAccount, error)
+	// GetServiceAccountByTokenHash looks up an account globally by the SHA-256
+	// of its token (for Bearer auth). Returns nil if not found.
+	GetServiceAccountByTokenHash(ctx context.Context, tokenHash string) (*ServiceAccount, error)
+	CreateServiceAccount(ctx context.Context, region string, sa *ServiceAccount, tokenHash string) (*ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, region string, id int64) error
 
 	// Users (OIDC-synced or builtin)
 	UpsertUser(ctx context.Context, user *User) error // INSERT sets is_admin; UPDATE preserves existing
@@ -129,6 +451,27 @@ type Store interface {
 	UpdateUserPassword(ctx context.Context, sub, passwordHash string) error
 	// SetMustChangePassword sets or clears the must_change_password flag for a user.
 	SetMustChangePassword(ctx context.Context, sub string, must bool) error
+	// SetUserEnabled enables or disables a user's account. Disabled users
+	// keep their row and bindings but cannot authenticate.
+	SetUserEnabled(ctx context.Context, sub string, enabled bool) error
+	// RevokeUserSessions sets the user's sessions_valid_after cutoff to now
+	// and revokes their refresh tokens. Returns the cutoff, or an error if
+	// the user does not exist.
+	RevokeUserSessions(ctx context.Context, sub string) (time.Time, error)
+	// GetUserTOTP returns the user's sealed TOTP secret and whether TOTP is
+	// enabled. An enrolled-but-unverified user has a secret with enabled=false.
+	GetUserTOTP(ctx context.Context, sub string) (sealedSecret string, enabled bool, err error)
+	// SetUserTOTP stores the sealed TOTP secret and enabled flag. An empty
+	// secret with enabled=false removes TOTP from the account.
+	SetUserTOTP(ctx context.Context, sub, sealedSecret string, enabled bool) error
+	// ClaimTOTPStep records step as the user's last accepted TOTP time step.
+	// It returns false, without recording, when a code for this step or a
+	// later one was already accepted, so each code works only once.
+	ClaimTOTPStep(ctx context.Context, sub string, step int64) (bool, error)
+	// ListPasswordHistory returns up to limit previous bcrypt hashes for a user, newest first.
+	ListPasswordHistory(ctx context.Context, sub string, limit int) ([]string, error)
+	// AddPasswordHistory records a hash and prunes the user's history to the newest keep entries.
+	AddPasswordHistory(ctx context.Context, sub, passwordHash string, keep int) error
 	// DeleteUser removes a user by sub. Returns error if not found.
 	DeleteUser(ctx context.Context, sub string) error
 
@@ -144,9 +487,53 @@ type Store interface {
 	// CreateSigningKey inserts a new signing key. If an active key exists,
 	// it is retired with the given grace period.
 	CreateSigningKey(ctx context.Context, key *JWTSigningKey) error
-	// RotateSigningKey creates a new active key and retires the old one.
+	// RotateSigningKey creates a new active key for alg and retires the old one.
 	// The old key remains valid for gracePeriod (so in-flight tokens don't break).
-	RotateSigningKey(ctx context.Context, gracePeriod time.Duration) (*JWTSigningKey, error)
+	RotateSigningKey(ctx context.Context, alg string, gracePeriod time.Duration) (*JWTSigningKey, error)
+	// RotateSigningKeyIfOlder rotates like RotateSigningKey, but only when the
+	// active key is older than maxAge and no other replica is rotating at the
+	// same time. Returns nil when nothing was rotated.
+	RotateSigningKeyIfOlder(ctx context.Context, alg string, maxAge, gracePeriod time.Duration) (*JWTSigningKey, error)
+
+	// OIDC login state (authorization code flow)
+	// CreateOIDCAuthState persists a pending login (state → PKCE verifier).
+	CreateOIDCAuthState(ctx context.Context, st *OIDCAuthState) error
+	// ConsumeOIDCAuthState deletes and returns the pending login for state.
+	// Returns nil if it does not exist or has expired. Single-use by design.
+	ConsumeOIDCAuthState(ctx context.Context, state string) (*OIDCAuthState, error)
+
+	// Builtin refresh tokens (rotated on every use)
+	// CreateRefreshToken stores a new refresh token (by hash).
+	CreateRefreshToken(ctx context.Context, tok *RefreshToken) error
+	// ConsumeRefreshToken marks the token used and returns it. If the token was
+	// already used, it is returned with reused=true (possible theft). Returns
+	// nil if no such token exists.
+	ConsumeRefreshToken(ctx context.Context, tokenHash string) (tok *RefreshToken, reused bool, err error)
+	// RevokeRefreshTokenFamily revokes every token descended from the same login.
+	RevokeRefreshTokenFamily(ctx context.Context, familyID string) error
+
+	// Builtin login throttling (keyed by normalized email)
+	// GetLoginLockout returns when the email's lockout ends (zero if not locked).
+	GetLoginLockout(ctx context.Context, email string) (time.Time, error)
+	// RecordLoginFailure counts a failed login and returns the number of
+	// failures within the current window (a new window starts once it elapses).
+	RecordLoginFailure(ctx context.Context, email string, window time.Duration) (int, error)
+	// LockLogin locks the email until the given time and clears its failure count.
+	LockLogin(ctx context.Context, email string, until time.Time) error
+	// ResetLoginFailures clears failure and lockout state after a successful login.
+	ResetLoginFailures(ctx context.Context, email string) error
+
+	// Idempotency keys (keyed by key + region + route)
+	// ReserveIdempotencyKey claims key for a request whose body hashes to
+	// fingerprint, holding it for lease. If the key is already held it
+	// returns the existing record and false; a record with Status 0 is
+	// still in flight. Expired keys are claimed afresh.
+	ReserveIdempotencyKey(ctx context.Context, region, route, key, fingerprint string, lease time.Duration) (*IdempotencyRecord, bool, error)
+	// CompleteIdempotencyKey stores the response for a reserved key and
+	// keeps it for ttl.
+	CompleteIdempotencyKey(ctx context.Context, region, route, key string, rec *IdempotencyRecord, ttl time.Duration) error
+	// ReleaseIdempotencyKey drops a reservation so the request can be retried.
+	ReleaseIdempotencyKey(ctx context.Context, region, route, key string) error
 
 	// Region Members
 	ListRegionMembers(ctx context.Context, region string) ([]RegionMember, error)
@@ -160,12 +547,63 @@ type Store interface {
 	RemoveGroupBinding(ctx context.Context, region, group string) error
 	// GetEffectiveRoleByGroups returns the highest-privilege role granted to any of the given groups in a region.
 	GetEffectiveRoleByGroups(ctx context.Context, region string, groups []string) (*RegionRole, error)
+	// GetRolesByGroups returns every role (builtin or custom) bound to any of the given groups in a region.
+	GetRolesByGroups(ctx context.Context, region string, groups []string) ([]RegionRole, error)
+
+	// Custom roles (region-scoped named scope sets)
+	ListCustomRoles(ctx context.Context, region string) ([]CustomRole, error)
+	// GetCustomRole returns nil if the role does not exist.
+	GetCustomRole(ctx context.Context, region, name string) (*CustomRole, error)
+	PutCustomRole(ctx context.Context, role *CustomRole) error
+	// DeleteCustomRole returns ErrConflict if members or group bindings still use the role.
+	DeleteCustomRole(ctx context.Context, region, name string) error
+
+	// Secrets (region-scoped sealed values referenced by name from config)
+	// ListSecrets returns secret metadata; SealedValue is left empty.
+	ListSecrets(ctx context.Context, region string) ([]Secret, error)
+	// GetSecret returns the secret including its sealed value, or nil if it does not exist.
+	GetSecret(ctx context.Context, region, name string) (*Secret, error)
+	PutSecret(ctx context.Context, secret *Secret) error
+	// DeleteSecret returns ErrConflict if a domain still references the secret.
+	DeleteSecret(ctx context.Context, region, name string) error
+
+	// Maintenance mode (global)
+	// GetMaintenance returns the current maintenance state; the zero value
+	// (disabled) if it has never been set.
+	GetMaintenance(ctx context.Context) (*Maintenance, error)
+	SetMaintenance(ctx context.Context, m *Maintenance) error
+}
+
+// ResourceMeta describes the stored revision of a domain or cluster.
+// ResourceVersion restarts at 1 when a resource is deleted and re-created;
+// UpdatedAt tells those incarnations apart. LastOperator is who wrote the
+// latest history entry; empty if history has none.
+type ResourceMeta struct {
+	ResourceVersion int64
+	UpdatedAt       time.Time
+	LastOperator    string
+}
+
+// Maintenance is the cluster-wide maintenance switch. While enabled, the API
+// keeps serving reads but refuses writes.
+type Maintenance struct {
+	Enabled   bool      `json:"enabled"`
+	Message   string    `json:"message,omitempty"`
+	UpdatedBy string    `json:"updated_by,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// TableMaintenance reports what MaintainTables did to one table.
+type TableMaintenance struct {
+	Table      string `json:"table"`
+	Reindexed  bool   `json:"reindexed"`
+	DurationMS int64  `json:"duration_ms"`
 }
 
 // ChangeEvent represents a single config change for the watch API.
 type ChangeEvent struct {
 	Revision int64                `json:"revision"`
-	Kind     string               `json:"kind"` // "domain" or "cluster"
+	Kind     string               `json:"kind"` // "domain", "cluster" or "sync" (reconcile now)
 	Name     string               `json:"name"`
 	Action   string               `json:"action"` // "create", "update", "delete", "rollback", "import"
 	Operator string               `json:"operator,omitempty"`
@@ -173,6 +611,24 @@ type ChangeEvent struct {
 	Cluster  *model.ClusterConfig `json:"cluster,omitempty"`
 }
 
+// ConfigChange values for ConfigChange.Change.
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+	ChangeDeleted = "deleted"
+)
+
+// ConfigChange is the net change of one resource since a revision.
+type ConfigChange struct {
+	Kind     string               `json:"kind"`
+	Name     string               `json:"name"`
+	Change   string               `json:"change"`   // "created", "updated" or "deleted"
+	Revision int64                `json:"revision"` // latest change_log revision of the resource
+	Operator string               `json:"operator,omitempty"`
+	Domain   *model.DomainConfig  `json:"domain,omitempty"`
+	Cluster  *model.ClusterConfig `json:"cluster,omitempty"`
+}
+
 // AuditEntry represents a global change event for audit purposes.
 type AuditEntry struct {
 	Revision  int64     `json:"revision"`
@@ -180,6 +636,7 @@ type AuditEntry struct {
 	Name      string    `json:"name"`
 	Action    string    `json:"action"`
 	Operator  string    `json:"operator,omitempty"`
+	Reason    string    `json:"reason,omitempty"`
 	Timestamp time.Time `json:"timestamp"`
 }
 
@@ -207,6 +664,33 @@ type ControllerStatus struct {
 	UpdatedAt       time.Time `json:"updated_at"`
 }
 
+// LeadershipEvent records a controller gaining or losing leadership.
+type LeadershipEvent struct {
+	ControllerID   string    `json:"controller_id"`
+	IsLeader       bool      `json:"is_leader"`
+	ConfigRevision int64     `json:"config_revision"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// InstanceRevisionEvent records when a gateway instance was first seen at a
+// config revision.
+type InstanceRevisionEvent struct {
+	ConfigRevision int64     `json:"config_revision"`
+	ObservedAt     time.Time `json:"observed_at"`
+}
+
+// RegionStatusSummary is one region's row in the cross-region status view.
+// An instance is in sync once it is not offline and has applied the region's
+// latest config revision.
+type RegionStatusSummary struct {
+	Region          string            `json:"region"`
+	ConfigRevision  int64             `json:"config_revision"`
+	Instances       int               `json:"instances"`
+	InstancesOnline int               `json:"instances_online"`
+	InstancesInSync int               `json:"instances_in_sync"`
+	Controller      *ControllerStatus `json:"controller,omitempty"`
+}
+
 // StaleEntry identifies a component that was marked offline by the reaper.
 type StaleEntry struct {
 	Region string `json:"region"`
@@ -227,6 +711,7 @@ type GrafanaDashboard struct {
 const (
 	ScopeConfigRead      = "config:read"
 	ScopeConfigWrite     = "config:write"
+	ScopeConfigRollback  = "config:rollback" // rollback and bulk config import (in addition to config:write)
 	ScopeConfigWatch     = "config:watch"
 	ScopeStatusRead      = "status:read"
 	ScopeStatusWrite     = "status:write"
@@ -238,17 +723,20 @@ const (
 	ScopeAdminUsers      = "admin:users"
 	ScopeRegionRead      = "region:read"
 	ScopeRegionWrite     = "region:write"
+	ScopeSecretRead      = "secret:read"  // secret metadata only; values are never returned
+	ScopeSecretWrite     = "secret:write" // create, replace and delete secrets
 )
 
 // AllScopes is the complete list of valid scopes.
 var AllScopes = []string{
-	ScopeConfigRead, ScopeConfigWrite, ScopeConfigWatch,
+	ScopeConfigRead, ScopeConfigWrite, ScopeConfigRollback, ScopeConfigWatch,
 	ScopeStatusRead, ScopeStatusWrite,
 	ScopeCredentialRead, ScopeCredentialWrite,
 	ScopeMemberRead, ScopeMemberWrite,
 	ScopeAuditRead,
 	ScopeAdminUsers,
 	ScopeRegionRead, ScopeRegionWrite,
+	ScopeSecretRead, ScopeSecretWrite,
 }
 
 // RoleToScopes maps an OIDC user's region role to the equivalent scope set.
@@ -259,12 +747,13 @@ func RoleToScopes(role RegionRole, isAdmin bool) []string {
 	switch role {
 	case RoleOwner:
 		return []string{
-			ScopeConfigRead, ScopeConfigWrite, ScopeConfigWatch,
+			ScopeConfigRead, ScopeConfigWrite, ScopeConfigRollback, ScopeConfigWatch,
 			ScopeStatusRead, ScopeStatusWrite,
 			ScopeCredentialRead, ScopeCredentialWrite,
 			ScopeMemberRead, ScopeMemberWrite,
 			ScopeAuditRead,
 			ScopeRegionRead, ScopeRegionWrite,
+			ScopeSecretRead, ScopeSecretWrite,
 		}
 	case RoleEditor:
 		return []string{
@@ -274,6 +763,7 @@ func RoleToScopes(role RegionRole, isAdmin bool) []string {
 			ScopeMemberRead, ScopeMemberWrite,
 			ScopeAuditRead,
 			ScopeRegionRead,
+			ScopeSecretRead, ScopeSecretWrite,
 		}
 	case RoleViewer:
 		return []string{
@@ -283,6 +773,7 @@ func RoleToScopes(role RegionRole, isAdmin bool) []string {
 			ScopeMemberRead,
 			ScopeAuditRead,
 			ScopeRegionRead,
+			ScopeSecretRead,
 		}
 	default:
 		return nil
@@ -323,17 +814,133 @@ func (c *APICredential) HasScope(scope string) bool {
 	return false
 }
 
+// CredentialFilter selects credentials for a bulk enable or disable. Set
+// fields must all match.
+type CredentialFilter struct {
+	// Scope matches credentials that hold this scope.
+	Scope string `json:"scope,omitempty"`
+	// Description is a regular expression matched against the description.
+	Description string `json:"description,omitempty"`
+}
+
+// Matcher compiles the filter into a predicate.
+func (f CredentialFilter) Matcher() (func(*APICredential) bool, error) {
+	var re *regexp.Regexp
+	if f.Description != "" {
+		var err error
+		if re, err = regexp.Compile(f.Description); err != nil {
+			return nil, fmt.Errorf("description: %w", err)
+		}
+	}
+	return func(c *APICredential) bool {
+		return (f.Scope == "" || c.HasScope(f.Scope)) && (re == nil || re.MatchString(c.Description))
+	}, nil
+}
+
+// ServiceAccount is a non-human identity that authenticates with a long-lived
+// opaque bearer token. Like APICredential it is region-scoped and carries an
+// explicit scope set; only the token's SHA-256 is stored.
+type ServiceAccount struct {
+	ID          int64     `json:"id"`
+	Region      string    `json:"region,omitempty"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Scopes      []string  `json:"scopes"`
+	TokenPrefix string    `json:"token_prefix"`    // first characters of the token, for identification
+	Token       string    `json:"token,omitempty"` // only returned on create
+	CreatedAt   time.Time `json:"created_at"`
+}
+
 // JWT Signing Keys (builtin auth)
-// JWTSigningKey represents a persistent HMAC-SHA256 signing key for builtin auth.
+// JWTSigningKey represents a persistent signing key for builtin auth.
 // Keys have a lifecycle: active → retired → expired (deleted by reaper).
 type JWTSigningKey struct {
 	KID       string     `json:"kid"`    // unique key identifier, included in JWT header
-	Secret    []byte     `json:"-"`      // raw 256-bit HMAC key (never serialized to JSON)
+	Algorithm string     `json:"alg"`    // "HS256", "RS256" or "ES256"
+	Secret    []byte     `json:"-"`      // HS256: raw 256-bit HMAC key; RS256/ES256: PKCS#8 DER private key (never serialized to JSON)
 	Status    string     `json:"status"` // "active" or "retired"
 	CreatedAt time.Time  `json:"created_at"`
 	ExpiresAt *time.Time `json:"expires_at,omitempty"` // nil for active key; set when retired
 }
 
+// OIDC login state
+// OIDCAuthState is a pending OIDC authorization request created by Login and
+// consumed by Callback. Persisted in PostgreSQL so that any replica can
+// complete a login started on another one.
+type OIDCAuthState struct {
+	State        string    `json:"state"`
+	CodeVerifier string    `json:"-"`             // PKCE code_verifier (never serialized to JSON)
+	RedirectPath string    `json:"redirect_path"` // SPA path to return to after login
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// SearchResult is one hit of a Search.
+type SearchResult struct {
+	Type string `json:"type"` // "domain" or "cluster"
+	Name string `json:"name"`
+	// MatchField is the field that matched: "name" or "host".
+	MatchField string `json:"match_field"`
+	// Match is the matched value, e.g. the host.
+	Match string `json:"match"`
+}
+
+// BulkDeleteResult is the outcome for one name in a bulk delete.
+type BulkDeleteResult struct {
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+	// Version is the history version recorded for the delete.
+	Version int64  `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// BatchOp is one write in an ApplyBatch.
+type BatchOp struct {
+	// Op is "put" or "delete".
+	Op string `json:"op"`
+	// Kind is "domain" or "cluster".
+	Kind string `json:"kind"`
+	// Name is the resource to delete; a put takes it from its config.
+	Name    string               `json:"name,omitempty"`
+	Domain  *model.DomainConfig  `json:"domain,omitempty"`
+	Cluster *model.ClusterConfig `json:"cluster,omitempty"`
+	// ExpectedVersion has PutDomain's semantics; deletes ignore it. A put is
+	// recorded as "create" when it is 0 and as "update" otherwise.
+	ExpectedVersion int64 `json:"expected_version,omitempty"`
+}
+
+// BatchResult is the outcome of one BatchOp, in the order of the ops.
+type BatchResult struct {
+	Op   string `json:"op"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Version is the history version recorded for the write.
+	Version int64 `json:"version"`
+}
+
+// IdempotencyRecord is the stored outcome of a request sent with an
+// Idempotency-Key. Status is 0 while the first request is still running.
+type IdempotencyRecord struct {
+	Fingerprint string
+	Status      int
+	ContentType string
+	Body        []byte
+	CreatedAt   time.Time
+}
+
+// RefreshToken is a builtin-auth refresh token. Only the SHA-256 hash of the
+// token is stored. All tokens rotated from one login share a FamilyID so that
+// reuse of any of them can revoke the whole chain.
+type RefreshToken struct {
+	TokenHash string     `json:"-"`
+	FamilyID  string     `json:"family_id"`
+	UserSub   string     `json:"user_sub"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	Revoked   bool       `json:"revoked"`
+}
+
 // Users (synced from OIDC)
 // User represents a user synced from the OIDC provider.
 type User struct {
@@ -343,7 +950,13 @@ type User struct {
 	Name               string    `json:"name"`
 	IsAdmin            bool      `json:"is_admin"`
 	MustChangePassword bool      `json:"must_change_password"`
+	TOTPEnabled        bool      `json:"totp_enabled"`
 	LastSeen           time.Time `json:"last_seen"`
+	// Enabled is false for accounts an admin has disabled. UpsertUser
+	// never changes it.
+	Enabled bool `json:"enabled"`
+	// SessionsValidAfter, when set, rejects the user's tokens issued before it.
+	SessionsValidAfter *time.Time `json:"sessions_valid_after,omitempty"`
 }
 
 // GroupBinding maps an OIDC group to a role within a region.
@@ -363,6 +976,35 @@ const (
 	RoleViewer RegionRole = "viewer"
 )
 
+// AllRoles lists the builtin region roles from most to least privileged.
+var AllRoles = []RegionRole{RoleOwner, RoleEditor, RoleViewer}
+
+// IsBuiltinRole reports whether r is one of owner/editor/viewer.
+func IsBuiltinRole(r RegionRole) bool {
+	return r == RoleOwner || r == RoleEditor || r == RoleViewer
+}
+
+// Secret is a sensitive value (upstream token, TLS certificate and key)
+// sealed with the server master key. Config references it by name.
+type Secret struct {
+	Region      string    `json:"region"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	SealedValue string    `json:"-"` // never serialized
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// CustomRole is a region-defined role granting an explicit scope set. It can
+// be assigned to members and group bindings like the builtin roles.
+type CustomRole struct {
+	Region    string    `json:"region"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 // RegionMember represents a user's role within a region.
 type RegionMember struct {
 	Region   string     `json:"region"`
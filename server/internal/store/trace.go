@@ -0,0 +1,240 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing
+//
+// tracedDB and tracedTx shadow the query methods of *sql.DB and *sql.Tx so
+// that every statement gets a client span named after it ("INSERT domains"),
+// and every transaction a span parenting its statements. Spans go to the
+// global tracer provider, which is a no-op unless tracing is configured.
+//
+// A query's span stays open until its rows are closed (callers defer
+// rows.Close()), so it covers reading the result set, not just sending the
+// statement.
+
+var tracer = otel.Tracer("github.com/jizhuozhi/hermes/server/internal/store")
+
+type tracedDB struct{ *sql.DB }
+
+type tracedTx struct {
+	*sql.Tx
+	ctx  context.Context // carries the transaction span
+	span trace.Span
+}
+
+func (db *tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startStatementSpan(ctx, query)
+	res, err := db.DB.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (db *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*tracedRows, error) {
+	ctx, span := startStatementSpan(ctx, query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (db *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startStatementSpan(ctx, query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (db *tracedDB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*tracedTx, error) {
+	ctx, span := tracer.Start(ctx, "tx", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, ctx: ctx, span: span}, nil
+}
+
+// Conn pins one pooled connection, for session state such as session-level
+// advisory locks.
+func (db *tracedDB) Conn(ctx context.Context) (*tracedConn, error) {
+	c, err := db.DB.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &tracedConn{Conn: c}, nil
+}
+
+type tracedConn struct{ *sql.Conn }
+
+func (c *tracedConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startStatementSpan(ctx, query)
+	res, err := c.Conn.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (c *tracedConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startStatementSpan(ctx, query)
+	row := c.Conn.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+// tracedRows ends its statement span on Close, recording any error met
+// while iterating.
+type tracedRows struct {
+	*sql.Rows
+	span  trace.Span
+	ended bool
+}
+
+func (r *tracedRows) Close() error {
+	err := r.Rows.Close()
+	if !r.ended {
+		r.ended = true
+		if iterErr := r.Rows.Err(); iterErr != nil {
+			endSpan(r.span, iterErr)
+		} else {
+			endSpan(r.span, err)
+		}
+	}
+	return err
+}
+
+// inTx parents a statement span under the transaction span while keeping
+// ctx's cancellation.
+func (tx *tracedTx) inTx(ctx context.Context) context.Context {
+	return trace.ContextWithSpan(ctx, tx.span)
+}
+
+func (tx *tracedTx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startStatementSpan(tx.inTx(ctx), query)
+	res, err := tx.Tx.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (tx *tracedTx) QueryContext(ctx context.Context, query string, args ...any) (*tracedRows, error) {
+	ctx, span := startStatementSpan(tx.inTx(ctx), query)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		endSpan(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (tx *tracedTx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startStatementSpan(tx.inTx(ctx), query)
+	row := tx.Tx.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
+
+func (tx *tracedTx) Commit() error {
+	err := tx.Tx.Commit()
+	tx.span.SetAttributes(attribute.Bool("db.tx.committed", err == nil))
+	endSpan(tx.span, err)
+	return err
+}
+
+// Rollback ends the transaction span unless Commit already did; the usual
+// deferred Rollback after a successful Commit is a harmless no-op.
+func (tx *tracedTx) Rollback() error {
+	err := tx.Tx.Rollback()
+	if err == sql.ErrTxDone {
+		return err
+	}
+	endSpan(tx.span, err)
+	return err
+}
+
+func startStatementSpan(ctx context.Context, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, statementName(query), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.statement", query),
+		))
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// statementName derives a low-cardinality span name from SQL: the operation
+// followed by the table it targets, e.g. "SELECT change_log". Statements
+// whose table can't be found (DDL, function calls) use the operation alone.
+func statementName(query string) string {
+	fields := strings.Fields(stripLineComments(query))
+	if len(fields) == 0 {
+		return "SQL"
+	}
+	op := strings.ToUpper(fields[0])
+
+	var after string
+	switch op {
+	case "SELECT", "DELETE":
+		after = "FROM"
+	case "INSERT":
+		after = "INTO"
+	case "UPDATE":
+		if len(fields) > 1 {
+			return op + " " + tableName(fields[1])
+		}
+		return op
+	case "WITH":
+		// Name a CTE after its main statement: the first one outside parentheses.
+		depth := 0
+		for i := 1; i < len(fields); i++ {
+			depth += strings.Count(fields[i], "(")
+			switch strings.ToUpper(strings.Trim(fields[i], "()")) {
+			case "SELECT", "INSERT", "UPDATE", "DELETE":
+				if depth == 0 {
+					return statementName(strings.Join(fields[i:], " "))
+				}
+			}
+			depth -= strings.Count(fields[i], ")")
+		}
+		return op
+	default:
+		return op
+	}
+	for i := 1; i+1 < len(fields); i++ {
+		if strings.EqualFold(fields[i], after) {
+			return op + " " + tableName(fields[i+1])
+		}
+	}
+	return op
+}
+
+func stripLineComments(query string) string {
+	var b strings.Builder
+	for _, line := range strings.Split(query, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			b.WriteString(line)
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+func tableName(tok string) string {
+	return strings.Trim(tok, "(),;")
+}
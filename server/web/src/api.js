@@ -30,11 +30,14 @@ export function resetAuthConfig() {
 }
 
 // Builtin login: POST /api/auth/login with email + password.
-export async function builtinLogin(email, password) {
-  const res = await axios.post('/api/auth/login', { email, password })
-  const { access_token, must_change_password } = res.data
+export async function builtinLogin(email, password, totpCode) {
+  const body = { email, password }
+  if (totpCode) body.totp_code = totpCode
+  const res = await axios.post('/api/auth/login', body)
+  const { access_token, refresh_token, must_change_password } = res.data
   if (!access_token) throw new Error('No access token in response')
   setToken(access_token)
+  if (refresh_token) setRefreshToken(refresh_token)
 
   const payload = parseJwtPayload(access_token)
   if (payload) {
@@ -187,7 +190,7 @@ api.interceptors.response.use(
     const config = error.config
     if (error.response?.status === 401 && getToken() && !config._retried) {
       config._retried = true
-      // Token might have just expired; try refresh once (OIDC only).
+      // Token might have just expired; try refresh once.
       const ok = await refreshAccessToken()
       if (ok) {
         config.headers['Authorization'] = 'Bearer ' + getToken()
@@ -197,6 +200,11 @@ api.interceptors.response.use(
       clearAuth()
       window.location.href = '/login'
     }
+    // Server confines flagged users to the password change flow.
+    if (error.response?.status === 403 && error.response?.data?.error === 'password change required') {
+      localStorage.setItem('hermes_must_change_password', '1')
+      window.location.href = '/login'
+    }
     return Promise.reject(error)
   }
 )
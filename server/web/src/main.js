@@ -56,11 +56,12 @@ router.beforeEach(async (to) => {
 
   // Check if we have a valid token.
   const token = getToken()
-  if (!token) return '/login'
+  const toLogin = { path: '/login', query: { redirect: to.fullPath } }
+  if (!token) return toLogin
 
   if (isTokenExpired()) {
     const ok = await refreshAccessToken()
-    if (!ok) return '/login'
+    if (!ok) return toLogin
   }
 
   return true